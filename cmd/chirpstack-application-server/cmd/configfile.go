@@ -134,6 +134,18 @@ id="{{ .ApplicationServer.ID }}"
   # User authentication
   [application_server.user_authentication]
 
+  # Password reset token TTL.
+  #
+  # The validity of the (single-use) token which is sent by e-mail when a user
+  # requests a password reset. This requires the e-mail settings below.
+  password_reset_token_ttl="{{ .ApplicationServer.UserAuthentication.PasswordResetTokenTTL }}"
+
+  # E-mail verification token TTL.
+  #
+  # The validity of the (single-use) token which is sent by e-mail to verify
+  # the e-mail address of a user.
+  email_verification_token_ttl="{{ .ApplicationServer.UserAuthentication.EmailVerificationTokenTTL }}"
+
     # OpenID Connect.
     [application_server.user_authentication.openid_connect]
 
@@ -183,12 +195,244 @@ id="{{ .ApplicationServer.ID }}"
     # The login label is used in the web-interface login form.
     login_label="{{ .ApplicationServer.UserAuthentication.OpenIDConnect.LoginLabel }}"
 
+    # Accept access tokens.
+    #
+    # When enabled, access tokens issued by the OpenID Connect provider are
+    # accepted by the API directly (using the Authorization header), without
+    # the need of exchanging these for an API token first. The token subject
+    # (or verified e-mail address) is used to lookup the user.
+    accept_access_tokens={{ .ApplicationServer.UserAuthentication.OpenIDConnect.AcceptAccessTokens }}
+
+    # Access token audience.
+    #
+    # The audience which must be present in the access tokens. When left
+    # blank, the client_id is used.
+    access_token_audience="{{ .ApplicationServer.UserAuthentication.OpenIDConnect.AccessTokenAudience }}"
+
+    # Groups claim.
+    #
+    # The name of the ID token claim containing the groups (or roles) of the
+    # user. Nested claims can be referenced using a dot, e.g.
+    # "realm_access.roles". When set, the admin_group and group_mapping are
+    # evaluated on every login, so that removing a user from a group revokes
+    # the access. Note that the provider must include this claim in the ID
+    # token (this might require additional provider configuration).
+    # When accept_access_tokens is enabled, the claim is evaluated on every
+    # request and access tokens without this claim are rejected.
+    # When left blank, groups are not evaluated.
+    groups_claim="{{ .ApplicationServer.UserAuthentication.OpenIDConnect.GroupsClaim }}"
+
+    # Admin group.
+    #
+    # When set, users that are a member of this group are global admin users.
+    # Users that are not a member of this group lose their global admin
+    # privileges on login. When left blank, the global admin flag is not
+    # changed.
+    admin_group="{{ .ApplicationServer.UserAuthentication.OpenIDConnect.AdminGroup }}"
+
+    # Group mapping.
+    #
+    # Maps groups to organization memberships. Memberships of the
+    # organizations referenced by the group mapping are created, updated and
+    # removed on every login. When a user is a member of multiple groups
+    # mapping to the same organization, the flags are combined.
+    #
+    # Example (the [[application_server.user_authentication.openid_connect.group_mapping]]
+    # can be repeated):
+    # [[application_server.user_authentication.openid_connect.group_mapping]]
+    # group="lora-admins"
+    # organization_id=1
+    # is_admin=true
+    # is_device_admin=false
+    # is_gateway_admin=false
+{{ range $index, $element := .ApplicationServer.UserAuthentication.OpenIDConnect.GroupMapping }}
+    [[application_server.user_authentication.openid_connect.group_mapping]]
+    group="{{ $element.Group }}"
+    organization_id={{ $element.OrganizationID }}
+    is_admin={{ $element.IsAdmin }}
+    is_device_admin={{ $element.IsDeviceAdmin }}
+    is_gateway_admin={{ $element.IsGatewayAdmin }}
+{{ end }}
+
+    # Login rate limiting.
+    #
+    # Failed logins are counted (in Redis) per e-mail address and per client
+    # IP address. When the number of failed logins within the window reaches
+    # the configured maximum, further logins for the e-mail address or from
+    # the IP address are rejected for the lockout duration.
+    # Set a maximum to 0 to disable.
+    [application_server.user_authentication.login_rate_limit]
+
+    # Max. failed login attempts per e-mail address.
+    max_failed_attempts_per_email={{ .ApplicationServer.UserAuthentication.LoginRateLimit.MaxFailedAttemptsPerEmail }}
+
+    # Max. failed login attempts per client IP address.
+    #
+    # Note: when the API is served behind a reverse-proxy, the IP address of the
+    # proxy is used unless the proxy sets the X-Forwarded-For header and is
+    # configured in the external_api trusted_proxies.
+    max_failed_attempts_per_ip={{ .ApplicationServer.UserAuthentication.LoginRateLimit.MaxFailedAttemptsPerIP }}
+
+    # Window in which the failed login attempts are counted.
+    window="{{ .ApplicationServer.UserAuthentication.LoginRateLimit.Window }}"
+
+    # Lockout duration.
+    lockout_duration="{{ .ApplicationServer.UserAuthentication.LoginRateLimit.LockoutDuration }}"
+
+    # Max. password reset requests per e-mail address.
+    #
+    # Password reset requests are counted within the window. When the maximum
+    # has been reached, no further password reset e-mails are sent.
+    max_password_resets_per_email={{ .ApplicationServer.UserAuthentication.LoginRateLimit.MaxPasswordResetsPerEmail }}
+
+    # Max. password reset requests per client IP address.
+    max_password_resets_per_ip={{ .ApplicationServer.UserAuthentication.LoginRateLimit.MaxPasswordResetsPerIP }}
+
+
+    # Password policy.
+    #
+    # This policy applies when setting or changing the password of a user.
+    [application_server.user_authentication.password_policy]
+
+    # Min. password length.
+    min_length={{ .ApplicationServer.UserAuthentication.PasswordPolicy.MinLength }}
+
+    # Require at least one upper case character.
+    require_uppercase={{ .ApplicationServer.UserAuthentication.PasswordPolicy.RequireUppercase }}
+
+    # Require at least one lower case character.
+    require_lowercase={{ .ApplicationServer.UserAuthentication.PasswordPolicy.RequireLowercase }}
+
+    # Require at least one digit.
+    require_digit={{ .ApplicationServer.UserAuthentication.PasswordPolicy.RequireDigit }}
+
+    # Require at least one special (non-alphanumeric) character.
+    require_special={{ .ApplicationServer.UserAuthentication.PasswordPolicy.RequireSpecial }}
+
+    # Password history size.
+    #
+    # When set, the new password must not match the last N passwords of the
+    # user. Set to 0 to disable.
+    history_size={{ .ApplicationServer.UserAuthentication.PasswordPolicy.HistorySize }}
+
+    # Max. password age.
+    #
+    # When set, the password expires after the given duration (e.g. 2160h for
+    # 90 days) and must be changed before the user can login again.
+    # Set to 0 to disable.
+    max_age="{{ .ApplicationServer.UserAuthentication.PasswordPolicy.MaxAge }}"
+
+
+    # LDAP / Active Directory.
+    [application_server.user_authentication.ldap]
+
+    # Enable LDAP authentication.
+    #
+    # When enabled, the login credentials are validated against the directory
+    # instead of the local password. On the first login, the user is created
+    # (linking an existing user with the same e-mail address). On every login,
+    # the e-mail address, the global admin flag and the organization
+    # memberships are updated from the directory.
+    enabled={{ .ApplicationServer.UserAuthentication.LDAP.Enabled }}
+
+    # LDAP server URL.
+    #
+    # Use ldap://hostname:389 or ldaps://hostname:636.
+    server="{{ .ApplicationServer.UserAuthentication.LDAP.Server }}"
+
+    # Use StartTLS (ldap:// only).
+    start_tls={{ .ApplicationServer.UserAuthentication.LDAP.StartTLS }}
+
+    # CA certificate (optional).
+    #
+    # When set, this CA certificate is used to validate the server certificate.
+    ca_cert="{{ .ApplicationServer.UserAuthentication.LDAP.CACert }}"
+
+    # Bind DN and password used for looking up the user.
+    #
+    # When blank, an anonymous bind is used.
+    bind_dn="{{ .ApplicationServer.UserAuthentication.LDAP.BindDN }}"
+    bind_password="{{ .ApplicationServer.UserAuthentication.LDAP.BindPassword }}"
+
+    # Base DN under which the users are searched.
+    base_dn="{{ .ApplicationServer.UserAuthentication.LDAP.BaseDN }}"
+
+    # User attribute.
+    #
+    # The attribute matching the login name, e.g. mail, uid or
+    # sAMAccountName (Active Directory).
+    user_attribute="{{ .ApplicationServer.UserAuthentication.LDAP.UserAttribute }}"
+
+    # E-mail attribute.
+    email_attribute="{{ .ApplicationServer.UserAuthentication.LDAP.EmailAttribute }}"
+
+    # Group attribute.
+    #
+    # The attribute of the user containing the DNs of the groups of which the
+    # user is a member (e.g. memberOf).
+    group_attribute="{{ .ApplicationServer.UserAuthentication.LDAP.GroupAttribute }}"
+
+    # Link local users.
+    #
+    # On the first login of a directory user, an existing user with the same
+    # e-mail address is linked to the directory user. By default, this is
+    # only done for users without local password. When enabled, users with a
+    # local password are linked as well. Global admin users and users which
+    # are linked to an other directory user or OpenID Connect account are
+    # never linked.
+    link_local_users={{ .ApplicationServer.UserAuthentication.LDAP.LinkLocalUsers }}
+
+    # Admin group (DN).
+    #
+    # When set, members of this group are global admin users. Users that are
+    # no longer a member of this group lose the global admin flag on their
+    # next login.
+    admin_group="{{ .ApplicationServer.UserAuthentication.LDAP.AdminGroup }}"
+
+    # Group mapping.
+    #
+    # Maps groups (DN) to organization memberships. Memberships of the
+    # organizations referenced by the group mapping are created, updated and
+    # removed on every login. When a user is a member of multiple groups
+    # mapping to the same organization, the flags are combined.
+    #
+    # Example (the [[application_server.user_authentication.ldap.group_mapping]]
+    # can be repeated):
+    # [[application_server.user_authentication.ldap.group_mapping]]
+    # group="cn=lora-admins,ou=groups,dc=example,dc=com"
+    # organization_id=1
+    # is_admin=true
+    # is_device_admin=false
+    # is_gateway_admin=false
+{{ range $index, $element := .ApplicationServer.UserAuthentication.LDAP.GroupMapping }}
+    [[application_server.user_authentication.ldap.group_mapping]]
+    group="{{ $element.Group }}"
+    organization_id={{ $element.OrganizationID }}
+    is_admin={{ $element.IsAdmin }}
+    is_device_admin={{ $element.IsDeviceAdmin }}
+    is_gateway_admin={{ $element.IsGatewayAdmin }}
+{{ end }}
+
 
   # JavaScript codec settings.
   [application_server.codec.js]
   # Maximum execution time.
   max_execution_time="{{ .ApplicationServer.Codec.JS.MaxExecutionTime }}"
 
+  # Device state TTL.
+  #
+  # The decoder function can store a per-device state (context.state), which
+  # is persisted between uplinks. The state expires when it has not been
+  # updated within this duration.
+  device_state_ttl="{{ .ApplicationServer.Codec.JS.DeviceStateTTL }}"
+
+  # Console log rate limit.
+  #
+  # The max. number of console.log / console.warn entries (per device per
+  # minute) which are written to the device event-log. Set this to 0 to
+  # disable logging of console entries.
+  log_rate_limit={{ .ApplicationServer.Codec.JS.LogRateLimit }}
+
 
   # Integration configures the data integration.
   #
@@ -431,6 +675,37 @@ id="{{ .ApplicationServer.ID }}"
   # You could generate this by executing 'openssl rand -base64 32' for example
   jwt_secret="{{ .ApplicationServer.ExternalAPI.JWTSecret }}"
 
+  # JWT signing algorithm.
+  #
+  # Valid options are:
+  #  * HS256: tokens are signed using the jwt_secret
+  #  * RS256: tokens are signed using the RSA private-key (jwt_private_key_file)
+  #  * ES256: tokens are signed using the ECDSA P-256 private-key (jwt_private_key_file)
+  #
+  # When using RS256 or ES256, the public-key(s) are published at
+  # /.well-known/jwks.json so that other services can validate the tokens
+  # without knowing the jwt_secret. Note that the jwt_secret is still
+  # required for internal use. Tokens signed using HS256 and the jwt_secret,
+  # e.g. the API keys issued before switching the algorithm, remain valid.
+  jwt_algorithm="{{ .ApplicationServer.ExternalAPI.JWTAlgorithm }}"
+
+  # JWT private-key file (PEM).
+  #
+  # This key is used for signing tokens when jwt_algorithm is set to RS256
+  # or ES256. You could generate this by executing
+  # 'openssl genrsa -out jwt.key 2048' (RS256) or
+  # 'openssl ecparam -name prime256v1 -genkey -noout -out jwt.key' (ES256).
+  jwt_private_key_file="{{ .ApplicationServer.ExternalAPI.JWTPrivateKeyFile }}"
+
+  # JWT public-key files (PEM).
+  #
+  # On key rotation, add the public-key(s) of the previous private-key(s)
+  # to this list. Tokens signed by these keys are still accepted and the
+  # keys are still published, until they are removed from this list.
+  jwt_public_key_files=[{{ range $index, $elm := .ApplicationServer.ExternalAPI.JWTPublicKeyFiles }}
+    "{{ $elm }}",{{ end }}
+  ]
+
   # Allow origin header (CORS).
   #
   # Set this to allows cross-domain communication from the browser (CORS).
@@ -438,6 +713,17 @@ id="{{ .ApplicationServer.ID }}"
   # When left blank (default), CORS will not be used.
   cors_allow_origin="{{ .ApplicationServer.ExternalAPI.CORSAllowOrigin }}"
 
+  # Trusted proxies.
+  #
+  # IP addresses or networks (e.g. 10.0.0.0/8) of the reverse-proxies in
+  # front of the external api. The client IP address (e.g. used for the
+  # login rate-limiting) is only read from the X-Forwarded-For header when
+  # the request was received from a trusted proxy. Requests forwarded by the
+  # REST api (which connects over the loopback interface) are always trusted.
+  trusted_proxies=[{{ range $index, $elm := .ApplicationServer.ExternalAPI.TrustedProxies }}
+    "{{ $elm }}",{{ end }}
+  ]
+
 
   # Settings for the remote multicast setup.
   [application_server.remote_multicast_setup]
@@ -462,6 +748,76 @@ id="{{ .ApplicationServer.ID }}"
   # Synchronization batch-size.
   sync_batch_size={{ .ApplicationServer.FragmentationSession.SyncBatchSize }}
 
+
+  # Settings for the FUOTA deployments.
+  [application_server.fuota_deployment]
+  # Max. number of repair sessions.
+  #
+  # When devices report missing fragments, the deployment sends additional
+  # coded fragments in a follow-up multicast session (up to the configured
+  # number of times) before marking these devices as failed.
+  repair_sessions={{ .ApplicationServer.FUOTADeployment.RepairSessions }}
+
+
+  # Firmware image settings.
+  [application_server.firmware_image]
+  # Signing public-key (PEM) file.
+  #
+  # When set, the signature uploaded together with a firmware image is
+  # verified using this public-key. RSA (PKCS #1 v1.5), ECDSA and Ed25519
+  # keys are supported. The signature must be over the SHA-256 of the
+  # firmware image (for Ed25519, over the image itself).
+  signing_public_key="{{ .ApplicationServer.FirmwareImage.SigningPublicKey }}"
+
+  # Require signed firmware images.
+  #
+  # When enabled, firmware images without a valid signature are rejected.
+  require_signature={{ .ApplicationServer.FirmwareImage.RequireSignature }}
+
+  # E-mail settings.
+  #
+  # E-mail is used for the self-service password reset and for the
+  # verification of e-mail addresses.
+  [application_server.email]
+  # Sender.
+  #
+  # Valid options are:
+  # * smtp: send the e-mails using the SMTP settings below
+  # * file: append the e-mails to the file configured below (for testing)
+  # * log: log the e-mails (for testing)
+  #
+  # When blank, sending e-mail is disabled.
+  sender="{{ .ApplicationServer.Email.Sender }}"
+
+  # From address.
+  from="{{ .ApplicationServer.Email.From }}"
+
+  # Base URL.
+  #
+  # The (public) URL of the web-interface, used for the links in the e-mails,
+  # e.g. https://lora.example.com.
+  base_url="{{ .ApplicationServer.Email.BaseURL }}"
+
+    # SMTP settings.
+    [application_server.email.smtp]
+    # SMTP server (hostname:port).
+    #
+    # STARTTLS is used when supported by the server.
+    server="{{ .ApplicationServer.Email.SMTP.Server }}"
+
+    # Username and password (optional).
+    username="{{ .ApplicationServer.Email.SMTP.Username }}"
+    password="{{ .ApplicationServer.Email.SMTP.Password }}"
+
+    # Use TLS (e.g. for port 465) instead of STARTTLS.
+    tls={{ .ApplicationServer.Email.SMTP.TLS }}
+
+    # File settings.
+    [application_server.email.file]
+    # Path of the file to which the e-mails are appended.
+    path="{{ .ApplicationServer.Email.File.Path }}"
+
+
 {{ if ne .ApplicationServer.Branding.Footer  "" }}
   # Branding configuration.
   [application_server.branding]
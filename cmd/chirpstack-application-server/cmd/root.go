@@ -46,6 +46,21 @@ func init() {
 	viper.SetDefault("application_server.id", "6d5db27e-4ce2-4b2b-b5d7-91f069397978")
 	viper.SetDefault("application_server.api.bind", "0.0.0.0:8001")
 	viper.SetDefault("application_server.external_api.bind", "0.0.0.0:8080")
+	viper.SetDefault("application_server.external_api.jwt_algorithm", "HS256")
+	viper.SetDefault("application_server.user_authentication.login_rate_limit.max_failed_attempts_per_email", 5)
+	viper.SetDefault("application_server.user_authentication.login_rate_limit.max_failed_attempts_per_ip", 20)
+	viper.SetDefault("application_server.user_authentication.login_rate_limit.window", 15*time.Minute)
+	viper.SetDefault("application_server.user_authentication.login_rate_limit.lockout_duration", 15*time.Minute)
+	viper.SetDefault("application_server.user_authentication.login_rate_limit.max_password_resets_per_email", 3)
+	viper.SetDefault("application_server.user_authentication.login_rate_limit.max_password_resets_per_ip", 10)
+	viper.SetDefault("application_server.user_authentication.password_policy.min_length", 6)
+	viper.SetDefault("application_server.user_authentication.ldap.server", "ldap://localhost:389")
+	viper.SetDefault("application_server.user_authentication.ldap.user_attribute", "mail")
+	viper.SetDefault("application_server.user_authentication.ldap.email_attribute", "mail")
+	viper.SetDefault("application_server.user_authentication.ldap.group_attribute", "memberOf")
+	viper.SetDefault("application_server.user_authentication.password_reset_token_ttl", time.Hour)
+	viper.SetDefault("application_server.user_authentication.email_verification_token_ttl", 48*time.Hour)
+	viper.SetDefault("application_server.email.smtp.server", "localhost:25")
 	viper.SetDefault("join_server.bind", "0.0.0.0:8003")
 	viper.SetDefault("application_server.integration.marshaler", "json_v3")
 	viper.SetDefault("application_server.integration.mqtt.server", "tcp://localhost:1883")
@@ -61,6 +76,8 @@ func init() {
 	viper.SetDefault("application_server.integration.amqp.event_routing_key_template", "application.{{ .ApplicationID }}.device.{{ .DevEUI }}.event.{{ .EventType }}")
 	viper.SetDefault("application_server.integration.enabled", []string{"mqtt"})
 	viper.SetDefault("application_server.codec.js.max_execution_time", 100*time.Millisecond)
+	viper.SetDefault("application_server.codec.js.device_state_ttl", 720*time.Hour)
+	viper.SetDefault("application_server.codec.js.log_rate_limit", 20)
 
 	viper.SetDefault("application_server.remote_multicast_setup.sync_interval", time.Second)
 	viper.SetDefault("application_server.remote_multicast_setup.sync_retries", 3)
@@ -70,6 +87,8 @@ func init() {
 	viper.SetDefault("application_server.fragmentation_session.sync_retries", 3)
 	viper.SetDefault("application_server.fragmentation_session.sync_batch_size", 100)
 
+	viper.SetDefault("application_server.fuota_deployment.repair_sessions", 1)
+
 	viper.SetDefault("metrics.timezone", "Local")
 	viper.SetDefault("metrics.redis.aggregation_intervals", []string{"MINUTE", "HOUR", "DAY", "MONTH"})
 	viper.SetDefault("metrics.redis.minute_aggregation_ttl", time.Hour*2)
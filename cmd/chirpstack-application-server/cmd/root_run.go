@@ -18,9 +18,13 @@ import (
 	jscodec "github.com/gyh1621/chirpstack-application-server/internal/codec/js"
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
 	"github.com/gyh1621/chirpstack-application-server/internal/downlink"
+	"github.com/gyh1621/chirpstack-application-server/internal/email"
+	"github.com/gyh1621/chirpstack-application-server/internal/firmware"
 	"github.com/gyh1621/chirpstack-application-server/internal/fuota"
 	"github.com/gyh1621/chirpstack-application-server/internal/gwping"
 	"github.com/gyh1621/chirpstack-application-server/internal/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/jwtkeys"
+	"github.com/gyh1621/chirpstack-application-server/internal/loginlimit"
 	"github.com/gyh1621/chirpstack-application-server/internal/migrations/code"
 	"github.com/gyh1621/chirpstack-application-server/internal/monitoring"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
@@ -46,6 +50,10 @@ func run(cmd *cobra.Command, args []string) error {
 		setupMulticastSetup,
 		setupFragmentation,
 		setupFUOTA,
+		setupFirmware,
+		setupJWTKeys,
+		setupLoginLimit,
+		setupEmail,
 		setupAPI,
 		setupMonitoring,
 	}
@@ -138,6 +146,27 @@ func handleDataDownPayloads() error {
 	return nil
 }
 
+func setupJWTKeys() error {
+	if err := jwtkeys.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup jwt keys error")
+	}
+	return nil
+}
+
+func setupLoginLimit() error {
+	if err := loginlimit.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup login limit error")
+	}
+	return nil
+}
+
+func setupEmail() error {
+	if err := email.Setup(config.C); err != nil {
+		return errors.Wrap(err, "setup email error")
+	}
+	return nil
+}
+
 func setupAPI() error {
 	if err := api.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup api error")
@@ -172,6 +201,13 @@ func setupFUOTA() error {
 	return nil
 }
 
+func setupFirmware() error {
+	if err := firmware.Setup(config.C); err != nil {
+		return errors.Wrap(err, "firmware setup error")
+	}
+	return nil
+}
+
 func setupMonitoring() error {
 	if err := monitoring.Setup(config.C); err != nil {
 		return errors.Wrap(err, "setup monitoring error")
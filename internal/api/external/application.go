@@ -75,7 +75,15 @@ func (a *ApplicationAPI) Create(ctx context.Context, req *pb.CreateApplicationRe
 		PayloadDecoderScript: req.Application.PayloadDecoderScript,
 	}
 
-	if err := storage.CreateApplication(ctx, storage.DB(), &app); err != nil {
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.CreateApplication(ctx, tx, &app); err != nil {
+			return err
+		}
+
+		rev := app.CodecRevision()
+		return createCodecRevision(ctx, tx, a.validator, storage.CodecRevision{}, &rev)
+	})
+	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
@@ -143,6 +151,8 @@ func (a *ApplicationAPI) Update(ctx context.Context, req *pb.UpdateApplicationRe
 		return nil, grpc.Errorf(codes.InvalidArgument, "application and service-profile must be under the same organization")
 	}
 
+	oldRev := app.CodecRevision()
+
 	// update the fields
 	app.Name = req.Application.Name
 	app.Description = req.Application.Description
@@ -151,7 +161,14 @@ func (a *ApplicationAPI) Update(ctx context.Context, req *pb.UpdateApplicationRe
 	app.PayloadEncoderScript = req.Application.PayloadEncoderScript
 	app.PayloadDecoderScript = req.Application.PayloadDecoderScript
 
-	err = storage.UpdateApplication(ctx, storage.DB(), app)
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := storage.UpdateApplication(ctx, tx, app); err != nil {
+			return err
+		}
+
+		newRev := app.CodecRevision()
+		return createCodecRevision(ctx, tx, a.validator, oldRev, &newRev)
+	})
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
@@ -159,6 +176,118 @@ func (a *ApplicationAPI) Update(ctx context.Context, req *pb.UpdateApplicationRe
 	return &empty.Empty{}, nil
 }
 
+// TestCodec runs the (legacy) application payload codec against the given
+// input, using either the given or the stored codec settings.
+func (a *ApplicationAPI) TestCodec(ctx context.Context, req *TestApplicationCodecRequest) (*TestCodecResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	app, err := storage.GetApplication(ctx, storage.DB(), req.ID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return runCodecTest(app.PayloadCodec, app.PayloadDecoderScript, app.PayloadEncoderScript, codec.ProtobufSchema{}, req.CodecTest)
+}
+
+// ListCodecRevisions lists the payload codec revisions of the application.
+func (a *ApplicationAPI) ListCodecRevisions(ctx context.Context, req *ListApplicationCodecRevisionsRequest) (*ListCodecRevisionsResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ID, auth.Read),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return listCodecRevisions(ctx, storage.CodecRevisionFilters{
+		ApplicationID: &req.ID,
+		Limit:         int(req.Limit),
+		Offset:        int(req.Offset),
+	})
+}
+
+// GetCodecRevision returns the given payload codec revision of the
+// application.
+func (a *ApplicationAPI) GetCodecRevision(ctx context.Context, req *ApplicationCodecRevisionRequest) (*GetCodecRevisionResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ID, auth.Read),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	rev, err := storage.GetCodecRevision(ctx, storage.DB(), storage.CodecRevisionFilters{ApplicationID: &req.ID}, req.Revision)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetCodecRevisionResponse{
+		CodecRevision: codecRevisionToAPI(rev),
+	}, nil
+}
+
+// DiffCodecRevision returns the differences between the given payload codec
+// revision and the compareTo revision (by default the previous revision).
+func (a *ApplicationAPI) DiffCodecRevision(ctx context.Context, req *ApplicationCodecRevisionRequest) (*DiffCodecRevisionResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ID, auth.Read),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return diffCodecRevisions(ctx, storage.CodecRevisionFilters{ApplicationID: &req.ID}, req.Revision, req.CompareTo)
+}
+
+// RollbackCodecRevision restores the codec settings of the given revision.
+// The restored settings are stored as a new revision.
+func (a *ApplicationAPI) RollbackCodecRevision(ctx context.Context, req *ApplicationCodecRevisionRequest) (*RollbackCodecRevisionResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationAccess(req.ID, auth.Update),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	var revision int
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		app, err := storage.GetApplication(ctx, tx, req.ID)
+		if err != nil {
+			return err
+		}
+
+		rev, err := storage.GetCodecRevision(ctx, tx, storage.CodecRevisionFilters{ApplicationID: &req.ID}, req.Revision)
+		if err != nil {
+			return err
+		}
+
+		oldRev := app.CodecRevision()
+
+		app.PayloadCodec = rev.PayloadCodec
+		app.PayloadEncoderScript = rev.PayloadEncoderScript
+		app.PayloadDecoderScript = rev.PayloadDecoderScript
+
+		if err := storage.UpdateApplication(ctx, tx, app); err != nil {
+			return err
+		}
+
+		newRev := app.CodecRevision()
+		if err := createCodecRevision(ctx, tx, a.validator, oldRev, &newRev); err != nil {
+			return err
+		}
+
+		revision = newRev.Revision
+		return nil
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &RollbackCodecRevisionResponse{
+		Revision: revision,
+	}, nil
+}
+
 // Delete deletes the given application.
 func (a *ApplicationAPI) Delete(ctx context.Context, req *pb.DeleteApplicationRequest) (*empty.Empty, error) {
 	if err := a.validator.Validate(ctx,
@@ -208,9 +337,10 @@ func (a *ApplicationAPI) List(ctx context.Context, req *pb.ListApplicationReques
 			return nil, helpers.ErrToRPCError(err)
 		}
 
-		// Filter on user ID when OrganizationID is not set and the user is
-		// not a global admin.
-		if !user.IsAdmin && filters.OrganizationID == 0 {
+		// Filter on user ID when the user is not a global admin, as the
+		// access of the user can be restricted to the applications for which
+		// the user has a role.
+		if !user.IsAdmin {
 			filters.UserID = user.ID
 		}
 
@@ -0,0 +1,80 @@
+package external
+
+import (
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// ApplicationLayer defines the LoRaWAN application-layer package settings
+// of a device-profile.
+type ApplicationLayer struct {
+	// Remote multicast setup is enabled.
+	MulticastSetupEnabled bool `json:"multicastSetupEnabled"`
+
+	// Remote multicast setup fPort (1 - 223).
+	MulticastSetupFPort int `json:"multicastSetupFPort"`
+
+	// Fragmented data block transport is enabled.
+	FragmentationEnabled bool `json:"fragmentationEnabled"`
+
+	// Fragmented data block transport fPort (1 - 223).
+	FragmentationFPort int `json:"fragmentationFPort"`
+
+	// Application-layer clock synchronization is enabled.
+	ClockSyncEnabled bool `json:"clockSyncEnabled"`
+
+	// Application-layer clock synchronization fPort (1 - 223).
+	ClockSyncFPort int `json:"clockSyncFPort"`
+}
+
+// DeviceProfileApplicationLayerRequest defines the request for getting the
+// application-layer settings of a device-profile.
+type DeviceProfileApplicationLayerRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+}
+
+// GetDeviceProfileApplicationLayerResponse defines the get application-layer
+// settings response.
+type GetDeviceProfileApplicationLayerResponse struct {
+	ApplicationLayer ApplicationLayer `json:"applicationLayer"`
+}
+
+// UpdateDeviceProfileApplicationLayerRequest defines the request for
+// updating the application-layer settings of a device-profile.
+type UpdateDeviceProfileApplicationLayerRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+
+	ApplicationLayer ApplicationLayer `json:"applicationLayer"`
+}
+
+func applicationLayerToAPI(p storage.ApplicationLayerParams) ApplicationLayer {
+	return ApplicationLayer{
+		MulticastSetupEnabled: p.MulticastSetupEnabled,
+		MulticastSetupFPort:   int(p.MulticastSetupFPort),
+		FragmentationEnabled:  p.FragmentationEnabled,
+		FragmentationFPort:    int(p.FragmentationFPort),
+		ClockSyncEnabled:      p.ClockSyncEnabled,
+		ClockSyncFPort:        int(p.ClockSyncFPort),
+	}
+}
+
+func applicationLayerFromAPI(al ApplicationLayer) storage.ApplicationLayerParams {
+	return storage.ApplicationLayerParams{
+		MulticastSetupEnabled: al.MulticastSetupEnabled,
+		MulticastSetupFPort:   applicationLayerFPort(al.MulticastSetupFPort),
+		FragmentationEnabled:  al.FragmentationEnabled,
+		FragmentationFPort:    applicationLayerFPort(al.FragmentationFPort),
+		ClockSyncEnabled:      al.ClockSyncEnabled,
+		ClockSyncFPort:        applicationLayerFPort(al.ClockSyncFPort),
+	}
+}
+
+// applicationLayerFPort returns the given fPort as uint8. Out of range
+// values are returned as 0, which is rejected by the validation.
+func applicationLayerFPort(fPort int) uint8 {
+	if fPort < 0 || fPort > 255 {
+		return 0
+	}
+	return uint8(fPort)
+}
@@ -0,0 +1,207 @@
+package external
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// ApplicationUser defines a role assigned to an user for an application.
+type ApplicationUser struct {
+	// User ID.
+	// The user must be a member of the organization of the application.
+	UserID int64 `json:"userID,string"`
+
+	// E-mail of the user.
+	Email string `json:"email"`
+
+	// Role ID.
+	// The role must belong to the organization of the application.
+	RoleID int64 `json:"roleID,string"`
+
+	// Created at timestamp.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Last update timestamp.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateApplicationUserRequest defines the request for adding an user to
+// an application.
+type CreateApplicationUserRequest struct {
+	// Application ID.
+	ApplicationID int64 `json:"applicationID"`
+
+	ApplicationUser ApplicationUser `json:"applicationUser"`
+}
+
+// ApplicationUserRequest defines the request for getting or deleting an
+// application user.
+type ApplicationUserRequest struct {
+	// Application ID.
+	ApplicationID int64 `json:"applicationID"`
+
+	// User ID.
+	UserID int64 `json:"userID"`
+}
+
+// GetApplicationUserResponse defines the get application user response.
+type GetApplicationUserResponse struct {
+	ApplicationUser ApplicationUser `json:"applicationUser"`
+}
+
+// UpdateApplicationUserRequest defines the request for updating an
+// application user. Only the role can be updated.
+type UpdateApplicationUserRequest struct {
+	// Application ID.
+	ApplicationID int64 `json:"applicationID"`
+
+	// User ID.
+	UserID int64 `json:"userID"`
+
+	ApplicationUser ApplicationUser `json:"applicationUser"`
+}
+
+// ListApplicationUsersRequest defines the request for listing the users of
+// an application.
+type ListApplicationUsersRequest struct {
+	// Application ID.
+	ApplicationID int64 `json:"applicationID"`
+
+	// Max number of items to return.
+	Limit int64 `json:"limit"`
+
+	// Offset in the result-set (for pagination).
+	Offset int64 `json:"offset"`
+}
+
+// ListApplicationUsersResponse defines the application users list response.
+type ListApplicationUsersResponse struct {
+	// Total number of application users.
+	TotalCount int64 `json:"totalCount,string"`
+
+	// Application users within the requested limit and offset.
+	Result []ApplicationUser `json:"result"`
+}
+
+// CreateUser assigns a role to the given user for the application.
+func (a *ApplicationAPI) CreateUser(ctx context.Context, req *CreateApplicationUserRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationUsersAccess(auth.Create, req.ApplicationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	au := storage.ApplicationUser{
+		ApplicationID: req.ApplicationID,
+		UserID:        req.ApplicationUser.UserID,
+		RoleID:        req.ApplicationUser.RoleID,
+	}
+
+	if err := storage.CreateApplicationUser(ctx, storage.DB(), &au); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// GetUser returns the given application user.
+func (a *ApplicationAPI) GetUser(ctx context.Context, req *ApplicationUserRequest) (*GetApplicationUserResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationUserAccess(auth.Read, req.ApplicationID, req.UserID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	au, err := storage.GetApplicationUser(ctx, storage.DB(), req.ApplicationID, req.UserID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetApplicationUserResponse{
+		ApplicationUser: applicationUserToAPI(au),
+	}, nil
+}
+
+// UpdateUser updates the role of the given application user.
+func (a *ApplicationAPI) UpdateUser(ctx context.Context, req *UpdateApplicationUserRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationUserAccess(auth.Update, req.ApplicationID, req.UserID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	au := storage.ApplicationUser{
+		ApplicationID: req.ApplicationID,
+		UserID:        req.UserID,
+		RoleID:        req.ApplicationUser.RoleID,
+	}
+
+	if err := storage.UpdateApplicationUser(ctx, storage.DB(), &au); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// DeleteUser removes the given user from the application.
+func (a *ApplicationAPI) DeleteUser(ctx context.Context, req *ApplicationUserRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationUserAccess(auth.Delete, req.ApplicationID, req.UserID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := storage.DeleteApplicationUser(ctx, storage.DB(), req.ApplicationID, req.UserID); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// ListUsers lists the users of the given application.
+func (a *ApplicationAPI) ListUsers(ctx context.Context, req *ListApplicationUsersRequest) (*ListApplicationUsersResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateApplicationUsersAccess(auth.List, req.ApplicationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	count, err := storage.GetApplicationUserCount(ctx, storage.DB(), req.ApplicationID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	users, err := storage.GetApplicationUsers(ctx, storage.DB(), req.ApplicationID, int(req.Limit), int(req.Offset))
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListApplicationUsersResponse{
+		TotalCount: int64(count),
+		Result:     make([]ApplicationUser, 0, len(users)),
+	}
+
+	for _, au := range users {
+		resp.Result = append(resp.Result, applicationUserToAPI(au))
+	}
+
+	return &resp, nil
+}
+
+func applicationUserToAPI(au storage.ApplicationUser) ApplicationUser {
+	return ApplicationUser{
+		UserID:    au.UserID,
+		Email:     au.Email,
+		RoleID:    au.RoleID,
+		CreatedAt: au.CreatedAt,
+		UpdatedAt: au.UpdatedAt,
+	}
+}
@@ -0,0 +1,609 @@
+package external
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// auditRedacted is the value which is stored instead of the value of a
+// secret field.
+const auditRedacted = "<redacted>"
+
+// auditSecretRegexp matches the names of the fields which values must not be
+// stored in the audit log. This includes the integration credentials, e.g.
+// the GCP Pub/Sub credentials_file (service-account key), the Azure Service
+// Bus connection_string (shared access key) and the HTTP integration
+// headers (e.g. Authorization), and the two-factor authentication codes
+// (e.g. the code of a TOTP activation and the recovery codes).
+var auditSecretRegexp = regexp.MustCompile(`(?i)(key|password|secret|token|jwt|codes?|credentials|credentials_?file|connection_?string|headers|authorization)$`)
+
+// auditIgnoreFields contains the fields which are not included in the
+// audit log changes as they change on every update.
+var auditIgnoreFields = map[string]struct{}{
+	"createdat":  {},
+	"updatedat":  {},
+	"lastseenat": {},
+}
+
+// auditIdentifierFields contains the (normalized) fields which identify a
+// resource, in the order in which they are used as resource ID.
+var auditIdentifierFields = []string{
+	"id",
+	"deveui",
+	"gatewayid",
+	"userid",
+	"applicationid",
+	"multicastgroupid",
+	"serviceprofileid",
+	"organizationid",
+}
+
+// auditReadPrefixes contains the prefixes of the API methods which do not
+// modify any state and which therefore are not recorded into the audit log.
+// Login calls are not recorded either, failed logins are recorded as
+// login_failed by the login handler.
+var auditReadPrefixes = []string{
+	"Get",
+	"List",
+	"Stream",
+	"Diff",
+	"Test",
+	"GlobalSearch",
+	"Profile",
+	"Branding",
+	"Settings",
+	"Login",
+	"OpenIDConnectLogin",
+}
+
+// auditActions maps the API method prefixes to the audit log action.
+// Other mutating methods (e.g. AddDevice, Activate, Enqueue, Rotate) are
+// recorded as execute.
+var auditActions = []struct {
+	prefix string
+	action string
+}{
+	{"Create", storage.AuditActionCreate},
+	{"Update", storage.AuditActionUpdate},
+	{"Delete", storage.AuditActionDelete},
+}
+
+// auditAction returns the audit log action and the resource suffix for the
+// given API method, e.g. update and Keys for UpdateKeys. An empty action is
+// returned for methods which must not be recorded.
+func auditAction(method string) (string, string) {
+	for _, p := range auditReadPrefixes {
+		if strings.HasPrefix(method, p) {
+			return "", ""
+		}
+	}
+
+	for _, a := range auditActions {
+		if strings.HasPrefix(method, a.prefix) {
+			return a.action, strings.TrimPrefix(method, a.prefix)
+		}
+	}
+
+	// the suffix starts at the second word, e.g. Device for AddDevice
+	for i, r := range method {
+		if i > 0 && unicode.IsUpper(r) {
+			return storage.AuditActionExecute, method[i:]
+		}
+	}
+	return storage.AuditActionExecute, ""
+}
+
+// auditUnaryServerInterceptor returns a gRPC interceptor which records all
+// mutating calls into the audit log.
+func auditUnaryServerInterceptor(validator auth.Validator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// FullMethod has the format /package.Service/Method
+		parts := strings.Split(strings.TrimPrefix(info.FullMethod, "/"), "/")
+		if len(parts) != 2 {
+			return handler(ctx, req)
+		}
+		service := parts[0]
+		if i := strings.LastIndex(service, "."); i != -1 {
+			service = service[i+1:]
+		}
+
+		return audit(ctx, validator, info.Server, service, parts[1], req, func(ctx context.Context) (interface{}, error) {
+			return handler(ctx, req)
+		})
+	}
+}
+
+// auditHTTPHandler returns the service and method name of the given JSON
+// route handler, e.g. FirmwareImageService and Create for
+// (*FirmwareImageAPI).Create.
+func auditHTTPHandler(handler interface{}) (string, string) {
+	f := runtime.FuncForPC(reflect.ValueOf(handler).Pointer())
+	if f == nil {
+		return "", ""
+	}
+
+	// github.com/.../external.(*FirmwareImageAPI).Create-fm
+	name := f.Name()
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	parts := strings.Split(strings.TrimSuffix(name, "-fm"), ".")
+	if len(parts) != 3 {
+		return "", ""
+	}
+
+	service := strings.Trim(parts[1], "(*)")
+	service = strings.TrimSuffix(strings.TrimSuffix(service, "API"), "Service")
+
+	return service + "Service", parts[2]
+}
+
+// audit executes the given API call and when it is a mutating call which
+// succeeded, it records it into the audit log. The server is used to
+// retrieve the state of the resource before and after the call, using its
+// Get method matching the given method (e.g. GetKeys for UpdateKeys).
+// The state after the call is not retrieved when the call already returned
+// the resource. When nil, when there is no such method or for execute
+// actions, the request is stored.
+func audit(ctx context.Context, validator auth.Validator, server interface{}, service, method string, req interface{}, call func(context.Context) (interface{}, error)) (interface{}, error) {
+	action, suffix := auditAction(method)
+	if action == "" || service == "" {
+		return call(ctx)
+	}
+
+	reqState := auditState(req)
+	ids := auditIdentifiers(reqState)
+
+	var before map[string]interface{}
+	var orgID *int64
+	if action != storage.AuditActionCreate && action != storage.AuditActionExecute {
+		before = auditGetState(ctx, server, "Get"+suffix, ids)
+
+		// for deletes, the resource does not exist anymore after the call
+		orgID = auditOrganizationID(ctx, service, ids, before)
+	}
+
+	resp, err := call(ctx)
+	if err != nil {
+		return resp, err
+	}
+
+	respState := auditState(resp)
+	for k, v := range auditIdentifiers(respState) {
+		ids[k] = v
+	}
+
+	var after map[string]interface{}
+	if action != storage.AuditActionDelete && action != storage.AuditActionExecute {
+		if auditHasResource(respState) {
+			after = respState
+		} else {
+			after = auditGetState(ctx, server, "Get"+suffix, ids)
+		}
+	}
+
+	// fallback to the request when the resource state is not available
+	if before == nil && after == nil {
+		if action == storage.AuditActionDelete {
+			before = reqState
+		} else {
+			after = reqState
+		}
+	}
+
+	if orgID == nil {
+		orgID = auditOrganizationID(ctx, service, ids, after)
+	}
+
+	changes, err := json.Marshal(auditDiff(before, after))
+	if err != nil {
+		log.WithError(err).Error("api/external: marshal audit-log changes error")
+		return resp, nil
+	}
+
+	resourceType := auditSnakeCase(strings.TrimSuffix(service, "Service"))
+	if service == "InternalService" {
+		resourceType = ""
+	}
+	if suffix != "" {
+		resourceType = strings.TrimPrefix(resourceType+"_"+auditSnakeCase(suffix), "_")
+	}
+
+	entry := storage.AuditLogEntry{
+		OrganizationID: orgID,
+		Service:        service,
+		Method:         method,
+		Action:         action,
+		ResourceType:   resourceType,
+		ResourceID:     auditResourceID(ids),
+		Changes:        changes,
+	}
+
+	if ctxID, ok := ctx.Value(logging.ContextIDKey).(uuid.UUID); ok {
+		entry.RequestID = &ctxID
+	}
+
+	if err := auditSetActor(ctx, validator, &entry); err != nil {
+		log.WithError(err).Error("api/external: get audit-log actor error")
+	}
+
+	if err := storage.CreateAuditLogEntry(ctx, storage.DB(), &entry); err != nil {
+		log.WithError(err).Error("api/external: create audit-log entry error")
+	}
+
+	return resp, nil
+}
+
+// auditSetActor sets the user or API key performing the call.
+func auditSetActor(ctx context.Context, validator auth.Validator, entry *storage.AuditLogEntry) error {
+	subject, err := validator.GetSubject(ctx)
+	if err != nil {
+		// unauthenticated calls (e.g. changing an expired password)
+		if cause := errors.Cause(err); cause == auth.ErrNoMetadataInContext || cause == auth.ErrNoAuthorizationInMetadata {
+			return nil
+		}
+		return err
+	}
+	entry.Subject = subject
+
+	switch subject {
+	case auth.SubjectUser, auth.SubjectTOTPEnrollment:
+		user, err := validator.GetUser(ctx)
+		if err != nil {
+			return err
+		}
+		entry.UserID = &user.ID
+		entry.Username = user.Email
+	case auth.SubjectAPIKey:
+		id, err := validator.GetAPIKeyID(ctx)
+		if err != nil {
+			return err
+		}
+		entry.APIKeyID = &id
+	}
+
+	return nil
+}
+
+// auditState returns the given request or response as map. Protobuf
+// messages are marshaled using the original (snake_case) field names.
+func auditState(v interface{}) map[string]interface{} {
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return nil
+	}
+
+	var b []byte
+	var err error
+
+	if pb, ok := v.(proto.Message); ok {
+		var buf bytes.Buffer
+		m := jsonpb.Marshaler{OrigName: true}
+		err = m.Marshal(&buf, pb)
+		b = buf.Bytes()
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		log.WithError(err).Error("api/external: marshal audit-log state error")
+		return nil
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		log.WithError(err).Error("api/external: unmarshal audit-log state error")
+		return nil
+	}
+
+	return out
+}
+
+// auditGetState calls the given Get method of the server for the given
+// resource identifiers and returns the response as map. It returns nil
+// when the server does not implement the Get method or when it fails.
+func auditGetState(ctx context.Context, server interface{}, method string, ids map[string]interface{}) map[string]interface{} {
+	if server == nil || len(ids) == 0 {
+		return nil
+	}
+
+	m := reflect.ValueOf(server).MethodByName(method)
+	if !m.IsValid() || m.Type().NumIn() != 2 || m.Type().NumOut() != 2 || m.Type().In(1).Kind() != reflect.Ptr {
+		return nil
+	}
+
+	b, err := json.Marshal(ids)
+	if err != nil {
+		return nil
+	}
+
+	req := reflect.New(m.Type().In(1).Elem())
+	if pb, ok := req.Interface().(proto.Message); ok {
+		um := jsonpb.Unmarshaler{AllowUnknownFields: true}
+		err = um.Unmarshal(bytes.NewReader(b), pb)
+	} else {
+		err = json.Unmarshal(b, req.Interface())
+	}
+	if err != nil {
+		return nil
+	}
+
+	out := m.Call([]reflect.Value{reflect.ValueOf(ctx), req})
+	if err, ok := out[1].Interface().(error); ok && err != nil {
+		log.WithError(err).WithField("method", method).Debug("api/external: get audit-log resource state error")
+		return nil
+	}
+
+	return auditState(out[0].Interface())
+}
+
+// auditIdentifiers returns the resource identifiers from the given top-level
+// and nested objects.
+func auditIdentifiers(state map[string]interface{}) map[string]interface{} {
+	ids := make(map[string]interface{})
+
+	// top-level fields take precedence over nested fields
+	fields := auditFlatten("", state)
+	for _, path := range auditSortedKeys(fields) {
+		name := path[strings.LastIndex(path, ".")+1:]
+		if _, ok := ids[name]; ok || !auditIsIdentifier(name) {
+			continue
+		}
+
+		switch v := fields[path]; v.(type) {
+		case string, float64:
+			ids[name] = v
+		}
+	}
+
+	return ids
+}
+
+// auditResourceID returns the ID of the resource.
+func auditResourceID(ids map[string]interface{}) string {
+	for _, f := range auditIdentifierFields {
+		for k, v := range ids {
+			if auditNormalize(k) != f {
+				continue
+			}
+
+			switch v := v.(type) {
+			case string:
+				return v
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+	}
+
+	return ""
+}
+
+// auditOrganizationID returns the ID of the organization to which the
+// resource belongs, or nil in case of a global resource.
+func auditOrganizationID(ctx context.Context, service string, ids map[string]interface{}, state map[string]interface{}) *int64 {
+	fields := auditFlatten("", state)
+	for k, v := range ids {
+		fields[k] = v
+	}
+
+	get := func(name string) string {
+		for _, k := range auditSortedKeys(fields) {
+			if auditNormalize(k[strings.LastIndex(k, ".")+1:]) != name {
+				continue
+			}
+			switch v := fields[k].(type) {
+			case string:
+				if v != "" {
+					return v
+				}
+			case float64:
+				return strconv.FormatFloat(v, 'f', -1, 64)
+			}
+		}
+		return ""
+	}
+
+	var orgID int64
+	var err error
+
+	if service == "OrganizationService" {
+		if s := get("organizationid"); s != "" {
+			orgID, err = strconv.ParseInt(s, 10, 64)
+		} else {
+			orgID, err = strconv.ParseInt(get("id"), 10, 64)
+		}
+	} else if s := get("organizationid"); s != "" {
+		orgID, err = strconv.ParseInt(s, 10, 64)
+	} else if s := get("applicationid"); s != "" {
+		var appID int64
+		appID, err = strconv.ParseInt(s, 10, 64)
+		if err == nil {
+			var app storage.Application
+			app, err = storage.GetApplication(ctx, storage.DB(), appID)
+			orgID = app.OrganizationID
+		}
+	} else if s := get("deveui"); s != "" {
+		var devEUI lorawan.EUI64
+		if err = devEUI.UnmarshalText([]byte(s)); err == nil {
+			var d storage.Device
+			d, err = storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+			if err == nil {
+				var app storage.Application
+				app, err = storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+				orgID = app.OrganizationID
+			}
+		}
+	} else if s := get("serviceprofileid"); s != "" {
+		var spID uuid.UUID
+		if spID, err = uuid.FromString(s); err == nil {
+			var sp storage.ServiceProfile
+			sp, err = storage.GetServiceProfile(ctx, storage.DB(), spID, true)
+			orgID = sp.OrganizationID
+		}
+	}
+
+	if err != nil {
+		log.WithError(err).Debug("api/external: get audit-log organization id error")
+		return nil
+	}
+	if orgID == 0 {
+		return nil
+	}
+
+	return &orgID
+}
+
+// auditDiff returns the changes between the given states, in the format
+// {"field": {"old": ..., "new": ...}}. Values of secret fields are redacted.
+func auditDiff(before, after map[string]interface{}) map[string]interface{} {
+	oldFields := auditFlatten("", before)
+	newFields := auditFlatten("", after)
+
+	changes := make(map[string]interface{})
+	for _, fields := range []map[string]interface{}{oldFields, newFields} {
+		for path := range fields {
+			if _, ok := changes[path]; ok {
+				continue
+			}
+
+			name := path[strings.LastIndex(path, ".")+1:]
+			if _, ok := auditIgnoreFields[auditNormalize(name)]; ok {
+				continue
+			}
+
+			oldV, newV := oldFields[path], newFields[path]
+			if reflect.DeepEqual(oldV, newV) {
+				continue
+			}
+
+			if auditIsSecret(path) {
+				if oldV != nil {
+					oldV = auditRedacted
+				}
+				if newV != nil {
+					newV = auditRedacted
+				}
+			}
+
+			changes[path] = map[string]interface{}{
+				"old": oldV,
+				"new": newV,
+			}
+		}
+	}
+
+	return changes
+}
+
+// auditFlatten flattens the nested objects of the given map into a single
+// map, using dot separated keys. Arrays are not flattened.
+func auditFlatten(prefix string, m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+
+		if nested, ok := v.(map[string]interface{}); ok {
+			for nk, nv := range auditFlatten(k, nested) {
+				out[nk] = nv
+			}
+			continue
+		}
+
+		out[k] = v
+	}
+	return out
+}
+
+func auditSortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	// top-level keys first
+	sort.Slice(keys, func(i, j int) bool {
+		di, dj := strings.Count(keys[i], "."), strings.Count(keys[j], ".")
+		if di != dj {
+			return di < dj
+		}
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
+
+// auditIsSecret returns true when the given (dot separated) field path
+// contains a secret field, e.g. the headers of headers.Authorization.
+func auditIsSecret(path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		if auditSecretRegexp.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditHasResource returns true when the given response state contains
+// more than the identifiers of the resource (e.g. the ID of a created
+// resource).
+func auditHasResource(state map[string]interface{}) bool {
+	for k := range state {
+		if !auditIsIdentifier(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func auditIsIdentifier(name string) bool {
+	n := auditNormalize(name)
+	for _, f := range auditIdentifierFields {
+		if n == f {
+			return true
+		}
+	}
+	return false
+}
+
+// auditNormalize normalizes the given field name so that snake_case and
+// camelCase names can be compared (e.g. dev_eui, devEUI and devEui).
+func auditNormalize(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// auditSnakeCase returns the snake_case version of the given CamelCase
+// name, e.g. FUOTADeployment becomes fuota_deployment.
+func auditSnakeCase(s string) string {
+	r := []rune(s)
+	var out []rune
+
+	for i := range r {
+		if i > 0 && unicode.IsUpper(r[i]) && (unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToLower(r[i]))
+	}
+
+	return string(out)
+}
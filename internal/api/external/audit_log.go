@@ -0,0 +1,201 @@
+package external
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// AuditLogEntry defines an audit log entry.
+type AuditLogEntry struct {
+	// Audit log entry ID.
+	ID int64 `json:"id,string"`
+
+	// Created at timestamp.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Request ID (as logged by the application-server).
+	RequestID string `json:"requestID"`
+
+	// Organization ID.
+	// This is 0 for global resources (e.g. network-servers).
+	OrganizationID int64 `json:"organizationID,string"`
+
+	// Subject of the actor (user or api_key).
+	Subject string `json:"subject"`
+
+	// User ID (in case of a user).
+	UserID int64 `json:"userID,string"`
+
+	// Username (in case of a user).
+	Username string `json:"username"`
+
+	// API key ID (in case of an API key).
+	APIKeyID string `json:"apiKeyID"`
+
+	// API service and method.
+	Service string `json:"service"`
+	Method  string `json:"method"`
+
+	// Action (create, update or delete).
+	Action string `json:"action"`
+
+	// Resource type and ID.
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceID"`
+
+	// Changes in the format {"field": {"old": ..., "new": ...}}.
+	// Values of secret fields (e.g. keys and passwords) are redacted.
+	Changes json.RawMessage `json:"changes"`
+}
+
+// ListAuditLogRequest defines the request for listing the audit log.
+type ListAuditLogRequest struct {
+	// Organization ID.
+	// When set to 0, the entries of all organizations and the global
+	// resources are returned (global admin only).
+	OrganizationID int64 `json:"organizationID"`
+
+	// Only return the entries of the given user ID.
+	UserID int64 `json:"userID"`
+
+	// Only return the entries of the given API key ID.
+	APIKeyID string `json:"apiKeyID"`
+
+	// Only return the entries of the given action.
+	Action string `json:"action"`
+
+	// Only return the entries of the given resource type and ID.
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceID"`
+
+	// Only return the entries within the given interval (RFC3339).
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Max number of items to return.
+	Limit int64 `json:"limit"`
+
+	// Offset in the result-set (for pagination).
+	Offset int64 `json:"offset"`
+}
+
+// ListAuditLogResponse defines the audit log list response.
+type ListAuditLogResponse struct {
+	// Total number of audit log entries.
+	TotalCount int64 `json:"totalCount,string"`
+
+	// Audit log entries within the requested limit and offset.
+	Result []AuditLogEntry `json:"result"`
+}
+
+// AuditLogAPI exports the audit log related functions.
+type AuditLogAPI struct {
+	validator auth.Validator
+}
+
+// NewAuditLogAPI creates a new AuditLogAPI.
+func NewAuditLogAPI(validator auth.Validator) *AuditLogAPI {
+	return &AuditLogAPI{
+		validator: validator,
+	}
+}
+
+// List lists the audit log entries of the given organization, most recent
+// first.
+func (a *AuditLogAPI) List(ctx context.Context, req *ListAuditLogRequest) (*ListAuditLogResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateIsOrganizationAdmin(req.OrganizationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	filters := storage.AuditLogFilters{
+		OrganizationID: req.OrganizationID,
+		UserID:         req.UserID,
+		Action:         req.Action,
+		ResourceType:   req.ResourceType,
+		ResourceID:     req.ResourceID,
+		Limit:          int(req.Limit),
+		Offset:         int(req.Offset),
+	}
+
+	if req.APIKeyID != "" {
+		id, err := uuid.FromString(req.APIKeyID)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "apiKeyID: %s", err)
+		}
+		filters.APIKeyID = &id
+	}
+
+	if req.From != "" {
+		from, err := time.Parse(time.RFC3339, req.From)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "from: %s", err)
+		}
+		filters.From = &from
+	}
+
+	if req.To != "" {
+		to, err := time.Parse(time.RFC3339, req.To)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "to: %s", err)
+		}
+		filters.To = &to
+	}
+
+	count, err := storage.GetAuditLogEntryCount(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	entries, err := storage.GetAuditLogEntries(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListAuditLogResponse{
+		TotalCount: int64(count),
+		Result:     make([]AuditLogEntry, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		item := AuditLogEntry{
+			ID:           e.ID,
+			CreatedAt:    e.CreatedAt,
+			Subject:      e.Subject,
+			Username:     e.Username,
+			Service:      e.Service,
+			Method:       e.Method,
+			Action:       e.Action,
+			ResourceType: e.ResourceType,
+			ResourceID:   e.ResourceID,
+			Changes:      e.Changes,
+		}
+
+		if e.RequestID != nil {
+			item.RequestID = e.RequestID.String()
+		}
+		if e.OrganizationID != nil {
+			item.OrganizationID = *e.OrganizationID
+		}
+		if e.UserID != nil {
+			item.UserID = *e.UserID
+		}
+		if e.APIKeyID != nil {
+			item.APIKeyID = e.APIKeyID.String()
+		}
+
+		resp.Result = append(resp.Result, item)
+	}
+
+	return &resp, nil
+}
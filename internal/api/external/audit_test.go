@@ -0,0 +1,212 @@
+package external
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/gyh1621/chirpstack-api/go/v3/as/external/api"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+func TestAuditSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Gateway":         "gateway",
+		"DeviceProfile":   "device_profile",
+		"FUOTADeployment": "fuota_deployment",
+		"APIKey":          "api_key",
+		"Keys":            "keys",
+	}
+
+	for in, out := range tests {
+		t.Run(in, func(t *testing.T) {
+			require.Equal(t, out, auditSnakeCase(in))
+		})
+	}
+}
+
+func TestAuditHTTPHandler(t *testing.T) {
+	assert := require.New(t)
+
+	api := NewFirmwareImageAPI(nil)
+	service, method := auditHTTPHandler(api.Create)
+	assert.Equal("FirmwareImageService", service)
+	assert.Equal("Create", method)
+
+	dpAPI := NewDeviceProfileServiceAPI(nil)
+	service, method = auditHTTPHandler(dpAPI.UpdateApplicationLayer)
+	assert.Equal("DeviceProfileService", service)
+	assert.Equal("UpdateApplicationLayer", method)
+}
+
+func TestAuditIdentifiers(t *testing.T) {
+	assert := require.New(t)
+
+	state := auditState(&pb.UpdateDeviceKeysRequest{
+		DeviceKeys: &pb.DeviceKeys{
+			DevEui: "0102030405060708",
+			NwkKey: "01020304050607080102030405060708",
+		},
+	})
+	ids := auditIdentifiers(state)
+	assert.Equal(map[string]interface{}{
+		"dev_eui": "0102030405060708",
+	}, ids)
+	assert.Equal("0102030405060708", auditResourceID(ids))
+
+	state = auditState(&pb.DeleteOrganizationUserRequest{
+		OrganizationId: 1,
+		UserId:         2,
+	})
+	ids = auditIdentifiers(state)
+	assert.Equal(map[string]interface{}{
+		"organization_id": "1",
+		"user_id":         "2",
+	}, ids)
+	assert.Equal("2", auditResourceID(ids))
+}
+
+func TestAuditDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   map[string]interface{}
+		after    map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name: "create",
+			after: map[string]interface{}{
+				"gateway": map[string]interface{}{
+					"id":   "0102030405060708",
+					"name": "test-gw",
+				},
+				"created_at": "2020-01-01T00:00:00Z",
+			},
+			expected: map[string]interface{}{
+				"gateway.id":   map[string]interface{}{"old": nil, "new": "0102030405060708"},
+				"gateway.name": map[string]interface{}{"old": nil, "new": "test-gw"},
+			},
+		},
+		{
+			name: "update with secret",
+			before: map[string]interface{}{
+				"device_keys": map[string]interface{}{
+					"dev_eui": "0102030405060708",
+					"nwk_key": "01020304050607080102030405060708",
+				},
+			},
+			after: map[string]interface{}{
+				"device_keys": map[string]interface{}{
+					"dev_eui": "0102030405060708",
+					"nwk_key": "08070605040302010807060504030201",
+				},
+			},
+			expected: map[string]interface{}{
+				"device_keys.nwk_key": map[string]interface{}{"old": auditRedacted, "new": auditRedacted},
+			},
+		},
+		{
+			name: "integration credentials",
+			before: map[string]interface{}{
+				"integration": map[string]interface{}{
+					"credentials_file":  "{\"private_key\": \"old\"}",
+					"connection_string": "Endpoint=sb://old/;SharedAccessKey=old",
+					"headers": []interface{}{
+						map[string]interface{}{"key": "Authorization", "value": "Bearer old"},
+					},
+					"topic_name": "old",
+				},
+			},
+			after: map[string]interface{}{
+				"integration": map[string]interface{}{
+					"credentialsFile":  "{\"private_key\": \"new\"}",
+					"connectionString": "Endpoint=sb://new/;SharedAccessKey=new",
+					"headers": map[string]interface{}{
+						"Authorization": "Bearer new",
+					},
+					"topic_name": "new",
+				},
+			},
+			expected: map[string]interface{}{
+				"integration.credentials_file":      map[string]interface{}{"old": auditRedacted, "new": nil},
+				"integration.connection_string":     map[string]interface{}{"old": auditRedacted, "new": nil},
+				"integration.credentialsFile":       map[string]interface{}{"old": nil, "new": auditRedacted},
+				"integration.connectionString":      map[string]interface{}{"old": nil, "new": auditRedacted},
+				"integration.headers":               map[string]interface{}{"old": auditRedacted, "new": nil},
+				"integration.headers.Authorization": map[string]interface{}{"old": nil, "new": auditRedacted},
+				"integration.topic_name":            map[string]interface{}{"old": "old", "new": "new"},
+			},
+		},
+		{
+			name: "two-factor authentication codes",
+			after: map[string]interface{}{
+				"code":          "123456",
+				"recoveryCodes": []interface{}{"abcd-efgh"},
+				"jwt":           "header.payload.signature",
+			},
+			expected: map[string]interface{}{
+				"code":          map[string]interface{}{"old": nil, "new": auditRedacted},
+				"recoveryCodes": map[string]interface{}{"old": nil, "new": auditRedacted},
+				"jwt":           map[string]interface{}{"old": nil, "new": auditRedacted},
+			},
+		},
+		{
+			name: "delete",
+			before: map[string]interface{}{
+				"password": "secret",
+				"tags":     []interface{}{"a", "b"},
+			},
+			expected: map[string]interface{}{
+				"password": map[string]interface{}{"old": auditRedacted, "new": nil},
+				"tags":     map[string]interface{}{"old": []interface{}{"a", "b"}, "new": nil},
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			require.Equal(t, tst.expected, auditDiff(tst.before, tst.after))
+		})
+	}
+}
+
+func TestAuditAction(t *testing.T) {
+	tests := []struct {
+		method         string
+		expectedAction string
+		expectedSuffix string
+	}{
+		{"Create", storage.AuditActionCreate, ""},
+		{"UpdateKeys", storage.AuditActionUpdate, "Keys"},
+		{"DeleteHTTPIntegration", storage.AuditActionDelete, "HTTPIntegration"},
+		{"AddDevice", storage.AuditActionExecute, "Device"},
+		{"RemoveDevice", storage.AuditActionExecute, "Device"},
+		{"Activate", storage.AuditActionExecute, ""},
+		{"Enqueue", storage.AuditActionExecute, ""},
+		{"FlushQueue", storage.AuditActionExecute, "Queue"},
+		{"Cancel", storage.AuditActionExecute, ""},
+		{"Pause", storage.AuditActionExecute, ""},
+		{"Resume", storage.AuditActionExecute, ""},
+		{"RotateAPIKey", storage.AuditActionExecute, "APIKey"},
+		{"RollbackCodecRevision", storage.AuditActionExecute, "CodecRevision"},
+		{"EnrollTOTP", storage.AuditActionExecute, "TOTP"},
+		{"Get", "", ""},
+		{"GetKeys", "", ""},
+		{"List", "", ""},
+		{"StreamEventLogs", "", ""},
+		{"DiffCodecRevision", "", ""},
+		{"TestCodec", "", ""},
+		{"GlobalSearch", "", ""},
+		{"Login", "", ""},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.method, func(t *testing.T) {
+			assert := require.New(t)
+
+			action, suffix := auditAction(tst.method)
+			assert.Equal(tst.expectedAction, action)
+			assert.Equal(tst.expectedSuffix, suffix)
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package auth
+
+// noApplicationUser matches the organization users (ou) which do not have
+// a role for any application of the organization.
+const noApplicationUser = `
+	not exists (
+		select 1
+		from application_user sau
+		inner join application sa
+			on sa.id = sau.application_id
+		where
+			sau.user_id = ou.user_id
+			and sa.organization_id = ou.organization_id)`
+
+// applicationScope restricts the access of the organization user (ou) to
+// the applications of the organization. Organization users which are not
+// an organization admin and which have a role for one or more applications
+// of the organization (application_user) only have access to these
+// applications, using the permissions of this role (see validateRole).
+const applicationScope = `ou.is_admin = true or` + noApplicationUser
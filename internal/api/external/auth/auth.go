@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
@@ -12,6 +13,8 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/metadata"
 
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/oidc"
+	"github.com/gyh1621/chirpstack-application-server/internal/jwtkeys"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
@@ -29,6 +32,10 @@ type Claims struct {
 
 	// APIKeyID defines the API key ID.
 	APIKeyID uuid.UUID `json:"api_key_id"`
+
+	// Scopes contains the scopes of the API key. This is not part of the
+	// token but is set from the API key on validation.
+	Scopes []string `json:"-"`
 }
 
 // Validator defines the interface a validator needs to implement.
@@ -57,11 +64,16 @@ type Validator interface {
 // error in case an error occurred (e.g. db connectivity).
 type ValidatorFunc func(sqlx.Queryer, *Claims) (bool, error)
 
+// GroupSyncFunc updates the global admin flag and the organization
+// memberships of the given user, based on the given groups.
+type GroupSyncFunc func(ctx context.Context, db sqlx.Ext, user *storage.User, groups []string) error
+
 // JWTValidator validates JWT tokens.
 type JWTValidator struct {
 	db        sqlx.Ext
 	secret    string
 	algorithm string
+	groupSync GroupSyncFunc
 }
 
 // NewJWTValidator creates a new JWTValidator.
@@ -73,6 +85,12 @@ func NewJWTValidator(db sqlx.Ext, algorithm, secret string) *JWTValidator {
 	}
 }
 
+// SetGroupSync sets the function which is used to re-evaluate the groups
+// of OpenID Connect access tokens when the groups claim has been configured.
+func (v *JWTValidator) SetGroupSync(f GroupSyncFunc) {
+	v.groupSync = f
+}
+
 // Validate validates the token from the given context against the given
 // validator funcs.
 func (v JWTValidator) Validate(ctx context.Context, funcs ...ValidatorFunc) error {
@@ -81,6 +99,12 @@ func (v JWTValidator) Validate(ctx context.Context, funcs ...ValidatorFunc) erro
 		return err
 	}
 
+	if claims.Subject == SubjectAPIKey {
+		if err := v.validateAPIKey(ctx, claims); err != nil {
+			return err
+		}
+	}
+
 	for _, f := range funcs {
 		ok, err := f(v.db, claims)
 		if err != nil {
@@ -121,7 +145,7 @@ func (v JWTValidator) GetUser(ctx context.Context) (storage.User, error) {
 		return storage.User{}, err
 	}
 
-	if claims.Subject != SubjectUser {
+	if claims.Subject != SubjectUser && claims.Subject != SubjectTOTPEnrollment {
 		return storage.User{}, errors.New("subject must be user")
 	}
 
@@ -136,18 +160,131 @@ func (v JWTValidator) GetUser(ctx context.Context) (storage.User, error) {
 	return storage.User{}, errors.New("no username or user_id in claims")
 }
 
+// validateAPIKey validates that the API key has not expired and that the
+// token has not been rotated (or is within the grace period). On success,
+// it sets the scopes of the API key and updates the last used timestamp.
+func (v JWTValidator) validateAPIKey(ctx context.Context, claims *Claims) error {
+	ak, err := storage.GetAPIKey(ctx, v.db, claims.APIKeyID)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return ErrNotAuthorized
+		}
+		return errors.Wrap(err, "get api key error")
+	}
+
+	now := time.Now()
+
+	if ak.ExpiresAt != nil && !now.Before(*ak.ExpiresAt) {
+		return ErrAPIKeyExpired
+	}
+
+	// tokens issued before the introduction of token IDs do not have a jti
+	// and match the nil token ID
+	var tokenID uuid.UUID
+	if claims.Id != "" {
+		tokenID, err = uuid.FromString(claims.Id)
+		if err != nil {
+			return ErrInvalidToken
+		}
+	}
+
+	switch {
+	case tokenID == ak.TokenID:
+	case ak.PreviousTokenID != nil && tokenID == *ak.PreviousTokenID && ak.PreviousTokenExpiresAt != nil && now.Before(*ak.PreviousTokenExpiresAt):
+	default:
+		return ErrAPIKeyTokenRotated
+	}
+
+	claims.Scopes = ak.Scopes
+
+	if err := storage.UpdateAPIKeyLastUsed(ctx, v.db, ak.ID); err != nil {
+		return errors.Wrap(err, "update api key last used error")
+	}
+
+	return nil
+}
+
+// keyFunc returns the key for validating the given token. Tokens signed
+// using HS256 and the jwt_secret remain valid when the jwt_algorithm is
+// set to RS256 or ES256, as API key tokens do not expire and these would
+// otherwise all be invalidated by the switch.
+func (v JWTValidator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Header["alg"] {
+	case jwt.SigningMethodHS256.Alg():
+		return []byte(v.secret), nil
+	case v.algorithm:
+		return jwtkeys.Keyfunc(token)
+	default:
+		return nil, ErrInvalidAlgorithm
+	}
+}
+
+// getAccessTokenClaims verifies the given OpenID Connect access token and
+// returns the claims of the matching user. The user is matched by external
+// ID or else by (verified) e-mail address. When the groups claim has been
+// configured, the groups are re-evaluated as is done on login.
+func (v JWTValidator) getAccessTokenClaims(ctx context.Context, tokenStr string) (*Claims, error) {
+	oidcUser, err := oidc.VerifyAccessToken(ctx, tokenStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "verify oidc access token error")
+	}
+
+	user, err := storage.GetUserByExternalID(ctx, v.db, oidcUser.ExternalID)
+	if err == storage.ErrDoesNotExist && oidcUser.Email != "" && oidcUser.EmailVerified {
+		user, err = storage.GetUserByEmail(ctx, v.db, oidcUser.Email)
+		if err == nil && user.ExternalID != nil && *user.ExternalID != oidcUser.ExternalID {
+			return nil, ErrNotAuthorized
+		}
+	}
+	if err != nil {
+		if err == storage.ErrDoesNotExist {
+			return nil, ErrNotAuthorized
+		}
+		return nil, errors.Wrap(err, "get user error")
+	}
+
+	if oidc.GroupsClaimEnabled() {
+		if v.groupSync == nil {
+			return nil, ErrNotAuthorized
+		}
+
+		isAdmin := user.IsAdmin
+		err := storage.Transaction(func(tx sqlx.Ext) error {
+			if err := v.groupSync(ctx, tx, &user, oidcUser.Groups); err != nil {
+				return err
+			}
+			if user.IsAdmin == isAdmin {
+				return nil
+			}
+			return storage.UpdateUser(ctx, tx, &user)
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "sync user groups error")
+		}
+	}
+
+	return &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject: SubjectUser,
+		},
+		Username: user.Email,
+		UserID:   user.ID,
+	}, nil
+}
+
 func (v JWTValidator) getClaims(ctx context.Context) (*Claims, error) {
 	tokenStr, err := getTokenFromContext(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "get token from context error")
 	}
 
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if token.Header["alg"] != v.algorithm {
-			return nil, ErrInvalidAlgorithm
-		}
-		return []byte(v.secret), nil
-	})
+	// access tokens issued by the OpenID Connect provider
+	var stdClaims jwt.StandardClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenStr, &stdClaims); err == nil && oidc.IsAccessTokenIssuer(stdClaims.Issuer) {
+		return v.getAccessTokenClaims(ctx, tokenStr)
+	}
+
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, v.keyFunc)
 	if err != nil {
 		return nil, errors.Wrap(err, "jwt parse error")
 	}
@@ -99,3 +99,45 @@ func TestJWTValidator(t *testing.T) {
 	}
 
 }
+
+func TestJWTValidatorLegacyHS256(t *testing.T) {
+	apiKeyID, err := uuid.NewV4()
+	require.NoError(t, err)
+
+	v := JWTValidator{
+		secret:    "verysecret",
+		algorithm: "ES256",
+	}
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		err    error
+	}{
+		{"HS256 tokens remain valid", jwt.SigningMethodHS256, nil},
+		{"other HMAC algorithms are rejected", jwt.SigningMethodHS384, ErrInvalidAlgorithm},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			token := jwt.NewWithClaims(tst.method, Claims{APIKeyID: apiKeyID})
+			ss, err := token.SignedString([]byte(v.secret))
+			assert.NoError(err)
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{
+				"authorization": []string{ss},
+			})
+
+			id, err := v.GetAPIKeyID(ctx)
+			if tst.err != nil {
+				assert.Error(err)
+				assert.Contains(err.Error(), tst.err.Error())
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(apiKeyID, id)
+		})
+	}
+}
@@ -9,4 +9,6 @@ var (
 	ErrInvalidAlgorithm          = errors.New("invalid algorithm")
 	ErrInvalidToken              = errors.New("invalid token")
 	ErrNotAuthorized             = errors.New("not authorized")
+	ErrAPIKeyExpired             = errors.New("api key expired")
+	ErrAPIKeyTokenRotated        = errors.New("api key token has been rotated")
 )
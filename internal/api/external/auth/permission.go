@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// flagActions maps the authorization flags to the permission actions.
+var flagActions = map[Flag]string{
+	Create: "create",
+	Read:   "read",
+	Update: "update",
+	Delete: "delete",
+	List:   "list",
+}
+
+// rolePermissions defines per resource the operations which can be granted
+// by a role.
+var rolePermissions = []struct {
+	resource string
+	flags    []Flag
+}{
+	{resourceApplication, []Flag{Create, Read, Update, Delete, List}},
+	{resourceDevice, []Flag{Create, Read, Update, Delete, List}},
+	{resourceDeviceKeys, []Flag{Create, Read, Update, Delete}},
+	{resourceDeviceQueue, []Flag{Create, List, Delete}},
+	{resourceGateway, []Flag{Create, Read, Update, Delete, List}},
+	{resourceOrganizationUser, []Flag{Read, List}},
+	{resourceDeviceProfile, []Flag{Create, Read, Update, Delete, List}},
+	{resourceMulticastGroup, []Flag{Create, Read, Update, Delete, List}},
+	{resourceMulticastGroupQueue, []Flag{Create, Read, List, Delete}},
+	{resourceFUOTADeployment, []Flag{Create, Read, Update}},
+	{resourceFirmwareImage, []Flag{Create, Read, Update, Delete, List}},
+	{resourceNetworkServer, []Flag{Read}},
+}
+
+// Role targets, returning the organization_id and application_id of the
+// object given as $4.
+const (
+	roleTargetOrganization = `
+		select o.id as organization_id, null::bigint as application_id
+		from organization o
+		where o.id = $4`
+
+	roleTargetGatewayOrganization = `
+		select o.id as organization_id, null::bigint as application_id
+		from organization o
+		where o.id = $4 and o.can_have_gateways = true`
+
+	roleTargetApplication = `
+		select a.organization_id, a.id as application_id
+		from application a
+		where a.id = $4`
+
+	roleTargetDevice = `
+		select a.organization_id, a.id as application_id
+		from device d
+		inner join application a
+			on a.id = d.application_id
+		where d.dev_eui = $4`
+
+	roleTargetGateway = `
+		select g.organization_id, null::bigint as application_id
+		from gateway g
+		where g.mac = $4`
+
+	roleTargetDeviceProfile = `
+		select dp.organization_id, null::bigint as application_id
+		from device_profile dp
+		where dp.device_profile_id = $4`
+
+	roleTargetMulticastGroup = `
+		select sp.organization_id, null::bigint as application_id
+		from multicast_group mg
+		inner join service_profile sp
+			on sp.service_profile_id = mg.service_profile_id
+		where mg.id = $4`
+
+	roleTargetFUOTADeployment = `
+		select distinct a.organization_id, a.id as application_id
+		from fuota_deployment_device fdd
+		inner join device d
+			on d.dev_eui = fdd.dev_eui
+		inner join application a
+			on a.id = d.application_id
+		where fdd.fuota_deployment_id = $4`
+
+	roleTargetFirmwareImage = `
+		select fi.organization_id, null::bigint as application_id
+		from firmware_image fi
+		where fi.id = $4`
+
+	roleTargetNetworkServer = `
+		select distinct sp.organization_id, null::bigint as application_id
+		from service_profile sp
+		where sp.network_server_id = $4`
+)
+
+// permission returns the permission for the given operation on the given
+// resource, e.g. gateway:read.
+func permission(resource string, flag Flag) string {
+	return resource + ":" + flagActions[flag]
+}
+
+// Permissions returns all the permissions which can be granted by a role.
+func Permissions() []string {
+	var out []string
+	for _, rp := range rolePermissions {
+		for _, flag := range rp.flags {
+			out = append(out, permission(rp.resource, flag))
+		}
+	}
+	return out
+}
+
+// IsValidPermission returns true when the given permission can be granted
+// by a role.
+func IsValidPermission(p string) bool {
+	for _, permission := range Permissions() {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRole wraps the given validator func and in case it does not
+// grant access to an user, it validates if a role assigned to the user
+// grants the given operation on the given resource. The target query must
+// return the organization (and application) of the object given as arg.
+//
+// Organization roles apply to all the objects of the organization,
+// application roles only to the objects of the application. Once an user
+// has a role for one or more applications of the organization, the
+// organization role no longer applies to the objects of the applications
+// (see applicationScope).
+func validateRole(resource string, flag Flag, target string, arg interface{}, f ValidatorFunc) ValidatorFunc {
+	p := permission(resource, flag)
+
+	// the operation can not be granted by a role
+	if !IsValidPermission(p) {
+		return f
+	}
+
+	query := `
+		select
+			1
+		from
+			"user" u
+		cross join (` + target + `) t
+		left join organization_user ou
+			on ou.user_id = u.id and ou.organization_id = t.organization_id
+		left join role ro
+			on ro.id = ou.role_id
+		left join application_user au
+			on au.user_id = u.id and au.application_id = t.application_id
+		left join role ra
+			on ra.id = au.role_id
+	`
+
+	// organization role
+	// application role (of an organization user)
+	where := [][]string{
+		{"(u.email = $1 or u.id = $2)", "u.is_active = true", "$3 = any(ro.permissions)", "t.application_id is null or" + noApplicationUser},
+		{"(u.email = $1 or u.id = $2)", "u.is_active = true", "ou.user_id is not null", "$3 = any(ra.permissions)"},
+	}
+
+	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		ok, err := f(db, claims)
+		if err != nil || ok {
+			return ok, err
+		}
+
+		if claims.Subject != SubjectUser {
+			return false, nil
+		}
+
+		return executeQuery(db, query, where, claims.Username, claims.UserID, p, arg)
+	}
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValidPermission(t *testing.T) {
+	tests := []struct {
+		Permission string
+		ExpectedOK bool
+	}{
+		{"gateway:read", true},
+		{"device-queue:create", true},
+		{"device-keys:read", true},
+		{"device-keys:list", false},
+		{"organization-user:create", false},
+		{"organization:update", false},
+		{"gateway", false},
+		{"", false},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Permission, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.ExpectedOK, IsValidPermission(tst.Permission))
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// Scope defines an API key scope.
+type Scope string
+
+// API key scopes.
+// An API key without scopes is not restricted by scope.
+const (
+	// ScopeRead allows all read and list operations.
+	ScopeRead Scope = "read"
+
+	// ScopeGatewayRead allows reading and listing gateways.
+	ScopeGatewayRead Scope = "gateway-read"
+
+	// ScopeDeviceQueueEnqueue allows enqueueing device-queue items.
+	ScopeDeviceQueueEnqueue Scope = "device-queue-enqueue"
+)
+
+// Scopes contains all the valid API key scopes.
+var Scopes = []Scope{
+	ScopeRead,
+	ScopeGatewayRead,
+	ScopeDeviceQueueEnqueue,
+}
+
+// Resources used for validating the API key scopes and role permissions.
+const (
+	resourceUser                      = "user"
+	resourceApplication               = "application"
+	resourceDevice                    = "device"
+	resourceDeviceQueue               = "device-queue"
+	resourceDeviceKeys                = "device-keys"
+	resourceGateway                   = "gateway"
+	resourceOrganization              = "organization"
+	resourceOrganizationUser          = "organization-user"
+	resourceGatewayProfile            = "gateway-profile"
+	resourceNetworkServer             = "network-server"
+	resourceServiceProfile            = "service-profile"
+	resourceDeviceProfile             = "device-profile"
+	resourceMulticastGroup            = "multicast-group"
+	resourceMulticastGroupQueue       = "multicast-group-queue"
+	resourceFUOTADeployment           = "fuota-deployment"
+	resourceFirmwareImage             = "firmware-image"
+	resourceOrganizationNetworkServer = "organization-network-server"
+	resourceRole                      = "role"
+	resourceApplicationUser           = "application-user"
+)
+
+// IsValidScope returns true when the given scope is a valid scope.
+func IsValidScope(s string) bool {
+	for _, scope := range Scopes {
+		if string(scope) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeAllows returns true when the given scope allows the given operation
+// on the given resource.
+func scopeAllows(scope Scope, resource string, flag Flag) bool {
+	switch scope {
+	case ScopeRead:
+		return flag == Read || flag == List
+	case ScopeGatewayRead:
+		return resource == resourceGateway && (flag == Read || flag == List)
+	case ScopeDeviceQueueEnqueue:
+		return resource == resourceDeviceQueue && flag == Create
+	default:
+		return false
+	}
+}
+
+// validateAPIKeyScope wraps the given validator func and in case of an API
+// key which is restricted by scopes, it validates that one of the scopes
+// allows the given operation on the given resource.
+func validateAPIKeyScope(resource string, flag Flag, f ValidatorFunc) ValidatorFunc {
+	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		if claims.Subject == SubjectAPIKey && len(claims.Scopes) != 0 {
+			var ok bool
+			for _, s := range claims.Scopes {
+				if scopeAllows(Scope(s), resource, flag) {
+					ok = true
+					break
+				}
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+
+		return f(db, claims)
+	}
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAPIKeyScope(t *testing.T) {
+	pass := func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		return true, nil
+	}
+
+	tests := []struct {
+		Name       string
+		Claims     Claims
+		Resource   string
+		Flag       Flag
+		ExpectedOK bool
+	}{
+		{
+			Name:       "user is not restricted",
+			Claims:     Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectUser}, Scopes: []string{string(ScopeRead)}},
+			Resource:   resourceDevice,
+			Flag:       Delete,
+			ExpectedOK: true,
+		},
+		{
+			Name:       "api key without scopes is not restricted",
+			Claims:     Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}},
+			Resource:   resourceDevice,
+			Flag:       Delete,
+			ExpectedOK: true,
+		},
+		{
+			Name:       "read scope allows list",
+			Claims:     Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeRead)}},
+			Resource:   resourceApplication,
+			Flag:       List,
+			ExpectedOK: true,
+		},
+		{
+			Name:     "read scope does not allow create",
+			Claims:   Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeRead)}},
+			Resource: resourceApplication,
+			Flag:     Create,
+		},
+		{
+			Name:       "gateway-read scope allows reading a gateway",
+			Claims:     Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeGatewayRead)}},
+			Resource:   resourceGateway,
+			Flag:       Read,
+			ExpectedOK: true,
+		},
+		{
+			Name:     "gateway-read scope does not allow updating a gateway",
+			Claims:   Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeGatewayRead)}},
+			Resource: resourceGateway,
+			Flag:     Update,
+		},
+		{
+			Name:       "device-queue-enqueue scope allows enqueue",
+			Claims:     Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeDeviceQueueEnqueue)}},
+			Resource:   resourceDeviceQueue,
+			Flag:       Create,
+			ExpectedOK: true,
+		},
+		{
+			Name:     "device-queue-enqueue scope does not allow flushing the queue",
+			Claims:   Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeDeviceQueueEnqueue)}},
+			Resource: resourceDeviceQueue,
+			Flag:     Delete,
+		},
+		{
+			Name:       "multiple scopes",
+			Claims:     Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{string(ScopeGatewayRead), string(ScopeDeviceQueueEnqueue)}},
+			Resource:   resourceDeviceQueue,
+			Flag:       Create,
+			ExpectedOK: true,
+		},
+		{
+			Name:     "unknown scope",
+			Claims:   Claims{StandardClaims: jwt.StandardClaims{Subject: SubjectAPIKey}, Scopes: []string{"foo"}},
+			Resource: resourceDevice,
+			Flag:     Read,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ok, err := validateAPIKeyScope(tst.Resource, tst.Flag, pass)(nil, &tst.Claims)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedOK, ok)
+		})
+	}
+}
+
+func TestIsValidScope(t *testing.T) {
+	assert := require.New(t)
+
+	assert.True(IsValidScope("read"))
+	assert.True(IsValidScope("gateway-read"))
+	assert.True(IsValidScope("device-queue-enqueue"))
+	assert.False(IsValidScope("write"))
+}
@@ -14,6 +14,11 @@ import (
 const (
 	SubjectUser   = "user"
 	SubjectAPIKey = "api_key"
+
+	// SubjectTOTPEnrollment is the subject of the token which is issued
+	// on login when two-factor authentication is required but not yet
+	// enabled. It only passes the ValidateTOTPEnrollment validator.
+	SubjectTOTPEnrollment = "totp_enrollment"
 )
 
 // Flag defines the authorization flag.
@@ -54,6 +59,30 @@ func ValidateActiveUser() ValidatorFunc {
 	}
 }
 
+// ValidateTOTPEnrollment validates if the user in the JWT claim is active
+// and is allowed to manage its own two-factor authentication.
+func ValidateTOTPEnrollment() ValidatorFunc {
+	query := `
+		select
+			1
+		from
+			"user" u
+	`
+
+	where := [][]string{
+		{"(u.email = $1 or u.id = $2)", "u.is_active = true"},
+	}
+
+	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser, SubjectTOTPEnrollment:
+			return executeQuery(db, query, where, claims.Username, claims.UserID)
+		default:
+			return false, nil
+		}
+	}
+}
+
 // ValidateUsersAccess validates if the client has access to the global users
 // resource.
 func ValidateUsersAccess(flag Flag) ValidatorFunc {
@@ -99,7 +128,7 @@ func ValidateUsersAccess(flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceUser, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, claims.UserID)
@@ -108,7 +137,7 @@ func ValidateUsersAccess(flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateUserAccess validates if the client has access to the given user
@@ -170,7 +199,7 @@ func ValidateUserAccess(userID int64, flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceUser, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, userID, claims.UserID)
@@ -179,7 +208,7 @@ func ValidateUserAccess(userID int64, flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateApplicationsAccess validates if the client has access to the
@@ -191,7 +220,7 @@ func ValidateApplicationsAccess(flag Flag, organizationID int64) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join application a
@@ -250,7 +279,7 @@ func ValidateApplicationsAccess(flag Flag, organizationID int64) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceApplication, flag, validateRole(resourceApplication, flag, roleTargetOrganization, organizationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
@@ -259,7 +288,7 @@ func ValidateApplicationsAccess(flag Flag, organizationID int64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateApplicationAccess validates if the client has access to the given
@@ -271,7 +300,7 @@ func ValidateApplicationAccess(applicationID int64, flag Flag) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join application a
@@ -296,7 +325,7 @@ func ValidateApplicationAccess(applicationID int64, flag Flag) ValidatorFunc {
 		// organization user
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "a.id = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "a.id = $2", applicationScope},
 		}
 
 		// admin api key
@@ -313,7 +342,7 @@ func ValidateApplicationAccess(applicationID int64, flag Flag) ValidatorFunc {
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "a.id = $2"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "a.id = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "a.id = $2", applicationScope},
 		}
 
 		// admin api key
@@ -330,7 +359,7 @@ func ValidateApplicationAccess(applicationID int64, flag Flag) ValidatorFunc {
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "a.id = $2"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "a.id = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "a.id = $2", applicationScope},
 		}
 
 		// admin api key
@@ -344,7 +373,7 @@ func ValidateApplicationAccess(applicationID int64, flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceApplication, flag, validateRole(resourceApplication, flag, roleTargetApplication, applicationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, applicationID, claims.UserID)
@@ -353,7 +382,7 @@ func ValidateApplicationAccess(applicationID int64, flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateNodesAccess validates if the client has access to the global nodes
@@ -365,7 +394,7 @@ func ValidateNodesAccess(applicationID int64, flag Flag) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join application a
@@ -392,7 +421,7 @@ func ValidateNodesAccess(applicationID int64, flag Flag) ValidatorFunc {
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "a.id = $2"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "a.id = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "a.id = $2", applicationScope},
 		}
 
 		// admin api key
@@ -407,7 +436,7 @@ func ValidateNodesAccess(applicationID int64, flag Flag) ValidatorFunc {
 		// organization user
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "a.id = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "a.id = $2", applicationScope},
 		}
 
 		// admin api key
@@ -421,7 +450,7 @@ func ValidateNodesAccess(applicationID int64, flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceDevice, flag, validateRole(resourceDevice, flag, roleTargetApplication, applicationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, applicationID, claims.UserID)
@@ -430,7 +459,7 @@ func ValidateNodesAccess(applicationID int64, flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateNodeAccess validates if the client has access to the given node.
@@ -441,7 +470,7 @@ func ValidateNodeAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join application a
@@ -470,7 +499,7 @@ func ValidateNodeAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		// organization user
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "d.dev_eui = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "d.dev_eui = $2", applicationScope},
 		}
 
 		// admin api key
@@ -488,7 +517,7 @@ func ValidateNodeAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "d.dev_eui = $2"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "d.dev_eui = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "d.dev_eui = $2", applicationScope},
 		}
 
 		// admin api key
@@ -505,7 +534,7 @@ func ValidateNodeAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "d.dev_eui = $2"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "d.dev_eui = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "d.dev_eui = $2", applicationScope},
 		}
 
 		// admin api key
@@ -519,7 +548,7 @@ func ValidateNodeAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceDevice, flag, validateRole(resourceDevice, flag, roleTargetDevice, devEUI[:], func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, devEUI[:], claims.UserID)
@@ -528,7 +557,7 @@ func ValidateNodeAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateDeviceQueueAccess validates if the client has access to the queue
@@ -540,7 +569,7 @@ func ValidateDeviceQueueAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join application a
 			on a.organization_id = ou.organization_id
 		left join device d
@@ -567,7 +596,7 @@ func ValidateDeviceQueueAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		// organization user
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "d.dev_eui = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "d.dev_eui = $2", applicationScope},
 		}
 
 		// admin api key
@@ -582,7 +611,7 @@ func ValidateDeviceQueueAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceDeviceQueue, flag, validateRole(resourceDeviceQueue, flag, roleTargetDevice, devEUI[:], func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, devEUI[:], claims.UserID)
@@ -591,7 +620,86 @@ func ValidateDeviceQueueAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
+	}))
+}
+
+// ValidateDeviceKeysAccess validates if the client has access to the keys
+// (device-keys and activation) of the given device.
+func ValidateDeviceKeysAccess(devEUI lorawan.EUI64, flag Flag) ValidatorFunc {
+	userQuery := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
+		left join application a
+			on a.organization_id = ou.organization_id
+		left join device d
+			on a.id = d.application_id
+	`
+
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+		left join application a
+			on ak.application_id = a.id or ak.organization_id = a.organization_id
+		left join device d
+			on a.id = d.application_id
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
+
+	switch flag {
+	case Read:
+		// global admin
+		// organization user
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "d.dev_eui = $2", applicationScope},
+		}
+
+		// admin api key
+		// organization api key
+		// application api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "d.dev_eui = $2"}, // application is joined on a.id and a.organization_id
+		}
+	case Create, Update, Delete:
+		// global admin
+		// organization admin
+		// organization device admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "d.dev_eui = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "d.dev_eui = $2", applicationScope},
+		}
+
+		// admin api key
+		// organization api key
+		// application api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "d.dev_eui = $2"}, // application is joined on a.id and a.organization_id
+		}
+	default:
+		panic("unsupported flag")
 	}
+
+	return validateAPIKeyScope(resourceDeviceKeys, flag, validateRole(resourceDeviceKeys, flag, roleTargetDevice, devEUI[:], func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, devEUI[:], claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, devEUI[:])
+		default:
+			return false, nil
+		}
+	}))
 }
 
 // ValidateGatewaysAccess validates if the client has access to the gateways.
@@ -602,7 +710,7 @@ func ValidateGatewaysAccess(flag Flag, organizationID int64) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 	`
@@ -657,7 +765,13 @@ func ValidateGatewaysAccess(flag Flag, organizationID int64) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	// gateways can only be created when the organization can have gateways
+	target := roleTargetOrganization
+	if flag == Create {
+		target = roleTargetGatewayOrganization
+	}
+
+	return validateAPIKeyScope(resourceGateway, flag, validateRole(resourceGateway, flag, target, organizationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
@@ -666,7 +780,7 @@ func ValidateGatewaysAccess(flag Flag, organizationID int64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateGatewayAccess validates if the client has access to the given gateway.
@@ -677,7 +791,7 @@ func ValidateGatewayAccess(flag Flag, mac lorawan.EUI64) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join gateway g
@@ -731,7 +845,7 @@ func ValidateGatewayAccess(flag Flag, mac lorawan.EUI64) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceGateway, flag, validateRole(resourceGateway, flag, roleTargetGateway, mac[:], func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, mac[:], claims.UserID)
@@ -740,7 +854,7 @@ func ValidateGatewayAccess(flag Flag, mac lorawan.EUI64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateIsOrganizationAdmin validates if the client has access to
@@ -752,7 +866,7 @@ func ValidateIsOrganizationAdmin(organizationID int64) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 	`
@@ -780,7 +894,7 @@ func ValidateIsOrganizationAdmin(organizationID int64) ValidatorFunc {
 		{"ak.id = $1", "o.id = $2"},
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceOrganization, Update, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
@@ -789,7 +903,7 @@ func ValidateIsOrganizationAdmin(organizationID int64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateOrganizationsAccess validates if the client has access to the
@@ -839,7 +953,7 @@ func ValidateOrganizationsAccess(flag Flag) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceOrganization, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, claims.UserID)
@@ -848,7 +962,7 @@ func ValidateOrganizationsAccess(flag Flag) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateOrganizationAccess validates if the client has access to the
@@ -895,7 +1009,7 @@ func ValidateOrganizationAccess(flag Flag, id int64) ValidatorFunc {
 		// organization admin
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "o.id = $2", "ou.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "o.id = $2", "ou.is_admin = true", "ou.role_id is null"},
 		}
 
 		// admin api key
@@ -918,7 +1032,7 @@ func ValidateOrganizationAccess(flag Flag, id int64) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceOrganization, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
@@ -927,7 +1041,7 @@ func ValidateOrganizationAccess(flag Flag, id int64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateOrganizationUsersAccess validates if the client has access to
@@ -939,7 +1053,7 @@ func ValidateOrganizationUsersAccess(flag Flag, id int64) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 	`
@@ -986,7 +1100,7 @@ func ValidateOrganizationUsersAccess(flag Flag, id int64) ValidatorFunc {
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceOrganizationUser, flag, validateRole(resourceOrganizationUser, flag, roleTargetOrganization, id, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
@@ -995,7 +1109,7 @@ func ValidateOrganizationUsersAccess(flag Flag, id int64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateOrganizationUserAccess validates if the client has access to the
@@ -1028,7 +1142,7 @@ func ValidateOrganizationUserAccess(flag Flag, organizationID, userID int64) Val
 		// user itself
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.is_admin = true"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.is_admin = true", "ou.role_id is null"},
 			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.user_id = $3", "ou.user_id = u.id"},
 		}
 
@@ -1043,7 +1157,7 @@ func ValidateOrganizationUserAccess(flag Flag, organizationID, userID int64) Val
 		// organization admin
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true", "$3 = $3"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.is_admin = true"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.is_admin = true", "ou.role_id is null"},
 		}
 
 		// admin api key
@@ -1057,7 +1171,7 @@ func ValidateOrganizationUserAccess(flag Flag, organizationID, userID int64) Val
 		// organization admin
 		userWhere = [][]string{
 			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true", "$3 = $3"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.is_admin = true"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "o.id = $2", "ou.is_admin = true", "ou.role_id is null"},
 		}
 
 		// admin api key
@@ -1070,7 +1184,7 @@ func ValidateOrganizationUserAccess(flag Flag, organizationID, userID int64) Val
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceOrganizationUser, flag, validateRole(resourceOrganizationUser, flag, roleTargetOrganization, organizationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, userID, claims.UserID)
@@ -1079,7 +1193,7 @@ func ValidateOrganizationUserAccess(flag Flag, organizationID, userID int64) Val
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateGatewayProfileAccess validates if the client has access
@@ -1107,9 +1221,14 @@ func ValidateGatewayProfileAccess(flag Flag) ValidatorFunc {
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
-		return executeQuery(db, query, where, claims.Username, claims.UserID)
-	}
+	return validateAPIKeyScope(resourceGatewayProfile, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, query, where, claims.Username, claims.UserID)
+		default:
+			return false, nil
+		}
+	})
 }
 
 // ValidateNetworkServersAccess validates if the client has access to the
@@ -1163,7 +1282,7 @@ func ValidateNetworkServersAccess(flag Flag, organizationID int64) ValidatorFunc
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceNetworkServer, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
@@ -1172,7 +1291,7 @@ func ValidateNetworkServersAccess(flag Flag, organizationID int64) ValidatorFunc
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateNetworkServerAccess validates if the client has access to the
@@ -1184,7 +1303,7 @@ func ValidateNetworkServerAccess(flag Flag, id int64) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join service_profile sp
@@ -1236,7 +1355,7 @@ func ValidateNetworkServerAccess(flag Flag, id int64) ValidatorFunc {
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceNetworkServer, flag, validateRole(resourceNetworkServer, flag, roleTargetNetworkServer, id, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
@@ -1245,7 +1364,7 @@ func ValidateNetworkServerAccess(flag Flag, id int64) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateOrganizationNetworkServerAccess validates if the given client has
@@ -1301,7 +1420,7 @@ func ValidateOrganizationNetworkServerAccess(flag Flag, organizationID, networkS
 		panic("unsupported flag")
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceOrganizationNetworkServer, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, networkServerID, claims.UserID)
@@ -1310,7 +1429,7 @@ func ValidateOrganizationNetworkServerAccess(flag Flag, organizationID, networkS
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateServiceProfilesAccess validates if the client has access to the
@@ -1366,7 +1485,7 @@ func ValidateServiceProfilesAccess(flag Flag, organizationID int64) ValidatorFun
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceServiceProfile, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
@@ -1375,7 +1494,7 @@ func ValidateServiceProfilesAccess(flag Flag, organizationID int64) ValidatorFun
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateServiceProfileAccess validates if the client has access to the
@@ -1431,7 +1550,7 @@ func ValidateServiceProfileAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceServiceProfile, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
@@ -1440,7 +1559,7 @@ func ValidateServiceProfileAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	})
 }
 
 // ValidateDeviceProfilesAccess validates if the client has access to the
@@ -1452,7 +1571,7 @@ func ValidateDeviceProfilesAccess(flag Flag, organizationID, applicationID int64
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join application a
@@ -1510,7 +1629,16 @@ func ValidateDeviceProfilesAccess(flag Flag, organizationID, applicationID int64
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	// roles are validated against the application when given
+	var target string
+	var arg interface{}
+	if applicationID != 0 {
+		target, arg = roleTargetApplication, applicationID
+	} else {
+		target, arg = roleTargetOrganization, organizationID
+	}
+
+	return validateAPIKeyScope(resourceDeviceProfile, flag, validateRole(resourceDeviceProfile, flag, target, arg, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, applicationID, claims.UserID)
@@ -1519,7 +1647,7 @@ func ValidateDeviceProfilesAccess(flag Flag, organizationID, applicationID int64
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateDeviceProfileAccess validates if the client has access to the
@@ -1531,7 +1659,7 @@ func ValidateDeviceProfileAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 		left join application a
@@ -1590,7 +1718,7 @@ func ValidateDeviceProfileAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceDeviceProfile, flag, validateRole(resourceDeviceProfile, flag, roleTargetDeviceProfile, id, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
@@ -1599,7 +1727,7 @@ func ValidateDeviceProfileAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateMulticastGroupsAccess validates if the client has access to the
@@ -1611,7 +1739,7 @@ func ValidateMulticastGroupsAccess(flag Flag, organizationID int64) ValidatorFun
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on o.id = ou.organization_id
 	`
@@ -1660,7 +1788,7 @@ func ValidateMulticastGroupsAccess(flag Flag, organizationID int64) ValidatorFun
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceMulticastGroup, flag, validateRole(resourceMulticastGroup, flag, roleTargetOrganization, organizationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
@@ -1669,7 +1797,7 @@ func ValidateMulticastGroupsAccess(flag Flag, organizationID int64) ValidatorFun
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateMulticastGroupAccess validates if the client has access to the given
@@ -1681,7 +1809,7 @@ func ValidateMulticastGroupAccess(flag Flag, multicastGroupID uuid.UUID) Validat
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join service_profile sp
 			on sp.organization_id = ou.organization_id
 		left join multicast_group mg
@@ -1735,7 +1863,7 @@ func ValidateMulticastGroupAccess(flag Flag, multicastGroupID uuid.UUID) Validat
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceMulticastGroup, flag, validateRole(resourceMulticastGroup, flag, roleTargetMulticastGroup, multicastGroupID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, multicastGroupID, claims.UserID)
@@ -1744,7 +1872,7 @@ func ValidateMulticastGroupAccess(flag Flag, multicastGroupID uuid.UUID) Validat
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateMulticastGroupQueueAccess validates if the client has access to
@@ -1756,7 +1884,7 @@ func ValidateMulticastGroupQueueAccess(flag Flag, multicastGroupID uuid.UUID) Va
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join service_profile sp
 			on sp.organization_id = ou.organization_id
 		left join multicast_group mg
@@ -1796,7 +1924,7 @@ func ValidateMulticastGroupQueueAccess(flag Flag, multicastGroupID uuid.UUID) Va
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceMulticastGroupQueue, flag, validateRole(resourceMulticastGroupQueue, flag, roleTargetMulticastGroup, multicastGroupID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, multicastGroupID, claims.UserID)
@@ -1805,7 +1933,7 @@ func ValidateMulticastGroupQueueAccess(flag Flag, multicastGroupID uuid.UUID) Va
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateFUOTADeploymentAccess validates if the client has access to the
@@ -1817,7 +1945,7 @@ func ValidateFUOTADeploymentAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join application a
 			on a.organization_id = ou.organization_id
 		left join device d
@@ -1855,6 +1983,21 @@ func ValidateFUOTADeploymentAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "fd.id = $2"},
 		}
 
+		// admin api key
+		// org api key
+		// app api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "fd.id = $2"},
+		}
+	case Update:
+		// global admin
+		// organization admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "fd.id = $2"},
+		}
+
 		// admin api key
 		// org api key
 		// app api key
@@ -1864,7 +2007,7 @@ func ValidateFUOTADeploymentAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	return validateAPIKeyScope(resourceFUOTADeployment, flag, validateRole(resourceFUOTADeployment, flag, roleTargetFUOTADeployment, id, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
@@ -1873,7 +2016,7 @@ func ValidateFUOTADeploymentAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
 // ValidateFUOTADeploymentsAccess validates if the client has access to the
@@ -1885,7 +2028,7 @@ func ValidateFUOTADeploymentsAccess(flag Flag, applicationID int64, devEUI loraw
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join application a
 			on a.organization_id = ou.organization_id
 		left join device d
@@ -1926,7 +2069,17 @@ func ValidateFUOTADeploymentsAccess(flag Flag, applicationID int64, devEUI loraw
 		}
 	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+	// roles are validated against the application when given, else
+	// against the device
+	var target string
+	var arg interface{}
+	if applicationID > 0 {
+		target, arg = roleTargetApplication, applicationID
+	} else {
+		target, arg = roleTargetDevice, devEUI[:]
+	}
+
+	return validateAPIKeyScope(resourceFUOTADeployment, flag, validateRole(resourceFUOTADeployment, flag, target, arg, func(db sqlx.Queryer, claims *Claims) (bool, error) {
 		switch claims.Subject {
 		case SubjectUser:
 			return executeQuery(db, userQuery, userWhere, claims.Username, applicationID, devEUI, claims.UserID)
@@ -1935,66 +2088,451 @@ func ValidateFUOTADeploymentsAccess(flag Flag, applicationID int64, devEUI loraw
 		default:
 			return false, nil
 		}
-	}
+	}))
 }
 
-// ValidateAPIKeysAccess validates if the client has access to the global
-// API key resource.
-func ValidateAPIKeysAccess(flag Flag, organizationID int64, applicationID int64) ValidatorFunc {
-	query := `
+// ValidateFirmwareImagesAccess validates if the client has access to the
+// firmware images of the given organization.
+func ValidateFirmwareImagesAccess(flag Flag, organizationID int64) ValidatorFunc {
+	userQuery := `
 		select
 			1
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
-			on ou.organization_id = o.id
-		left join application a
-			on o.id = a.organization_id
+			on o.id = ou.organization_id
 	`
 
-	var where [][]string
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+		left join organization o
+			on ak.organization_id = o.id
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
 
 	switch flag {
 	case Create:
 		// global admin
-		// organization admin of given org id
-		// organization admin of given app id
-		where = [][]string{
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$2 > 0", "$3 = 0", "o.id = $2"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$3 > 0", "$2 = 0", "a.id = $3"},
+		// organization admin
+		// organization device admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "o.id = $2", "ou.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "o.id = $2", "ou.is_device_admin = true"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "o.id = $2"},
 		}
 
 	case List:
 		// global admin
-		// organization admin of given org id (api key filtered by org in api)
-		// organization admin of given app id (api key filtered by app in api)
-		where = [][]string{
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$2 > 0", "$3 = 0", "o.id = $2"},
-			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$3 > 0", "$2 = 0", "a.id = $3"},
+		// organization user
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "o.id = $2"},
 		}
-	default:
-		panic("unsupported flag")
-	}
 
-	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
-		return executeQuery(db, query, where, claims.Username, organizationID, applicationID, claims.UserID)
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "o.id = $2"},
+		}
 	}
-}
 
-// ValidateAPIKeyAccess validates if the client has access to the given API
-// key.
-func ValidateAPIKeyAccess(flag Flag, id uuid.UUID) ValidatorFunc {
-	query := `
-		select
-			1
+	return validateAPIKeyScope(resourceFirmwareImage, flag, validateRole(resourceFirmwareImage, flag, roleTargetOrganization, organizationID, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, organizationID)
+		default:
+			return false, nil
+		}
+	}))
+}
+
+// ValidateFirmwareImageAccess validates if the client has access to the given
+// firmware image.
+func ValidateFirmwareImageAccess(flag Flag, id uuid.UUID) ValidatorFunc {
+	userQuery := `
+		select
+			1
 		from
 			"user" u
 		left join organization_user ou
-			on u.id = ou.user_id
+			on u.id = ou.user_id and ou.role_id is null
+		left join firmware_image fi
+			on fi.organization_id = ou.organization_id
+	`
+
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+		left join firmware_image fi
+			on fi.organization_id = ak.organization_id
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
+
+	switch flag {
+	case Read:
+		// global admin
+		// organization users
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "fi.id = $2"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "fi.id = $2"},
+		}
+	case Update, Delete:
+		// global admin
+		// organization admin users
+		// organization device admin users
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "fi.id = $2"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_device_admin = true", "fi.id = $2"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "fi.id = $2"},
+		}
+	}
+
+	return validateAPIKeyScope(resourceFirmwareImage, flag, validateRole(resourceFirmwareImage, flag, roleTargetFirmwareImage, id, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, id)
+		default:
+			return false, nil
+		}
+	}))
+}
+
+// ValidateRolesAccess validates if the client has access to the roles of
+// the given organization.
+func ValidateRolesAccess(flag Flag, organizationID int64) ValidatorFunc {
+	userQuery := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
+	`
+
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
+
+	switch flag {
+	case Create, List:
+		// global admin
+		// organization admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.organization_id = $2", "ou.is_admin = true"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "ak.organization_id = $2"},
+		}
+	default:
+		panic("unsupported flag")
+	}
+
+	return validateAPIKeyScope(resourceRole, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, organizationID, claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, organizationID)
+		default:
+			return false, nil
+		}
+	})
+}
+
+// ValidateRoleAccess validates if the client has access to the given role.
+func ValidateRoleAccess(flag Flag, id int64) ValidatorFunc {
+	userQuery := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
+		left join role r
+			on r.organization_id = ou.organization_id or r.organization_id is null
+	`
+
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+		left join role r
+			on r.organization_id = ak.organization_id or r.organization_id is null
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
+
+	switch flag {
+	case Read:
+		// global admin
+		// organization admin (including the predefined roles)
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "r.id = $2"},
+		}
+
+		// admin api key
+		// org api key (including the predefined roles)
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "ak.organization_id is not null", "r.id = $2"},
+		}
+	case Update, Delete:
+		// global admin
+		// organization admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "r.organization_id is not null", "r.id = $2"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "r.organization_id is not null", "r.id = $2"},
+		}
+	default:
+		panic("unsupported flag")
+	}
+
+	return validateAPIKeyScope(resourceRole, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, id, claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, id)
+		default:
+			return false, nil
+		}
+	})
+}
+
+// ValidateApplicationUsersAccess validates if the client has access to the
+// users of the given application.
+func ValidateApplicationUsersAccess(flag Flag, applicationID int64) ValidatorFunc {
+	userQuery := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
+		left join application a
+			on a.organization_id = ou.organization_id
+	`
+
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+		left join application a
+			on a.organization_id = ak.organization_id
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
+
+	switch flag {
+	case Create, List:
+		// global admin
+		// organization admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $3)", "u.is_active = true", "ou.is_admin = true", "a.id = $2"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "a.id = $2"},
+		}
+	default:
+		panic("unsupported flag")
+	}
+
+	return validateAPIKeyScope(resourceApplicationUser, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, applicationID, claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, applicationID)
+		default:
+			return false, nil
+		}
+	})
+}
+
+// ValidateApplicationUserAccess validates if the client has access to the
+// given user of the given application.
+func ValidateApplicationUserAccess(flag Flag, applicationID, userID int64) ValidatorFunc {
+	userQuery := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
+		left join application a
+			on a.organization_id = ou.organization_id
+	`
+
+	apiKeyQuery := `
+		select
+			1
+		from
+			api_key ak
+		left join application a
+			on a.organization_id = ak.organization_id
+	`
+
+	var userWhere = [][]string{}
+	var apiKeyWhere = [][]string{}
+
+	switch flag {
+	case Read, Update, Delete:
+		// global admin
+		// organization admin
+		userWhere = [][]string{
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true", "$3 = $3"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "a.id = $2"},
+		}
+
+		// admin api key
+		// org api key
+		apiKeyWhere = [][]string{
+			{"ak.id = $1", "ak.is_admin = true"},
+			{"ak.id = $1", "a.id = $2"},
+		}
+	default:
+		panic("unsupported flag")
+	}
+
+	return validateAPIKeyScope(resourceApplicationUser, flag, func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, userQuery, userWhere, claims.Username, applicationID, userID, claims.UserID)
+		case SubjectAPIKey:
+			return executeQuery(db, apiKeyQuery, apiKeyWhere, claims.APIKeyID, applicationID)
+		default:
+			return false, nil
+		}
+	})
+}
+
+// ValidateAPIKeysAccess validates if the client has access to the global
+// API key resource.
+func ValidateAPIKeysAccess(flag Flag, organizationID int64, applicationID int64) ValidatorFunc {
+	query := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
+		left join organization o
+			on ou.organization_id = o.id
+		left join application a
+			on o.id = a.organization_id
+	`
+
+	var where [][]string
+
+	switch flag {
+	case Create:
+		// global admin
+		// organization admin of given org id
+		// organization admin of given app id
+		where = [][]string{
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$2 > 0", "$3 = 0", "o.id = $2"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$3 > 0", "$2 = 0", "a.id = $3"},
+		}
+
+	case List:
+		// global admin
+		// organization admin of given org id (api key filtered by org in api)
+		// organization admin of given app id (api key filtered by app in api)
+		where = [][]string{
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "u.is_admin = true"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$2 > 0", "$3 = 0", "o.id = $2"},
+			{"(u.email = $1 or u.id = $4)", "u.is_active = true", "ou.is_admin = true", "$3 > 0", "$2 = 0", "a.id = $3"},
+		}
+	default:
+		panic("unsupported flag")
+	}
+
+	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, query, where, claims.Username, organizationID, applicationID, claims.UserID)
+		default:
+			return false, nil
+		}
+	}
+}
+
+// ValidateAPIKeyAccess validates if the client has access to the given API
+// key.
+func ValidateAPIKeyAccess(flag Flag, id uuid.UUID) ValidatorFunc {
+	query := `
+		select
+			1
+		from
+			"user" u
+		left join organization_user ou
+			on u.id = ou.user_id and ou.role_id is null
 		left join organization o
 			on ou.organization_id = o.id
 		left join application a
@@ -2005,7 +2543,7 @@ func ValidateAPIKeyAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 
 	var where [][]string
 	switch flag {
-	case Delete:
+	case Read, Update, Delete:
 		// global admin
 		// organization admin
 		where = [][]string{
@@ -2017,7 +2555,12 @@ func ValidateAPIKeyAccess(flag Flag, id uuid.UUID) ValidatorFunc {
 	}
 
 	return func(db sqlx.Queryer, claims *Claims) (bool, error) {
-		return executeQuery(db, query, where, claims.Username, id, claims.UserID)
+		switch claims.Subject {
+		case SubjectUser:
+			return executeQuery(db, query, where, claims.Username, id, claims.UserID)
+		default:
+			return false, nil
+		}
 	}
 }
 
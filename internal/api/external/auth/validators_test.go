@@ -5,11 +5,16 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/oidc"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
@@ -2278,6 +2283,188 @@ func (ts *ValidatorTestSuite) TestMulticastGroup() {
 	})
 }
 
+func (ts *ValidatorTestSuite) TestFirmwareImage() {
+	assert := require.New(ts.T())
+
+	users := []struct {
+		id       int64
+		username string
+		isActive bool
+		isAdmin  bool
+	}{
+		{username: "activeAdmin", isActive: true, isAdmin: true},
+		{username: "activeUser", isActive: true, isAdmin: false},
+	}
+	for i, user := range users {
+		id, err := ts.CreateUser(user.username, user.isActive, user.isAdmin)
+		assert.NoError(err)
+		users[i].id = id
+	}
+
+	orgUsers := []struct {
+		organizationID int64
+		username       string
+		isAdmin        bool
+		isDeviceAdmin  bool
+		isGatewayAdmin bool
+		id             int64
+	}{
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUser", isAdmin: false, isDeviceAdmin: false, isGatewayAdmin: false},
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUserAdmin", isAdmin: true, isDeviceAdmin: false, isGatewayAdmin: false},
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUserDeviceAdmin", isAdmin: false, isDeviceAdmin: true, isGatewayAdmin: false},
+		{organizationID: ts.organizations[1].ID, username: "org1ActiveUserAdmin", isAdmin: true, isDeviceAdmin: false, isGatewayAdmin: false},
+	}
+
+	for i, orgUser := range orgUsers {
+		id, err := ts.CreateUser(orgUser.username, true, false)
+		assert.NoError(err)
+		orgUsers[i].id = id
+
+		err = storage.CreateOrganizationUser(context.Background(), storage.DB(), orgUser.organizationID, id, orgUser.isAdmin, orgUser.isDeviceAdmin, orgUser.isGatewayAdmin)
+		assert.NoError(err)
+	}
+
+	apiKeys := []storage.APIKey{
+		{Name: "admin", IsAdmin: true},
+		{Name: "org", OrganizationID: &ts.organizations[0].ID},
+		{Name: "other-org", OrganizationID: &ts.organizations[1].ID},
+	}
+	for i := range apiKeys {
+		_, err := storage.CreateAPIKey(context.Background(), storage.DB(), &apiKeys[i])
+		assert.NoError(err)
+	}
+
+	fi := storage.FirmwareImage{
+		OrganizationID: ts.organizations[0].ID,
+		Name:           "test-image",
+		Version:        "1.0.0",
+		Payload:        []byte{1, 2, 3, 4},
+	}
+	assert.NoError(storage.CreateFirmwareImage(context.Background(), storage.DB(), &fi))
+
+	ts.T().Run("FirmwareImagesAccess", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "global admin users can create and list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization admin users can create and list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization device admin users can create and list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can not create",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "non-organization users can not create or list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: users[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "admin api key can create and list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{APIKeyID: apiKeys[0].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "org api key can create and list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "other org api key can not create or list",
+				Validators: []ValidatorFunc{ValidateFirmwareImagesAccess(Create, ts.organizations[0].ID), ValidateFirmwareImagesAccess(List, ts.organizations[0].ID)},
+				Claims:     Claims{APIKeyID: apiKeys[2].ID},
+				ExpectedOK: false,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+
+	ts.T().Run("FirmwareImageAccess", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "global admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization device admin users can read, update and delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can read",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization users can not update or delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "other organization admin users can not read, update or delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{UserID: orgUsers[3].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "admin api key can read, update and delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{APIKeyID: apiKeys[0].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "org api key can read, update and delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "other org api key can not read, update or delete",
+				Validators: []ValidatorFunc{ValidateFirmwareImageAccess(Read, fi.ID), ValidateFirmwareImageAccess(Update, fi.ID), ValidateFirmwareImageAccess(Delete, fi.ID)},
+				Claims:     Claims{APIKeyID: apiKeys[2].ID},
+				ExpectedOK: false,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+}
+
 func (ts *ValidatorTestSuite) TestFUOTA() {
 	assert := require.New(ts.T())
 
@@ -2428,6 +2615,36 @@ func (ts *ValidatorTestSuite) TestFUOTA() {
 				Claims:     Claims{APIKeyID: apiKeys[3].ID},
 				ExpectedOK: false,
 			},
+			{
+				Name:       "global admin user can update",
+				Validators: []ValidatorFunc{ValidateFUOTADeploymentAccess(Update, fuotaDeployments[0].ID)},
+				Claims:     Claims{UserID: users[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization admin can update",
+				Validators: []ValidatorFunc{ValidateFUOTADeploymentAccess(Update, fuotaDeployments[0].ID)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization user can not update",
+				Validators: []ValidatorFunc{ValidateFUOTADeploymentAccess(Update, fuotaDeployments[0].ID)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "org api key can update",
+				Validators: []ValidatorFunc{ValidateFUOTADeploymentAccess(Update, fuotaDeployments[0].ID)},
+				Claims:     Claims{APIKeyID: apiKeys[1].ID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "other api key can not update",
+				Validators: []ValidatorFunc{ValidateFUOTADeploymentAccess(Update, fuotaDeployments[0].ID)},
+				Claims:     Claims{APIKeyID: apiKeys[3].ID},
+				ExpectedOK: false,
+			},
 		}
 
 		ts.RunTests(t, tests)
@@ -2717,6 +2934,637 @@ func (ts *ValidatorTestSuite) TestAPIKeys() {
 	})
 }
 
+func (ts *ValidatorTestSuite) TestAPIKeyValidation() {
+	assert := require.New(ts.T())
+
+	v := NewJWTValidator(storage.DB(), "HS256", test.GetConfig().ApplicationServer.ExternalAPI.JWTSecret)
+
+	expired := time.Now().Add(-time.Minute)
+	apiKeys := []storage.APIKey{
+		{Name: "admin", IsAdmin: true},
+		{Name: "expired", IsAdmin: true, ExpiresAt: &expired},
+		{Name: "read-only", IsAdmin: true, Scopes: []string{string(ScopeRead)}},
+		{Name: "enqueue-only", IsAdmin: true, Scopes: []string{string(ScopeDeviceQueueEnqueue)}},
+		{Name: "gateway-read", IsAdmin: true, Scopes: []string{string(ScopeGatewayRead)}},
+	}
+	tokens := make([]string, len(apiKeys))
+	for i := range apiKeys {
+		var err error
+		tokens[i], err = storage.CreateAPIKey(context.Background(), storage.DB(), &apiKeys[i])
+		assert.NoError(err)
+	}
+
+	// rotate the admin key, the old token is valid within the grace period
+	rotated, err := storage.RotateAPIKey(context.Background(), storage.DB(), &apiKeys[0], time.Hour)
+	assert.NoError(err)
+
+	// rotate the read-only key without grace period
+	_, err = storage.RotateAPIKey(context.Background(), storage.DB(), &apiKeys[2], 0)
+	assert.NoError(err)
+	readOnly, err := storage.RotateAPIKey(context.Background(), storage.DB(), &apiKeys[2], 0)
+	assert.NoError(err)
+
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	gatewayID := lorawan.EUI64{8, 7, 6, 5, 4, 3, 2, 1}
+
+	tests := []struct {
+		Name       string
+		Token      string
+		Validators []ValidatorFunc
+		Error      error
+	}{
+		{
+			Name:       "current token",
+			Token:      rotated,
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Update)},
+		},
+		{
+			Name:       "previous token within grace period",
+			Token:      tokens[0],
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Update)},
+		},
+		{
+			Name:       "previous token after grace period",
+			Token:      tokens[2],
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Read)},
+			Error:      ErrAPIKeyTokenRotated,
+		},
+		{
+			Name:       "expired api key",
+			Token:      tokens[1],
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Read)},
+			Error:      ErrAPIKeyExpired,
+		},
+		{
+			Name:       "read scope allows read",
+			Token:      readOnly,
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Read)},
+		},
+		{
+			Name:       "read scope does not allow update",
+			Token:      readOnly,
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Update)},
+			Error:      ErrNotAuthorized,
+		},
+		{
+			Name:       "device-queue-enqueue scope allows enqueue",
+			Token:      tokens[3],
+			Validators: []ValidatorFunc{ValidateDeviceQueueAccess(devEUI, Create)},
+		},
+		{
+			Name:       "device-queue-enqueue scope does not allow reading the queue",
+			Token:      tokens[3],
+			Validators: []ValidatorFunc{ValidateDeviceQueueAccess(devEUI, List)},
+			Error:      ErrNotAuthorized,
+		},
+		{
+			Name:       "gateway-read scope allows reading gateways",
+			Token:      tokens[4],
+			Validators: []ValidatorFunc{ValidateGatewayAccess(Read, gatewayID)},
+		},
+		{
+			Name:       "gateway-read scope does not allow reading devices",
+			Token:      tokens[4],
+			Validators: []ValidatorFunc{ValidateNodeAccess(devEUI, Read)},
+			Error:      ErrNotAuthorized,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{
+				"authorization": []string{"Bearer " + tst.Token},
+			})
+			assert.Equal(tst.Error, errors.Cause(v.Validate(ctx, tst.Validators...)))
+		})
+	}
+
+	ak, err := storage.GetAPIKey(context.Background(), storage.DB(), apiKeys[0].ID)
+	assert.NoError(err)
+	assert.NotNil(ak.LastUsedAt)
+}
+
+func (ts *ValidatorTestSuite) TestRole() {
+	assert := require.New(ts.T())
+
+	adminID, err := ts.CreateUser("activeAdmin", true, true)
+	assert.NoError(err)
+
+	orgUsers := []struct {
+		id             int64
+		organizationID int64
+		username       string
+		isAdmin        bool
+	}{
+		{organizationID: ts.organizations[0].ID, username: "org0ActiveUserAdmin", isAdmin: true},
+		{organizationID: ts.organizations[0].ID, username: "org0FieldTechnician", isAdmin: true},
+		{organizationID: ts.organizations[0].ID, username: "org0ApplicationOperator"},
+		{organizationID: ts.organizations[1].ID, username: "org1FieldTechnician"},
+	}
+
+	for i, orgUser := range orgUsers {
+		id, err := ts.CreateUser(orgUser.username, true, false)
+		assert.NoError(err)
+		orgUsers[i].id = id
+
+		err = storage.CreateOrganizationUser(context.Background(), storage.DB(), orgUser.organizationID, id, orgUser.isAdmin, false, false)
+		assert.NoError(err)
+	}
+
+	roles := []storage.Role{
+		{OrganizationID: &ts.organizations[0].ID, Name: "field technician", Permissions: []string{"gateway:read", "gateway:list", "device:read", "device-queue:create"}},
+		{OrganizationID: &ts.organizations[0].ID, Name: "operator", Permissions: []string{"application:read", "device:read", "device:update", "device-keys:read"}},
+		{OrganizationID: &ts.organizations[1].ID, Name: "field technician", Permissions: []string{"gateway:read", "gateway:list", "device:read", "device-queue:create"}},
+	}
+	for i := range roles {
+		assert.NoError(storage.CreateRole(context.Background(), storage.DB(), &roles[i]))
+	}
+
+	// the admin flag is replaced by the role
+	assert.NoError(storage.UpdateOrganizationUserRole(context.Background(), storage.DB(), ts.organizations[0].ID, orgUsers[1].id, &roles[0].ID))
+	assert.NoError(storage.UpdateOrganizationUserRole(context.Background(), storage.DB(), ts.organizations[1].ID, orgUsers[3].id, &roles[2].ID))
+
+	var serviceProfileIDs []uuid.UUID
+	serviceProfiles := []storage.ServiceProfile{
+		{Name: "test-sp-1", NetworkServerID: ts.networkServers[0].ID, OrganizationID: ts.organizations[0].ID},
+	}
+	for i := range serviceProfiles {
+		assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &serviceProfiles[i]))
+		id, _ := uuid.FromBytes(serviceProfiles[i].ServiceProfile.Id)
+		serviceProfileIDs = append(serviceProfileIDs, id)
+	}
+
+	applications := []storage.Application{
+		{OrganizationID: ts.organizations[0].ID, Name: "application-1", ServiceProfileID: serviceProfileIDs[0]},
+		{OrganizationID: ts.organizations[0].ID, Name: "application-2", ServiceProfileID: serviceProfileIDs[0]},
+	}
+	for i := range applications {
+		assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &applications[i]))
+	}
+
+	assert.NoError(storage.CreateApplicationUser(context.Background(), storage.DB(), &storage.ApplicationUser{
+		ApplicationID: applications[0].ID,
+		UserID:        orgUsers[2].id,
+		RoleID:        roles[1].ID,
+	}))
+
+	deviceProfiles := []storage.DeviceProfile{
+		{Name: "test-dp-1", OrganizationID: ts.organizations[0].ID, NetworkServerID: ts.networkServers[0].ID},
+	}
+	var deviceProfilesIDs []uuid.UUID
+	for i := range deviceProfiles {
+		assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &deviceProfiles[i]))
+		dpID, _ := uuid.FromBytes(deviceProfiles[i].DeviceProfile.Id)
+		deviceProfilesIDs = append(deviceProfilesIDs, dpID)
+	}
+
+	devices := []storage.Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "test-1", ApplicationID: applications[0].ID, DeviceProfileID: deviceProfilesIDs[0]},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}, Name: "test-2", ApplicationID: applications[1].ID, DeviceProfileID: deviceProfilesIDs[0]},
+	}
+	for i := range devices {
+		assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &devices[i]))
+	}
+
+	gateways := []storage.Gateway{
+		{MAC: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "gateway1", OrganizationID: ts.organizations[0].ID, NetworkServerID: ts.networkServers[0].ID},
+	}
+	for i := range gateways {
+		assert.NoError(storage.CreateGateway(context.Background(), storage.DB(), &gateways[i]))
+	}
+
+	ts.T().Run("Organization role", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "field technician can view gateways",
+				Validators: []ValidatorFunc{ValidateGatewaysAccess(List, ts.organizations[0].ID), ValidateGatewayAccess(Read, gateways[0].MAC)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "field technician can enqueue downlinks",
+				Validators: []ValidatorFunc{ValidateDeviceQueueAccess(devices[0].DevEUI, Create), ValidateDeviceQueueAccess(devices[1].DevEUI, Create)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "field technician can not see keys",
+				Validators: []ValidatorFunc{ValidateDeviceKeysAccess(devices[0].DevEUI, Read)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "field technician can not update gateways or flush the device-queue",
+				Validators: []ValidatorFunc{ValidateGatewayAccess(Update, gateways[0].MAC), ValidateDeviceQueueAccess(devices[0].DevEUI, Delete)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "role replaces the organization admin flag",
+				Validators: []ValidatorFunc{ValidateIsOrganizationAdmin(ts.organizations[0].ID), ValidateApplicationAccess(applications[0].ID, Update), ValidateOrganizationAccess(Update, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "field technician is still an organization member",
+				Validators: []ValidatorFunc{ValidateOrganizationAccess(Read, ts.organizations[0].ID)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "role of other organization does not grant access",
+				Validators: []ValidatorFunc{ValidateGatewayAccess(Read, gateways[0].MAC), ValidateDeviceQueueAccess(devices[0].DevEUI, Create)},
+				Claims:     Claims{UserID: orgUsers[3].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "organization admin without role can see keys",
+				Validators: []ValidatorFunc{ValidateDeviceKeysAccess(devices[0].DevEUI, Read), ValidateDeviceKeysAccess(devices[0].DevEUI, Update)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: true,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+
+	ts.T().Run("Application role", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "operator can read and update devices of the application",
+				Validators: []ValidatorFunc{ValidateApplicationAccess(applications[0].ID, Read), ValidateNodeAccess(devices[0].DevEUI, Update), ValidateDeviceKeysAccess(devices[0].DevEUI, Read)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "operator can not delete devices or update keys",
+				Validators: []ValidatorFunc{ValidateNodeAccess(devices[0].DevEUI, Delete), ValidateDeviceKeysAccess(devices[0].DevEUI, Update)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "operator can not update devices of other applications",
+				Validators: []ValidatorFunc{ValidateNodeAccess(devices[1].DevEUI, Update)},
+				Claims:     Claims{UserID: orgUsers[2].id},
+				ExpectedOK: false,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+
+	ts.T().Run("RolesAccess", func(t *testing.T) {
+		tests := []validatorTest{
+			{
+				Name:       "global admin and organization admin can manage roles",
+				Validators: []ValidatorFunc{ValidateRolesAccess(Create, ts.organizations[0].ID), ValidateRolesAccess(List, ts.organizations[0].ID), ValidateRoleAccess(Update, roles[0].ID), ValidateApplicationUsersAccess(Create, applications[0].ID), ValidateApplicationUserAccess(Delete, applications[0].ID, orgUsers[2].id)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "global admin can manage roles of other organizations",
+				Validators: []ValidatorFunc{ValidateRolesAccess(List, ts.organizations[1].ID), ValidateRoleAccess(Delete, roles[2].ID)},
+				Claims:     Claims{UserID: adminID},
+				ExpectedOK: true,
+			},
+			{
+				Name:       "organization admin can not manage roles of other organizations",
+				Validators: []ValidatorFunc{ValidateRolesAccess(List, ts.organizations[1].ID), ValidateRoleAccess(Read, roles[2].ID)},
+				Claims:     Claims{UserID: orgUsers[0].id},
+				ExpectedOK: false,
+			},
+			{
+				Name:       "users with a role can not manage roles",
+				Validators: []ValidatorFunc{ValidateRolesAccess(Create, ts.organizations[0].ID), ValidateRoleAccess(Update, roles[0].ID), ValidateApplicationUsersAccess(Create, applications[0].ID)},
+				Claims:     Claims{UserID: orgUsers[1].id},
+				ExpectedOK: false,
+			},
+		}
+
+		ts.RunTests(t, tests)
+	})
+}
+
+func (ts *ValidatorTestSuite) TestApplicationUser() {
+	assert := require.New(ts.T())
+
+	orgUsers := []struct {
+		id            int64
+		username      string
+		isAdmin       bool
+		isDeviceAdmin bool
+		role          string
+	}{
+		{username: "org0ActiveUser"},
+		{username: "org0ActiveUserAdmin", isAdmin: true, role: storage.RoleViewer},
+		{username: "org0Viewer", role: storage.RoleViewer},
+		{username: "org0Operator", role: storage.RoleOperator},
+		{username: "org0Admin", role: storage.RoleAdmin},
+		{username: "org0DeviceAdminViewer", isDeviceAdmin: true, role: storage.RoleViewer},
+		{username: "org0TechnicianViewer", role: storage.RoleViewer},
+	}
+
+	for i, orgUser := range orgUsers {
+		id, err := ts.CreateUser(orgUser.username, true, false)
+		assert.NoError(err)
+		orgUsers[i].id = id
+
+		err = storage.CreateOrganizationUser(context.Background(), storage.DB(), ts.organizations[0].ID, id, orgUser.isAdmin, orgUser.isDeviceAdmin, false)
+		assert.NoError(err)
+	}
+
+	sp := storage.ServiceProfile{Name: "test-sp-1", NetworkServerID: ts.networkServers[0].ID, OrganizationID: ts.organizations[0].ID}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), storage.DB(), &sp))
+	spID, _ := uuid.FromBytes(sp.ServiceProfile.Id)
+
+	applications := []storage.Application{
+		{OrganizationID: ts.organizations[0].ID, Name: "application-1", ServiceProfileID: spID},
+		{OrganizationID: ts.organizations[0].ID, Name: "application-2", ServiceProfileID: spID},
+	}
+	for i := range applications {
+		assert.NoError(storage.CreateApplication(context.Background(), storage.DB(), &applications[i]))
+	}
+
+	for _, orgUser := range orgUsers {
+		if orgUser.role == "" {
+			continue
+		}
+
+		r, err := storage.GetPredefinedRole(context.Background(), storage.DB(), orgUser.role)
+		assert.NoError(err)
+
+		assert.NoError(storage.CreateApplicationUser(context.Background(), storage.DB(), &storage.ApplicationUser{
+			ApplicationID: applications[0].ID,
+			UserID:        orgUser.id,
+			RoleID:        r.ID,
+		}))
+	}
+
+	// the application role replaces the organization role for the applications
+	technician := storage.Role{OrganizationID: &ts.organizations[0].ID, Name: "technician", Permissions: []string{"device:read", "device:update"}}
+	assert.NoError(storage.CreateRole(context.Background(), storage.DB(), &technician))
+	assert.NoError(storage.UpdateOrganizationUserRole(context.Background(), storage.DB(), ts.organizations[0].ID, orgUsers[6].id, &technician.ID))
+
+	viewer, err := storage.GetPredefinedRole(context.Background(), storage.DB(), storage.RoleViewer)
+	assert.NoError(err)
+
+	dp := storage.DeviceProfile{Name: "test-dp-1", OrganizationID: ts.organizations[0].ID, NetworkServerID: ts.networkServers[0].ID}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), storage.DB(), &dp))
+	dpID, _ := uuid.FromBytes(dp.DeviceProfile.Id)
+
+	devices := []storage.Device{
+		{DevEUI: lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1}, Name: "test-1", ApplicationID: applications[0].ID, DeviceProfileID: dpID},
+		{DevEUI: lorawan.EUI64{2, 2, 2, 2, 2, 2, 2, 2}, Name: "test-2", ApplicationID: applications[1].ID, DeviceProfileID: dpID},
+	}
+	for i := range devices {
+		assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &devices[i]))
+	}
+
+	tests := []validatorTest{
+		{
+			Name:       "organization user without application role has access to all applications",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[1].ID, Read), ValidateNodesAccess(applications[1].ID, List), ValidateNodeAccess(devices[1].DevEUI, Read), ValidateDeviceQueueAccess(devices[1].DevEUI, Create)},
+			Claims:     Claims{UserID: orgUsers[0].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "organization admin is not restricted by the application role",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[1].ID, Update), ValidateNodeAccess(devices[1].DevEUI, Delete)},
+			Claims:     Claims{UserID: orgUsers[1].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "viewer can read the application and its devices",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[0].ID, Read), ValidateNodesAccess(applications[0].ID, List), ValidateNodeAccess(devices[0].DevEUI, Read), ValidateDeviceQueueAccess(devices[0].DevEUI, List)},
+			Claims:     Claims{UserID: orgUsers[2].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "viewer can not update devices or enqueue downlinks",
+			Validators: []ValidatorFunc{ValidateNodeAccess(devices[0].DevEUI, Update), ValidateNodesAccess(applications[0].ID, Create), ValidateDeviceQueueAccess(devices[0].DevEUI, Create)},
+			Claims:     Claims{UserID: orgUsers[2].id},
+			ExpectedOK: false,
+		},
+		{
+			Name:       "application users can not access other applications",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[1].ID, Read), ValidateNodesAccess(applications[1].ID, List), ValidateNodeAccess(devices[1].DevEUI, Read), ValidateDeviceQueueAccess(devices[1].DevEUI, List)},
+			Claims:     Claims{UserID: orgUsers[2].id},
+			ExpectedOK: false,
+		},
+		{
+			Name:       "operator can manage devices and the device-queue",
+			Validators: []ValidatorFunc{ValidateNodesAccess(applications[0].ID, Create), ValidateNodeAccess(devices[0].DevEUI, Update), ValidateNodeAccess(devices[0].DevEUI, Delete), ValidateDeviceQueueAccess(devices[0].DevEUI, Create), ValidateDeviceQueueAccess(devices[0].DevEUI, Delete)},
+			Claims:     Claims{UserID: orgUsers[3].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "operator can not update the application",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[0].ID, Update)},
+			Claims:     Claims{UserID: orgUsers[3].id},
+			ExpectedOK: false,
+		},
+		{
+			Name:       "admin can update the application and device-keys",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[0].ID, Update), ValidateDeviceKeysAccess(devices[0].DevEUI, Update)},
+			Claims:     Claims{UserID: orgUsers[4].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "admin can not delete the application or access other applications",
+			Validators: []ValidatorFunc{ValidateApplicationAccess(applications[0].ID, Delete), ValidateNodeAccess(devices[1].DevEUI, Update)},
+			Claims:     Claims{UserID: orgUsers[4].id},
+			ExpectedOK: false,
+		},
+		{
+			Name:       "organization device admin flag is restricted to the application role",
+			Validators: []ValidatorFunc{ValidateNodeAccess(devices[0].DevEUI, Update), ValidateNodeAccess(devices[1].DevEUI, Update)},
+			Claims:     Claims{UserID: orgUsers[5].id},
+			ExpectedOK: false,
+		},
+		{
+			Name:       "operator can read the device-keys",
+			Validators: []ValidatorFunc{ValidateDeviceKeysAccess(devices[0].DevEUI, Read)},
+			Claims:     Claims{UserID: orgUsers[3].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "application role grants access to the application of an user with an organization role",
+			Validators: []ValidatorFunc{ValidateNodeAccess(devices[0].DevEUI, Read)},
+			Claims:     Claims{UserID: orgUsers[6].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "organization role no longer applies to the applications",
+			Validators: []ValidatorFunc{ValidateNodeAccess(devices[0].DevEUI, Update), ValidateNodeAccess(devices[1].DevEUI, Read), ValidateNodeAccess(devices[1].DevEUI, Update)},
+			Claims:     Claims{UserID: orgUsers[6].id},
+			ExpectedOK: false,
+		},
+		{
+			Name:       "organization admin can read the predefined roles",
+			Validators: []ValidatorFunc{ValidateRoleAccess(Read, viewer.ID)},
+			Claims:     Claims{UserID: orgUsers[1].id},
+			ExpectedOK: true,
+		},
+		{
+			Name:       "organization admin can not update or delete the predefined roles",
+			Validators: []ValidatorFunc{ValidateRoleAccess(Update, viewer.ID), ValidateRoleAccess(Delete, viewer.ID)},
+			Claims:     Claims{UserID: orgUsers[1].id},
+			ExpectedOK: false,
+		},
+	}
+
+	ts.RunTests(ts.T(), tests)
+}
+
+func (ts *ValidatorTestSuite) TestAccessTokenGroups() {
+	assert := require.New(ts.T())
+
+	conf := test.GetConfig()
+	conf.ApplicationServer.UserAuthentication.OpenIDConnect.Enabled = true
+	conf.ApplicationServer.UserAuthentication.OpenIDConnect.AcceptAccessTokens = true
+	conf.ApplicationServer.UserAuthentication.OpenIDConnect.GroupsClaim = "groups"
+	assert.NoError(oidc.Setup(conf, mux.NewRouter()))
+	defer func() {
+		conf.ApplicationServer.UserAuthentication.OpenIDConnect.GroupsClaim = ""
+		assert.NoError(oidc.Setup(conf, mux.NewRouter()))
+		oidc.MockVerifyAccessTokenUser = nil
+		oidc.MockVerifyAccessTokenError = nil
+	}()
+
+	externalID := "oidc-user"
+	user := storage.User{
+		IsActive:   true,
+		IsAdmin:    true,
+		Email:      "oidc-user@example.com",
+		ExternalID: &externalID,
+	}
+	assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &user))
+
+	oidc.MockVerifyAccessTokenUser = &oidc.User{
+		ExternalID: externalID,
+		Email:      user.Email,
+		Groups:     []string{"users"},
+	}
+
+	v := NewJWTValidator(storage.DB(), "HS256", conf.ApplicationServer.ExternalAPI.JWTSecret)
+
+	ts.T().Run("Access token rejected without group sync", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := v.getAccessTokenClaims(context.Background(), "token")
+		assert.Equal(ErrNotAuthorized, err)
+	})
+
+	ts.T().Run("Groups are re-evaluated", func(t *testing.T) {
+		assert := require.New(t)
+
+		var groups []string
+		v.SetGroupSync(func(ctx context.Context, db sqlx.Ext, u *storage.User, g []string) error {
+			groups = g
+			u.IsAdmin = false
+			return nil
+		})
+
+		claims, err := v.getAccessTokenClaims(context.Background(), "token")
+		assert.NoError(err)
+		assert.Equal(user.ID, claims.UserID)
+		assert.Equal([]string{"users"}, groups)
+
+		u, err := storage.GetUser(context.Background(), storage.DB(), user.ID)
+		assert.NoError(err)
+		assert.False(u.IsAdmin)
+	})
+
+	ts.T().Run("Access token without groups claim", func(t *testing.T) {
+		assert := require.New(t)
+
+		oidc.MockVerifyAccessTokenError = oidc.ErrNoGroupsClaim
+		defer func() { oidc.MockVerifyAccessTokenError = nil }()
+
+		_, err := v.getAccessTokenClaims(context.Background(), "token")
+		assert.Equal(oidc.ErrNoGroupsClaim, errors.Cause(err))
+	})
+}
+
+func (ts *ValidatorTestSuite) TestTOTPEnrollment() {
+	assert := require.New(ts.T())
+
+	// global admin which has not yet enrolled for two-factor authentication
+	userID, err := ts.CreateUser("activeAdmin", true, true)
+	assert.NoError(err)
+	assert.NoError(storage.CreateOrganizationUser(context.Background(), storage.DB(), ts.organizations[0].ID, userID, true, false, false))
+
+	claims := Claims{
+		UserID:   userID,
+		Username: "activeAdmin@example.com",
+	}
+	claims.Subject = SubjectTOTPEnrollment
+
+	ok, err := ValidateTOTPEnrollment()(storage.DB(), &claims)
+	assert.NoError(err)
+	assert.True(ok)
+
+	orgID := ts.organizations[0].ID
+	nsID := ts.networkServers[0].ID
+	devEUI := lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8}
+	id := uuid.Must(uuid.NewV4())
+
+	validators := map[string]ValidatorFunc{
+		"ActiveUser":                      ValidateActiveUser(),
+		"UsersAccess":                     ValidateUsersAccess(Create),
+		"UserAccess":                      ValidateUserAccess(userID, Update),
+		"ApplicationsAccess":              ValidateApplicationsAccess(Create, orgID),
+		"ApplicationAccess":               ValidateApplicationAccess(1, Delete),
+		"NodesAccess":                     ValidateNodesAccess(1, Create),
+		"NodeAccess":                      ValidateNodeAccess(devEUI, Delete),
+		"DeviceQueueAccess":               ValidateDeviceQueueAccess(devEUI, Create),
+		"DeviceKeysAccess":                ValidateDeviceKeysAccess(devEUI, Update),
+		"GatewaysAccess":                  ValidateGatewaysAccess(Create, orgID),
+		"GatewayAccess":                   ValidateGatewayAccess(Delete, devEUI),
+		"IsOrganizationAdmin":             ValidateIsOrganizationAdmin(orgID),
+		"OrganizationsAccess":             ValidateOrganizationsAccess(Create),
+		"OrganizationAccess":              ValidateOrganizationAccess(Update, orgID),
+		"OrganizationUsersAccess":         ValidateOrganizationUsersAccess(Create, orgID),
+		"OrganizationUserAccess":          ValidateOrganizationUserAccess(Update, orgID, userID),
+		"GatewayProfileAccess Create":     ValidateGatewayProfileAccess(Create),
+		"GatewayProfileAccess Update":     ValidateGatewayProfileAccess(Update),
+		"GatewayProfileAccess Delete":     ValidateGatewayProfileAccess(Delete),
+		"GatewayProfileAccess Read":       ValidateGatewayProfileAccess(Read),
+		"NetworkServersAccess":            ValidateNetworkServersAccess(Create, orgID),
+		"NetworkServerAccess":             ValidateNetworkServerAccess(Update, nsID),
+		"OrganizationNetworkServerAccess": ValidateOrganizationNetworkServerAccess(Read, orgID, nsID),
+		"ServiceProfilesAccess":           ValidateServiceProfilesAccess(Create, orgID),
+		"ServiceProfileAccess":            ValidateServiceProfileAccess(Update, id),
+		"DeviceProfilesAccess":            ValidateDeviceProfilesAccess(Create, orgID, 0),
+		"DeviceProfileAccess":             ValidateDeviceProfileAccess(Update, id),
+		"MulticastGroupsAccess":           ValidateMulticastGroupsAccess(Create, orgID),
+		"MulticastGroupAccess":            ValidateMulticastGroupAccess(Update, id),
+		"MulticastGroupQueueAccess":       ValidateMulticastGroupQueueAccess(Create, id),
+		"FUOTADeploymentAccess":           ValidateFUOTADeploymentAccess(Update, id),
+		"FUOTADeploymentsAccess":          ValidateFUOTADeploymentsAccess(Create, 1, lorawan.EUI64{}),
+		"FirmwareImagesAccess":            ValidateFirmwareImagesAccess(Create, orgID),
+		"FirmwareImageAccess":             ValidateFirmwareImageAccess(Update, id),
+		"RolesAccess":                     ValidateRolesAccess(Create, orgID),
+		"RoleAccess":                      ValidateRoleAccess(Update, 1),
+		"ApplicationUsersAccess":          ValidateApplicationUsersAccess(Create, 1),
+		"ApplicationUserAccess":           ValidateApplicationUserAccess(Update, 1, userID),
+		"APIKeysAccess":                   ValidateAPIKeysAccess(Create, orgID, 0),
+		"APIKeyAccess":                    ValidateAPIKeyAccess(Delete, id),
+	}
+
+	for name, v := range validators {
+		ts.T().Run(name, func(t *testing.T) {
+			assert := require.New(t)
+
+			ok, err := v(storage.DB(), &claims)
+			assert.NoError(err)
+			assert.False(ok)
+		})
+	}
+}
+
 func TestValidators(t *testing.T) {
 	suite.Run(t, new(ValidatorTestSuite))
 }
@@ -0,0 +1,250 @@
+package external
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/clocksync"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// DeviceClockSyncRequest defines the request for getting the clock
+// synchronization state of a device.
+type DeviceClockSyncRequest struct {
+	// Device EUI (HEX encoded).
+	DevEUI string `json:"devEUI"`
+}
+
+// DeviceClockSync defines the clock synchronization state of a device.
+type DeviceClockSync struct {
+	// Last observed clock drift in seconds (network time - device time).
+	ClockDrift int `json:"clockDrift"`
+
+	// Timestamp of the last observed clock drift.
+	ClockDriftAt *time.Time `json:"clockDriftAt"`
+
+	// Last requested periodicity (0 - 15).
+	// The device sends an AppTimeReq every 128 * 2^Periodicity seconds.
+	Periodicity *int `json:"periodicity"`
+
+	// Timestamp of the last DeviceAppTimePeriodicityReq.
+	PeriodicityRequestedAt *time.Time `json:"periodicityRequestedAt"`
+
+	// Timestamp of the last DeviceAppTimePeriodicityAns.
+	PeriodicityAnsweredAt *time.Time `json:"periodicityAnsweredAt"`
+
+	// The device answered that the requested periodicity is not supported.
+	PeriodicityNotSupported bool `json:"periodicityNotSupported"`
+
+	// Number of transmissions of the last ForceDeviceResyncReq.
+	ResyncNbTransmissions int `json:"resyncNbTransmissions"`
+
+	// Timestamp of the last ForceDeviceResyncReq.
+	ResyncRequestedAt *time.Time `json:"resyncRequestedAt"`
+}
+
+// GetDeviceClockSyncResponse defines the clock synchronization state
+// response.
+type GetDeviceClockSyncResponse struct {
+	ClockSync DeviceClockSync `json:"clockSync"`
+}
+
+// DeviceClockSyncPeriodicityRequest defines the request for sending a
+// DeviceAppTimePeriodicityReq to a device.
+type DeviceClockSyncPeriodicityRequest struct {
+	// Device EUI (HEX encoded).
+	DevEUI string `json:"devEUI"`
+
+	// Periodicity (0 - 15).
+	// The device sends an AppTimeReq every 128 * 2^Period seconds.
+	Period int `json:"period"`
+}
+
+// DeviceClockSyncResyncRequest defines the request for sending a
+// ForceDeviceResyncReq to a device.
+type DeviceClockSyncResyncRequest struct {
+	// Device EUI (HEX encoded).
+	DevEUI string `json:"devEUI"`
+
+	// Number of AppTimeReq transmissions (1 - 7).
+	NbTransmissions int `json:"nbTransmissions"`
+}
+
+// MulticastGroupClockSyncPeriodicityRequest defines the request for sending
+// a DeviceAppTimePeriodicityReq to a multicast-group.
+type MulticastGroupClockSyncPeriodicityRequest struct {
+	// Multicast-group ID.
+	ID string `json:"id"`
+
+	// Periodicity (0 - 15).
+	// The devices send an AppTimeReq every 128 * 2^Period seconds.
+	Period int `json:"period"`
+}
+
+// MulticastGroupClockSyncResyncRequest defines the request for sending a
+// ForceDeviceResyncReq to a multicast-group.
+type MulticastGroupClockSyncResyncRequest struct {
+	// Multicast-group ID.
+	ID string `json:"id"`
+
+	// Number of AppTimeReq transmissions (1 - 7).
+	NbTransmissions int `json:"nbTransmissions"`
+}
+
+// GetClockSync returns the clock synchronization state of the given device.
+func (a *DeviceAPI) GetClockSync(ctx context.Context, req *DeviceClockSyncRequest) (*GetDeviceClockSyncResponse, error) {
+	var devEUI lorawan.EUI64
+	if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateNodeAccess(devEUI, auth.Read)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	// make sure the device exists
+	if _, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var resp GetDeviceClockSyncResponse
+
+	cs, err := storage.GetDeviceClockSync(ctx, storage.DB(), devEUI, false)
+	if err != nil {
+		if err == storage.ErrDoesNotExist {
+			// no clock synchronization has happened yet
+			return &resp, nil
+		}
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp.ClockSync = DeviceClockSync{
+		ClockDrift:              cs.ClockDrift,
+		ClockDriftAt:            cs.ClockDriftAt,
+		Periodicity:             cs.Periodicity,
+		PeriodicityRequestedAt:  cs.PeriodicityRequestedAt,
+		PeriodicityAnsweredAt:   cs.PeriodicityAnsweredAt,
+		PeriodicityNotSupported: cs.PeriodicityNotSupported,
+		ResyncNbTransmissions:   cs.ResyncNbTransmissions,
+		ResyncRequestedAt:       cs.ResyncRequestedAt,
+	}
+
+	return &resp, nil
+}
+
+// ClockSyncPeriodicity enqueues a DeviceAppTimePeriodicityReq for the given
+// device.
+func (a *DeviceAPI) ClockSyncPeriodicity(ctx context.Context, req *DeviceClockSyncPeriodicityRequest) (*empty.Empty, error) {
+	var devEUI lorawan.EUI64
+	if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+	}
+
+	if req.Period < 0 || req.Period > clocksync.MaxPeriodicity {
+		return nil, helpers.ErrToRPCError(clocksync.ErrInvalidPeriodicity)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceQueueAccess(devEUI, auth.Create)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		return clocksync.EnqueueDeviceAppTimePeriodicityReq(ctx, tx, devEUI, uint8(req.Period))
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// ClockSyncResync enqueues a ForceDeviceResyncReq for the given device.
+func (a *DeviceAPI) ClockSyncResync(ctx context.Context, req *DeviceClockSyncResyncRequest) (*empty.Empty, error) {
+	var devEUI lorawan.EUI64
+	if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+	}
+
+	if req.NbTransmissions < 1 || req.NbTransmissions > clocksync.MaxNbTransmissions {
+		return nil, helpers.ErrToRPCError(clocksync.ErrInvalidNbTransmissions)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceQueueAccess(devEUI, auth.Create)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		return clocksync.EnqueueForceDeviceResyncReq(ctx, tx, devEUI, uint8(req.NbTransmissions))
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// ClockSyncPeriodicity enqueues a DeviceAppTimePeriodicityReq for the given
+// multicast-group.
+func (a *MulticastGroupAPI) ClockSyncPeriodicity(ctx context.Context, req *MulticastGroupClockSyncPeriodicityRequest) (*empty.Empty, error) {
+	mgID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if req.Period < 0 || req.Period > clocksync.MaxPeriodicity {
+		return nil, helpers.ErrToRPCError(clocksync.ErrInvalidPeriodicity)
+	}
+
+	if err = a.validator.Validate(ctx,
+		auth.ValidateMulticastGroupQueueAccess(auth.Create, mgID)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return clocksync.EnqueueMulticastDeviceAppTimePeriodicityReq(ctx, tx, mgID, uint8(req.Period))
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// ClockSyncResync enqueues a ForceDeviceResyncReq for the given
+// multicast-group.
+func (a *MulticastGroupAPI) ClockSyncResync(ctx context.Context, req *MulticastGroupClockSyncResyncRequest) (*empty.Empty, error) {
+	mgID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if req.NbTransmissions < 1 || req.NbTransmissions > clocksync.MaxNbTransmissions {
+		return nil, helpers.ErrToRPCError(clocksync.ErrInvalidNbTransmissions)
+	}
+
+	if err = a.validator.Validate(ctx,
+		auth.ValidateMulticastGroupQueueAccess(auth.Create, mgID)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return clocksync.EnqueueMulticastForceDeviceResyncReq(ctx, tx, mgID, uint8(req.NbTransmissions))
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
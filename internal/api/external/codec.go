@@ -0,0 +1,507 @@
+package external
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/codec"
+	"github.com/gyh1621/chirpstack-application-server/internal/codec/diff"
+	"github.com/gyh1621/chirpstack-application-server/internal/codec/js"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// codecDiffContext defines the number of context lines of the script diffs.
+const codecDiffContext = 3
+
+// CodecTest contains the codec test input.
+type CodecTest struct {
+	// Payload codec type.
+	// When not set, the stored payload codec is used.
+	PayloadCodec string `json:"payloadCodec"`
+
+	// Payload decoder script.
+	// When not set, the stored decoder script is used.
+	PayloadDecoderScript string `json:"payloadDecoderScript"`
+
+	// Payload encoder script.
+	// When not set, the stored encoder script is used.
+	PayloadEncoderScript string `json:"payloadEncoderScript"`
+
+	// FPort.
+	FPort uint32 `json:"fPort"`
+
+	// Device variables.
+	Variables map[string]string `json:"variables"`
+
+	// Uplink frame-counter (exposed to the decoder as context.fCnt).
+	FCnt uint32 `json:"fCnt"`
+
+	// JSON encoded device state (exposed to the decoder as context.state).
+	State string `json:"state"`
+
+	// Payload to decode (base64 encoded).
+	// Either data or jsonObject must be set.
+	Data []byte `json:"data"`
+
+	// JSON object to encode.
+	// Either data or jsonObject must be set.
+	JSONObject string `json:"jsonObject"`
+}
+
+// TestDeviceProfileCodecRequest defines the request for testing the
+// device-profile payload codec.
+type TestDeviceProfileCodecRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+
+	CodecTest
+}
+
+// TestApplicationCodecRequest defines the request for testing the
+// application payload codec.
+type TestApplicationCodecRequest struct {
+	// Application ID.
+	ID int64 `json:"id"`
+
+	CodecTest
+}
+
+// TestCodecResponse defines the codec test response.
+type TestCodecResponse struct {
+	// Decoded JSON object (in case data was set).
+	ObjectJSON string `json:"objectJSON"`
+
+	// Encoded payload (base64 encoded, in case jsonObject was set).
+	Data []byte `json:"data"`
+
+	// Codec execution time.
+	ExecutionTime string `json:"executionTime"`
+
+	// Console log entries written by the script.
+	Log []CodecLogEntry `json:"log"`
+
+	// JSON encoded device state after decoding.
+	State string `json:"state"`
+
+	// Codec error.
+	Error string `json:"error"`
+
+	// Line and column of the script failure (when available).
+	ErrorLine   int `json:"errorLine"`
+	ErrorColumn int `json:"errorColumn"`
+}
+
+// CodecLogEntry defines a console log entry written by the script.
+type CodecLogEntry struct {
+	// Log level (log or warn).
+	Level string `json:"level"`
+
+	// Log message.
+	Message string `json:"message"`
+}
+
+// runCodecTest runs the codec test using the given codec settings. Settings
+// which are set in the given test override the given settings.
+// Codec errors are returned as part of the response.
+func runCodecTest(t codec.Type, decoderScript, encoderScript string, protoSchema codec.ProtobufSchema, test CodecTest) (*TestCodecResponse, error) {
+	if (len(test.Data) == 0) == (test.JSONObject == "") {
+		return nil, grpc.Errorf(codes.InvalidArgument, "either data or jsonObject must be set")
+	}
+
+	if test.FPort == 0 || test.FPort > 255 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "fPort must be between 1 and 255")
+	}
+
+	if test.PayloadCodec != "" {
+		t = codec.Type(test.PayloadCodec)
+	}
+	if test.PayloadDecoderScript != "" {
+		decoderScript = test.PayloadDecoderScript
+	}
+	if test.PayloadEncoderScript != "" {
+		encoderScript = test.PayloadEncoderScript
+	}
+
+	if t == codec.None {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "no payload codec configured")
+	}
+
+	vars := hstore.Hstore{
+		Map: make(map[string]sql.NullString),
+	}
+	for k, v := range test.Variables {
+		vars.Map[k] = sql.NullString{Valid: true, String: v}
+	}
+
+	var resp TestCodecResponse
+	var logs []codec.LogEntry
+	var err error
+
+	start := time.Now()
+	if len(test.Data) != 0 {
+		devCtx := codec.DeviceContext{
+			FCnt:     test.FCnt,
+			RecvTime: time.Now(),
+		}
+		if test.State != "" {
+			devCtx.State = []byte(test.State)
+		}
+
+		var b []byte
+		b, logs, err = codec.BinaryToJSON(t, uint8(test.FPort), vars, decoderScript, protoSchema, &devCtx, test.Data)
+		resp.ObjectJSON = string(b)
+		resp.State = string(devCtx.State)
+	} else {
+		resp.Data, logs, err = codec.JSONToBinary(t, uint8(test.FPort), vars, encoderScript, protoSchema, []byte(test.JSONObject))
+	}
+	resp.ExecutionTime = time.Since(start).String()
+
+	for _, l := range logs {
+		resp.Log = append(resp.Log, CodecLogEntry{
+			Level:   l.Level,
+			Message: l.Message,
+		})
+	}
+
+	if err != nil {
+		resp.Error = err.Error()
+
+		if scriptErr, ok := errors.Cause(err).(*js.ScriptError); ok {
+			resp.ErrorLine = scriptErr.Line
+			resp.ErrorColumn = scriptErr.Column
+		}
+	}
+
+	return &resp, nil
+}
+
+// CodecRevision defines a payload codec revision.
+type CodecRevision struct {
+	// Revision number.
+	Revision int `json:"revision"`
+
+	// Created at timestamp.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// ID of the user that created the revision.
+	UserID int64 `json:"userID,string"`
+
+	// E-mail of the user that created the revision.
+	UserEmail string `json:"userEmail"`
+
+	// ID of the API key that created the revision.
+	APIKeyID string `json:"apiKeyID"`
+
+	// Payload codec type.
+	PayloadCodec string `json:"payloadCodec"`
+
+	// Payload encoder script.
+	PayloadEncoderScript string `json:"payloadEncoderScript"`
+
+	// Payload decoder script.
+	PayloadDecoderScript string `json:"payloadDecoderScript"`
+
+	// Protobuf FileDescriptorSet (base64 encoded).
+	PayloadProtobufDescriptorSet []byte `json:"payloadProtobufDescriptorSet"`
+
+	// Protobuf fPort to message-name mapping.
+	PayloadProtobufMessages map[string]string `json:"payloadProtobufMessages"`
+}
+
+// ProtobufSchema defines the schema used by the Protobuf payload codec.
+type ProtobufSchema struct {
+	// Compiled Protobuf FileDescriptorSet (base64 encoded), e.g. generated
+	// by protoc --include_imports --descriptor_set_out.
+	DescriptorSet []byte `json:"descriptorSet"`
+
+	// fPort to fully-qualified message-name mapping.
+	Messages map[string]string `json:"messages"`
+}
+
+// DeviceProfileProtobufSchemaRequest defines the request for getting the
+// Protobuf schema of a device-profile.
+type DeviceProfileProtobufSchemaRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+}
+
+// GetDeviceProfileProtobufSchemaResponse defines the get Protobuf schema
+// response.
+type GetDeviceProfileProtobufSchemaResponse struct {
+	ProtobufSchema ProtobufSchema `json:"protobufSchema"`
+}
+
+// UpdateDeviceProfileProtobufSchemaRequest defines the request for updating
+// the Protobuf schema of a device-profile.
+type UpdateDeviceProfileProtobufSchemaRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+
+	ProtobufSchema ProtobufSchema `json:"protobufSchema"`
+}
+
+// ListDeviceProfileCodecRevisionsRequest defines the request for listing the
+// device-profile codec revisions.
+type ListDeviceProfileCodecRevisionsRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+
+	// Max number of items to return.
+	Limit int64 `json:"limit"`
+
+	// Offset in the result-set (for pagination).
+	Offset int64 `json:"offset"`
+}
+
+// ListApplicationCodecRevisionsRequest defines the request for listing the
+// application codec revisions.
+type ListApplicationCodecRevisionsRequest struct {
+	// Application ID.
+	ID int64 `json:"id"`
+
+	// Max number of items to return.
+	Limit int64 `json:"limit"`
+
+	// Offset in the result-set (for pagination).
+	Offset int64 `json:"offset"`
+}
+
+// ListCodecRevisionsResponse defines the codec revisions list response.
+type ListCodecRevisionsResponse struct {
+	// Total number of revisions.
+	TotalCount int64 `json:"totalCount,string"`
+
+	// Revisions within the requested limit and offset, latest first.
+	Result []CodecRevision `json:"result"`
+}
+
+// DeviceProfileCodecRevisionRequest defines the request for getting, diffing
+// or rolling back to a device-profile codec revision.
+type DeviceProfileCodecRevisionRequest struct {
+	// Device-profile ID.
+	ID string `json:"id"`
+
+	// Revision number.
+	Revision int `json:"revision"`
+
+	// Revision to compare against (diff only).
+	// When not set, the previous revision is used.
+	CompareTo int `json:"compareTo"`
+}
+
+// ApplicationCodecRevisionRequest defines the request for getting, diffing
+// or rolling back to an application codec revision.
+type ApplicationCodecRevisionRequest struct {
+	// Application ID.
+	ID int64 `json:"id"`
+
+	// Revision number.
+	Revision int `json:"revision"`
+
+	// Revision to compare against (diff only).
+	// When not set, the previous revision is used.
+	CompareTo int `json:"compareTo"`
+}
+
+// GetCodecRevisionResponse defines the get codec revision response.
+type GetCodecRevisionResponse struct {
+	CodecRevision CodecRevision `json:"codecRevision"`
+}
+
+// DiffCodecRevisionResponse defines the codec revision diff response.
+type DiffCodecRevisionResponse struct {
+	// Revision compared against.
+	From int `json:"from"`
+
+	// Requested revision.
+	To int `json:"to"`
+
+	// Payload codec of the from revision.
+	PayloadCodecFrom string `json:"payloadCodecFrom"`
+
+	// Payload codec of the to revision.
+	PayloadCodecTo string `json:"payloadCodecTo"`
+
+	// Unified diff of the decoder script.
+	PayloadDecoderScriptDiff string `json:"payloadDecoderScriptDiff"`
+
+	// Unified diff of the encoder script.
+	PayloadEncoderScriptDiff string `json:"payloadEncoderScriptDiff"`
+
+	// Protobuf descriptor set or message mapping has changed.
+	PayloadProtobufSchemaChanged bool `json:"payloadProtobufSchemaChanged"`
+}
+
+// RollbackCodecRevisionResponse defines the codec rollback response.
+type RollbackCodecRevisionResponse struct {
+	// The revision number created by the rollback.
+	Revision int `json:"revision"`
+}
+
+// createCodecRevision stores the new codec settings as revision when these
+// are different from the old settings. The user or API key of the request
+// is stored as author of the revision.
+func createCodecRevision(ctx context.Context, db sqlx.Ext, validator auth.Validator, old storage.CodecRevision, new *storage.CodecRevision) error {
+	if old.CodecEqual(*new) {
+		return nil
+	}
+
+	sub, err := validator.GetSubject(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch sub {
+	case auth.SubjectUser:
+		user, err := validator.GetUser(ctx)
+		if err != nil {
+			return err
+		}
+		new.UserID = &user.ID
+	case auth.SubjectAPIKey:
+		id, err := validator.GetAPIKeyID(ctx)
+		if err != nil {
+			return err
+		}
+		new.APIKeyID = &id
+	default:
+		return grpc.Errorf(codes.Unauthenticated, "invalid token subject: %s", sub)
+	}
+
+	return storage.CreateCodecRevision(ctx, db, new)
+}
+
+func listCodecRevisions(ctx context.Context, filters storage.CodecRevisionFilters) (*ListCodecRevisionsResponse, error) {
+	count, err := storage.GetCodecRevisionCount(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	revisions, err := storage.GetCodecRevisions(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListCodecRevisionsResponse{
+		TotalCount: int64(count),
+		Result:     make([]CodecRevision, 0, len(revisions)),
+	}
+
+	for _, r := range revisions {
+		rev := codecRevisionToAPI(r.CodecRevision)
+		if r.UserEmail != nil {
+			rev.UserEmail = *r.UserEmail
+		}
+		resp.Result = append(resp.Result, rev)
+	}
+
+	return &resp, nil
+}
+
+func diffCodecRevisions(ctx context.Context, filters storage.CodecRevisionFilters, revision, compareTo int) (*DiffCodecRevisionResponse, error) {
+	if compareTo == 0 {
+		compareTo = revision - 1
+	}
+
+	to, err := storage.GetCodecRevision(ctx, storage.DB(), filters, revision)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	// the first revision is compared against no codec
+	var from storage.CodecRevision
+	if compareTo > 0 {
+		from, err = storage.GetCodecRevision(ctx, storage.DB(), filters, compareTo)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+	}
+
+	fromLabel := fmt.Sprintf("revision %d", compareTo)
+	toLabel := fmt.Sprintf("revision %d", revision)
+
+	return &DiffCodecRevisionResponse{
+		From:                         compareTo,
+		To:                           revision,
+		PayloadCodecFrom:             string(from.PayloadCodec),
+		PayloadCodecTo:               string(to.PayloadCodec),
+		PayloadDecoderScriptDiff:     diff.Unified(from.PayloadDecoderScript, to.PayloadDecoderScript, fromLabel, toLabel, codecDiffContext),
+		PayloadEncoderScriptDiff:     diff.Unified(from.PayloadEncoderScript, to.PayloadEncoderScript, fromLabel, toLabel, codecDiffContext),
+		PayloadProtobufSchemaChanged: !from.ProtobufSchemaEqual(to),
+	}, nil
+}
+
+func codecRevisionToAPI(r storage.CodecRevision) CodecRevision {
+	out := CodecRevision{
+		Revision:                     r.Revision,
+		CreatedAt:                    r.CreatedAt,
+		PayloadCodec:                 string(r.PayloadCodec),
+		PayloadEncoderScript:         r.PayloadEncoderScript,
+		PayloadDecoderScript:         r.PayloadDecoderScript,
+		PayloadProtobufDescriptorSet: r.PayloadProtobufDescriptorSet,
+		PayloadProtobufMessages:      make(map[string]string),
+	}
+
+	if r.UserID != nil {
+		out.UserID = *r.UserID
+	}
+	if r.APIKeyID != nil {
+		out.APIKeyID = r.APIKeyID.String()
+	}
+	for k, v := range r.PayloadProtobufMessages.Map {
+		out.PayloadProtobufMessages[k] = v.String
+	}
+
+	return out
+}
+
+func protobufSchemaToAPI(s codec.ProtobufSchema) ProtobufSchema {
+	out := ProtobufSchema{
+		DescriptorSet: s.DescriptorSet,
+		Messages:      make(map[string]string),
+	}
+
+	for k, v := range s.Messages.Map {
+		if v.Valid {
+			out.Messages[k] = v.String
+		}
+	}
+
+	return out
+}
+
+// protobufSchemaFromAPI returns the codec schema for the given schema. A
+// non-empty schema is validated against the descriptor set, so that every
+// fPort refers to a message of the descriptor set.
+func protobufSchemaFromAPI(s ProtobufSchema) (codec.ProtobufSchema, error) {
+	out := codec.ProtobufSchema{
+		DescriptorSet: s.DescriptorSet,
+		Messages: hstore.Hstore{
+			Map: make(map[string]sql.NullString),
+		},
+	}
+
+	for k, v := range s.Messages {
+		out.Messages.Map[k] = sql.NullString{Valid: true, String: v}
+	}
+
+	if len(out.DescriptorSet) == 0 && len(out.Messages.Map) == 0 {
+		return out, nil
+	}
+
+	if err := out.Validate(); err != nil {
+		return out, grpc.Errorf(codes.InvalidArgument, "%s: %s", storage.ErrDeviceProfileInvalidProtobufSchema, err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,216 @@
+package external
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/codec"
+)
+
+func TestRunCodecTest(t *testing.T) {
+	decoder := `
+		function Decode(fPort, bytes, variables) {
+			console.log("decoding", bytes.length, "bytes");
+			return {"fPort": fPort, "value": bytes[0], "unit": variables["unit"]};
+		}
+	`
+	encoder := `
+		function Encode(fPort, obj) {
+			return [obj.value];
+		}
+	`
+
+	tests := []struct {
+		Name          string
+		Codec         codec.Type
+		Test          CodecTest
+		Expected      TestCodecResponse
+		ExpectedError string
+	}{
+		{
+			Name:  "decode using stored script",
+			Codec: codec.CustomJSType,
+			Test: CodecTest{
+				FPort:     10,
+				Data:      []byte{5},
+				Variables: map[string]string{"unit": "C"},
+			},
+			Expected: TestCodecResponse{
+				ObjectJSON: `{"fPort":10,"unit":"C","value":5}`,
+				Log:        []CodecLogEntry{{Level: "log", Message: "decoding 1 bytes"}},
+			},
+		},
+		{
+			Name:  "encode using stored script",
+			Codec: codec.CustomJSType,
+			Test: CodecTest{
+				FPort:      10,
+				JSONObject: `{"value": 3}`,
+			},
+			Expected: TestCodecResponse{
+				Data: []byte{3},
+			},
+		},
+		{
+			Name:  "decode using given script",
+			Codec: codec.CustomJSType,
+			Test: CodecTest{
+				FPort:                10,
+				Data:                 []byte{5},
+				PayloadDecoderScript: "function Decode() {\n\tnull.foo;\n}",
+			},
+			Expected: TestCodecResponse{
+				Error:       "execute js error: js vm error: TypeError: Cannot access member 'foo' of null (line 2, column 2)",
+				ErrorLine:   2,
+				ErrorColumn: 2,
+			},
+		},
+		{
+			Name:  "decode using device state",
+			Codec: codec.CustomJSType,
+			Test: CodecTest{
+				FPort:                10,
+				FCnt:                 7,
+				Data:                 []byte{5},
+				State:                `{"total":10}`,
+				PayloadDecoderScript: `function Decode(fPort, bytes, variables, context) { context.state.total += bytes[0]; return {"fCnt": context.fCnt}; }`,
+			},
+			Expected: TestCodecResponse{
+				ObjectJSON: `{"fCnt":7}`,
+				State:      `{"total":15}`,
+			},
+		},
+		{
+			Name:  "decode using given codec",
+			Codec: codec.None,
+			Test: CodecTest{
+				FPort:        10,
+				Data:         []byte{0x01, 0x67, 0x00, 0xe1},
+				PayloadCodec: string(codec.CayenneLPPType),
+			},
+			Expected: TestCodecResponse{
+				ObjectJSON: `{"temperatureSensor":{"1":22.5}}`,
+			},
+		},
+		{
+			Name:  "no codec",
+			Codec: codec.None,
+			Test: CodecTest{
+				FPort: 10,
+				Data:  []byte{1},
+			},
+			ExpectedError: "rpc error: code = FailedPrecondition desc = no payload codec configured",
+		},
+		{
+			Name:  "data and json object",
+			Codec: codec.CustomJSType,
+			Test: CodecTest{
+				FPort:      10,
+				Data:       []byte{1},
+				JSONObject: "{}",
+			},
+			ExpectedError: "rpc error: code = InvalidArgument desc = either data or jsonObject must be set",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := runCodecTest(tst.Codec, decoder, encoder, codec.ProtobufSchema{}, tst.Test)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.NotEmpty(resp.ExecutionTime)
+
+			resp.ExecutionTime = ""
+			assert.Equal(tst.Expected, *resp)
+		})
+	}
+}
+
+func TestProtobufSchemaFromAPI(t *testing.T) {
+	fds := descriptor.FileDescriptorSet{
+		File: []*descriptor.FileDescriptorProto{
+			{
+				Name:    proto.String("sensor.proto"),
+				Package: proto.String("sensor"),
+				Syntax:  proto.String("proto3"),
+				MessageType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("Uplink"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{Name: proto.String("temperature"), Number: proto.Int32(1), Label: descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Type: descriptor.FieldDescriptorProto_TYPE_FLOAT.Enum()},
+						},
+					},
+				},
+			},
+		},
+	}
+	descriptorSet, err := proto.Marshal(&fds)
+	require.NoError(t, err)
+
+	tests := []struct {
+		Name          string
+		Schema        ProtobufSchema
+		ExpectedError string
+	}{
+		{
+			Name: "valid schema",
+			Schema: ProtobufSchema{
+				DescriptorSet: descriptorSet,
+				Messages:      map[string]string{"2": "sensor.Uplink"},
+			},
+		},
+		{
+			Name:   "empty schema",
+			Schema: ProtobufSchema{Messages: map[string]string{}},
+		},
+		{
+			Name: "unknown message",
+			Schema: ProtobufSchema{
+				DescriptorSet: descriptorSet,
+				Messages:      map[string]string{"2": "sensor.Downlink"},
+			},
+			ExpectedError: "rpc error: code = InvalidArgument desc = invalid device-profile protobuf schema",
+		},
+		{
+			Name: "invalid fPort",
+			Schema: ProtobufSchema{
+				DescriptorSet: descriptorSet,
+				Messages:      map[string]string{"256": "sensor.Uplink"},
+			},
+			ExpectedError: "rpc error: code = InvalidArgument desc = invalid device-profile protobuf schema: invalid fPort: 256",
+		},
+		{
+			Name: "invalid descriptor set",
+			Schema: ProtobufSchema{
+				DescriptorSet: []byte{0xff},
+				Messages:      map[string]string{"2": "sensor.Uplink"},
+			},
+			ExpectedError: "rpc error: code = InvalidArgument desc = invalid device-profile protobuf schema",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			schema, err := protobufSchemaFromAPI(tst.Schema)
+			if tst.ExpectedError != "" {
+				assert.Error(err)
+				assert.Contains(err.Error(), tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.Schema.DescriptorSet, schema.DescriptorSet)
+			assert.Equal(len(tst.Schema.Messages), len(schema.Messages.Map))
+			assert.Equal(tst.Schema, protobufSchemaToAPI(schema))
+		})
+	}
+}
@@ -456,7 +456,7 @@ func (a *DeviceAPI) CreateKeys(ctx context.Context, req *pb.CreateDeviceKeysRequ
 	}
 
 	if err := a.validator.Validate(ctx,
-		auth.ValidateNodeAccess(eui, auth.Update),
+		auth.ValidateDeviceKeysAccess(eui, auth.Create),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
@@ -482,6 +482,7 @@ func (a *DeviceAPI) GetKeys(ctx context.Context, req *pb.GetDeviceKeysRequest) (
 	}
 
 	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceKeysAccess(eui, auth.Read),
 		auth.ValidateNodeAccess(eui, auth.Update),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
@@ -536,7 +537,7 @@ func (a *DeviceAPI) UpdateKeys(ctx context.Context, req *pb.UpdateDeviceKeysRequ
 	}
 
 	if err := a.validator.Validate(ctx,
-		auth.ValidateNodeAccess(eui, auth.Update),
+		auth.ValidateDeviceKeysAccess(eui, auth.Update),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
@@ -565,7 +566,7 @@ func (a *DeviceAPI) DeleteKeys(ctx context.Context, req *pb.DeleteDeviceKeysRequ
 	}
 
 	if err := a.validator.Validate(ctx,
-		auth.ValidateNodeAccess(eui, auth.Delete),
+		auth.ValidateDeviceKeysAccess(eui, auth.Delete),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
@@ -585,7 +586,7 @@ func (a *DeviceAPI) Deactivate(ctx context.Context, req *pb.DeactivateDeviceRequ
 	}
 
 	if err := a.validator.Validate(ctx,
-		auth.ValidateNodeAccess(devEUI, auth.Update),
+		auth.ValidateDeviceKeysAccess(devEUI, auth.Delete),
 	); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
@@ -645,7 +646,7 @@ func (a *DeviceAPI) Activate(ctx context.Context, req *pb.ActivateDeviceRequest)
 	}
 
 	if err := a.validator.Validate(ctx,
-		auth.ValidateNodeAccess(devEUI, auth.Update)); err != nil {
+		auth.ValidateDeviceKeysAccess(devEUI, auth.Update)); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
@@ -719,7 +720,7 @@ func (a *DeviceAPI) GetActivation(ctx context.Context, req *pb.GetDeviceActivati
 	}
 
 	if err := a.validator.Validate(ctx,
-		auth.ValidateNodeAccess(devEUI, auth.Read)); err != nil {
+		auth.ValidateDeviceKeysAccess(devEUI, auth.Read)); err != nil {
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
@@ -856,7 +857,7 @@ func (a *DeviceAPI) StreamEventLogs(req *pb.StreamDeviceEventLogsRequest, srv pb
 	}()
 
 	for el := range eventLogChan {
-		b, err := json.Marshal(el.Payload)
+		b, err := el.PayloadJSON()
 		if err != nil {
 			return grpc.Errorf(codes.Internal, "marshal json error: %s", err)
 		}
@@ -0,0 +1,111 @@
+package external
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/packageversion"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// DeviceApplicationLayerRequest defines the request for getting the
+// application-layer package versions of a device, or for requesting them
+// from the device.
+type DeviceApplicationLayerRequest struct {
+	// Device EUI (HEX encoded).
+	DevEUI string `json:"devEUI"`
+}
+
+// DeviceApplicationLayer defines the application-layer package versions
+// implemented by a device. Packages for which the device did not report the
+// version are assumed to implement version 1.
+type DeviceApplicationLayer struct {
+	// Clock synchronization package version.
+	ClockSyncVersion int `json:"clockSyncVersion"`
+
+	// Remote multicast setup package version.
+	MulticastSetupVersion int `json:"multicastSetupVersion"`
+
+	// Fragmented data block transport package version.
+	FragmentationVersion int `json:"fragmentationVersion"`
+
+	// Timestamp of the last PackageVersionReq.
+	PackageVersionRequestedAt *time.Time `json:"packageVersionRequestedAt"`
+}
+
+// GetDeviceApplicationLayerResponse defines the application-layer package
+// versions response.
+type GetDeviceApplicationLayerResponse struct {
+	ApplicationLayer DeviceApplicationLayer `json:"applicationLayer"`
+}
+
+// GetApplicationLayer returns the application-layer package versions of the
+// given device.
+func (a *DeviceAPI) GetApplicationLayer(ctx context.Context, req *DeviceApplicationLayerRequest) (*GetDeviceApplicationLayerResponse, error) {
+	var devEUI lorawan.EUI64
+	if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateNodeAccess(devEUI, auth.Read)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	// make sure the device exists
+	if _, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	dal, err := storage.GetDeviceApplicationLayer(ctx, storage.DB(), devEUI, false)
+	if err != nil && err != storage.ErrDoesNotExist {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	version := func(v *int) int {
+		if v == nil {
+			return packageversion.DefaultVersion
+		}
+		return *v
+	}
+
+	return &GetDeviceApplicationLayerResponse{
+		ApplicationLayer: DeviceApplicationLayer{
+			ClockSyncVersion:          version(dal.ClockSyncVersion),
+			MulticastSetupVersion:     version(dal.MulticastSetupVersion),
+			FragmentationVersion:      version(dal.FragmentationVersion),
+			PackageVersionRequestedAt: dal.PackageVersionRequestedAt,
+		},
+	}, nil
+}
+
+// RequestPackageVersion enqueues a PackageVersionReq for each
+// application-layer package enabled for the given device.
+func (a *DeviceAPI) RequestPackageVersion(ctx context.Context, req *DeviceApplicationLayerRequest) (*empty.Empty, error) {
+	var devEUI lorawan.EUI64
+	if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceQueueAccess(devEUI, auth.Create)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		return packageversion.EnqueuePackageVersionReq(ctx, tx, devEUI)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
@@ -86,7 +86,16 @@ func (a *DeviceProfileServiceAPI) Create(ctx context.Context, req *pb.CreateDevi
 	// as this also performs a remote call to create the device-profile
 	// on the network-server, wrap it in a transaction
 	err := storage.Transaction(func(tx sqlx.Ext) error {
-		return storage.CreateDeviceProfile(ctx, tx, &dp)
+		if err := storage.CreateDeviceProfile(ctx, tx, &dp); err != nil {
+			return err
+		}
+
+		rev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
+		return createCodecRevision(ctx, tx, a.validator, storage.CodecRevision{}, &rev)
 	})
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
@@ -194,6 +203,11 @@ func (a *DeviceProfileServiceAPI) Update(ctx context.Context, req *pb.UpdateDevi
 			return err
 		}
 
+		oldRev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
 		dp.Name = req.DeviceProfile.Name
 		dp.PayloadCodec = codec.Type(req.DeviceProfile.PayloadCodec)
 		dp.PayloadEncoderScript = req.DeviceProfile.PayloadEncoderScript
@@ -228,12 +242,287 @@ func (a *DeviceProfileServiceAPI) Update(ctx context.Context, req *pb.UpdateDevi
 			dp.Tags.Map[k] = sql.NullString{Valid: true, String: v}
 		}
 
-		return storage.UpdateDeviceProfile(ctx, tx, &dp)
+		if err := storage.UpdateDeviceProfile(ctx, tx, &dp); err != nil {
+			return err
+		}
+
+		newRev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
+		return createCodecRevision(ctx, tx, a.validator, oldRev, &newRev)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// TestCodec runs the payload codec against the given input, using either
+// the given or the stored codec settings.
+func (a *DeviceProfileServiceAPI) TestCodec(ctx context.Context, req *TestDeviceProfileCodecRequest) (*TestCodecResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Update, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dpID, false, true)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return runCodecTest(dp.PayloadCodec, dp.PayloadDecoderScript, dp.PayloadEncoderScript, dp.PayloadProtobufSchema(), req.CodecTest)
+}
+
+// ListCodecRevisions lists the payload codec revisions of the device-profile.
+func (a *DeviceProfileServiceAPI) ListCodecRevisions(ctx context.Context, req *ListDeviceProfileCodecRevisionsRequest) (*ListCodecRevisionsResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Read, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return listCodecRevisions(ctx, storage.CodecRevisionFilters{
+		DeviceProfileID: &dpID,
+		Limit:           int(req.Limit),
+		Offset:          int(req.Offset),
+	})
+}
+
+// GetCodecRevision returns the given payload codec revision of the
+// device-profile.
+func (a *DeviceProfileServiceAPI) GetCodecRevision(ctx context.Context, req *DeviceProfileCodecRevisionRequest) (*GetCodecRevisionResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Read, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	rev, err := storage.GetCodecRevision(ctx, storage.DB(), storage.CodecRevisionFilters{DeviceProfileID: &dpID}, req.Revision)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetCodecRevisionResponse{
+		CodecRevision: codecRevisionToAPI(rev),
+	}, nil
+}
+
+// DiffCodecRevision returns the differences between the given payload codec
+// revision and the compareTo revision (by default the previous revision).
+func (a *DeviceProfileServiceAPI) DiffCodecRevision(ctx context.Context, req *DeviceProfileCodecRevisionRequest) (*DiffCodecRevisionResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Read, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return diffCodecRevisions(ctx, storage.CodecRevisionFilters{DeviceProfileID: &dpID}, req.Revision, req.CompareTo)
+}
+
+// RollbackCodecRevision restores the codec settings of the given revision.
+// The restored settings are stored as a new revision.
+func (a *DeviceProfileServiceAPI) RollbackCodecRevision(ctx context.Context, req *DeviceProfileCodecRevisionRequest) (*RollbackCodecRevisionResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Update, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	var revision int
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		dp, err := storage.GetDeviceProfile(ctx, tx, dpID, true, false)
+		if err != nil {
+			return err
+		}
+
+		rev, err := storage.GetCodecRevision(ctx, tx, storage.CodecRevisionFilters{DeviceProfileID: &dpID}, req.Revision)
+		if err != nil {
+			return err
+		}
+
+		oldRev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
+		dp.PayloadCodec = rev.PayloadCodec
+		dp.PayloadEncoderScript = rev.PayloadEncoderScript
+		dp.PayloadDecoderScript = rev.PayloadDecoderScript
+		dp.PayloadProtobufDescriptorSet = rev.PayloadProtobufDescriptorSet
+		dp.PayloadProtobufMessages = rev.PayloadProtobufMessages
+
+		if err := storage.UpdateDeviceProfile(ctx, tx, &dp); err != nil {
+			return err
+		}
+
+		newRev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
+		if err := createCodecRevision(ctx, tx, a.validator, oldRev, &newRev); err != nil {
+			return err
+		}
+
+		revision = newRev.Revision
+		return nil
 	})
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	return &RollbackCodecRevisionResponse{
+		Revision: revision,
+	}, nil
+}
+
+// GetProtobufSchema returns the Protobuf schema of the device-profile.
+func (a *DeviceProfileServiceAPI) GetProtobufSchema(ctx context.Context, req *DeviceProfileProtobufSchemaRequest) (*GetDeviceProfileProtobufSchemaResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Read, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dpID, false, true)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetDeviceProfileProtobufSchemaResponse{
+		ProtobufSchema: protobufSchemaToAPI(dp.PayloadProtobufSchema()),
+	}, nil
+}
+
+// UpdateProtobufSchema updates the Protobuf schema of the device-profile.
+// This creates a new payload codec revision.
+func (a *DeviceProfileServiceAPI) UpdateProtobufSchema(ctx context.Context, req *UpdateDeviceProfileProtobufSchemaRequest) (*empty.Empty, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Update, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	schema, err := protobufSchemaFromAPI(req.ProtobufSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		dp, err := storage.GetDeviceProfile(ctx, tx, dpID, true, false)
+		if err != nil {
+			return err
+		}
+
+		oldRev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
+		dp.PayloadProtobufDescriptorSet = schema.DescriptorSet
+		dp.PayloadProtobufMessages = schema.Messages
+
+		if err := storage.UpdateDeviceProfile(ctx, tx, &dp); err != nil {
+			return err
+		}
+
+		newRev, err := dp.CodecRevision()
+		if err != nil {
+			return err
+		}
+
+		return createCodecRevision(ctx, tx, a.validator, oldRev, &newRev)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// GetApplicationLayer returns the application-layer settings of the
+// device-profile.
+func (a *DeviceProfileServiceAPI) GetApplicationLayer(ctx context.Context, req *DeviceProfileApplicationLayerRequest) (*GetDeviceProfileApplicationLayerResponse, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Read, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, storage.DB(), dpID, false, true)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetDeviceProfileApplicationLayerResponse{
+		ApplicationLayer: applicationLayerToAPI(dp.ApplicationLayerParams),
+	}, nil
+}
+
+// UpdateApplicationLayer updates the application-layer settings of the
+// device-profile.
+func (a *DeviceProfileServiceAPI) UpdateApplicationLayer(ctx context.Context, req *UpdateDeviceProfileApplicationLayerRequest) (*empty.Empty, error) {
+	dpID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "uuid error: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateDeviceProfileAccess(auth.Update, dpID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err = storage.UpdateDeviceProfileApplicationLayerParams(ctx, storage.DB(), dpID, applicationLayerFromAPI(req.ApplicationLayer))
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
 	return &empty.Empty{}, nil
 }
 
@@ -77,13 +77,15 @@ func (d *DeviceQueueAPI) Enqueue(ctx context.Context, req *pb.EnqueueDeviceQueue
 			// device-profile codec fields.
 			payloadCodec := app.PayloadCodec
 			payloadEncoderScript := app.PayloadEncoderScript
+			var protoSchema codec.ProtobufSchema
 
 			if dp.PayloadCodec != "" {
 				payloadCodec = dp.PayloadCodec
 				payloadEncoderScript = dp.PayloadEncoderScript
+				protoSchema = dp.PayloadProtobufSchema()
 			}
 
-			req.DeviceQueueItem.Data, err = codec.JSONToBinary(payloadCodec, uint8(req.DeviceQueueItem.FPort), dev.Variables, payloadEncoderScript, []byte(req.DeviceQueueItem.JsonObject))
+			req.DeviceQueueItem.Data, _, err = codec.JSONToBinary(payloadCodec, uint8(req.DeviceQueueItem.FPort), dev.Variables, payloadEncoderScript, protoSchema, []byte(req.DeviceQueueItem.JsonObject))
 			if err != nil {
 				return helpers.ErrToRPCError(err)
 			}
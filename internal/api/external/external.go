@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/mux"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/tmc/grpc-websocket-proxy/wsproxy"
@@ -24,9 +26,11 @@ import (
 
 	pb "github.com/gyh1621/chirpstack-api/go/v3/as/external/api"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/ldap"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/external/oidc"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/jwtkeys"
 	"github.com/gyh1621/chirpstack-application-server/internal/static"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
@@ -39,11 +43,24 @@ var (
 	registrationEnabled     bool
 	registrationCallbackURL string
 
+	passwordResetTokenTTL     time.Duration
+	emailVerificationTokenTTL time.Duration
+
+	ldapAdminGroup     string
+	ldapGroupMapping   []config.GroupMappingConfig
+	ldapLinkLocalUsers bool
+
+	oidcGroupsClaim  string
+	oidcAdminGroup   string
+	oidcGroupMapping []config.GroupMappingConfig
+
 	bind            string
 	tlsCert         string
 	tlsKey          string
 	jwtSecret       string
+	jwtAlgorithm    string
 	corsAllowOrigin string
+	trustedProxies  []*net.IPNet
 
 	applicationServerID uuid.UUID
 )
@@ -60,13 +77,38 @@ func Setup(conf config.Config) error {
 	registrationCallbackURL = conf.ApplicationServer.UserAuthentication.OpenIDConnect.RegistrationCallbackURL
 	openIDConnectEnabled = conf.ApplicationServer.UserAuthentication.OpenIDConnect.Enabled
 	openIDLoginLabel = conf.ApplicationServer.UserAuthentication.OpenIDConnect.LoginLabel
+	passwordResetTokenTTL = conf.ApplicationServer.UserAuthentication.PasswordResetTokenTTL
+	emailVerificationTokenTTL = conf.ApplicationServer.UserAuthentication.EmailVerificationTokenTTL
+	ldapAdminGroup = conf.ApplicationServer.UserAuthentication.LDAP.AdminGroup
+	ldapGroupMapping = conf.ApplicationServer.UserAuthentication.LDAP.GroupMapping
+	ldapLinkLocalUsers = conf.ApplicationServer.UserAuthentication.LDAP.LinkLocalUsers
+	oidcGroupsClaim = conf.ApplicationServer.UserAuthentication.OpenIDConnect.GroupsClaim
+	oidcAdminGroup = conf.ApplicationServer.UserAuthentication.OpenIDConnect.AdminGroup
+	oidcGroupMapping = conf.ApplicationServer.UserAuthentication.OpenIDConnect.GroupMapping
+
+	if err := ldap.Setup(conf); err != nil {
+		return errors.Wrap(err, "setup ldap error")
+	}
 
 	bind = conf.ApplicationServer.ExternalAPI.Bind
 	tlsCert = conf.ApplicationServer.ExternalAPI.TLSCert
 	tlsKey = conf.ApplicationServer.ExternalAPI.TLSKey
 	jwtSecret = conf.ApplicationServer.ExternalAPI.JWTSecret
+	jwtAlgorithm = conf.ApplicationServer.ExternalAPI.JWTAlgorithm
+	if jwtAlgorithm == "" {
+		jwtAlgorithm = "HS256"
+	}
 	corsAllowOrigin = conf.ApplicationServer.ExternalAPI.CORSAllowOrigin
 
+	trustedProxies = nil
+	for _, p := range conf.ApplicationServer.ExternalAPI.TrustedProxies {
+		ipNet, err := parseTrustedProxy(p)
+		if err != nil {
+			return errors.Wrap(err, "parse trusted_proxies error")
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+
 	if err := applicationServerID.UnmarshalText([]byte(conf.ApplicationServer.ID)); err != nil {
 		return errors.Wrap(err, "decode application_server.id error")
 	}
@@ -75,13 +117,17 @@ func Setup(conf config.Config) error {
 }
 
 func setupAPI(conf config.Config) error {
-	validator := auth.NewJWTValidator(storage.DB(), "HS256", jwtSecret)
+	validator := auth.NewJWTValidator(storage.DB(), jwtAlgorithm, jwtSecret)
+	validator.SetGroupSync(func(ctx context.Context, db sqlx.Ext, user *storage.User, groups []string) error {
+		return syncUserGroups(ctx, db, user, groups, oidcAdminGroup, oidcGroupMapping)
+	})
 	rpID, err := uuid.FromString(conf.ApplicationServer.ID)
 	if err != nil {
 		return errors.Wrap(err, "application-server id to uuid error")
 	}
 
 	grpcOpts := helpers.GetgRPCServerOptions()
+	grpcOpts = append(grpcOpts, grpc.ChainUnaryInterceptor(auditUnaryServerInterceptor(validator)))
 	grpcServer := grpc.NewServer(grpcOpts...)
 	pb.RegisterApplicationServiceServer(grpcServer, NewApplicationAPI(validator))
 	pb.RegisterDeviceQueueServiceServer(grpcServer, NewDeviceQueueAPI(validator))
@@ -150,7 +196,7 @@ func setupAPI(conf config.Config) error {
 	time.Sleep(time.Millisecond * 100)
 
 	// setup the HTTP handler
-	clientHTTPHandler, err = setupHTTPAPI(conf)
+	clientHTTPHandler, err = setupHTTPAPI(conf, validator)
 	if err != nil {
 		return err
 	}
@@ -158,7 +204,7 @@ func setupAPI(conf config.Config) error {
 	return nil
 }
 
-func setupHTTPAPI(conf config.Config) (http.Handler, error) {
+func setupHTTPAPI(conf config.Config, validator auth.Validator) (http.Handler, error) {
 	r := mux.NewRouter()
 
 	// setup json api handler
@@ -177,12 +223,19 @@ func setupHTTPAPI(conf config.Config) (http.Handler, error) {
 		}
 		w.Write(data)
 	}).Methods("get")
+
+	// the json api handlers must be registered before the gRPC gateway
+	// as it handles all /api requests
+	setupHTTPRoutes(r, validator)
 	r.PathPrefix("/api").Handler(jsonHandler)
 
 	if err := oidc.Setup(conf, r); err != nil {
 		return nil, errors.Wrap(err, "setup openid connect error")
 	}
 
+	log.WithField("path", "/.well-known/jwks.json").Info("api/external: registering jwks endpoint")
+	r.HandleFunc("/.well-known/jwks.json", jwtkeys.JWKSHandler).Methods("get")
+
 	// setup static file server
 	r.PathPrefix("/").Handler(http.FileServer(&assetfs.AssetFS{
 		Asset:     static.Asset,
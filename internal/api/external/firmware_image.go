@@ -0,0 +1,358 @@
+package external
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/firmware"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// FirmwareImage defines a firmware image.
+type FirmwareImage struct {
+	// Firmware image ID (UUID).
+	// This will be automatically assigned on create.
+	ID string `json:"id"`
+
+	// Organization ID.
+	OrganizationID int64 `json:"organizationID,string"`
+
+	// Name of the firmware image.
+	Name string `json:"name"`
+
+	// Version of the firmware image.
+	// The combination of name and version must be unique within the
+	// organization. The version can not be updated.
+	Version string `json:"version"`
+
+	// Description of the firmware image.
+	Description string `json:"description"`
+
+	// IDs of the device-profiles the image is compatible with.
+	// When empty, the image is compatible with all device-profiles.
+	DeviceProfileIDs []string `json:"deviceProfileIDs"`
+
+	// FUOTA descriptor (4 bytes, base64 encoded).
+	// When not set on create, the first four bytes of the SHA-256 are used.
+	Descriptor []byte `json:"descriptor"`
+
+	// Firmware image payload (base64 encoded).
+	// This is only used on create.
+	Payload []byte `json:"payload,omitempty"`
+
+	// Firmware image signature (base64 encoded).
+	// This is only used on create.
+	Signature []byte `json:"signature,omitempty"`
+
+	// Size of the firmware image (bytes).
+	Size int `json:"size"`
+
+	// SHA-256 of the firmware image (HEX encoded).
+	SHA256 string `json:"sha256"`
+
+	// The signature has been verified using the configured public-key.
+	SignatureVerified bool `json:"signatureVerified"`
+
+	// Created at timestamp.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Last update timestamp.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateFirmwareImageRequest defines the request for creating a firmware
+// image.
+type CreateFirmwareImageRequest struct {
+	FirmwareImage FirmwareImage `json:"firmwareImage"`
+}
+
+// CreateFirmwareImageResponse defines the create firmware image response.
+type CreateFirmwareImageResponse struct {
+	// Firmware image ID.
+	ID string `json:"id"`
+}
+
+// FirmwareImageRequest defines the request for getting or deleting a
+// firmware image.
+type FirmwareImageRequest struct {
+	// Firmware image ID.
+	ID string `json:"id"`
+}
+
+// GetFirmwareImageResponse defines the get firmware image response.
+type GetFirmwareImageResponse struct {
+	FirmwareImage FirmwareImage `json:"firmwareImage"`
+}
+
+// UpdateFirmwareImageRequest defines the request for updating a firmware
+// image. Only the name, description, descriptor and device-profiles can be
+// updated.
+type UpdateFirmwareImageRequest struct {
+	// Firmware image ID.
+	ID string `json:"id"`
+
+	FirmwareImage FirmwareImage `json:"firmwareImage"`
+}
+
+// ListFirmwareImagesRequest defines the request for listing the firmware
+// images.
+type ListFirmwareImagesRequest struct {
+	// Organization ID.
+	OrganizationID int64 `json:"organizationID"`
+
+	// Only return the images compatible with the given device-profile ID.
+	DeviceProfileID string `json:"deviceProfileID"`
+
+	// Max number of items to return.
+	Limit int64 `json:"limit"`
+
+	// Offset in the result-set (for pagination).
+	Offset int64 `json:"offset"`
+}
+
+// ListFirmwareImagesResponse defines the firmware images list response.
+type ListFirmwareImagesResponse struct {
+	// Total number of firmware images.
+	TotalCount int64 `json:"totalCount,string"`
+
+	// Firmware images within the requested limit and offset.
+	Result []FirmwareImage `json:"result"`
+}
+
+// FirmwareImageAPI exports the firmware image related functions.
+type FirmwareImageAPI struct {
+	validator auth.Validator
+}
+
+// NewFirmwareImageAPI creates a new FirmwareImageAPI.
+func NewFirmwareImageAPI(validator auth.Validator) *FirmwareImageAPI {
+	return &FirmwareImageAPI{
+		validator: validator,
+	}
+}
+
+// Create creates the given firmware image. When a signing public-key is
+// configured, the signature of the image is verified.
+func (a *FirmwareImageAPI) Create(ctx context.Context, req *CreateFirmwareImageRequest) (*CreateFirmwareImageResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateFirmwareImagesAccess(auth.Create, req.FirmwareImage.OrganizationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	fi := storage.FirmwareImage{
+		OrganizationID: req.FirmwareImage.OrganizationID,
+		Name:           req.FirmwareImage.Name,
+		Version:        req.FirmwareImage.Version,
+		Description:    req.FirmwareImage.Description,
+		Payload:        req.FirmwareImage.Payload,
+		Descriptor:     req.FirmwareImage.Descriptor,
+		Signature:      req.FirmwareImage.Signature,
+	}
+
+	var err error
+	fi.DeviceProfileIDs, err = firmwareImageDeviceProfileIDs(req.FirmwareImage.DeviceProfileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	fi.SignatureVerified, err = firmware.Verify(fi.Payload, fi.Signature)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	err = storage.Transaction(func(db sqlx.Ext) error {
+		return storage.CreateFirmwareImage(ctx, db, &fi)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &CreateFirmwareImageResponse{
+		ID: fi.ID.String(),
+	}, nil
+}
+
+// Get returns the firmware image for the given ID. The payload of the image
+// is not returned.
+func (a *FirmwareImageAPI) Get(ctx context.Context, req *FirmwareImageRequest) (*GetFirmwareImageResponse, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateFirmwareImageAccess(auth.Read, id),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	fi, err := storage.GetFirmwareImage(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetFirmwareImageResponse{
+		FirmwareImage: firmwareImageToAPI(fi),
+	}, nil
+}
+
+// Update updates the given firmware image.
+func (a *FirmwareImageAPI) Update(ctx context.Context, req *UpdateFirmwareImageRequest) (*empty.Empty, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateFirmwareImageAccess(auth.Update, id),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	dpIDs, err := firmwareImageDeviceProfileIDs(req.FirmwareImage.DeviceProfileIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.Transaction(func(db sqlx.Ext) error {
+		fi, err := storage.GetFirmwareImage(ctx, db, id)
+		if err != nil {
+			return err
+		}
+
+		fi.Name = req.FirmwareImage.Name
+		fi.Description = req.FirmwareImage.Description
+		fi.DeviceProfileIDs = dpIDs
+		if len(req.FirmwareImage.Descriptor) != 0 {
+			fi.Descriptor = req.FirmwareImage.Descriptor
+		}
+
+		return storage.UpdateFirmwareImage(ctx, db, &fi)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// Delete deletes the given firmware image. An image which is referenced by
+// a FUOTA deployment can not be deleted.
+func (a *FirmwareImageAPI) Delete(ctx context.Context, req *FirmwareImageRequest) (*empty.Empty, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateFirmwareImageAccess(auth.Delete, id),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := storage.DeleteFirmwareImage(ctx, storage.DB(), id); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// List lists the firmware images of the given organization.
+func (a *FirmwareImageAPI) List(ctx context.Context, req *ListFirmwareImagesRequest) (*ListFirmwareImagesResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateFirmwareImagesAccess(auth.List, req.OrganizationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	filters := storage.FirmwareImageFilters{
+		OrganizationID: req.OrganizationID,
+		Limit:          int(req.Limit),
+		Offset:         int(req.Offset),
+	}
+
+	if req.DeviceProfileID != "" {
+		dpID, err := uuid.FromString(req.DeviceProfileID)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "deviceProfileID: %s", err)
+		}
+		filters.DeviceProfileID = &dpID
+	}
+
+	count, err := storage.GetFirmwareImageCount(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	images, err := storage.GetFirmwareImages(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListFirmwareImagesResponse{
+		TotalCount: int64(count),
+		Result:     make([]FirmwareImage, 0, len(images)),
+	}
+
+	for _, fi := range images {
+		resp.Result = append(resp.Result, FirmwareImage{
+			ID:                fi.ID.String(),
+			OrganizationID:    fi.OrganizationID,
+			Name:              fi.Name,
+			Version:           fi.Version,
+			Description:       fi.Description,
+			Size:              fi.Size,
+			SHA256:            hex.EncodeToString(fi.SHA256),
+			SignatureVerified: fi.SignatureVerified,
+			CreatedAt:         fi.CreatedAt,
+			UpdatedAt:         fi.UpdatedAt,
+		})
+	}
+
+	return &resp, nil
+}
+
+func firmwareImageDeviceProfileIDs(ids []string) ([]uuid.UUID, error) {
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, s := range ids {
+		id, err := uuid.FromString(s)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "deviceProfileIDs: %s", err)
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+func firmwareImageToAPI(fi storage.FirmwareImage) FirmwareImage {
+	out := FirmwareImage{
+		ID:                fi.ID.String(),
+		OrganizationID:    fi.OrganizationID,
+		Name:              fi.Name,
+		Version:           fi.Version,
+		Description:       fi.Description,
+		DeviceProfileIDs:  make([]string, 0, len(fi.DeviceProfileIDs)),
+		Descriptor:        fi.Descriptor,
+		Size:              len(fi.Payload),
+		SHA256:            hex.EncodeToString(fi.SHA256),
+		SignatureVerified: fi.SignatureVerified,
+		CreatedAt:         fi.CreatedAt,
+		UpdatedAt:         fi.UpdatedAt,
+	}
+
+	for _, id := range fi.DeviceProfileIDs {
+		out.DeviceProfileIDs = append(out.DeviceProfileIDs, id.String())
+	}
+
+	return out
+}
@@ -1,6 +1,8 @@
 package external
 
 import (
+	"time"
+
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/empty"
@@ -16,6 +18,7 @@ import (
 	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	"github.com/gyh1621/chirpstack-application-server/internal/fuota"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
@@ -65,76 +68,9 @@ func (f *FUOTADeploymentAPI) CreateForGroup(ctx context.Context, req *pb.CreateF
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
-	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
-	}
-
-	versionResp, err := nsClient.GetVersion(ctx, &empty.Empty{})
-	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
-	}
-
-	var b band.Band
-
-	switch versionResp.Region {
-	case common.Region_EU868:
-		b, err = band.GetConfig(band.EU868, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_US915:
-		b, err = band.GetConfig(band.US915, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_CN779:
-		b, err = band.GetConfig(band.CN779, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_EU433:
-		b, err = band.GetConfig(band.EU433, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_AU915:
-		b, err = band.GetConfig(band.AU915, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_CN470:
-		b, err = band.GetConfig(band.CN470, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_AS923:
-		b, err = band.GetConfig(band.AS923, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_KR920:
-		b, err = band.GetConfig(band.KR920, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_IN865:
-		b, err = band.GetConfig(band.IN865, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_RU864:
-		b, err = band.GetConfig(band.RU864, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	default:
-		return nil, grpc.Errorf(codes.Internal, "region %s is not implemented", versionResp.Region)
-	}
-
-	maxPLSize, err := b.GetMaxPayloadSizeForDataRateIndex("", "", int(req.FuotaDeployment.Dr))
+	fragSize, err := getFUOTAFragSize(ctx, n, int(req.FuotaDeployment.Dr))
 	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
+		return nil, err
 	}
 
 	// Create FUOTA Deployment
@@ -143,7 +79,7 @@ func (f *FUOTADeploymentAPI) CreateForGroup(ctx context.Context, req *pb.CreateF
 		DR:                  int(mg.MulticastGroup.Dr),
 		Frequency:           int(mg.MulticastGroup.Frequency),
 		Payload:             req.FuotaDeployment.Payload,
-		FragSize:            maxPLSize.N - 3,
+		FragSize:            fragSize,
 		Redundancy:          int(req.FuotaDeployment.Redundancy),
 		MulticastTimeout:    int(req.FuotaDeployment.MulticastTimeout),
 		FragmentationMatrix: uint8(req.FuotaDeployment.FragAlgo),
@@ -195,76 +131,9 @@ func (f *FUOTADeploymentAPI) CreateForDevice(ctx context.Context, req *pb.Create
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	fragSize, err := getFUOTAFragSize(ctx, n, int(req.FuotaDeployment.Dr))
 	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
-	}
-
-	versionResp, err := nsClient.GetVersion(ctx, &empty.Empty{})
-	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
-	}
-
-	var b band.Band
-
-	switch versionResp.Region {
-	case common.Region_EU868:
-		b, err = band.GetConfig(band.EU868, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_US915:
-		b, err = band.GetConfig(band.US915, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_CN779:
-		b, err = band.GetConfig(band.CN779, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_EU433:
-		b, err = band.GetConfig(band.EU433, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_AU915:
-		b, err = band.GetConfig(band.AU915, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_CN470:
-		b, err = band.GetConfig(band.CN470, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_AS923:
-		b, err = band.GetConfig(band.AS923, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_KR920:
-		b, err = band.GetConfig(band.KR920, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_IN865:
-		b, err = band.GetConfig(band.IN865, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	case common.Region_RU864:
-		b, err = band.GetConfig(band.RU864, false, lorawan.DwellTimeNoLimit)
-		if err != nil {
-			return nil, helpers.ErrToRPCError(err)
-		}
-	default:
-		return nil, grpc.Errorf(codes.Internal, "region %s is not implemented", versionResp.Region)
-	}
-
-	maxPLSize, err := b.GetMaxPayloadSizeForDataRateIndex("", "", int(req.FuotaDeployment.Dr))
-	if err != nil {
-		return nil, helpers.ErrToRPCError(err)
+		return nil, err
 	}
 
 	fd := storage.FUOTADeployment{
@@ -272,7 +141,7 @@ func (f *FUOTADeploymentAPI) CreateForDevice(ctx context.Context, req *pb.Create
 		DR:                  int(req.FuotaDeployment.Dr),
 		Frequency:           int(req.FuotaDeployment.Frequency),
 		Payload:             req.FuotaDeployment.Payload,
-		FragSize:            maxPLSize.N - 3,
+		FragSize:            fragSize,
 		Redundancy:          int(req.FuotaDeployment.Redundancy),
 		MulticastTimeout:    int(req.FuotaDeployment.MulticastTimeout),
 		FragmentationMatrix: uint8(req.FuotaDeployment.FragAlgo),
@@ -353,6 +222,8 @@ func (f *FUOTADeploymentAPI) Get(ctx context.Context, req *pb.GetFUOTADeployment
 		resp.FuotaDeployment.GroupType = pb.MulticastGroupType_CLASS_B
 	case storage.FUOTADeploymentGroupTypeC:
 		resp.FuotaDeployment.GroupType = pb.MulticastGroupType_CLASS_C
+	case storage.FUOTADeploymentGroupTypeA:
+		// unicast deployments do not have a multicast group-type
 	default:
 		return nil, grpc.Errorf(codes.Internal, "unexpected group-type: %s", fd.GroupType)
 	}
@@ -445,7 +316,7 @@ func (f *FUOTADeploymentAPI) GetDeploymentDevice(ctx context.Context, req *pb.Ge
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	fdd, err := storage.GetFUOTADeploymentDevice(ctx, storage.DB(), fuotaDeploymentID, devEUI)
+	fdd, err := storage.GetFUOTADeploymentDevice(ctx, storage.DB(), fuotaDeploymentID, devEUI, false)
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
@@ -546,6 +417,396 @@ func (f *FUOTADeploymentAPI) ListDeploymentDevices(ctx context.Context, req *pb.
 	return &out, nil
 }
 
+// FUOTADeploymentStateRequest defines the request for changing the state of
+// a FUOTA deployment.
+type FUOTADeploymentStateRequest struct {
+	// FUOTA deployment ID.
+	ID string `json:"id"`
+}
+
+// FUOTADeploymentStateResponse defines the response containing the new
+// FUOTA deployment state.
+type FUOTADeploymentStateResponse struct {
+	// FUOTA deployment state.
+	State string `json:"state"`
+}
+
+// Cancel cancels the given FUOTA deployment. The multicast and fragmentation
+// sessions of the devices that were already set up will be deleted before
+// the deployment is set to CANCELLED.
+func (f *FUOTADeploymentAPI) Cancel(ctx context.Context, req *FUOTADeploymentStateRequest) (*FUOTADeploymentStateResponse, error) {
+	return f.updateState(ctx, req, func(fd *storage.FUOTADeployment) error {
+		switch fd.State {
+		case storage.FUOTADeploymentPaused:
+			// keep the state before the deployment was paused
+		case storage.FUOTADeploymentNextWave,
+			storage.FUOTADeploymentHalted,
+			storage.FUOTADeploymentMulticastCreate,
+			storage.FUOTADeploymentMulticastSetup,
+			storage.FUOTADeploymentFragmentationSessSetup,
+			storage.FUOTADeploymentMulticastSessCSetup,
+			storage.FUOTADeploymentEnqueue,
+			storage.FUOTADeploymentUnicastDelivery,
+			storage.FUOTADeploymentStatusRequest,
+			storage.FUOTADeploymentSetDeviceStatus:
+			fd.PreviousState = fd.State
+		default:
+			return grpc.Errorf(codes.FailedPrecondition, "fuota deployment in state %s can not be cancelled", fd.State)
+		}
+
+		fd.State = storage.FUOTADeploymentCancel
+		fd.NextStepAfter = time.Now()
+		return nil
+	})
+}
+
+// Pause pauses the given FUOTA deployment. The deployment will not proceed
+// to the next step until it is resumed.
+func (f *FUOTADeploymentAPI) Pause(ctx context.Context, req *FUOTADeploymentStateRequest) (*FUOTADeploymentStateResponse, error) {
+	return f.updateState(ctx, req, func(fd *storage.FUOTADeployment) error {
+		switch fd.State {
+		case storage.FUOTADeploymentNextWave,
+			storage.FUOTADeploymentMulticastCreate,
+			storage.FUOTADeploymentMulticastSetup,
+			storage.FUOTADeploymentFragmentationSessSetup,
+			storage.FUOTADeploymentMulticastSessCSetup,
+			storage.FUOTADeploymentEnqueue,
+			storage.FUOTADeploymentUnicastDelivery,
+			storage.FUOTADeploymentStatusRequest,
+			storage.FUOTADeploymentSetDeviceStatus:
+		default:
+			return grpc.Errorf(codes.FailedPrecondition, "fuota deployment in state %s can not be paused", fd.State)
+		}
+
+		fd.PreviousState = fd.State
+		fd.State = storage.FUOTADeploymentPaused
+		return nil
+	})
+}
+
+// Resume resumes the given (paused) FUOTA deployment. A halted deployment
+// continues with the next wave, the end of the start-time window is
+// removed.
+func (f *FUOTADeploymentAPI) Resume(ctx context.Context, req *FUOTADeploymentStateRequest) (*FUOTADeploymentStateResponse, error) {
+	return f.updateState(ctx, req, func(fd *storage.FUOTADeployment) error {
+		switch fd.State {
+		case storage.FUOTADeploymentPaused:
+			fd.State = fd.PreviousState
+			fd.PreviousState = ""
+		case storage.FUOTADeploymentHalted:
+			fd.State = storage.FUOTADeploymentNextWave
+			fd.StartBefore = nil
+			fd.NextStepAfter = time.Now()
+		default:
+			return grpc.Errorf(codes.FailedPrecondition, "fuota deployment in state %s can not be resumed", fd.State)
+		}
+
+		return nil
+	})
+}
+
+// CreateFUOTADeploymentFromImageRequest defines the request for creating a
+// FUOTA deployment for a firmware image. Either the DevEUI or the
+// MulticastGroupID must be set.
+type CreateFUOTADeploymentFromImageRequest struct {
+	// Firmware image ID.
+	FirmwareImageID string `json:"firmwareImageID"`
+
+	// Device EUI (HEX encoded).
+	DevEUI string `json:"devEUI"`
+
+	// Multicast-group ID.
+	MulticastGroupID string `json:"multicastGroupID"`
+
+	// Name of the deployment.
+	Name string `json:"name"`
+
+	// Multicast type (CLASS_A or CLASS_C). With CLASS_A, the fragments are
+	// sent to each device through the device-queue.
+	GroupType string `json:"groupType"`
+
+	// Data-rate (device and CLASS_A deployments only, multicast-group
+	// deployments use the data-rate of the multicast-group).
+	DR int `json:"dr"`
+
+	// Frequency (Hz) (device deployments only, multicast-group deployments
+	// use the frequency of the multicast-group).
+	Frequency int `json:"frequency"`
+
+	// Redundancy (number of packages).
+	Redundancy int `json:"redundancy"`
+
+	// Multicast time-out (this defines the 2^timeout seconds).
+	MulticastTimeout int `json:"multicastTimeout"`
+
+	// Unicast time-out (e.g. 60s).
+	UnicastTimeout string `json:"unicastTimeout"`
+
+	// Fragmentation algorithm.
+	FragAlgo uint8 `json:"fragAlgo"`
+
+	// The deployment does not start before this time (multicast-group
+	// deployments only).
+	StartAfter *time.Time `json:"startAfter"`
+
+	// The deployment is halted when a wave could not be started before
+	// this time (multicast-group deployments only).
+	StartBefore *time.Time `json:"startBefore"`
+
+	// Cumulative percentages of the devices per wave, e.g. [1, 10, 100]
+	// (multicast-group deployments only). The last wave must be 100.
+	Waves []int `json:"waves"`
+
+	// Min. success-rate (0 - 100) of a wave before the next wave is
+	// started. When not met, the deployment is halted.
+	WaveSuccessThreshold float64 `json:"waveSuccessThreshold"`
+}
+
+// CreateFUOTADeploymentFromImageResponse defines the response of creating a
+// FUOTA deployment for a firmware image.
+type CreateFUOTADeploymentFromImageResponse struct {
+	// FUOTA deployment ID.
+	ID string `json:"id"`
+}
+
+// FUOTADeploymentFirmwareImageRequest defines the request for getting the
+// firmware image of a FUOTA deployment.
+type FUOTADeploymentFirmwareImageRequest struct {
+	// FUOTA deployment ID.
+	ID string `json:"id"`
+}
+
+// CreateFromImage creates a deployment for the given firmware image. The
+// image is referenced by the deployment instead of copied and the
+// descriptor is set from the image metadata. The image must belong to the
+// organization of the device or multicast-group and must be compatible
+// with the device-profiles of the devices.
+func (f *FUOTADeploymentAPI) CreateFromImage(ctx context.Context, req *CreateFUOTADeploymentFromImageRequest) (*CreateFUOTADeploymentFromImageResponse, error) {
+	fiID, err := uuid.FromString(req.FirmwareImageID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "firmwareImageID: %s", err)
+	}
+
+	if (req.DevEUI == "") == (req.MulticastGroupID == "") {
+		return nil, grpc.Errorf(codes.InvalidArgument, "either devEUI or multicastGroupID must be given")
+	}
+
+	fd := storage.FUOTADeployment{
+		Name:                 req.Name,
+		DR:                   req.DR,
+		Frequency:            req.Frequency,
+		Redundancy:           req.Redundancy,
+		MulticastTimeout:     req.MulticastTimeout,
+		FragmentationMatrix:  req.FragAlgo,
+		FirmwareImageID:      &fiID,
+		StartAfter:           req.StartAfter,
+		StartBefore:          req.StartBefore,
+		Waves:                req.Waves,
+		WaveSuccessThreshold: req.WaveSuccessThreshold,
+	}
+
+	switch req.GroupType {
+	case "CLASS_A":
+		fd.GroupType = storage.FUOTADeploymentGroupTypeA
+	case pb.MulticastGroupType_CLASS_C.String():
+		fd.GroupType = storage.FUOTADeploymentGroupTypeC
+	default:
+		return nil, grpc.Errorf(codes.InvalidArgument, "group_type %s is not supported", req.GroupType)
+	}
+
+	fd.UnicastTimeout, err = time.ParseDuration(req.UnicastTimeout)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "unicastTimeout: %s", err)
+	}
+
+	var organizationID int64
+	var devices []storage.Device
+	var devEUI lorawan.EUI64
+	var mgID uuid.UUID
+	var n storage.NetworkServer
+
+	if req.DevEUI != "" {
+		if fd.Staged() {
+			return nil, grpc.Errorf(codes.InvalidArgument, "waves and start-time window are only supported for multicast-group deployments")
+		}
+
+		if err := devEUI.UnmarshalText([]byte(req.DevEUI)); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "devEUI: %s", err)
+		}
+
+		if err := f.validator.Validate(ctx,
+			auth.ValidateFUOTADeploymentsAccess(auth.Create, 0, devEUI)); err != nil {
+			return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+		}
+
+		d, err := storage.GetDevice(ctx, storage.DB(), devEUI, false, true)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		app, err := storage.GetApplication(ctx, storage.DB(), d.ApplicationID)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		organizationID = app.OrganizationID
+		devices = append(devices, d)
+
+		n, err = storage.GetNetworkServerForDevEUI(ctx, storage.DB(), devEUI)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+	} else {
+		mgID, err = uuid.FromString(req.MulticastGroupID)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "multicastGroupID: %s", err)
+		}
+
+		mg, err := storage.GetMulticastGroup(ctx, storage.DB(), mgID, false, false)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		sp, err := storage.GetServiceProfile(ctx, storage.DB(), mg.ServiceProfileID, true)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+
+		if err := f.validator.Validate(ctx,
+			auth.ValidateMulticastGroupsAccess(auth.Create, sp.OrganizationID)); err != nil {
+			return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+		}
+		organizationID = sp.OrganizationID
+		fd.MulticastGroupID = &mgID
+		if fd.GroupType != storage.FUOTADeploymentGroupTypeA {
+			fd.DR = int(mg.MulticastGroup.Dr)
+			fd.Frequency = int(mg.MulticastGroup.Frequency)
+		}
+
+		count, err := storage.GetDeviceCountForMulticastGroup(ctx, storage.DB(), mgID)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		items, err := storage.GetDevicesForMulticastGroup(ctx, storage.DB(), mgID, count, 0)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+		for _, item := range items {
+			devices = append(devices, item.Device)
+		}
+
+		n, err = storage.GetNetworkServerForMulticastGroupID(ctx, storage.DB(), mgID)
+		if err != nil {
+			return nil, helpers.ErrToRPCError(err)
+		}
+	}
+
+	fi, err := storage.GetFirmwareImage(ctx, storage.DB(), fiID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	if fi.OrganizationID != organizationID {
+		return nil, grpc.Errorf(codes.InvalidArgument, "firmware image does not belong to the organization")
+	}
+	for _, d := range devices {
+		if !fi.CompatibleWith(d.DeviceProfileID) {
+			return nil, grpc.Errorf(codes.FailedPrecondition, "firmware image is not compatible with device %s", d.DevEUI)
+		}
+	}
+
+	fd.Descriptor = fi.FUOTADescriptor()
+	fd.FragSize, err = getFUOTAFragSize(ctx, n, fd.DR)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storage.Transaction(func(db sqlx.Ext) error {
+		if fd.MulticastGroupID != nil {
+			return storage.CreateFUOTADeploymentForGroup(ctx, db, &fd, mgID)
+		}
+		return storage.CreateFUOTADeploymentForDevice(ctx, db, &fd, devEUI)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &CreateFUOTADeploymentFromImageResponse{
+		ID: fd.ID.String(),
+	}, nil
+}
+
+// GetFirmwareImage returns the firmware image of the given FUOTA deployment.
+func (f *FUOTADeploymentAPI) GetFirmwareImage(ctx context.Context, req *FUOTADeploymentFirmwareImageRequest) (*GetFirmwareImageResponse, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	err = f.validator.Validate(ctx,
+		auth.ValidateFUOTADeploymentAccess(auth.Read, id),
+	)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	fd, err := storage.GetFUOTADeployment(ctx, storage.DB(), id, false)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if fd.FirmwareImageID == nil {
+		return nil, grpc.Errorf(codes.NotFound, "fuota deployment does not reference a firmware image")
+	}
+
+	fi, err := storage.GetFirmwareImage(ctx, storage.DB(), *fd.FirmwareImageID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetFirmwareImageResponse{
+		FirmwareImage: firmwareImageToAPI(fi),
+	}, nil
+}
+
+// updateState validates the access to the given FUOTA deployment and
+// updates it using the given function.
+func (f *FUOTADeploymentAPI) updateState(ctx context.Context, req *FUOTADeploymentStateRequest, fn func(*storage.FUOTADeployment) error) (*FUOTADeploymentStateResponse, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	err = f.validator.Validate(ctx,
+		auth.ValidateFUOTADeploymentAccess(auth.Update, id),
+	)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	var fd storage.FUOTADeployment
+	var prevState storage.FUOTADeploymentState
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		fd, err = storage.GetFUOTADeployment(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		prevState = fd.State
+
+		if err := fn(&fd); err != nil {
+			return err
+		}
+
+		return storage.UpdateFUOTADeployment(ctx, tx, &fd)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	fuota.SendDeploymentStateEvent(ctx, storage.DB(), fd, prevState)
+
+	return &FUOTADeploymentStateResponse{
+		State: string(fd.State),
+	}, nil
+}
+
 func (f *FUOTADeploymentAPI) returnList(count int, deployments []storage.FUOTADeploymentListItem) (*pb.ListFUOTADeploymentResponse, error) {
 	var err error
 
@@ -579,3 +840,185 @@ func (f *FUOTADeploymentAPI) returnList(count int, deployments []storage.FUOTADe
 
 	return &resp, nil
 }
+
+// getFUOTAFragSize returns the fragment size for the given data-rate, based
+// on the region of the network-server. The fragment size is the max.
+// payload size minus the fragmentation header.
+func getFUOTAFragSize(ctx context.Context, n storage.NetworkServer, dr int) (int, error) {
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return 0, helpers.ErrToRPCError(err)
+	}
+
+	versionResp, err := nsClient.GetVersion(ctx, &empty.Empty{})
+	if err != nil {
+		return 0, helpers.ErrToRPCError(err)
+	}
+
+	var b band.Band
+
+	switch versionResp.Region {
+	case common.Region_EU868:
+		b, err = band.GetConfig(band.EU868, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_US915:
+		b, err = band.GetConfig(band.US915, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_CN779:
+		b, err = band.GetConfig(band.CN779, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_EU433:
+		b, err = band.GetConfig(band.EU433, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_AU915:
+		b, err = band.GetConfig(band.AU915, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_CN470:
+		b, err = band.GetConfig(band.CN470, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_AS923:
+		b, err = band.GetConfig(band.AS923, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_KR920:
+		b, err = band.GetConfig(band.KR920, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_IN865:
+		b, err = band.GetConfig(band.IN865, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	case common.Region_RU864:
+		b, err = band.GetConfig(band.RU864, false, lorawan.DwellTimeNoLimit)
+		if err != nil {
+			return 0, helpers.ErrToRPCError(err)
+		}
+	default:
+		return 0, grpc.Errorf(codes.Internal, "region %s is not implemented", versionResp.Region)
+	}
+
+	maxPLSize, err := b.GetMaxPayloadSizeForDataRateIndex("", "", dr)
+	if err != nil {
+		return 0, helpers.ErrToRPCError(err)
+	}
+
+	return maxPLSize.N - 3, nil
+}
+
+// FUOTADeploymentRolloutRequest defines the request for getting the rollout
+// status of a FUOTA deployment.
+type FUOTADeploymentRolloutRequest struct {
+	// FUOTA deployment ID.
+	ID string `json:"id"`
+}
+
+// FUOTADeploymentWave contains the device states of a deployment wave.
+type FUOTADeploymentWave struct {
+	// Wave (0 is the first wave).
+	Wave int `json:"wave"`
+
+	// Number of devices in the wave.
+	Total int `json:"total"`
+
+	// Number of devices waiting for the wave to start.
+	Waiting int `json:"waiting"`
+
+	// Number of pending devices.
+	Pending int `json:"pending"`
+
+	// Number of devices that completed the deployment.
+	Success int `json:"success"`
+
+	// Number of devices that failed the deployment.
+	Error int `json:"error"`
+
+	// Success-rate (0 - 100).
+	SuccessRate float64 `json:"successRate"`
+}
+
+// FUOTADeploymentRolloutResponse defines the rollout status of a FUOTA
+// deployment.
+type FUOTADeploymentRolloutResponse struct {
+	// FUOTA deployment state.
+	State string `json:"state"`
+
+	// Start-time window.
+	StartAfter  *time.Time `json:"startAfter"`
+	StartBefore *time.Time `json:"startBefore"`
+
+	// Cumulative percentages of the devices per wave.
+	Waves []int `json:"waves"`
+
+	// Current wave (0 is the first wave).
+	CurrentWave int `json:"currentWave"`
+
+	// Min. success-rate of a wave before the next wave is started.
+	WaveSuccessThreshold float64 `json:"waveSuccessThreshold"`
+
+	// Device states per wave.
+	Result []FUOTADeploymentWave `json:"result"`
+}
+
+// GetRollout returns the rollout status of the given FUOTA deployment.
+func (f *FUOTADeploymentAPI) GetRollout(ctx context.Context, req *FUOTADeploymentRolloutRequest) (*FUOTADeploymentRolloutResponse, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	err = f.validator.Validate(ctx,
+		auth.ValidateFUOTADeploymentAccess(auth.Read, id),
+	)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	fd, err := storage.GetFUOTADeployment(ctx, storage.DB(), id, false)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	stats, err := storage.GetFUOTADeploymentWaveStats(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := FUOTADeploymentRolloutResponse{
+		State:                string(fd.State),
+		StartAfter:           fd.StartAfter,
+		StartBefore:          fd.StartBefore,
+		Waves:                fd.Waves,
+		CurrentWave:          fd.CurrentWave,
+		WaveSuccessThreshold: fd.WaveSuccessThreshold,
+		Result:               make([]FUOTADeploymentWave, 0, len(stats)),
+	}
+
+	for _, s := range stats {
+		resp.Result = append(resp.Result, FUOTADeploymentWave{
+			Wave:        s.Wave,
+			Total:       s.Total,
+			Waiting:     s.Waiting,
+			Pending:     s.Pending,
+			Success:     s.Success,
+			Error:       s.Error,
+			SuccessRate: s.SuccessRate(),
+		})
+	}
+
+	return &resp, nil
+}
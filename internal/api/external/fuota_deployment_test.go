@@ -8,6 +8,8 @@ import (
 	"github.com/golang/protobuf/ptypes"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/brocaar/lorawan"
 	pb "github.com/gyh1621/chirpstack-api/go/v3/as/external/api"
@@ -211,5 +213,38 @@ func (ts *APITestSuite) TestFUOTADeployment() {
 			assert.EqualValues(1, resp.TotalCount)
 			assert.Len(resp.Result, 1)
 		})
+
+		t.Run("Pause and Resume", func(t *testing.T) {
+			assert := require.New(t)
+
+			stateResp, err := api.Pause(context.Background(), &FUOTADeploymentStateRequest{ID: resp.Id})
+			assert.NoError(err)
+			assert.Equal("PAUSED", stateResp.State)
+
+			_, err = api.Pause(context.Background(), &FUOTADeploymentStateRequest{ID: resp.Id})
+			assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+
+			stateResp, err = api.Resume(context.Background(), &FUOTADeploymentStateRequest{ID: resp.Id})
+			assert.NoError(err)
+			assert.Equal("MC_CREATE", stateResp.State)
+
+			_, err = api.Resume(context.Background(), &FUOTADeploymentStateRequest{ID: resp.Id})
+			assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+		})
+
+		t.Run("Cancel", func(t *testing.T) {
+			assert := require.New(t)
+
+			stateResp, err := api.Cancel(context.Background(), &FUOTADeploymentStateRequest{ID: resp.Id})
+			assert.NoError(err)
+			assert.Equal("CANCEL", stateResp.State)
+
+			fd, err := storage.GetFUOTADeployment(context.Background(), storage.DB(), uuid.FromStringOrNil(resp.Id), false)
+			assert.NoError(err)
+			assert.Equal(storage.FUOTADeploymentMulticastCreate, fd.PreviousState)
+
+			_, err = api.Cancel(context.Background(), &FUOTADeploymentStateRequest{ID: resp.Id})
+			assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+		})
 	})
 }
@@ -0,0 +1,94 @@
+package external
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// syncUserGroups updates the global admin flag and the organization
+// memberships of the given user, based on the groups of which the user is
+// a member (e.g. LDAP groups). When adminGroup is blank, the global admin
+// flag is not changed. Only the memberships of the organizations referenced
+// by the mappings are created, updated or removed, other memberships are
+// left untouched. The caller must update the user afterwards.
+func syncUserGroups(ctx context.Context, db sqlx.Ext, user *storage.User, groups []string, adminGroup string, mappings []config.GroupMappingConfig) error {
+	if adminGroup != "" {
+		user.IsAdmin = hasGroup(groups, adminGroup)
+	}
+
+	// the memberships of all referenced organizations are managed, the
+	// flags of multiple matching groups are combined
+	managed := make(map[int64]*storage.OrganizationUser)
+	var orgIDs []int64
+	for _, m := range mappings {
+		if _, ok := managed[m.OrganizationID]; !ok {
+			managed[m.OrganizationID] = nil
+			orgIDs = append(orgIDs, m.OrganizationID)
+		}
+
+		if !hasGroup(groups, m.Group) {
+			continue
+		}
+
+		ou := managed[m.OrganizationID]
+		if ou == nil {
+			ou = &storage.OrganizationUser{}
+			managed[m.OrganizationID] = ou
+		}
+		ou.IsAdmin = ou.IsAdmin || m.IsAdmin
+		ou.IsDeviceAdmin = ou.IsDeviceAdmin || m.IsDeviceAdmin
+		ou.IsGatewayAdmin = ou.IsGatewayAdmin || m.IsGatewayAdmin
+	}
+
+	for _, orgID := range orgIDs {
+		want := managed[orgID]
+
+		current, err := storage.GetOrganizationUser(ctx, db, orgID, user.ID)
+		if err != nil && errors.Cause(err) != storage.ErrDoesNotExist {
+			return errors.Wrap(err, "get organization user error")
+		}
+		exists := err == nil
+
+		switch {
+		case want == nil && exists:
+			err = storage.DeleteOrganizationUser(ctx, db, orgID, user.ID)
+		case want != nil && !exists:
+			err = storage.CreateOrganizationUser(ctx, db, orgID, user.ID, want.IsAdmin, want.IsDeviceAdmin, want.IsGatewayAdmin)
+		case want != nil && (current.IsAdmin != want.IsAdmin || current.IsDeviceAdmin != want.IsDeviceAdmin || current.IsGatewayAdmin != want.IsGatewayAdmin):
+			err = storage.UpdateOrganizationUser(ctx, db, orgID, user.ID, want.IsAdmin, want.IsDeviceAdmin, want.IsGatewayAdmin)
+		default:
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "sync organization user error")
+		}
+
+		log.WithFields(log.Fields{
+			"user_id":         user.ID,
+			"organization_id": orgID,
+			"member":          want != nil,
+			"ctx_id":          ctx.Value(logging.ContextIDKey),
+		}).Info("api/external: organization membership synchronized from groups")
+	}
+
+	return nil
+}
+
+// hasGroup returns true when the given group is in groups. Groups are
+// compared case-insensitive, as LDAP DNs are case-insensitive.
+func hasGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(strings.TrimSpace(g), strings.TrimSpace(group)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,290 @@
+package external
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/mux"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// httpRoute defines an API method which is exposed as JSON endpoint.
+// These are API methods which are not (yet) part of the gRPC API
+// definitions. The handler must be of type
+// func(context.Context, *Request) (*Response, error).
+type httpRoute struct {
+	method  string
+	path    string
+	handler interface{}
+}
+
+// getHTTPRoutes returns the JSON endpoints, which are registered in front
+// of the gRPC gateway.
+func getHTTPRoutes(validator auth.Validator) []httpRoute {
+	applicationAPI := NewApplicationAPI(validator)
+	auditLogAPI := NewAuditLogAPI(validator)
+	deviceAPI := NewDeviceAPI(validator)
+	deviceProfileAPI := NewDeviceProfileServiceAPI(validator)
+	fuotaDeploymentAPI := NewFUOTADeploymentAPI(validator)
+	firmwareImageAPI := NewFirmwareImageAPI(validator)
+	internalAPI := NewInternalAPI(validator)
+	organizationAPI := NewOrganizationAPI(validator)
+	roleAPI := NewRoleAPI(validator)
+	userAPI := NewUserAPI(validator)
+	// the routing-profile ID is only used by the gRPC multicast-group API
+	multicastGroupAPI := NewMulticastGroupAPI(validator, uuid.Nil)
+
+	return []httpRoute{
+		{http.MethodPost, "/api/applications/{id}/codec/test", applicationAPI.TestCodec},
+		{http.MethodGet, "/api/applications/{id}/codec/revisions", applicationAPI.ListCodecRevisions},
+		{http.MethodGet, "/api/applications/{id}/codec/revisions/{revision}", applicationAPI.GetCodecRevision},
+		{http.MethodGet, "/api/applications/{id}/codec/revisions/{revision}/diff", applicationAPI.DiffCodecRevision},
+		{http.MethodPost, "/api/applications/{id}/codec/revisions/{revision}/rollback", applicationAPI.RollbackCodecRevision},
+		{http.MethodPost, "/api/applications/{applicationID}/users", applicationAPI.CreateUser},
+		{http.MethodGet, "/api/applications/{applicationID}/users", applicationAPI.ListUsers},
+		{http.MethodGet, "/api/applications/{applicationID}/users/{userID}", applicationAPI.GetUser},
+		{http.MethodPut, "/api/applications/{applicationID}/users/{userID}", applicationAPI.UpdateUser},
+		{http.MethodDelete, "/api/applications/{applicationID}/users/{userID}", applicationAPI.DeleteUser},
+		{http.MethodGet, "/api/audit-log", auditLogAPI.List},
+		{http.MethodGet, "/api/devices/{devEUI}/application-layer", deviceAPI.GetApplicationLayer},
+		{http.MethodPost, "/api/devices/{devEUI}/application-layer/package-version", deviceAPI.RequestPackageVersion},
+		{http.MethodGet, "/api/devices/{devEUI}/clock-sync", deviceAPI.GetClockSync},
+		{http.MethodPost, "/api/devices/{devEUI}/clock-sync/periodicity", deviceAPI.ClockSyncPeriodicity},
+		{http.MethodPost, "/api/devices/{devEUI}/clock-sync/resync", deviceAPI.ClockSyncResync},
+		{http.MethodPost, "/api/device-profiles/{id}/codec/test", deviceProfileAPI.TestCodec},
+		{http.MethodGet, "/api/device-profiles/{id}/codec/revisions", deviceProfileAPI.ListCodecRevisions},
+		{http.MethodGet, "/api/device-profiles/{id}/codec/revisions/{revision}", deviceProfileAPI.GetCodecRevision},
+		{http.MethodGet, "/api/device-profiles/{id}/codec/revisions/{revision}/diff", deviceProfileAPI.DiffCodecRevision},
+		{http.MethodPost, "/api/device-profiles/{id}/codec/revisions/{revision}/rollback", deviceProfileAPI.RollbackCodecRevision},
+		{http.MethodGet, "/api/device-profiles/{id}/codec/protobuf", deviceProfileAPI.GetProtobufSchema},
+		{http.MethodPut, "/api/device-profiles/{id}/codec/protobuf", deviceProfileAPI.UpdateProtobufSchema},
+		{http.MethodGet, "/api/device-profiles/{id}/application-layer", deviceProfileAPI.GetApplicationLayer},
+		{http.MethodPut, "/api/device-profiles/{id}/application-layer", deviceProfileAPI.UpdateApplicationLayer},
+		{http.MethodPost, "/api/fuota-deployments/{id}/cancel", fuotaDeploymentAPI.Cancel},
+		{http.MethodPost, "/api/fuota-deployments/{id}/pause", fuotaDeploymentAPI.Pause},
+		{http.MethodPost, "/api/fuota-deployments/{id}/resume", fuotaDeploymentAPI.Resume},
+		{http.MethodPost, "/api/fuota-deployments/from-image", fuotaDeploymentAPI.CreateFromImage},
+		{http.MethodGet, "/api/fuota-deployments/{id}/firmware-image", fuotaDeploymentAPI.GetFirmwareImage},
+		{http.MethodGet, "/api/fuota-deployments/{id}/rollout", fuotaDeploymentAPI.GetRollout},
+		{http.MethodPost, "/api/firmware-images", firmwareImageAPI.Create},
+		{http.MethodGet, "/api/firmware-images", firmwareImageAPI.List},
+		{http.MethodGet, "/api/firmware-images/{id}", firmwareImageAPI.Get},
+		{http.MethodPut, "/api/firmware-images/{id}", firmwareImageAPI.Update},
+		{http.MethodDelete, "/api/firmware-images/{id}", firmwareImageAPI.Delete},
+		{http.MethodGet, "/api/internal/api-keys/{id}", internalAPI.GetAPIKey},
+		{http.MethodPut, "/api/internal/api-keys/{id}", internalAPI.UpdateAPIKey},
+		{http.MethodPost, "/api/internal/api-keys/{id}/rotate", internalAPI.RotateAPIKey},
+		{http.MethodPost, "/api/internal/login/password", internalAPI.UpdateExpiredPassword},
+		{http.MethodPost, "/api/internal/password-reset", internalAPI.CreatePasswordReset},
+		{http.MethodPost, "/api/internal/password-reset/confirm", internalAPI.UpdatePasswordReset},
+		{http.MethodPost, "/api/internal/email-verification", internalAPI.CreateEmailVerification},
+		{http.MethodPost, "/api/internal/email-verification/confirm", internalAPI.UpdateEmailVerification},
+		{http.MethodGet, "/api/internal/totp", internalAPI.GetTOTP},
+		{http.MethodPost, "/api/internal/totp", internalAPI.EnrollTOTP},
+		{http.MethodPost, "/api/internal/totp/activate", internalAPI.ActivateTOTP},
+		{http.MethodPost, "/api/internal/totp/recovery-codes", internalAPI.CreateTOTPRecoveryCodes},
+		{http.MethodPost, "/api/internal/totp/disable", internalAPI.DeleteTOTP},
+		{http.MethodGet, "/api/internal/totp/settings", internalAPI.GetTOTPSettings},
+		{http.MethodPut, "/api/internal/totp/settings", internalAPI.UpdateTOTPSettings},
+		{http.MethodPost, "/api/multicast-groups/{id}/remote-setup", multicastGroupAPI.RemoteSetup},
+		{http.MethodGet, "/api/multicast-groups/{id}/remote-setup", multicastGroupAPI.GetRemoteSetup},
+		{http.MethodDelete, "/api/multicast-groups/{id}/remote-setup", multicastGroupAPI.DeleteRemoteSetup},
+		{http.MethodPost, "/api/multicast-groups/{id}/remote-setup/class-c-session", multicastGroupAPI.RemoteClassCSession},
+		{http.MethodPost, "/api/multicast-groups/{id}/clock-sync/periodicity", multicastGroupAPI.ClockSyncPeriodicity},
+		{http.MethodPost, "/api/multicast-groups/{id}/clock-sync/resync", multicastGroupAPI.ClockSyncResync},
+		{http.MethodGet, "/api/organizations/{organizationID}/users/{userID}/role", organizationAPI.GetUserRole},
+		{http.MethodPut, "/api/organizations/{organizationID}/users/{userID}/role", organizationAPI.UpdateUserRole},
+		{http.MethodPost, "/api/roles", roleAPI.Create},
+		{http.MethodGet, "/api/roles", roleAPI.List},
+		{http.MethodGet, "/api/roles/permissions", roleAPI.ListPermissions},
+		{http.MethodGet, "/api/roles/{id}", roleAPI.Get},
+		{http.MethodPut, "/api/roles/{id}", roleAPI.Update},
+		{http.MethodDelete, "/api/roles/{id}", roleAPI.Delete},
+		{http.MethodDelete, "/api/users/{id}/totp", userAPI.DeleteTOTP},
+	}
+}
+
+// setupHTTPRoutes registers the JSON endpoints to the given router.
+func setupHTTPRoutes(r *mux.Router, validator auth.Validator) {
+	for _, route := range getHTTPRoutes(validator) {
+		log.WithFields(log.Fields{
+			"method": route.method,
+			"path":   route.path,
+		}).Debug("api/external: registering json api handler")
+
+		r.Handle(route.path, newHTTPHandler(route.handler, validator)).Methods(route.method)
+	}
+}
+
+// newHTTPHandler wraps the given API method as http.Handler.
+// The request is decoded from the JSON body (for POST and PUT requests), the
+// query parameters and the path variables. The authorization is read from
+// the Grpc-Metadata-Authorization or the Authorization header, as is done
+// by the gRPC gateway. When a validator is given, mutating calls are
+// recorded into the audit log.
+func newHTTPHandler(handler interface{}, validator auth.Validator) http.Handler {
+	f := reflect.ValueOf(handler)
+	reqType := f.Type().In(1).Elem()
+	service, method := auditHTTPHandler(handler)
+
+	call := func(ctx context.Context, req reflect.Value) (interface{}, error) {
+		out := f.Call([]reflect.Value{reflect.ValueOf(ctx), req})
+		err, _ := out[1].Interface().(error)
+		return out[0].Interface(), err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := reflect.New(reqType)
+
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if err := json.NewDecoder(r.Body).Decode(req.Interface()); err != nil && err != io.EOF {
+				writeHTTPError(w, status.Errorf(codes.InvalidArgument, "decode json error: %s", err))
+				return
+			}
+		}
+
+		for k, v := range r.URL.Query() {
+			if len(v) == 0 {
+				continue
+			}
+			if err := setRequestField(req.Elem(), k, v[0]); err != nil {
+				writeHTTPError(w, status.Errorf(codes.InvalidArgument, "%s: %s", k, err))
+				return
+			}
+		}
+
+		for k, v := range mux.Vars(r) {
+			if err := setRequestField(req.Elem(), k, v); err != nil {
+				writeHTTPError(w, status.Errorf(codes.InvalidArgument, "%s: %s", k, err))
+				return
+			}
+		}
+
+		ctx, err := httpRequestContext(r)
+		if err != nil {
+			writeHTTPError(w, status.Errorf(codes.Internal, "%s", err))
+			return
+		}
+
+		var resp interface{}
+		if validator != nil && r.Method != http.MethodGet {
+			resp, err = audit(ctx, validator, nil, service, method, req.Interface(), func(ctx context.Context) (interface{}, error) {
+				return call(ctx, req)
+			})
+		} else {
+			resp, err = call(ctx, req)
+		}
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.WithError(err).Error("api/external: encode json response error")
+		}
+	})
+}
+
+func httpRequestContext(r *http.Request) (context.Context, error) {
+	ctxID, err := uuid.NewV4()
+	if err != nil {
+		return nil, fmt.Errorf("new uuid error: %s", err)
+	}
+
+	md := metadata.MD{}
+	for _, h := range []string{"Grpc-Metadata-Authorization", "Authorization"} {
+		if v := r.Header.Get(h); v != "" {
+			md.Set("authorization", v)
+			break
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), logging.ContextIDKey, ctxID)
+
+	// as is done by the gRPC gateway, the remote address is appended to the
+	// X-Forwarded-For header and is set as peer
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		xff := addr.IP.String()
+		if v := r.Header.Get("X-Forwarded-For"); v != "" {
+			xff = v + ", " + xff
+		}
+		md.Set("x-forwarded-for", xff)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+
+	return metadata.NewIncomingContext(ctx, md), nil
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	s, _ := status.FromError(err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(runtime.HTTPStatusFromCode(s.Code()))
+	json.NewEncoder(w).Encode(struct {
+		Error   string `json:"error"`
+		Code    int32  `json:"code"`
+		Message string `json:"message"`
+	}{
+		Error:   s.Message(),
+		Code:    int32(s.Code()),
+		Message: s.Message(),
+	})
+}
+
+// setRequestField sets the request field matching the given JSON name.
+func setRequestField(v reflect.Value, name, value string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if tag != name {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetInt(i)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			i, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetUint(i)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return err
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("unsupported field type: %s", field.Kind())
+		}
+		return nil
+	}
+
+	// unknown parameters are ignored
+	return nil
+}
@@ -0,0 +1,170 @@
+package external
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+type testHTTPRequest struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Limit int    `json:"limit"`
+}
+
+type testHTTPResponse struct {
+	Request       testHTTPRequest `json:"request"`
+	Authorization string          `json:"authorization"`
+}
+
+func TestHTTPHandler(t *testing.T) {
+	handler := func(ctx context.Context, req *testHTTPRequest) (*testHTTPResponse, error) {
+		if req.Name == "error" {
+			return nil, grpc.Errorf(codes.NotFound, "object does not exist")
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		resp := testHTTPResponse{
+			Request: *req,
+		}
+		if v := md.Get("authorization"); len(v) != 0 {
+			resp.Authorization = v[0]
+		}
+		return &resp, nil
+	}
+
+	r := mux.NewRouter()
+	r.Handle("/api/test/{id}", newHTTPHandler(handler, nil)).Methods("POST")
+
+	tests := []struct {
+		Name           string
+		Body           string
+		URL            string
+		ExpectedStatus int
+		ExpectedBody   string
+	}{
+		{
+			Name:           "body, query and path parameters",
+			Body:           `{"name": "foo"}`,
+			URL:            "/api/test/10?limit=5",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   `{"request":{"id":10,"name":"foo","limit":5},"authorization":"Bearer secret"}`,
+		},
+		{
+			Name:           "empty body",
+			URL:            "/api/test/10",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   `{"request":{"id":10,"name":"","limit":0},"authorization":"Bearer secret"}`,
+		},
+		{
+			Name:           "invalid path parameter",
+			URL:            "/api/test/abc",
+			ExpectedStatus: http.StatusBadRequest,
+			ExpectedBody:   `{"error":"id: strconv.ParseInt: parsing \"abc\": invalid syntax","code":3,"message":"id: strconv.ParseInt: parsing \"abc\": invalid syntax"}`,
+		},
+		{
+			Name:           "api error",
+			Body:           `{"name": "error"}`,
+			URL:            "/api/test/10",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   `{"error":"object does not exist","code":5,"message":"object does not exist"}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			req := httptest.NewRequest("POST", tst.URL, strings.NewReader(tst.Body))
+			req.Header.Set("Grpc-Metadata-Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+			assert.Equal(tst.ExpectedStatus, w.Code)
+			assert.JSONEq(tst.ExpectedBody, w.Body.String())
+		})
+	}
+}
+
+func TestLoginClientIP(t *testing.T) {
+	assert := require.New(t)
+
+	proxy, err := parseTrustedProxy("10.0.0.0/8")
+	assert.NoError(err)
+	lb, err := parseTrustedProxy("192.0.2.10")
+	assert.NoError(err)
+	trustedProxies = []*net.IPNet{proxy, lb}
+	defer func() { trustedProxies = nil }()
+
+	tests := []struct {
+		Name          string
+		RemoteAddr    string
+		XForwardedFor string
+		ExpectedIP    string
+	}{
+		{
+			Name:       "direct request",
+			RemoteAddr: "198.51.100.1:1234",
+			ExpectedIP: "198.51.100.1",
+		},
+		{
+			Name:          "spoofed x-forwarded-for from untrusted client",
+			RemoteAddr:    "198.51.100.1:1234",
+			XForwardedFor: "203.0.113.1",
+			ExpectedIP:    "198.51.100.1",
+		},
+		{
+			Name:          "trusted proxy",
+			RemoteAddr:    "10.0.0.1:1234",
+			XForwardedFor: "198.51.100.1",
+			ExpectedIP:    "198.51.100.1",
+		},
+		{
+			Name:          "spoofed x-forwarded-for through trusted proxies",
+			RemoteAddr:    "10.0.0.1:1234",
+			XForwardedFor: "203.0.113.1, 198.51.100.1, 192.0.2.10",
+			ExpectedIP:    "198.51.100.1",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			req := httptest.NewRequest("POST", "/api/test", nil)
+			req.RemoteAddr = tst.RemoteAddr
+			if tst.XForwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tst.XForwardedFor)
+			}
+
+			ctx, err := httpRequestContext(req)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedIP, loginClientIP(ctx))
+		})
+	}
+
+	t.Run("grpc-gateway", func(t *testing.T) {
+		assert := require.New(t)
+
+		// the gRPC gateway connects over the loopback interface and appends
+		// the remote address of the client
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "203.0.113.1, 198.51.100.1"))
+		assert.Equal("198.51.100.1", loginClientIP(ctx))
+
+		// gRPC client setting the metadata directly
+		ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1234}})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "203.0.113.1"))
+		assert.Equal("198.51.100.1", loginClientIP(ctx))
+	})
+}
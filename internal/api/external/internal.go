@@ -7,11 +7,13 @@ import (
 	"github.com/gofrs/uuid"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/gyh1621/chirpstack-api/go/v3/as/external/api"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
@@ -33,9 +35,16 @@ func NewInternalAPI(validator auth.Validator) *InternalAPI {
 }
 
 // Login validates the login request and returns a JWT token.
+// When two-factor authentication is enabled for the user, the TOTP code or
+// a recovery code must be given as totp-code or recovery-code request
+// metadata.
 func (a *InternalAPI) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
-	jwt, err := storage.LoginUserByPassword(ctx, storage.DB(), req.Email, req.Password)
-	if nil != err {
+	md, _ := metadata.FromIncomingContext(ctx)
+	totpCode := firstMetadataValue(md, totpCodeMetadataKey)
+	recoveryCode := firstMetadataValue(md, recoveryCodeMetadataKey)
+
+	_, jwt, err := a.login(ctx, req.Email, req.Password, totpCode, recoveryCode, false)
+	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
@@ -315,7 +324,12 @@ func (a *InternalAPI) OpenIDConnectLogin(ctx context.Context, req *pb.OpenIDConn
 			if err != nil {
 				// we did not find the user by external_id or email and registration is enabled.
 				if err == storage.ErrDoesNotExist && registrationEnabled {
-					user, err = a.createAndProvisionUser(ctx, oidcUser)
+					user, err = a.createAndProvisionUser(ctx, storage.User{
+						IsActive:      true,
+						Email:         oidcUser.Email,
+						EmailVerified: oidcUser.EmailVerified,
+						ExternalID:    &oidcUser.ExternalID,
+					})
 					if err != nil {
 						return nil, helpers.ErrToRPCError(err)
 					}
@@ -329,11 +343,18 @@ func (a *InternalAPI) OpenIDConnectLogin(ctx context.Context, req *pb.OpenIDConn
 		}
 	}
 
-	// update the user
+	// update the user and (when configured) the group based memberships
 	user.Email = oidcUser.Email
 	user.EmailVerified = oidcUser.EmailVerified
-	if err := storage.UpdateUser(ctx, storage.DB(), &user); err != nil {
-		fmt.Println("SDFSDFSDFSDF")
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if oidcGroupsClaim != "" {
+			if err := syncUserGroups(ctx, tx, &user, oidcUser.Groups, oidcAdminGroup, oidcGroupMapping); err != nil {
+				return err
+			}
+		}
+		return storage.UpdateUser(ctx, tx, &user)
+	})
+	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
@@ -348,14 +369,9 @@ func (a *InternalAPI) OpenIDConnectLogin(ctx context.Context, req *pb.OpenIDConn
 	}, nil
 }
 
-func (a *InternalAPI) createAndProvisionUser(ctx context.Context, user oidc.User) (storage.User, error) {
-	u := storage.User{
-		IsActive:      true,
-		Email:         user.Email,
-		EmailVerified: user.EmailVerified,
-		ExternalID:    &user.ExternalID,
-	}
-
+// createAndProvisionUser creates the given user and, when configured, calls
+// the registration callback. When the callback fails, the user is removed.
+func (a *InternalAPI) createAndProvisionUser(ctx context.Context, u storage.User) (storage.User, error) {
 	if err := storage.CreateUser(ctx, storage.DB(), &u); err != nil {
 		return storage.User{}, errors.Wrap(err, "create user error")
 	}
@@ -0,0 +1,194 @@
+package external
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// APIKeyDetails defines the API key details, including the expiration,
+// scopes and rotation state.
+type APIKeyDetails struct {
+	// API key ID.
+	ID string `json:"id"`
+
+	// Name of the API key.
+	Name string `json:"name"`
+
+	// The API key is an admin API key.
+	IsAdmin bool `json:"isAdmin"`
+
+	// Organization ID (in case of an organization API key).
+	OrganizationID int64 `json:"organizationID,string"`
+
+	// Application ID (in case of an application API key).
+	ApplicationID int64 `json:"applicationID,string"`
+
+	// Expiration timestamp.
+	// When not set, the API key does not expire.
+	ExpiresAt *time.Time `json:"expiresAt"`
+
+	// Scopes (read, gateway-read, device-queue-enqueue).
+	// When empty, the API key is not restricted by scope.
+	Scopes []string `json:"scopes"`
+
+	// Last used timestamp (approximate).
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+
+	// Timestamp until which the previous token is valid after a rotation.
+	PreviousTokenExpiresAt *time.Time `json:"previousTokenExpiresAt"`
+
+	// Created at timestamp.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKeyRequest defines the request for getting an API key.
+type APIKeyRequest struct {
+	// API key ID.
+	ID string `json:"id"`
+}
+
+// GetAPIKeyResponse defines the get API key response.
+type GetAPIKeyResponse struct {
+	APIKey APIKeyDetails `json:"apiKey"`
+}
+
+// UpdateAPIKeyRequest defines the request for updating an API key.
+// Only the name, expiration and scopes can be updated.
+type UpdateAPIKeyRequest struct {
+	// API key ID.
+	ID string `json:"id"`
+
+	APIKey APIKeyDetails `json:"apiKey"`
+}
+
+// RotateAPIKeyRequest defines the request for rotating the token of an API
+// key.
+type RotateAPIKeyRequest struct {
+	// API key ID.
+	ID string `json:"id"`
+
+	// Grace period (seconds) during which the current token remains valid.
+	GracePeriod int64 `json:"gracePeriod"`
+}
+
+// RotateAPIKeyResponse defines the rotate API key response.
+type RotateAPIKeyResponse struct {
+	// New JWT token for the API key.
+	JWTToken string `json:"jwtToken"`
+}
+
+// GetAPIKey returns the details of the given API key.
+func (a *InternalAPI) GetAPIKey(ctx context.Context, req *APIKeyRequest) (*GetAPIKeyResponse, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateAPIKeyAccess(auth.Read, id)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	ak, err := storage.GetAPIKey(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := GetAPIKeyResponse{
+		APIKey: APIKeyDetails{
+			ID:                     ak.ID.String(),
+			Name:                   ak.Name,
+			IsAdmin:                ak.IsAdmin,
+			ExpiresAt:              ak.ExpiresAt,
+			Scopes:                 []string(ak.Scopes),
+			LastUsedAt:             ak.LastUsedAt,
+			PreviousTokenExpiresAt: ak.PreviousTokenExpiresAt,
+			CreatedAt:              ak.CreatedAt,
+		},
+	}
+
+	if ak.OrganizationID != nil {
+		resp.APIKey.OrganizationID = *ak.OrganizationID
+	}
+
+	if ak.ApplicationID != nil {
+		resp.APIKey.ApplicationID = *ak.ApplicationID
+	}
+
+	return &resp, nil
+}
+
+// UpdateAPIKey updates the name, expiration and scopes of the given API key.
+func (a *InternalAPI) UpdateAPIKey(ctx context.Context, req *UpdateAPIKeyRequest) (*empty.Empty, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateAPIKeyAccess(auth.Update, id)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	for _, s := range req.APIKey.Scopes {
+		if !auth.IsValidScope(s) {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid scope: %s", s)
+		}
+	}
+
+	ak, err := storage.GetAPIKey(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	ak.Name = req.APIKey.Name
+	ak.ExpiresAt = req.APIKey.ExpiresAt
+	ak.Scopes = req.APIKey.Scopes
+
+	if err := storage.UpdateAPIKey(ctx, storage.DB(), &ak); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// RotateAPIKey issues a new token for the given API key. The current token
+// remains valid for the given grace period.
+func (a *InternalAPI) RotateAPIKey(ctx context.Context, req *RotateAPIKeyRequest) (*RotateAPIKeyResponse, error) {
+	id, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err := a.validator.Validate(ctx,
+		auth.ValidateAPIKeyAccess(auth.Update, id)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if req.GracePeriod < 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "gracePeriod must be >= 0")
+	}
+
+	ak, err := storage.GetAPIKey(ctx, storage.DB(), id)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	jwtToken, err := storage.RotateAPIKey(ctx, storage.DB(), &ak, time.Duration(req.GracePeriod)*time.Second)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &RotateAPIKeyResponse{
+		JWTToken: jwtToken,
+	}, nil
+}
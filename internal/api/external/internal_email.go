@@ -0,0 +1,233 @@
+package external
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/email"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/loginlimit"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// CreatePasswordResetRequest defines the request for requesting a password
+// reset.
+type CreatePasswordResetRequest struct {
+	// E-mail address of the user.
+	Email string `json:"email"`
+}
+
+// UpdatePasswordResetRequest defines the request for resetting the password
+// using the token received by e-mail.
+type UpdatePasswordResetRequest struct {
+	// Token received by e-mail.
+	Token string `json:"token"`
+
+	// New password.
+	Password string `json:"password"`
+}
+
+// UpdateEmailVerificationRequest defines the request for verifying the
+// e-mail address using the token received by e-mail.
+type UpdateEmailVerificationRequest struct {
+	// Token received by e-mail.
+	Token string `json:"token"`
+}
+
+// CreatePasswordReset sends a password reset e-mail to the given e-mail
+// address. To prevent the enumeration of users, this does not return an
+// error when the user does not exist or when the max. number of password
+// reset requests for the e-mail address or client IP address has been
+// reached.
+func (a *InternalAPI) CreatePasswordReset(ctx context.Context, req *CreatePasswordResetRequest) (*empty.Empty, error) {
+	if openIDConnectEnabled {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "password authentication is disabled")
+	}
+
+	if !email.Enabled() {
+		return nil, helpers.ErrToRPCError(email.ErrDisabled)
+	}
+
+	ip := loginClientIP(ctx)
+
+	if err := loginlimit.Check(req.Email, ip); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	allowed, err := loginlimit.RegisterPasswordReset(req.Email, ip)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	if !allowed {
+		log.WithFields(log.Fields{
+			"email":  req.Email,
+			"ip":     ip,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Warning("api/external: password reset rate limit reached")
+		return &empty.Empty{}, nil
+	}
+
+	user, err := storage.GetUserByEmail(ctx, storage.DB(), req.Email)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			log.WithFields(log.Fields{
+				"email":  req.Email,
+				"ctx_id": ctx.Value(logging.ContextIDKey),
+			}).Warning("api/external: password reset requested for unknown user")
+			return &empty.Empty{}, nil
+		}
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	// users without password (e.g. OpenID Connect users) can not reset
+	// their password
+	if !user.IsActive || user.PasswordHash == "" {
+		log.WithFields(log.Fields{
+			"user_id": user.ID,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Warning("api/external: password reset requested for inactive user or user without password")
+		return &empty.Empty{}, nil
+	}
+
+	token, err := storage.CreateUserToken(ctx, storage.UserTokenPasswordReset, storage.UserToken{
+		UserID:            user.ID,
+		Email:             user.Email,
+		PasswordChangedAt: user.PasswordChangedAt,
+	}, passwordResetTokenTTL)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := email.SendPasswordReset(user.Email, token, passwordResetTokenTTL); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// UpdatePasswordReset sets the password of the user, using the token
+// received by e-mail. As the token proves the ownership of the e-mail
+// address, the e-mail address is marked as verified.
+func (a *InternalAPI) UpdatePasswordReset(ctx context.Context, req *UpdatePasswordResetRequest) (*empty.Empty, error) {
+	user, err := getUserByToken(ctx, storage.UserTokenPasswordReset, req.Token)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	user.EmailVerified = true
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.UpdateUserPassword(ctx, tx, &user, req.Password)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := loginlimit.Reset(user.Email); err != nil {
+		log.WithError(err).Error("api/external: reset failed login count error")
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// CreateEmailVerification sends a verification e-mail to the e-mail address
+// of the authenticated user.
+func (a *InternalAPI) CreateEmailVerification(ctx context.Context, req *empty.Empty) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateActiveUser()); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	user, err := a.validator.GetUser(ctx)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	if err := sendEmailVerification(ctx, user); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// UpdateEmailVerification marks the e-mail address of the user as verified,
+// using the token received by e-mail.
+func (a *InternalAPI) UpdateEmailVerification(ctx context.Context, req *UpdateEmailVerificationRequest) (*empty.Empty, error) {
+	user, err := getUserByToken(ctx, storage.UserTokenEmailVerification, req.Token)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	user.EmailVerified = true
+
+	if err := storage.UpdateUser(ctx, storage.DB(), &user); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// getUserByToken uses the given token and returns the user to which it
+// belongs. It returns ErrInvalidUserToken when the e-mail address of the
+// user has been changed after the token was sent, or when the user is
+// inactive.
+func getUserByToken(ctx context.Context, purpose, token string) (storage.User, error) {
+	t, err := storage.UseUserToken(ctx, purpose, token)
+	if err != nil {
+		return storage.User{}, err
+	}
+
+	user, err := storage.GetUser(ctx, storage.DB(), t.UserID)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return storage.User{}, storage.ErrInvalidUserToken
+		}
+		return storage.User{}, err
+	}
+
+	if !user.IsActive || user.Email != t.Email {
+		return storage.User{}, storage.ErrInvalidUserToken
+	}
+
+	// password reset tokens are invalidated by a password change, e.g. when
+	// an other password reset token has been used
+	if purpose == storage.UserTokenPasswordReset && !equalTime(user.PasswordChangedAt, t.PasswordChangedAt) {
+		return storage.User{}, storage.ErrInvalidUserToken
+	}
+
+	return user, nil
+}
+
+// sendEmailVerification sends a verification e-mail to the e-mail address
+// of the given user.
+func sendEmailVerification(ctx context.Context, user storage.User) error {
+	if !email.Enabled() {
+		return email.ErrDisabled
+	}
+
+	token, err := storage.CreateUserToken(ctx, storage.UserTokenEmailVerification, storage.UserToken{
+		UserID: user.ID,
+		Email:  user.Email,
+	}, emailVerificationTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return email.SendEmailVerification(user.Email, token, emailVerificationTokenTTL)
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
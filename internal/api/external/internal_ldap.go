@@ -0,0 +1,121 @@
+package external
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/ldap"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// ldapLogin authenticates the user against the LDAP directory. On the first
+// login, the user is created (or an existing user with the same e-mail
+// address is linked, see ldapCanLink). On every login, the e-mail address,
+// the global admin flag and the organization memberships are updated from
+// the directory.
+//
+// When the directory rejects the credentials or is unavailable, users that
+// are not linked to the directory (e.g. the initial admin user) can login
+// using their local password.
+func (a *InternalAPI) ldapLogin(ctx context.Context, login, password string) (storage.User, error) {
+	ldapUser, err := ldap.Authenticate(login, password)
+	if err != nil {
+		if err != ldap.ErrInvalidCredentials {
+			log.WithError(err).WithFields(log.Fields{
+				"login":  login,
+				"ctx_id": ctx.Value(logging.ContextIDKey),
+			}).Error("api/external: ldap authenticate error, falling back to local login")
+		}
+
+		user, err := storage.GetUserByEmailAndPassword(ctx, storage.DB(), login, password)
+		if err != nil {
+			return storage.User{}, err
+		}
+		if user.ExternalID != nil {
+			return storage.User{}, storage.ErrInvalidUsernameOrPassword
+		}
+		return user, nil
+	}
+
+	if ldapUser.Email == "" {
+		return storage.User{}, errors.New("ldap user has no e-mail address")
+	}
+
+	// try to get the user by external ID, then by e-mail address
+	user, err := storage.GetUserByExternalID(ctx, storage.DB(), ldapUser.DN)
+	if err != nil {
+		if errors.Cause(err) != storage.ErrDoesNotExist {
+			return storage.User{}, err
+		}
+
+		user, err = storage.GetUserByEmail(ctx, storage.DB(), ldapUser.Email)
+		if err != nil {
+			if errors.Cause(err) != storage.ErrDoesNotExist {
+				return storage.User{}, err
+			}
+
+			user, err = a.createAndProvisionUser(ctx, storage.User{
+				IsActive:      true,
+				Email:         ldapUser.Email,
+				EmailVerified: true,
+				ExternalID:    &ldapUser.DN,
+			})
+			if err != nil {
+				return storage.User{}, err
+			}
+
+			log.WithFields(log.Fields{
+				"user_id": user.ID,
+				"dn":      ldapUser.DN,
+				"ctx_id":  ctx.Value(logging.ContextIDKey),
+			}).Info("api/external: user created from ldap directory")
+		} else if !ldapCanLink(user, ldapUser.DN) {
+			log.WithFields(log.Fields{
+				"user_id": user.ID,
+				"dn":      ldapUser.DN,
+				"ctx_id":  ctx.Value(logging.ContextIDKey),
+			}).Warning("api/external: ldap user matches existing user which can not be linked")
+			return storage.User{}, storage.ErrInvalidUsernameOrPassword
+		}
+	}
+
+	user.Email = ldapUser.Email
+	user.EmailVerified = true
+	user.ExternalID = &ldapUser.DN
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := syncUserGroups(ctx, tx, &user, ldapUser.Groups, ldapAdminGroup, ldapGroupMapping); err != nil {
+			return err
+		}
+		return storage.UpdateUser(ctx, tx, &user)
+	})
+	if err != nil {
+		return storage.User{}, err
+	}
+
+	return user, nil
+}
+
+// ldapCanLink returns true when the given existing user (matched by e-mail
+// address) can be linked to the directory user with the given DN. Users
+// linked to an other external ID and global admin users are never linked.
+// Users with a local password are only linked when link_local_users is set,
+// as otherwise the directory would take over a local account.
+func ldapCanLink(user storage.User, dn string) bool {
+	if user.ExternalID != nil && *user.ExternalID != dn {
+		return false
+	}
+
+	if user.IsAdmin {
+		return false
+	}
+
+	if user.PasswordHash != "" && !ldapLinkLocalUsers {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,245 @@
+package external
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/ldap"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/loginlimit"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// UpdateExpiredPasswordRequest defines the request for changing an expired
+// password. As the user can not login with an expired password, this
+// request is authenticated using the current credentials.
+type UpdateExpiredPasswordRequest struct {
+	// E-mail address of the user.
+	Email string `json:"email"`
+
+	// Current (expired) password.
+	Password string `json:"password"`
+
+	// TOTP code or recovery code (when two-factor authentication is enabled).
+	TOTPCode     string `json:"totpCode"`
+	RecoveryCode string `json:"recoveryCode"`
+
+	// New password.
+	NewPassword string `json:"newPassword"`
+}
+
+// UpdateExpiredPasswordResponse defines the response for changing an
+// expired password.
+type UpdateExpiredPasswordResponse struct {
+	// JWT token for the user.
+	JWT string `json:"jwt"`
+}
+
+// UpdateExpiredPassword changes the expired password of the user and
+// returns a JWT token.
+func (a *InternalAPI) UpdateExpiredPassword(ctx context.Context, req *UpdateExpiredPasswordRequest) (*UpdateExpiredPasswordResponse, error) {
+	user, jwt, err := a.login(ctx, req.Email, req.Password, req.TOTPCode, req.RecoveryCode, true)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.UpdateUserPassword(ctx, tx, &user, req.NewPassword)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &UpdateExpiredPasswordResponse{JWT: jwt}, nil
+}
+
+// login authenticates the user by e-mail address (or LDAP login name),
+// password and (when enabled) the two-factor authentication code and returns
+// the user and the JWT token. Failed attempts are rate-limited per login and
+// client IP address and are recorded into the audit log. Unless allowExpired
+// is set, an expired password results in ErrUserPasswordExpired.
+func (a *InternalAPI) login(ctx context.Context, email, password, totpCode, recoveryCode string, allowExpired bool) (storage.User, string, error) {
+	ip := loginClientIP(ctx)
+
+	if err := loginlimit.Check(email, ip); err != nil {
+		if err == loginlimit.ErrLocked {
+			auditLoginFailed(ctx, email, ip, nil, err)
+		}
+		return storage.User{}, "", err
+	}
+
+	var user storage.User
+	var err error
+	if ldap.Enabled() {
+		user, err = a.ldapLogin(ctx, email, password)
+	} else {
+		user, err = storage.GetUserByEmailAndPassword(ctx, storage.DB(), email, password)
+	}
+	if err != nil {
+		if errors.Cause(err) == storage.ErrInvalidUsernameOrPassword {
+			a.loginFailed(ctx, email, ip, nil, err)
+		}
+		return storage.User{}, "", err
+	}
+
+	if !allowExpired && user.PasswordExpired() {
+		return storage.User{}, "", storage.ErrUserPasswordExpired
+	}
+
+	jwt, err := a.getLoginToken(ctx, user, totpCode, recoveryCode)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrInvalidTOTPCode {
+			a.loginFailed(ctx, email, ip, &user, err)
+		}
+		return storage.User{}, "", err
+	}
+
+	if err := loginlimit.Reset(email); err != nil {
+		log.WithError(err).Error("api/external: reset failed login count error")
+	}
+
+	return user, jwt, nil
+}
+
+// loginFailed registers the failed login attempt and records it into the
+// audit log.
+func (a *InternalAPI) loginFailed(ctx context.Context, email, ip string, user *storage.User, reason error) {
+	locked, err := loginlimit.RegisterFailure(email, ip)
+	if err != nil {
+		log.WithError(err).Error("api/external: register failed login error")
+	}
+
+	auditLoginFailed(ctx, email, ip, user, reason)
+
+	if locked {
+		log.WithFields(log.Fields{
+			"email":      email,
+			"ip_address": ip,
+		}).Warning("api/external: login locked because of too many failed attempts")
+	}
+}
+
+// auditLoginFailed records the failed login attempt into the audit log.
+func auditLoginFailed(ctx context.Context, email, ip string, user *storage.User, reason error) {
+	changes, err := json.Marshal(auditDiff(nil, map[string]interface{}{
+		"ip_address": ip,
+		"reason":     errors.Cause(reason).Error(),
+	}))
+	if err != nil {
+		log.WithError(err).Error("api/external: marshal audit-log changes error")
+		return
+	}
+
+	entry := storage.AuditLogEntry{
+		Subject:      "user",
+		Username:     email,
+		Service:      "InternalService",
+		Method:       "Login",
+		Action:       storage.AuditActionLoginFailed,
+		ResourceType: "user",
+		Changes:      changes,
+	}
+
+	if user != nil {
+		entry.UserID = &user.ID
+		entry.ResourceID = auditResourceID(map[string]interface{}{"id": user.ID})
+	}
+
+	if ctxID, ok := ctx.Value(logging.ContextIDKey).(uuid.UUID); ok {
+		entry.RequestID = &ctxID
+	}
+
+	if err := storage.CreateAuditLogEntry(ctx, storage.DB(), &entry); err != nil {
+		log.WithError(err).Error("api/external: create audit-log entry error")
+	}
+}
+
+// loginClientIP returns the IP address of the client. This is the address
+// of the peer, unless the peer is a trusted proxy (the REST api connects over
+// the loopback interface). In that case the X-Forwarded-For entries are
+// read from right to left and the first entry which is not a trusted proxy
+// is returned, as the entries on the left are controlled by the client.
+func loginClientIP(ctx context.Context) string {
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	var forwarded []string
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("x-forwarded-for") {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				forwarded = append(forwarded, s)
+			}
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip = forwarded[i]
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// isTrustedProxy returns true when the given IP address is a loopback
+// address or matches one of the configured trusted proxies.
+func isTrustedProxy(s string) bool {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return false
+	}
+
+	if ip.IsLoopback() {
+		return true
+	}
+
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTrustedProxy parses the given trusted proxy IP address or network
+// (CIDR).
+func parseTrustedProxy(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		return ipNet, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Errorf("invalid ip address: %s", s)
+	}
+
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
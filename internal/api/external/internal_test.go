@@ -4,20 +4,31 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	pb "github.com/gyh1621/chirpstack-api/go/v3/as/external/api"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/ldap"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/external/oidc"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/email"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+	"github.com/gyh1621/chirpstack-application-server/internal/totp"
 )
 
 func (ts *APITestSuite) TestInternal() {
@@ -392,5 +403,685 @@ func (ts *APITestSuite) TestInternal() {
 			assert.NoError(err)
 			assert.Equal("foo@bar.com", user.Email)
 		})
+
+		t.Run("Group mapping", func(t *testing.T) {
+			assert := require.New(t)
+
+			oidcGroupsClaim = "groups"
+			oidcAdminGroup = "admins"
+			oidcGroupMapping = []config.GroupMappingConfig{
+				{Group: "users", OrganizationID: org.ID},
+				{Group: "device-admins", OrganizationID: org.ID, IsDeviceAdmin: true},
+			}
+			defer func() {
+				oidcGroupsClaim = ""
+				oidcAdminGroup = ""
+				oidcGroupMapping = nil
+			}()
+
+			oidc.MockGetUserUser = &oidc.User{
+				ExternalID:    "ext-test-id-groups",
+				Email:         "groups@example.com",
+				EmailVerified: true,
+				Groups:        []string{"admins", "users", "device-admins"},
+			}
+			oidc.MockGetUserError = nil
+
+			_, err := api.OpenIDConnectLogin(context.Background(), &pb.OpenIDConnectLoginRequest{
+				Code:  "A",
+				State: "B",
+			})
+			assert.NoError(err)
+
+			user, err := storage.GetUserByExternalID(context.Background(), storage.DB(), "ext-test-id-groups")
+			assert.NoError(err)
+			assert.True(user.IsAdmin)
+
+			ou, err := storage.GetOrganizationUser(context.Background(), storage.DB(), org.ID, user.ID)
+			assert.NoError(err)
+			assert.True(ou.IsDeviceAdmin)
+
+			t.Run("Removed from groups", func(t *testing.T) {
+				assert := require.New(t)
+
+				oidc.MockGetUserUser.Groups = nil
+
+				_, err := api.OpenIDConnectLogin(context.Background(), &pb.OpenIDConnectLoginRequest{
+					Code:  "A",
+					State: "B",
+				})
+				assert.NoError(err)
+
+				user, err := storage.GetUser(context.Background(), storage.DB(), user.ID)
+				assert.NoError(err)
+				assert.False(user.IsAdmin)
+
+				_, err = storage.GetOrganizationUser(context.Background(), storage.DB(), org.ID, user.ID)
+				assert.Equal(storage.ErrDoesNotExist, errors.Cause(err))
+			})
+		})
+	})
+
+	ts.T().Run("TOTP", func(t *testing.T) {
+		assert := require.New(t)
+
+		user := storage.User{
+			Email:    "totp@example.com",
+			IsActive: true,
+			IsAdmin:  true,
+		}
+		assert.NoError(user.SetPasswordHash("password"))
+		assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &user))
+
+		validator := &TestValidator{returnSubject: "user", returnUser: user}
+		api := NewInternalAPI(validator)
+
+		loginCtx := func(md ...string) context.Context {
+			return metadata.NewIncomingContext(context.Background(), metadata.Pairs(md...))
+		}
+
+		var secret string
+		var recoveryCodes []string
+
+		t.Run("Enroll", func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := api.EnrollTOTP(context.Background(), &empty.Empty{})
+			assert.NoError(err)
+			assert.NotEqual("", resp.Secret)
+			assert.Contains(resp.URI, "otpauth://totp/")
+			secret = resp.Secret
+
+			status, err := api.GetTOTP(context.Background(), &empty.Empty{})
+			assert.NoError(err)
+			assert.False(status.Enabled)
+		})
+
+		t.Run("Activate with invalid code", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.ActivateTOTP(context.Background(), &TOTPCodeRequest{Code: "000000"})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("Activate", func(t *testing.T) {
+			assert := require.New(t)
+
+			code, err := totp.Code(secret, totp.Counter(time.Now())-1)
+			assert.NoError(err)
+
+			// call through the audit interceptor to validate the redaction
+			// of the code
+			out, err := auditUnaryServerInterceptor(validator)(context.Background(), &TOTPCodeRequest{Code: code}, &grpc.UnaryServerInfo{
+				Server:     api,
+				FullMethod: "/api.InternalService/ActivateTOTP",
+			}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return api.ActivateTOTP(ctx, req.(*TOTPCodeRequest))
+			})
+			assert.NoError(err)
+			resp := out.(*ActivateTOTPResponse)
+			assert.Len(resp.RecoveryCodes, 10)
+			assert.NotEqual("", resp.JWT)
+			recoveryCodes = resp.RecoveryCodes
+
+			status, err := api.GetTOTP(context.Background(), &empty.Empty{})
+			assert.NoError(err)
+			assert.True(status.Enabled)
+			assert.Equal(10, status.RecoveryCodesRemaining)
+
+			entries, err := storage.GetAuditLogEntries(context.Background(), storage.DB(), storage.AuditLogFilters{
+				UserID:       user.ID,
+				Action:       storage.AuditActionExecute,
+				ResourceType: "totp",
+				Limit:        10,
+			})
+			assert.NoError(err)
+			assert.Len(entries, 1)
+			assert.Equal("ActivateTOTP", entries[0].Method)
+			assert.JSONEq(`{"code": {"old": null, "new": "<redacted>"}}`, string(entries[0].Changes))
+		})
+
+		t.Run("Login without code", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.Login(context.Background(), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password",
+			})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("Login with code", func(t *testing.T) {
+			assert := require.New(t)
+
+			code, err := totp.Code(secret, totp.Counter(time.Now()))
+			assert.NoError(err)
+
+			resp, err := api.Login(loginCtx(totpCodeMetadataKey, code), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password",
+			})
+			assert.NoError(err)
+			assert.NotEqual("", resp.Jwt)
+
+			// the same code can not be used twice
+			_, err = api.Login(loginCtx(totpCodeMetadataKey, code), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password",
+			})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("Login with recovery code", func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := api.Login(loginCtx(recoveryCodeMetadataKey, recoveryCodes[0]), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password",
+			})
+			assert.NoError(err)
+			assert.NotEqual("", resp.Jwt)
+
+			_, err = api.Login(loginCtx(recoveryCodeMetadataKey, recoveryCodes[0]), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password",
+			})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("Required for admins", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.UpdateTOTPSettings(context.Background(), &UpdateTOTPSettingsRequest{
+				Settings: TOTPSettings{RequireForAdmins: true},
+			})
+			assert.NoError(err)
+
+			settings, err := api.GetTOTPSettings(context.Background(), &empty.Empty{})
+			assert.NoError(err)
+			assert.True(settings.Settings.RequireForAdmins)
+
+			t.Run("Disable is not allowed", func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := api.DeleteTOTP(context.Background(), &TOTPCodeRequest{})
+				assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+			})
+
+			t.Run("Reset by admin", func(t *testing.T) {
+				assert := require.New(t)
+
+				userAPI := NewUserAPI(validator)
+				_, err := userAPI.DeleteTOTP(context.Background(), &UserTOTPRequest{ID: user.ID})
+				assert.NoError(err)
+			})
+
+			t.Run("Login returns enrollment token", func(t *testing.T) {
+				assert := require.New(t)
+
+				resp, err := api.Login(context.Background(), &pb.LoginRequest{
+					Email:    user.Email,
+					Password: "password",
+				})
+				assert.NoError(err)
+
+				var claims jwt.MapClaims
+				_, _, err = new(jwt.Parser).ParseUnverified(resp.Jwt, &claims)
+				assert.NoError(err)
+				assert.Equal(auth.SubjectTOTPEnrollment, claims["sub"])
+			})
+
+			_, err = api.UpdateTOTPSettings(context.Background(), &UpdateTOTPSettingsRequest{})
+			assert.NoError(err)
+		})
+	})
+
+	ts.T().Run("Password expiry", func(t *testing.T) {
+		assert := require.New(t)
+
+		storage.SetPasswordPolicy(storage.PasswordPolicy{
+			MinLength: 6,
+			MaxAge:    24 * time.Hour,
+		})
+		defer storage.SetPasswordPolicy(storage.PasswordPolicy{MinLength: 6})
+
+		changedAt := time.Now().Add(-48 * time.Hour)
+		user := storage.User{
+			Email:    "expired@example.com",
+			IsActive: true,
+		}
+		assert.NoError(user.SetPasswordHash("password"))
+		user.PasswordChangedAt = &changedAt
+		assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &user))
+
+		t.Run("Login", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.Login(context.Background(), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password",
+			})
+			assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+		})
+
+		t.Run("Update with invalid password", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.UpdateExpiredPassword(context.Background(), &UpdateExpiredPasswordRequest{
+				Email:       user.Email,
+				Password:    "invalid",
+				NewPassword: "new-password",
+			})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			resp, err := api.UpdateExpiredPassword(context.Background(), &UpdateExpiredPasswordRequest{
+				Email:       user.Email,
+				Password:    "password",
+				NewPassword: "new-password",
+			})
+			assert.NoError(err)
+			assert.NotEqual("", resp.JWT)
+
+			_, err = api.Login(context.Background(), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "new-password",
+			})
+			assert.NoError(err)
+		})
+	})
+
+	ts.T().Run("Password reset and e-mail verification", func(t *testing.T) {
+		assert := require.New(t)
+
+		openIDConnectEnabled = false
+
+		sender := testEmailSender{}
+		email.SetSender(&sender)
+		defer email.SetSender(nil)
+
+		user := storage.User{
+			Email:    "reset@example.com",
+			IsActive: true,
+		}
+		assert.NoError(user.SetPasswordHash("password"))
+		assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &user))
+
+		t.Run("Unknown user", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.CreatePasswordReset(context.Background(), &CreatePasswordResetRequest{Email: "unknown@example.com"})
+			assert.NoError(err)
+			assert.Len(sender.messages, 0)
+		})
+
+		t.Run("Reset password", func(t *testing.T) {
+			assert := require.New(t)
+
+			_, err := api.CreatePasswordReset(context.Background(), &CreatePasswordResetRequest{Email: user.Email})
+			assert.NoError(err)
+			_, err = api.CreatePasswordReset(context.Background(), &CreatePasswordResetRequest{Email: user.Email})
+			assert.NoError(err)
+			assert.Len(sender.messages, 2)
+			assert.Equal(user.Email, sender.messages[0].To)
+			token := sender.token(0)
+
+			_, err = api.UpdatePasswordReset(context.Background(), &UpdatePasswordResetRequest{Token: token, Password: "new-password"})
+			assert.NoError(err)
+
+			_, err = api.Login(context.Background(), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "new-password",
+			})
+			assert.NoError(err)
+
+			u, err := storage.GetUser(context.Background(), storage.DB(), user.ID)
+			assert.NoError(err)
+			assert.True(u.EmailVerified)
+
+			t.Run("Token can only be used once", func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := api.UpdatePasswordReset(context.Background(), &UpdatePasswordResetRequest{Token: token, Password: "other-password"})
+				assert.Equal(codes.InvalidArgument, grpc.Code(err))
+			})
+
+			t.Run("Outstanding tokens are invalidated", func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := api.UpdatePasswordReset(context.Background(), &UpdatePasswordResetRequest{Token: sender.token(1), Password: "other-password"})
+				assert.Equal(codes.InvalidArgument, grpc.Code(err))
+			})
+		})
+
+		t.Run("Verify e-mail", func(t *testing.T) {
+			assert := require.New(t)
+
+			user.EmailVerified = false
+			assert.NoError(storage.UpdateUser(context.Background(), storage.DB(), &user))
+
+			validator := &TestValidator{returnSubject: "user", returnUser: user}
+			api := NewInternalAPI(validator)
+
+			_, err := api.CreateEmailVerification(context.Background(), &empty.Empty{})
+			assert.NoError(err)
+			assert.Len(sender.messages, 3)
+			token := sender.token(2)
+
+			t.Run("E-mail changed", func(t *testing.T) {
+				assert := require.New(t)
+
+				userAPI := NewUserAPI(validator)
+				_, err := userAPI.Update(context.Background(), &pb.UpdateUserRequest{
+					User: &pb.User{
+						Id:       user.ID,
+						Email:    "reset-changed@example.com",
+						IsActive: true,
+					},
+				})
+				assert.NoError(err)
+
+				// the old token is no longer valid
+				_, err = api.UpdateEmailVerification(context.Background(), &UpdateEmailVerificationRequest{Token: token})
+				assert.Equal(codes.InvalidArgument, grpc.Code(err))
+
+				u, err := storage.GetUser(context.Background(), storage.DB(), user.ID)
+				assert.NoError(err)
+				assert.False(u.EmailVerified)
+				assert.Equal(user.Email, u.EmailOld)
+
+				// a verification e-mail is sent to the new address
+				assert.Len(sender.messages, 4)
+				assert.Equal("reset-changed@example.com", sender.messages[3].To)
+
+				_, err = api.UpdateEmailVerification(context.Background(), &UpdateEmailVerificationRequest{Token: sender.token(3)})
+				assert.NoError(err)
+
+				u, err = storage.GetUser(context.Background(), storage.DB(), user.ID)
+				assert.NoError(err)
+				assert.True(u.EmailVerified)
+			})
+		})
 	})
+
+	ts.T().Run("LDAP", func(t *testing.T) {
+		assert := require.New(t)
+		openIDConnectEnabled = false
+
+		var conf config.Config
+		conf.ApplicationServer.UserAuthentication.LDAP.Enabled = true
+		conf.ApplicationServer.UserAuthentication.LDAP.Server = "ldap://localhost:389"
+		conf.ApplicationServer.UserAuthentication.LDAP.BaseDN = "dc=example,dc=com"
+		conf.ApplicationServer.UserAuthentication.LDAP.UserAttribute = "uid"
+		conf.ApplicationServer.UserAuthentication.LDAP.EmailAttribute = "mail"
+		assert.NoError(ldap.Setup(conf))
+
+		org2 := storage.Organization{
+			Name: "test-org-ldap",
+		}
+		assert.NoError(storage.CreateOrganization(context.Background(), storage.DB(), &org2))
+
+		ldapAdminGroup = "cn=admins,dc=example,dc=com"
+		ldapGroupMapping = []config.GroupMappingConfig{
+			{Group: "cn=users,dc=example,dc=com", OrganizationID: org.ID},
+			{Group: "cn=gateways,dc=example,dc=com", OrganizationID: org.ID, IsGatewayAdmin: true},
+			{Group: "cn=org-admins,dc=example,dc=com", OrganizationID: org2.ID, IsAdmin: true},
+		}
+
+		defer func() {
+			ldap.MockAuthenticateUser = nil
+			ldap.MockAuthenticateError = nil
+			ldapAdminGroup = ""
+			ldapGroupMapping = nil
+			assert.NoError(ldap.Setup(config.Config{}))
+		}()
+
+		t.Run("Invalid credentials", func(t *testing.T) {
+			assert := require.New(t)
+
+			ldap.MockAuthenticateUser = nil
+			ldap.MockAuthenticateError = ldap.ErrInvalidCredentials
+
+			_, err := api.Login(context.Background(), &pb.LoginRequest{
+				Email:    "ldap-user",
+				Password: "secret",
+			})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("Local user fallback", func(t *testing.T) {
+			assert := require.New(t)
+
+			ldap.MockAuthenticateUser = nil
+			ldap.MockAuthenticateError = ldap.ErrInvalidCredentials
+
+			user := storage.User{
+				IsActive: true,
+				Email:    "ldap-local@example.com",
+			}
+			assert.NoError(user.SetPasswordHash("password123"))
+			assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &user))
+
+			_, err := api.Login(context.Background(), &pb.LoginRequest{
+				Email:    user.Email,
+				Password: "password123",
+			})
+			assert.NoError(err)
+		})
+
+		t.Run("Local user fallback when directory is unavailable", func(t *testing.T) {
+			assert := require.New(t)
+
+			ldap.MockAuthenticateUser = nil
+			ldap.MockAuthenticateError = errors.New("connect error")
+
+			_, err := api.Login(context.Background(), &pb.LoginRequest{
+				Email:    "ldap-local@example.com",
+				Password: "password123",
+			})
+			assert.NoError(err)
+
+			_, err = api.Login(context.Background(), &pb.LoginRequest{
+				Email:    "ldap-user",
+				Password: "secret",
+			})
+			assert.Equal(codes.Unauthenticated, grpc.Code(err))
+		})
+
+		t.Run("First login", func(t *testing.T) {
+			assert := require.New(t)
+
+			ldap.MockAuthenticateError = nil
+			ldap.MockAuthenticateUser = &ldap.User{
+				DN:    "uid=ldap-user,dc=example,dc=com",
+				Email: "ldap-user@example.com",
+				Groups: []string{
+					"CN=Admins,DC=example,DC=com",
+					"cn=users,dc=example,dc=com",
+					"cn=gateways,dc=example,dc=com",
+					"cn=org-admins,dc=example,dc=com",
+				},
+			}
+
+			resp, err := api.Login(context.Background(), &pb.LoginRequest{
+				Email:    "ldap-user",
+				Password: "secret",
+			})
+			assert.NoError(err)
+			assert.NotEqual("", resp.Jwt)
+
+			user, err := storage.GetUserByExternalID(context.Background(), storage.DB(), "uid=ldap-user,dc=example,dc=com")
+			assert.NoError(err)
+			assert.Equal("ldap-user@example.com", user.Email)
+			assert.True(user.EmailVerified)
+			assert.True(user.IsAdmin)
+
+			ou, err := storage.GetOrganizationUser(context.Background(), storage.DB(), org.ID, user.ID)
+			assert.NoError(err)
+			assert.False(ou.IsAdmin)
+			assert.True(ou.IsGatewayAdmin)
+
+			ou, err = storage.GetOrganizationUser(context.Background(), storage.DB(), org2.ID, user.ID)
+			assert.NoError(err)
+			assert.True(ou.IsAdmin)
+
+			// a linked user can not login using a local password
+			t.Run("Local password rejected", func(t *testing.T) {
+				assert := require.New(t)
+
+				assert.NoError(user.SetPasswordHash("password123"))
+				assert.NoError(storage.UpdateUser(context.Background(), storage.DB(), &user))
+
+				ldap.MockAuthenticateUser = nil
+				ldap.MockAuthenticateError = ldap.ErrInvalidCredentials
+
+				_, err := api.Login(context.Background(), &pb.LoginRequest{
+					Email:    user.Email,
+					Password: "password123",
+				})
+				assert.Equal(codes.Unauthenticated, grpc.Code(err))
+			})
+
+			t.Run("Groups are re-evaluated", func(t *testing.T) {
+				assert := require.New(t)
+
+				ldap.MockAuthenticateError = nil
+				ldap.MockAuthenticateUser = &ldap.User{
+					DN:     "uid=ldap-user,dc=example,dc=com",
+					Email:  "ldap-user-new@example.com",
+					Groups: []string{"cn=users,dc=example,dc=com"},
+				}
+
+				_, err := api.Login(context.Background(), &pb.LoginRequest{
+					Email:    "ldap-user",
+					Password: "secret",
+				})
+				assert.NoError(err)
+
+				user, err := storage.GetUser(context.Background(), storage.DB(), user.ID)
+				assert.NoError(err)
+				assert.Equal("ldap-user-new@example.com", user.Email)
+				assert.False(user.IsAdmin)
+
+				ou, err := storage.GetOrganizationUser(context.Background(), storage.DB(), org.ID, user.ID)
+				assert.NoError(err)
+				assert.False(ou.IsGatewayAdmin)
+
+				_, err = storage.GetOrganizationUser(context.Background(), storage.DB(), org2.ID, user.ID)
+				assert.Equal(storage.ErrDoesNotExist, errors.Cause(err))
+			})
+		})
+
+		t.Run("Existing users", func(t *testing.T) {
+			otherID := "oidc-user"
+			users := []storage.User{
+				{IsActive: true, Email: "ldap-password@example.com"},
+				{IsActive: true, Email: "ldap-admin@example.com", IsAdmin: true},
+				{IsActive: true, Email: "ldap-other@example.com", ExternalID: &otherID},
+				{IsActive: true, Email: "ldap-nopassword@example.com"},
+			}
+			assert.NoError(users[0].SetPasswordHash("password123"))
+			for i := range users {
+				assert.NoError(storage.CreateUser(context.Background(), storage.DB(), &users[i]))
+			}
+
+			login := func(u storage.User) error {
+				ldap.MockAuthenticateError = nil
+				ldap.MockAuthenticateUser = &ldap.User{
+					DN:    "uid=" + strings.Split(u.Email, "@")[0] + ",dc=example,dc=com",
+					Email: u.Email,
+				}
+
+				_, err := api.Login(context.Background(), &pb.LoginRequest{
+					Email:    u.Email,
+					Password: "secret",
+				})
+				return err
+			}
+
+			tests := []struct {
+				name       string
+				user       storage.User
+				linkLocal  bool
+				expectedOK bool
+			}{
+				{"user with local password is not linked", users[0], false, false},
+				{"global admin is not linked", users[1], true, false},
+				{"user linked to other external id is not linked", users[2], true, false},
+				{"user without local password is linked", users[3], false, true},
+				{"user with local password is linked when enabled", users[0], true, true},
+			}
+
+			for _, tst := range tests {
+				t.Run(tst.name, func(t *testing.T) {
+					assert := require.New(t)
+
+					ldapLinkLocalUsers = tst.linkLocal
+					defer func() { ldapLinkLocalUsers = false }()
+
+					err := login(tst.user)
+					user, getErr := storage.GetUser(context.Background(), storage.DB(), tst.user.ID)
+					assert.NoError(getErr)
+
+					if tst.expectedOK {
+						assert.NoError(err)
+						assert.NotNil(user.ExternalID)
+						assert.Equal("uid="+strings.Split(tst.user.Email, "@")[0]+",dc=example,dc=com", *user.ExternalID)
+					} else {
+						assert.Equal(codes.Unauthenticated, grpc.Code(err))
+						assert.Equal(tst.user.ExternalID, user.ExternalID)
+					}
+				})
+			}
+		})
+	})
+}
+
+type testEmailSender struct {
+	messages []email.Message
+}
+
+func (s *testEmailSender) Send(msg email.Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// token returns the token from the link in the given message.
+func (s *testEmailSender) token(i int) string {
+	match := regexp.MustCompile(`token=([^"]+)`).FindStringSubmatch(s.messages[i].Body)
+	if len(match) != 2 {
+		return ""
+	}
+	return match[1]
+}
+
+func TestLDAPCanLink(t *testing.T) {
+	dn := "uid=user,dc=example,dc=com"
+	otherDN := "uid=other,dc=example,dc=com"
+
+	tests := []struct {
+		Name           string
+		User           storage.User
+		LinkLocalUsers bool
+		ExpectedOK     bool
+	}{
+		{"user without password", storage.User{}, false, true},
+		{"user linked to the same dn", storage.User{ExternalID: &dn}, false, true},
+		{"user linked to other dn", storage.User{ExternalID: &otherDN}, true, false},
+		{"global admin", storage.User{IsAdmin: true}, true, false},
+		{"user with local password", storage.User{PasswordHash: "hash"}, false, false},
+		{"user with local password and link_local_users", storage.User{PasswordHash: "hash"}, true, true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			ldapLinkLocalUsers = tst.LinkLocalUsers
+			defer func() { ldapLinkLocalUsers = false }()
+
+			require.Equal(t, tst.ExpectedOK, ldapCanLink(tst.User, dn))
+		})
+	}
 }
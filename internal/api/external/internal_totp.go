@@ -0,0 +1,377 @@
+package external
+
+import (
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+	"github.com/gyh1621/chirpstack-application-server/internal/totp"
+)
+
+// totpIssuer defines the issuer which is shown by the authenticator app.
+const totpIssuer = "ChirpStack"
+
+// Login request metadata keys for the two-factor authentication. Using the
+// REST API, these are set using the Grpc-Metadata-Totp-Code and
+// Grpc-Metadata-Recovery-Code headers.
+const (
+	totpCodeMetadataKey     = "totp-code"
+	recoveryCodeMetadataKey = "recovery-code"
+)
+
+// GetTOTPResponse defines the two-factor authentication status response.
+type GetTOTPResponse struct {
+	// Two-factor authentication is enabled.
+	Enabled bool `json:"enabled"`
+
+	// Two-factor authentication is required for the user.
+	Required bool `json:"required"`
+
+	// Number of unused recovery codes.
+	RecoveryCodesRemaining int `json:"recoveryCodesRemaining"`
+}
+
+// EnrollTOTPResponse defines the two-factor authentication enrollment
+// response.
+type EnrollTOTPResponse struct {
+	// Base32 encoded TOTP secret.
+	Secret string `json:"secret"`
+
+	// The otpauth URI (to be presented as QR code).
+	URI string `json:"uri"`
+}
+
+// TOTPCodeRequest defines a request which must be confirmed using a TOTP
+// code.
+type TOTPCodeRequest struct {
+	// TOTP code.
+	Code string `json:"code"`
+}
+
+// ActivateTOTPResponse defines the two-factor authentication activation
+// response.
+type ActivateTOTPResponse struct {
+	// Recovery codes. These are only returned once.
+	RecoveryCodes []string `json:"recoveryCodes"`
+
+	// New JWT token for the user.
+	JWT string `json:"jwt"`
+}
+
+// TOTPRecoveryCodesResponse defines the recovery codes response.
+type TOTPRecoveryCodesResponse struct {
+	// Recovery codes. These are only returned once.
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TOTPSettings defines the global two-factor authentication settings.
+type TOTPSettings struct {
+	// Require two-factor authentication for global and organization admin
+	// users.
+	RequireForAdmins bool `json:"requireForAdmins"`
+}
+
+// UpdateTOTPSettingsRequest defines the request for updating the global
+// two-factor authentication settings.
+type UpdateTOTPSettingsRequest struct {
+	Settings TOTPSettings `json:"settings"`
+}
+
+// GetTOTPSettingsResponse defines the global two-factor authentication
+// settings response.
+type GetTOTPSettingsResponse struct {
+	Settings TOTPSettings `json:"settings"`
+}
+
+// GetTOTP returns the two-factor authentication status of the user.
+func (a *InternalAPI) GetTOTP(ctx context.Context, req *empty.Empty) (*GetTOTPResponse, error) {
+	user, err := a.getTOTPUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp GetTOTPResponse
+
+	t, err := storage.GetUserTOTP(ctx, storage.DB(), user.ID, false)
+	if err != nil && errors.Cause(err) != storage.ErrDoesNotExist {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	resp.Enabled = err == nil && t.Enabled
+
+	resp.Required, err = storage.IsUserTOTPRequired(ctx, storage.DB(), user)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp.RecoveryCodesRemaining, err = storage.GetUserTOTPRecoveryCodeCount(ctx, storage.DB(), user.ID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &resp, nil
+}
+
+// EnrollTOTP starts the two-factor authentication enrollment of the user.
+// It returns a new secret which must be confirmed using ActivateTOTP.
+// A pending enrollment is replaced.
+func (a *InternalAPI) EnrollTOTP(ctx context.Context, req *empty.Empty) (*EnrollTOTPResponse, error) {
+	user, err := a.getTOTPUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		t, err := storage.GetUserTOTP(ctx, tx, user.ID, true)
+		if err != nil {
+			if errors.Cause(err) == storage.ErrDoesNotExist {
+				return storage.CreateUserTOTP(ctx, tx, &storage.UserTOTP{
+					UserID: user.ID,
+					Secret: secret,
+				})
+			}
+			return err
+		}
+
+		if t.Enabled {
+			return storage.ErrAlreadyExists
+		}
+
+		t.Secret = secret
+		t.LastCounter = 0
+		return storage.UpdateUserTOTP(ctx, tx, &t)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &EnrollTOTPResponse{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ActivateTOTP confirms the two-factor authentication enrollment using a
+// code generated by the authenticator app. On success, it returns the
+// recovery codes and a new JWT token.
+func (a *InternalAPI) ActivateTOTP(ctx context.Context, req *TOTPCodeRequest) (*ActivateTOTPResponse, error) {
+	user, err := a.getTOTPUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp ActivateTOTPResponse
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		t, err := storage.GetUserTOTP(ctx, tx, user.ID, true)
+		if err != nil {
+			return err
+		}
+
+		if t.Enabled {
+			return storage.ErrAlreadyExists
+		}
+
+		if err := storage.ValidateUserTOTPCode(ctx, tx, user.ID, req.Code); err != nil {
+			return err
+		}
+
+		t, err = storage.GetUserTOTP(ctx, tx, user.ID, false)
+		if err != nil {
+			return err
+		}
+
+		t.Enabled = true
+		if err := storage.UpdateUserTOTP(ctx, tx, &t); err != nil {
+			return err
+		}
+
+		resp.RecoveryCodes, err = storage.CreateUserTOTPRecoveryCodes(ctx, tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp.JWT, err = storage.GetUserToken(user)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &resp, nil
+}
+
+// CreateTOTPRecoveryCodes generates new recovery codes, replacing the
+// existing codes.
+func (a *InternalAPI) CreateTOTPRecoveryCodes(ctx context.Context, req *TOTPCodeRequest) (*TOTPRecoveryCodesResponse, error) {
+	user, err := a.getTOTPUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp TOTPRecoveryCodesResponse
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := a.validateEnabledTOTPCode(ctx, tx, user.ID, req.Code); err != nil {
+			return err
+		}
+
+		resp.RecoveryCodes, err = storage.CreateUserTOTPRecoveryCodes(ctx, tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &resp, nil
+}
+
+// DeleteTOTP disables the two-factor authentication of the user. This is
+// not allowed when two-factor authentication is required for the user.
+func (a *InternalAPI) DeleteTOTP(ctx context.Context, req *TOTPCodeRequest) (*empty.Empty, error) {
+	user, err := a.getTOTPUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	required, err := storage.IsUserTOTPRequired(ctx, storage.DB(), user)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+	if required {
+		return nil, helpers.ErrToRPCError(storage.ErrTOTPRequired)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		if err := a.validateEnabledTOTPCode(ctx, tx, user.ID, req.Code); err != nil {
+			return err
+		}
+
+		return storage.DeleteUserTOTP(ctx, tx, user.ID)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// GetTOTPSettings returns the global two-factor authentication settings.
+func (a *InternalAPI) GetTOTPSettings(ctx context.Context, req *empty.Empty) (*GetTOTPSettingsResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateIsOrganizationAdmin(0)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	var resp GetTOTPSettingsResponse
+	err := storage.GetGlobalSetting(ctx, storage.DB(), storage.GlobalSettingRequireAdminTOTP, &resp.Settings.RequireForAdmins)
+	if err != nil && errors.Cause(err) != storage.ErrDoesNotExist {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &resp, nil
+}
+
+// UpdateTOTPSettings updates the global two-factor authentication settings.
+func (a *InternalAPI) UpdateTOTPSettings(ctx context.Context, req *UpdateTOTPSettingsRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateIsOrganizationAdmin(0)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := storage.SetGlobalSetting(ctx, storage.DB(), storage.GlobalSettingRequireAdminTOTP, req.Settings.RequireForAdmins); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// getTOTPUser validates that the client may manage its own two-factor
+// authentication and returns the user.
+func (a *InternalAPI) getTOTPUser(ctx context.Context) (storage.User, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateTOTPEnrollment()); err != nil {
+		return storage.User{}, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	user, err := a.validator.GetUser(ctx)
+	if err != nil {
+		return storage.User{}, helpers.ErrToRPCError(err)
+	}
+
+	return user, nil
+}
+
+// validateEnabledTOTPCode validates the given code, after validating that
+// two-factor authentication is enabled for the given user.
+func (a *InternalAPI) validateEnabledTOTPCode(ctx context.Context, db sqlx.Ext, userID int64, code string) error {
+	t, err := storage.GetUserTOTP(ctx, db, userID, true)
+	if err != nil {
+		if errors.Cause(err) == storage.ErrDoesNotExist {
+			return storage.ErrTOTPNotEnabled
+		}
+		return err
+	}
+
+	if !t.Enabled {
+		return storage.ErrTOTPNotEnabled
+	}
+
+	return storage.ValidateUserTOTPCode(ctx, db, userID, code)
+}
+
+// getLoginToken returns the JWT token for the given (password
+// authenticated) user. When two-factor authentication is enabled, the TOTP
+// code or a recovery code must be given. When two-factor authentication is
+// required but not enabled, a token is returned which only grants access to
+// the enrollment.
+func (a *InternalAPI) getLoginToken(ctx context.Context, user storage.User, totpCode, recoveryCode string) (string, error) {
+	t, err := storage.GetUserTOTP(ctx, storage.DB(), user.ID, false)
+	if err != nil && errors.Cause(err) != storage.ErrDoesNotExist {
+		return "", err
+	}
+
+	if err == nil && t.Enabled {
+		if totpCode != "" {
+			if err := storage.ValidateUserTOTPCode(ctx, storage.DB(), user.ID, totpCode); err != nil {
+				return "", err
+			}
+		} else if recoveryCode != "" {
+			if err := storage.UseUserTOTPRecoveryCode(ctx, storage.DB(), user.ID, recoveryCode); err != nil {
+				return "", err
+			}
+		} else {
+			return "", storage.ErrTOTPCodeRequired
+		}
+
+		return storage.GetUserToken(user)
+	}
+
+	required, err := storage.IsUserTOTPRequired(ctx, storage.DB(), user)
+	if err != nil {
+		return "", err
+	}
+	if required {
+		return storage.GetUserTOTPEnrollmentToken(user)
+	}
+
+	return storage.GetUserToken(user)
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) != 0 {
+		return v[0]
+	}
+	return ""
+}
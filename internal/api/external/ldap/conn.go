@@ -0,0 +1,366 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/pkg/errors"
+)
+
+// LDAP protocol operations (RFC 4511).
+const (
+	appBindRequest     ber.Tag = 0
+	appBindResponse    ber.Tag = 1
+	appUnbindRequest   ber.Tag = 2
+	appSearchRequest   ber.Tag = 3
+	appSearchEntry     ber.Tag = 4
+	appSearchDone      ber.Tag = 5
+	appSearchReference ber.Tag = 19
+	appExtendedRequest ber.Tag = 23
+	appExtendedResp    ber.Tag = 24
+)
+
+// Filter choices.
+const (
+	filterAnd           ber.Tag = 0
+	filterEqualityMatch ber.Tag = 3
+	filterPresent       ber.Tag = 7
+)
+
+const (
+	resultSuccess            = 0
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+	scopeWholeSubtree = 2
+	derefNever        = 0
+)
+
+// resultError defines a non-success LDAP result.
+type resultError struct {
+	code    int64
+	message string
+}
+
+func (e resultError) Error() string {
+	return fmt.Sprintf("ldap result code %d: %s", e.code, e.message)
+}
+
+// entry defines a search result entry.
+type entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// attribute returns the values of the given attribute. Attribute names are
+// case-insensitive.
+func (e entry) attribute(name string) []string {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// conn implements a minimal (synchronous) LDAP v3 client, supporting the
+// operations needed for authentication: simple bind and search.
+type conn struct {
+	conn      net.Conn
+	timeout   time.Duration
+	messageID int64
+}
+
+// dial connects to the given ldap:// or ldaps:// URL. When startTLS is set,
+// the connection is upgraded using the StartTLS extended operation.
+func dial(serverURL string, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) (*conn, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse server url error")
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ldaps":
+			host = net.JoinHostPort(u.Hostname(), "636")
+		default:
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+	}
+
+	tlsConf := &tls.Config{ServerName: u.Hostname()}
+	if tlsConfig != nil {
+		tlsConf = tlsConfig.Clone()
+		tlsConf.ServerName = u.Hostname()
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	var nc net.Conn
+
+	switch u.Scheme {
+	case "ldap":
+		nc, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		nc, err = tls.DialWithDialer(&dialer, "tcp", host, tlsConf)
+	default:
+		return nil, fmt.Errorf("unsupported server url scheme: %s", u.Scheme)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "dial error")
+	}
+
+	c := conn{
+		conn:    nc,
+		timeout: timeout,
+	}
+
+	if startTLS && u.Scheme == "ldap" {
+		if err := c.startTLS(tlsConf); err != nil {
+			c.close()
+			return nil, errors.Wrap(err, "starttls error")
+		}
+	}
+
+	return &c, nil
+}
+
+// close sends the unbind request and closes the connection.
+func (c *conn) close() {
+	c.messageID++
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.messageID, "MessageID"))
+	packet.AppendChild(ber.Encode(ber.ClassApplication, ber.TypePrimitive, appUnbindRequest, nil, "Unbind Request"))
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	c.conn.Write(packet.Bytes())
+	c.conn.Close()
+}
+
+// bind performs a simple bind.
+func (c *conn) bind(dn, password string) error {
+	req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appBindRequest, nil, "Bind Request")
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 3, "Version"))
+	req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "User Name"))
+	req.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, password, "Password"))
+
+	resp, err := c.request(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.Tag != appBindResponse {
+		return fmt.Errorf("unexpected response tag: %d", resp.Tag)
+	}
+
+	return result(resp)
+}
+
+// search performs a (subtree) search and returns the found entries. As the
+// search is used for looking up a single user, at most two entries are
+// requested, which is sufficient to detect non-unique matches.
+func (c *conn) search(baseDN string, filter *ber.Packet, attributes []string) ([]entry, error) {
+	req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appSearchRequest, nil, "Search Request")
+	req.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, baseDN, "Base DN"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, scopeWholeSubtree, "Scope"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, derefNever, "Deref Aliases"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, 2, "Size Limit"))
+	req.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, int64(c.timeout/time.Second), "Time Limit"))
+	req.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "Types Only"))
+	req.AppendChild(filter)
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, a := range attributes {
+		attrs.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a, "Attribute"))
+	}
+	req.AppendChild(attrs)
+
+	if err := c.send(req); err != nil {
+		return nil, err
+	}
+
+	var out []entry
+	for {
+		resp, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.Tag {
+		case appSearchEntry:
+			e, err := parseEntry(resp)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, e)
+		case appSearchReference:
+			// referrals are not followed
+		case appSearchDone:
+			if err := result(resp); err != nil {
+				// the entries returned until the size-limit was reached
+				// are valid
+				if rerr, ok := err.(resultError); ok && rerr.code == resultSizeLimitExceeded {
+					return out, nil
+				}
+				return nil, err
+			}
+			return out, nil
+		default:
+			return nil, fmt.Errorf("unexpected response tag: %d", resp.Tag)
+		}
+	}
+}
+
+// startTLS upgrades the connection to TLS.
+func (c *conn) startTLS(tlsConfig *tls.Config) error {
+	req := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appExtendedRequest, nil, "Start TLS")
+	req.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, startTLSOID, "TLS Extended Command"))
+
+	resp, err := c.request(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.Tag != appExtendedResp {
+		return fmt.Errorf("unexpected response tag: %d", resp.Tag)
+	}
+
+	if err := result(resp); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return errors.Wrap(err, "tls handshake error")
+	}
+	c.conn = tlsConn
+
+	return nil
+}
+
+// request sends the given protocol operation and returns the (single)
+// response protocol operation.
+func (c *conn) request(op *ber.Packet) (*ber.Packet, error) {
+	if err := c.send(op); err != nil {
+		return nil, err
+	}
+	return c.receive()
+}
+
+func (c *conn) send(op *ber.Packet) error {
+	c.messageID++
+
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Request")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.messageID, "MessageID"))
+	packet.AppendChild(op)
+
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return errors.Wrap(err, "set deadline error")
+	}
+
+	if _, err := c.conn.Write(packet.Bytes()); err != nil {
+		return errors.Wrap(err, "write error")
+	}
+
+	return nil
+}
+
+func (c *conn) receive() (*ber.Packet, error) {
+	packet, err := ber.ReadPacket(c.conn)
+	if err != nil {
+		return nil, errors.Wrap(err, "read error")
+	}
+
+	if len(packet.Children) < 2 {
+		return nil, errors.New("invalid ldap message")
+	}
+
+	id, ok := packet.Children[0].Value.(int64)
+	if !ok || id != c.messageID {
+		return nil, errors.New("unexpected ldap message id")
+	}
+
+	op := packet.Children[1]
+	if op.ClassType != ber.ClassApplication {
+		return nil, errors.New("invalid ldap protocol operation")
+	}
+
+	return op, nil
+}
+
+// result returns the LDAPResult of the given response as error, or nil on
+// success.
+func result(op *ber.Packet) error {
+	if len(op.Children) < 3 {
+		return errors.New("invalid ldap result")
+	}
+
+	code, ok := op.Children[0].Value.(int64)
+	if !ok {
+		return errors.New("invalid ldap result code")
+	}
+
+	if code == resultSuccess {
+		return nil
+	}
+
+	return resultError{
+		code:    code,
+		message: op.Children[2].Data.String(),
+	}
+}
+
+func parseEntry(op *ber.Packet) (entry, error) {
+	if len(op.Children) != 2 {
+		return entry{}, errors.New("invalid search result entry")
+	}
+
+	e := entry{
+		DN:         op.Children[0].Data.String(),
+		Attributes: make(map[string][]string),
+	}
+
+	for _, attr := range op.Children[1].Children {
+		if len(attr.Children) != 2 {
+			return entry{}, errors.New("invalid search result attribute")
+		}
+
+		name := attr.Children[0].Data.String()
+		for _, v := range attr.Children[1].Children {
+			e.Attributes[name] = append(e.Attributes[name], v.Data.String())
+		}
+	}
+
+	return e, nil
+}
+
+// filterEqual returns an equality-match filter. As the filter is encoded
+// directly, the value does not need to be escaped.
+func filterEqual(attribute, value string) *ber.Packet {
+	f := ber.Encode(ber.ClassContext, ber.TypeConstructed, filterEqualityMatch, nil, "Equality Match")
+	f.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute, "Attribute"))
+	f.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+	return f
+}
+
+// filterPresence returns a presence filter.
+func filterPresence(attribute string) *ber.Packet {
+	return ber.NewString(ber.ClassContext, ber.TypePrimitive, filterPresent, attribute, "Present")
+}
+
+// filterAll returns an and-filter of the given filters.
+func filterAll(filters ...*ber.Packet) *ber.Packet {
+	f := ber.Encode(ber.ClassContext, ber.TypeConstructed, filterAnd, nil, "And")
+	for _, c := range filters {
+		f.AppendChild(c)
+	}
+	return f
+}
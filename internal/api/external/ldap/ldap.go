@@ -0,0 +1,169 @@
+// Package ldap implements the authentication of users against a LDAP
+// directory (e.g. Active Directory).
+package ldap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+// ErrInvalidCredentials is returned when the user does not exist in the
+// directory or when the password is invalid.
+var ErrInvalidCredentials = errors.New("invalid ldap credentials")
+
+// timeout defines the timeout for connecting and for each LDAP operation.
+const timeout = 10 * time.Second
+
+var (
+	enabled        bool
+	server         string
+	startTLS       bool
+	tlsConfig      *tls.Config
+	bindDN         string
+	bindPassword   string
+	baseDN         string
+	userAttribute  string
+	emailAttribute string
+	groupAttribute string
+
+	// MockAuthenticateUser contains a possible mocked Authenticate User
+	MockAuthenticateUser *User
+	// MockAuthenticateError contains a possible mocked Authenticate error
+	MockAuthenticateError error
+)
+
+// User defines a LDAP user.
+type User struct {
+	// DN contains the distinguished name of the user, this is used as
+	// external ID.
+	DN     string
+	Email  string
+	Groups []string
+}
+
+// Setup configures the package.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.UserAuthentication.LDAP
+
+	enabled = c.Enabled
+	server = c.Server
+	startTLS = c.StartTLS
+	bindDN = c.BindDN
+	bindPassword = c.BindPassword
+	baseDN = c.BaseDN
+	userAttribute = c.UserAttribute
+	emailAttribute = c.EmailAttribute
+	groupAttribute = c.GroupAttribute
+	tlsConfig = nil
+
+	if !enabled {
+		return nil
+	}
+
+	if server == "" || baseDN == "" || userAttribute == "" || emailAttribute == "" {
+		return errors.New("ldap server, base_dn, user_attribute and email_attribute must be set")
+	}
+
+	if c.CACert != "" {
+		b, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return errors.Wrap(err, "read ca certificate error")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.New("append ca certificate error")
+		}
+
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	log.WithFields(log.Fields{
+		"server":  server,
+		"base_dn": baseDN,
+	}).Info("api/external/ldap: ldap authentication enabled")
+
+	return nil
+}
+
+// Enabled returns true when LDAP authentication is enabled.
+func Enabled() bool {
+	return enabled
+}
+
+// Authenticate looks up the user matching the given login name and
+// validates the password by binding as this user. It returns
+// ErrInvalidCredentials when the user does not exist (or is not unique) or
+// when the password is invalid.
+func Authenticate(login, password string) (User, error) {
+	if MockAuthenticateUser != nil || MockAuthenticateError != nil {
+		if MockAuthenticateError != nil {
+			return User{}, MockAuthenticateError
+		}
+		return *MockAuthenticateUser, nil
+	}
+
+	// an empty password would result in an unauthenticated bind, which
+	// succeeds on most servers
+	if login == "" || password == "" {
+		return User{}, ErrInvalidCredentials
+	}
+
+	c, err := dial(server, startTLS, tlsConfig, timeout)
+	if err != nil {
+		return User{}, errors.Wrap(err, "connect error")
+	}
+	defer c.close()
+
+	if bindDN != "" {
+		if err := c.bind(bindDN, bindPassword); err != nil {
+			return User{}, errors.Wrap(err, "bind error")
+		}
+	}
+
+	attributes := []string{emailAttribute}
+	if groupAttribute != "" {
+		attributes = append(attributes, groupAttribute)
+	}
+
+	entries, err := c.search(baseDN, filterAll(
+		filterEqual(userAttribute, login),
+		filterPresence(emailAttribute),
+	), attributes)
+	if err != nil {
+		return User{}, errors.Wrap(err, "search error")
+	}
+
+	if len(entries) != 1 {
+		log.WithFields(log.Fields{
+			"login":   login,
+			"entries": len(entries),
+		}).Warning("api/external/ldap: user not found or not unique")
+		return User{}, ErrInvalidCredentials
+	}
+	e := entries[0]
+
+	if err := c.bind(e.DN, password); err != nil {
+		if rerr, ok := err.(resultError); ok && rerr.code == resultInvalidCredentials {
+			return User{}, ErrInvalidCredentials
+		}
+		return User{}, errors.Wrap(err, "bind error")
+	}
+
+	user := User{
+		DN:     e.DN,
+		Groups: e.attribute(groupAttribute),
+	}
+	if emails := e.attribute(emailAttribute); len(emails) != 0 {
+		user.Email = emails[0]
+	}
+
+	return user, nil
+}
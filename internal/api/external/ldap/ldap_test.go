@@ -0,0 +1,249 @@
+package ldap
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+// testServer implements a minimal LDAP server, supporting simple bind and
+// equality-match search on the uid attribute.
+type testServer struct {
+	ln       net.Listener
+	accounts map[string]string // dn => password
+	entries  []entry
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := testServer{
+		ln: ln,
+		accounts: map[string]string{
+			"cn=service,dc=example,dc=com": "service-secret",
+			"uid=alice,dc=example,dc=com":  "alice-secret",
+		},
+		entries: []entry{
+			{
+				DN: "uid=alice,dc=example,dc=com",
+				Attributes: map[string][]string{
+					"uid":      {"alice"},
+					"mail":     {"alice@example.com"},
+					"memberOf": {"cn=admins,dc=example,dc=com", "cn=users,dc=example,dc=com"},
+				},
+			},
+			{
+				DN: "uid=bob,dc=example,dc=com",
+				Attributes: map[string][]string{
+					"uid": {"bob"},
+				},
+			},
+		},
+	}
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(c)
+		}
+	}()
+
+	return &s
+}
+
+func (s *testServer) handle(c net.Conn) {
+	defer c.Close()
+
+	for {
+		packet, err := ber.ReadPacket(c)
+		if err != nil {
+			return
+		}
+
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case appBindRequest:
+			dn := op.Children[1].Data.String()
+			pw := op.Children[2].Data.String()
+			code := int64(resultSuccess)
+			if p, ok := s.accounts[dn]; !ok || p != pw {
+				code = resultInvalidCredentials
+			}
+			s.write(c, id, s.result(appBindResponse, code))
+		case appSearchRequest:
+			filter := op.Children[6]
+			for _, e := range s.entries {
+				if !s.match(e, filter) {
+					continue
+				}
+
+				resp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, appSearchEntry, nil, "Search Result Entry")
+				resp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+				for k, values := range e.Attributes {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, "Name"))
+					vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+					for _, v := range values {
+						vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+					}
+					attr.AppendChild(vals)
+					attrs.AppendChild(attr)
+				}
+				resp.AppendChild(attrs)
+				s.write(c, id, resp)
+			}
+			s.write(c, id, s.result(appSearchDone, resultSuccess))
+		case appUnbindRequest:
+			return
+		}
+	}
+}
+
+// match evaluates the and, equality-match and presence filters.
+func (s *testServer) match(e entry, f *ber.Packet) bool {
+	switch f.Tag {
+	case filterAnd:
+		for _, c := range f.Children {
+			if !s.match(e, c) {
+				return false
+			}
+		}
+		return true
+	case filterEqualityMatch:
+		for _, v := range e.attribute(f.Children[0].Data.String()) {
+			if strings.EqualFold(v, f.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return len(e.attribute(f.Data.String())) != 0
+	}
+	return false
+}
+
+func (s *testServer) result(tag ber.Tag, code int64) *ber.Packet {
+	resp := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	resp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	resp.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return resp
+}
+
+func (s *testServer) write(c net.Conn, id int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	packet.AppendChild(op)
+	c.Write(packet.Bytes())
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestServer(t)
+	defer s.ln.Close()
+
+	var conf config.Config
+	conf.ApplicationServer.UserAuthentication.LDAP.Enabled = true
+	conf.ApplicationServer.UserAuthentication.LDAP.Server = "ldap://" + s.ln.Addr().String()
+	conf.ApplicationServer.UserAuthentication.LDAP.BindDN = "cn=service,dc=example,dc=com"
+	conf.ApplicationServer.UserAuthentication.LDAP.BindPassword = "service-secret"
+	conf.ApplicationServer.UserAuthentication.LDAP.BaseDN = "dc=example,dc=com"
+	conf.ApplicationServer.UserAuthentication.LDAP.UserAttribute = "uid"
+	conf.ApplicationServer.UserAuthentication.LDAP.EmailAttribute = "mail"
+	conf.ApplicationServer.UserAuthentication.LDAP.GroupAttribute = "memberOf"
+
+	t.Run("Setup", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(Setup(conf))
+		assert.True(Enabled())
+	})
+
+	tests := []struct {
+		Name          string
+		Login         string
+		Password      string
+		ExpectedUser  User
+		ExpectedError error
+	}{
+		{
+			Name:     "valid credentials",
+			Login:    "alice",
+			Password: "alice-secret",
+			ExpectedUser: User{
+				DN:     "uid=alice,dc=example,dc=com",
+				Email:  "alice@example.com",
+				Groups: []string{"cn=admins,dc=example,dc=com", "cn=users,dc=example,dc=com"},
+			},
+		},
+		{
+			Name:          "invalid password",
+			Login:         "alice",
+			Password:      "invalid",
+			ExpectedError: ErrInvalidCredentials,
+		},
+		{
+			Name:          "empty password",
+			Login:         "alice",
+			ExpectedError: ErrInvalidCredentials,
+		},
+		{
+			Name:          "unknown user",
+			Login:         "carol",
+			Password:      "secret",
+			ExpectedError: ErrInvalidCredentials,
+		},
+		{
+			Name:          "user without e-mail address",
+			Login:         "bob",
+			Password:      "secret",
+			ExpectedError: ErrInvalidCredentials,
+		},
+		{
+			Name:          "filter injection",
+			Login:         "*",
+			Password:      "alice-secret",
+			ExpectedError: ErrInvalidCredentials,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			user, err := Authenticate(tst.Login, tst.Password)
+			assert.Equal(tst.ExpectedError, err)
+			assert.Equal(tst.ExpectedUser, user)
+		})
+	}
+
+	t.Run("Invalid service account", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := conf
+		c.ApplicationServer.UserAuthentication.LDAP.BindPassword = "invalid"
+		assert.NoError(Setup(c))
+
+		_, err := Authenticate("alice", "alice-secret")
+		assert.Error(err)
+		assert.NotEqual(ErrInvalidCredentials, err)
+	})
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := conf
+		c.ApplicationServer.UserAuthentication.LDAP.BaseDN = ""
+		assert.Error(Setup(c))
+	})
+}
@@ -0,0 +1,346 @@
+package external
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-api/go/v3/ns"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// remoteMulticastSetupRetryInterval defines the interval between the
+// McGroupSetupReq, McGroupDeleteReq and McClassCSessionReq retries.
+const remoteMulticastSetupRetryInterval = 30 * time.Second
+
+// MulticastGroupRemoteSetupRequest defines the request for setting up,
+// getting or deleting the remote multicast-setup of a multicast-group.
+type MulticastGroupRemoteSetupRequest struct {
+	// Multicast-group ID.
+	ID string `json:"id"`
+}
+
+// MulticastGroupRemoteClassCSessionRequest defines the request for setting
+// up the remote Class-C session of a multicast-group.
+type MulticastGroupRemoteClassCSessionRequest struct {
+	// Multicast-group ID.
+	ID string `json:"id"`
+
+	// Start of the Class-C session.
+	SessionTime time.Time `json:"sessionTime"`
+
+	// Session timeout (0 - 15).
+	// The session lasts 2^SessionTimeOut seconds.
+	SessionTimeOut int `json:"sessionTimeOut"`
+}
+
+// MulticastGroupRemoteClassCSession defines the remote Class-C session
+// state of a device.
+type MulticastGroupRemoteClassCSession struct {
+	// Start of the Class-C session.
+	SessionTime time.Time `json:"sessionTime"`
+
+	// Session timeout.
+	SessionTimeOut int `json:"sessionTimeOut"`
+
+	// The device acknowledged the McClassCSessionReq.
+	StateProvisioned bool `json:"stateProvisioned"`
+
+	// Number of McClassCSessionReq transmissions.
+	RetryCount int `json:"retryCount"`
+
+	// Next McClassCSessionReq transmission (when not provisioned).
+	RetryAfter time.Time `json:"retryAfter"`
+}
+
+// MulticastGroupRemoteSetupDevice defines the remote multicast-setup state
+// of a device.
+type MulticastGroupRemoteSetupDevice struct {
+	// Device EUI (HEX encoded).
+	DevEUI string `json:"devEUI"`
+
+	// Multicast group ID on the device (0 - 3).
+	McGroupID int `json:"mcGroupID"`
+
+	// State of the setup (SETUP or DELETE).
+	State storage.RemoteMulticastSetupState `json:"state"`
+
+	// The device acknowledged the McGroupSetupReq or McGroupDeleteReq.
+	StateProvisioned bool `json:"stateProvisioned"`
+
+	// Number of McGroupSetupReq or McGroupDeleteReq transmissions.
+	RetryCount int `json:"retryCount"`
+
+	// Next McGroupSetupReq or McGroupDeleteReq transmission (when not
+	// provisioned).
+	RetryAfter time.Time `json:"retryAfter"`
+
+	// Last update timestamp.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Class-C session (when set up).
+	ClassCSession *MulticastGroupRemoteClassCSession `json:"classCSession"`
+}
+
+// GetMulticastGroupRemoteSetupResponse defines the get remote multicast-setup
+// response.
+type GetMulticastGroupRemoteSetupResponse struct {
+	Result []MulticastGroupRemoteSetupDevice `json:"result"`
+}
+
+// RemoteSetup (re)schedules the McGroupSetupReq for all devices of the
+// given multicast-group. Devices which already completed the setup are
+// provisioned again.
+func (a *MulticastGroupAPI) RemoteSetup(ctx context.Context, req *MulticastGroupRemoteSetupRequest) (*empty.Empty, error) {
+	mgID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err = a.validator.Validate(ctx,
+		auth.ValidateMulticastGroupAccess(auth.Update, mgID)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	items, err := storage.GetRemoteMulticastSetupItemsForMulticastGroup(ctx, storage.DB(), mgID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	count, err := storage.GetDeviceCountForMulticastGroup(ctx, storage.DB(), mgID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	devices, err := storage.GetDevicesForMulticastGroup(ctx, storage.DB(), mgID, count, 0)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	// devices which were added to the group without remote multicast-setup
+	setup := make(map[lorawan.EUI64]struct{}, len(items))
+	for _, item := range items {
+		setup[item.DevEUI] = struct{}{}
+	}
+	for _, d := range devices {
+		if _, ok := setup[d.DevEUI]; ok {
+			continue
+		}
+		if err = addDevice(ctx, mgID, d.DevEUI); err != nil {
+			return nil, grpc.Errorf(codes.Internal, "fail on dev_eui %s: %s", d.DevEUI, err)
+		}
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		for _, item := range items {
+			rms, err := storage.GetRemoteMulticastSetup(ctx, tx, item.DevEUI, mgID, true)
+			if err != nil {
+				return err
+			}
+
+			rms.State = storage.RemoteMulticastSetupSetup
+			rms.StateProvisioned = false
+			rms.RetryCount = 0
+			rms.RetryAfter = time.Now()
+
+			if err := storage.UpdateRemoteMulticastSetup(ctx, tx, &rms); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// RemoteClassCSession schedules the McClassCSessionReq for all devices of
+// the given Class-C multicast-group. The request is sent after the device
+// completed the multicast-setup.
+func (a *MulticastGroupAPI) RemoteClassCSession(ctx context.Context, req *MulticastGroupRemoteClassCSessionRequest) (*empty.Empty, error) {
+	mgID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if req.SessionTimeOut < 0 || req.SessionTimeOut > 15 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "sessionTimeOut must be between 0 and 15")
+	}
+
+	if !req.SessionTime.After(time.Now()) {
+		return nil, grpc.Errorf(codes.InvalidArgument, "sessionTime must be in the future")
+	}
+
+	if err = a.validator.Validate(ctx,
+		auth.ValidateMulticastGroupAccess(auth.Update, mgID)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		mg, err := storage.GetMulticastGroup(ctx, tx, mgID, false, false)
+		if err != nil {
+			return err
+		}
+
+		if mg.MulticastGroup.GroupType != ns.MulticastGroupType_CLASS_C {
+			return grpc.Errorf(codes.FailedPrecondition, "multicast-group must be of type CLASS_C")
+		}
+
+		items, err := storage.GetRemoteMulticastSetupItemsForMulticastGroup(ctx, tx, mgID)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if item.State != storage.RemoteMulticastSetupSetup {
+				continue
+			}
+
+			// replace the session of a previous request
+			err = storage.DeleteRemoteMulticastClassCSession(ctx, tx, item.DevEUI, mgID)
+			if err != nil && err != storage.ErrDoesNotExist {
+				return err
+			}
+
+			sess := storage.RemoteMulticastClassCSession{
+				DevEUI:           item.DevEUI,
+				MulticastGroupID: mgID,
+				McGroupID:        item.McGroupID,
+				DLFrequency:      int(mg.MulticastGroup.Frequency),
+				DR:               int(mg.MulticastGroup.Dr),
+				SessionTime:      req.SessionTime,
+				SessionTimeOut:   req.SessionTimeOut,
+				RetryInterval:    remoteMulticastSetupRetryInterval,
+			}
+			if err := storage.CreateRemoteMulticastClassCSession(ctx, tx, &sess); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// GetRemoteSetup returns the remote multicast-setup and Class-C session
+// state of the devices of the given multicast-group.
+func (a *MulticastGroupAPI) GetRemoteSetup(ctx context.Context, req *MulticastGroupRemoteSetupRequest) (*GetMulticastGroupRemoteSetupResponse, error) {
+	mgID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err = a.validator.Validate(ctx,
+		auth.ValidateMulticastGroupAccess(auth.Read, mgID)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	items, err := storage.GetRemoteMulticastSetupItemsForMulticastGroup(ctx, storage.DB(), mgID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	sessions, err := storage.GetRemoteMulticastClassCSessionsForMulticastGroup(ctx, storage.DB(), mgID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	sessionsByDevEUI := make(map[lorawan.EUI64]storage.RemoteMulticastClassCSession, len(sessions))
+	for _, sess := range sessions {
+		sessionsByDevEUI[sess.DevEUI] = sess
+	}
+
+	resp := GetMulticastGroupRemoteSetupResponse{
+		Result: make([]MulticastGroupRemoteSetupDevice, 0, len(items)),
+	}
+
+	for _, item := range items {
+		d := MulticastGroupRemoteSetupDevice{
+			DevEUI:           item.DevEUI.String(),
+			McGroupID:        item.McGroupID,
+			State:            item.State,
+			StateProvisioned: item.StateProvisioned,
+			RetryCount:       item.RetryCount,
+			RetryAfter:       item.RetryAfter,
+			UpdatedAt:        item.UpdatedAt,
+		}
+
+		if sess, ok := sessionsByDevEUI[item.DevEUI]; ok {
+			d.ClassCSession = &MulticastGroupRemoteClassCSession{
+				SessionTime:      sess.SessionTime,
+				SessionTimeOut:   sess.SessionTimeOut,
+				StateProvisioned: sess.StateProvisioned,
+				RetryCount:       sess.RetryCount,
+				RetryAfter:       sess.RetryAfter,
+			}
+		}
+
+		resp.Result = append(resp.Result, d)
+	}
+
+	return &resp, nil
+}
+
+// DeleteRemoteSetup schedules the McGroupDeleteReq for all devices of the
+// given multicast-group. The device is removed from the multicast-group
+// once it acknowledged the request.
+func (a *MulticastGroupAPI) DeleteRemoteSetup(ctx context.Context, req *MulticastGroupRemoteSetupRequest) (*empty.Empty, error) {
+	mgID, err := uuid.FromString(req.ID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "id: %s", err)
+	}
+
+	if err = a.validator.Validate(ctx,
+		auth.ValidateMulticastGroupAccess(auth.Update, mgID)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		items, err := storage.GetRemoteMulticastSetupItemsForMulticastGroup(ctx, tx, mgID)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			// a pending Class-C session is of no use after the delete
+			err = storage.DeleteRemoteMulticastClassCSession(ctx, tx, item.DevEUI, mgID)
+			if err != nil && err != storage.ErrDoesNotExist {
+				return err
+			}
+
+			if item.State == storage.RemoteMulticastSetupDelete {
+				continue
+			}
+
+			item.State = storage.RemoteMulticastSetupDelete
+			item.StateProvisioned = false
+			item.RetryCount = 0
+			item.RetryAfter = time.Now()
+
+			if err := storage.UpdateRemoteMulticastSetup(ctx, tx, &item); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
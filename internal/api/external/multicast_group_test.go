@@ -2,6 +2,7 @@ package external
 
 import (
 	"testing"
+	"time"
 
 	"github.com/brocaar/lorawan"
 
@@ -316,7 +317,7 @@ func (ts *APITestSuite) TestMulticastGroup() {
 			assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
 
 			_, err := api.AddDevice(context.Background(), &pb.AddDeviceToMulticastGroupRequest{
-				DevEui:           d.DevEUI.String(),
+				DevEuis:          []string{d.DevEUI.String()},
 				MulticastGroupId: createResp.Id,
 			})
 			assert.NoError(err)
@@ -339,6 +340,69 @@ func (ts *APITestSuite) TestMulticastGroup() {
 			})
 		})
 
+		t.Run("Remote setup", func(t *testing.T) {
+			assert := require.New(t)
+
+			d := storage.Device{
+				DevEUI:          lorawan.EUI64{1, 1, 1, 1, 1, 1, 1, 1},
+				ApplicationID:   app.ID,
+				DeviceProfileID: dpID,
+				Name:            "test-device-3",
+			}
+			assert.NoError(storage.CreateDevice(context.Background(), storage.DB(), &d))
+			assert.NoError(storage.CreateDeviceKeys(context.Background(), storage.DB(), &storage.DeviceKeys{
+				DevEUI: d.DevEUI,
+			}))
+
+			_, err := api.AddDevice(context.Background(), &pb.AddDeviceToMulticastGroupRequest{
+				DevEuis:          []string{d.DevEUI.String()},
+				MulticastGroupId: createResp.Id,
+			})
+			assert.NoError(err)
+
+			_, err = api.RemoteSetup(context.Background(), &MulticastGroupRemoteSetupRequest{
+				ID: createResp.Id,
+			})
+			assert.NoError(err)
+
+			resp, err := api.GetRemoteSetup(context.Background(), &MulticastGroupRemoteSetupRequest{
+				ID: createResp.Id,
+			})
+			assert.NoError(err)
+			assert.Len(resp.Result, 1)
+			assert.Equal(d.DevEUI.String(), resp.Result[0].DevEUI)
+			assert.Equal(storage.RemoteMulticastSetupSetup, resp.Result[0].State)
+			assert.False(resp.Result[0].StateProvisioned)
+			assert.Equal(0, resp.Result[0].RetryCount)
+			assert.Nil(resp.Result[0].ClassCSession)
+
+			t.Run("Class-C session on Class-B group", func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := api.RemoteClassCSession(context.Background(), &MulticastGroupRemoteClassCSessionRequest{
+					ID:          createResp.Id,
+					SessionTime: time.Now().Add(time.Minute),
+				})
+				assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+			})
+
+			t.Run("Delete", func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := api.DeleteRemoteSetup(context.Background(), &MulticastGroupRemoteSetupRequest{
+					ID: createResp.Id,
+				})
+				assert.NoError(err)
+
+				resp, err := api.GetRemoteSetup(context.Background(), &MulticastGroupRemoteSetupRequest{
+					ID: createResp.Id,
+				})
+				assert.NoError(err)
+				assert.Len(resp.Result, 1)
+				assert.Equal(storage.RemoteMulticastSetupDelete, resp.Result[0].State)
+			})
+		})
+
 		t.Run("Update", func(t *testing.T) {
 			assert := require.New(t)
 
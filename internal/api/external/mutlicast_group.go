@@ -470,6 +470,10 @@ func addDevice(ctx context.Context, mgID uuid.UUID, devEUI lorawan.EUI64) error
 		return helpers.ErrToRPCError(err)
 	}
 
+	if !dp.ApplicationLayerParams.MulticastSetupEnabled {
+		return grpc.Errorf(codes.FailedPrecondition, "remote multicast setup is disabled by the device-profile")
+	}
+
 	app, err := storage.GetApplication(ctx, storage.DB(), dev.ApplicationID)
 	if err != nil {
 		return helpers.ErrToRPCError(err)
@@ -501,13 +505,13 @@ func addDevice(ctx context.Context, mgID uuid.UUID, devEUI lorawan.EUI64) error
 	if dk.AppKey != nullKey && devMacVersion >= 110 {
 		mcRootKey, err = multicastsetup.GetMcRootKeyForAppKey(dk.AppKey)
 		if err != nil {
-			return grpc.Errorf(codes.Unknown, "get McRootKey for AppKey error", err)
+			return grpc.Errorf(codes.Unknown, "get McRootKey for AppKey error: %s", err)
 		}
 		log.Infof("Use AppKey to generate, appkey: %s, mcRootKey: %s", dk.AppKey, mcRootKey)
 	} else {
 		mcRootKey, err = multicastsetup.GetMcRootKeyForGenAppKey(dk.GenAppKey)
 		if err != nil {
-			return grpc.Errorf(codes.Unknown, "get McRootKey for GenAppKey error", err)
+			return grpc.Errorf(codes.Unknown, "get McRootKey for GenAppKey error: %s", err)
 		}
 		log.Infof("Use GenAppKey to generate, genappkey: %s, mcRootKey: %s", dk.GenAppKey, mcRootKey)
 	}
@@ -515,12 +519,12 @@ func addDevice(ctx context.Context, mgID uuid.UUID, devEUI lorawan.EUI64) error
 	mcKEKey, err := multicastsetup.GetMcKEKey(mcRootKey)
 	log.Infof("Get McKEKey: %s", mcKEKey)
 	if err != nil {
-		return grpc.Errorf(codes.Unknown, "get McKEKey error", err)
+		return grpc.Errorf(codes.Unknown, "get McKEKey error: %s", err)
 	}
 
 	block, err := aes.NewCipher(mcKEKey[:])
 	if err != nil {
-		return grpc.Errorf(codes.Unknown, "new cipher error", err)
+		return grpc.Errorf(codes.Unknown, "new cipher error: %s", err)
 	}
 	block.Decrypt(mcKeyEncrypted[:], mg.MCKey[:])
 	log.Infof("Get McKey_Encrypted: %s", mcKeyEncrypted)
@@ -538,7 +542,7 @@ func addDevice(ctx context.Context, mgID uuid.UUID, devEUI lorawan.EUI64) error
 		MinMcFCnt:        0,
 		MaxMcFCnt:        (1 << 32) - 1,
 		State:            storage.RemoteMulticastSetupSetup,
-		RetryInterval:    time.Second * 30,
+		RetryInterval:    remoteMulticastSetupRetryInterval,
 	}
 	copy(rms.McAddr[:], mg.MulticastGroup.McAddr)
 	log.Infof("remote multicast logs, before create, mcAddr: %s, %s",
@@ -6,6 +6,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/go-oidc"
@@ -18,6 +20,10 @@ import (
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
 )
 
+// ErrNoGroupsClaim is returned when the groups claim has been configured,
+// but is not present in the access token.
+var ErrNoGroupsClaim = errors.New("access token does not contain the groups claim")
+
 var (
 	providerURL  string
 	clientID     string
@@ -25,10 +31,24 @@ var (
 	redirectURL  string
 	jwtSecret    string
 
+	acceptAccessTokens  bool
+	accessTokenAudience string
+	groupsClaim         string
+
+	// the provider used for verifying access tokens is cached as it
+	// performs the discovery and caches the provider keys
+	providerMux sync.Mutex
+	provider    *oidc.Provider
+
 	// MockGetUserUser contains a possible mocked GetUser User
 	MockGetUserUser *User
 	// MockGetUserError contains a possible mocked GetUser error
 	MockGetUserError error
+
+	// MockVerifyAccessTokenUser contains a possible mocked VerifyAccessToken User
+	MockVerifyAccessTokenUser *User
+	// MockVerifyAccessTokenError contains a possible mocked VerifyAccessToken error
+	MockVerifyAccessTokenError error
 )
 
 // User defines an OpenID Connect user object.
@@ -37,6 +57,10 @@ type User struct {
 	Name          string `json:"name"`
 	Email         string `json:"email"`
 	EmailVerified bool   `json:"email_verified"`
+
+	// Groups contains the groups from the configured groups claim. This is
+	// only set by GetUser and VerifyAccessToken.
+	Groups []string `json:"-"`
 }
 
 // Setup configured the OpenID Connect endpoint handlers.
@@ -57,6 +81,13 @@ func Setup(conf config.Config, r *mux.Router) error {
 	clientSecret = oidcConfig.ClientSecret
 	redirectURL = oidcConfig.RedirectURL
 	jwtSecret = externalAPIConfig.JWTSecret
+	acceptAccessTokens = oidcConfig.AcceptAccessTokens
+	accessTokenAudience = oidcConfig.AccessTokenAudience
+	groupsClaim = oidcConfig.GroupsClaim
+
+	providerMux.Lock()
+	provider = nil
+	providerMux.Unlock()
 
 	r.HandleFunc("/auth/oidc/login", loginHandler)
 	r.HandleFunc("/auth/oidc/callback", callbackHandler)
@@ -200,5 +231,122 @@ func GetUser(ctx context.Context, code string, state string) (User, error) {
 		return User{}, errors.Wrap(err, "get userInfo error")
 	}
 
+	if groupsClaim != "" {
+		var claims map[string]interface{}
+		if err := idToken.Claims(&claims); err != nil {
+			return User{}, errors.Wrap(err, "get claims error")
+		}
+		user.Groups = getGroups(claims, groupsClaim)
+	}
+
 	return user, nil
 }
+
+// getGroups returns the groups from the given claim. Nested claims can be
+// referenced using a dot (e.g. realm_access.roles). The claim value can be a
+// list of strings or a single string. When the claim is not present, nil is
+// returned.
+func getGroups(claims map[string]interface{}, claim string) []string {
+	var value interface{} = claims
+	for _, key := range strings.Split(claim, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		// an empty list is returned when the claim is present but empty
+		out := []string{}
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+
+	return nil
+}
+
+// IsAccessTokenIssuer returns true when accepting access tokens is enabled
+// and the given issuer is the configured OpenID Connect provider.
+func IsAccessTokenIssuer(issuer string) bool {
+	if !acceptAccessTokens || issuer == "" {
+		return false
+	}
+
+	return strings.TrimSuffix(issuer, "/") == strings.TrimSuffix(providerURL, "/")
+}
+
+// VerifyAccessToken verifies the given access token, issued by the OpenID
+// Connect provider, and returns the user object from its claims.
+func VerifyAccessToken(ctx context.Context, rawToken string) (User, error) {
+	// for testing the API
+	if MockVerifyAccessTokenUser != nil {
+		return *MockVerifyAccessTokenUser, MockVerifyAccessTokenError
+	}
+
+	p, err := getProvider()
+	if err != nil {
+		return User{}, errors.Wrap(err, "get provider error")
+	}
+
+	audience := accessTokenAudience
+	if audience == "" {
+		audience = clientID
+	}
+
+	token, err := p.Verifier(&oidc.Config{ClientID: audience}).Verify(ctx, rawToken)
+	if err != nil {
+		return User{}, errors.Wrap(err, "verify access token error")
+	}
+
+	var user User
+	if err := token.Claims(&user); err != nil {
+		return User{}, errors.Wrap(err, "get claims error")
+	}
+
+	// the groups must be re-evaluated for every access token, tokens
+	// without groups claim can therefore not be accepted
+	if groupsClaim != "" {
+		var claims map[string]interface{}
+		if err := token.Claims(&claims); err != nil {
+			return User{}, errors.Wrap(err, "get claims error")
+		}
+		user.Groups = getGroups(claims, groupsClaim)
+		if user.Groups == nil {
+			return User{}, ErrNoGroupsClaim
+		}
+	}
+
+	return user, nil
+}
+
+// GroupsClaimEnabled returns true when the groups claim has been configured.
+func GroupsClaimEnabled() bool {
+	return groupsClaim != ""
+}
+
+func getProvider() (*oidc.Provider, error) {
+	providerMux.Lock()
+	defer providerMux.Unlock()
+
+	if provider != nil {
+		return provider, nil
+	}
+
+	// the context is used by the provider for fetching the keys and must
+	// therefore not be request scoped
+	p, err := oidc.NewProvider(context.Background(), providerURL)
+	if err != nil {
+		return nil, err
+	}
+	provider = p
+
+	return provider, nil
+}
@@ -16,3 +16,34 @@ func TestNewAuthenticator(t *testing.T) {
 		assert.Equal("openid connect is not properly configured", err.Error())
 	})
 }
+
+func TestGetGroups(t *testing.T) {
+	claims := map[string]interface{}{
+		"groups": []interface{}{"admins", "users", 1},
+		"role":   "operator",
+		"empty":  []interface{}{},
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"gateway-admins"},
+		},
+	}
+
+	tests := []struct {
+		Claim    string
+		Expected []string
+	}{
+		{"groups", []string{"admins", "users"}},
+		{"role", []string{"operator"}},
+		{"realm_access.roles", []string{"gateway-admins"}},
+		{"realm_access.missing", nil},
+		{"role.nested", nil},
+		{"missing", nil},
+		{"empty", []string{}},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Claim, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, getGroups(claims, tst.Claim))
+		})
+	}
+}
@@ -348,7 +348,9 @@ func (a *OrganizationAPI) DeleteUser(ctx context.Context, req *pb.DeleteOrganiza
 		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
 	}
 
-	err := storage.DeleteOrganizationUser(ctx, storage.DB(), req.OrganizationId, req.UserId)
+	err := storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.DeleteOrganizationUser(ctx, tx, req.OrganizationId, req.UserId)
+	})
 	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
@@ -0,0 +1,88 @@
+package external
+
+import (
+	"github.com/golang/protobuf/ptypes/empty"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// OrganizationUserRoleRequest defines the request for getting the role of
+// an organization user.
+type OrganizationUserRoleRequest struct {
+	// Organization ID.
+	OrganizationID int64 `json:"organizationID"`
+
+	// User ID.
+	UserID int64 `json:"userID"`
+}
+
+// GetOrganizationUserRoleResponse defines the get organization user role
+// response.
+type GetOrganizationUserRoleResponse struct {
+	// Role ID.
+	// This is 0 when no role is assigned.
+	RoleID int64 `json:"roleID,string"`
+}
+
+// UpdateOrganizationUserRoleRequest defines the request for updating the
+// role of an organization user.
+type UpdateOrganizationUserRoleRequest struct {
+	// Organization ID.
+	OrganizationID int64 `json:"organizationID"`
+
+	// User ID.
+	UserID int64 `json:"userID"`
+
+	// Role ID.
+	// Set to 0 to remove the role.
+	RoleID int64 `json:"roleID,string"`
+}
+
+// GetUserRole returns the role of the given organization user.
+func (a *OrganizationAPI) GetUserRole(ctx context.Context, req *OrganizationUserRoleRequest) (*GetOrganizationUserRoleResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateOrganizationUserAccess(auth.Read, req.OrganizationID, req.UserID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	ou, err := storage.GetOrganizationUser(ctx, storage.DB(), req.OrganizationID, req.UserID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	var resp GetOrganizationUserRoleResponse
+	if ou.RoleID != nil {
+		resp.RoleID = *ou.RoleID
+	}
+
+	return &resp, nil
+}
+
+// UpdateUserRole assigns the given role to the organization user. The role
+// replaces the admin flags of the organization user. As a role could grant
+// more permissions than the client has, only organization admins can
+// assign roles.
+func (a *OrganizationAPI) UpdateUserRole(ctx context.Context, req *UpdateOrganizationUserRoleRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateIsOrganizationAdmin(req.OrganizationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	var roleID *int64
+	if req.RoleID != 0 {
+		roleID = &req.RoleID
+	}
+
+	if err := storage.UpdateOrganizationUserRole(ctx, storage.DB(), req.OrganizationID, req.UserID, roleID); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
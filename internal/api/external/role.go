@@ -0,0 +1,302 @@
+package external
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
+	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// Role defines a role.
+type Role struct {
+	// Role ID.
+	// This will be automatically assigned on create.
+	ID int64 `json:"id,string"`
+
+	// Organization ID.
+	// This is 0 for the predefined roles.
+	OrganizationID int64 `json:"organizationID,string"`
+
+	// Predefined roles are available to all organizations and can not be
+	// updated or deleted.
+	Predefined bool `json:"predefined"`
+
+	// Name of the role.
+	// The name must be unique within the organization.
+	Name string `json:"name"`
+
+	// Description of the role.
+	Description string `json:"description"`
+
+	// Permissions granted by the role, in the format <resource>:<action>
+	// (e.g. gateway:read).
+	Permissions []string `json:"permissions"`
+
+	// Created at timestamp.
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Last update timestamp.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CreateRoleRequest defines the request for creating a role.
+type CreateRoleRequest struct {
+	Role Role `json:"role"`
+}
+
+// CreateRoleResponse defines the create role response.
+type CreateRoleResponse struct {
+	// Role ID.
+	ID int64 `json:"id,string"`
+}
+
+// RoleRequest defines the request for getting or deleting a role.
+type RoleRequest struct {
+	// Role ID.
+	ID int64 `json:"id"`
+}
+
+// GetRoleResponse defines the get role response.
+type GetRoleResponse struct {
+	Role Role `json:"role"`
+}
+
+// UpdateRoleRequest defines the request for updating a role. The
+// organization of a role can not be updated.
+type UpdateRoleRequest struct {
+	// Role ID.
+	ID int64 `json:"id"`
+
+	Role Role `json:"role"`
+}
+
+// ListRolesRequest defines the request for listing the roles.
+type ListRolesRequest struct {
+	// Organization ID.
+	OrganizationID int64 `json:"organizationID"`
+
+	// Max number of items to return.
+	Limit int64 `json:"limit"`
+
+	// Offset in the result-set (for pagination).
+	Offset int64 `json:"offset"`
+}
+
+// ListRolesResponse defines the roles list response.
+type ListRolesResponse struct {
+	// Total number of roles.
+	TotalCount int64 `json:"totalCount,string"`
+
+	// Roles within the requested limit and offset.
+	Result []Role `json:"result"`
+}
+
+// ListPermissionsRequest defines the request for listing the permissions.
+type ListPermissionsRequest struct{}
+
+// ListPermissionsResponse defines the permissions list response.
+type ListPermissionsResponse struct {
+	// Permissions which can be granted by a role.
+	Result []string `json:"result"`
+}
+
+// RoleAPI exports the role related functions.
+type RoleAPI struct {
+	validator auth.Validator
+}
+
+// NewRoleAPI creates a new RoleAPI.
+func NewRoleAPI(validator auth.Validator) *RoleAPI {
+	return &RoleAPI{
+		validator: validator,
+	}
+}
+
+// Create creates the given role.
+func (a *RoleAPI) Create(ctx context.Context, req *CreateRoleRequest) (*CreateRoleResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateRolesAccess(auth.Create, req.Role.OrganizationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validatePermissions(req.Role.Permissions); err != nil {
+		return nil, err
+	}
+
+	r := storage.Role{
+		OrganizationID: &req.Role.OrganizationID,
+		Name:           req.Role.Name,
+		Description:    req.Role.Description,
+		Permissions:    req.Role.Permissions,
+	}
+
+	if err := storage.CreateRole(ctx, storage.DB(), &r); err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &CreateRoleResponse{
+		ID: r.ID,
+	}, nil
+}
+
+// Get returns the role for the given ID.
+func (a *RoleAPI) Get(ctx context.Context, req *RoleRequest) (*GetRoleResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateRoleAccess(auth.Read, req.ID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	r, err := storage.GetRole(ctx, storage.DB(), req.ID)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &GetRoleResponse{
+		Role: roleToAPI(r),
+	}, nil
+}
+
+// Update updates the given role.
+func (a *RoleAPI) Update(ctx context.Context, req *UpdateRoleRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateRoleAccess(auth.Update, req.ID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := validatePermissions(req.Role.Permissions); err != nil {
+		return nil, err
+	}
+
+	err := storage.Transaction(func(db sqlx.Ext) error {
+		r, err := storage.GetRole(ctx, db, req.ID)
+		if err != nil {
+			return err
+		}
+
+		r.Name = req.Role.Name
+		r.Description = req.Role.Description
+		r.Permissions = req.Role.Permissions
+
+		return storage.UpdateRole(ctx, db, &r)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// Delete deletes the given role. A role which is assigned to users can not
+// be deleted.
+func (a *RoleAPI) Delete(ctx context.Context, req *RoleRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateRoleAccess(auth.Delete, req.ID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	err := storage.Transaction(func(db sqlx.Ext) error {
+		r, err := storage.GetRole(ctx, db, req.ID)
+		if err != nil {
+			return err
+		}
+		if r.OrganizationID == nil {
+			return storage.ErrRolePredefined
+		}
+
+		return storage.DeleteRole(ctx, db, r.ID)
+	})
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	return &empty.Empty{}, nil
+}
+
+// List lists the predefined roles and the roles of the given organization.
+func (a *RoleAPI) List(ctx context.Context, req *ListRolesRequest) (*ListRolesResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateRolesAccess(auth.List, req.OrganizationID),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	filters := storage.RoleFilters{
+		OrganizationID: req.OrganizationID,
+		Limit:          int(req.Limit),
+		Offset:         int(req.Offset),
+	}
+
+	count, err := storage.GetRoleCount(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	roles, err := storage.GetRoles(ctx, storage.DB(), filters)
+	if err != nil {
+		return nil, helpers.ErrToRPCError(err)
+	}
+
+	resp := ListRolesResponse{
+		TotalCount: int64(count),
+		Result:     make([]Role, 0, len(roles)),
+	}
+
+	for _, r := range roles {
+		resp.Result = append(resp.Result, roleToAPI(r))
+	}
+
+	return &resp, nil
+}
+
+// ListPermissions lists the permissions which can be granted by a role.
+func (a *RoleAPI) ListPermissions(ctx context.Context, req *ListPermissionsRequest) (*ListPermissionsResponse, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateActiveUser(),
+	); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	return &ListPermissionsResponse{
+		Result: auth.Permissions(),
+	}, nil
+}
+
+func validatePermissions(permissions []string) error {
+	for i, p := range permissions {
+		if !auth.IsValidPermission(p) {
+			return grpc.Errorf(codes.InvalidArgument, "permissions[%d]: invalid permission: %s", i, p)
+		}
+	}
+	return nil
+}
+
+func roleToAPI(r storage.Role) Role {
+	permissions := make([]string, 0, len(r.Permissions))
+	permissions = append(permissions, r.Permissions...)
+
+	role := Role{
+		ID:          r.ID,
+		Predefined:  r.OrganizationID == nil,
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: permissions,
+		CreatedAt:   r.CreatedAt,
+		UpdatedAt:   r.UpdatedAt,
+	}
+	if r.OrganizationID != nil {
+		role.OrganizationID = *r.OrganizationID
+	}
+
+	return role
+}
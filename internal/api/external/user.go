@@ -4,6 +4,7 @@ import (
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -11,6 +12,7 @@ import (
 	pb "github.com/gyh1621/chirpstack-api/go/v3/as/external/api"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/external/auth"
 	"github.com/gyh1621/chirpstack-application-server/internal/api/helpers"
+	"github.com/gyh1621/chirpstack-application-server/internal/email"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
@@ -67,6 +69,12 @@ func (a *UserAPI) Create(ctx context.Context, req *pb.CreateUserRequest) (*pb.Cr
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	if email.Enabled() {
+		if err := sendEmailVerification(ctx, user); err != nil {
+			log.WithError(err).Error("api/external: send e-mail verification error")
+		}
+	}
+
 	return &pb.CreateUserResponse{Id: user.ID}, nil
 }
 
@@ -166,6 +174,13 @@ func (a *UserAPI) Update(ctx context.Context, req *pb.UpdateUserRequest) (*empty
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	// the new e-mail address must be verified
+	emailChanged := user.Email != req.User.Email
+	if emailChanged {
+		user.EmailOld = user.Email
+		user.EmailVerified = false
+	}
+
 	user.IsAdmin = req.User.IsAdmin
 	user.IsActive = req.User.IsActive
 	user.SessionTTL = req.User.SessionTtl
@@ -176,6 +191,12 @@ func (a *UserAPI) Update(ctx context.Context, req *pb.UpdateUserRequest) (*empty
 		return nil, helpers.ErrToRPCError(err)
 	}
 
+	if emailChanged && email.Enabled() {
+		if err := sendEmailVerification(ctx, user); err != nil {
+			log.WithError(err).Error("api/external: send e-mail verification error")
+		}
+	}
+
 	return &empty.Empty{}, nil
 }
 
@@ -206,11 +227,33 @@ func (a *UserAPI) UpdatePassword(ctx context.Context, req *pb.UpdateUserPassword
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	if err := user.SetPasswordHash(req.Password); err != nil {
+	err = storage.Transaction(func(tx sqlx.Ext) error {
+		return storage.UpdateUserPassword(ctx, tx, &user, req.Password)
+	})
+	if err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
-	if err := storage.UpdateUser(ctx, storage.DB(), &user); err != nil {
+	return &empty.Empty{}, nil
+}
+
+// UserTOTPRequest defines the request for resetting the two-factor
+// authentication of a user.
+type UserTOTPRequest struct {
+	// User ID.
+	ID int64 `json:"id,string"`
+}
+
+// DeleteTOTP resets (disables) the two-factor authentication of the given
+// user, e.g. when the user lost access to the authenticator app and the
+// recovery codes.
+func (a *UserAPI) DeleteTOTP(ctx context.Context, req *UserTOTPRequest) (*empty.Empty, error) {
+	if err := a.validator.Validate(ctx,
+		auth.ValidateUserAccess(req.ID, auth.Update)); err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "authentication failed: %s", err)
+	}
+
+	if err := storage.DeleteUserTOTP(ctx, storage.DB(), req.ID); err != nil {
 		return nil, helpers.ErrToRPCError(err)
 	}
 
@@ -6,31 +6,68 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/clocksync"
+	"github.com/gyh1621/chirpstack-application-server/internal/email"
+	"github.com/gyh1621/chirpstack-application-server/internal/firmware"
 	"github.com/gyh1621/chirpstack-application-server/internal/integration/http"
 	"github.com/gyh1621/chirpstack-application-server/internal/integration/influxdb"
+	"github.com/gyh1621/chirpstack-application-server/internal/loginlimit"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
 var errToCode = map[error]codes.Code{
-	storage.ErrAlreadyExists:                   codes.AlreadyExists,
-	storage.ErrDoesNotExist:                    codes.NotFound,
-	storage.ErrUsedByOtherObjects:              codes.FailedPrecondition,
-	storage.ErrApplicationInvalidName:          codes.InvalidArgument,
-	storage.ErrNodeInvalidName:                 codes.InvalidArgument,
-	storage.ErrNodeMaxRXDelay:                  codes.InvalidArgument,
-	storage.ErrCFListTooManyChannels:           codes.InvalidArgument,
-	storage.ErrUserInvalidUsername:             codes.InvalidArgument,
-	storage.ErrUserPasswordLength:              codes.InvalidArgument,
-	storage.ErrInvalidUsernameOrPassword:       codes.Unauthenticated,
-	storage.ErrInvalidEmail:                    codes.InvalidArgument,
-	storage.ErrInvalidGatewayDiscoveryInterval: codes.InvalidArgument,
-	storage.ErrDeviceProfileInvalidName:        codes.InvalidArgument,
-	storage.ErrServiceProfileInvalidName:       codes.InvalidArgument,
-	storage.ErrMulticastGroupInvalidName:       codes.InvalidArgument,
-	storage.ErrOrganizationMaxDeviceCount:      codes.FailedPrecondition,
-	storage.ErrOrganizationMaxGatewayCount:     codes.FailedPrecondition,
-	http.ErrInvalidHeaderName:                  codes.InvalidArgument,
-	influxdb.ErrInvalidPrecision:               codes.InvalidArgument,
+	storage.ErrAlreadyExists:                      codes.AlreadyExists,
+	storage.ErrDoesNotExist:                       codes.NotFound,
+	storage.ErrUsedByOtherObjects:                 codes.FailedPrecondition,
+	storage.ErrApplicationInvalidName:             codes.InvalidArgument,
+	storage.ErrNodeInvalidName:                    codes.InvalidArgument,
+	storage.ErrNodeMaxRXDelay:                     codes.InvalidArgument,
+	storage.ErrCFListTooManyChannels:              codes.InvalidArgument,
+	storage.ErrUserInvalidUsername:                codes.InvalidArgument,
+	storage.ErrUserPasswordLength:                 codes.InvalidArgument,
+	storage.ErrUserPasswordCharacterClasses:       codes.InvalidArgument,
+	storage.ErrUserPasswordReused:                 codes.InvalidArgument,
+	storage.ErrUserPasswordExpired:                codes.FailedPrecondition,
+	storage.ErrInvalidUsernameOrPassword:          codes.Unauthenticated,
+	storage.ErrInvalidEmail:                       codes.InvalidArgument,
+	storage.ErrInvalidGatewayDiscoveryInterval:    codes.InvalidArgument,
+	storage.ErrDeviceProfileInvalidName:           codes.InvalidArgument,
+	storage.ErrDeviceProfileInvalidProtobufSchema: codes.InvalidArgument,
+	storage.ErrDeviceProfileInvalidAppLayerFPort:  codes.InvalidArgument,
+	storage.ErrDeviceProfileAppLayerFPortConflict: codes.InvalidArgument,
+	storage.ErrServiceProfileInvalidName:          codes.InvalidArgument,
+	storage.ErrMulticastGroupInvalidName:          codes.InvalidArgument,
+	storage.ErrOrganizationMaxDeviceCount:         codes.FailedPrecondition,
+	storage.ErrOrganizationMaxGatewayCount:        codes.FailedPrecondition,
+	storage.ErrFirmwareImageInvalidName:           codes.InvalidArgument,
+	storage.ErrFirmwareImageInvalidVersion:        codes.InvalidArgument,
+	storage.ErrFirmwareImageInvalidPayload:        codes.InvalidArgument,
+	storage.ErrFirmwareImageInvalidDescriptor:     codes.InvalidArgument,
+	storage.ErrFirmwareImageInvalidDeviceProfile:  codes.InvalidArgument,
+	storage.ErrFirmwareImageIncompatible:          codes.FailedPrecondition,
+	storage.ErrFUOTADeploymentInvalidWaves:        codes.InvalidArgument,
+	storage.ErrFUOTADeploymentInvalidThreshold:    codes.InvalidArgument,
+	storage.ErrFUOTADeploymentInvalidStartWindow:  codes.InvalidArgument,
+	storage.ErrTOTPCodeRequired:                   codes.Unauthenticated,
+	storage.ErrInvalidTOTPCode:                    codes.Unauthenticated,
+	storage.ErrTOTPNotEnabled:                     codes.FailedPrecondition,
+	storage.ErrTOTPRequired:                       codes.FailedPrecondition,
+	storage.ErrInvalidUserToken:                   codes.InvalidArgument,
+	storage.ErrRoleInvalidName:                    codes.InvalidArgument,
+	storage.ErrRoleInvalidOrganization:            codes.InvalidArgument,
+	storage.ErrRolePredefined:                     codes.InvalidArgument,
+	storage.ErrApplicationUserNotOrganizationUser: codes.InvalidArgument,
+	loginlimit.ErrLocked:                          codes.ResourceExhausted,
+	email.ErrDisabled:                             codes.FailedPrecondition,
+	clocksync.ErrDisabled:                         codes.FailedPrecondition,
+	clocksync.ErrFPortMismatch:                    codes.FailedPrecondition,
+	clocksync.ErrNoDevices:                        codes.FailedPrecondition,
+	clocksync.ErrInvalidPeriodicity:               codes.InvalidArgument,
+	clocksync.ErrInvalidNbTransmissions:           codes.InvalidArgument,
+	firmware.ErrSignatureRequired:                 codes.InvalidArgument,
+	firmware.ErrInvalidSignature:                  codes.InvalidArgument,
+	http.ErrInvalidHeaderName:                     codes.InvalidArgument,
+	influxdb.ErrInvalidPrecision:                  codes.InvalidArgument,
 }
 
 // ErrToRPCError converts the given error into a gRPC error.
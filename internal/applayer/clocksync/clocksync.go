@@ -5,15 +5,35 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/brocaar/lorawan"
 	"github.com/brocaar/lorawan/applayer/clocksync"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/packageversion"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/multicast"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
+// Limits of the DeviceAppTimePeriodicityReq and ForceDeviceResyncReq
+// fields.
+const (
+	MaxPeriodicity     = 15
+	MaxNbTransmissions = 7
+)
+
+// Errors
+var (
+	ErrDisabled               = errors.New("clock synchronization is disabled by the device-profile")
+	ErrFPortMismatch          = errors.New("devices of the multicast-group use different clock synchronization fPorts")
+	ErrNoDevices              = errors.New("multicast-group does not contain any devices")
+	ErrInvalidPeriodicity     = errors.New("periodicity must be between 0 and 15")
+	ErrInvalidNbTransmissions = errors.New("number of transmissions must be between 1 and 7")
+)
+
 // HandleClockSyncCommand handles an uplink clock synchronization command.
 func HandleClockSyncCommand(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, timeSinceGPSEpoch time.Duration, b []byte) error {
 	var cmd clocksync.Command
@@ -23,6 +43,14 @@ func HandleClockSyncCommand(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI
 	}
 
 	switch cmd.CID {
+	case clocksync.PackageVersionAns:
+		pl, ok := cmd.Payload.(*clocksync.PackageVersionAnsPayload)
+		if !ok {
+			return fmt.Errorf("expected *clocksync.PackageVersionAnsPayload, got: %T", cmd.Payload)
+		}
+		if err := packageversion.HandlePackageVersionAns(ctx, db, devEUI, packageversion.ClockSync, pl.PackageIdentifier, pl.PackageVersion); err != nil {
+			return errors.Wrap(err, "handle PackageVersionAns error")
+		}
 	case clocksync.AppTimeReq:
 		pl, ok := cmd.Payload.(*clocksync.AppTimeReqPayload)
 		if !ok {
@@ -31,6 +59,14 @@ func HandleClockSyncCommand(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI
 		if err := handleAppTimeReq(ctx, db, devEUI, timeSinceGPSEpoch, pl); err != nil {
 			return errors.Wrap(err, "handle AppTimeReq error")
 		}
+	case clocksync.DeviceAppTimePeriodicityAns:
+		pl, ok := cmd.Payload.(*clocksync.DeviceAppTimePeriodicityAnsPayload)
+		if !ok {
+			return fmt.Errorf("expected *clocksync.DeviceAppTimePeriodicityAnsPayload, got: %T", cmd.Payload)
+		}
+		if err := handleDeviceAppTimePeriodicityAns(ctx, db, devEUI, timeSinceGPSEpoch, pl); err != nil {
+			return errors.Wrap(err, "handle DeviceAppTimePeriodicityAns error")
+		}
 	default:
 		return fmt.Errorf("CID not implemented: %s", cmd.CID)
 	}
@@ -38,6 +74,147 @@ func HandleClockSyncCommand(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI
 	return nil
 }
 
+// EnqueueDeviceAppTimePeriodicityReq enqueues a DeviceAppTimePeriodicityReq
+// for the given device. The device will send an AppTimeReq every
+// 128 * 2^period seconds.
+func EnqueueDeviceAppTimePeriodicityReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, period uint8) error {
+	if period > MaxPeriodicity {
+		return ErrInvalidPeriodicity
+	}
+
+	fPort, err := getFPort(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	b, err := periodicityReq(period)
+	if err != nil {
+		return err
+	}
+
+	if _, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, fPort, b); err != nil {
+		return errors.Wrap(err, "enqueue downlink payload error")
+	}
+
+	if err := setPeriodicityRequested(ctx, db, devEUI, period); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": devEUI,
+		"period":  period,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("DeviceAppTimePeriodicityReq enqueued")
+
+	return nil
+}
+
+// EnqueueForceDeviceResyncReq enqueues a ForceDeviceResyncReq for the given
+// device. The device will send nbTransmissions AppTimeReq uplinks.
+func EnqueueForceDeviceResyncReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, nbTransmissions uint8) error {
+	if nbTransmissions == 0 || nbTransmissions > MaxNbTransmissions {
+		return ErrInvalidNbTransmissions
+	}
+
+	fPort, err := getFPort(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	b, err := forceResyncReq(nbTransmissions)
+	if err != nil {
+		return err
+	}
+
+	if _, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, fPort, b); err != nil {
+		return errors.Wrap(err, "enqueue downlink payload error")
+	}
+
+	if err := setResyncRequested(ctx, db, devEUI, nbTransmissions); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui":          devEUI,
+		"nb_transmissions": nbTransmissions,
+		"ctx_id":           ctx.Value(logging.ContextIDKey),
+	}).Info("ForceDeviceResyncReq enqueued")
+
+	return nil
+}
+
+// EnqueueMulticastDeviceAppTimePeriodicityReq enqueues a
+// DeviceAppTimePeriodicityReq for the given multicast-group.
+func EnqueueMulticastDeviceAppTimePeriodicityReq(ctx context.Context, db sqlx.Ext, multicastGroupID uuid.UUID, period uint8) error {
+	if period > MaxPeriodicity {
+		return ErrInvalidPeriodicity
+	}
+
+	fPort, devEUIs, err := getMulticastFPort(ctx, db, multicastGroupID)
+	if err != nil {
+		return err
+	}
+
+	b, err := periodicityReq(period)
+	if err != nil {
+		return err
+	}
+
+	if _, err = multicast.Enqueue(ctx, db, multicastGroupID, fPort, b); err != nil {
+		return errors.Wrap(err, "enqueue multicast-group queue-item error")
+	}
+
+	for _, devEUI := range devEUIs {
+		if err := setPeriodicityRequested(ctx, db, devEUI, period); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"multicast_group_id": multicastGroupID,
+		"period":             period,
+		"ctx_id":             ctx.Value(logging.ContextIDKey),
+	}).Info("DeviceAppTimePeriodicityReq enqueued")
+
+	return nil
+}
+
+// EnqueueMulticastForceDeviceResyncReq enqueues a ForceDeviceResyncReq for
+// the given multicast-group.
+func EnqueueMulticastForceDeviceResyncReq(ctx context.Context, db sqlx.Ext, multicastGroupID uuid.UUID, nbTransmissions uint8) error {
+	if nbTransmissions == 0 || nbTransmissions > MaxNbTransmissions {
+		return ErrInvalidNbTransmissions
+	}
+
+	fPort, devEUIs, err := getMulticastFPort(ctx, db, multicastGroupID)
+	if err != nil {
+		return err
+	}
+
+	b, err := forceResyncReq(nbTransmissions)
+	if err != nil {
+		return err
+	}
+
+	if _, err = multicast.Enqueue(ctx, db, multicastGroupID, fPort, b); err != nil {
+		return errors.Wrap(err, "enqueue multicast-group queue-item error")
+	}
+
+	for _, devEUI := range devEUIs {
+		if err := setResyncRequested(ctx, db, devEUI, nbTransmissions); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"multicast_group_id": multicastGroupID,
+		"nb_transmissions":   nbTransmissions,
+		"ctx_id":             ctx.Value(logging.ContextIDKey),
+	}).Info("ForceDeviceResyncReq enqueued")
+
+	return nil
+}
+
 func handleAppTimeReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, timeSinceGPSEpoch time.Duration, pl *clocksync.AppTimeReqPayload) error {
 	deviceGPSTime := int64(pl.DeviceTime)
 	networkGPSTime := int64((timeSinceGPSEpoch / time.Second) % (1 << 32))
@@ -49,6 +226,10 @@ func handleAppTimeReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, ti
 		"token_req":    pl.Param.TokenReq,
 	}).Info("AppTimeReq received")
 
+	if err := setClockDrift(ctx, db, devEUI, int(networkGPSTime-deviceGPSTime), nil); err != nil {
+		return err
+	}
+
 	ans := clocksync.Command{
 		CID: clocksync.AppTimeAns,
 		Payload: &clocksync.AppTimeAnsPayload{
@@ -63,7 +244,12 @@ func handleAppTimeReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, ti
 		return errors.Wrap(err, "marshal command error")
 	}
 
-	_, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, uint8(clocksync.DefaultFPort), b)
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	_, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, params.ClockSyncFPort, b)
 	if err != nil {
 		return errors.Wrap(err, "enqueue downlink payload error")
 	}
@@ -76,3 +262,197 @@ func handleAppTimeReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, ti
 
 	return nil
 }
+
+func handleDeviceAppTimePeriodicityAns(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, timeSinceGPSEpoch time.Duration, pl *clocksync.DeviceAppTimePeriodicityAnsPayload) error {
+	deviceGPSTime := int64(pl.Time)
+	networkGPSTime := int64((timeSinceGPSEpoch / time.Second) % (1 << 32))
+
+	log.WithFields(log.Fields{
+		"dev_eui":       devEUI,
+		"not_supported": pl.Status.NotSupported,
+		"device_time":   pl.Time,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("DeviceAppTimePeriodicityAns received")
+
+	return setClockDrift(ctx, db, devEUI, int(networkGPSTime-deviceGPSTime), &pl.Status.NotSupported)
+}
+
+func periodicityReq(period uint8) ([]byte, error) {
+	cmd := clocksync.Command{
+		CID: clocksync.DeviceAppTimePeriodicityReq,
+		Payload: &clocksync.DeviceAppTimePeriodicityReqPayload{
+			Periodicity: clocksync.DeviceAppTimePeriodicityReqPayloadPeriodicity{
+				Period: period,
+			},
+		},
+	}
+	b, err := cmd.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal command error")
+	}
+	return b, nil
+}
+
+func forceResyncReq(nbTransmissions uint8) ([]byte, error) {
+	cmd := clocksync.Command{
+		CID: clocksync.ForceDeviceResyncReq,
+		Payload: &clocksync.ForceDeviceResyncReqPayload{
+			ForceConf: clocksync.ForceDeviceResyncReqPayloadForceConf{
+				NbTransmissions: nbTransmissions,
+			},
+		},
+	}
+	b, err := cmd.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal command error")
+	}
+	return b, nil
+}
+
+// getFPort returns the clock synchronization fPort of the given device.
+func getFPort(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (uint8, error) {
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, devEUI)
+	if err != nil {
+		return 0, errors.Wrap(err, "get application-layer params error")
+	}
+
+	if !params.ClockSyncEnabled {
+		return 0, ErrDisabled
+	}
+
+	return params.ClockSyncFPort, nil
+}
+
+// getMulticastFPort returns the clock synchronization fPort and the devices
+// of the given multicast-group. All devices of the multicast-group must have
+// clock synchronization enabled, using the same fPort.
+func getMulticastFPort(ctx context.Context, db sqlx.Queryer, multicastGroupID uuid.UUID) (uint8, []lorawan.EUI64, error) {
+	var devices []struct {
+		DevEUI lorawan.EUI64 `db:"dev_eui"`
+		storage.ApplicationLayerParams
+	}
+	err := sqlx.Select(db, &devices, `
+		select
+			d.dev_eui,
+			dp.app_layer_multicast_setup_enabled,
+			dp.app_layer_multicast_setup_f_port,
+			dp.app_layer_fragmentation_enabled,
+			dp.app_layer_fragmentation_f_port,
+			dp.app_layer_clock_sync_enabled,
+			dp.app_layer_clock_sync_f_port
+		from
+			device_multicast_group dmg
+		inner join device d
+			on d.dev_eui = dmg.dev_eui
+		inner join device_profile dp
+			on dp.device_profile_id = d.device_profile_id
+		where
+			dmg.multicast_group_id = $1`,
+		multicastGroupID,
+	)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "select error")
+	}
+
+	if len(devices) == 0 {
+		return 0, nil, ErrNoDevices
+	}
+
+	var devEUIs []lorawan.EUI64
+	for _, d := range devices {
+		if !d.ClockSyncEnabled {
+			return 0, nil, ErrDisabled
+		}
+		if d.ClockSyncFPort != devices[0].ClockSyncFPort {
+			return 0, nil, ErrFPortMismatch
+		}
+		devEUIs = append(devEUIs, d.DevEUI)
+	}
+
+	return devices[0].ClockSyncFPort, devEUIs, nil
+}
+
+// getClockSync returns the locked clock synchronization state of the given
+// device. The state is created when it does not exist yet.
+func getClockSync(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) (storage.DeviceClockSync, error) {
+	cs, err := storage.GetDeviceClockSync(ctx, db, devEUI, true)
+	if err == nil {
+		return cs, nil
+	}
+	if err != storage.ErrDoesNotExist {
+		return cs, errors.Wrap(err, "get device clock-sync error")
+	}
+
+	cs = storage.DeviceClockSync{
+		DevEUI: devEUI,
+	}
+	if err := storage.CreateDeviceClockSync(ctx, db, &cs); err != nil {
+		return cs, errors.Wrap(err, "create device clock-sync error")
+	}
+
+	return cs, nil
+}
+
+func setPeriodicityRequested(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, period uint8) error {
+	cs, err := getClockSync(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	p := int(period)
+	cs.Periodicity = &p
+	cs.PeriodicityRequestedAt = &now
+	cs.PeriodicityAnsweredAt = nil
+	cs.PeriodicityNotSupported = false
+
+	if err := storage.UpdateDeviceClockSync(ctx, db, &cs); err != nil {
+		return errors.Wrap(err, "update device clock-sync error")
+	}
+
+	return nil
+}
+
+func setResyncRequested(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, nbTransmissions uint8) error {
+	cs, err := getClockSync(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cs.ResyncNbTransmissions = int(nbTransmissions)
+	cs.ResyncRequestedAt = &now
+
+	if err := storage.UpdateDeviceClockSync(ctx, db, &cs); err != nil {
+		return errors.Wrap(err, "update device clock-sync error")
+	}
+
+	return nil
+}
+
+// setClockDrift records the observed clock drift of the device and
+// publishes it as integration event. When periodicityNotSupported is set,
+// the drift was observed from a DeviceAppTimePeriodicityAns.
+func setClockDrift(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, drift int, periodicityNotSupported *bool) error {
+	cs, err := getClockSync(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cs.ClockDrift = drift
+	cs.ClockDriftAt = &now
+
+	if periodicityNotSupported != nil {
+		cs.PeriodicityAnsweredAt = &now
+		cs.PeriodicityNotSupported = *periodicityNotSupported
+	}
+
+	if err := storage.UpdateDeviceClockSync(ctx, db, &cs); err != nil {
+		return errors.Wrap(err, "update device clock-sync error")
+	}
+
+	sendClockDriftEvent(ctx, db, cs)
+
+	return nil
+}
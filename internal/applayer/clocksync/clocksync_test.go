@@ -14,6 +14,8 @@ import (
 	"github.com/brocaar/lorawan/gps"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/gyh1621/chirpstack-application-server/internal/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/integration/mock"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 	"github.com/gyh1621/chirpstack-application-server/internal/test"
 )
@@ -23,6 +25,7 @@ type ClockSyncTestSuite struct {
 	tx *storage.TxLogger
 
 	NSClient       *nsmock.Client
+	Integration    *mock.Integration
 	NetworkServer  storage.NetworkServer
 	Organization   storage.Organization
 	ServiceProfile storage.ServiceProfile
@@ -48,6 +51,9 @@ func (ts *ClockSyncTestSuite) SetupTest() {
 	ts.NSClient = nsmock.NewClient()
 	networkserver.SetPool(nsmock.NewPool(ts.NSClient))
 
+	ts.Integration = mock.New()
+	integration.SetMockIntegration(ts.Integration)
+
 	var err error
 	ts.tx, err = storage.DB().Beginx()
 	assert.NoError(err)
@@ -140,8 +146,151 @@ func (ts *ClockSyncTestSuite) TestAppTimeReq() {
 				TimeCorrection: 20,
 			},
 		}, ans)
+
+		cs, err := storage.GetDeviceClockSync(context.Background(), ts.tx, ts.Device.DevEUI, false)
+		assert.NoError(err)
+		assert.Equal(20, cs.ClockDrift)
+		assert.NotNil(cs.ClockDriftAt)
+
+		pl := <-ts.Integration.SendIntegrationNotificationChan
+		assert.Equal("clock_sync", pl.IntegrationName)
+		assert.Equal("clock_drift", pl.EventType)
+		assert.Equal(ts.Device.DevEUI[:], pl.DevEui)
 	})
 
+	ts.T().Run("Custom fPort", func(t *testing.T) {
+		assert := require.New(t)
+
+		params := storage.DefaultApplicationLayerParams()
+		params.ClockSyncFPort = 100
+		assert.NoError(storage.UpdateDeviceProfileApplicationLayerParams(context.Background(), ts.tx, ts.Device.DeviceProfileID, params))
+
+		cmd := clocksync.Command{
+			CID: clocksync.AppTimeReq,
+			Payload: &clocksync.AppTimeReqPayload{
+				DeviceTime: uint32((deviceGPSTime / time.Second) % (1 << 32)),
+				Param: clocksync.AppTimeReqPayloadParam{
+					AnsRequired: true,
+				},
+			},
+		}
+		b, err := cmd.MarshalBinary()
+		assert.NoError(err)
+		assert.NoError(HandleClockSyncCommand(context.Background(), ts.tx, ts.Device.DevEUI, serverGPSTime, b))
+
+		queueReq := <-ts.NSClient.CreateDeviceQueueItemChan
+		assert.EqualValues(100, queueReq.Item.FPort)
+	})
+}
+
+func (ts *ClockSyncTestSuite) TestDeviceAppTimePeriodicityReq() {
+	ts.T().Run("Invalid periodicity", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(ErrInvalidPeriodicity, EnqueueDeviceAppTimePeriodicityReq(context.Background(), ts.tx, ts.Device.DevEUI, 16))
+	})
+
+	ts.T().Run("Enqueue", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(EnqueueDeviceAppTimePeriodicityReq(context.Background(), ts.tx, ts.Device.DevEUI, 4))
+
+		queueReq := <-ts.NSClient.CreateDeviceQueueItemChan
+		assert.Equal(clocksync.DefaultFPort, uint8(queueReq.Item.FPort))
+
+		b, err := lorawan.EncryptFRMPayload(ts.Device.AppSKey, false, ts.Device.DevAddr, 0, queueReq.Item.FrmPayload)
+		assert.NoError(err)
+
+		var req clocksync.Command
+		assert.NoError(req.UnmarshalBinary(false, b))
+		assert.Equal(clocksync.Command{
+			CID: clocksync.DeviceAppTimePeriodicityReq,
+			Payload: &clocksync.DeviceAppTimePeriodicityReqPayload{
+				Periodicity: clocksync.DeviceAppTimePeriodicityReqPayloadPeriodicity{
+					Period: 4,
+				},
+			},
+		}, req)
+
+		cs, err := storage.GetDeviceClockSync(context.Background(), ts.tx, ts.Device.DevEUI, false)
+		assert.NoError(err)
+		assert.NotNil(cs.Periodicity)
+		assert.Equal(4, *cs.Periodicity)
+		assert.NotNil(cs.PeriodicityRequestedAt)
+		assert.Nil(cs.PeriodicityAnsweredAt)
+	})
+
+	ts.T().Run("DeviceAppTimePeriodicityAns", func(t *testing.T) {
+		assert := require.New(t)
+
+		deviceTime := time.Now()
+		serverTime := deviceTime.Add(-5 * time.Second)
+
+		cmd := clocksync.Command{
+			CID: clocksync.DeviceAppTimePeriodicityAns,
+			Payload: &clocksync.DeviceAppTimePeriodicityAnsPayload{
+				Status: clocksync.DeviceAppTimePeriodicityAnsPayloadStatus{
+					NotSupported: true,
+				},
+				Time: uint32((gps.Time(deviceTime).TimeSinceGPSEpoch() / time.Second) % (1 << 32)),
+			},
+		}
+		b, err := cmd.MarshalBinary()
+		assert.NoError(err)
+		assert.NoError(HandleClockSyncCommand(context.Background(), ts.tx, ts.Device.DevEUI, gps.Time(serverTime).TimeSinceGPSEpoch(), b))
+
+		cs, err := storage.GetDeviceClockSync(context.Background(), ts.tx, ts.Device.DevEUI, false)
+		assert.NoError(err)
+		assert.Equal(-5, cs.ClockDrift)
+		assert.NotNil(cs.PeriodicityAnsweredAt)
+		assert.True(cs.PeriodicityNotSupported)
+
+		pl := <-ts.Integration.SendIntegrationNotificationChan
+		assert.Equal("clock_drift", pl.EventType)
+	})
+
+	ts.T().Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		params := storage.DefaultApplicationLayerParams()
+		params.ClockSyncEnabled = false
+		assert.NoError(storage.UpdateDeviceProfileApplicationLayerParams(context.Background(), ts.tx, ts.Device.DeviceProfileID, params))
+
+		assert.Equal(ErrDisabled, EnqueueDeviceAppTimePeriodicityReq(context.Background(), ts.tx, ts.Device.DevEUI, 4))
+	})
+}
+
+func (ts *ClockSyncTestSuite) TestForceDeviceResyncReq() {
+	ts.T().Run("Invalid number of transmissions", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(ErrInvalidNbTransmissions, EnqueueForceDeviceResyncReq(context.Background(), ts.tx, ts.Device.DevEUI, 0))
+		assert.Equal(ErrInvalidNbTransmissions, EnqueueForceDeviceResyncReq(context.Background(), ts.tx, ts.Device.DevEUI, 8))
+	})
+
+	ts.T().Run("Enqueue", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(EnqueueForceDeviceResyncReq(context.Background(), ts.tx, ts.Device.DevEUI, 3))
+
+		queueReq := <-ts.NSClient.CreateDeviceQueueItemChan
+		assert.Equal(clocksync.DefaultFPort, uint8(queueReq.Item.FPort))
+
+		b, err := lorawan.EncryptFRMPayload(ts.Device.AppSKey, false, ts.Device.DevAddr, 0, queueReq.Item.FrmPayload)
+		assert.NoError(err)
+
+		var req clocksync.Command
+		assert.NoError(req.UnmarshalBinary(false, b))
+		assert.Equal(clocksync.Command{
+			CID: clocksync.ForceDeviceResyncReq,
+			Payload: &clocksync.ForceDeviceResyncReqPayload{
+				ForceConf: clocksync.ForceDeviceResyncReqPayloadForceConf{
+					NbTransmissions: 3,
+				},
+			},
+		}, req)
+
+		cs, err := storage.GetDeviceClockSync(context.Background(), ts.tx, ts.Device.DevEUI, false)
+		assert.NoError(err)
+		assert.Equal(3, cs.ResyncNbTransmissions)
+		assert.NotNil(cs.ResyncRequestedAt)
+	})
 }
 
 func TestClockSynchronization(t *testing.T) {
@@ -0,0 +1,89 @@
+package clocksync
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+	pb "github.com/gyh1621/chirpstack-api/go/v3/as/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// Integration event types.
+const (
+	integrationName     = "clock_sync"
+	clockDriftEventType = "clock_drift"
+)
+
+// ClockDriftEvent is published when the clock drift of a device has been
+// observed.
+type ClockDriftEvent struct {
+	DevEUI                  lorawan.EUI64 `json:"devEUI"`
+	ClockDrift              int           `json:"clockDrift"`
+	ClockDriftAt            *time.Time    `json:"clockDriftAt"`
+	PeriodicityNotSupported bool          `json:"periodicityNotSupported"`
+}
+
+func sendClockDriftEvent(ctx context.Context, db sqlx.Queryer, cs storage.DeviceClockSync) {
+	if err := publishClockDriftEvent(ctx, db, cs); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": cs.DevEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("clocksync: send clock drift event error")
+	}
+}
+
+func publishClockDriftEvent(ctx context.Context, db sqlx.Queryer, cs storage.DeviceClockSync) error {
+	d, err := storage.GetDevice(ctx, db, cs.DevEUI, false, true)
+	if err != nil {
+		return errors.Wrap(err, "get device error")
+	}
+
+	app, err := storage.GetApplication(ctx, db, d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	b, err := json.Marshal(ClockDriftEvent{
+		DevEUI:                  cs.DevEUI,
+		ClockDrift:              cs.ClockDrift,
+		ClockDriftAt:            cs.ClockDriftAt,
+		PeriodicityNotSupported: cs.PeriodicityNotSupported,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(app.ID),
+		ApplicationName: app.Name,
+		DeviceName:      d.Name,
+		DevEui:          cs.DevEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: integrationName,
+		EventType:       clockDriftEventType,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range d.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	vars := make(map[string]string)
+	for k, v := range d.Variables.Map {
+		if v.Valid {
+			vars[k] = v.String
+		}
+	}
+
+	return integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, vars, pl)
+}
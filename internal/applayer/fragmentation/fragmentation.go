@@ -2,6 +2,7 @@ package fragmentation
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"time"
 
@@ -12,11 +13,27 @@ import (
 
 	"github.com/brocaar/lorawan"
 	"github.com/brocaar/lorawan/applayer/fragmentation"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/packageversion"
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/fuota"
 	"github.com/gyh1621/chirpstack-application-server/internal/logging"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
+// Fragmentation package v2 commands, not implemented by the lorawan
+// applayer/fragmentation package.
+const (
+	// FragDataBlockAuthReq is sent by the device after it reconstructed the
+	// data block. Its payload contains the FragIndex (1 byte, bits 0-1) and
+	// the MIC computed by the device over the data block (4 bytes).
+	FragDataBlockAuthReq fragmentation.CID = 0x05
+
+	// FragDataBlockAuthAns contains the FragIndex (bits 0-1) and the
+	// MICMismatch flag (bit 2), set when the MIC reported by the device does
+	// not match the MIC of the data block.
+	FragDataBlockAuthAns fragmentation.CID = 0x05
+)
+
 var (
 	syncInterval  time.Duration
 	syncRetries   int
@@ -56,7 +73,9 @@ func SyncRemoteFragmentationSessionsLoop() {
 }
 
 // HandleRemoteFragmentationSessionCommand handles an uplink fragmentation session command.
-func HandleRemoteFragmentationSessionCommand(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, b []byte) error {
+// The FUOTA device state events are added to events, to be published once
+// the transaction has been committed.
+func HandleRemoteFragmentationSessionCommand(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, b []byte, events *fuota.Events) error {
 	var cmd fragmentation.Command
 
 	if err := cmd.UnmarshalBinary(true, b); err != nil {
@@ -64,6 +83,14 @@ func HandleRemoteFragmentationSessionCommand(ctx context.Context, db sqlx.Ext, d
 	}
 
 	switch cmd.CID {
+	case fragmentation.PackageVersionAns:
+		pl, ok := cmd.Payload.(*fragmentation.PackageVersionAnsPayload)
+		if !ok {
+			return fmt.Errorf("expected *fragmentation.PackageVersionAnsPayload, got: %T", cmd.Payload)
+		}
+		if err := packageversion.HandlePackageVersionAns(ctx, db, devEUI, packageversion.Fragmentation, pl.PackageIdentifier, pl.PackageVersion); err != nil {
+			return errors.Wrap(err, "handle PackageVersionAns error")
+		}
 	case fragmentation.FragSessionSetupAns:
 		pl, ok := cmd.Payload.(*fragmentation.FragSessionSetupAnsPayload)
 		if !ok {
@@ -85,9 +112,13 @@ func HandleRemoteFragmentationSessionCommand(ctx context.Context, db sqlx.Ext, d
 		if !ok {
 			return fmt.Errorf("expected *fragmentation.FragSessionStatusAns, got: %T", cmd.Payload)
 		}
-		if err := handleFragSessionStatusAns(ctx, db, devEUI, pl); err != nil {
+		if err := handleFragSessionStatusAns(ctx, db, devEUI, pl, events); err != nil {
 			return errors.Wrap(err, "handle FragSessionStatusAns error")
 		}
+	case FragDataBlockAuthReq:
+		if err := handleFragDataBlockAuthReq(ctx, db, devEUI, b[1:], events); err != nil {
+			return errors.Wrap(err, "handle FragDataBlockAuthReq error")
+		}
 	default:
 		return fmt.Errorf("CID not implemented: %s", cmd.CID)
 	}
@@ -157,7 +188,21 @@ func syncRemoteFragmentationSession(ctx context.Context, db sqlx.Ext, item stora
 		return errors.Wrap(err, "marshal binary error")
 	}
 
-	_, err = storage.EnqueueDownlinkPayload(ctx, db, item.DevEUI, false, fragmentation.DefaultFPort, b)
+	// the v2 FragSessionSetupReq appends the SessionCnt and the MIC of the
+	// data block
+	if cmd.CID == fragmentation.FragSessionSetupReq && item.PackageVersion >= packageversion.Version2 {
+		sessionCnt := make([]byte, 2)
+		binary.LittleEndian.PutUint16(sessionCnt, uint16(item.SessionCnt))
+		b = append(b, sessionCnt...)
+		b = append(b, item.MIC[:]...)
+	}
+
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, item.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	_, err = storage.EnqueueDownlinkPayload(ctx, db, item.DevEUI, false, params.FragmentationFPort, b)
 	if err != nil {
 		return errors.Wrap(err, "enqueue downlink payload error")
 	}
@@ -232,7 +277,7 @@ func handleFragSessionDeleteAns(ctx context.Context, db sqlx.Ext, devEUI lorawan
 	return nil
 }
 
-func handleFragSessionStatusAns(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, pl *fragmentation.FragSessionStatusAnsPayload) error {
+func handleFragSessionStatusAns(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, pl *fragmentation.FragSessionStatusAnsPayload, events *fuota.Events) error {
 	log.WithFields(log.Fields{
 		"dev_eui":                  devEUI,
 		"frag_index":               pl.ReceivedAndIndex.FragIndex,
@@ -247,10 +292,23 @@ func handleFragSessionStatusAns(ctx context.Context, db sqlx.Ext, devEUI lorawan
 		return errors.Wrap(err, "get pending fuota deployment device error")
 	}
 
+	rfs, err := storage.GetRemoteFragmentationSession(ctx, db, devEUI, int(pl.ReceivedAndIndex.FragIndex), false)
+	if err != nil && err != storage.ErrDoesNotExist {
+		return errors.Wrap(err, "get remote fragmentation session error")
+	}
+
+	prevState := fdd.State
+	prevErrorMessage := fdd.ErrorMessage
+
 	fdd.State = storage.FUOTADeploymentDeviceSuccess
+	fdd.ErrorMessage = ""
+	fdd.NbFragReceived = int(pl.ReceivedAndIndex.NbFragReceived)
+	fdd.MissingFrag = int(pl.MissingFrag)
 
+	// the device stays pending as the missing fragments might be recovered
+	// by a repair session, see the fuota package
 	if pl.MissingFrag > 0 {
-		fdd.State = storage.FUOTADeploymentDeviceError
+		fdd.State = storage.FUOTADeploymentDevicePending
 		fdd.ErrorMessage = fmt.Sprintf("%d fragments missed (%d received).", pl.MissingFrag, pl.ReceivedAndIndex.NbFragReceived)
 	}
 
@@ -259,10 +317,88 @@ func handleFragSessionStatusAns(ctx context.Context, db sqlx.Ext, devEUI lorawan
 		fdd.ErrorMessage = "Not enough matrix memory."
 	}
 
+	if rfs.DataBlockAuthOK != nil && !*rfs.DataBlockAuthOK {
+		fdd.State = storage.FUOTADeploymentDeviceError
+		fdd.ErrorMessage = dataBlockAuthErrorMessage
+	}
+
 	err = storage.UpdateFUOTADeploymentDevice(ctx, db, &fdd)
 	if err != nil {
 		return errors.Wrap(err, "update fuota deployment device error")
 	}
 
+	if fdd.State != prevState || fdd.ErrorMessage != prevErrorMessage {
+		events.AddDeviceState(fdd.FUOTADeploymentID, devEUI)
+	}
+
+	return nil
+}
+
+const dataBlockAuthErrorMessage = "Data block integrity check failed."
+
+func handleFragDataBlockAuthReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, pl []byte, events *fuota.Events) error {
+	if len(pl) != 5 {
+		return fmt.Errorf("expected 5 bytes, got: %d", len(pl))
+	}
+
+	fragIndex := int(pl[0] & 0x03)
+	var mic [4]byte
+	copy(mic[:], pl[1:])
+
+	rfs, err := storage.GetRemoteFragmentationSession(ctx, db, devEUI, fragIndex, true)
+	if err != nil {
+		return errors.Wrap(err, "get remote fragmentation session error")
+	}
+
+	authOK := rfs.PackageVersion >= packageversion.Version2 && mic == rfs.MIC
+	rfs.DataBlockAuthOK = &authOK
+
+	log.WithFields(log.Fields{
+		"dev_eui":    devEUI,
+		"frag_index": fragIndex,
+		"auth_ok":    authOK,
+		"ctx_id":     ctx.Value(logging.ContextIDKey),
+	}).Info("FragDataBlockAuthReq received")
+
+	if err := storage.UpdateRemoteFragmentationSession(ctx, db, &rfs); err != nil {
+		return errors.Wrap(err, "update remote fragmentation session error")
+	}
+
+	status := uint8(fragIndex)
+	if !authOK {
+		status |= 1 << 2
+	}
+
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	_, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, params.FragmentationFPort, []byte{byte(FragDataBlockAuthAns), status})
+	if err != nil {
+		return errors.Wrap(err, "enqueue downlink payload error")
+	}
+
+	if authOK {
+		return nil
+	}
+
+	fdd, err := storage.GetPendingFUOTADeploymentDevice(ctx, db, devEUI)
+	if err != nil {
+		if err == storage.ErrDoesNotExist {
+			return nil
+		}
+		return errors.Wrap(err, "get pending fuota deployment device error")
+	}
+
+	fdd.State = storage.FUOTADeploymentDeviceError
+	fdd.ErrorMessage = dataBlockAuthErrorMessage
+
+	if err := storage.UpdateFUOTADeploymentDevice(ctx, db, &fdd); err != nil {
+		return errors.Wrap(err, "update fuota deployment device error")
+	}
+
+	events.AddDeviceState(fdd.FUOTADeploymentID, devEUI)
+
 	return nil
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/brocaar/lorawan/applayer/fragmentation"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/gyh1621/chirpstack-application-server/internal/fuota"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 	"github.com/gyh1621/chirpstack-application-server/internal/test"
 )
@@ -152,6 +153,101 @@ func (ts *FragmentationSessionTestSuite) TestSyncFragSessionSetupReq() {
 	}, cmd)
 }
 
+func (ts *FragmentationSessionTestSuite) TestSyncFragSessionSetupReqV2() {
+	assert := require.New(ts.T())
+	rfs := storage.RemoteFragmentationSession{
+		DevEUI:              ts.Device.DevEUI,
+		FragIndex:           1,
+		NbFrag:              10,
+		FragSize:            50,
+		FragmentationMatrix: 5,
+		BlockAckDelay:       3,
+		Padding:             2,
+		Descriptor:          [4]byte{1, 2, 3, 4},
+		State:               storage.RemoteMulticastSetupSetup,
+		RetryInterval:       time.Minute,
+		PackageVersion:      2,
+		SessionCnt:          258,
+		MIC:                 [4]byte{5, 6, 7, 8},
+	}
+	assert.NoError(storage.CreateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
+	assert.NoError(syncRemoteFragmentationSessions(context.Background(), ts.tx))
+
+	req := <-ts.NSClient.CreateDeviceQueueItemChan
+	b, err := lorawan.EncryptFRMPayload(ts.Device.AppSKey, false, ts.Device.DevAddr, 0, req.Item.FrmPayload)
+	assert.NoError(err)
+
+	// v1 FragSessionSetupReq + SessionCnt + MIC
+	assert.Len(b, 17)
+	assert.Equal(byte(fragmentation.FragSessionSetupReq), b[0])
+	assert.Equal([]byte{2, 1, 5, 6, 7, 8}, b[11:])
+}
+
+func (ts *FragmentationSessionTestSuite) TestFragDataBlockAuthReq() {
+	assert := require.New(ts.T())
+
+	fd := storage.FUOTADeployment{
+		Name: "test-deployment",
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	rfs := storage.RemoteFragmentationSession{
+		DevEUI:         ts.Device.DevEUI,
+		FragIndex:      1,
+		State:          storage.RemoteMulticastSetupSetup,
+		PackageVersion: 2,
+		SessionCnt:     1,
+		MIC:            [4]byte{1, 2, 3, 4},
+	}
+	assert.NoError(storage.CreateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
+
+	tests := []struct {
+		Name          string
+		MIC           []byte
+		ExpectedAns   []byte
+		ExpectedOK    bool
+		ExpectedState storage.FUOTADeploymentDeviceState
+	}{
+		{
+			Name:          "valid mic",
+			MIC:           []byte{1, 2, 3, 4},
+			ExpectedAns:   []byte{0x05, 0x01},
+			ExpectedOK:    true,
+			ExpectedState: storage.FUOTADeploymentDevicePending,
+		},
+		{
+			Name:          "invalid mic",
+			MIC:           []byte{4, 3, 2, 1},
+			ExpectedAns:   []byte{0x05, 0x05},
+			ExpectedOK:    false,
+			ExpectedState: storage.FUOTADeploymentDeviceError,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b := append([]byte{byte(FragDataBlockAuthReq), 0x01}, tst.MIC...)
+			assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b, &fuota.Events{}))
+
+			req := <-ts.NSClient.CreateDeviceQueueItemChan
+			b, err := lorawan.EncryptFRMPayload(ts.Device.AppSKey, false, ts.Device.DevAddr, 0, req.Item.FrmPayload)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedAns, b)
+
+			rfs, err := storage.GetRemoteFragmentationSession(context.Background(), ts.tx, ts.Device.DevEUI, 1, false)
+			assert.NoError(err)
+			assert.NotNil(rfs.DataBlockAuthOK)
+			assert.Equal(tst.ExpectedOK, *rfs.DataBlockAuthOK)
+
+			fdd, err := storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedState, fdd.State)
+		})
+	}
+}
+
 func (ts *FragmentationSessionTestSuite) TestSyncFragSessionDeleteReq() {
 	assert := require.New(ts.T())
 
@@ -218,7 +314,7 @@ func (ts *FragmentationSessionTestSuite) TestFragSessionSetupAns() {
 		}
 		b, err := cmd.MarshalBinary()
 		assert.NoError(err)
-		assert.Equal("handle FragSessionSetupAns error: WrongDescriptor: true, FragSessionIndexNotSupported: false, NotEnoughMemory: false, EncodingUnsupported: false", HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b).Error())
+		assert.Equal("handle FragSessionSetupAns error: WrongDescriptor: true, FragSessionIndexNotSupported: false, NotEnoughMemory: false, EncodingUnsupported: false", HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b, &fuota.Events{}).Error())
 	})
 
 	ts.T().Run("OK", func(t *testing.T) {
@@ -234,7 +330,7 @@ func (ts *FragmentationSessionTestSuite) TestFragSessionSetupAns() {
 		}
 		b, err := cmd.MarshalBinary()
 		assert.NoError(err)
-		assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b))
+		assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b, &fuota.Events{}))
 
 		rfs, err := storage.GetRemoteFragmentationSession(context.Background(), ts.tx, ts.Device.DevEUI, 1, false)
 		assert.NoError(err)
@@ -266,7 +362,7 @@ func (ts *FragmentationSessionTestSuite) TestFragSessionDeleteAns() {
 		}
 		b, err := cmd.MarshalBinary()
 		assert.NoError(err)
-		assert.Equal("handle FragSessionDeleteAns error: FragIndex 1 does not exist", HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b).Error())
+		assert.Equal("handle FragSessionDeleteAns error: FragIndex 1 does not exist", HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b, &fuota.Events{}).Error())
 	})
 
 	ts.T().Run("OK", func(t *testing.T) {
@@ -282,7 +378,7 @@ func (ts *FragmentationSessionTestSuite) TestFragSessionDeleteAns() {
 		}
 		b, err := cmd.MarshalBinary()
 		assert.NoError(err)
-		assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b))
+		assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b, &fuota.Events{}))
 
 		rfs, err := storage.GetRemoteFragmentationSession(context.Background(), ts.tx, ts.Device.DevEUI, 1, false)
 		assert.NoError(err)
@@ -324,7 +420,7 @@ func (ts *FragmentationSessionTestSuite) TestFragSessionStatusAns() {
 				},
 				MissingFrag: 20,
 			},
-			ExpectedState:        storage.FUOTADeploymentDeviceError,
+			ExpectedState:        storage.FUOTADeploymentDevicePending,
 			ExpectedErrorMessage: "20 fragments missed (10 received).",
 		},
 		{
@@ -359,13 +455,18 @@ func (ts *FragmentationSessionTestSuite) TestFragSessionStatusAns() {
 			b, err := cmd.MarshalBinary()
 			assert.NoError(err)
 
-			assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b))
+			assert.NoError(HandleRemoteFragmentationSessionCommand(context.Background(), ts.tx, ts.Device.DevEUI, b, &fuota.Events{}))
 
 			devices, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
 			assert.NoError(err)
 			assert.Len(devices, 1)
 			assert.Equal(tst.ExpectedState, devices[0].State)
 			assert.Equal(tst.ExpectedErrorMessage, devices[0].ErrorMessage)
+
+			fdd, err = storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+			assert.NoError(err)
+			assert.EqualValues(tst.FragSessionStatusAns.ReceivedAndIndex.NbFragReceived, fdd.NbFragReceived)
+			assert.EqualValues(tst.FragSessionStatusAns.MissingFrag, fdd.MissingFrag)
 		})
 	}
 }
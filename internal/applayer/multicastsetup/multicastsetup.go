@@ -13,6 +13,7 @@ import (
 	"github.com/brocaar/lorawan"
 	"github.com/brocaar/lorawan/applayer/multicastsetup"
 	"github.com/brocaar/lorawan/gps"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/packageversion"
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
 	"github.com/gyh1621/chirpstack-application-server/internal/logging"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
@@ -90,6 +91,14 @@ func HandleRemoteMulticastSetupCommand(ctx context.Context, db sqlx.Ext, devEUI
 	}
 
 	switch cmd.CID {
+	case multicastsetup.PackageVersionAns:
+		pl, ok := cmd.Payload.(*multicastsetup.PackageVersionAnsPayload)
+		if !ok {
+			return fmt.Errorf("expected *multicastsetup.PackageVersionAnsPayload, got: %T", cmd.Payload)
+		}
+		if err := packageversion.HandlePackageVersionAns(ctx, db, devEUI, packageversion.MulticastSetup, pl.PackageIdentifier, pl.PackageVersion); err != nil {
+			return errors.Wrap(err, "handle PackageVersionAns error")
+		}
 	case multicastsetup.McGroupSetupAns:
 		pl, ok := cmd.Payload.(*multicastsetup.McGroupSetupAnsPayload)
 		if !ok {
@@ -155,6 +164,15 @@ func handleMcGroupSetupAns(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI6
 		}
 		return nil
 	}); err != nil {
+		// the device is set up again
+		if errors.Cause(err) == storage.ErrAlreadyExists {
+			log.WithFields(log.Fields{
+				"dev_eui":            devEUI,
+				"multicast_group_id": rms.MulticastGroupID,
+				"ctx_id":             ctx.Value(logging.ContextIDKey),
+			}).Warning("applayer/multicastsetup: adding device to multicast group, but device was already added")
+			return nil
+		}
 		return err
 	}
 
@@ -287,7 +305,12 @@ func syncRemoteMulticastSetupItem(ctx context.Context, db sqlx.Ext, item storage
 		return errors.Wrap(err, "marshal binary error")
 	}
 
-	_, err = storage.EnqueueDownlinkPayload(ctx, db, item.DevEUI, false, multicastsetup.DefaultFPort, b)
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, item.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	_, err = storage.EnqueueDownlinkPayload(ctx, db, item.DevEUI, false, params.MulticastSetupFPort, b)
 	if err != nil {
 		return errors.Wrap(err, "enqueue downlink payload error")
 	}
@@ -345,7 +368,12 @@ func syncRemoteMulticastClassCSessionItem(ctx context.Context, db sqlx.Ext, item
 		return errors.Wrap(err, "marshal binary error")
 	}
 
-	_, err = storage.EnqueueDownlinkPayload(ctx, db, item.DevEUI, false, multicastsetup.DefaultFPort, b)
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, item.DevEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	_, err = storage.EnqueueDownlinkPayload(ctx, db, item.DevEUI, false, params.MulticastSetupFPort, b)
 	if err != nil {
 		return errors.Wrap(err, "enqueue downlink payload error")
 	}
@@ -0,0 +1,195 @@
+// Package packageversion implements the application-layer package version
+// negotiation (PackageVersionReq / PackageVersionAns), shared by the clock
+// synchronization, remote multicast setup and fragmented data block
+// transport packages.
+package packageversion
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// Package defines the application-layer package identifier.
+type Package uint8
+
+// Application-layer package identifiers.
+const (
+	ClockSync      Package = 1
+	MulticastSetup Package = 2
+	Fragmentation  Package = 3
+)
+
+func (p Package) String() string {
+	switch p {
+	case ClockSync:
+		return "clock_sync"
+	case MulticastSetup:
+		return "multicast_setup"
+	case Fragmentation:
+		return "fragmentation"
+	default:
+		return fmt.Sprintf("Package(%d)", uint8(p))
+	}
+}
+
+// Package versions.
+const (
+	Version1 = 1
+	Version2 = 2
+
+	// DefaultVersion is assumed when the device did not report its
+	// package version.
+	DefaultVersion = Version1
+)
+
+// packageVersionReq contains the PackageVersionReq command. The CID is the
+// same for all packages and the command does not have a payload.
+var packageVersionReq = []byte{0x00}
+
+// Errors
+var (
+	ErrInvalidPackageIdentifier = errors.New("invalid package identifier")
+	ErrUnsupportedVersion       = errors.New("unsupported package version")
+)
+
+// Get returns the negotiated version of the given package. The
+// DefaultVersion is returned when the device did not report the version.
+func Get(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, pkg Package) (int, error) {
+	dal, err := storage.GetDeviceApplicationLayer(ctx, db, devEUI, false)
+	if err != nil {
+		if err == storage.ErrDoesNotExist {
+			return DefaultVersion, nil
+		}
+		return 0, errors.Wrap(err, "get device application-layer error")
+	}
+
+	if v := versionField(&dal, pkg); v != nil && *v != nil {
+		return **v, nil
+	}
+
+	return DefaultVersion, nil
+}
+
+// GetForUpdate returns the locked application-layer state of the given
+// device. The state is created when it does not exist yet.
+func GetForUpdate(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) (storage.DeviceApplicationLayer, error) {
+	dal, err := storage.GetDeviceApplicationLayer(ctx, db, devEUI, true)
+	if err == nil {
+		return dal, nil
+	}
+	if err != storage.ErrDoesNotExist {
+		return dal, errors.Wrap(err, "get device application-layer error")
+	}
+
+	dal = storage.DeviceApplicationLayer{
+		DevEUI: devEUI,
+	}
+	if err := storage.CreateDeviceApplicationLayer(ctx, db, &dal); err != nil {
+		return dal, errors.Wrap(err, "create device application-layer error")
+	}
+
+	return dal, nil
+}
+
+// HandlePackageVersionAns stores the package version reported by the
+// PackageVersionAns of the given package.
+func HandlePackageVersionAns(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64, pkg Package, packageIdentifier, packageVersion uint8) error {
+	log.WithFields(log.Fields{
+		"dev_eui":            devEUI,
+		"package":            pkg,
+		"package_identifier": packageIdentifier,
+		"package_version":    packageVersion,
+		"ctx_id":             ctx.Value(logging.ContextIDKey),
+	}).Info("PackageVersionAns received")
+
+	if Package(packageIdentifier) != pkg {
+		return ErrInvalidPackageIdentifier
+	}
+
+	if packageVersion < Version1 || packageVersion > Version2 {
+		return ErrUnsupportedVersion
+	}
+
+	dal, err := GetForUpdate(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	v := int(packageVersion)
+	*versionField(&dal, pkg) = &v
+
+	if err := storage.UpdateDeviceApplicationLayer(ctx, db, &dal); err != nil {
+		return errors.Wrap(err, "update device application-layer error")
+	}
+
+	return nil
+}
+
+// EnqueuePackageVersionReq enqueues a PackageVersionReq for each
+// application-layer package enabled by the device-profile of the given
+// device.
+func EnqueuePackageVersionReq(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error {
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	var fPorts []uint8
+	if params.ClockSyncEnabled {
+		fPorts = append(fPorts, params.ClockSyncFPort)
+	}
+	if params.MulticastSetupEnabled {
+		fPorts = append(fPorts, params.MulticastSetupFPort)
+	}
+	if params.FragmentationEnabled {
+		fPorts = append(fPorts, params.FragmentationFPort)
+	}
+
+	for _, fPort := range fPorts {
+		if _, err := storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, fPort, packageVersionReq); err != nil {
+			return errors.Wrap(err, "enqueue downlink payload error")
+		}
+
+		log.WithFields(log.Fields{
+			"dev_eui": devEUI,
+			"f_port":  fPort,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Info("PackageVersionReq enqueued")
+	}
+
+	dal, err := GetForUpdate(ctx, db, devEUI)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dal.PackageVersionRequestedAt = &now
+
+	if err := storage.UpdateDeviceApplicationLayer(ctx, db, &dal); err != nil {
+		return errors.Wrap(err, "update device application-layer error")
+	}
+
+	return nil
+}
+
+func versionField(dal *storage.DeviceApplicationLayer, pkg Package) **int {
+	switch pkg {
+	case ClockSync:
+		return &dal.ClockSyncVersion
+	case MulticastSetup:
+		return &dal.MulticastSetupVersion
+	case Fragmentation:
+		return &dal.FragmentationVersion
+	default:
+		return nil
+	}
+}
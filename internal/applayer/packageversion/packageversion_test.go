@@ -0,0 +1,161 @@
+package packageversion
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+	"github.com/gyh1621/chirpstack-application-server/internal/test"
+)
+
+type PackageVersionTestSuite struct {
+	suite.Suite
+	tx *storage.TxLogger
+
+	NSClient       *nsmock.Client
+	NetworkServer  storage.NetworkServer
+	Organization   storage.Organization
+	ServiceProfile storage.ServiceProfile
+	Application    storage.Application
+	DeviceProfile  storage.DeviceProfile
+	Device         storage.Device
+}
+
+func (ts *PackageVersionTestSuite) SetupSuite() {
+	assert := require.New(ts.T())
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+	test.MustResetDB(storage.DB().DB)
+}
+
+func (ts *PackageVersionTestSuite) TearDownTest() {
+	ts.tx.Rollback()
+}
+
+func (ts *PackageVersionTestSuite) SetupTest() {
+	assert := require.New(ts.T())
+
+	ts.NSClient = nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(ts.NSClient))
+
+	var err error
+	ts.tx, err = storage.DB().Beginx()
+	assert.NoError(err)
+
+	ts.NetworkServer = storage.NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(storage.CreateNetworkServer(context.Background(), ts.tx, &ts.NetworkServer))
+
+	ts.Organization = storage.Organization{
+		Name: "test-org",
+	}
+	assert.NoError(storage.CreateOrganization(context.Background(), ts.tx, &ts.Organization))
+
+	ts.ServiceProfile = storage.ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  ts.Organization.ID,
+		NetworkServerID: ts.NetworkServer.ID,
+	}
+	assert.NoError(storage.CreateServiceProfile(context.Background(), ts.tx, &ts.ServiceProfile))
+	var spID uuid.UUID
+	copy(spID[:], ts.ServiceProfile.ServiceProfile.Id)
+
+	ts.Application = storage.Application{
+		Name:             "test-app",
+		OrganizationID:   ts.Organization.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(storage.CreateApplication(context.Background(), ts.tx, &ts.Application))
+
+	ts.DeviceProfile = storage.DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  ts.Organization.ID,
+		NetworkServerID: ts.NetworkServer.ID,
+	}
+	assert.NoError(storage.CreateDeviceProfile(context.Background(), ts.tx, &ts.DeviceProfile))
+	var dpID uuid.UUID
+	copy(dpID[:], ts.DeviceProfile.DeviceProfile.Id)
+
+	ts.Device = storage.Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   ts.Application.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+		Description:     "test device",
+	}
+	assert.NoError(storage.CreateDevice(context.Background(), ts.tx, &ts.Device))
+}
+
+func (ts *PackageVersionTestSuite) TestGet() {
+	assert := require.New(ts.T())
+
+	v, err := Get(context.Background(), ts.tx, ts.Device.DevEUI, Fragmentation)
+	assert.NoError(err)
+	assert.Equal(DefaultVersion, v)
+}
+
+func (ts *PackageVersionTestSuite) TestHandlePackageVersionAns() {
+	ts.T().Run("Invalid package identifier", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(ErrInvalidPackageIdentifier, HandlePackageVersionAns(context.Background(), ts.tx, ts.Device.DevEUI, Fragmentation, 1, 2))
+	})
+
+	ts.T().Run("Unsupported version", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(ErrUnsupportedVersion, HandlePackageVersionAns(context.Background(), ts.tx, ts.Device.DevEUI, Fragmentation, 3, 3))
+	})
+
+	ts.T().Run("Version 2", func(t *testing.T) {
+		assert := require.New(t)
+		assert.NoError(HandlePackageVersionAns(context.Background(), ts.tx, ts.Device.DevEUI, Fragmentation, 3, 2))
+		assert.NoError(HandlePackageVersionAns(context.Background(), ts.tx, ts.Device.DevEUI, ClockSync, 1, 1))
+
+		v, err := Get(context.Background(), ts.tx, ts.Device.DevEUI, Fragmentation)
+		assert.NoError(err)
+		assert.Equal(Version2, v)
+
+		v, err = Get(context.Background(), ts.tx, ts.Device.DevEUI, ClockSync)
+		assert.NoError(err)
+		assert.Equal(Version1, v)
+
+		v, err = Get(context.Background(), ts.tx, ts.Device.DevEUI, MulticastSetup)
+		assert.NoError(err)
+		assert.Equal(DefaultVersion, v)
+	})
+}
+
+func (ts *PackageVersionTestSuite) TestEnqueuePackageVersionReq() {
+	assert := require.New(ts.T())
+
+	params := storage.DefaultApplicationLayerParams()
+	params.MulticastSetupEnabled = false
+	assert.NoError(storage.UpdateDeviceProfileApplicationLayerParams(context.Background(), ts.tx, ts.Device.DeviceProfileID, params))
+
+	assert.NoError(EnqueuePackageVersionReq(context.Background(), ts.tx, ts.Device.DevEUI))
+
+	for _, fPort := range []uint8{params.ClockSyncFPort, params.FragmentationFPort} {
+		req := <-ts.NSClient.CreateDeviceQueueItemChan
+		assert.EqualValues(fPort, req.Item.FPort)
+
+		b, err := lorawan.EncryptFRMPayload(ts.Device.AppSKey, false, ts.Device.DevAddr, 0, req.Item.FrmPayload)
+		assert.NoError(err)
+		assert.Equal([]byte{0x00}, b)
+	}
+
+	dal, err := storage.GetDeviceApplicationLayer(context.Background(), ts.tx, ts.Device.DevEUI, false)
+	assert.NoError(err)
+	assert.NotNil(dal.PackageVersionRequestedAt)
+}
+
+func TestPackageVersion(t *testing.T) {
+	suite.Run(t, new(PackageVersionTestSuite))
+}
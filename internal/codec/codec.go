@@ -2,9 +2,11 @@ package codec
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/gyh1621/chirpstack-application-server/internal/codec/cayennelpp"
 	"github.com/gyh1621/chirpstack-application-server/internal/codec/js"
+	"github.com/gyh1621/chirpstack-application-server/internal/codec/protobuf"
 	"github.com/lib/pq/hstore"
 )
 
@@ -16,10 +18,54 @@ const (
 	None                = ""
 	CayenneLPPType Type = "CAYENNE_LPP"
 	CustomJSType   Type = "CUSTOM_JS"
+	ProtobufType   Type = "PROTOBUF"
 )
 
+// DeviceContext contains the uplink meta-data and persistent device state
+// exposed to the (JavaScript) decoder.
+type DeviceContext = js.DeviceContext
+
+// LogEntry contains a console log entry written by the (JavaScript) codec.
+type LogEntry = js.LogEntry
+
+// ProtobufSchema defines the schema used by the Protobuf codec.
+type ProtobufSchema struct {
+	// DescriptorSet contains the compiled (binary) FileDescriptorSet.
+	DescriptorSet []byte
+
+	// Messages maps the fPort to the fully-qualified message name.
+	Messages hstore.Hstore
+}
+
+// Validate validates the Protobuf schema.
+func (s ProtobufSchema) Validate() error {
+	var names []string
+	for k, v := range s.Messages.Map {
+		if _, err := strconv.ParseUint(k, 10, 8); err != nil {
+			return fmt.Errorf("invalid fPort: %s", k)
+		}
+		if v.Valid {
+			names = append(names, v.String)
+		}
+	}
+
+	return protobuf.ValidateDescriptorSet(s.DescriptorSet, names...)
+}
+
+// messageName returns the message name for the given fPort.
+func (s ProtobufSchema) messageName(fPort uint8) (string, error) {
+	v, ok := s.Messages.Map[strconv.FormatUint(uint64(fPort), 10)]
+	if !ok || !v.Valid {
+		return "", fmt.Errorf("no protobuf message configured for fPort: %d", fPort)
+	}
+	return v.String, nil
+}
+
 // BinaryToJSON encodes the given binary payload to JSON.
-func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript string, b []byte) ([]byte, error) {
+// It returns the JSON, the console log entries written by the codec (if
+// supported by the codec type) and a possible error. The device context
+// is only used by the JavaScript codec and may be nil.
+func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript string, protoSchema ProtobufSchema, devCtx *DeviceContext, b []byte) ([]byte, []LogEntry, error) {
 	vars := make(map[string]string)
 	for k, v := range variables.Map {
 		if v.Valid {
@@ -29,16 +75,26 @@ func BinaryToJSON(t Type, fPort uint8, variables hstore.Hstore, decodeScript str
 
 	switch t {
 	case CayenneLPPType:
-		return cayennelpp.BinaryToJSON(b)
+		out, err := cayennelpp.BinaryToJSON(b)
+		return out, nil, err
 	case CustomJSType:
-		return js.BinaryToJSON(fPort, vars, decodeScript, b)
+		return js.BinaryToJSON(fPort, vars, decodeScript, devCtx, b)
+	case ProtobufType:
+		msg, err := protoSchema.messageName(fPort)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := protobuf.BinaryToJSON(protoSchema.DescriptorSet, msg, b)
+		return out, nil, err
 	default:
-		return nil, fmt.Errorf("unknown codec type: %s", t)
+		return nil, nil, fmt.Errorf("unknown codec type: %s", t)
 	}
 }
 
 // JSONToBinary encodes the given JSON to binary.
-func JSONToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript string, jsonB []byte) ([]byte, error) {
+// It returns the bytes, the console log entries written by the codec (if
+// supported by the codec type) and a possible error.
+func JSONToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript string, protoSchema ProtobufSchema, jsonB []byte) ([]byte, []LogEntry, error) {
 	vars := make(map[string]string)
 	for k, v := range variables.Map {
 		if v.Valid {
@@ -48,10 +104,18 @@ func JSONToBinary(t Type, fPort uint8, variables hstore.Hstore, encodeScript str
 
 	switch t {
 	case CayenneLPPType:
-		return cayennelpp.JSONToBinary(jsonB)
+		out, err := cayennelpp.JSONToBinary(jsonB)
+		return out, nil, err
 	case CustomJSType:
 		return js.JSONToBinary(fPort, vars, encodeScript, jsonB)
+	case ProtobufType:
+		msg, err := protoSchema.messageName(fPort)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, err := protobuf.JSONToBinary(protoSchema.DescriptorSet, msg, jsonB)
+		return out, nil, err
 	default:
-		return nil, fmt.Errorf("unknown codec type: %s", t)
+		return nil, nil, fmt.Errorf("unknown codec type: %s", t)
 	}
 }
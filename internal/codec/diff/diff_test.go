@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		Name     string
+		A        string
+		B        string
+		Expected string
+	}{
+		{
+			Name:     "equal",
+			A:        "a\nb\n",
+			B:        "a\nb\n",
+			Expected: "",
+		},
+		{
+			Name: "from empty",
+			A:    "",
+			B:    "a\nb\n",
+			Expected: `--- a
++++ b
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		},
+		{
+			Name: "to empty",
+			A:    "a\n",
+			B:    "",
+			Expected: `--- a
++++ b
+@@ -1 +0,0 @@
+-a
+`,
+		},
+		{
+			Name: "change with context",
+			A:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			B:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			Expected: `--- a
++++ b
+@@ -3,5 +3,5 @@
+ 3
+ 4
+-5
++five
+ 6
+ 7
+`,
+		},
+		{
+			Name: "multiple hunks",
+			A:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			B:    "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			Expected: `--- a
++++ b
+@@ -1,3 +1,3 @@
+-1
++one
+ 2
+ 3
+@@ -8,3 +8,3 @@
+ 8
+ 9
+-10
++ten
+`,
+		},
+		{
+			Name: "merged hunks",
+			A:    "1\n2\n3\n4\n5\n",
+			B:    "one\n2\n3\n4\nfive\n",
+			Expected: `--- a
++++ b
+@@ -1,5 +1,5 @@
+-1
++one
+ 2
+ 3
+ 4
+-5
++five
+`,
+		},
+		{
+			Name: "insertion",
+			A:    "function Decode(fPort, bytes) {\n  return {};\n}\n",
+			B:    "function Decode(fPort, bytes) {\n  console.log(fPort);\n  return {};\n}\n",
+			Expected: `--- a
++++ b
+@@ -1,3 +1,4 @@
+ function Decode(fPort, bytes) {
++  console.log(fPort);
+   return {};
+ }
+`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, Unified(tst.A, tst.B, "a", "b", 2))
+		})
+	}
+}
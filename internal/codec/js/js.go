@@ -4,17 +4,84 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
 	"github.com/pkg/errors"
 	"github.com/robertkrimen/otto"
+	"github.com/robertkrimen/otto/parser"
 )
 
 var (
 	maxExecutionTime = 10 * time.Millisecond
 )
 
+const (
+	// maxDeviceStateSize defines the max. size of the JSON encoded device state.
+	maxDeviceStateSize = 4096
+
+	// maxLogEntries defines the max. number of console log entries captured
+	// per execution.
+	maxLogEntries = 100
+
+	// maxLogMessageSize defines the max. size of a console log message.
+	maxLogMessageSize = 1024
+)
+
+// Console log levels.
+const (
+	LogLevelLog  = "log"
+	LogLevelWarn = "warn"
+)
+
+// stackLocationRegexp matches the location of the first frame of the otto
+// error stack-trace, e.g. "at Decode (<anonymous>:3:9)".
+var stackLocationRegexp = regexp.MustCompile(`at .*?:(\d+):(\d+)\)?\n`)
+
+// LogEntry contains a line written to the console by the script.
+type LogEntry struct {
+	// Level contains the log level (log or warn).
+	Level string
+
+	// Message contains the log message.
+	Message string
+}
+
+// ScriptError contains the script error and the location of the failure,
+// when available.
+type ScriptError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+// Error implements the error interface.
+func (e *ScriptError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Line, e.Column)
+}
+
+// DeviceContext contains the uplink meta-data and the persistent device
+// state, which are exposed to the Decode function as context argument.
+type DeviceContext struct {
+	// FCnt contains the uplink frame-counter.
+	FCnt uint32
+
+	// RecvTime contains the uplink receive time.
+	RecvTime time.Time
+
+	// State contains the JSON encoded device state. After execution, this
+	// contains the state as updated by the script (nil when the state is
+	// empty or cleared by the script).
+	State []byte
+}
+
 // Setup configures the JS codec.
 func Setup(conf config.Config) error {
 	maxExecutionTime = conf.ApplicationServer.Codec.JS.MaxExecutionTime
@@ -22,8 +89,10 @@ func Setup(conf config.Config) error {
 }
 
 // BinaryToJSON encodes the given binary payload to JSON.
-func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string, b []byte) ([]byte, error) {
-	decodeScript = decodeScript + "\n\nDecode(fPort, bytes, variables);\n"
+// It returns the JSON, the entries written to the console by the script
+// and a possible error. When devCtx is nil, the script is executed with an
+// empty device state.
+func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string, devCtx *DeviceContext, b []byte) ([]byte, []LogEntry, error) {
 
 	vars := make(map[string]interface{})
 
@@ -31,38 +100,45 @@ func BinaryToJSON(fPort uint8, variables map[string]string, decodeScript string,
 	vars["bytes"] = b
 	vars["variables"] = variables
 
-	v, err := executeJS(decodeScript, vars)
+	if devCtx == nil {
+		devCtx = &DeviceContext{RecvTime: time.Now()}
+	}
+
+	v, logs, err := executeJS(decodeScript, "Decode(fPort, bytes, variables, context);", vars, devCtx)
 	if err != nil {
-		return nil, errors.Wrap(err, "execute js error")
+		return nil, logs, errors.Wrap(err, "execute js error")
 	}
 
-	return json.Marshal(v)
+	out, err := json.Marshal(v)
+	return out, logs, err
 }
 
 // JSONToBinary encodes the given JSON payload to binary.
-func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string, b []byte) ([]byte, error) {
+// It returns the bytes, the entries written to the console by the script
+// and a possible error.
+func JSONToBinary(fPort uint8, variables map[string]string, encodeScript string, b []byte) ([]byte, []LogEntry, error) {
 	var v interface{}
 	if err := json.Unmarshal(b, &v); err != nil {
-		return nil, errors.Wrap(err, "unmarshal json error")
+		return nil, nil, errors.Wrap(err, "unmarshal json error")
 	}
 
-	encodeScript = encodeScript + "\n\nEncode(fPort, obj, variables);"
-
 	vars := make(map[string]interface{})
 
 	vars["fPort"] = fPort
 	vars["obj"] = v
 	vars["variables"] = variables
 
-	v, err := executeJS(encodeScript, vars)
+	v, logs, err := executeJS(encodeScript, "Encode(fPort, obj, variables);", vars, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "execute js error")
+		return nil, logs, errors.Wrap(err, "execute js error")
 	}
 
-	return interfaceToByteSlice(v)
+	out, err := interfaceToByteSlice(v)
+	return out, logs, err
 }
 
-func executeJS(script string, vars map[string]interface{}) (out interface{}, err error) {
+// executeJS executes the given script, followed by the given call.
+func executeJS(script, call string, vars map[string]interface{}, devCtx *DeviceContext) (out interface{}, logs []LogEntry, err error) {
 	defer func() {
 		if caught := recover(); caught != nil {
 			err = fmt.Errorf("%s", caught)
@@ -75,7 +151,34 @@ func executeJS(script string, vars map[string]interface{}) (out interface{}, err
 
 	for k, v := range vars {
 		if err := vm.Set(k, v); err != nil {
-			return nil, errors.Wrap(err, "set variable error")
+			return nil, nil, errors.Wrap(err, "set variable error")
+		}
+	}
+
+	console, err := vm.Object("console = {}")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create console object error")
+	}
+	for _, level := range []string{LogLevelLog, LogLevelWarn} {
+		level := level
+		if err := console.Set(level, func(call otto.FunctionCall) otto.Value {
+			if len(logs) < maxLogEntries {
+				logs = append(logs, LogEntry{
+					Level:   level,
+					Message: formatLogArguments(call.ArgumentList),
+				})
+			}
+			return otto.UndefinedValue()
+		}); err != nil {
+			return nil, nil, errors.Wrapf(err, "set console.%s error", level)
+		}
+	}
+
+	var contextObj *otto.Object
+	if devCtx != nil {
+		contextObj, err = setDeviceContext(vm, devCtx)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "set context error")
 		}
 	}
 
@@ -87,13 +190,157 @@ func executeJS(script string, vars map[string]interface{}) (out interface{}, err
 	}()
 
 	var val otto.Value
-	val, err = vm.Run(script)
+	val, err = vm.Run(script + "\n\n" + call + "\n")
 	if err != nil {
-		fmt.Println(err)
-		return nil, errors.Wrap(err, "js vm error")
+		return nil, logs, errors.Wrap(newScriptError(err, strings.Count(script, "\n")+1), "js vm error")
 	}
 
-	return val.Export()
+	if contextObj != nil {
+		if err := getDeviceState(vm, contextObj, devCtx); err != nil {
+			return nil, logs, errors.Wrap(err, "get context state error")
+		}
+	}
+
+	out, err = val.Export()
+	return out, logs, err
+}
+
+// setDeviceContext creates the context object, containing the uplink
+// meta-data and the device state.
+func setDeviceContext(vm *otto.Otto, devCtx *DeviceContext) (*otto.Object, error) {
+	obj, err := vm.Object("context = {}")
+	if err != nil {
+		return nil, errors.Wrap(err, "create context object error")
+	}
+
+	if err := obj.Set("fCnt", devCtx.FCnt); err != nil {
+		return nil, errors.Wrap(err, "set fCnt error")
+	}
+
+	recvTime, err := vm.Run(fmt.Sprintf("new Date(%d)", devCtx.RecvTime.UnixNano()/int64(time.Millisecond)))
+	if err != nil {
+		return nil, errors.Wrap(err, "create recvTime error")
+	}
+	if err := obj.Set("recvTime", recvTime); err != nil {
+		return nil, errors.Wrap(err, "set recvTime error")
+	}
+
+	state := "{}"
+	if len(devCtx.State) != 0 {
+		state = string(devCtx.State)
+	}
+	stateVal, err := vm.Call("JSON.parse", nil, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse state error")
+	}
+	if err := obj.Set("state", stateVal); err != nil {
+		return nil, errors.Wrap(err, "set state error")
+	}
+
+	return obj, nil
+}
+
+// getDeviceState reads the (updated) device state from the context object.
+func getDeviceState(vm *otto.Otto, obj *otto.Object, devCtx *DeviceContext) error {
+	stateVal, err := obj.Get("state")
+	if err != nil {
+		return err
+	}
+
+	if stateVal.IsUndefined() || stateVal.IsNull() {
+		devCtx.State = nil
+		return nil
+	}
+
+	if !stateVal.IsObject() {
+		return errors.New("state must be an object")
+	}
+
+	b, err := vm.Call("JSON.stringify", nil, stateVal)
+	if err != nil {
+		return errors.Wrap(err, "stringify state error")
+	}
+
+	if len(b.String()) > maxDeviceStateSize {
+		return fmt.Errorf("state exceeds max size of %d bytes", maxDeviceStateSize)
+	}
+
+	// an empty state does not need to be persisted
+	if b.String() == "{}" {
+		devCtx.State = nil
+		return nil
+	}
+
+	devCtx.State = []byte(b.String())
+	return nil
+}
+
+// newScriptError returns the ScriptError for the given otto error, including
+// the location of the failure when available. Locations after the given
+// number of script lines are within the appended function call and are
+// therefore omitted.
+func newScriptError(err error, scriptLines int) *ScriptError {
+	e := scriptError(err)
+	if e.Line > scriptLines {
+		e.Line = 0
+		e.Column = 0
+	}
+	return e
+}
+
+func scriptError(err error) *ScriptError {
+	switch v := err.(type) {
+	case *otto.Error:
+		e := ScriptError{Message: v.Error()}
+		if m := stackLocationRegexp.FindStringSubmatch(v.String()); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Column, _ = strconv.Atoi(m[2])
+		}
+		return &e
+	case parser.ErrorList:
+		if len(v) != 0 {
+			return &ScriptError{
+				Message: "SyntaxError: " + v[0].Message,
+				Line:    v[0].Position.Line,
+				Column:  v[0].Position.Column,
+			}
+		}
+	case *parser.Error:
+		return &ScriptError{
+			Message: "SyntaxError: " + v.Message,
+			Line:    v.Position.Line,
+			Column:  v.Position.Column,
+		}
+	}
+
+	return &ScriptError{Message: err.Error()}
+}
+
+// formatLogArguments formats the console arguments as a single line.
+// Objects are formatted as JSON. Messages exceeding maxLogMessageSize are
+// truncated on a rune boundary.
+func formatLogArguments(args []otto.Value) string {
+	var parts []string
+	for _, arg := range args {
+		if arg.IsObject() {
+			if v, err := arg.Export(); err == nil {
+				if b, err := json.Marshal(v); err == nil {
+					parts = append(parts, string(b))
+					continue
+				}
+			}
+		}
+		parts = append(parts, arg.String())
+	}
+	msg := strings.Join(parts, " ")
+	if len(msg) > maxLogMessageSize {
+		i := maxLogMessageSize
+		for i > 0 && !utf8.RuneStart(msg[i]) {
+			i--
+		}
+		msg = msg[:i] + "..."
+	}
+	return msg
 }
 
 func interfaceToByteSlice(obj interface{}) ([]byte, error) {
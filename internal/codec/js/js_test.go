@@ -1,7 +1,10 @@
 package js
 
 import (
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
@@ -82,7 +85,7 @@ func TestJSDecode(t *testing.T) {
 		t.Run(tst.Name, func(t *testing.T) {
 			assert := require.New(t)
 
-			jsonB, err := BinaryToJSON(tst.FPort, tst.Variables, tst.Script, tst.Payload)
+			jsonB, _, err := BinaryToJSON(tst.FPort, tst.Variables, tst.Script, nil, tst.Payload)
 			if tst.ExpectedError != nil {
 				assert.Equal(tst.ExpectedError.Error(), err.Error())
 				return
@@ -192,7 +195,7 @@ func TestJSEncode(t *testing.T) {
 		t.Run(tst.Name, func(t *testing.T) {
 			assert := require.New(t)
 
-			b, err := JSONToBinary(tst.FPort, tst.Variables, tst.Script, []byte(tst.JSON))
+			b, _, err := JSONToBinary(tst.FPort, tst.Variables, tst.Script, []byte(tst.JSON))
 			if tst.ExpectedError != nil {
 				assert.Equal(tst.ExpectedError.Error(), err.Error())
 				return
@@ -202,3 +205,153 @@ func TestJSEncode(t *testing.T) {
 		})
 	}
 }
+
+func TestJSConsoleLog(t *testing.T) {
+	assert := require.New(t)
+
+	script := `
+		function Decode(fPort, bytes) {
+			console.log("fPort:", fPort);
+			console.warn({"length": bytes.length});
+			return {};
+		}
+	`
+
+	_, logs, err := BinaryToJSON(10, nil, script, nil, []byte{1, 2, 3})
+	assert.NoError(err)
+	assert.Equal([]LogEntry{
+		{Level: LogLevelLog, Message: "fPort: 10"},
+		{Level: LogLevelWarn, Message: `{"length":3}`},
+	}, logs)
+}
+
+func TestJSConsoleLogTruncate(t *testing.T) {
+	assert := require.New(t)
+
+	// the 3 byte rune crosses maxLogMessageSize
+	script := `
+		function Decode(fPort, bytes) {
+			console.log(Array(1023).join("a") + "€€");
+			return {};
+		}
+	`
+
+	_, logs, err := BinaryToJSON(10, nil, script, nil, []byte{1, 2, 3})
+	assert.NoError(err)
+	assert.Len(logs, 1)
+	assert.True(utf8.ValidString(logs[0].Message))
+	assert.Equal(strings.Repeat("a", 1022)+"...", logs[0].Message)
+}
+
+func TestJSScriptError(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Script   string
+		Expected ScriptError
+	}{
+		{
+			Name: "runtime error",
+			Script: `function Decode(fPort, bytes) {
+	var obj;
+	return obj.foo;
+}`,
+			Expected: ScriptError{
+				Message: "TypeError: Cannot access member 'foo' of undefined",
+				Line:    3,
+				Column:  9,
+			},
+		},
+		{
+			Name: "syntax error",
+			Script: `function Decode(fPort, bytes) {
+	var a = ;
+}`,
+			Expected: ScriptError{
+				Message: "SyntaxError: Unexpected token ;",
+				Line:    2,
+				Column:  10,
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			_, _, err := BinaryToJSON(10, nil, tst.Script, nil, []byte{1})
+			assert.Error(err)
+
+			scriptErr, ok := errors.Cause(err).(*ScriptError)
+			assert.True(ok)
+			assert.Equal(tst.Expected, *scriptErr)
+		})
+	}
+}
+
+func TestJSDeviceContext(t *testing.T) {
+	script := `
+		function Decode(fPort, bytes, variables, context) {
+			var last = context.state.last || 0;
+			context.state.last = last + bytes[0];
+			return {
+				"value": context.state.last,
+				"fCnt": context.fCnt,
+				"recvTime": context.recvTime.toISOString()
+			};
+		}
+	`
+
+	t.Run("Empty state", func(t *testing.T) {
+		assert := require.New(t)
+
+		devCtx := DeviceContext{
+			FCnt:     10,
+			RecvTime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+
+		b, _, err := BinaryToJSON(10, nil, script, &devCtx, []byte{5})
+		assert.NoError(err)
+		assert.JSONEq(`{"value": 5, "fCnt": 10, "recvTime": "2020-01-02T03:04:05.000Z"}`, string(b))
+		assert.Equal(`{"last":5}`, string(devCtx.State))
+
+		t.Run("Existing state", func(t *testing.T) {
+			assert := require.New(t)
+
+			devCtx.FCnt = 11
+			b, _, err := BinaryToJSON(10, nil, script, &devCtx, []byte{3})
+			assert.NoError(err)
+			assert.JSONEq(`{"value": 8, "fCnt": 11, "recvTime": "2020-01-02T03:04:05.000Z"}`, string(b))
+			assert.Equal(`{"last":8}`, string(devCtx.State))
+		})
+	})
+
+	t.Run("Clear state", func(t *testing.T) {
+		assert := require.New(t)
+
+		devCtx := DeviceContext{
+			State: []byte(`{"last":5}`),
+		}
+
+		_, _, err := BinaryToJSON(10, nil, `
+			function Decode(fPort, bytes, variables, context) {
+				context.state = null;
+				return {};
+			}
+		`, &devCtx, []byte{5})
+		assert.NoError(err)
+		assert.Nil(devCtx.State)
+	})
+
+	t.Run("State too large", func(t *testing.T) {
+		assert := require.New(t)
+
+		var devCtx DeviceContext
+		_, _, err := BinaryToJSON(10, nil, `
+			function Decode(fPort, bytes, variables, context) {
+				context.state.data = new Array(5000).join("x");
+				return {};
+			}
+		`, &devCtx, []byte{5})
+		assert.Error(err)
+	})
+}
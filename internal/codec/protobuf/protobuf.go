@@ -0,0 +1,856 @@
+// Package protobuf implements a payload codec which decodes and encodes
+// payloads using dynamic Protobuf messages described by a compiled
+// FileDescriptorSet (e.g. the output of protoc --descriptor_set_out).
+package protobuf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/pkg/errors"
+)
+
+// wire types
+const (
+	wireVarint     = 0
+	wireFixed64    = 1
+	wireBytes      = 2
+	wireStartGroup = 3
+	wireEndGroup   = 4
+	wireFixed32    = 5
+)
+
+// BinaryToJSON decodes the given binary payload as the given message type
+// and returns it as JSON.
+func BinaryToJSON(descriptorSet []byte, messageName string, b []byte) ([]byte, error) {
+	s, err := newSchema(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := s.message(messageName)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.decodeMessage(md, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode message error")
+	}
+
+	return json.Marshal(obj)
+}
+
+// JSONToBinary encodes the given JSON object as the given message type and
+// returns the binary payload.
+func JSONToBinary(descriptorSet []byte, messageName string, jsonB []byte) ([]byte, error) {
+	s, err := newSchema(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := s.message(messageName)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(jsonB))
+	dec.UseNumber()
+
+	var obj map[string]interface{}
+	if err := dec.Decode(&obj); err != nil {
+		return nil, errors.Wrap(err, "unmarshal json error")
+	}
+
+	b, err := s.encodeMessage(md, obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode message error")
+	}
+
+	return b, nil
+}
+
+// ValidateDescriptorSet validates that the given FileDescriptorSet can be
+// parsed and that it contains the given message types.
+func ValidateDescriptorSet(descriptorSet []byte, messageNames ...string) error {
+	s, err := newSchema(descriptorSet)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range messageNames {
+		if _, err := s.message(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type schema struct {
+	messages map[string]*descriptor.DescriptorProto
+	enums    map[string]*descriptor.EnumDescriptorProto
+}
+
+func newSchema(descriptorSet []byte) (*schema, error) {
+	var fds descriptor.FileDescriptorSet
+	if err := proto.Unmarshal(descriptorSet, &fds); err != nil {
+		return nil, errors.Wrap(err, "unmarshal file descriptor set error")
+	}
+
+	s := schema{
+		messages: make(map[string]*descriptor.DescriptorProto),
+		enums:    make(map[string]*descriptor.EnumDescriptorProto),
+	}
+
+	for _, fd := range fds.GetFile() {
+		prefix := fd.GetPackage()
+		for _, ed := range fd.GetEnumType() {
+			s.enums[fullName(prefix, ed.GetName())] = ed
+		}
+		for _, md := range fd.GetMessageType() {
+			s.addMessage(prefix, md)
+		}
+	}
+
+	return &s, nil
+}
+
+func (s *schema) addMessage(prefix string, md *descriptor.DescriptorProto) {
+	name := fullName(prefix, md.GetName())
+	s.messages[name] = md
+
+	for _, ed := range md.GetEnumType() {
+		s.enums[fullName(name, ed.GetName())] = ed
+	}
+	for _, nested := range md.GetNestedType() {
+		s.addMessage(name, nested)
+	}
+}
+
+func (s *schema) message(name string) (*descriptor.DescriptorProto, error) {
+	md, ok := s.messages[strings.TrimPrefix(name, ".")]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type: %s", name)
+	}
+	return md, nil
+}
+
+func (s *schema) enum(name string) (*descriptor.EnumDescriptorProto, error) {
+	ed, ok := s.enums[strings.TrimPrefix(name, ".")]
+	if !ok {
+		return nil, fmt.Errorf("unknown enum type: %s", name)
+	}
+	return ed, nil
+}
+
+// mapEntry returns the map-entry descriptor in case the given field is a
+// map field, else it returns nil.
+func (s *schema) mapEntry(fd *descriptor.FieldDescriptorProto) *descriptor.DescriptorProto {
+	if fd.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED || fd.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil
+	}
+
+	md, err := s.message(fd.GetTypeName())
+	if err != nil || !md.GetOptions().GetMapEntry() {
+		return nil
+	}
+
+	return md
+}
+
+func (s *schema) decodeMessage(md *descriptor.DescriptorProto, b []byte) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	fields := make(map[int32]*descriptor.FieldDescriptorProto)
+
+	// the occurrences of non-repeated embedded messages are merged by
+	// decoding their concatenated encodings, see decodeEmbeddedMessages
+	embedded := make(map[int32][]byte)
+
+	for _, fd := range md.GetField() {
+		fields[fd.GetNumber()] = fd
+
+		// emit the default value for singular scalar fields so that a
+		// measurement of e.g. 0 is not omitted from the object
+		if fd.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED && fd.OneofIndex == nil && fd.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			v, err := s.defaultValue(fd)
+			if err != nil {
+				return nil, err
+			}
+			out[jsonName(fd)] = v
+		}
+	}
+
+	r := reader{b: b}
+	for !r.eof() {
+		key, err := r.varint()
+		if err != nil {
+			return nil, errors.Wrap(err, "read field key error")
+		}
+		num := int32(key >> 3)
+		wireType := int(key & 7)
+
+		fd, ok := fields[num]
+		if !ok {
+			if err := r.skip(wireType); err != nil {
+				return nil, errors.Wrapf(err, "skip unknown field %d error", num)
+			}
+			continue
+		}
+
+		if entry := s.mapEntry(fd); entry != nil {
+			if wireType != wireBytes {
+				return nil, fmt.Errorf("field %s: invalid wire type %d", fd.GetName(), wireType)
+			}
+			eb, err := r.bytes()
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", fd.GetName())
+			}
+			kv, err := s.decodeMessage(entry, eb)
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", fd.GetName())
+			}
+
+			m, _ := out[jsonName(fd)].(map[string]interface{})
+			if m == nil {
+				m = make(map[string]interface{})
+				out[jsonName(fd)] = m
+			}
+			m[fmt.Sprintf("%v", kv["key"])] = kv["value"]
+			continue
+		}
+
+		if fd.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED && fd.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			if wireType != wireBytes {
+				return nil, fmt.Errorf("field %s: invalid wire type %d", fd.GetName(), wireType)
+			}
+			eb, err := r.bytes()
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", fd.GetName())
+			}
+			embedded[num] = append(embedded[num], eb...)
+			continue
+		}
+
+		var values []interface{}
+		if wireType == wireBytes && isPackable(fd.GetType()) {
+			pb, err := r.bytes()
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", fd.GetName())
+			}
+			pr := reader{b: pb}
+			for !pr.eof() {
+				v, err := s.decodeValue(&pr, fd, scalarWireType(fd.GetType()))
+				if err != nil {
+					return nil, errors.Wrapf(err, "field %s", fd.GetName())
+				}
+				values = append(values, v)
+			}
+		} else {
+			v, err := s.decodeValue(&r, fd, wireType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "field %s", fd.GetName())
+			}
+			values = append(values, v)
+		}
+
+		if fd.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			list, _ := out[jsonName(fd)].([]interface{})
+			out[jsonName(fd)] = append(list, values...)
+		} else {
+			// last one wins
+			out[jsonName(fd)] = values[len(values)-1]
+		}
+	}
+
+	if err := s.decodeEmbeddedMessages(out, fields, embedded); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// decodeEmbeddedMessages decodes the given non-repeated embedded messages
+// (by field number) into out. Each message contains the concatenation of
+// all its occurrences, which results in the merge of these occurrences as
+// required by the Protobuf encoding (scalars of the last occurrence win,
+// repeated fields are concatenated and embedded messages are merged).
+func (s *schema) decodeEmbeddedMessages(out map[string]interface{}, fields map[int32]*descriptor.FieldDescriptorProto, embedded map[int32][]byte) error {
+	for num, b := range embedded {
+		fd := fields[num]
+		md, err := s.message(fd.GetTypeName())
+		if err != nil {
+			return errors.Wrapf(err, "field %s", fd.GetName())
+		}
+		v, err := s.decodeMessage(md, b)
+		if err != nil {
+			return errors.Wrapf(err, "field %s", fd.GetName())
+		}
+		out[jsonName(fd)] = v
+	}
+
+	return nil
+}
+
+func (s *schema) decodeValue(r *reader, fd *descriptor.FieldDescriptorProto, wireType int) (interface{}, error) {
+	t := fd.GetType()
+	if t == descriptor.FieldDescriptorProto_TYPE_GROUP {
+		return nil, errors.New("groups are not supported")
+	}
+
+	if expected := scalarWireType(t); wireType != expected {
+		return nil, fmt.Errorf("invalid wire type %d, expected %d", wireType, expected)
+	}
+
+	// 64 bit integers are represented as string by the Protobuf JSON
+	// mapping, as JavaScript numbers can not represent all 64 bit values
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		v, err := r.fixed64()
+		return math.Float64frombits(v), err
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		v, err := r.fixed32()
+		return float64(math.Float32frombits(v)), err
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		v, err := r.fixed64()
+		return strconv.FormatUint(v, 10), err
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		v, err := r.fixed64()
+		return strconv.FormatInt(int64(v), 10), err
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return r.fixed32()
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		v, err := r.fixed32()
+		return int32(v), err
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		v, err := r.bytes()
+		return string(v), err
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		v, err := r.bytes()
+		return append([]byte{}, v...), err
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		md, err := s.message(fd.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return s.decodeMessage(md, v)
+	}
+
+	v, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_INT64:
+		return strconv.FormatInt(int64(v), 10), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT64:
+		return strconv.FormatUint(v, 10), nil
+	case descriptor.FieldDescriptorProto_TYPE_INT32:
+		return int32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT32:
+		return uint32(v), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return int32(uint32(v)>>1) ^ -int32(v&1), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return strconv.FormatInt(int64(v>>1)^-int64(v&1), 10), nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return v != 0, nil
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		ed, err := s.enum(fd.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range ed.GetValue() {
+			if ev.GetNumber() == int32(v) {
+				return ev.GetName(), nil
+			}
+		}
+		// unknown enum values are returned as number
+		return int32(v), nil
+	}
+
+	return nil, fmt.Errorf("unsupported field type: %s", t)
+}
+
+func (s *schema) defaultValue(fd *descriptor.FieldDescriptorProto) (interface{}, error) {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return "", nil
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return []byte{}, nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return false, nil
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return "0", nil
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		ed, err := s.enum(fd.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		if len(ed.GetValue()) == 0 {
+			return int32(0), nil
+		}
+		return ed.GetValue()[0].GetName(), nil
+	default:
+		return 0, nil
+	}
+}
+
+func (s *schema) encodeMessage(md *descriptor.DescriptorProto, obj map[string]interface{}) ([]byte, error) {
+	byName := make(map[string]*descriptor.FieldDescriptorProto)
+	for _, fd := range md.GetField() {
+		byName[jsonName(fd)] = fd
+		byName[fd.GetName()] = fd
+	}
+
+	// sort the keys so that the output is deterministic
+	var keys []string
+	for k := range obj {
+		if _, ok := byName[k]; !ok {
+			return nil, fmt.Errorf("unknown field: %s", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return byName[keys[i]].GetNumber() < byName[keys[j]].GetNumber()
+	})
+
+	var w writer
+	for _, k := range keys {
+		fd := byName[k]
+		v := obj[k]
+		if v == nil {
+			continue
+		}
+
+		if entry := s.mapEntry(fd); entry != nil {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("field %s: expected object", k)
+			}
+
+			var mapKeys []string
+			for mk := range m {
+				mapKeys = append(mapKeys, mk)
+			}
+			sort.Strings(mapKeys)
+
+			for _, mk := range mapKeys {
+				b, err := s.encodeMapEntry(entry, mk, m[mk])
+				if err != nil {
+					return nil, errors.Wrapf(err, "field %s", k)
+				}
+				w.key(fd.GetNumber(), wireBytes)
+				w.bytes(b)
+			}
+			continue
+		}
+
+		if fd.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("field %s: expected array", k)
+			}
+
+			if isPackable(fd.GetType()) {
+				var pw writer
+				for _, item := range list {
+					if err := s.encodeValue(&pw, fd, item); err != nil {
+						return nil, errors.Wrapf(err, "field %s", k)
+					}
+				}
+				w.key(fd.GetNumber(), wireBytes)
+				w.bytes(pw.b)
+				continue
+			}
+
+			for _, item := range list {
+				w.key(fd.GetNumber(), scalarWireType(fd.GetType()))
+				if err := s.encodeValue(&w, fd, item); err != nil {
+					return nil, errors.Wrapf(err, "field %s", k)
+				}
+			}
+			continue
+		}
+
+		w.key(fd.GetNumber(), scalarWireType(fd.GetType()))
+		if err := s.encodeValue(&w, fd, v); err != nil {
+			return nil, errors.Wrapf(err, "field %s", k)
+		}
+	}
+
+	return w.b, nil
+}
+
+func (s *schema) encodeMapEntry(md *descriptor.DescriptorProto, key string, value interface{}) ([]byte, error) {
+	var keyFD, valueFD *descriptor.FieldDescriptorProto
+	for _, fd := range md.GetField() {
+		switch fd.GetNumber() {
+		case 1:
+			keyFD = fd
+		case 2:
+			valueFD = fd
+		}
+	}
+	if keyFD == nil || valueFD == nil {
+		return nil, errors.New("invalid map entry")
+	}
+
+	var k interface{} = key
+	if keyFD.GetType() != descriptor.FieldDescriptorProto_TYPE_STRING {
+		k = json.Number(key)
+	}
+	if keyFD.GetType() == descriptor.FieldDescriptorProto_TYPE_BOOL {
+		b, err := strconv.ParseBool(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse map key error")
+		}
+		k = b
+	}
+
+	var w writer
+	w.key(1, scalarWireType(keyFD.GetType()))
+	if err := s.encodeValue(&w, keyFD, k); err != nil {
+		return nil, errors.Wrap(err, "map key")
+	}
+	w.key(2, scalarWireType(valueFD.GetType()))
+	if err := s.encodeValue(&w, valueFD, value); err != nil {
+		return nil, errors.Wrap(err, "map value")
+	}
+
+	return w.b, nil
+}
+
+func (s *schema) encodeValue(w *writer, fd *descriptor.FieldDescriptorProto, v interface{}) error {
+	switch fd.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		f, err := toFloat(v)
+		if err != nil {
+			return err
+		}
+		w.fixed64(math.Float64bits(f))
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		f, err := toFloat(v)
+		if err != nil {
+			return err
+		}
+		w.fixed32(math.Float32bits(float32(f)))
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_INT32:
+		i, err := toInt(v)
+		if err != nil {
+			return err
+		}
+		w.varint(uint64(i))
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_UINT32:
+		i, err := toUint(v)
+		if err != nil {
+			return err
+		}
+		w.varint(i)
+	case descriptor.FieldDescriptorProto_TYPE_SINT32, descriptor.FieldDescriptorProto_TYPE_SINT64:
+		i, err := toInt(v)
+		if err != nil {
+			return err
+		}
+		w.varint(uint64(i<<1) ^ uint64(i>>63))
+	case descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		i, err := toUint(v)
+		if err != nil {
+			return err
+		}
+		w.fixed64(i)
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		i, err := toInt(v)
+		if err != nil {
+			return err
+		}
+		w.fixed64(uint64(i))
+	case descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		i, err := toUint(v)
+		if err != nil {
+			return err
+		}
+		w.fixed32(uint32(i))
+	case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		i, err := toInt(v)
+		if err != nil {
+			return err
+		}
+		w.fixed32(uint32(i))
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expected bool, got: %T", v)
+		}
+		if b {
+			w.varint(1)
+		} else {
+			w.varint(0)
+		}
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		ed, err := s.enum(fd.GetTypeName())
+		if err != nil {
+			return err
+		}
+		if name, ok := v.(string); ok {
+			for _, ev := range ed.GetValue() {
+				if ev.GetName() == name {
+					w.varint(uint64(ev.GetNumber()))
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown enum value: %s", name)
+		}
+		i, err := toInt(v)
+		if err != nil {
+			return err
+		}
+		w.varint(uint64(i))
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got: %T", v)
+		}
+		w.bytes([]byte(str))
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expected base64 encoded string, got: %T", v)
+		}
+		b, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return errors.Wrap(err, "decode base64 error")
+		}
+		w.bytes(b)
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		md, err := s.message(fd.GetTypeName())
+		if err != nil {
+			return err
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected object, got: %T", v)
+		}
+		b, err := s.encodeMessage(md, obj)
+		if err != nil {
+			return err
+		}
+		w.bytes(b)
+	default:
+		return fmt.Errorf("unsupported field type: %s", fd.GetType())
+	}
+
+	return nil
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("expected number, got: %T", v)
+	}
+}
+
+func toInt(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		// 64 bit integers are represented as string by the Protobuf JSON
+		// mapping
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("expected integer, got: %T", v)
+	}
+}
+
+func toUint(v interface{}) (uint64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return strconv.ParseUint(string(v), 10, 64)
+	case string:
+		return strconv.ParseUint(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("expected unsigned integer, got: %T", v)
+	}
+}
+
+func fullName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// jsonName returns the lowerCamelCase JSON name of the field, as used by the
+// Protobuf JSON mapping.
+func jsonName(fd *descriptor.FieldDescriptorProto) string {
+	if fd.GetJsonName() != "" {
+		return fd.GetJsonName()
+	}
+
+	var out []byte
+	upper := false
+	for _, c := range []byte(fd.GetName()) {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		out = append(out, c)
+	}
+
+	return string(out)
+}
+
+func isPackable(t descriptor.FieldDescriptorProto_Type) bool {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	default:
+		return true
+	}
+}
+
+func scalarWireType(t descriptor.FieldDescriptorProto_Type) int {
+	switch t {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return wireFixed64
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return wireFixed32
+	case descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		return wireBytes
+	case descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return wireStartGroup
+	default:
+		return wireVarint
+	}
+}
+
+type reader struct {
+	b []byte
+	i int
+}
+
+func (r *reader) eof() bool {
+	return r.i >= len(r.b)
+}
+
+func (r *reader) varint() (uint64, error) {
+	v, n := proto.DecodeVarint(r.b[r.i:])
+	if n == 0 {
+		return 0, errors.New("invalid varint")
+	}
+	r.i += n
+	return v, nil
+}
+
+func (r *reader) fixed64() (uint64, error) {
+	if len(r.b)-r.i < 8 {
+		return 0, errors.New("unexpected end of payload")
+	}
+	v := binary.LittleEndian.Uint64(r.b[r.i:])
+	r.i += 8
+	return v, nil
+}
+
+func (r *reader) fixed32() (uint32, error) {
+	if len(r.b)-r.i < 4 {
+		return 0, errors.New("unexpected end of payload")
+	}
+	v := binary.LittleEndian.Uint32(r.b[r.i:])
+	r.i += 4
+	return v, nil
+}
+
+func (r *reader) bytes() ([]byte, error) {
+	l, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(r.b)-r.i) < l {
+		return nil, errors.New("unexpected end of payload")
+	}
+	b := r.b[r.i : r.i+int(l)]
+	r.i += int(l)
+	return b, nil
+}
+
+func (r *reader) skip(wireType int) error {
+	var err error
+	switch wireType {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireFixed32:
+		_, err = r.fixed32()
+	case wireBytes:
+		_, err = r.bytes()
+	default:
+		err = fmt.Errorf("unsupported wire type: %d", wireType)
+	}
+	return err
+}
+
+type writer struct {
+	b []byte
+}
+
+func (w *writer) key(num int32, wireType int) {
+	w.varint(uint64(num)<<3 | uint64(wireType))
+}
+
+func (w *writer) varint(v uint64) {
+	w.b = append(w.b, proto.EncodeVarint(v)...)
+}
+
+func (w *writer) fixed64(v uint64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	w.b = append(w.b, b...)
+}
+
+func (w *writer) fixed32(v uint32) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	w.b = append(w.b, b...)
+}
+
+func (w *writer) bytes(b []byte) {
+	w.varint(uint64(len(b)))
+	w.b = append(w.b, b...)
+}
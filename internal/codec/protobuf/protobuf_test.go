@@ -0,0 +1,236 @@
+package protobuf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/stretchr/testify/require"
+)
+
+func testDescriptorSet(t *testing.T) []byte {
+	assert := require.New(t)
+
+	optional := descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum()
+
+	fds := descriptor.FileDescriptorSet{
+		File: []*descriptor.FileDescriptorProto{
+			{
+				Name:    proto.String("sensor.proto"),
+				Package: proto.String("sensor"),
+				Syntax:  proto.String("proto3"),
+				EnumType: []*descriptor.EnumDescriptorProto{
+					{
+						Name: proto.String("Mode"),
+						Value: []*descriptor.EnumValueDescriptorProto{
+							{Name: proto.String("IDLE"), Number: proto.Int32(0)},
+							{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+						},
+					},
+				},
+				MessageType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("Uplink"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{Name: proto.String("temperature"), Number: proto.Int32(1), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_FLOAT.Enum()},
+							{Name: proto.String("battery_level"), Number: proto.Int32(2), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_UINT32.Enum()},
+							{Name: proto.String("mode"), Number: proto.Int32(3), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_ENUM.Enum(), TypeName: proto.String(".sensor.Mode")},
+							{Name: proto.String("readings"), Number: proto.Int32(4), Label: repeated, Type: descriptor.FieldDescriptorProto_TYPE_SINT32.Enum()},
+							{Name: proto.String("location"), Number: proto.Int32(5), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".sensor.Uplink.Location")},
+							{Name: proto.String("labels"), Number: proto.Int32(6), Label: repeated, Type: descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".sensor.Uplink.LabelsEntry")},
+						},
+						NestedType: []*descriptor.DescriptorProto{
+							{
+								Name: proto.String("Location"),
+								Field: []*descriptor.FieldDescriptorProto{
+									{Name: proto.String("latitude"), Number: proto.Int32(1), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_DOUBLE.Enum()},
+									{Name: proto.String("longitude"), Number: proto.Int32(2), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_DOUBLE.Enum()},
+								},
+							},
+							{
+								Name: proto.String("LabelsEntry"),
+								Field: []*descriptor.FieldDescriptorProto{
+									{Name: proto.String("key"), Number: proto.Int32(1), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum()},
+									{Name: proto.String("value"), Number: proto.Int32(2), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum()},
+								},
+								Options: &descriptor.MessageOptions{
+									MapEntry: proto.Bool(true),
+								},
+							},
+						},
+					},
+					{
+						Name: proto.String("Counters"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{Name: proto.String("uptime"), Number: proto.Int32(1), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_INT64.Enum()},
+							{Name: proto.String("frames"), Number: proto.Int32(2), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_UINT64.Enum()},
+							{Name: proto.String("serial"), Number: proto.Int32(3), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_FIXED64.Enum()},
+							{Name: proto.String("offset"), Number: proto.Int32(4), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_SFIXED64.Enum()},
+							{Name: proto.String("delta"), Number: proto.Int32(5), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_SINT64.Enum()},
+							{Name: proto.String("location"), Number: proto.Int32(6), Label: optional, Type: descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".sensor.Uplink.Location")},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	b, err := proto.Marshal(&fds)
+	assert.NoError(err)
+	return b
+}
+
+func TestProtobufCodec(t *testing.T) {
+	fds := testDescriptorSet(t)
+
+	tests := []struct {
+		Name          string
+		MessageName   string
+		Payload       []byte
+		JSON          string
+		ExpectedError string
+	}{
+		{
+			Name:        "default values",
+			MessageName: "sensor.Uplink",
+			Payload:     nil,
+			JSON:        `{"batteryLevel":0,"mode":"IDLE","temperature":0}`,
+		},
+		{
+			Name:        "all fields",
+			MessageName: "sensor.Uplink",
+			Payload: []byte{
+				0x0d, 0x00, 0x00, 0xb4, 0x41, // temperature = 22.5
+				0x10, 0x5a, // battery_level = 90
+				0x18, 0x01, // mode = ACTIVE
+				0x22, 0x03, 0x01, 0x02, 0x03, // readings = [-1, 1, -2] (packed)
+				0x2a, 0x12, // location
+				0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, // latitude = 1
+				0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, // longitude = 2
+				0x32, 0x08, // labels
+				0x0a, 0x01, 0x61, // key = a
+				0x12, 0x03, 0x66, 0x6f, 0x6f, // value = foo
+			},
+			JSON: `{"batteryLevel":90,"labels":{"a":"foo"},"location":{"latitude":1,"longitude":2},"mode":"ACTIVE","readings":[-1,1,-2],"temperature":22.5}`,
+		},
+		{
+			Name:        "64 bit integers",
+			MessageName: "sensor.Counters",
+			Payload: []byte{
+				0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, // uptime = -1
+				0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, // frames = 18446744073709551615
+				0x19, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01, // serial = 0x0102030405060708
+				0x21, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // offset = -2
+				0x28, 0x05, // delta = -3
+			},
+			JSON: `{"delta":"-3","frames":"18446744073709551615","offset":"-2","serial":"72623859790382856","uptime":"-1"}`,
+		},
+		{
+			Name:        "merge embedded message",
+			MessageName: "sensor.Counters",
+			Payload: []byte{
+				0x32, 0x09, // location
+				0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f, // latitude = 1
+				0x32, 0x09, // location
+				0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x40, // longitude = 2
+			},
+			JSON: `{"delta":"0","frames":"0","location":{"latitude":1,"longitude":2},"offset":"0","serial":"0","uptime":"0"}`,
+		},
+		{
+			Name:          "unknown message",
+			MessageName:   "sensor.Downlink",
+			ExpectedError: "unknown message type: sensor.Downlink",
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := BinaryToJSON(fds, tst.MessageName, tst.Payload)
+			if tst.ExpectedError != "" {
+				assert.EqualError(err, tst.ExpectedError)
+				return
+			}
+			assert.NoError(err)
+			assert.JSONEq(tst.JSON, string(b))
+
+			// encoding the decoded object must result in the same payload
+			out, err := JSONToBinary(fds, tst.MessageName, b)
+			assert.NoError(err)
+
+			b, err = BinaryToJSON(fds, tst.MessageName, out)
+			assert.NoError(err)
+			assert.JSONEq(tst.JSON, string(b))
+		})
+	}
+
+	t.Run("encode unknown field", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := JSONToBinary(fds, "sensor.Uplink", []byte(`{"humidity": 12}`))
+		assert.EqualError(err, "encode message error: unknown field: humidity")
+	})
+
+	t.Run("encode", func(t *testing.T) {
+		assert := require.New(t)
+
+		b, err := JSONToBinary(fds, "sensor.Uplink", []byte(`{"battery_level": 90, "mode": "ACTIVE"}`))
+		assert.NoError(err)
+		assert.Equal([]byte{0x10, 0x5a, 0x18, 0x01}, b)
+	})
+}
+
+func TestProtobufCodecGeneratedMessage(t *testing.T) {
+	assert := require.New(t)
+
+	// use the descriptor of a generated message to validate the dynamic
+	// decoding against the generated code
+	fdB, _ := (&duration.Duration{}).Descriptor()
+	fd, err := extractFile(fdB)
+	assert.NoError(err)
+
+	fdsB, err := proto.Marshal(&descriptor.FileDescriptorSet{
+		File: []*descriptor.FileDescriptorProto{fd},
+	})
+	assert.NoError(err)
+
+	b, err := proto.Marshal(&duration.Duration{Seconds: -10, Nanos: 500})
+	assert.NoError(err)
+
+	jsonB, err := BinaryToJSON(fdsB, "google.protobuf.Duration", b)
+	assert.NoError(err)
+	assert.JSONEq(`{"seconds":"-10","nanos":500}`, string(jsonB))
+
+	out, err := JSONToBinary(fdsB, "google.protobuf.Duration", jsonB)
+	assert.NoError(err)
+
+	var d duration.Duration
+	assert.NoError(proto.Unmarshal(out, &d))
+	assert.EqualValues(-10, d.Seconds)
+	assert.EqualValues(500, d.Nanos)
+}
+
+func extractFile(gz []byte) (*descriptor.FileDescriptorProto, error) {
+	r, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var fd descriptor.FileDescriptorProto
+	if err := proto.Unmarshal(b, &fd); err != nil {
+		return nil, err
+	}
+
+	return &fd, nil
+}
@@ -42,12 +42,58 @@ type Config struct {
 				ClientSecret            string `mapstructure:"client_secret"`
 				RedirectURL             string `mapstructure:"redirect_url"`
 				LoginLabel              string `mapstructure:"login_label"`
+				AcceptAccessTokens      bool   `mapstructure:"accept_access_tokens"`
+				AccessTokenAudience     string `mapstructure:"access_token_audience"`
+				GroupsClaim             string `mapstructure:"groups_claim"`
+				AdminGroup              string `mapstructure:"admin_group"`
+
+				GroupMapping []GroupMappingConfig `mapstructure:"group_mapping"`
 			} `mapstructure:"openid_connect"`
+
+			LoginRateLimit struct {
+				MaxFailedAttemptsPerEmail int           `mapstructure:"max_failed_attempts_per_email"`
+				MaxFailedAttemptsPerIP    int           `mapstructure:"max_failed_attempts_per_ip"`
+				Window                    time.Duration `mapstructure:"window"`
+				LockoutDuration           time.Duration `mapstructure:"lockout_duration"`
+				MaxPasswordResetsPerEmail int           `mapstructure:"max_password_resets_per_email"`
+				MaxPasswordResetsPerIP    int           `mapstructure:"max_password_resets_per_ip"`
+			} `mapstructure:"login_rate_limit"`
+
+			PasswordPolicy struct {
+				MinLength        int           `mapstructure:"min_length"`
+				RequireUppercase bool          `mapstructure:"require_uppercase"`
+				RequireLowercase bool          `mapstructure:"require_lowercase"`
+				RequireDigit     bool          `mapstructure:"require_digit"`
+				RequireSpecial   bool          `mapstructure:"require_special"`
+				HistorySize      int           `mapstructure:"history_size"`
+				MaxAge           time.Duration `mapstructure:"max_age"`
+			} `mapstructure:"password_policy"`
+
+			LDAP struct {
+				Enabled        bool                 `mapstructure:"enabled"`
+				Server         string               `mapstructure:"server"`
+				StartTLS       bool                 `mapstructure:"start_tls"`
+				CACert         string               `mapstructure:"ca_cert"`
+				BindDN         string               `mapstructure:"bind_dn"`
+				BindPassword   string               `mapstructure:"bind_password"`
+				BaseDN         string               `mapstructure:"base_dn"`
+				UserAttribute  string               `mapstructure:"user_attribute"`
+				EmailAttribute string               `mapstructure:"email_attribute"`
+				GroupAttribute string               `mapstructure:"group_attribute"`
+				AdminGroup     string               `mapstructure:"admin_group"`
+				GroupMapping   []GroupMappingConfig `mapstructure:"group_mapping"`
+				LinkLocalUsers bool                 `mapstructure:"link_local_users"`
+			} `mapstructure:"ldap"`
+
+			PasswordResetTokenTTL     time.Duration `mapstructure:"password_reset_token_ttl"`
+			EmailVerificationTokenTTL time.Duration `mapstructure:"email_verification_token_ttl"`
 		} `mapstructure:"user_authentication"`
 
 		Codec struct {
 			JS struct {
 				MaxExecutionTime time.Duration `mapstructure:"max_execution_time"`
+				DeviceStateTTL   time.Duration `mapstructure:"device_state_ttl"`
+				LogRateLimit     int           `mapstructure:"log_rate_limit"`
 			} `mapstructure:"js"`
 		} `mapstructure:"codec"`
 
@@ -78,6 +124,15 @@ type Config struct {
 			TLSKey          string `mapstructure:"tls_key"`
 			JWTSecret       string `mapstructure:"jwt_secret"`
 			CORSAllowOrigin string `mapstructure:"cors_allow_origin"`
+
+			// TrustedProxies contains the IP addresses or networks (CIDR) of
+			// the reverse-proxies from which the X-Forwarded-For header is
+			// accepted.
+			TrustedProxies []string `mapstructure:"trusted_proxies"`
+
+			JWTAlgorithm      string   `mapstructure:"jwt_algorithm"`
+			JWTPrivateKeyFile string   `mapstructure:"jwt_private_key_file"`
+			JWTPublicKeyFiles []string `mapstructure:"jwt_public_key_files"`
 		} `mapstructure:"external_api"`
 
 		RemoteMulticastSetup struct {
@@ -93,14 +148,37 @@ type Config struct {
 		} `mapstructure:"fragmentation_session"`
 
 		FUOTADeployment struct {
-			McGroupID int `mapstructure:"mc_group_id"`
-			FragIndex int `mapstructure:"frag_index"`
+			McGroupID      int `mapstructure:"mc_group_id"`
+			FragIndex      int `mapstructure:"frag_index"`
+			RepairSessions int `mapstructure:"repair_sessions"`
 		} `mapstructure:"fuota_deployment"`
 
+		FirmwareImage struct {
+			SigningPublicKey string `mapstructure:"signing_public_key"`
+			RequireSignature bool   `mapstructure:"require_signature"`
+		} `mapstructure:"firmware_image"`
+
 		Branding struct {
 			Footer       string
 			Registration string
 		} `mapstructure:"branding"`
+
+		Email struct {
+			Sender  string `mapstructure:"sender"`
+			From    string `mapstructure:"from"`
+			BaseURL string `mapstructure:"base_url"`
+
+			SMTP struct {
+				Server   string `mapstructure:"server"`
+				Username string `mapstructure:"username"`
+				Password string `mapstructure:"password"`
+				TLS      bool   `mapstructure:"tls"`
+			} `mapstructure:"smtp"`
+
+			File struct {
+				Path string `mapstructure:"path"`
+			} `mapstructure:"file"`
+		} `mapstructure:"email"`
 	} `mapstructure:"application_server"`
 
 	JoinServer struct {
@@ -225,6 +303,16 @@ type IntegrationKafkaConfig struct {
 	EventKeyTemplate string   `mapstructure:"event_key_template"`
 }
 
+// GroupMappingConfig maps a (LDAP or OpenID Connect) group to an
+// organization membership.
+type GroupMappingConfig struct {
+	Group          string `mapstructure:"group"`
+	OrganizationID int64  `mapstructure:"organization_id"`
+	IsAdmin        bool   `mapstructure:"is_admin"`
+	IsDeviceAdmin  bool   `mapstructure:"is_device_admin"`
+	IsGatewayAdmin bool   `mapstructure:"is_gateway_admin"`
+}
+
 // AzurePublishMode defines the publish-mode type.
 type AzurePublishMode string
 
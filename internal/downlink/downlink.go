@@ -75,13 +75,15 @@ func handleDataDownPayload(ctx context.Context, pl models.DataDownPayload) error
 			// device-profile codec fields.
 			payloadCodec := app.PayloadCodec
 			payloadEncoderScript := app.PayloadEncoderScript
+			var protoSchema codec.ProtobufSchema
 
 			if dp.PayloadCodec != "" {
 				payloadCodec = dp.PayloadCodec
 				payloadEncoderScript = dp.PayloadEncoderScript
+				protoSchema = dp.PayloadProtobufSchema()
 			}
 
-			pl.Data, err = codec.JSONToBinary(payloadCodec, pl.FPort, d.Variables, payloadEncoderScript, []byte(pl.Object))
+			pl.Data, _, err = codec.JSONToBinary(payloadCodec, pl.FPort, d.Variables, payloadEncoderScript, protoSchema, []byte(pl.Object))
 			if err != nil {
 				logCodecError(ctx, app, d, err)
 				return errors.Wrap(err, "encode object error")
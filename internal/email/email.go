@@ -0,0 +1,164 @@
+// Package email implements the sending of (templated) e-mail messages, e.g.
+// for the password reset and the e-mail address verification.
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+// ErrDisabled is returned when sending e-mail is not configured.
+var ErrDisabled = errors.New("sending e-mail is not configured")
+
+// Message defines an e-mail message.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+
+	// Body contains the HTML body.
+	Body string
+}
+
+// Bytes returns the message in RFC 5322 format.
+func (m Message) Bytes() []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(m.From))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(m.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(m.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/html; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	b.WriteString("\r\n")
+
+	return b.Bytes()
+}
+
+// headerValue removes the line-breaks from the given header value, to
+// prevent the injection of headers.
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Sender defines the interface for sending e-mail messages.
+type Sender interface {
+	Send(msg Message) error
+}
+
+var (
+	sender  Sender
+	from    string
+	baseURL string
+	footer  string
+)
+
+// Setup configures the package.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.Email
+
+	from = c.From
+	baseURL = strings.TrimRight(c.BaseURL, "/")
+	footer = conf.ApplicationServer.Branding.Footer
+
+	switch c.Sender {
+	case "":
+		sender = nil
+		return nil
+	case "smtp":
+		sender = &SMTPSender{
+			Server:   c.SMTP.Server,
+			Username: c.SMTP.Username,
+			Password: c.SMTP.Password,
+			TLS:      c.SMTP.TLS,
+		}
+	case "file":
+		if c.File.Path == "" {
+			return errors.New("email file path must be set")
+		}
+		sender = &FileSender{Path: c.File.Path}
+	case "log":
+		sender = &LogSender{}
+	default:
+		return fmt.Errorf("unknown email sender: %s", c.Sender)
+	}
+
+	if from == "" {
+		return errors.New("email from address must be set")
+	}
+
+	return nil
+}
+
+// Enabled returns true when sending e-mail is configured.
+func Enabled() bool {
+	return sender != nil
+}
+
+// SetSender sets the sender. This is intended for testing.
+func SetSender(s Sender) {
+	sender = s
+}
+
+// SendPasswordReset sends the password reset e-mail containing the given
+// token.
+func SendPasswordReset(to, token string, ttl time.Duration) error {
+	return send(to, passwordResetTemplate, templateData{
+		Link: link("/#/password-reset", token),
+		TTL:  ttl,
+	})
+}
+
+// SendEmailVerification sends the e-mail address verification e-mail
+// containing the given token.
+func SendEmailVerification(to, token string, ttl time.Duration) error {
+	return send(to, emailVerificationTemplate, templateData{
+		Link: link("/#/verify-email", token),
+		TTL:  ttl,
+	})
+}
+
+func send(to string, tmpl *template.Template, data templateData) error {
+	if sender == nil {
+		return ErrDisabled
+	}
+
+	data.Email = to
+	data.Footer = template.HTML(footer)
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return errors.Wrap(err, "execute subject template error")
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return errors.Wrap(err, "execute body template error")
+	}
+
+	msg := Message{
+		From:    from,
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+	}
+
+	if err := sender.Send(msg); err != nil {
+		return errors.Wrap(err, "send email error")
+	}
+
+	return nil
+}
+
+func link(path, token string) string {
+	return baseURL + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,164 @@
+package email
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+type testSender struct {
+	messages []Message
+}
+
+func (s *testSender) Send(msg Message) error {
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name    string
+		sender  string
+		from    string
+		path    string
+		enabled bool
+		err     bool
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:    "smtp",
+			sender:  "smtp",
+			from:    "noreply@example.com",
+			enabled: true,
+		},
+		{
+			name:    "log",
+			sender:  "log",
+			from:    "noreply@example.com",
+			enabled: true,
+		},
+		{
+			name:   "file without path",
+			sender: "file",
+			from:   "noreply@example.com",
+			err:    true,
+		},
+		{
+			name:   "missing from",
+			sender: "log",
+			err:    true,
+		},
+		{
+			name:   "unknown sender",
+			sender: "foo",
+			from:   "noreply@example.com",
+			err:    true,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			var conf config.Config
+			conf.ApplicationServer.Email.Sender = tst.sender
+			conf.ApplicationServer.Email.From = tst.from
+			conf.ApplicationServer.Email.File.Path = tst.path
+
+			err := Setup(conf)
+			if tst.err {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(tst.enabled, Enabled())
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	assert := require.New(t)
+
+	var conf config.Config
+	conf.ApplicationServer.Email.Sender = "log"
+	conf.ApplicationServer.Email.From = "ChirpStack <noreply@example.com>"
+	conf.ApplicationServer.Email.BaseURL = "https://lora.example.com/"
+	conf.ApplicationServer.Branding.Footer = "<a href=\"https://example.com\">Example</a>"
+	assert.NoError(Setup(conf))
+
+	sender := testSender{}
+	SetSender(&sender)
+
+	t.Run("Password reset", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(SendPasswordReset("user@example.com", "abc+def", time.Hour))
+		assert.Len(sender.messages, 1)
+
+		msg := sender.messages[0]
+		assert.Equal("ChirpStack <noreply@example.com>", msg.From)
+		assert.Equal("user@example.com", msg.To)
+		assert.Equal("Password reset", msg.Subject)
+		assert.Contains(msg.Body, `href="https://lora.example.com/#/password-reset?token=abc%2Bdef"`)
+		assert.Contains(msg.Body, "expires in 1 hour")
+		assert.Contains(msg.Body, conf.ApplicationServer.Branding.Footer)
+	})
+
+	t.Run("E-mail verification", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(SendEmailVerification("<script>@example.com", "abc", 48*time.Hour))
+		assert.Len(sender.messages, 2)
+
+		msg := sender.messages[1]
+		assert.Equal("Verify your e-mail address", msg.Subject)
+		assert.Contains(msg.Body, `href="https://lora.example.com/#/verify-email?token=abc"`)
+		assert.Contains(msg.Body, "expires in 48 hours")
+		assert.Contains(msg.Body, "&lt;script&gt;@example.com")
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+
+		SetSender(nil)
+		assert.Equal(ErrDisabled, SendPasswordReset("user@example.com", "abc", time.Hour))
+	})
+}
+
+func TestFileSender(t *testing.T) {
+	assert := require.New(t)
+
+	dir, err := ioutil.TempDir("", "email")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	sender := FileSender{Path: filepath.Join(dir, "email.txt")}
+
+	for _, subject := range []string{"first", "second\r\nBcc: attacker@example.com"} {
+		assert.NoError(sender.Send(Message{
+			From:    "noreply@example.com",
+			To:      "user@example.com",
+			Subject: subject,
+			Body:    "<p>Hello</p>",
+		}))
+	}
+
+	b, err := ioutil.ReadFile(sender.Path)
+	assert.NoError(err)
+
+	s := string(b)
+	assert.Equal(2, strings.Count(s, "To: user@example.com\r\n"))
+	assert.Contains(s, "Subject: first\r\n")
+	assert.Contains(s, "Subject: secondBcc: attacker@example.com\r\n")
+	assert.NotContains(s, "\r\nBcc:")
+	assert.Contains(s, "Content-Type: text/html; charset=\"utf-8\"\r\n\r\n<p>Hello</p>\r\n")
+}
@@ -0,0 +1,129 @@
+package email
+
+import (
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// SMTPSender sends the e-mail messages using SMTP.
+type SMTPSender struct {
+	// Server contains the hostname:port of the SMTP server.
+	Server   string
+	Username string
+	Password string
+
+	// TLS enables (implicit) TLS. When false, STARTTLS is used when
+	// supported by the server.
+	TLS bool
+}
+
+// Send sends the given message.
+func (s *SMTPSender) Send(msg Message) error {
+	fromAddr, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return errors.Wrap(err, "parse from address error")
+	}
+	toAddr, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return errors.Wrap(err, "parse to address error")
+	}
+
+	host, _, err := net.SplitHostPort(s.Server)
+	if err != nil {
+		return errors.Wrap(err, "split host-port error")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	if !s.TLS {
+		return smtp.SendMail(s.Server, auth, fromAddr.Address, []string{toAddr.Address}, msg.Bytes())
+	}
+
+	conn, err := tls.Dial("tcp", s.Server, &tls.Config{ServerName: host})
+	if err != nil {
+		return errors.Wrap(err, "dial error")
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "new smtp client error")
+	}
+	defer c.Close()
+
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return errors.Wrap(err, "smtp auth error")
+		}
+	}
+
+	if err := c.Mail(fromAddr.Address); err != nil {
+		return errors.Wrap(err, "smtp mail error")
+	}
+	if err := c.Rcpt(toAddr.Address); err != nil {
+		return errors.Wrap(err, "smtp rcpt error")
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "smtp data error")
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return errors.Wrap(err, "write message error")
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "smtp data error")
+	}
+
+	return c.Quit()
+}
+
+// FileSender appends the e-mail messages to a file. This is intended for
+// testing.
+type FileSender struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Send appends the given message to the file.
+func (s *FileSender) Send(msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open file error")
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(msg.Bytes(), '\r', '\n')); err != nil {
+		return errors.Wrap(err, "write file error")
+	}
+
+	return nil
+}
+
+// LogSender logs the e-mail messages. This is intended for testing.
+type LogSender struct{}
+
+// Send logs the given message.
+func (s *LogSender) Send(msg Message) error {
+	log.WithFields(log.Fields{
+		"to":      msg.To,
+		"subject": msg.Subject,
+		"body":    msg.Body,
+	}).Info("email: message sent")
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package email
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// templateData defines the data available to the templates.
+type templateData struct {
+	// Email contains the e-mail address of the recipient.
+	Email string
+
+	// Link contains the link containing the token.
+	Link string
+
+	// TTL contains the validity of the token.
+	TTL time.Duration
+
+	// Footer contains the (HTML) footer of the branding configuration.
+	Footer template.HTML
+}
+
+var templateFuncs = template.FuncMap{
+	"duration": formatDuration,
+}
+
+// formatDuration formats the given duration in whole hours or minutes when
+// possible, e.g. "1 hour" or "30 minutes".
+func formatDuration(d time.Duration) string {
+	var n int
+	var unit string
+
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		n, unit = int(d/time.Hour), "hour"
+	case d >= time.Minute && d%time.Minute == 0:
+		n, unit = int(d/time.Minute), "minute"
+	default:
+		return d.String()
+	}
+
+	if n != 1 {
+		unit += "s"
+	}
+
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+const layoutTemplate = `
+{{ define "footer" }}{{ if .Footer }}
+<hr>
+<p>{{ .Footer }}</p>{{ end }}{{ end }}`
+
+var passwordResetTemplate = template.Must(template.New("password_reset").Funcs(templateFuncs).Parse(layoutTemplate + `
+{{ define "subject" }}Password reset{{ end }}
+{{ define "body" }}<p>A password reset was requested for the account {{ .Email }}.</p>
+<p><a href="{{ .Link }}">Click here to set a new password</a>.</p>
+<p>This link can be used once and expires in {{ duration .TTL }}. If you did not request a password reset, you can ignore this e-mail.</p>
+{{ template "footer" . }}{{ end }}`))
+
+var emailVerificationTemplate = template.Must(template.New("email_verification").Funcs(templateFuncs).Parse(layoutTemplate + `
+{{ define "subject" }}Verify your e-mail address{{ end }}
+{{ define "body" }}<p>Please verify the e-mail address {{ .Email }}.</p>
+<p><a href="{{ .Link }}">Click here to verify your e-mail address</a>.</p>
+<p>This link can be used once and expires in {{ duration .TTL }}.</p>
+{{ template "footer" . }}{{ end }}`))
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/golang/protobuf/proto"
@@ -12,11 +13,13 @@ import (
 
 	"github.com/brocaar/lorawan"
 	"github.com/gyh1621/chirpstack-application-server/internal/integration/marshaler"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
 const (
 	deviceEventUplinkPubSubKeyTempl = "lora:as:device:%s:pubsub:event"
+	deviceEventRateLimitKeyTempl    = "lora:as:device:%s:eventlog:%s:rate"
 )
 
 // Event types.
@@ -29,24 +32,64 @@ const (
 	Location    = "location"
 	TxAck       = "txack"
 	Integration = "integration"
+	Log         = "log"
 )
 
+// MetadataKey holds the context key of the event metadata
+// (map[string]string), e.g. the codec revision which decoded the uplink.
+// The metadata is added to the event-log next to the event payload.
+const MetadataKey logging.ContextKey = "eventlog_metadata"
+
 // EventLog contains an event log.
 type EventLog struct {
 	Type    string
 	Payload json.RawMessage
+
+	// Metadata contains information about the event which is not part of
+	// the event payload.
+	Metadata map[string]string `json:",omitempty"`
+}
+
+// PayloadJSON returns the payload JSON, including the metadata (if any)
+// under the metadata key.
+func (el EventLog) PayloadJSON() ([]byte, error) {
+	if len(el.Metadata) == 0 {
+		return json.Marshal(el.Payload)
+	}
+
+	var pl map[string]interface{}
+	if err := json.Unmarshal(el.Payload, &pl); err != nil {
+		return nil, errors.Wrap(err, "unmarshal payload error")
+	}
+	pl["metadata"] = el.Metadata
+
+	return json.Marshal(pl)
+}
+
+// MetadataFromContext returns the event metadata stored in the given
+// context, or nil when not set.
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(MetadataKey).(map[string]string)
+	return md
 }
 
 // LogEventForDevice logs an event for the given device.
 func LogEventForDevice(devEUI lorawan.EUI64, t string, msg proto.Message) error {
+	return LogEventForDeviceWithMetadata(devEUI, t, nil, msg)
+}
+
+// LogEventForDeviceWithMetadata logs an event with the given metadata for
+// the given device.
+func LogEventForDeviceWithMetadata(devEUI lorawan.EUI64, t string, metadata map[string]string, msg proto.Message) error {
 	b, err := marshaler.Marshal(marshaler.ProtobufJSON, msg)
 	if err != nil {
 		return errors.Wrap(err, "marshal protobuf json error")
 	}
 
 	el := EventLog{
-		Type:    t,
-		Payload: json.RawMessage(b),
+		Type:     t,
+		Payload:  json.RawMessage(b),
+		Metadata: metadata,
 	}
 
 	key := fmt.Sprintf(deviceEventUplinkPubSubKeyTempl, devEUI)
@@ -63,6 +106,37 @@ func LogEventForDevice(devEUI lorawan.EUI64, t string, msg proto.Message) error
 	return nil
 }
 
+// RateLimitForDevice returns how many of the given n events of type t can be
+// logged for the given device, given the max. number of events per window.
+func RateLimitForDevice(devEUI lorawan.EUI64, t string, n, limit int, window time.Duration) (int, error) {
+	if n == 0 || limit == 0 {
+		return 0, nil
+	}
+
+	key := fmt.Sprintf(deviceEventRateLimitKeyTempl, devEUI, t)
+	count, err := storage.RedisClient().IncrBy(key, int64(n)).Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "increment rate-limit counter error")
+	}
+
+	// the key was created by this increment, start the window
+	if int(count) == n {
+		if err := storage.RedisClient().PExpire(key, window).Err(); err != nil {
+			return 0, errors.Wrap(err, "set rate-limit expire error")
+		}
+	}
+
+	allowed := limit - (int(count) - n)
+	if allowed < 0 {
+		allowed = 0
+	}
+	if allowed > n {
+		allowed = n
+	}
+
+	return allowed, nil
+}
+
 // GetEventLogForDevice subscribes to the device events for the given DevEUI
 // and sends this to the given channel.
 func GetEventLogForDevice(ctx context.Context, devEUI lorawan.EUI64, eventsChan chan EventLog) error {
@@ -62,3 +62,41 @@ func TestEventLog(t *testing.T) {
 		})
 	})
 }
+
+func TestEventLogPayloadJSON(t *testing.T) {
+	tests := []struct {
+		Name     string
+		EventLog EventLog
+		Expected string
+	}{
+		{
+			Name:     "without metadata",
+			EventLog: EventLog{Type: Uplink, Payload: []byte(`{"fPort":1}`)},
+			Expected: `{"fPort":1}`,
+		},
+		{
+			Name:     "with metadata",
+			EventLog: EventLog{Type: Uplink, Payload: []byte(`{"fPort":1,"tags":{"codec_revision":"foo"}}`), Metadata: map[string]string{"codec_revision": "3"}},
+			Expected: `{"fPort":1,"metadata":{"codec_revision":"3"},"tags":{"codec_revision":"foo"}}`,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := tst.EventLog.PayloadJSON()
+			assert.NoError(err)
+			assert.JSONEq(tst.Expected, string(b))
+		})
+	}
+}
+
+func TestMetadataFromContext(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Nil(MetadataFromContext(context.Background()))
+
+	ctx := context.WithValue(context.Background(), MetadataKey, map[string]string{"codec_revision": "3"})
+	assert.Equal(map[string]string{"codec_revision": "3"}, MetadataFromContext(ctx))
+}
@@ -5,10 +5,13 @@ import (
 	"crypto/aes"
 	"encoding/hex"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	keywrap "github.com/NickBall/go-aes-key-wrap"
 	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -18,16 +21,23 @@ import (
 	"github.com/gyh1621/chirpstack-api/go/v3/as"
 	pb "github.com/gyh1621/chirpstack-api/go/v3/as/integration"
 	"github.com/gyh1621/chirpstack-api/go/v3/common"
+	"github.com/gyh1621/chirpstack-api/go/v3/gw"
 	"github.com/gyh1621/chirpstack-application-server/internal/applayer/clocksync"
 	"github.com/gyh1621/chirpstack-application-server/internal/applayer/fragmentation"
 	"github.com/gyh1621/chirpstack-application-server/internal/applayer/multicastsetup"
 	"github.com/gyh1621/chirpstack-application-server/internal/codec"
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/eventlog"
+	"github.com/gyh1621/chirpstack-application-server/internal/fuota"
 	"github.com/gyh1621/chirpstack-application-server/internal/integration"
 	"github.com/gyh1621/chirpstack-application-server/internal/logging"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 )
 
+// codecRevisionMetadata defines the event-log metadata key containing the
+// codec revision that produced the decoded object.
+const codecRevisionMetadata = "codec_revision"
+
 type uplinkContext struct {
 	uplinkDataReq as.HandleUplinkDataRequest
 
@@ -36,8 +46,9 @@ type uplinkContext struct {
 	application   storage.Application
 	deviceProfile storage.DeviceProfile
 
-	data       []byte
-	objectJSON string
+	data          []byte
+	objectJSON    string
+	codecRevision int
 }
 
 var tasks = []func(*uplinkContext) error{
@@ -48,6 +59,7 @@ var tasks = []func(*uplinkContext) error{
 	updateDeviceActivation,
 	decryptPayload,
 	handleApplicationLayers,
+	handleFUOTA,
 	handleCodec,
 	handleIntegrations,
 }
@@ -186,25 +198,31 @@ func decryptPayload(ctx *uplinkContext) error {
 	return nil
 }
 
+// handleApplicationLayers handles the uplinks of the application-layer
+// packages enabled by the device-profile, using the configured fPorts.
 func handleApplicationLayers(ctx *uplinkContext) error {
-	// TODO: make application layer configurable
-	// * make ports configurable
-	// * disable application-layer features
-	if ctx.uplinkDataReq.FPort != 200 && ctx.uplinkDataReq.FPort != 201 && ctx.uplinkDataReq.FPort != 202 {
+	params := ctx.deviceProfile.ApplicationLayerParams
+	fPort := uint8(ctx.uplinkDataReq.FPort)
+
+	if ctx.uplinkDataReq.FPort > 223 || !params.IsApplicationLayerFPort(fPort) {
 		return nil
 	}
 
-	return storage.Transaction(func(db sqlx.Ext) error {
-		switch ctx.uplinkDataReq.FPort {
-		case 200:
+	// the fuota events are published once the transaction has been
+	// committed
+	var fuotaEvents fuota.Events
+
+	err := storage.Transaction(func(db sqlx.Ext) error {
+		switch {
+		case params.MulticastSetupEnabled && fPort == params.MulticastSetupFPort:
 			if err := multicastsetup.HandleRemoteMulticastSetupCommand(ctx.ctx, db, ctx.device.DevEUI, ctx.data); err != nil {
 				return errors.Wrap(err, "handle remote multicast setup command error")
 			}
-		case 201:
-			if err := fragmentation.HandleRemoteFragmentationSessionCommand(ctx.ctx, db, ctx.device.DevEUI, ctx.data); err != nil {
+		case params.FragmentationEnabled && fPort == params.FragmentationFPort:
+			if err := fragmentation.HandleRemoteFragmentationSessionCommand(ctx.ctx, db, ctx.device.DevEUI, ctx.data, &fuotaEvents); err != nil {
 				return errors.Wrap(err, "handle remote fragmentation session command error")
 			}
-		case 202:
+		case params.ClockSyncEnabled && fPort == params.ClockSyncFPort:
 			var timeSinceGPSEpoch time.Duration
 			var timeField time.Time
 			var err error
@@ -240,23 +258,76 @@ func handleApplicationLayers(ctx *uplinkContext) error {
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	fuotaEvents.Send(ctx.ctx, storage.DB())
+
+	return nil
+}
+
+// handleFUOTA enqueues the next fragment of a unicast FUOTA deployment.
+// Errors are logged so that they do not prevent the uplink from being
+// forwarded to the integrations.
+func handleFUOTA(ctx *uplinkContext) error {
+	err := storage.Transaction(func(db sqlx.Ext) error {
+		return fuota.HandleUplink(ctx.ctx, db, ctx.device.DevEUI)
+	})
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"ctx_id":  ctx.ctx.Value(logging.ContextIDKey),
+		}).Error("handle fuota uplink error")
+	}
+
+	return nil
 }
 
 func handleCodec(ctx *uplinkContext) error {
 	codecType := ctx.application.PayloadCodec
 	decoderScript := ctx.application.PayloadDecoderScript
+	codecRevision := ctx.application.PayloadCodecRevision
+	var protoSchema codec.ProtobufSchema
 
 	if ctx.deviceProfile.PayloadCodec != "" {
 		codecType = ctx.deviceProfile.PayloadCodec
 		decoderScript = ctx.deviceProfile.PayloadDecoderScript
+		codecRevision = ctx.deviceProfile.PayloadCodecRevision
+		protoSchema = ctx.deviceProfile.PayloadProtobufSchema()
 	}
 
 	if codecType == codec.None {
 		return nil
 	}
 
+	devCtx := codec.DeviceContext{
+		FCnt:     ctx.uplinkDataReq.FCnt,
+		RecvTime: getRecvTime(ctx.uplinkDataReq.RxInfo),
+	}
+
+	// the device state is only used by the JS codec, in case it can't be
+	// loaded, it will not be saved to avoid overwriting the stored state
+	var stateLoaded bool
+	if codecType == codec.CustomJSType {
+		state, err := storage.GetDeviceCodecState(ctx.ctx, ctx.device.DevEUI)
+		if err != nil {
+			log.WithError(err).WithField("dev_eui", ctx.device.DevEUI).Error("get device codec state error")
+		} else {
+			devCtx.State = state
+			stateLoaded = true
+		}
+	}
+	prevState := devCtx.State
+
 	start := time.Now()
-	b, err := codec.BinaryToJSON(codecType, uint8(ctx.uplinkDataReq.FPort), ctx.device.Variables, decoderScript, ctx.data)
+	b, logEntries, err := codec.BinaryToJSON(codecType, uint8(ctx.uplinkDataReq.FPort), ctx.device.Variables, decoderScript, protoSchema, &devCtx, ctx.data)
+	logEntries = logCodecEntries(ctx, logEntries)
+	if err == nil && stateLoaded && (prevState != nil || devCtx.State != nil) {
+		if err := storage.SaveDeviceCodecState(ctx.ctx, ctx.device.DevEUI, devCtx.State, config.C.ApplicationServer.Codec.JS.DeviceStateTTL); err != nil {
+			log.WithError(err).WithField("dev_eui", ctx.device.DevEUI).Error("save device codec state error")
+		}
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"codec":          codecType,
@@ -272,7 +343,7 @@ func handleCodec(ctx *uplinkContext) error {
 			DeviceName:      ctx.device.Name,
 			DevEui:          ctx.device.DevEUI[:],
 			Type:            pb.ErrorType_UPLINK_CODEC,
-			Error:           err.Error(),
+			Error:           codecErrorWithLog(err, logEntries),
 			FCnt:            ctx.uplinkDataReq.FCnt,
 			Tags:            make(map[string]string),
 		}
@@ -298,10 +369,12 @@ func handleCodec(ctx *uplinkContext) error {
 	log.WithFields(log.Fields{
 		"application_id": ctx.application.ID,
 		"codec":          codecType,
+		"codec_revision": codecRevision,
 		"duration":       time.Since(start),
 	}).Debug("payload codec completed Decode execution")
 
 	ctx.objectJSON = string(b)
+	ctx.codecRevision = codecRevision
 
 	return nil
 }
@@ -331,6 +404,7 @@ func handleIntegrations(ctx *uplinkContext) error {
 			pl.Tags[k] = v.String
 		}
 	}
+
 	vars := make(map[string]string)
 	for k, v := range ctx.device.Variables.Map {
 		if v.Valid {
@@ -341,6 +415,13 @@ func handleIntegrations(ctx *uplinkContext) error {
 	bgCtx := context.Background()
 	bgCtx = context.WithValue(bgCtx, logging.ContextIDKey, ctx.ctx.Value(logging.ContextIDKey))
 
+	// add the codec revision which produced the object to the event-log
+	if ctx.objectJSON != "" && ctx.codecRevision != 0 {
+		bgCtx = context.WithValue(bgCtx, eventlog.MetadataKey, map[string]string{
+			codecRevisionMetadata: strconv.Itoa(ctx.codecRevision),
+		})
+	}
+
 	// Handle the actual integration handling in a Go-routine so that the
 	// as.HandleUplinkData api can return.
 	go func() {
@@ -353,6 +434,83 @@ func handleIntegrations(ctx *uplinkContext) error {
 	return nil
 }
 
+// logCodecEntries writes the codec console log entries to the device
+// event-log. It returns the entries that were logged, as the number of
+// entries is limited by the configured rate limit.
+func logCodecEntries(ctx *uplinkContext, entries []codec.LogEntry) []codec.LogEntry {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	n, err := eventlog.RateLimitForDevice(ctx.device.DevEUI, eventlog.Log, len(entries), config.C.ApplicationServer.Codec.JS.LogRateLimit, time.Minute)
+	if err != nil {
+		log.WithError(err).WithField("dev_eui", ctx.device.DevEUI).Error("get event-log rate limit error")
+		return nil
+	}
+
+	if n < len(entries) {
+		log.WithFields(log.Fields{
+			"dev_eui": ctx.device.DevEUI,
+			"dropped": len(entries) - n,
+		}).Warning("codec log rate limit reached, dropping log entries")
+	}
+
+	for _, entry := range entries[:n] {
+		pl := structpb.Struct{
+			Fields: map[string]*structpb.Value{
+				"applicationID":   {Kind: &structpb.Value_StringValue{StringValue: strconv.FormatInt(ctx.device.ApplicationID, 10)}},
+				"applicationName": {Kind: &structpb.Value_StringValue{StringValue: ctx.application.Name}},
+				"deviceName":      {Kind: &structpb.Value_StringValue{StringValue: ctx.device.Name}},
+				"devEUI":          {Kind: &structpb.Value_StringValue{StringValue: ctx.device.DevEUI.String()}},
+				"fCnt":            {Kind: &structpb.Value_NumberValue{NumberValue: float64(ctx.uplinkDataReq.FCnt)}},
+				"level":           {Kind: &structpb.Value_StringValue{StringValue: entry.Level}},
+				"message":         {Kind: &structpb.Value_StringValue{StringValue: entry.Message}},
+			},
+		}
+
+		if err := eventlog.LogEventForDevice(ctx.device.DevEUI, eventlog.Log, &pl); err != nil {
+			log.WithError(err).WithField("dev_eui", ctx.device.DevEUI).Error("log codec event error")
+		}
+	}
+
+	return entries[:n]
+}
+
+// codecErrorWithLog returns the codec error message, followed by the console
+// log entries written before the failure.
+func codecErrorWithLog(err error, entries []codec.LogEntry) string {
+	if len(entries) == 0 {
+		return err.Error()
+	}
+
+	lines := []string{err.Error(), "", "console:"}
+	for _, entry := range entries {
+		lines = append(lines, fmt.Sprintf("[%s] %s", entry.Level, entry.Message))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// getRecvTime returns the receive time of the uplink. It falls back on the
+// current server time when none of the gateways provided a time.
+func getRecvTime(rxInfo []*gw.UplinkRXInfo) time.Time {
+	for _, rx := range rxInfo {
+		if rx.Time == nil {
+			continue
+		}
+
+		ts, err := ptypes.Timestamp(rx.Time)
+		if err != nil {
+			log.WithError(err).Error("time to timestamp error")
+			continue
+		}
+
+		return ts
+	}
+
+	return time.Now()
+}
+
 func unwrapASKey(ke *common.KeyEnvelope) (lorawan.AES128Key, error) {
 	var key lorawan.AES128Key
 
@@ -0,0 +1,146 @@
+// Package firmware implements the verification of signed firmware images.
+package firmware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+// Errors
+var (
+	ErrSignatureRequired = errors.New("firmware image signature is required")
+	ErrInvalidSignature  = errors.New("invalid firmware image signature")
+)
+
+var (
+	publicKey        crypto.PublicKey
+	requireSignature bool
+)
+
+// Setup configures the package.
+func Setup(conf config.Config) error {
+	requireSignature = conf.ApplicationServer.FirmwareImage.RequireSignature
+	publicKey = nil
+
+	if conf.ApplicationServer.FirmwareImage.SigningPublicKey == "" {
+		if requireSignature {
+			return errors.New("require_signature is set but signing_public_key is not configured")
+		}
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(conf.ApplicationServer.FirmwareImage.SigningPublicKey)
+	if err != nil {
+		return errors.Wrap(err, "read signing public-key error")
+	}
+
+	publicKey, err = ParsePublicKey(b)
+	if err != nil {
+		return errors.Wrap(err, "parse signing public-key error")
+	}
+
+	return nil
+}
+
+// ParsePublicKey parses the given PEM encoded (PKIX) public-key. RSA, ECDSA
+// and Ed25519 keys are supported.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse pkix public-key error")
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return pub, nil
+	default:
+		return nil, errors.Errorf("unsupported public-key type: %T", pub)
+	}
+}
+
+// Verify verifies the signature of the given firmware image payload using
+// the configured public-key. It returns true when the signature has been
+// verified. When no public-key is configured, the signature is not verified
+// and false is returned, unless a signature is required.
+func Verify(payload, signature []byte) (bool, error) {
+	if len(signature) == 0 {
+		if requireSignature {
+			return false, ErrSignatureRequired
+		}
+		return false, nil
+	}
+
+	if publicKey == nil {
+		return false, nil
+	}
+
+	if err := VerifySignature(publicKey, payload, signature); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// VerifySignature verifies the signature of the given payload. For RSA
+// (PKCS #1 v1.5) and ECDSA keys, the signature must be over the SHA-256 of
+// the payload. ECDSA signatures can be either ASN.1 (DER) or r || s encoded.
+func VerifySignature(pub crypto.PublicKey, payload, signature []byte) error {
+	digest := sha256.Sum256(payload)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		r, s, err := ecdsaSignature(pub, signature)
+		if err != nil || !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, payload, signature) {
+			return ErrInvalidSignature
+		}
+	default:
+		return errors.Errorf("unsupported public-key type: %T", pub)
+	}
+
+	return nil
+}
+
+func ecdsaSignature(pub *ecdsa.PublicKey, signature []byte) (*big.Int, *big.Int, error) {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(signature) == 2*size {
+		return new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:]), nil
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "asn.1 unmarshal error")
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("trailing data after signature")
+	}
+
+	return sig.R, sig.S, nil
+}
@@ -0,0 +1,116 @@
+package firmware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignature(t *testing.T) {
+	assert := require.New(t)
+	payload := []byte("firmware image")
+	digest := sha256.Sum256(payload)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	assert.NoError(err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	ecSig, err := ecKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.NoError(err)
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	assert.NoError(err)
+	ecRawSig := make([]byte, 64)
+	copy(ecRawSig[32-len(r.Bytes()):32], r.Bytes())
+	copy(ecRawSig[64-len(s.Bytes()):], s.Bytes())
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	edSig := ed25519.Sign(edKey, payload)
+
+	tests := []struct {
+		Name          string
+		PublicKey     crypto.PublicKey
+		Signature     []byte
+		ExpectedError error
+	}{
+		{"rsa", rsaKey.Public(), rsaSig, nil},
+		{"rsa invalid", rsaKey.Public(), edSig, ErrInvalidSignature},
+		{"ecdsa asn.1", ecKey.Public(), ecSig, nil},
+		{"ecdsa r || s", ecKey.Public(), ecRawSig, nil},
+		{"ecdsa invalid", ecKey.Public(), rsaSig, ErrInvalidSignature},
+		{"ed25519", edPub, edSig, nil},
+		{"ed25519 invalid", edPub, ecRawSig, ErrInvalidSignature},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			b, err := x509.MarshalPKIXPublicKey(tst.PublicKey)
+			assert.NoError(err)
+			pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+			assert.NoError(err)
+
+			assert.Equal(tst.ExpectedError, VerifySignature(pub, payload, tst.Signature))
+			assert.Equal(ErrInvalidSignature, VerifySignature(pub, []byte("other image"), tst.Signature))
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	assert := require.New(t)
+
+	defer func() {
+		publicKey = nil
+		requireSignature = false
+	}()
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	payload := []byte("firmware image")
+	sig := ed25519.Sign(edKey, payload)
+
+	t.Run("No public-key", func(t *testing.T) {
+		assert := require.New(t)
+
+		ok, err := Verify(payload, sig)
+		assert.NoError(err)
+		assert.False(ok)
+	})
+
+	t.Run("Public-key", func(t *testing.T) {
+		assert := require.New(t)
+		publicKey = edPub
+
+		ok, err := Verify(payload, sig)
+		assert.NoError(err)
+		assert.True(ok)
+
+		ok, err = Verify(payload, nil)
+		assert.NoError(err)
+		assert.False(ok)
+
+		_, err = Verify([]byte("other image"), sig)
+		assert.Equal(ErrInvalidSignature, err)
+	})
+
+	t.Run("Signature required", func(t *testing.T) {
+		assert := require.New(t)
+		requireSignature = true
+
+		_, err := Verify(payload, nil)
+		assert.Equal(ErrSignatureRequired, err)
+	})
+}
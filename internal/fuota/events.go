@@ -0,0 +1,241 @@
+package fuota
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+	pb "github.com/gyh1621/chirpstack-api/go/v3/as/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// Integration event types.
+const (
+	integrationName           = "fuota"
+	deploymentStateEventType  = "fuota_deployment_state"
+	deploymentDeviceEventType = "fuota_deployment_device_state"
+)
+
+// DeploymentStateEvent is published when a FUOTA deployment changes state.
+type DeploymentStateEvent struct {
+	FUOTADeploymentID uuid.UUID                    `json:"fuotaDeploymentID"`
+	Name              string                       `json:"name"`
+	State             storage.FUOTADeploymentState `json:"state"`
+	PreviousState     storage.FUOTADeploymentState `json:"previousState"`
+	CurrentWave       int                          `json:"currentWave"`
+}
+
+// DeviceStateEvent is published when the state of a device within a FUOTA
+// deployment changes.
+type DeviceStateEvent struct {
+	FUOTADeploymentID uuid.UUID                          `json:"fuotaDeploymentID"`
+	Name              string                             `json:"name"`
+	DevEUI            lorawan.EUI64                      `json:"devEUI"`
+	State             storage.FUOTADeploymentDeviceState `json:"state"`
+	ErrorMessage      string                             `json:"errorMessage"`
+	NbFragReceived    int                                `json:"nbFragReceived"`
+	MissingFrag       int                                `json:"missingFrag"`
+}
+
+// SendDeploymentStateEvent publishes the state of the given deployment to
+// the integrations of the applications of the deployment devices. As the
+// event is not device specific, the device fields are not set. Errors are
+// logged.
+func SendDeploymentStateEvent(ctx context.Context, db sqlx.Queryer, fd storage.FUOTADeployment, previousState storage.FUOTADeploymentState) {
+	if err := sendDeploymentStateEvent(ctx, db, fd, previousState); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"id":     fd.ID,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Error("fuota: send deployment state event error")
+	}
+}
+
+// SendDeviceStateEvent publishes the state of the given device within the
+// given deployment to the integrations of the application of the device.
+// Errors are logged.
+func SendDeviceStateEvent(ctx context.Context, db sqlx.Queryer, fuotaDeploymentID uuid.UUID, devEUI lorawan.EUI64) {
+	if err := sendDeviceStateEvent(ctx, db, fuotaDeploymentID, devEUI); err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"id":      fuotaDeploymentID,
+			"dev_eui": devEUI,
+			"ctx_id":  ctx.Value(logging.ContextIDKey),
+		}).Error("fuota: send device state event error")
+	}
+}
+
+// Events collects the deployment and device state events of a transaction,
+// so that these are only published to the integrations once the transaction
+// has been committed. Publishing within the transaction would hold the
+// (row) locks during the integration round-trips and would publish state
+// changes which might still be rolled back.
+type Events struct {
+	events []func(ctx context.Context, db sqlx.Queryer)
+}
+
+// AddDeploymentState adds a deployment state event for the given
+// deployment.
+func (e *Events) AddDeploymentState(fd storage.FUOTADeployment, previousState storage.FUOTADeploymentState) {
+	e.events = append(e.events, func(ctx context.Context, db sqlx.Queryer) {
+		SendDeploymentStateEvent(ctx, db, fd, previousState)
+	})
+}
+
+// AddDeviceState adds a device state event for the given device within the
+// given deployment. The state is read when the event is published.
+func (e *Events) AddDeviceState(fuotaDeploymentID uuid.UUID, devEUI lorawan.EUI64) {
+	e.events = append(e.events, func(ctx context.Context, db sqlx.Queryer) {
+		SendDeviceStateEvent(ctx, db, fuotaDeploymentID, devEUI)
+	})
+}
+
+func (e *Events) addDeviceStates(fuotaDeploymentID uuid.UUID, devEUIs []lorawan.EUI64) {
+	for _, devEUI := range devEUIs {
+		e.AddDeviceState(fuotaDeploymentID, devEUI)
+	}
+}
+
+// Send publishes the collected events in the order in which these were
+// added. It must be called after the transaction has been committed, using
+// storage.DB(). Errors are logged.
+func (e *Events) Send(ctx context.Context, db sqlx.Queryer) {
+	for _, f := range e.events {
+		f(ctx, db)
+	}
+	e.events = nil
+}
+
+func sendDeploymentStateEvent(ctx context.Context, db sqlx.Queryer, fd storage.FUOTADeployment, previousState storage.FUOTADeploymentState) error {
+	b, err := json.Marshal(DeploymentStateEvent{
+		FUOTADeploymentID: fd.ID,
+		Name:              fd.Name,
+		State:             fd.State,
+		PreviousState:     previousState,
+		CurrentWave:       fd.CurrentWave,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	var apps []storage.Application
+	err = sqlx.Select(db, &apps, `
+		select
+			a.*
+		from
+			application a
+		where
+			a.id in (
+				select
+					d.application_id
+				from
+					fuota_deployment_device fdd
+				inner join
+					device d
+				on
+					d.dev_eui = fdd.dev_eui
+				where
+					fdd.fuota_deployment_id = $1
+			)`,
+		fd.ID,
+	)
+	if err != nil {
+		return errors.Wrap(err, "get applications error")
+	}
+
+	for _, app := range apps {
+		pl := pb.IntegrationEvent{
+			ApplicationId:   uint64(app.ID),
+			ApplicationName: app.Name,
+			IntegrationName: integrationName,
+			EventType:       deploymentStateEventType,
+			ObjectJson:      string(b),
+		}
+
+		if err := integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, nil, pl); err != nil {
+			return errors.Wrap(err, "handle integration event error")
+		}
+	}
+
+	return nil
+}
+
+func sendDeviceStateEvent(ctx context.Context, db sqlx.Queryer, fuotaDeploymentID uuid.UUID, devEUI lorawan.EUI64) error {
+	var fdd struct {
+		storage.FUOTADeploymentDevice
+		Name string `db:"name"`
+	}
+	err := sqlx.Get(db, &fdd, `
+		select
+			fdd.*,
+			fd.name
+		from
+			fuota_deployment_device fdd
+		inner join
+			fuota_deployment fd
+		on
+			fd.id = fdd.fuota_deployment_id
+		where
+			fdd.fuota_deployment_id = $1
+			and fdd.dev_eui = $2`,
+		fuotaDeploymentID,
+		devEUI,
+	)
+	if err != nil {
+		return errors.Wrap(err, "get fuota deployment device error")
+	}
+
+	d, err := storage.GetDevice(ctx, db, devEUI, false, true)
+	if err != nil {
+		return errors.Wrap(err, "get device error")
+	}
+
+	app, err := storage.GetApplication(ctx, db, d.ApplicationID)
+	if err != nil {
+		return errors.Wrap(err, "get application error")
+	}
+
+	b, err := json.Marshal(DeviceStateEvent{
+		FUOTADeploymentID: fuotaDeploymentID,
+		Name:              fdd.Name,
+		DevEUI:            devEUI,
+		State:             fdd.State,
+		ErrorMessage:      fdd.ErrorMessage,
+		NbFragReceived:    fdd.NbFragReceived,
+		MissingFrag:       fdd.MissingFrag,
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	pl := pb.IntegrationEvent{
+		ApplicationId:   uint64(app.ID),
+		ApplicationName: app.Name,
+		DeviceName:      d.Name,
+		DevEui:          devEUI[:],
+		Tags:            make(map[string]string),
+		IntegrationName: integrationName,
+		EventType:       deploymentDeviceEventType,
+		ObjectJson:      string(b),
+	}
+
+	for k, v := range d.Tags.Map {
+		if v.Valid {
+			pl.Tags[k] = v.String
+		}
+	}
+
+	vars := make(map[string]string)
+	for k, v := range d.Variables.Map {
+		if v.Valid {
+			vars[k] = v.String
+		}
+	}
+
+	return integration.ForApplicationID(app.ID).HandleIntegrationEvent(ctx, vars, pl)
+}
@@ -20,6 +20,8 @@ import (
 	"github.com/brocaar/lorawan/applayer/fragmentation"
 	"github.com/brocaar/lorawan/applayer/multicastsetup"
 	"github.com/gyh1621/chirpstack-api/go/v3/ns"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/packageversion"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
 	"github.com/gyh1621/chirpstack-application-server/internal/logging"
 	"github.com/gyh1621/chirpstack-application-server/internal/multicast"
@@ -32,9 +34,14 @@ var (
 	fragIndex                         int
 	remoteMulticastSetupRetries       int
 	remoteFragmentationSessionRetries int
+	repairSessions                    int
 	routingProfileID                  uuid.UUID
 )
 
+// maxFragments defines the max. number of fragments of a fragmentation
+// session (the fragment index is 14 bits).
+const maxFragments = 1<<14 - 1
+
 // Setup configures the package.
 func Setup(conf config.Config) error {
 	var err error
@@ -49,6 +56,7 @@ func Setup(conf config.Config) error {
 	fragIndex = conf.ApplicationServer.FUOTADeployment.FragIndex
 	remoteMulticastSetupRetries = conf.ApplicationServer.RemoteMulticastSetup.SyncRetries
 	remoteFragmentationSessionRetries = conf.ApplicationServer.FragmentationSession.SyncRetries
+	repairSessions = conf.ApplicationServer.FUOTADeployment.RepairSessions
 
 	go fuotaDeploymentLoop()
 
@@ -65,32 +73,46 @@ func fuotaDeploymentLoop() {
 		ctx := context.Background()
 		ctx = context.WithValue(ctx, logging.ContextIDKey, ctxID)
 
+		var events Events
 		err = storage.Transaction(func(tx sqlx.Ext) error {
-			return fuotaDeployments(ctx, tx)
+			return fuotaDeployments(ctx, tx, &events)
 		})
 		if err != nil {
 			log.WithError(err).Error("fuota deployment error")
+		} else {
+			events.Send(ctx, storage.DB())
 		}
 		time.Sleep(interval)
 	}
 }
 
-func fuotaDeployments(ctx context.Context, db sqlx.Ext) error {
+// fuotaDeployments handles the pending deployments. The state events are
+// added to events, to be published after the transaction has been
+// committed.
+func fuotaDeployments(ctx context.Context, db sqlx.Ext, events *Events) error {
 	items, err := storage.GetPendingFUOTADeployments(ctx, db, batchSize)
 	if err != nil {
 		return err
 	}
 
 	for _, item := range items {
-		if err := fuotaDeployment(ctx, db, item); err != nil {
+		if err := fuotaDeployment(ctx, db, item, events); err != nil {
 			return errors.Wrap(err, "fuota deployment error")
 		}
+
+		fd, err := storage.GetFUOTADeployment(ctx, db, item.ID, false)
+		if err != nil {
+			return errors.Wrap(err, "get fuota deployment error")
+		}
+		if fd.State != item.State {
+			events.AddDeploymentState(fd, item.State)
+		}
 	}
 
 	return nil
 }
 
-func fuotaDeployment(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
+func fuotaDeployment(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment, events *Events) error {
 	switch item.State {
 	case storage.FUOTADeploymentMulticastCreate:
 		return stepMulticastCreate(ctx, db, item)
@@ -102,12 +124,20 @@ func fuotaDeployment(ctx context.Context, db sqlx.Ext, item storage.FUOTADeploym
 		return stepMulticastSessCSetup(ctx, db, item)
 	case storage.FUOTADeploymentEnqueue:
 		return stepEnqueue(ctx, db, item)
+	case storage.FUOTADeploymentUnicastDelivery:
+		return stepUnicastDelivery(ctx, db, item)
 	case storage.FUOTADeploymentStatusRequest:
 		return stepStatusRequest(ctx, db, item)
 	case storage.FUOTADeploymentSetDeviceStatus:
-		return stepSetDeviceStatus(ctx, db, item)
+		return stepSetDeviceStatus(ctx, db, item, events)
 	case storage.FUOTADeploymentCleanup:
-		return stepCleanup(ctx, db, item)
+		return stepCleanup(ctx, db, item, storage.FUOTADeploymentDone)
+	case storage.FUOTADeploymentNextWave:
+		return stepNextWave(ctx, db, item, events)
+	case storage.FUOTADeploymentCancel:
+		return stepCancel(ctx, db, item, events)
+	case storage.FUOTADeploymentCancelCleanup:
+		return stepCleanup(ctx, db, item, storage.FUOTADeploymentCancelled)
 	default:
 		return fmt.Errorf("unexpected state: %s", item.State)
 	}
@@ -199,7 +229,8 @@ func stepMulticastSetup(ctx context.Context, db sqlx.Ext, item storage.FUOTADepl
 		return errors.Wrap(err, "get multicast group error")
 	}
 
-	// query all device-keys that relate to this FUOTA deployment
+	// query all device-keys that relate to this FUOTA deployment, devices
+	// without remote multicast setup or fragmentation support are skipped
 	var deviceKeys []storage.DeviceKeys
 	err = sqlx.Select(db, &deviceKeys, `
 		select
@@ -209,8 +240,16 @@ func stepMulticastSetup(ctx context.Context, db sqlx.Ext, item storage.FUOTADepl
 		inner join
 			device_keys dk
 			on dd.dev_eui = dk.dev_eui
+		inner join
+			device d
+			on d.dev_eui = dd.dev_eui
+		inner join
+			device_profile dp
+			on dp.device_profile_id = d.device_profile_id
 		where
-			dd.fuota_deployment_id = $1`,
+			dd.fuota_deployment_id = $1
+			and dp.app_layer_multicast_setup_enabled = true
+			and dp.app_layer_fragmentation_enabled = true`,
 		item.ID,
 	)
 	if err != nil {
@@ -311,7 +350,7 @@ func stepMulticastSetup(ctx context.Context, db sqlx.Ext, item storage.FUOTADepl
 }
 
 func stepFragmentationSessSetup(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
-	if item.MulticastGroupID == nil {
+	if item.MulticastGroupID == nil && item.GroupType != storage.FUOTADeploymentGroupTypeA {
 		return errors.New("MulticastGroupID must not be nil")
 	}
 
@@ -322,21 +361,55 @@ func stepFragmentationSessSetup(ctx context.Context, db sqlx.Ext, item storage.F
 	// query all devices with complete multicast setup
 	var rmsItems []struct {
 		DevEUI    lorawan.EUI64 `db:"dev_eui"`
-		McGroupID int           `db:"mc_group_id"`
+		McGroupID *int          `db:"mc_group_id"`
+	}
+	var err error
+	if item.GroupType == storage.FUOTADeploymentGroupTypeA {
+		// the fragments are sent through the device-queue, there is no
+		// multicast setup
+		err = sqlx.Select(db, &rmsItems, `
+			select
+				fdd.dev_eui, null as mc_group_id
+			from
+				fuota_deployment_device fdd
+			inner join
+				device d
+			on
+				d.dev_eui = fdd.dev_eui
+			inner join
+				device_profile dp
+			on
+				dp.device_profile_id = d.device_profile_id
+			where
+				fdd.fuota_deployment_id = $1
+				and fdd.state = $2
+				and dp.app_layer_fragmentation_enabled = true`,
+			item.ID,
+			storage.FUOTADeploymentDevicePending,
+		)
+	} else {
+		err = sqlx.Select(db, &rmsItems, `
+			select
+				rms.dev_eui, rms.mc_group_id
+			from
+				remote_multicast_setup rms
+			inner join
+				fuota_deployment_device fdd
+			on
+				fdd.dev_eui = rms.dev_eui
+				and fdd.fuota_deployment_id = $4
+			where
+				rms.multicast_group_id = $1
+				and rms.state = $2
+				and rms.state_provisioned = $3
+				and fdd.state = $5`,
+			item.MulticastGroupID,
+			storage.RemoteMulticastSetupSetup,
+			true,
+			item.ID,
+			storage.FUOTADeploymentDevicePending,
+		)
 	}
-	err := sqlx.Select(db, &rmsItems, `
-		select
-			dev_eui, mc_group_id
-		from
-			remote_multicast_setup
-		where
-			multicast_group_id = $1
-			and state = $2
-			and state_provisioned = $3`,
-		item.MulticastGroupID,
-		storage.RemoteMulticastSetupSetup,
-		true,
-	)
 	if err != nil {
 		return errors.Wrap(err, "get devices with multicast setup error")
 	}
@@ -354,7 +427,6 @@ func stepFragmentationSessSetup(ctx context.Context, db sqlx.Ext, item storage.F
 		fs := storage.RemoteFragmentationSession{
 			DevEUI:              rmsItem.DevEUI,
 			FragIndex:           fragIndex,
-			MCGroupIDs:          []int{rmsItem.McGroupID},
 			NbFrag:              nbFrag,
 			FragSize:            item.FragSize,
 			FragmentationMatrix: item.FragmentationMatrix,
@@ -364,6 +436,20 @@ func stepFragmentationSessSetup(ctx context.Context, db sqlx.Ext, item storage.F
 			State:               storage.RemoteMulticastSetupSetup,
 			RetryInterval:       item.UnicastTimeout,
 		}
+		if rmsItem.McGroupID != nil {
+			fs.MCGroupIDs = []int{*rmsItem.McGroupID}
+		}
+
+		fs.PackageVersion, err = packageversion.Get(ctx, db, rmsItem.DevEUI, packageversion.Fragmentation)
+		if err != nil {
+			return errors.Wrap(err, "get fragmentation package version error")
+		}
+		if fs.PackageVersion >= packageversion.Version2 {
+			if err := setDataBlockIntegrity(ctx, db, &fs, item.Payload); err != nil {
+				return errors.Wrap(err, "set data block integrity error")
+			}
+		}
+
 		err = storage.CreateRemoteFragmentationSession(ctx, db, &fs)
 		if err != nil {
 			return errors.Wrap(err, "create remote fragmentation session error")
@@ -371,6 +457,9 @@ func stepFragmentationSessSetup(ctx context.Context, db sqlx.Ext, item storage.F
 	}
 
 	item.State = storage.FUOTADeploymentMulticastSessCSetup
+	if item.GroupType == storage.FUOTADeploymentGroupTypeA {
+		item.State = storage.FUOTADeploymentEnqueue
+	}
 	item.NextStepAfter = time.Now().Add(time.Duration(remoteFragmentationSessionRetries) * item.UnicastTimeout)
 
 	err = storage.UpdateFUOTADeployment(ctx, db, &item)
@@ -391,7 +480,7 @@ func stepMulticastSessCSetup(ctx context.Context, db sqlx.Ext, item storage.FUOT
 		return errors.Wrap(err, "get multicast group error")
 	}
 
-	// query all devices with complete fragmentation session setup
+	// query all pending devices with complete fragmentation session setup
 	var devEUIs []lorawan.EUI64
 	err = sqlx.Select(db, &devEUIs, `
 		select
@@ -403,16 +492,24 @@ func stepMulticastSessCSetup(ctx context.Context, db sqlx.Ext, item storage.FUOT
 		on
 			rfs.dev_eui = rms.dev_eui
 			and rfs.frag_index = $1
+		inner join
+			fuota_deployment_device fdd
+		on
+			fdd.dev_eui = rms.dev_eui
+			and fdd.fuota_deployment_id = $5
 		where
 			rms.multicast_group_id = $2
 			and rms.state = $3
 			and rms.state_provisioned = $4
 			and rfs.state = $3
-			and rms.state_provisioned = $4`,
+			and rms.state_provisioned = $4
+			and fdd.state = $6`,
 		fragIndex,
 		item.MulticastGroupID,
 		storage.RemoteMulticastSetupSetup,
 		true,
+		item.ID,
+		storage.FUOTADeploymentDevicePending,
 	)
 	if err != nil {
 		return errors.Wrap(err, "get devices with fragmentation session setup error")
@@ -424,6 +521,12 @@ func stepMulticastSessCSetup(ctx context.Context, db sqlx.Ext, item storage.FUOT
 		if err != nil {
 			return errors.Wrap(err, "get remote multicast setup error")
 		}
+
+		// delete the session of the previous (repair) round if it exists
+		err = storage.DeleteRemoteMulticastClassCSession(ctx, db, devEUI, *item.MulticastGroupID)
+		if err != nil && err != storage.ErrDoesNotExist {
+			return errors.Wrap(err, "delete remote multicast class-c session error")
+		}
 		rmccs := storage.RemoteMulticastClassCSession{
 			DevEUI:           devEUI,
 			MulticastGroupID: *item.MulticastGroupID,
@@ -452,34 +555,31 @@ func stepMulticastSessCSetup(ctx context.Context, db sqlx.Ext, item storage.FUOT
 }
 
 func stepEnqueue(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
+	if item.GroupType == storage.FUOTADeploymentGroupTypeA {
+		return startUnicastDelivery(ctx, db, item)
+	}
+
 	if item.MulticastGroupID == nil {
 		return errors.New("MulticastGroupID must not be nil")
 	}
 
-	// fragment the payload
-	padding := (item.FragSize - (len(item.Payload) % item.FragSize)) % item.FragSize
-	var fragments [][]byte
-	var err error
-
-	switch item.FragmentationMatrix {
-	case 0: // FEC encoding
-		fragments, err = fragmentation.Encode(append(item.Payload, make([]byte, padding)...), item.FragSize, item.Redundancy)
-	case 7: // disable encoding
-		// fragment the data into rows
-		data := append(item.Payload, make([]byte, padding)...)
-		for i := 0; i < len(data)/item.FragSize; i++ {
-			offset := i * item.FragSize
-			fragments = append(fragments, data[offset:offset+item.FragSize])
-		}
+	// in case of a repair session, only the extra coded fragments are sent
+	var offset int
+	redundancy := item.Redundancy
+	if item.RepairCount > 0 {
+		offset = item.FragmentsSent
+		redundancy = item.FragmentsSent - nbFragments(item) + item.RepairFragments
 	}
 
+	// fragment the payload
+	fragments, err := encodeFragments(item, redundancy)
 	if err != nil {
 		return errors.Wrap(err, "fragment payload error")
 	}
 
 	// wrap the payloads into data-fragment payloads
 	var payloads [][]byte
-	for i := range fragments {
+	for i := offset; i < len(fragments); i++ {
 		cmd := fragmentation.Command{
 			CID: fragmentation.DataFragment,
 			Payload: &fragmentation.DataFragmentPayload{
@@ -500,11 +600,17 @@ func stepEnqueue(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment)
 	}
 
 	// enqueue the payloads
-	_, err = multicast.EnqueueMultiple(ctx, db, *item.MulticastGroupID, fragmentation.DefaultFPort, payloads)
+	fPort, err := getMulticastFragmentationFPort(db, item)
+	if err != nil {
+		return errors.Wrap(err, "get fragmentation fPort error")
+	}
+
+	_, err = multicast.EnqueueMultiple(ctx, db, *item.MulticastGroupID, fPort, payloads)
 	if err != nil {
 		return errors.Wrap(err, "enqueue multiple error")
 	}
 
+	item.FragmentsSent = len(fragments)
 	item.State = storage.FUOTADeploymentStatusRequest
 
 	switch item.GroupType {
@@ -522,34 +628,275 @@ func stepEnqueue(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment)
 	return nil
 }
 
-func stepStatusRequest(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
-	if item.MulticastGroupID == nil {
-		return errors.New("MulticastGroupID must not be nil")
+// startUnicastDelivery starts the delivery of the fragments through the
+// device-queue. The fragments are not enqueued at once, HandleUplink
+// enqueues the next fragment on each uplink of the device.
+func startUnicastDelivery(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
+	// in case of a repair session, the extra coded fragments are sent
+	if item.RepairCount > 0 {
+		item.FragmentsSent += item.RepairFragments
+	} else {
+		item.FragmentsSent = nbFragments(item)
+		if item.FragmentationMatrix == 0 {
+			item.FragmentsSent += item.Redundancy
+		}
 	}
 
-	// query all devices with complete fragmentation session setup
-	var devEUIs []lorawan.EUI64
-	err := sqlx.Select(db, &devEUIs, `
+	// the multicast-timeout is used as the deadline for delivering the
+	// fragments to all devices
+	item.State = storage.FUOTADeploymentUnicastDelivery
+	item.NextStepAfter = time.Now().Add(time.Second * time.Duration(1<<uint(item.MulticastTimeout)))
+
+	err := storage.UpdateFUOTADeployment(ctx, db, &item)
+	if err != nil {
+		return errors.Wrap(err, "update fuota deployment error")
+	}
+
+	return nil
+}
+
+// stepUnicastDelivery is called when all devices received their fragments
+// or when the delivery deadline has expired.
+func stepUnicastDelivery(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
+	item.State = storage.FUOTADeploymentStatusRequest
+	item.NextStepAfter = time.Now()
+
+	err := storage.UpdateFUOTADeployment(ctx, db, &item)
+	if err != nil {
+		return errors.Wrap(err, "update fuota deployment error")
+	}
+
+	return nil
+}
+
+// HandleUplink enqueues the next fragment for the given device when it
+// participates in a FUOTA deployment of group-type A. A fragment is only
+// enqueued when the device-queue is empty, so that the delivery is paced
+// by the uplinks of the device.
+func HandleUplink(ctx context.Context, db sqlx.Ext, devEUI lorawan.EUI64) error {
+	var ids []uuid.UUID
+	err := sqlx.Select(db, &ids, `
 		select
-			rms.dev_eui
+			fd.id
 		from
-			remote_multicast_setup rms
+			fuota_deployment fd
+		inner join
+			fuota_deployment_device fdd
+		on
+			fdd.fuota_deployment_id = fd.id
 		inner join
 			remote_fragmentation_session rfs
 		on
-			rfs.dev_eui = rms.dev_eui
-			and rfs.frag_index = $1
+			rfs.dev_eui = fdd.dev_eui
+			and rfs.frag_index = $2
 		where
-			rms.multicast_group_id = $2
-			and rms.state = $3
-			and rms.state_provisioned = $4
-			and rfs.state = $3
-			and rfs.state_provisioned = $4`,
+			fdd.dev_eui = $1
+			and fd.group_type = $3
+			and fd.state = $4
+			and fdd.state = $5
+			and fdd.fragments_sent < fd.fragments_sent
+			and rfs.state = $6
+			and rfs.state_provisioned = $7
+		order by
+			fd.created_at
+		limit 1`,
+		devEUI,
 		fragIndex,
-		item.MulticastGroupID,
+		storage.FUOTADeploymentGroupTypeA,
+		storage.FUOTADeploymentUnicastDelivery,
+		storage.FUOTADeploymentDevicePending,
 		storage.RemoteMulticastSetupSetup,
 		true,
 	)
+	if err != nil {
+		return errors.Wrap(err, "get unicast fuota deployment error")
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// the deployment is shared by all the devices of the deployment and is
+	// not locked, so that the uplinks of these devices do not serialize on
+	// it
+	item, err := storage.GetFUOTADeployment(ctx, db, ids[0], false)
+	if err != nil {
+		return errors.Wrap(err, "get fuota deployment error")
+	}
+
+	// the device-queue also contains the fragmentation session and status
+	// requests, only enqueue the next fragment once these have been sent.
+	// This is requested before locking the deployment device, so that the
+	// lock is not held during the network-server round-trip.
+	n, err := storage.GetNetworkServerForDevEUI(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get network-server error")
+	}
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return errors.Wrap(err, "get network-server client error")
+	}
+	queueResp, err := nsClient.GetDeviceQueueItemsForDevEUI(ctx, &ns.GetDeviceQueueItemsForDevEUIRequest{
+		DevEui:    devEUI[:],
+		CountOnly: true,
+	})
+	if err != nil {
+		return errors.Wrap(err, "get device-queue items error")
+	}
+	if queueResp.TotalCount != 0 {
+		return nil
+	}
+
+	fdd, err := storage.GetFUOTADeploymentDevice(ctx, db, item.ID, devEUI, true)
+	if err != nil {
+		return errors.Wrap(err, "get fuota deployment device error")
+	}
+	if fdd.State != storage.FUOTADeploymentDevicePending || fdd.FragmentsSent >= item.FragmentsSent {
+		return nil
+	}
+
+	fragments, err := encodeFragments(item, item.FragmentsSent-nbFragments(item))
+	if err != nil {
+		return errors.Wrap(err, "fragment payload error")
+	}
+	if fdd.FragmentsSent >= len(fragments) {
+		return fmt.Errorf("fragment %d out of range", fdd.FragmentsSent+1)
+	}
+
+	cmd := fragmentation.Command{
+		CID: fragmentation.DataFragment,
+		Payload: &fragmentation.DataFragmentPayload{
+			IndexAndN: fragmentation.DataFragmentPayloadIndexAndN{
+				FragIndex: uint8(fragIndex),
+				N:         uint16(fdd.FragmentsSent + 1),
+			},
+			Payload: fragments[fdd.FragmentsSent],
+		},
+	}
+	b, err := cmd.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "marshal binary error")
+	}
+
+	params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, devEUI)
+	if err != nil {
+		return errors.Wrap(err, "get application-layer params error")
+	}
+
+	_, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, params.FragmentationFPort, b)
+	if err != nil {
+		return errors.Wrap(err, "enqueue downlink payload error")
+	}
+
+	fdd.FragmentsSent++
+	if err := storage.UpdateFUOTADeploymentDevice(ctx, db, &fdd); err != nil {
+		return errors.Wrap(err, "update fuota deployment device error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":             item.ID,
+		"dev_eui":        devEUI,
+		"fragment":       fdd.FragmentsSent,
+		"fragments_sent": item.FragmentsSent,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("fuota: fragment enqueued")
+
+	// continue with the status request once all devices received their
+	// fragments
+	var remaining int
+	err = sqlx.Get(db, &remaining, `
+		select
+			count(*)
+		from
+			fuota_deployment_device fdd
+		inner join
+			remote_fragmentation_session rfs
+		on
+			rfs.dev_eui = fdd.dev_eui
+			and rfs.frag_index = $2
+		where
+			fdd.fuota_deployment_id = $1
+			and fdd.state = $3
+			and fdd.fragments_sent < $4
+			and rfs.state = $5
+			and rfs.state_provisioned = $6`,
+		item.ID,
+		fragIndex,
+		storage.FUOTADeploymentDevicePending,
+		item.FragmentsSent,
+		storage.RemoteMulticastSetupSetup,
+		true,
+	)
+	if err != nil {
+		return errors.Wrap(err, "get remaining unicast devices error")
+	}
+	if remaining != 0 {
+		return nil
+	}
+
+	// only the next step is scheduled, as the deployment has not been
+	// locked and might have been updated in the meantime. In case of
+	// concurrent uplinks of the last devices, the delivery deadline is used.
+	_, err = db.Exec(`
+		update
+			fuota_deployment
+		set
+			updated_at = $2,
+			next_step_after = $2
+		where
+			id = $1
+			and state = $3`,
+		item.ID,
+		time.Now(),
+		storage.FUOTADeploymentUnicastDelivery,
+	)
+	if err != nil {
+		return errors.Wrap(err, "update fuota deployment error")
+	}
+
+	return nil
+}
+
+func stepStatusRequest(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
+	if item.MulticastGroupID == nil && item.GroupType != storage.FUOTADeploymentGroupTypeA {
+		return errors.New("MulticastGroupID must not be nil")
+	}
+
+	// query all pending devices with complete fragmentation session setup
+	var devEUIs []lorawan.EUI64
+	var err error
+	if item.GroupType == storage.FUOTADeploymentGroupTypeA {
+		devEUIs, err = getUnicastDevices(db, item)
+	} else {
+		err = sqlx.Select(db, &devEUIs, `
+			select
+				rms.dev_eui
+			from
+				remote_multicast_setup rms
+			inner join
+				remote_fragmentation_session rfs
+			on
+				rfs.dev_eui = rms.dev_eui
+				and rfs.frag_index = $1
+			inner join
+				fuota_deployment_device fdd
+			on
+				fdd.dev_eui = rms.dev_eui
+				and fdd.fuota_deployment_id = $5
+			where
+				rms.multicast_group_id = $2
+				and rms.state = $3
+				and rms.state_provisioned = $4
+				and rfs.state = $3
+				and rfs.state_provisioned = $4
+				and fdd.state = $6`,
+			fragIndex,
+			item.MulticastGroupID,
+			storage.RemoteMulticastSetupSetup,
+			true,
+			item.ID,
+			storage.FUOTADeploymentDevicePending,
+		)
+	}
 	if err != nil {
 		return errors.Wrap(err, "get devices with fragmentation session setup error")
 	}
@@ -569,7 +916,12 @@ func stepStatusRequest(ctx context.Context, db sqlx.Ext, item storage.FUOTADeplo
 			return errors.Wrap(err, "marshal binary error")
 		}
 
-		_, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, fragmentation.DefaultFPort, b)
+		params, err := storage.GetApplicationLayerParamsForDevice(ctx, db, devEUI)
+		if err != nil {
+			return errors.Wrap(err, "get application-layer params error")
+		}
+
+		_, err = storage.EnqueueDownlinkPayload(ctx, db, devEUI, false, params.FragmentationFPort, b)
 		if err != nil {
 			return errors.Wrap(err, "enqueue downlink payload error")
 		}
@@ -586,43 +938,99 @@ func stepStatusRequest(ctx context.Context, db sqlx.Ext, item storage.FUOTADeplo
 	return nil
 }
 
-func stepSetDeviceStatus(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
-	if item.MulticastGroupID == nil {
+func stepSetDeviceStatus(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment, events *Events) error {
+	if item.MulticastGroupID == nil && item.GroupType != storage.FUOTADeploymentGroupTypeA {
 		return errors.New("MulticastGroupID must not be nil")
 	}
 
-	// set remote multicast session error
-	_, err := db.Exec(`
-		update
-			fuota_deployment_device fdd
-		set
-			state = $5,
-			error_message = $6
-		from
-			remote_multicast_setup rms
-		where
-			fdd.fuota_deployment_id = $1
-			and rms.multicast_group_id = $2
+	// start a repair session when devices reported missing fragments
+	if item.FragmentationMatrix == 0 && item.RepairCount < repairSessions {
+		var devices []repairStatus
+		err := sqlx.Select(db, &devices, `
+			select
+				nb_frag_received,
+				missing_frag
+			from
+				fuota_deployment_device
+			where
+				fuota_deployment_id = $1
+				and state = $2
+				and missing_frag > 0`,
+			item.ID,
+			storage.FUOTADeploymentDevicePending,
+		)
+		if err != nil {
+			return errors.Wrap(err, "get devices with missing fragments error")
+		}
 
-			and fdd.state = $3
-			and rms.state_provisioned = $4
+		if n := repairFragments(item.FragmentsSent, devices); n > 0 {
+			item.RepairCount++
+			item.RepairFragments = n
+			item.State = storage.FUOTADeploymentMulticastSessCSetup
+			if item.GroupType == storage.FUOTADeploymentGroupTypeA {
+				item.State = storage.FUOTADeploymentEnqueue
+			}
+			item.NextStepAfter = time.Now()
 
-			-- join the two tables
-			and fdd.dev_eui = rms.dev_eui`,
+			log.WithFields(log.Fields{
+				"id":               item.ID,
+				"repair_count":     item.RepairCount,
+				"repair_fragments": item.RepairFragments,
+				"devices":          len(devices),
+				"ctx_id":           ctx.Value(logging.ContextIDKey),
+			}).Info("fuota: starting repair session")
 
-		item.ID,
-		*item.MulticastGroupID,
-		storage.FUOTADeploymentDevicePending,
-		false,
-		storage.FUOTADeploymentDeviceError,
-		"The device failed to provision the remote multicast setup.",
-	)
-	if err != nil {
-		return errors.Wrap(err, "set remote multicast setup error error")
+			err = storage.UpdateFUOTADeployment(ctx, db, &item)
+			if err != nil {
+				return errors.Wrap(err, "update fuota deployment error")
+			}
+
+			return nil
+		}
+	}
+
+	// the devices set to error, for publishing the device state events
+	var failed []lorawan.EUI64
+
+	// set remote multicast session error
+	if item.GroupType != storage.FUOTADeploymentGroupTypeA {
+		var devEUIs []lorawan.EUI64
+		err := sqlx.Select(db, &devEUIs, `
+			update
+				fuota_deployment_device fdd
+			set
+				state = $5,
+				error_message = $6
+			from
+				remote_multicast_setup rms
+			where
+				fdd.fuota_deployment_id = $1
+				and rms.multicast_group_id = $2
+
+				and fdd.state = $3
+				and rms.state_provisioned = $4
+
+				-- join the two tables
+				and fdd.dev_eui = rms.dev_eui
+			returning
+				fdd.dev_eui`,
+
+			item.ID,
+			*item.MulticastGroupID,
+			storage.FUOTADeploymentDevicePending,
+			false,
+			storage.FUOTADeploymentDeviceError,
+			"The device failed to provision the remote multicast setup.",
+		)
+		if err != nil {
+			return errors.Wrap(err, "set remote multicast setup error error")
+		}
+		failed = append(failed, devEUIs...)
 	}
 
 	// set remote fragmentation session error
-	_, err = db.Exec(`
+	var devEUIs []lorawan.EUI64
+	err := sqlx.Select(db, &devEUIs, `
 		update
 			fuota_deployment_device fdd
 		set
@@ -638,7 +1046,9 @@ func stepSetDeviceStatus(ctx context.Context, db sqlx.Ext, item storage.FUOTADep
 			and rfs.state_provisioned = $4
 
 			-- join the two tables
-			and fdd.dev_eui = rfs.dev_eui`,
+			and fdd.dev_eui = rfs.dev_eui
+		returning
+			fdd.dev_eui`,
 		item.ID,
 		fragIndex,
 		storage.FUOTADeploymentDevicePending,
@@ -649,17 +1059,22 @@ func stepSetDeviceStatus(ctx context.Context, db sqlx.Ext, item storage.FUOTADep
 	if err != nil {
 		return errors.Wrap(err, "set fragmentation session setup error error")
 	}
+	failed = append(failed, devEUIs...)
 
-	// set remaining errors
-	_, err = db.Exec(`
+	// set remaining errors, devices that reported missing fragments keep
+	// the reported error
+	devEUIs = nil
+	err = sqlx.Select(db, &devEUIs, `
 		update
 			fuota_deployment_device
 		set
 			state = $3,
-			error_message = $4
+			error_message = case when missing_frag > 0 then error_message else $4 end
 		where
 			fuota_deployment_id = $1
-			and state = $2`,
+			and state = $2
+		returning
+			dev_eui`,
 		item.ID,
 		storage.FUOTADeploymentDevicePending,
 		storage.FUOTADeploymentDeviceError,
@@ -668,6 +1083,9 @@ func stepSetDeviceStatus(ctx context.Context, db sqlx.Ext, item storage.FUOTADep
 	if err != nil {
 		return errors.Wrap(err, "set incomplete fuota deployment error")
 	}
+	failed = append(failed, devEUIs...)
+
+	events.addDeviceStates(item.ID, failed)
 
 	item.State = storage.FUOTADeploymentCleanup
 	item.NextStepAfter = time.Now()
@@ -680,14 +1098,129 @@ func stepSetDeviceStatus(ctx context.Context, db sqlx.Ext, item storage.FUOTADep
 	return nil
 }
 
-func stepCleanup(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment) error {
+func stepCancel(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment, events *Events) error {
+	var devEUIs []lorawan.EUI64
+	err := sqlx.Select(db, &devEUIs, `
+		update
+			fuota_deployment_device
+		set
+			updated_at = $4,
+			state = $3,
+			error_message = $5
+		where
+			fuota_deployment_id = $1
+			and state in ($2, $6)
+		returning
+			dev_eui`,
+		item.ID,
+		storage.FUOTADeploymentDevicePending,
+		storage.FUOTADeploymentDeviceError,
+		time.Now(),
+		"The FUOTA deployment was cancelled.",
+		storage.FUOTADeploymentDeviceWaiting,
+	)
+	if err != nil {
+		return errors.Wrap(err, "set cancelled fuota deployment error")
+	}
+
+	events.addDeviceStates(item.ID, devEUIs)
+
+	// the multicast-group has not yet been created, there is nothing to
+	// clean up
+	if item.MulticastGroupID == nil && item.GroupType != storage.FUOTADeploymentGroupTypeA {
+		item.State = storage.FUOTADeploymentCancelled
+		item.NextStepAfter = time.Now()
+
+		if err := storage.UpdateFUOTADeployment(ctx, db, &item); err != nil {
+			return errors.Wrap(err, "update fuota deployment error")
+		}
+
+		return nil
+	}
+
+	// the fragments have been enqueued, remove them from the multicast-queue
+	if item.MulticastGroupID != nil && item.GroupType != storage.FUOTADeploymentGroupTypeA && (item.PreviousState == storage.FUOTADeploymentStatusRequest || item.PreviousState == storage.FUOTADeploymentSetDeviceStatus) {
+		if err := multicast.FlushQueue(ctx, db, *item.MulticastGroupID); err != nil {
+			return errors.Wrap(err, "flush multicast-queue error")
+		}
+	}
+
+	// delete the fragmentation sessions of the devices that were set up
+	_, err = db.Exec(`
+		update
+			remote_fragmentation_session rfs
+		set
+			updated_at = $4,
+			state = $3,
+			state_provisioned = false,
+			retry_count = 0,
+			retry_after = $4
+		from
+			fuota_deployment_device fdd
+		where
+			fdd.fuota_deployment_id = $1
+			and rfs.frag_index = $2
+			and rfs.state != $3
+
+			-- join the two tables
+			and rfs.dev_eui = fdd.dev_eui`,
+		item.ID,
+		fragIndex,
+		storage.RemoteMulticastSetupDelete,
+		time.Now(),
+	)
+	if err != nil {
+		return errors.Wrap(err, "set remote fragmentation session delete error")
+	}
+
+	// the multicast-group was created for this deployment, delete the
+	// multicast setup of the devices that were set up
+	if item.MulticastGroupID != nil && item.Type == storage.FUOTADeploymentForDevice {
+		_, err = db.Exec(`
+			update
+				remote_multicast_setup
+			set
+				updated_at = $3,
+				state = $2,
+				state_provisioned = false,
+				retry_count = 0,
+				retry_after = $3
+			where
+				multicast_group_id = $1
+				and state != $2`,
+			*item.MulticastGroupID,
+			storage.RemoteMulticastSetupDelete,
+			time.Now(),
+		)
+		if err != nil {
+			return errors.Wrap(err, "set remote multicast setup delete error")
+		}
+	}
+
+	retries := remoteMulticastSetupRetries
+	if remoteFragmentationSessionRetries > retries {
+		retries = remoteFragmentationSessionRetries
+	}
+
+	item.State = storage.FUOTADeploymentCancelCleanup
+	item.NextStepAfter = time.Now().Add(time.Duration(retries) * item.UnicastTimeout)
+
+	err = storage.UpdateFUOTADeployment(ctx, db, &item)
+	if err != nil {
+		return errors.Wrap(err, "update fuota deployment error")
+	}
+
+	return nil
+}
+
+func stepCleanup(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment, state storage.FUOTADeploymentState) error {
 	if item.MulticastGroupID != nil && item.Type == storage.FUOTADeploymentForDevice {
 		// FUOTA for Device, remove multicast group
 		if err := storage.DeleteMulticastGroup(ctx, db, *item.MulticastGroupID); err != nil {
 			return errors.Wrap(err, "delete multicast group error")
 		}
 		item.MulticastGroupID = nil
-	} else {
+	} else if item.MulticastGroupID != nil && item.GroupType != storage.FUOTADeploymentGroupTypeA {
 		// FUOTA for group, remove multicast class c session records
 		nbDevice, err := storage.GetDeviceCountForMulticastGroup(ctx, db, *item.MulticastGroupID)
 		if err != nil {
@@ -705,7 +1238,14 @@ func stepCleanup(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment)
 		}
 	}
 
-	item.State = storage.FUOTADeploymentDone
+	item.State = state
+
+	// continue with the next wave of a staged deployment
+	if state == storage.FUOTADeploymentDone && item.CurrentWave+1 < item.WaveCount() {
+		if err := gateNextWave(ctx, db, &item); err != nil {
+			return err
+		}
+	}
 
 	err := storage.UpdateFUOTADeployment(ctx, db, &item)
 	if err != nil {
@@ -714,3 +1254,221 @@ func stepCleanup(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment)
 
 	return nil
 }
+
+// gateNextWave sets the state to start the next wave when the success-rate
+// of the current wave meets the threshold. Otherwise the deployment is
+// halted. A halted deployment continues with the next wave when resumed.
+func gateNextWave(ctx context.Context, db sqlx.Ext, item *storage.FUOTADeployment) error {
+	stats, err := storage.GetFUOTADeploymentWaveStats(ctx, db, item.ID)
+	if err != nil {
+		return errors.Wrap(err, "get fuota deployment wave stats error")
+	}
+
+	current := storage.FUOTADeploymentWaveStats{Wave: item.CurrentWave}
+	for _, s := range stats {
+		if s.Wave == item.CurrentWave {
+			current = s
+		}
+	}
+
+	item.CurrentWave++
+	item.State = storage.FUOTADeploymentNextWave
+	item.NextStepAfter = time.Now()
+
+	if rate := current.SuccessRate(); rate < item.WaveSuccessThreshold {
+		item.State = storage.FUOTADeploymentHalted
+
+		log.WithFields(log.Fields{
+			"id":           item.ID,
+			"wave":         current.Wave,
+			"success_rate": rate,
+			"threshold":    item.WaveSuccessThreshold,
+			"ctx_id":       ctx.Value(logging.ContextIDKey),
+		}).Warning("fuota: success-rate below threshold, deployment halted")
+	}
+
+	return nil
+}
+
+// stepNextWave starts the current wave of a staged deployment within the
+// start-time window.
+func stepNextWave(ctx context.Context, db sqlx.Ext, item storage.FUOTADeployment, events *Events) error {
+	now := time.Now()
+
+	switch {
+	case item.StartAfter != nil && now.Before(*item.StartAfter):
+		item.NextStepAfter = *item.StartAfter
+	case item.StartBefore != nil && now.After(*item.StartBefore):
+		item.State = storage.FUOTADeploymentHalted
+
+		log.WithFields(log.Fields{
+			"id":     item.ID,
+			"wave":   item.CurrentWave,
+			"ctx_id": ctx.Value(logging.ContextIDKey),
+		}).Warning("fuota: start-time window has passed, deployment halted")
+	case item.CurrentWave >= item.WaveCount():
+		item.State = storage.FUOTADeploymentDone
+	default:
+		devEUIs, err := storage.StartFUOTADeploymentWave(ctx, db, item.ID, item.CurrentWave)
+		if err != nil {
+			return errors.Wrap(err, "start fuota deployment wave error")
+		}
+
+		events.addDeviceStates(item.ID, devEUIs)
+
+		item.NextStepAfter = now
+
+		// skip waves without devices
+		if len(devEUIs) == 0 {
+			item.CurrentWave++
+			break
+		}
+
+		item.State = storage.FUOTADeploymentFragmentationSessSetup
+		item.FragmentsSent = 0
+		item.RepairCount = 0
+		item.RepairFragments = 0
+	}
+
+	err := storage.UpdateFUOTADeployment(ctx, db, &item)
+	if err != nil {
+		return errors.Wrap(err, "update fuota deployment error")
+	}
+
+	return nil
+}
+
+// nbFragments returns the number of uncoded fragments of the payload.
+func nbFragments(item storage.FUOTADeployment) int {
+	padding := (item.FragSize - (len(item.Payload) % item.FragSize)) % item.FragSize
+	return (len(item.Payload) + padding) / item.FragSize
+}
+
+// encodeFragments fragments the payload of the given deployment. In case
+// of FEC encoding, redundancy coded fragments are appended.
+func encodeFragments(item storage.FUOTADeployment, redundancy int) ([][]byte, error) {
+	padding := (item.FragSize - (len(item.Payload) % item.FragSize)) % item.FragSize
+	data := append(item.Payload, make([]byte, padding)...)
+
+	var fragments [][]byte
+	var err error
+
+	switch item.FragmentationMatrix {
+	case 0: // FEC encoding
+		fragments, err = fragmentation.Encode(data, item.FragSize, redundancy)
+	case 7: // disable encoding
+		// fragment the data into rows
+		for i := 0; i < len(data)/item.FragSize; i++ {
+			offset := i * item.FragSize
+			fragments = append(fragments, data[offset:offset+item.FragSize])
+		}
+	}
+
+	return fragments, err
+}
+
+// getMulticastFragmentationFPort returns the fragmentation fPort of the
+// pending devices of the given deployment. As the fragments are sent to the
+// multicast-group, all these devices must use the same fPort.
+func getMulticastFragmentationFPort(db sqlx.Queryer, item storage.FUOTADeployment) (uint8, error) {
+	var fPorts []int
+	err := sqlx.Select(db, &fPorts, `
+		select
+			distinct dp.app_layer_fragmentation_f_port
+		from
+			fuota_deployment_device fdd
+		inner join
+			device d
+		on
+			d.dev_eui = fdd.dev_eui
+		inner join
+			device_profile dp
+		on
+			dp.device_profile_id = d.device_profile_id
+		where
+			fdd.fuota_deployment_id = $1
+			and fdd.state = $2`,
+		item.ID,
+		storage.FUOTADeploymentDevicePending,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "select error")
+	}
+
+	switch len(fPorts) {
+	case 0:
+		return fragmentation.DefaultFPort, nil
+	case 1:
+		return uint8(fPorts[0]), nil
+	default:
+		return 0, fmt.Errorf("devices use different fragmentation fPorts: %v", fPorts)
+	}
+}
+
+// getUnicastDevices returns the pending devices of a group-type A
+// deployment with complete fragmentation session setup.
+func getUnicastDevices(db sqlx.Queryer, item storage.FUOTADeployment) ([]lorawan.EUI64, error) {
+	var devEUIs []lorawan.EUI64
+	err := sqlx.Select(db, &devEUIs, `
+		select
+			fdd.dev_eui
+		from
+			fuota_deployment_device fdd
+		inner join
+			remote_fragmentation_session rfs
+		on
+			rfs.dev_eui = fdd.dev_eui
+			and rfs.frag_index = $2
+		where
+			fdd.fuota_deployment_id = $1
+			and fdd.state = $3
+			and rfs.state = $4
+			and rfs.state_provisioned = $5`,
+		item.ID,
+		fragIndex,
+		storage.FUOTADeploymentDevicePending,
+		storage.RemoteMulticastSetupSetup,
+		true,
+	)
+	return devEUIs, err
+}
+
+// repairStatus contains the fragmentation session status as reported by the
+// device.
+type repairStatus struct {
+	NbFragReceived int `db:"nb_frag_received"`
+	MissingFrag    int `db:"missing_frag"`
+}
+
+// repairFragments returns the number of extra coded fragments to send in a
+// repair session. For each device, the number of missing fragments is
+// corrected for the fragment loss observed by the device. The max. over all
+// devices is returned, limited by the max. number of fragments.
+func repairFragments(fragmentsSent int, devices []repairStatus) int {
+	var out int
+
+	for _, d := range devices {
+		if d.MissingFrag <= 0 {
+			continue
+		}
+
+		n := d.MissingFrag
+		if d.NbFragReceived > 0 && d.NbFragReceived < fragmentsSent {
+			// n = missing / (received / sent), rounded up
+			n = (d.MissingFrag*fragmentsSent + d.NbFragReceived - 1) / d.NbFragReceived
+		}
+
+		if n > out {
+			out = n
+		}
+	}
+
+	if fragmentsSent+out > maxFragments {
+		out = maxFragments - fragmentsSent
+	}
+	if out < 0 {
+		out = 0
+	}
+
+	return out
+}
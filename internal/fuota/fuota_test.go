@@ -2,6 +2,7 @@ package fuota
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/gyh1621/chirpstack-api/go/v3/ns"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/gyh1621/chirpstack-application-server/internal/integration"
+	"github.com/gyh1621/chirpstack-application-server/internal/integration/mock"
 	"github.com/gyh1621/chirpstack-application-server/internal/storage"
 	"github.com/gyh1621/chirpstack-application-server/internal/test"
 	"github.com/stretchr/testify/require"
@@ -20,8 +23,9 @@ import (
 type FUOTATestSuite struct {
 	suite.Suite
 
-	tx       *storage.TxLogger
-	nsClient *nsmock.Client
+	tx          *storage.TxLogger
+	nsClient    *nsmock.Client
+	integration *mock.Integration
 
 	NetworkServer  storage.NetworkServer
 	Organization   storage.Organization
@@ -54,6 +58,9 @@ func (ts *FUOTATestSuite) SetupTest() {
 	ts.nsClient = nsmock.NewClient()
 	networkserver.SetPool(nsmock.NewPool(ts.nsClient))
 
+	ts.integration = mock.New()
+	integration.SetMockIntegration(ts.integration)
+
 	ts.NetworkServer = storage.NetworkServer{
 		Name:   "test",
 		Server: "test:1234",
@@ -113,7 +120,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentMulticastCreate() {
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// test that multicast-group has been set
 	fdGet, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
@@ -171,7 +178,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentMulticastSetupLW10() {
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate remote multicast setup
 	items, err := storage.GetPendingRemoteMulticastSetupItems(context.Background(), ts.tx, 10, 10)
@@ -229,7 +236,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentMulticastSetupLW11() {
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate remote multicast setup
 	items, err := storage.GetPendingRemoteMulticastSetupItems(context.Background(), ts.tx, 10, 10)
@@ -293,7 +300,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetup() {
 	assert.NoError(storage.CreateRemoteMulticastSetup(context.Background(), ts.tx, &rms))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate fragmentation sesssion
 	items, err := storage.GetPendingRemoteFragmentationSessions(context.Background(), ts.tx, 10, 10)
@@ -316,6 +323,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetup() {
 		Descriptor:          [4]byte{1, 2, 3, 4},
 		State:               storage.RemoteMulticastSetupSetup,
 		RetryInterval:       time.Second,
+		PackageVersion:      1,
 	}, items[0])
 
 	// validate fuota deployment record
@@ -325,6 +333,104 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetup() {
 	assert.True(fdUpdated.NextStepAfter.After(time.Now()))
 }
 
+func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetupV2() {
+	assert := require.New(ts.T())
+
+	deviceKeys := storage.DeviceKeys{
+		DevEUI:    ts.Device.DevEUI,
+		GenAppKey: lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+	}
+	assert.NoError(storage.CreateDeviceKeys(context.Background(), ts.tx, &deviceKeys))
+	ts.nsClient.GetDeviceProfileResponse = ns.GetDeviceProfileResponse{
+		DeviceProfile: &ns.DeviceProfile{
+			MacVersion: "1.0.3",
+		},
+	}
+
+	fragmentationVersion := 2
+	assert.NoError(storage.CreateDeviceApplicationLayer(context.Background(), ts.tx, &storage.DeviceApplicationLayer{
+		DevEUI:               ts.Device.DevEUI,
+		FragmentationVersion: &fragmentationVersion,
+	}))
+
+	mcg := storage.MulticastGroup{
+		Name:  "test-mg",
+		MCKey: lorawan.AES128Key{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1},
+	}
+	copy(mcg.ServiceProfileID[:], ts.ServiceProfile.ServiceProfile.Id)
+	assert.NoError(storage.CreateMulticastGroup(context.Background(), ts.tx, &mcg))
+	var mcgID uuid.UUID
+	copy(mcgID[:], mcg.MulticastGroup.Id)
+	mcgReq := <-ts.nsClient.CreateMulticastGroupChan
+	ts.nsClient.GetMulticastGroupResponse.MulticastGroup = mcgReq.MulticastGroup
+
+	fd := storage.FUOTADeployment{
+		Name:                "test-deployment",
+		MulticastGroupID:    &mcgID,
+		UnicastTimeout:      time.Second,
+		State:               storage.FUOTADeploymentFragmentationSessSetup,
+		FragmentationMatrix: 3,
+		Descriptor:          [4]byte{1, 2, 3, 4},
+		Payload:             []byte{1, 2, 3, 4, 5},
+		FragSize:            2,
+		Redundancy:          10,
+		BlockAckDelay:       4,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	rms := storage.RemoteMulticastSetup{
+		DevEUI:           ts.Device.DevEUI,
+		MulticastGroupID: mcgID,
+		State:            storage.RemoteMulticastSetupSetup,
+		StateProvisioned: true,
+	}
+	assert.NoError(storage.CreateRemoteMulticastSetup(context.Background(), ts.tx, &rms))
+
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	items, err := storage.GetPendingRemoteFragmentationSessions(context.Background(), ts.tx, 10, 10)
+	assert.NoError(err)
+	assert.Len(items, 1)
+
+	key, err := dataBlockIntKey(deviceKeys.GenAppKey)
+	assert.NoError(err)
+	mic, err := dataBlockMIC(key, 1, 0, fd.Descriptor, fd.Payload)
+	assert.NoError(err)
+
+	assert.Equal(2, items[0].PackageVersion)
+	assert.Equal(1, items[0].SessionCnt)
+	assert.Equal(mic, items[0].MIC)
+
+	dal, err := storage.GetDeviceApplicationLayer(context.Background(), ts.tx, ts.Device.DevEUI, false)
+	assert.NoError(err)
+	assert.Equal(1, dal.FragmentationSessionCnt)
+}
+
+func TestDataBlockMIC(t *testing.T) {
+	assert := require.New(t)
+
+	key, err := dataBlockIntKey(lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	assert.NoError(err)
+	assert.NotEqual(lorawan.AES128Key{}, key)
+
+	mic1, err := dataBlockMIC(key, 1, 0, [4]byte{1, 2, 3, 4}, []byte{1, 2, 3, 4, 5})
+	assert.NoError(err)
+
+	// the same input results in the same MIC
+	mic, err := dataBlockMIC(key, 1, 0, [4]byte{1, 2, 3, 4}, []byte{1, 2, 3, 4, 5})
+	assert.NoError(err)
+	assert.Equal(mic1, mic)
+
+	// the MIC changes with the session counter and the data
+	mic, err = dataBlockMIC(key, 2, 0, [4]byte{1, 2, 3, 4}, []byte{1, 2, 3, 4, 5})
+	assert.NoError(err)
+	assert.NotEqual(mic1, mic)
+
+	mic, err = dataBlockMIC(key, 1, 0, [4]byte{1, 2, 3, 4}, []byte{1, 2, 3, 4, 6})
+	assert.NoError(err)
+	assert.NotEqual(mic1, mic)
+}
+
 func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetupMulticastSetupNotCompleted() {
 	assert := require.New(ts.T())
 
@@ -362,7 +468,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetupMulticastS
 	assert.NoError(storage.CreateRemoteMulticastSetup(context.Background(), ts.tx, &rms))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate fragmentation sesssion
 	items, err := storage.GetPendingRemoteFragmentationSessions(context.Background(), ts.tx, 10, 10)
@@ -412,7 +518,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentMulticastSessCSetup() {
 	assert.NoError(storage.CreateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate class-c sessions
 	items, err := storage.GetPendingRemoteMulticastClassCSessions(context.Background(), ts.tx, 10, 10)
@@ -465,7 +571,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentEnqueue() {
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
 	// run
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate scheduled payloads
 	items := []ns.MulticastQueueItem{
@@ -495,6 +601,169 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentEnqueue() {
 	}
 }
 
+func (ts *FUOTATestSuite) TestFUOTADeploymentEnqueueRepair() {
+	assert := require.New(ts.T())
+
+	mcg := storage.MulticastGroup{
+		Name:      "test-mg",
+		MCAppSKey: lorawan.AES128Key{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		MulticastGroup: ns.MulticastGroup{
+			FCnt: 10,
+		},
+	}
+	copy(mcg.ServiceProfileID[:], ts.ServiceProfile.ServiceProfile.Id)
+	assert.NoError(storage.CreateMulticastGroup(context.Background(), ts.tx, &mcg))
+	var mcgID uuid.UUID
+	copy(mcgID[:], mcg.MulticastGroup.Id)
+	mcgReq := <-ts.nsClient.CreateMulticastGroupChan
+	ts.nsClient.GetMulticastGroupResponse.MulticastGroup = mcgReq.MulticastGroup
+
+	fd := storage.FUOTADeployment{
+		Name:             "test-deployment",
+		MulticastGroupID: &mcgID,
+		Payload:          []byte{1, 2, 3, 4},
+		FragSize:         2,
+		Redundancy:       1,
+		State:            storage.FUOTADeploymentEnqueue,
+		GroupType:        storage.FUOTADeploymentGroupTypeC,
+		FragmentsSent:    3,
+		RepairCount:      1,
+		RepairFragments:  2,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	// run
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	// validate that only the repair fragments (index 4 and 5) are scheduled
+	for _, fCnt := range []uint32{10, 11} {
+		req := <-ts.nsClient.EnqueueMulticastQueueItemChan
+		assert.Equal(fCnt, req.MulticastQueueItem.FCnt)
+	}
+	assert.Len(ts.nsClient.EnqueueMulticastQueueItemChan, 0)
+
+	fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+	assert.NoError(err)
+	assert.Equal(5, fdUpdated.FragmentsSent)
+	assert.Equal(storage.FUOTADeploymentStatusRequest, fdUpdated.State)
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentFragmentationSessionSetupUnicast() {
+	assert := require.New(ts.T())
+
+	fd := storage.FUOTADeployment{
+		Name:                "test-deployment",
+		GroupType:           storage.FUOTADeploymentGroupTypeA,
+		UnicastTimeout:      time.Second,
+		FragmentationMatrix: 0,
+		Descriptor:          [4]byte{1, 2, 3, 4},
+		Payload:             []byte{1, 2, 3, 4, 5},
+		FragSize:            2,
+		Redundancy:          1,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+	assert.Equal(storage.FUOTADeploymentFragmentationSessSetup, fd.State)
+
+	// run
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	// validate fragmentation session, no multicast group is used
+	items, err := storage.GetPendingRemoteFragmentationSessions(context.Background(), ts.tx, 10, 10)
+	assert.NoError(err)
+	assert.Len(items, 1)
+	assert.Len(items[0].MCGroupIDs, 0)
+	assert.Equal(3, items[0].NbFrag)
+
+	fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+	assert.NoError(err)
+	assert.Equal(storage.FUOTADeploymentEnqueue, fdUpdated.State)
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentUnicastDelivery() {
+	assert := require.New(ts.T())
+
+	rfs := storage.RemoteFragmentationSession{
+		DevEUI:           ts.Device.DevEUI,
+		FragIndex:        fragIndex,
+		State:            storage.RemoteMulticastSetupSetup,
+		StateProvisioned: true,
+	}
+	assert.NoError(storage.CreateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
+
+	fd := storage.FUOTADeployment{
+		Name:             "test-deployment",
+		GroupType:        storage.FUOTADeploymentGroupTypeA,
+		Payload:          []byte{1, 2, 3, 4},
+		FragSize:         2,
+		Redundancy:       1,
+		MulticastTimeout: 10,
+		State:            storage.FUOTADeploymentEnqueue,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	ts.T().Run("Enqueue", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+		// nothing is enqueued until the device sends an uplink
+		assert.Len(ts.nsClient.CreateDeviceQueueItemChan, 0)
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentUnicastDelivery, fdUpdated.State)
+		assert.Equal(3, fdUpdated.FragmentsSent)
+		assert.True(fdUpdated.NextStepAfter.After(time.Now()))
+	})
+
+	ts.T().Run("HandleUplink device-queue not empty", func(t *testing.T) {
+		assert := require.New(t)
+
+		ts.nsClient.GetDeviceQueueItemsForDevEUIResponse.TotalCount = 1
+		assert.NoError(HandleUplink(context.Background(), ts.tx, ts.Device.DevEUI))
+		<-ts.nsClient.GetDeviceQueueItemsForDevEUIChan
+		assert.Len(ts.nsClient.CreateDeviceQueueItemChan, 0)
+		ts.nsClient.GetDeviceQueueItemsForDevEUIResponse.TotalCount = 0
+	})
+
+	ts.T().Run("HandleUplink", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(HandleUplink(context.Background(), ts.tx, ts.Device.DevEUI))
+			<-ts.nsClient.GetDeviceQueueItemsForDevEUIChan
+			req := <-ts.nsClient.CreateDeviceQueueItemChan
+			assert.Equal(uint32(fragmentation.DefaultFPort), req.Item.FPort)
+		}
+
+		fdd, err := storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+		assert.NoError(err)
+		assert.Equal(3, fdd.FragmentsSent)
+
+		// all fragments have been sent
+		assert.NoError(HandleUplink(context.Background(), ts.tx, ts.Device.DevEUI))
+		assert.Len(ts.nsClient.GetDeviceQueueItemsForDevEUIChan, 0)
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.True(fdUpdated.NextStepAfter.Before(time.Now()))
+	})
+
+	ts.T().Run("Status request", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+		assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+		req := <-ts.nsClient.CreateDeviceQueueItemChan
+		assert.Equal(uint32(fragmentation.DefaultFPort), req.Item.FPort)
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentSetDeviceStatus, fdUpdated.State)
+	})
+}
+
 func (ts *FUOTATestSuite) TestFUOTADeploymentStatusRequest() {
 	assert := require.New(ts.T())
 
@@ -529,7 +798,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentStatusRequest() {
 	}
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate
 	req := <-ts.nsClient.CreateDeviceQueueItemChan
@@ -571,7 +840,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentSetDeviceStatusNoError() {
 	fdd.State = storage.FUOTADeploymentDeviceSuccess
 	assert.NoError(storage.UpdateFUOTADeploymentDevice(context.Background(), ts.tx, &fdd))
 
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	items, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
 	assert.NoError(err)
@@ -604,7 +873,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentSetDeviceStatusGenericError() {
 	}
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	items, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
 	assert.NoError(err)
@@ -645,7 +914,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentSetDeviceStatusRemoteMulticastSetup
 	}
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	items, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
 	assert.NoError(err)
@@ -686,7 +955,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentSetDeviceStatusFragmentationSession
 	}
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	items, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
 	assert.NoError(err)
@@ -701,6 +970,72 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentSetDeviceStatusFragmentationSession
 	assert.True(fdUpdated.NextStepAfter.Before(time.Now()))
 }
 
+func (ts *FUOTATestSuite) TestFUOTADeploymentSetDeviceStatusRepair() {
+	assert := require.New(ts.T())
+
+	repairSessions = 1
+	defer func() { repairSessions = 0 }()
+
+	mcg := storage.MulticastGroup{
+		Name: "test-mg",
+	}
+	copy(mcg.ServiceProfileID[:], ts.ServiceProfile.ServiceProfile.Id)
+	assert.NoError(storage.CreateMulticastGroup(context.Background(), ts.tx, &mcg))
+	var mcgID uuid.UUID
+	copy(mcgID[:], mcg.MulticastGroup.Id)
+
+	fd := storage.FUOTADeployment{
+		Name:             "test-deployment",
+		MulticastGroupID: &mcgID,
+		State:            storage.FUOTADeploymentSetDeviceStatus,
+		FragmentsSent:    20,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	fdd, err := storage.GetPendingFUOTADeploymentDevice(context.Background(), ts.tx, ts.Device.DevEUI)
+	assert.NoError(err)
+	fdd.NbFragReceived = 15
+	fdd.MissingFrag = 3
+	fdd.ErrorMessage = "3 fragments missed (15 received)."
+	assert.NoError(storage.UpdateFUOTADeploymentDevice(context.Background(), ts.tx, &fdd))
+
+	ts.T().Run("Repair session", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+		items, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
+		assert.NoError(err)
+		assert.Len(items, 1)
+		assert.Equal(storage.FUOTADeploymentDevicePending, items[0].State)
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentMulticastSessCSetup, fdUpdated.State)
+		assert.Equal(1, fdUpdated.RepairCount)
+		assert.Equal(4, fdUpdated.RepairFragments)
+
+		t.Run("Repair sessions exhausted", func(t *testing.T) {
+			assert := require.New(t)
+
+			fdUpdated.State = storage.FUOTADeploymentSetDeviceStatus
+			assert.NoError(storage.UpdateFUOTADeployment(context.Background(), ts.tx, &fdUpdated))
+
+			assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+			items, err := storage.GetFUOTADeploymentDevices(context.Background(), ts.tx, fd.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(storage.FUOTADeploymentDeviceError, items[0].State)
+			assert.Equal("3 fragments missed (15 received).", items[0].ErrorMessage)
+
+			fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+			assert.NoError(err)
+			assert.Equal(storage.FUOTADeploymentCleanup, fdUpdated.State)
+		})
+	})
+}
+
 func (ts *FUOTATestSuite) TestFUOTADeploymentCleanup() {
 	assert := require.New(ts.T())
 
@@ -719,7 +1054,7 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentCleanup() {
 	}
 	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
 
-	assert.NoError(fuotaDeployments(context.Background(), ts.tx))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
 
 	// validate fuota deployment record
 	fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
@@ -732,6 +1067,373 @@ func (ts *FUOTATestSuite) TestFUOTADeploymentCleanup() {
 	assert.Equal(storage.ErrDoesNotExist, err)
 }
 
+func (ts *FUOTATestSuite) TestFUOTADeploymentCancel() {
+	assert := require.New(ts.T())
+
+	mcg := storage.MulticastGroup{
+		Name: "test-mg",
+	}
+	copy(mcg.ServiceProfileID[:], ts.ServiceProfile.ServiceProfile.Id)
+	assert.NoError(storage.CreateMulticastGroup(context.Background(), ts.tx, &mcg))
+	var mcgID uuid.UUID
+	copy(mcgID[:], mcg.MulticastGroup.Id)
+
+	fd := storage.FUOTADeployment{
+		Name:             "test-deployment",
+		MulticastGroupID: &mcgID,
+		State:            storage.FUOTADeploymentCancel,
+		PreviousState:    storage.FUOTADeploymentStatusRequest,
+		UnicastTimeout:   time.Second,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	rms := storage.RemoteMulticastSetup{
+		DevEUI:           ts.Device.DevEUI,
+		MulticastGroupID: mcgID,
+		State:            storage.RemoteMulticastSetupSetup,
+		StateProvisioned: true,
+	}
+	assert.NoError(storage.CreateRemoteMulticastSetup(context.Background(), ts.tx, &rms))
+
+	rfs := storage.RemoteFragmentationSession{
+		DevEUI:           ts.Device.DevEUI,
+		FragIndex:        fragIndex,
+		State:            storage.RemoteMulticastSetupSetup,
+		StateProvisioned: true,
+	}
+	assert.NoError(storage.CreateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
+
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	// validate that the queue has been flushed
+	flushReq := <-ts.nsClient.FlushMulticastQueueForMulticastGroupChan
+	assert.Equal(mcgID.Bytes(), flushReq.MulticastGroupId)
+
+	// validate fuota deployment record
+	fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+	assert.NoError(err)
+	assert.Equal(storage.FUOTADeploymentCancelCleanup, fdUpdated.State)
+	assert.True(fdUpdated.NextStepAfter.After(time.Now()))
+
+	// validate the device record
+	fdd, err := storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+	assert.NoError(err)
+	assert.Equal(storage.FUOTADeploymentDeviceError, fdd.State)
+	assert.Equal("The FUOTA deployment was cancelled.", fdd.ErrorMessage)
+
+	// validate that the sessions will be deleted
+	rms, err = storage.GetRemoteMulticastSetup(context.Background(), ts.tx, ts.Device.DevEUI, mcgID, false)
+	assert.NoError(err)
+	assert.Equal(storage.RemoteMulticastSetupDelete, rms.State)
+	assert.False(rms.StateProvisioned)
+
+	rfs, err = storage.GetRemoteFragmentationSession(context.Background(), ts.tx, ts.Device.DevEUI, fragIndex, false)
+	assert.NoError(err)
+	assert.Equal(storage.RemoteMulticastSetupDelete, rfs.State)
+	assert.False(rfs.StateProvisioned)
+
+	// run the cleanup
+	fdUpdated.NextStepAfter = time.Now()
+	assert.NoError(storage.UpdateFUOTADeployment(context.Background(), ts.tx, &fdUpdated))
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	fdUpdated, err = storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+	assert.NoError(err)
+	assert.Equal(storage.FUOTADeploymentCancelled, fdUpdated.State)
+	assert.Nil(fdUpdated.MulticastGroupID)
+
+	_, err = storage.GetMulticastGroup(context.Background(), ts.tx, mcgID, false, false)
+	assert.Equal(storage.ErrDoesNotExist, err)
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentCancelBeforeMulticastCreate() {
+	assert := require.New(ts.T())
+
+	fd := storage.FUOTADeployment{
+		Name:          "test-deployment",
+		State:         storage.FUOTADeploymentCancel,
+		PreviousState: storage.FUOTADeploymentMulticastCreate,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+	assert.NoError(err)
+	assert.Equal(storage.FUOTADeploymentCancelled, fdUpdated.State)
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentEvents() {
+	assert := require.New(ts.T())
+
+	fd := storage.FUOTADeployment{
+		Name:          "test-deployment",
+		State:         storage.FUOTADeploymentCancel,
+		PreviousState: storage.FUOTADeploymentMulticastCreate,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	// the events are only published by Send, after the transaction has
+	// been committed
+	var events Events
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &events))
+	select {
+	case <-ts.integration.SendIntegrationNotificationChan:
+		assert.Fail("unexpected event before Send")
+	default:
+	}
+	events.Send(context.Background(), ts.tx)
+
+	// device state event
+	pl := <-ts.integration.SendIntegrationNotificationChan
+	assert.Equal(uint64(ts.Application.ID), pl.ApplicationId)
+	assert.Equal(ts.Device.DevEUI[:], pl.DevEui)
+	assert.Equal("fuota", pl.IntegrationName)
+	assert.Equal("fuota_deployment_device_state", pl.EventType)
+
+	var deviceEvent DeviceStateEvent
+	assert.NoError(json.Unmarshal([]byte(pl.ObjectJson), &deviceEvent))
+	assert.Equal(DeviceStateEvent{
+		FUOTADeploymentID: fd.ID,
+		Name:              "test-deployment",
+		DevEUI:            ts.Device.DevEUI,
+		State:             storage.FUOTADeploymentDeviceError,
+		ErrorMessage:      "The FUOTA deployment was cancelled.",
+	}, deviceEvent)
+
+	// deployment state event
+	pl = <-ts.integration.SendIntegrationNotificationChan
+	assert.Equal(uint64(ts.Application.ID), pl.ApplicationId)
+	assert.Nil(pl.DevEui)
+	assert.Equal("fuota_deployment_state", pl.EventType)
+
+	var deploymentEvent DeploymentStateEvent
+	assert.NoError(json.Unmarshal([]byte(pl.ObjectJson), &deploymentEvent))
+	assert.Equal(DeploymentStateEvent{
+		FUOTADeploymentID: fd.ID,
+		Name:              "test-deployment",
+		State:             storage.FUOTADeploymentCancelled,
+		PreviousState:     storage.FUOTADeploymentCancel,
+	}, deploymentEvent)
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentPaused() {
+	assert := require.New(ts.T())
+
+	fd := storage.FUOTADeployment{
+		Name:          "test-deployment",
+		State:         storage.FUOTADeploymentPaused,
+		PreviousState: storage.FUOTADeploymentMulticastCreate,
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	assert.NoError(fuotaDeployments(context.Background(), ts.tx, &Events{}))
+
+	fdGet, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+	assert.NoError(err)
+	assert.Equal(storage.FUOTADeploymentPaused, fdGet.State)
+	assert.Nil(fdGet.MulticastGroupID)
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentNextWave() {
+	assert := require.New(ts.T())
+
+	fd := storage.FUOTADeployment{
+		Name:  "test-deployment",
+		State: storage.FUOTADeploymentNextWave,
+		Waves: []int{100},
+	}
+	assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+	fdd, err := storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+	assert.NoError(err)
+	fdd.State = storage.FUOTADeploymentDeviceWaiting
+	assert.NoError(storage.UpdateFUOTADeploymentDevice(context.Background(), ts.tx, &fdd))
+
+	ts.T().Run("Before start-time window", func(t *testing.T) {
+		assert := require.New(t)
+
+		startAfter := time.Now().Add(time.Hour)
+		fd.StartAfter = &startAfter
+		assert.NoError(stepNextWave(context.Background(), ts.tx, fd, &Events{}))
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentNextWave, fdUpdated.State)
+		assert.True(fdUpdated.NextStepAfter.Equal(startAfter))
+		fd.StartAfter = nil
+	})
+
+	ts.T().Run("After start-time window", func(t *testing.T) {
+		assert := require.New(t)
+
+		startBefore := time.Now().Add(-time.Hour)
+		item := fd
+		item.StartBefore = &startBefore
+		assert.NoError(stepNextWave(context.Background(), ts.tx, item, &Events{}))
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentHalted, fdUpdated.State)
+	})
+
+	ts.T().Run("Start wave", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(stepNextWave(context.Background(), ts.tx, fd, &Events{}))
+
+		fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentFragmentationSessSetup, fdUpdated.State)
+
+		fdd, err := storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+		assert.NoError(err)
+		assert.Equal(storage.FUOTADeploymentDevicePending, fdd.State)
+	})
+}
+
+func (ts *FUOTATestSuite) TestFUOTADeploymentCleanupWaveThreshold() {
+	tests := []struct {
+		Name          string
+		DeviceState   storage.FUOTADeploymentDeviceState
+		ExpectedState storage.FUOTADeploymentState
+	}{
+		{
+			Name:          "threshold met",
+			DeviceState:   storage.FUOTADeploymentDeviceSuccess,
+			ExpectedState: storage.FUOTADeploymentNextWave,
+		},
+		{
+			Name:          "threshold breached",
+			DeviceState:   storage.FUOTADeploymentDeviceError,
+			ExpectedState: storage.FUOTADeploymentHalted,
+		},
+	}
+
+	for _, tst := range tests {
+		ts.T().Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			fd := storage.FUOTADeployment{
+				Name:                 "test-deployment",
+				State:                storage.FUOTADeploymentCleanup,
+				Waves:                []int{50, 100},
+				WaveSuccessThreshold: 100,
+			}
+			assert.NoError(storage.CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, ts.Device.DevEUI))
+
+			fdd, err := storage.GetFUOTADeploymentDevice(context.Background(), ts.tx, fd.ID, ts.Device.DevEUI, false)
+			assert.NoError(err)
+			fdd.State = tst.DeviceState
+			assert.NoError(storage.UpdateFUOTADeploymentDevice(context.Background(), ts.tx, &fdd))
+
+			assert.NoError(stepCleanup(context.Background(), ts.tx, fd, storage.FUOTADeploymentDone))
+
+			fdUpdated, err := storage.GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+			assert.NoError(err)
+			assert.Equal(tst.ExpectedState, fdUpdated.State)
+			assert.Equal(1, fdUpdated.CurrentWave)
+		})
+	}
+}
+
+func TestRepairFragments(t *testing.T) {
+	tests := []struct {
+		Name          string
+		FragmentsSent int
+		Devices       []repairStatus
+		Expected      int
+	}{
+		{
+			Name:          "no devices",
+			FragmentsSent: 20,
+			Expected:      0,
+		},
+		{
+			Name:          "corrected for fragment loss",
+			FragmentsSent: 20,
+			Devices: []repairStatus{
+				{NbFragReceived: 15, MissingFrag: 3},
+			},
+			Expected: 4,
+		},
+		{
+			Name:          "max over devices",
+			FragmentsSent: 20,
+			Devices: []repairStatus{
+				{NbFragReceived: 15, MissingFrag: 3},
+				{NbFragReceived: 10, MissingFrag: 5},
+			},
+			Expected: 10,
+		},
+		{
+			Name:          "nothing received",
+			FragmentsSent: 20,
+			Devices: []repairStatus{
+				{NbFragReceived: 0, MissingFrag: 16},
+			},
+			Expected: 16,
+		},
+		{
+			Name:          "limited by max fragments",
+			FragmentsSent: maxFragments - 2,
+			Devices: []repairStatus{
+				{NbFragReceived: 100, MissingFrag: 10},
+			},
+			Expected: 2,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, repairFragments(tst.FragmentsSent, tst.Devices))
+		})
+	}
+}
+
+func TestEncodeFragments(t *testing.T) {
+	tests := []struct {
+		Name                string
+		FragmentationMatrix uint8
+		Redundancy          int
+		Expected            int
+	}{
+		{
+			Name:     "fec encoding",
+			Expected: 3,
+		},
+		{
+			Name:       "fec encoding with redundancy",
+			Redundancy: 2,
+			Expected:   5,
+		},
+		{
+			Name:                "encoding disabled",
+			FragmentationMatrix: 7,
+			Redundancy:          2,
+			Expected:            3,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			item := storage.FUOTADeployment{
+				Payload:             []byte{1, 2, 3, 4, 5},
+				FragSize:            2,
+				FragmentationMatrix: tst.FragmentationMatrix,
+			}
+			fragments, err := encodeFragments(item, tst.Redundancy)
+			assert.NoError(err)
+			assert.Len(fragments, tst.Expected)
+			assert.Equal(3, nbFragments(item))
+			assert.Equal([]byte{5, 0}, fragments[2])
+		})
+	}
+}
+
 func TestFUOTA(t *testing.T) {
 	suite.Run(t, new(FUOTATestSuite))
 }
@@ -0,0 +1,124 @@
+package fuota
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/jacobsa/crypto/cmac"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/applayer/packageversion"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+// setDataBlockIntegrity sets the SessionCnt and the MIC of the data block
+// of the given (fragmentation package v2) fragmentation session. The
+// SessionCnt is incremented for every fragmentation session of the device.
+func setDataBlockIntegrity(ctx context.Context, db sqlx.Ext, fs *storage.RemoteFragmentationSession, data []byte) error {
+	dal, err := packageversion.GetForUpdate(ctx, db, fs.DevEUI)
+	if err != nil {
+		return err
+	}
+
+	dal.FragmentationSessionCnt = (dal.FragmentationSessionCnt + 1) % (1 << 16)
+	if err := storage.UpdateDeviceApplicationLayer(ctx, db, &dal); err != nil {
+		return errors.Wrap(err, "update device application-layer error")
+	}
+
+	key, err := getDataBlockIntKey(ctx, db, fs.DevEUI)
+	if err != nil {
+		return err
+	}
+
+	fs.SessionCnt = dal.FragmentationSessionCnt
+	fs.MIC, err = dataBlockMIC(key, fs.SessionCnt, fs.FragIndex, fs.Descriptor, data)
+	if err != nil {
+		return errors.Wrap(err, "get data block mic error")
+	}
+
+	return nil
+}
+
+// getDataBlockIntKey returns the DataBlockIntKey of the given device. As for
+// the McRootKey, it is derived from the AppKey for LoRaWAN 1.1 devices and
+// from the GenAppKey for LoRaWAN 1.0.x devices.
+func getDataBlockIntKey(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (lorawan.AES128Key, error) {
+	var nullKey lorawan.AES128Key
+
+	dk, err := storage.GetDeviceKeys(ctx, db, devEUI)
+	if err != nil {
+		return nullKey, errors.Wrap(err, "get device-keys error")
+	}
+
+	d, err := storage.GetDevice(ctx, db, devEUI, false, true)
+	if err != nil {
+		return nullKey, errors.Wrap(err, "get device error")
+	}
+
+	dp, err := storage.GetDeviceProfile(ctx, db, d.DeviceProfileID, false, false)
+	if err != nil {
+		return nullKey, errors.Wrap(err, "get device profile error")
+	}
+
+	devMacVersion, err := strconv.Atoi(strings.ReplaceAll(dp.DeviceProfile.MacVersion, ".", ""))
+	if err != nil {
+		return nullKey, errors.Wrap(err, "get device mac version error")
+	}
+
+	rootKey := dk.GenAppKey
+	if dk.AppKey != nullKey && devMacVersion >= 110 {
+		rootKey = dk.AppKey
+	}
+
+	return dataBlockIntKey(rootKey)
+}
+
+// dataBlockIntKey returns aes128_encrypt(rootKey, 0x30 | pad16).
+func dataBlockIntKey(rootKey lorawan.AES128Key) (lorawan.AES128Key, error) {
+	var key lorawan.AES128Key
+	b := [16]byte{0x30}
+
+	block, err := aes.NewCipher(rootKey[:])
+	if err != nil {
+		return key, errors.Wrap(err, "new cipher error")
+	}
+	block.Encrypt(key[:], b[:])
+
+	return key, nil
+}
+
+// dataBlockMIC returns the MIC of the data block:
+// aes128_cmac(DataBlockIntKey, B0 | data)[0:4], with
+// B0 = 0x49 | 4 x 0x00 | SessionCnt (2) | FragIndex (1) | Descriptor (4) |
+// data length (4).
+func dataBlockMIC(key lorawan.AES128Key, sessionCnt, fragIndex int, descriptor [4]byte, data []byte) ([4]byte, error) {
+	var mic [4]byte
+
+	b0 := make([]byte, 16)
+	b0[0] = 0x49
+	binary.LittleEndian.PutUint16(b0[5:7], uint16(sessionCnt))
+	b0[7] = uint8(fragIndex)
+	copy(b0[8:12], descriptor[:])
+	binary.LittleEndian.PutUint32(b0[12:16], uint32(len(data)))
+
+	hash, err := cmac.New(key[:])
+	if err != nil {
+		return mic, errors.Wrap(err, "new cmac error")
+	}
+
+	if _, err := hash.Write(b0); err != nil {
+		return mic, errors.Wrap(err, "write b0 error")
+	}
+	if _, err := hash.Write(data); err != nil {
+		return mic, errors.Wrap(err, "write data error")
+	}
+
+	copy(mic[:], hash.Sum(nil))
+
+	return mic, nil
+}
@@ -110,5 +110,5 @@ func (i *Integration) log(ctx context.Context, typ string, devEUI lorawan.EUI64,
 		"ctx_id":  ctx.Value(logging.ContextIDKey),
 	}).Info("integration/logger: logging event")
 
-	return eventlog.LogEventForDevice(devEUI, typ, msg)
+	return eventlog.LogEventForDeviceWithMetadata(devEUI, typ, eventlog.MetadataFromContext(ctx), msg)
 }
@@ -0,0 +1,237 @@
+// Package jwtkeys implements the asymmetric (RS256 / ES256) signing and
+// validation of the API tokens and the publishing of the public-keys as
+// JSON Web Key Set (JWKS).
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+// Errors
+var (
+	ErrUnexpectedSigningMethod = errors.New("unexpected signing method")
+	ErrUnknownKeyID            = errors.New("unknown key id")
+)
+
+// Key defines a JWT key. The private-key is only set for the signing key.
+type Key struct {
+	ID         string
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+var (
+	method     jwt.SigningMethod
+	signingKey *Key
+	keys       []Key
+)
+
+// Setup configures the package.
+func Setup(conf config.Config) error {
+	extConf := conf.ApplicationServer.ExternalAPI
+
+	method = nil
+	signingKey = nil
+	keys = nil
+
+	switch extConf.JWTAlgorithm {
+	case "", "HS256":
+		return nil
+	case "RS256":
+		method = jwt.SigningMethodRS256
+	case "ES256":
+		method = jwt.SigningMethodES256
+	default:
+		return errors.Errorf("unsupported jwt_algorithm: %s", extConf.JWTAlgorithm)
+	}
+
+	if extConf.JWTPrivateKeyFile == "" {
+		return errors.Errorf("jwt_private_key_file must be set when jwt_algorithm is %s", extConf.JWTAlgorithm)
+	}
+
+	b, err := ioutil.ReadFile(extConf.JWTPrivateKeyFile)
+	if err != nil {
+		return errors.Wrap(err, "read jwt private-key error")
+	}
+
+	priv, err := ParsePrivateKey(method, b)
+	if err != nil {
+		return errors.Wrap(err, "parse jwt private-key error")
+	}
+
+	key, err := newKey(priv, priv.Public())
+	if err != nil {
+		return err
+	}
+	keys = append(keys, key)
+	signingKey = &keys[0]
+
+	for _, f := range extConf.JWTPublicKeyFiles {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return errors.Wrap(err, "read jwt public-key error")
+		}
+
+		pub, err := ParsePublicKey(method, b)
+		if err != nil {
+			return errors.Wrapf(err, "parse jwt public-key %s error", f)
+		}
+
+		key, err := newKey(nil, pub)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	log.WithFields(log.Fields{
+		"algorithm": method.Alg(),
+		"kid":       signingKey.ID,
+		"keys":      len(keys),
+	}).Info("jwtkeys: asymmetric jwt signing configured")
+
+	return nil
+}
+
+// Enabled returns true when asymmetric signing is configured.
+func Enabled() bool {
+	return signingKey != nil
+}
+
+// Sign signs the given claims using the signing key. The key ID is set as
+// kid header.
+func Sign(claims jwt.Claims) (string, error) {
+	if signingKey == nil {
+		return "", errors.New("asymmetric jwt signing is not configured")
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = signingKey.ID
+
+	return token.SignedString(signingKey.PrivateKey)
+}
+
+// Keyfunc returns the public-key for validating the given token, based on
+// its kid header. When the token does not have a kid header, the signing
+// key is used.
+func Keyfunc(token *jwt.Token) (interface{}, error) {
+	if method == nil || token.Method.Alg() != method.Alg() {
+		return nil, ErrUnexpectedSigningMethod
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return signingKey.PublicKey, nil
+	}
+
+	for _, k := range keys {
+		if k.ID == kid {
+			return k.PublicKey, nil
+		}
+	}
+
+	return nil, ErrUnknownKeyID
+}
+
+// JWKS returns the public-keys as JSON Web Key Set.
+func JWKS() jose.JSONWebKeySet {
+	set := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{},
+	}
+
+	for _, k := range keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey{
+			Key:       k.PublicKey,
+			KeyID:     k.ID,
+			Algorithm: method.Alg(),
+			Use:       "sig",
+		})
+	}
+
+	return set
+}
+
+// JWKSHandler serves the JSON Web Key Set.
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(JWKS()); err != nil {
+		log.WithError(err).Error("jwtkeys: encode jwks error")
+	}
+}
+
+// ParsePrivateKey parses the given PEM encoded private-key for the given
+// signing method.
+func ParsePrivateKey(m jwt.SigningMethod, b []byte) (crypto.Signer, error) {
+	switch m {
+	case jwt.SigningMethodRS256:
+		return jwt.ParseRSAPrivateKeyFromPEM(b)
+	case jwt.SigningMethodES256:
+		key, err := jwt.ParseECPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		return key, nil
+	default:
+		return nil, ErrUnexpectedSigningMethod
+	}
+}
+
+// ParsePublicKey parses the given PEM encoded public-key for the given
+// signing method.
+func ParsePublicKey(m jwt.SigningMethod, b []byte) (crypto.PublicKey, error) {
+	switch m {
+	case jwt.SigningMethodRS256:
+		return jwt.ParseRSAPublicKeyFromPEM(b)
+	case jwt.SigningMethodES256:
+		key, err := jwt.ParseECPublicKeyFromPEM(b)
+		if err != nil {
+			return nil, err
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		return key, nil
+	default:
+		return nil, ErrUnexpectedSigningMethod
+	}
+}
+
+// newKey returns a new Key, using the JWK thumbprint (RFC 7638) of the
+// public-key as key ID.
+func newKey(priv crypto.Signer, pub crypto.PublicKey) (Key, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return Key{}, fmt.Errorf("unsupported public-key type: %T", pub)
+	}
+
+	jwk := jose.JSONWebKey{Key: pub}
+	thumb, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return Key{}, errors.Wrap(err, "jwk thumbprint error")
+	}
+
+	return Key{
+		ID:         base64.RawURLEncoding.EncodeToString(thumb),
+		PrivateKey: priv,
+		PublicKey:  pub,
+	}, nil
+}
@@ -0,0 +1,193 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+	jose "gopkg.in/square/go-jose.v2"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+)
+
+func writePrivateKey(t *testing.T, dir, name string, key crypto.Signer) string {
+	var block pem.Block
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		b, err := x509.MarshalECPrivateKey(k)
+		require.NoError(t, err)
+		block = pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	}
+
+	p := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(p, pem.EncodeToMemory(&block), 0600))
+	return p
+}
+
+func writePublicKey(t *testing.T, dir, name string, key crypto.PublicKey) string {
+	b, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+
+	p := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}), 0600))
+	return p
+}
+
+func TestJWTKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaKeyOld, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecKeyOld, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecKeyP384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		algorithm  string
+		privateKey crypto.Signer
+		oldKey     crypto.Signer
+	}{
+		{
+			name:       "RS256",
+			algorithm:  "RS256",
+			privateKey: rsaKey,
+			oldKey:     rsaKeyOld,
+		},
+		{
+			name:       "ES256",
+			algorithm:  "ES256",
+			privateKey: ecKey,
+			oldKey:     ecKeyOld,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			// sign a token using the old key
+			var conf config.Config
+			conf.ApplicationServer.ExternalAPI.JWTAlgorithm = tst.algorithm
+			conf.ApplicationServer.ExternalAPI.JWTPrivateKeyFile = writePrivateKey(t, dir, tst.name+"-old.pem", tst.oldKey)
+			assert.NoError(Setup(conf))
+			assert.True(Enabled())
+			oldKID := signingKey.ID
+
+			oldToken, err := Sign(jwt.StandardClaims{Subject: "user"})
+			assert.NoError(err)
+
+			// rotate the signing key, keeping the old public-key
+			conf.ApplicationServer.ExternalAPI.JWTPrivateKeyFile = writePrivateKey(t, dir, tst.name+".pem", tst.privateKey)
+			conf.ApplicationServer.ExternalAPI.JWTPublicKeyFiles = []string{writePublicKey(t, dir, tst.name+"-old.pub", tst.oldKey.Public())}
+			assert.NoError(Setup(conf))
+			assert.NotEqual(oldKID, signingKey.ID)
+
+			newToken, err := Sign(jwt.StandardClaims{Subject: "user"})
+			assert.NoError(err)
+
+			t.Run("Validate tokens", func(t *testing.T) {
+				assert := require.New(t)
+
+				for _, tokenStr := range []string{oldToken, newToken} {
+					token, err := jwt.Parse(tokenStr, Keyfunc)
+					assert.NoError(err)
+					assert.True(token.Valid)
+				}
+			})
+
+			t.Run("Unknown kid", func(t *testing.T) {
+				assert := require.New(t)
+
+				conf.ApplicationServer.ExternalAPI.JWTPublicKeyFiles = nil
+				assert.NoError(Setup(conf))
+
+				_, err := jwt.Parse(oldToken, Keyfunc)
+				assert.Error(err)
+				assert.Equal(ErrUnknownKeyID, err.(*jwt.ValidationError).Inner)
+
+				_, err = jwt.Parse(newToken, Keyfunc)
+				assert.NoError(err)
+			})
+
+			t.Run("HS256 token", func(t *testing.T) {
+				assert := require.New(t)
+
+				tokenStr, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "user"}).SignedString([]byte("secret"))
+				assert.NoError(err)
+
+				_, err = jwt.Parse(tokenStr, Keyfunc)
+				assert.Error(err)
+				assert.Equal(ErrUnexpectedSigningMethod, err.(*jwt.ValidationError).Inner)
+			})
+
+			t.Run("JWKS", func(t *testing.T) {
+				assert := require.New(t)
+
+				conf.ApplicationServer.ExternalAPI.JWTPublicKeyFiles = []string{writePublicKey(t, dir, tst.name+"-old.pub", tst.oldKey.Public())}
+				assert.NoError(Setup(conf))
+
+				w := httptest.NewRecorder()
+				JWKSHandler(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+				assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+				var set jose.JSONWebKeySet
+				assert.NoError(json.Unmarshal(w.Body.Bytes(), &set))
+				assert.Len(set.Keys, 2)
+				assert.Equal(signingKey.ID, set.Keys[0].KeyID)
+				assert.Equal(oldKID, set.Keys[1].KeyID)
+
+				for _, k := range set.Keys {
+					assert.Equal(tst.algorithm, k.Algorithm)
+					assert.Equal("sig", k.Use)
+					assert.True(k.IsPublic())
+				}
+			})
+		})
+	}
+
+	t.Run("HS256", func(t *testing.T) {
+		assert := require.New(t)
+
+		var conf config.Config
+		conf.ApplicationServer.ExternalAPI.JWTAlgorithm = "HS256"
+		assert.NoError(Setup(conf))
+		assert.False(Enabled())
+		assert.Len(JWKS().Keys, 0)
+	})
+
+	t.Run("ES256 with P-384 key", func(t *testing.T) {
+		var conf config.Config
+		conf.ApplicationServer.ExternalAPI.JWTAlgorithm = "ES256"
+		conf.ApplicationServer.ExternalAPI.JWTPrivateKeyFile = writePrivateKey(t, dir, "p384.pem", ecKeyP384)
+		require.Error(t, Setup(conf))
+	})
+
+	t.Run("Missing private-key", func(t *testing.T) {
+		var conf config.Config
+		conf.ApplicationServer.ExternalAPI.JWTAlgorithm = "RS256"
+		require.Error(t, Setup(conf))
+	})
+}
@@ -0,0 +1,190 @@
+// Package loginlimit implements the rate limiting of failed login attempts
+// and password reset requests per e-mail address and per client IP address.
+// The attempts are counted in Redis, so that the limits apply across
+// multiple instances.
+package loginlimit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+)
+
+const (
+	emailKeyTempl = "lora:as:login:email:%s:failed"
+	ipKeyTempl    = "lora:as:login:ip:%s:failed"
+
+	resetEmailKeyTempl = "lora:as:password-reset:email:%s"
+	resetIPKeyTempl    = "lora:as:password-reset:ip:%s"
+)
+
+// ErrLocked is returned when the e-mail address or the client IP address
+// is (temporarily) locked because of too many failed login attempts.
+var ErrLocked = errors.New("too many failed login attempts, try again later")
+
+var (
+	maxPerEmail       int
+	maxPerIP          int
+	maxResetsPerEmail int
+	maxResetsPerIP    int
+	window            time.Duration
+	lockout           time.Duration
+)
+
+// Setup configures the package.
+func Setup(conf config.Config) error {
+	c := conf.ApplicationServer.UserAuthentication.LoginRateLimit
+
+	maxPerEmail = c.MaxFailedAttemptsPerEmail
+	maxPerIP = c.MaxFailedAttemptsPerIP
+	window = c.Window
+	lockout = c.LockoutDuration
+	maxResetsPerEmail = c.MaxPasswordResetsPerEmail
+	maxResetsPerIP = c.MaxPasswordResetsPerIP
+
+	if (maxPerEmail > 0 || maxPerIP > 0) && (window <= 0 || lockout <= 0) {
+		return errors.New("login_rate_limit window and lockout_duration must be greater than 0")
+	}
+
+	if (maxResetsPerEmail > 0 || maxResetsPerIP > 0) && window <= 0 {
+		return errors.New("login_rate_limit window must be greater than 0")
+	}
+
+	return nil
+}
+
+// Check returns ErrLocked when the given e-mail address or client IP
+// address is locked. The IP address is ignored when empty.
+func Check(email, ip string) error {
+	for _, k := range keys(email, ip) {
+		count, err := storage.RedisClient().Get(k.key).Int()
+		if err != nil {
+			if err == redis.Nil {
+				continue
+			}
+			return errors.Wrap(err, "get failed login count error")
+		}
+
+		if count >= k.max {
+			return ErrLocked
+		}
+	}
+
+	return nil
+}
+
+// RegisterFailure registers a failed login attempt for the given e-mail
+// address and client IP address. It returns true when this attempt locked
+// the e-mail address or IP address.
+func RegisterFailure(email, ip string) (bool, error) {
+	var locked bool
+
+	for _, k := range keys(email, ip) {
+		count, err := storage.RedisClient().Incr(k.key).Result()
+		if err != nil {
+			return false, errors.Wrap(err, "increment failed login count error")
+		}
+
+		var exp time.Duration
+		switch {
+		case int(count) == k.max:
+			// the max. has been reached, start the lockout
+			exp = lockout
+			locked = true
+		case count == 1:
+			// the key was created by this increment, start the window
+			exp = window
+		default:
+			continue
+		}
+
+		if err := storage.RedisClient().PExpire(k.key, exp).Err(); err != nil {
+			return false, errors.Wrap(err, "set failed login count expire error")
+		}
+	}
+
+	return locked, nil
+}
+
+// Reset resets the failed login attempts of the given e-mail address. This
+// must be called on successful login.
+func Reset(email string) error {
+	if maxPerEmail <= 0 {
+		return nil
+	}
+
+	if err := storage.RedisClient().Del(emailKey(email)).Err(); err != nil {
+		return errors.Wrap(err, "delete failed login count error")
+	}
+
+	return nil
+}
+
+// RegisterPasswordReset registers a password reset request for the given
+// e-mail address and client IP address. It returns false when the max.
+// number of password reset requests within the window has been exceeded,
+// in which case no password reset e-mail must be sent.
+func RegisterPasswordReset(email, ip string) (bool, error) {
+	var out []limitKey
+	if maxResetsPerEmail > 0 {
+		out = append(out, limitKey{key: fmt.Sprintf(resetEmailKeyTempl, normalizeEmail(email)), max: maxResetsPerEmail})
+	}
+	if maxResetsPerIP > 0 && ip != "" {
+		out = append(out, limitKey{key: fmt.Sprintf(resetIPKeyTempl, ip), max: maxResetsPerIP})
+	}
+
+	allowed := true
+
+	for _, k := range out {
+		count, err := storage.RedisClient().Incr(k.key).Result()
+		if err != nil {
+			return false, errors.Wrap(err, "increment password reset count error")
+		}
+
+		if count == 1 {
+			// the key was created by this increment, start the window
+			if err := storage.RedisClient().PExpire(k.key, window).Err(); err != nil {
+				return false, errors.Wrap(err, "set password reset count expire error")
+			}
+		}
+
+		if int(count) > k.max {
+			allowed = false
+		}
+	}
+
+	return allowed, nil
+}
+
+type limitKey struct {
+	key string
+	max int
+}
+
+func keys(email, ip string) []limitKey {
+	var out []limitKey
+
+	if maxPerEmail > 0 {
+		out = append(out, limitKey{key: emailKey(email), max: maxPerEmail})
+	}
+
+	if maxPerIP > 0 && ip != "" {
+		out = append(out, limitKey{key: fmt.Sprintf(ipKeyTempl, ip), max: maxPerIP})
+	}
+
+	return out
+}
+
+func emailKey(email string) string {
+	return fmt.Sprintf(emailKeyTempl, normalizeEmail(email))
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
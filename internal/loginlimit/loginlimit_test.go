@@ -0,0 +1,120 @@
+package loginlimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/storage"
+	"github.com/gyh1621/chirpstack-application-server/internal/test"
+)
+
+func TestLoginLimit(t *testing.T) {
+	assert := require.New(t)
+
+	conf := test.GetConfig()
+	assert.NoError(storage.Setup(conf))
+
+	conf.ApplicationServer.UserAuthentication.LoginRateLimit.MaxFailedAttemptsPerEmail = 3
+	conf.ApplicationServer.UserAuthentication.LoginRateLimit.MaxFailedAttemptsPerIP = 5
+	conf.ApplicationServer.UserAuthentication.LoginRateLimit.Window = time.Minute
+	conf.ApplicationServer.UserAuthentication.LoginRateLimit.LockoutDuration = time.Minute
+	conf.ApplicationServer.UserAuthentication.LoginRateLimit.MaxPasswordResetsPerEmail = 2
+	conf.ApplicationServer.UserAuthentication.LoginRateLimit.MaxPasswordResetsPerIP = 5
+	assert.NoError(Setup(conf))
+
+	t.Run("Lock e-mail", func(t *testing.T) {
+		assert := require.New(t)
+		storage.RedisClient().FlushAll()
+
+		for i := 0; i < 2; i++ {
+			assert.NoError(Check("user@example.com", "192.168.1.1"))
+			locked, err := RegisterFailure("user@example.com", "192.168.1.1")
+			assert.NoError(err)
+			assert.False(locked)
+		}
+
+		locked, err := RegisterFailure("User@Example.com", "192.168.1.1")
+		assert.NoError(err)
+		assert.True(locked)
+
+		assert.Equal(ErrLocked, Check("user@example.com", "192.168.1.2"))
+		assert.NoError(Check("other@example.com", "192.168.1.2"))
+
+		ttl, err := storage.RedisClient().PTTL(emailKey("user@example.com")).Result()
+		assert.NoError(err)
+		assert.True(ttl > 0 && ttl <= time.Minute)
+
+		t.Run("Reset", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(Reset("user@example.com"))
+			assert.NoError(Check("user@example.com", "192.168.1.2"))
+		})
+	})
+
+	t.Run("Lock IP", func(t *testing.T) {
+		assert := require.New(t)
+		storage.RedisClient().FlushAll()
+
+		for _, email := range []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"} {
+			_, err := RegisterFailure(email, "192.168.1.1")
+			assert.NoError(err)
+		}
+
+		assert.Equal(ErrLocked, Check("f@example.com", "192.168.1.1"))
+		assert.NoError(Check("f@example.com", "192.168.1.2"))
+	})
+
+	t.Run("Password reset", func(t *testing.T) {
+		assert := require.New(t)
+		storage.RedisClient().FlushAll()
+
+		for i := 0; i < 2; i++ {
+			allowed, err := RegisterPasswordReset("user@example.com", "192.168.1.1")
+			assert.NoError(err)
+			assert.True(allowed)
+		}
+
+		allowed, err := RegisterPasswordReset("User@Example.com", "192.168.1.2")
+		assert.NoError(err)
+		assert.False(allowed)
+
+		allowed, err = RegisterPasswordReset("other@example.com", "192.168.1.1")
+		assert.NoError(err)
+		assert.True(allowed)
+
+		for _, email := range []string{"a@example.com", "b@example.com"} {
+			_, err := RegisterPasswordReset(email, "192.168.1.1")
+			assert.NoError(err)
+		}
+
+		allowed, err = RegisterPasswordReset("c@example.com", "192.168.1.1")
+		assert.NoError(err)
+		assert.False(allowed)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert := require.New(t)
+		storage.RedisClient().FlushAll()
+
+		assert.NoError(Setup(test.GetConfig()))
+		defer Setup(conf)
+
+		for i := 0; i < 25; i++ {
+			_, err := RegisterFailure("user@example.com", "192.168.1.1")
+			assert.NoError(err)
+		}
+		assert.NoError(Check("user@example.com", "192.168.1.1"))
+	})
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		assert := require.New(t)
+
+		c := test.GetConfig()
+		c.ApplicationServer.UserAuthentication.LoginRateLimit.MaxFailedAttemptsPerEmail = 5
+		assert.Error(Setup(c))
+		assert.NoError(Setup(conf))
+	})
+}
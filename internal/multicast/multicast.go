@@ -125,3 +125,25 @@ func ListQueue(ctx context.Context, db sqlx.Ext, multicastGroupID uuid.UUID) ([]
 
 	return out, nil
 }
+
+// FlushQueue removes all items from the multicast-group queue.
+func FlushQueue(ctx context.Context, db sqlx.Ext, multicastGroupID uuid.UUID) error {
+	n, err := storage.GetNetworkServerForMulticastGroupID(ctx, db, multicastGroupID)
+	if err != nil {
+		return errors.Wrap(err, "get network-server for multicast-group error")
+	}
+
+	nsClient, err := networkserver.GetPool().Get(n.Server, []byte(n.CACert), []byte(n.TLSCert), []byte(n.TLSKey))
+	if err != nil {
+		return errors.Wrap(err, "get network-server client error")
+	}
+
+	_, err = nsClient.FlushMulticastQueueForMulticastGroup(ctx, &ns.FlushMulticastQueueForMulticastGroupRequest{
+		MulticastGroupId: multicastGroupID.Bytes(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "flush multicast queue-items error")
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
@@ -22,8 +23,29 @@ type APIKey struct {
 	IsAdmin        bool      `db:"is_admin"`
 	OrganizationID *int64    `db:"organization_id"`
 	ApplicationID  *int64    `db:"application_id"`
+
+	// ExpiresAt defines the time after which the API key is no longer
+	// valid. When nil, the API key does not expire.
+	ExpiresAt *time.Time `db:"expires_at"`
+
+	// Scopes restricts the API key to the given scopes. When empty, the
+	// API key is not restricted.
+	Scopes pq.StringArray `db:"scopes"`
+
+	// LastUsedAt contains the (approximate) time the API key was last used.
+	LastUsedAt *time.Time `db:"last_used_at"`
+
+	// TokenID contains the ID (jti) of the current token. The previous
+	// token remains valid until PreviousTokenExpiresAt after a rotation.
+	TokenID                uuid.UUID  `db:"token_id"`
+	PreviousTokenID        *uuid.UUID `db:"previous_token_id"`
+	PreviousTokenExpiresAt *time.Time `db:"previous_token_expires_at"`
 }
 
+// apiKeyLastUsedInterval defines the interval in which the last used
+// timestamp of an API key is updated, to avoid a write on every request.
+const apiKeyLastUsedInterval = time.Minute
+
 // CreateAPIKey creates the given API key and returns the JWT.
 func CreateAPIKey(ctx context.Context, db sqlx.Ext, a *APIKey) (string, error) {
 	id, err := uuid.NewV4()
@@ -31,8 +53,16 @@ func CreateAPIKey(ctx context.Context, db sqlx.Ext, a *APIKey) (string, error) {
 		return "", errors.Wrap(err, "new uuid error")
 	}
 
+	a.TokenID, err = uuid.NewV4()
+	if err != nil {
+		return "", errors.Wrap(err, "new uuid error")
+	}
+
 	a.ID = id
 	a.CreatedAt = time.Now()
+	if a.Scopes == nil {
+		a.Scopes = pq.StringArray{}
+	}
 
 	_, err = db.Exec(`
 		insert into api_key (
@@ -41,14 +71,20 @@ func CreateAPIKey(ctx context.Context, db sqlx.Ext, a *APIKey) (string, error) {
 			name,
 			is_admin,
 			organization_id,
-			application_id
-		) values ($1, $2, $3, $4, $5, $6)`,
+			application_id,
+			expires_at,
+			scopes,
+			token_id
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 		a.ID,
 		a.CreatedAt,
 		a.Name,
 		a.IsAdmin,
 		a.OrganizationID,
 		a.ApplicationID,
+		a.ExpiresAt,
+		a.Scopes,
+		a.TokenID,
 	)
 	if err != nil {
 		return "", handlePSQLError(Insert, err, "insert error")
@@ -59,15 +95,21 @@ func CreateAPIKey(ctx context.Context, db sqlx.Ext, a *APIKey) (string, error) {
 		"id":     a.ID,
 	}).Info("storage: api-key created")
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return apiKeyToken(*a)
+}
+
+// apiKeyToken returns the JWT for the current token ID of the given API key.
+// The expiration is not part of the token as it is validated against the
+// database, so that it can be updated.
+func apiKeyToken(a APIKey) (string, error) {
+	jwt, err := signToken(jwt.MapClaims{
 		"iss":        "as",
 		"aud":        "as",
 		"nbf":        time.Now().Unix(),
 		"sub":        "api_key",
+		"jti":        a.TokenID.String(),
 		"api_key_id": a.ID.String(),
 	})
-
-	jwt, err := token.SignedString(jwtsecret)
 	if err != nil {
 		return jwt, errors.Wrap(err, "sign jwt token error")
 	}
@@ -95,6 +137,116 @@ func GetAPIKey(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (APIKey, erro
 	return a, nil
 }
 
+// UpdateAPIKey updates the name, expiration and scopes of the given API key.
+func UpdateAPIKey(ctx context.Context, db sqlx.Execer, a *APIKey) error {
+	if a.Scopes == nil {
+		a.Scopes = pq.StringArray{}
+	}
+
+	res, err := db.Exec(`
+		update api_key
+		set
+			name = $2,
+			expires_at = $3,
+			scopes = $4
+		where
+			id = $1`,
+		a.ID,
+		a.Name,
+		a.ExpiresAt,
+		a.Scopes,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+		"id":     a.ID,
+	}).Info("storage: api-key updated")
+
+	return nil
+}
+
+// RotateAPIKey issues a new token for the given API key and returns the JWT.
+// The current token remains valid for the given grace period.
+func RotateAPIKey(ctx context.Context, db sqlx.Execer, a *APIKey, gracePeriod time.Duration) (string, error) {
+	tokenID, err := uuid.NewV4()
+	if err != nil {
+		return "", errors.Wrap(err, "new uuid error")
+	}
+
+	prevTokenID := a.TokenID
+	prevExpiresAt := time.Now().Add(gracePeriod)
+
+	res, err := db.Exec(`
+		update api_key
+		set
+			token_id = $2,
+			previous_token_id = $3,
+			previous_token_expires_at = $4
+		where
+			id = $1`,
+		a.ID,
+		tokenID,
+		prevTokenID,
+		prevExpiresAt,
+	)
+	if err != nil {
+		return "", handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return "", errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return "", ErrDoesNotExist
+	}
+
+	a.TokenID = tokenID
+	a.PreviousTokenID = &prevTokenID
+	a.PreviousTokenExpiresAt = &prevExpiresAt
+
+	log.WithFields(log.Fields{
+		"ctx_id":       ctx.Value(logging.ContextIDKey),
+		"id":           a.ID,
+		"grace_period": gracePeriod,
+	}).Info("storage: api-key rotated")
+
+	return apiKeyToken(*a)
+}
+
+// UpdateAPIKeyLastUsed sets the last used timestamp of the given API key.
+// To avoid a write on every request, this is only updated when the
+// previous timestamp is older than apiKeyLastUsedInterval.
+func UpdateAPIKeyLastUsed(ctx context.Context, db sqlx.Execer, id uuid.UUID) error {
+	now := time.Now()
+
+	_, err := db.Exec(`
+		update api_key
+		set
+			last_used_at = $2
+		where
+			id = $1
+			and (last_used_at is null or last_used_at < $3)`,
+		id,
+		now,
+		now.Add(-apiKeyLastUsedInterval),
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	return nil
+}
+
 // DeleteAPIKey deletes the API key for the given ID.
 func DeleteAPIKey(ctx context.Context, db sqlx.Ext, id uuid.UUID) error {
 	res, err := db.Exec(`
@@ -69,6 +69,7 @@ func (ts *StorageTestSuite) TestAPIKey() {
 
 		assert.Equal("api_key", claims["sub"])
 		assert.Equal(apiKey.ID.String(), claims["api_key_id"])
+		assert.Equal(apiKey.TokenID.String(), claims["jti"])
 
 		t.Run("Get", func(t *testing.T) {
 			assert := require.New(t)
@@ -80,6 +81,64 @@ func (ts *StorageTestSuite) TestAPIKey() {
 			assert.Equal(apiKey, res)
 		})
 
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+			apiKey.Name = "read-only token"
+			apiKey.ExpiresAt = &expiresAt
+			apiKey.Scopes = []string{"read", "gateway-read"}
+			assert.NoError(UpdateAPIKey(context.Background(), ts.tx, &apiKey))
+
+			res, err := GetAPIKey(context.Background(), ts.tx, apiKey.ID)
+			assert.NoError(err)
+			res.CreatedAt = res.CreatedAt.UTC().Truncate(time.Millisecond)
+			exp := res.ExpiresAt.UTC().Truncate(time.Millisecond)
+			res.ExpiresAt = &exp
+
+			assert.Equal(apiKey, res)
+		})
+
+		t.Run("Rotate", func(t *testing.T) {
+			assert := require.New(t)
+
+			prevTokenID := apiKey.TokenID
+			str, err := RotateAPIKey(context.Background(), ts.tx, &apiKey, time.Hour)
+			assert.NoError(err)
+			assert.NotEqual(prevTokenID, apiKey.TokenID)
+
+			var claims jwt.MapClaims
+			_, err = jwt.ParseWithClaims(str, &claims, func(token *jwt.Token) (interface{}, error) {
+				return jwtsecret, nil
+			})
+			assert.NoError(err)
+			assert.Equal(apiKey.TokenID.String(), claims["jti"])
+			assert.Equal(apiKey.ID.String(), claims["api_key_id"])
+
+			res, err := GetAPIKey(context.Background(), ts.tx, apiKey.ID)
+			assert.NoError(err)
+			assert.Equal(apiKey.TokenID, res.TokenID)
+			assert.Equal(&prevTokenID, res.PreviousTokenID)
+			assert.NotNil(res.PreviousTokenExpiresAt)
+			assert.True(res.PreviousTokenExpiresAt.After(time.Now().Add(59 * time.Minute)))
+		})
+
+		t.Run("Update last used", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateAPIKeyLastUsed(context.Background(), ts.tx, apiKey.ID))
+			res, err := GetAPIKey(context.Background(), ts.tx, apiKey.ID)
+			assert.NoError(err)
+			assert.NotNil(res.LastUsedAt)
+			lastUsedAt := *res.LastUsedAt
+
+			// within the interval, the timestamp is not updated
+			assert.NoError(UpdateAPIKeyLastUsed(context.Background(), ts.tx, apiKey.ID))
+			res, err = GetAPIKey(context.Background(), ts.tx, apiKey.ID)
+			assert.NoError(err)
+			assert.True(lastUsedAt.Equal(*res.LastUsedAt))
+		})
+
 		t.Run("Delete", func(t *testing.T) {
 			assert := require.New(t)
 
@@ -27,6 +27,7 @@ type Application struct {
 	PayloadCodec         codec.Type `db:"payload_codec"`
 	PayloadEncoderScript string     `db:"payload_encoder_script"`
 	PayloadDecoderScript string     `db:"payload_decoder_script"`
+	PayloadCodecRevision int        `db:"payload_codec_revision"`
 }
 
 // ApplicationListItem devices the application as a list item.
@@ -94,6 +95,8 @@ func GetApplication(ctx context.Context, db sqlx.Queryer, id int64) (Application
 
 // ApplicationFilters provides filters for filtering applications.
 type ApplicationFilters struct {
+	// UserID filters on the applications to which the user has access
+	// as organization user (see ApplicationUser).
 	UserID         int64  `db:"user_id"`
 	OrganizationID int64  `db:"organization_id"`
 	Search         string `db:"search"`
@@ -109,7 +112,7 @@ func (f ApplicationFilters) SQL() string {
 	var filters []string
 
 	if f.UserID != 0 {
-		filters = append(filters, "u.id = :user_id")
+		filters = append(filters, "u.id = :user_id", applicationScopeSQL)
 	}
 
 	if f.OrganizationID != 0 {
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// applicationScopeSQL matches the applications (a) to which the
+// organization user (ou) has access. Organization users which are not an
+// organization admin and which have a role for one or more applications of
+// the organization only have access to these applications.
+const applicationScopeSQL = `(
+	(ou.role_id is null and ou.is_admin = true)
+	or exists (
+		select 1
+		from application_user au
+		where
+			au.user_id = ou.user_id
+			and au.application_id = a.id)
+	or not exists (
+		select 1
+		from application_user au
+		inner join application aua
+			on aua.id = au.application_id
+		where
+			au.user_id = ou.user_id
+			and aua.organization_id = ou.organization_id))`
+
+// ApplicationUser represents a role assigned to an user for a single
+// application. The user must be a member of the organization of the
+// application. Once an user has a role for one or more applications, the
+// access of the user is restricted to these applications.
+type ApplicationUser struct {
+	ApplicationID int64     `db:"application_id"`
+	UserID        int64     `db:"user_id"`
+	Email         string    `db:"email"`
+	RoleID        int64     `db:"role_id"`
+	CreatedAt     time.Time `db:"created_at"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
+// validateApplicationUser validates that the role is a predefined role or
+// belongs to the organization of the application and that the user is a
+// member of this organization.
+func validateApplicationUser(ctx context.Context, db sqlx.Queryer, au ApplicationUser) error {
+	var res struct {
+		RoleValid bool `db:"role_valid"`
+		UserValid bool `db:"user_valid"`
+	}
+
+	err := sqlx.Get(db, &res, `
+		select
+			exists (
+				select 1 from role r
+				where r.id = $2 and (r.organization_id = a.organization_id or r.organization_id is null)
+			) as role_valid,
+			exists (
+				select 1 from organization_user ou
+				where ou.user_id = $3 and ou.organization_id = a.organization_id
+			) as user_valid
+		from
+			application a
+		where
+			a.id = $1`,
+		au.ApplicationID,
+		au.RoleID,
+		au.UserID,
+	)
+	if err != nil {
+		return handlePSQLError(Select, err, "select error")
+	}
+
+	if !res.RoleValid {
+		return ErrRoleInvalidOrganization
+	}
+	if !res.UserValid {
+		return ErrApplicationUserNotOrganizationUser
+	}
+
+	return nil
+}
+
+// CreateApplicationUser assigns the given role to the user for the
+// application.
+func CreateApplicationUser(ctx context.Context, db sqlx.Ext, au *ApplicationUser) error {
+	if err := validateApplicationUser(ctx, db, *au); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	au.CreatedAt = now
+	au.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into application_user (
+			application_id,
+			user_id,
+			role_id,
+			created_at,
+			updated_at
+		) values ($1, $2, $3, $4, $5)`,
+		au.ApplicationID,
+		au.UserID,
+		au.RoleID,
+		au.CreatedAt,
+		au.UpdatedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"application_id": au.ApplicationID,
+		"user_id":        au.UserID,
+		"role_id":        au.RoleID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("user added to application")
+
+	return nil
+}
+
+// GetApplicationUser returns the application user for the given
+// application and user ID.
+func GetApplicationUser(ctx context.Context, db sqlx.Queryer, applicationID, userID int64) (ApplicationUser, error) {
+	var au ApplicationUser
+
+	err := sqlx.Get(db, &au, `
+		select
+			au.application_id,
+			au.user_id,
+			u.email,
+			au.role_id,
+			au.created_at,
+			au.updated_at
+		from
+			application_user au
+		inner join "user" u
+			on u.id = au.user_id
+		where
+			au.application_id = $1
+			and au.user_id = $2`,
+		applicationID,
+		userID,
+	)
+	if err != nil {
+		return au, handlePSQLError(Select, err, "select error")
+	}
+
+	return au, nil
+}
+
+// GetApplicationUserCount returns the number of users for the given
+// application.
+func GetApplicationUserCount(ctx context.Context, db sqlx.Queryer, applicationID int64) (int, error) {
+	var count int
+
+	err := sqlx.Get(db, &count, `
+		select
+			count(*)
+		from
+			application_user
+		where
+			application_id = $1`,
+		applicationID,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetApplicationUsers returns the users for the given application.
+func GetApplicationUsers(ctx context.Context, db sqlx.Queryer, applicationID int64, limit, offset int) ([]ApplicationUser, error) {
+	var users []ApplicationUser
+
+	err := sqlx.Select(db, &users, `
+		select
+			au.application_id,
+			au.user_id,
+			u.email,
+			au.role_id,
+			au.created_at,
+			au.updated_at
+		from
+			application_user au
+		inner join "user" u
+			on u.id = au.user_id
+		where
+			au.application_id = $1
+		order by
+			u.email
+		limit $2
+		offset $3`,
+		applicationID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return users, nil
+}
+
+// UpdateApplicationUser updates the role of the given application user.
+func UpdateApplicationUser(ctx context.Context, db sqlx.Ext, au *ApplicationUser) error {
+	if err := validateApplicationUser(ctx, db, *au); err != nil {
+		return err
+	}
+
+	au.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update application_user
+		set
+			role_id = $3,
+			updated_at = $4
+		where
+			application_id = $1
+			and user_id = $2`,
+		au.ApplicationID,
+		au.UserID,
+		au.RoleID,
+		au.UpdatedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"application_id": au.ApplicationID,
+		"user_id":        au.UserID,
+		"role_id":        au.RoleID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("application user updated")
+
+	return nil
+}
+
+// DeleteApplicationUser removes the given user from the application.
+func DeleteApplicationUser(ctx context.Context, db sqlx.Execer, applicationID, userID int64) error {
+	res, err := db.Exec(`
+		delete from application_user
+		where
+			application_id = $1
+			and user_id = $2`,
+		applicationID,
+		userID,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"application_id": applicationID,
+		"user_id":        userID,
+		"ctx_id":         ctx.Value(logging.ContextIDKey),
+	}).Info("application user deleted")
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestApplicationUserScope() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	apps := []Application{
+		{Name: "marketing", OrganizationID: org.ID, ServiceProfileID: spID},
+		{Name: "facilities", OrganizationID: org.ID, ServiceProfileID: spID},
+	}
+	for i := range apps {
+		assert.NoError(CreateApplication(context.Background(), ts.tx, &apps[i]))
+	}
+
+	user := User{
+		IsActive: true,
+		Email:    "marketing@example.com",
+	}
+	assert.NoError(CreateUser(context.Background(), ts.tx, &user))
+
+	assert.NoError(CreateOrganizationUser(context.Background(), ts.tx, org.ID, user.ID, false, false, false))
+
+	viewer, err := GetPredefinedRole(context.Background(), ts.tx, RoleViewer)
+	assert.NoError(err)
+
+	ts.T().Run("Without application role all applications are visible", func(t *testing.T) {
+		assert := require.New(t)
+
+		count, err := GetApplicationCount(context.Background(), ts.tx, ApplicationFilters{UserID: user.ID, OrganizationID: org.ID})
+		assert.NoError(err)
+		assert.Equal(2, count)
+	})
+
+	ts.T().Run("Create with predefined role", func(t *testing.T) {
+		assert := require.New(t)
+
+		au := ApplicationUser{
+			ApplicationID: apps[0].ID,
+			UserID:        user.ID,
+			RoleID:        viewer.ID,
+		}
+		assert.NoError(CreateApplicationUser(context.Background(), ts.tx, &au))
+
+		t.Run("Applications are filtered", func(t *testing.T) {
+			assert := require.New(t)
+
+			filters := ApplicationFilters{UserID: user.ID, Limit: 10}
+			count, err := GetApplicationCount(context.Background(), ts.tx, filters)
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			items, err := GetApplications(context.Background(), ts.tx, filters)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(apps[0].ID, items[0].ID)
+		})
+
+		t.Run("Search is filtered", func(t *testing.T) {
+			assert := require.New(t)
+
+			res, err := GlobalSearch(context.Background(), ts.tx, user.ID, false, "i", 10, 0)
+			assert.NoError(err)
+
+			var appIDs []int64
+			for _, r := range res {
+				if r.Kind == "application" {
+					appIDs = append(appIDs, *r.ApplicationID)
+				}
+			}
+			assert.Equal([]int64{apps[0].ID}, appIDs)
+		})
+
+		t.Run("Organization admin is not restricted", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateOrganizationUser(context.Background(), ts.tx, org.ID, user.ID, true, false, false))
+			count, err := GetApplicationCount(context.Background(), ts.tx, ApplicationFilters{UserID: user.ID})
+			assert.NoError(err)
+			assert.Equal(2, count)
+			assert.NoError(UpdateOrganizationUser(context.Background(), ts.tx, org.ID, user.ID, false, false, false))
+		})
+
+		t.Run("Delete organization user", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteOrganizationUser(context.Background(), ts.tx, org.ID, user.ID))
+
+			count, err := GetApplicationCount(context.Background(), ts.tx, ApplicationFilters{UserID: user.ID})
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+	})
+}
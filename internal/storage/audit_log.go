@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// Audit log actions.
+const (
+	AuditActionCreate = "create"
+	AuditActionUpdate = "update"
+	AuditActionDelete = "delete"
+
+	// AuditActionExecute records the other mutating operations, e.g.
+	// adding a device to a multicast-group or enqueueing a downlink.
+	AuditActionExecute = "execute"
+
+	// AuditActionLoginFailed records a failed (or locked) login attempt.
+	AuditActionLoginFailed = "login_failed"
+)
+
+// AuditLogEntry defines a single audit log entry, recording a mutating
+// API operation.
+type AuditLogEntry struct {
+	ID        int64      `db:"id"`
+	CreatedAt time.Time  `db:"created_at"`
+	RequestID *uuid.UUID `db:"request_id"`
+
+	// OrganizationID contains the organization to which the resource
+	// belongs. This is nil for global resources (e.g. network-servers).
+	OrganizationID *int64 `db:"organization_id"`
+
+	// Subject, UserID, Username and APIKeyID identify the actor.
+	Subject  string     `db:"subject"`
+	UserID   *int64     `db:"user_id"`
+	Username string     `db:"username"`
+	APIKeyID *uuid.UUID `db:"api_key_id"`
+
+	Service      string `db:"service"`
+	Method       string `db:"method"`
+	Action       string `db:"action"`
+	ResourceType string `db:"resource_type"`
+	ResourceID   string `db:"resource_id"`
+
+	// Changes contains the (redacted) changes as JSON object, in the format
+	// {"field": {"old": ..., "new": ...}}.
+	Changes json.RawMessage `db:"changes"`
+}
+
+// CreateAuditLogEntry creates the given audit log entry.
+func CreateAuditLogEntry(ctx context.Context, db sqlx.Queryer, e *AuditLogEntry) error {
+	e.CreatedAt = time.Now()
+	if len(e.Changes) == 0 {
+		e.Changes = json.RawMessage("{}")
+	}
+
+	err := sqlx.Get(db, &e.ID, `
+		insert into audit_log (
+			created_at,
+			request_id,
+			organization_id,
+			subject,
+			user_id,
+			username,
+			api_key_id,
+			service,
+			method,
+			action,
+			resource_type,
+			resource_id,
+			changes
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		returning id`,
+		e.CreatedAt,
+		e.RequestID,
+		e.OrganizationID,
+		e.Subject,
+		e.UserID,
+		e.Username,
+		e.APIKeyID,
+		e.Service,
+		e.Method,
+		e.Action,
+		e.ResourceType,
+		e.ResourceID,
+		[]byte(e.Changes),
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":            e.ID,
+		"action":        e.Action,
+		"resource_type": e.ResourceType,
+		"resource_id":   e.ResourceID,
+		"ctx_id":        ctx.Value(logging.ContextIDKey),
+	}).Info("audit-log entry created")
+
+	return nil
+}
+
+// AuditLogFilters provides filters for filtering the audit log.
+type AuditLogFilters struct {
+	OrganizationID int64      `db:"organization_id"`
+	UserID         int64      `db:"user_id"`
+	APIKeyID       *uuid.UUID `db:"api_key_id"`
+	Action         string     `db:"action"`
+	ResourceType   string     `db:"resource_type"`
+	ResourceID     string     `db:"resource_id"`
+	From           *time.Time `db:"from"`
+	To             *time.Time `db:"to"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filter.
+func (f AuditLogFilters) SQL() string {
+	var filters []string
+
+	if f.OrganizationID != 0 {
+		filters = append(filters, "organization_id = :organization_id")
+	}
+
+	if f.UserID != 0 {
+		filters = append(filters, "user_id = :user_id")
+	}
+
+	if f.APIKeyID != nil {
+		filters = append(filters, "api_key_id = :api_key_id")
+	}
+
+	if f.Action != "" {
+		filters = append(filters, "action = :action")
+	}
+
+	if f.ResourceType != "" {
+		filters = append(filters, "resource_type = :resource_type")
+	}
+
+	if f.ResourceID != "" {
+		filters = append(filters, "resource_id = :resource_id")
+	}
+
+	if f.From != nil {
+		filters = append(filters, "created_at >= :from")
+	}
+
+	if f.To != nil {
+		filters = append(filters, "created_at < :to")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// GetAuditLogEntryCount returns the number of audit log entries matching
+// the given filters.
+func GetAuditLogEntryCount(ctx context.Context, db sqlx.Queryer, filters AuditLogFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			audit_log
+		`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	err = sqlx.Get(db, &count, query, args...)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetAuditLogEntries returns the audit log entries matching the given
+// filters, most recent first.
+func GetAuditLogEntries(ctx context.Context, db sqlx.Queryer, filters AuditLogFilters) ([]AuditLogEntry, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			*
+		from
+			audit_log
+		`+filters.SQL()+`
+		order by
+			created_at desc,
+			id desc
+		limit :limit
+		offset :offset
+	`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var entries []AuditLogEntry
+	err = sqlx.Select(db, &entries, query, args...)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return entries, nil
+}
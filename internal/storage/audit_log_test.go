@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestAuditLog() {
+	assert := require.New(ts.T())
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	userID := int64(1)
+	apiKeyID := uuid.Must(uuid.NewV4())
+	requestID := uuid.Must(uuid.NewV4())
+
+	entries := []AuditLogEntry{
+		{
+			RequestID:      &requestID,
+			OrganizationID: &org.ID,
+			Subject:        "user",
+			UserID:         &userID,
+			Username:       "admin",
+			Service:        "GatewayService",
+			Method:         "Create",
+			Action:         AuditActionCreate,
+			ResourceType:   "gateway",
+			ResourceID:     "0102030405060708",
+			Changes:        json.RawMessage(`{"name":{"old":null,"new":"test-gw"}}`),
+		},
+		{
+			OrganizationID: &org.ID,
+			Subject:        "api_key",
+			APIKeyID:       &apiKeyID,
+			Service:        "GatewayService",
+			Method:         "Delete",
+			Action:         AuditActionDelete,
+			ResourceType:   "gateway",
+			ResourceID:     "0102030405060708",
+		},
+		{
+			Subject:      "user",
+			UserID:       &userID,
+			Username:     "admin",
+			Service:      "NetworkServerService",
+			Method:       "Create",
+			Action:       AuditActionCreate,
+			ResourceType: "network_server",
+			ResourceID:   "1",
+		},
+	}
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		for i := range entries {
+			assert.NoError(CreateAuditLogEntry(context.Background(), ts.tx, &entries[i]))
+			assert.NotEqual(int64(0), entries[i].ID)
+			entries[i].CreatedAt = entries[i].CreatedAt.Round(time.Second).UTC()
+		}
+
+		t.Run("Get count and entries", func(t *testing.T) {
+			tests := []struct {
+				name    string
+				filters AuditLogFilters
+				ids     []int64
+			}{
+				{
+					name:    "no filters",
+					filters: AuditLogFilters{Limit: 10},
+					ids:     []int64{entries[2].ID, entries[1].ID, entries[0].ID},
+				},
+				{
+					name:    "organization",
+					filters: AuditLogFilters{OrganizationID: org.ID, Limit: 10},
+					ids:     []int64{entries[1].ID, entries[0].ID},
+				},
+				{
+					name:    "user",
+					filters: AuditLogFilters{OrganizationID: org.ID, UserID: userID, Limit: 10},
+					ids:     []int64{entries[0].ID},
+				},
+				{
+					name:    "api key",
+					filters: AuditLogFilters{APIKeyID: &apiKeyID, Limit: 10},
+					ids:     []int64{entries[1].ID},
+				},
+				{
+					name:    "action",
+					filters: AuditLogFilters{Action: AuditActionCreate, Limit: 10},
+					ids:     []int64{entries[2].ID, entries[0].ID},
+				},
+				{
+					name:    "resource",
+					filters: AuditLogFilters{ResourceType: "gateway", ResourceID: "0102030405060708", Limit: 10},
+					ids:     []int64{entries[1].ID, entries[0].ID},
+				},
+				{
+					name:    "limit and offset",
+					filters: AuditLogFilters{Limit: 1, Offset: 1},
+					ids:     []int64{entries[1].ID},
+				},
+			}
+
+			for _, tst := range tests {
+				t.Run(tst.name, func(t *testing.T) {
+					assert := require.New(t)
+
+					count, err := GetAuditLogEntryCount(context.Background(), ts.tx, tst.filters)
+					assert.NoError(err)
+					if tst.filters.Offset == 0 {
+						assert.Equal(len(tst.ids), count)
+					}
+
+					items, err := GetAuditLogEntries(context.Background(), ts.tx, tst.filters)
+					assert.NoError(err)
+
+					var ids []int64
+					for _, item := range items {
+						ids = append(ids, item.ID)
+					}
+					assert.Equal(tst.ids, ids)
+				})
+			}
+		})
+
+		t.Run("Get entries", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetAuditLogEntries(context.Background(), ts.tx, AuditLogFilters{
+				OrganizationID: org.ID,
+				UserID:         userID,
+				Limit:          10,
+			})
+			assert.NoError(err)
+			assert.Len(items, 1)
+
+			items[0].CreatedAt = items[0].CreatedAt.Round(time.Second).UTC()
+			assert.JSONEq(string(entries[0].Changes), string(items[0].Changes))
+			items[0].Changes = entries[0].Changes
+			assert.Equal(entries[0], items[0])
+		})
+	})
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq/hstore"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/codec"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// CodecRevision defines a payload codec revision of a device-profile or
+// (legacy) application codec.
+type CodecRevision struct {
+	ID                           int64         `db:"id"`
+	CreatedAt                    time.Time     `db:"created_at"`
+	DeviceProfileID              *uuid.UUID    `db:"device_profile_id"`
+	ApplicationID                *int64        `db:"application_id"`
+	Revision                     int           `db:"revision"`
+	UserID                       *int64        `db:"user_id"`
+	APIKeyID                     *uuid.UUID    `db:"api_key_id"`
+	PayloadCodec                 codec.Type    `db:"payload_codec"`
+	PayloadEncoderScript         string        `db:"payload_encoder_script"`
+	PayloadDecoderScript         string        `db:"payload_decoder_script"`
+	PayloadProtobufDescriptorSet []byte        `db:"payload_protobuf_descriptor_set"`
+	PayloadProtobufMessages      hstore.Hstore `db:"payload_protobuf_messages"`
+}
+
+// CodecRevisionListItem defines the codec revision as list item.
+type CodecRevisionListItem struct {
+	CodecRevision
+	UserEmail *string `db:"user_email"`
+}
+
+// CodecEqual returns true when the codec settings of both revisions are
+// equal.
+func (r CodecRevision) CodecEqual(other CodecRevision) bool {
+	return r.PayloadCodec == other.PayloadCodec &&
+		r.PayloadEncoderScript == other.PayloadEncoderScript &&
+		r.PayloadDecoderScript == other.PayloadDecoderScript &&
+		r.ProtobufSchemaEqual(other)
+}
+
+// ProtobufSchemaEqual returns true when the Protobuf descriptor set and
+// message mapping of both revisions are equal.
+func (r CodecRevision) ProtobufSchemaEqual(other CodecRevision) bool {
+	if string(r.PayloadProtobufDescriptorSet) != string(other.PayloadProtobufDescriptorSet) ||
+		len(r.PayloadProtobufMessages.Map) != len(other.PayloadProtobufMessages.Map) {
+		return false
+	}
+
+	for k, v := range r.PayloadProtobufMessages.Map {
+		if other.PayloadProtobufMessages.Map[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CodecRevision returns the current codec settings of the device-profile as
+// (unsaved) revision.
+func (dp DeviceProfile) CodecRevision() (CodecRevision, error) {
+	id, err := uuid.FromBytes(dp.DeviceProfile.Id)
+	if err != nil {
+		return CodecRevision{}, errors.Wrap(err, "uuid from bytes error")
+	}
+
+	return CodecRevision{
+		DeviceProfileID:              &id,
+		Revision:                     dp.PayloadCodecRevision,
+		PayloadCodec:                 dp.PayloadCodec,
+		PayloadEncoderScript:         dp.PayloadEncoderScript,
+		PayloadDecoderScript:         dp.PayloadDecoderScript,
+		PayloadProtobufDescriptorSet: dp.PayloadProtobufDescriptorSet,
+		PayloadProtobufMessages:      dp.PayloadProtobufMessages,
+	}, nil
+}
+
+// CodecRevision returns the current codec settings of the application as
+// (unsaved) revision.
+func (a Application) CodecRevision() CodecRevision {
+	id := a.ID
+
+	return CodecRevision{
+		ApplicationID:        &id,
+		Revision:             a.PayloadCodecRevision,
+		PayloadCodec:         a.PayloadCodec,
+		PayloadEncoderScript: a.PayloadEncoderScript,
+		PayloadDecoderScript: a.PayloadDecoderScript,
+	}
+}
+
+// CreateCodecRevision creates the given codec revision and sets it as the
+// current revision of the device-profile or application. The revision
+// number is incremented automatically.
+func CreateCodecRevision(ctx context.Context, db sqlx.Ext, r *CodecRevision) error {
+	if (r.DeviceProfileID == nil) == (r.ApplicationID == nil) {
+		return errors.New("either device_profile_id or application_id must be set")
+	}
+
+	r.CreatedAt = time.Now()
+
+	err := sqlx.Get(db, r, `
+		insert into codec_revision (
+			created_at,
+			device_profile_id,
+			application_id,
+			revision,
+			user_id,
+			api_key_id,
+			payload_codec,
+			payload_encoder_script,
+			payload_decoder_script,
+			payload_protobuf_descriptor_set,
+			payload_protobuf_messages
+		) values (
+			$1, $2, $3,
+			(
+				select
+					coalesce(max(revision), 0) + 1
+				from
+					codec_revision
+				where
+					device_profile_id = $2
+					or application_id = $3
+			),
+			$4, $5, $6, $7, $8, $9, $10
+		)
+		returning *`,
+		r.CreatedAt,
+		r.DeviceProfileID,
+		r.ApplicationID,
+		r.UserID,
+		r.APIKeyID,
+		r.PayloadCodec,
+		r.PayloadEncoderScript,
+		r.PayloadDecoderScript,
+		r.PayloadProtobufDescriptorSet,
+		r.PayloadProtobufMessages,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	if r.DeviceProfileID != nil {
+		_, err = db.Exec("update device_profile set payload_codec_revision = $2 where device_profile_id = $1", r.DeviceProfileID, r.Revision)
+	} else {
+		_, err = db.Exec("update application set payload_codec_revision = $2 where id = $1", r.ApplicationID, r.Revision)
+	}
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+
+	log.WithFields(log.Fields{
+		"device_profile_id": r.DeviceProfileID,
+		"application_id":    r.ApplicationID,
+		"revision":          r.Revision,
+		"ctx_id":            ctx.Value(logging.ContextIDKey),
+	}).Info("codec revision created")
+
+	return nil
+}
+
+// CodecRevisionFilters provides filters for filtering codec revisions.
+// Either the DeviceProfileID or ApplicationID must be set.
+type CodecRevisionFilters struct {
+	DeviceProfileID *uuid.UUID `db:"device_profile_id"`
+	ApplicationID   *int64     `db:"application_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filter.
+func (f CodecRevisionFilters) SQL() string {
+	var filters []string
+
+	if f.DeviceProfileID != nil {
+		filters = append(filters, "r.device_profile_id = :device_profile_id")
+	}
+
+	if f.ApplicationID != nil {
+		filters = append(filters, "r.application_id = :application_id")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// GetCodecRevision returns the codec revision for the given filters and
+// revision number.
+func GetCodecRevision(ctx context.Context, db sqlx.Queryer, filters CodecRevisionFilters, revision int) (CodecRevision, error) {
+	var r CodecRevision
+
+	if filters.DeviceProfileID == nil && filters.ApplicationID == nil {
+		return r, errors.New("either device_profile_id or application_id must be set")
+	}
+
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			r.*
+		from
+			codec_revision r
+	`+filters.SQL()+`
+			and r.revision = :revision
+	`, struct {
+		CodecRevisionFilters
+		Revision int `db:"revision"`
+	}{filters, revision})
+	if err != nil {
+		return r, errors.Wrap(err, "named query error")
+	}
+
+	if err := sqlx.Get(db, &r, query, args...); err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// GetCodecRevisionCount returns the number of codec revisions.
+func GetCodecRevisionCount(ctx context.Context, db sqlx.Queryer, filters CodecRevisionFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			codec_revision r
+	`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetCodecRevisions returns the codec revisions, latest revision first.
+func GetCodecRevisions(ctx context.Context, db sqlx.Queryer, filters CodecRevisionFilters) ([]CodecRevisionListItem, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			r.*,
+			u.email as user_email
+		from
+			codec_revision r
+		left join "user" u
+			on u.id = r.user_id
+	`+filters.SQL()+`
+		order by
+			r.revision desc
+		limit :limit
+		offset :offset
+	`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var items []CodecRevisionListItem
+	if err := sqlx.Select(db, &items, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return items, nil
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-api/go/v3/ns"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+	"github.com/gyh1621/chirpstack-application-server/internal/codec"
+)
+
+func TestCodecRevisionCodecEqual(t *testing.T) {
+	assert := require.New(t)
+
+	a := CodecRevision{
+		PayloadCodec:         codec.CustomJSType,
+		PayloadDecoderScript: "function Decode() {}",
+	}
+	b := a
+	assert.True(a.CodecEqual(b))
+
+	b.PayloadDecoderScript = "function Decode() { return {}; }"
+	assert.False(a.CodecEqual(b))
+
+	// the revision metadata is not part of the codec settings
+	b = a
+	b.Revision = 3
+	assert.True(a.CodecEqual(b))
+}
+
+func (ts *StorageTestSuite) TestCodecRevision() {
+	assert := require.New(ts.T())
+
+	nsClient := mock.NewClient()
+	networkserver.SetPool(mock.NewPool(nsClient))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.Tx(), &org))
+
+	u := User{
+		IsActive: true,
+		Email:    "foo@bar.com",
+	}
+	assert.NoError(CreateUser(context.Background(), ts.Tx(), &u))
+
+	n := NetworkServer{
+		Name:   "test-ns",
+		Server: "test-ns:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.Tx(), &n))
+
+	sp := ServiceProfile{
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+		Name:            "test-sp",
+		ServiceProfile:  ns.ServiceProfile{},
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.Tx(), &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+		Name:             "test-app",
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.Tx(), &app))
+
+	filters := CodecRevisionFilters{
+		ApplicationID: &app.ID,
+		Limit:         10,
+	}
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		for _, script := range []string{"function Decode() {}", "function Decode() { return {}; }"} {
+			app.PayloadCodec = codec.CustomJSType
+			app.PayloadDecoderScript = script
+
+			r := app.CodecRevision()
+			r.UserID = &u.ID
+			assert.NoError(CreateCodecRevision(context.Background(), ts.Tx(), &r))
+		}
+
+		app, err := GetApplication(context.Background(), ts.Tx(), app.ID)
+		assert.NoError(err)
+		assert.Equal(2, app.PayloadCodecRevision)
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			r, err := GetCodecRevision(context.Background(), ts.Tx(), filters, 1)
+			assert.NoError(err)
+			assert.Equal("function Decode() {}", r.PayloadDecoderScript)
+			assert.Equal(&u.ID, r.UserID)
+
+			_, err = GetCodecRevision(context.Background(), ts.Tx(), filters, 3)
+			assert.Equal(ErrDoesNotExist, err)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			count, err := GetCodecRevisionCount(context.Background(), ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Equal(2, count)
+
+			items, err := GetCodecRevisions(context.Background(), ts.Tx(), filters)
+			assert.NoError(err)
+			assert.Len(items, 2)
+			assert.Equal(2, items[0].Revision)
+			assert.Equal(1, items[1].Revision)
+			assert.Equal(u.Email, *items[0].UserEmail)
+		})
+	})
+}
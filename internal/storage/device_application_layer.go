@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// DeviceApplicationLayer defines the application-layer package versions
+// implemented by a device, as reported by the PackageVersionAns.
+// A nil version means that the version has not been reported (yet).
+type DeviceApplicationLayer struct {
+	DevEUI    lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+
+	ClockSyncVersion      *int `db:"clock_sync_version"`
+	MulticastSetupVersion *int `db:"multicast_setup_version"`
+	FragmentationVersion  *int `db:"fragmentation_version"`
+
+	// FragmentationSessionCnt contains the last used fragmentation session
+	// counter (fragmentation package v2).
+	FragmentationSessionCnt int `db:"fragmentation_session_cnt"`
+
+	PackageVersionRequestedAt *time.Time `db:"package_version_requested_at"`
+}
+
+// CreateDeviceApplicationLayer creates the given device application-layer
+// state.
+func CreateDeviceApplicationLayer(ctx context.Context, db sqlx.Execer, dal *DeviceApplicationLayer) error {
+	now := time.Now()
+	dal.CreatedAt = now
+	dal.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into device_application_layer (
+			dev_eui,
+			created_at,
+			updated_at,
+			clock_sync_version,
+			multicast_setup_version,
+			fragmentation_version,
+			fragmentation_session_cnt,
+			package_version_requested_at
+		) values ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		dal.DevEUI[:],
+		dal.CreatedAt,
+		dal.UpdatedAt,
+		dal.ClockSyncVersion,
+		dal.MulticastSetupVersion,
+		dal.FragmentationVersion,
+		dal.FragmentationSessionCnt,
+		dal.PackageVersionRequestedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": dal.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device application-layer created")
+
+	return nil
+}
+
+// GetDeviceApplicationLayer returns the application-layer state of the given
+// device.
+func GetDeviceApplicationLayer(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, forUpdate bool) (DeviceApplicationLayer, error) {
+	var fu string
+	if forUpdate {
+		fu = " for update"
+	}
+
+	var dal DeviceApplicationLayer
+	err := sqlx.Get(db, &dal, `
+		select
+			*
+		from
+			device_application_layer
+		where
+			dev_eui = $1`+fu,
+		devEUI[:],
+	)
+	if err != nil {
+		return dal, handlePSQLError(Select, err, "select error")
+	}
+
+	return dal, nil
+}
+
+// UpdateDeviceApplicationLayer updates the given device application-layer
+// state.
+func UpdateDeviceApplicationLayer(ctx context.Context, db sqlx.Execer, dal *DeviceApplicationLayer) error {
+	dal.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update device_application_layer
+		set
+			updated_at = $2,
+			clock_sync_version = $3,
+			multicast_setup_version = $4,
+			fragmentation_version = $5,
+			fragmentation_session_cnt = $6,
+			package_version_requested_at = $7
+		where
+			dev_eui = $1`,
+		dal.DevEUI[:],
+		dal.UpdatedAt,
+		dal.ClockSyncVersion,
+		dal.MulticastSetupVersion,
+		dal.FragmentationVersion,
+		dal.FragmentationSessionCnt,
+		dal.PackageVersionRequestedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": dal.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device application-layer updated")
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestDeviceApplicationLayer() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+		Description:     "test device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	ts.T().Run("Get does not exist", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := GetDeviceApplicationLayer(context.Background(), ts.tx, d.DevEUI, false)
+		assert.Equal(ErrDoesNotExist, err)
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		dal := DeviceApplicationLayer{
+			DevEUI: d.DevEUI,
+		}
+		assert.NoError(CreateDeviceApplicationLayer(context.Background(), ts.tx, &dal))
+		dal.CreatedAt = dal.CreatedAt.Round(time.Second).UTC()
+		dal.UpdatedAt = dal.UpdatedAt.Round(time.Second).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			dalGet, err := GetDeviceApplicationLayer(context.Background(), ts.tx, d.DevEUI, false)
+			assert.NoError(err)
+			dalGet.CreatedAt = dalGet.CreatedAt.Round(time.Second).UTC()
+			dalGet.UpdatedAt = dalGet.UpdatedAt.Round(time.Second).UTC()
+
+			assert.Equal(dal, dalGet)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			now := time.Now().Round(time.Second).UTC()
+			v1 := 1
+			v2 := 2
+
+			dal.ClockSyncVersion = &v1
+			dal.MulticastSetupVersion = &v2
+			dal.FragmentationVersion = &v2
+			dal.FragmentationSessionCnt = 10
+			dal.PackageVersionRequestedAt = &now
+			assert.NoError(UpdateDeviceApplicationLayer(context.Background(), ts.tx, &dal))
+			dal.UpdatedAt = dal.UpdatedAt.Round(time.Second).UTC()
+
+			dalGet, err := GetDeviceApplicationLayer(context.Background(), ts.tx, d.DevEUI, true)
+			assert.NoError(err)
+			dalGet.CreatedAt = dalGet.CreatedAt.Round(time.Second).UTC()
+			dalGet.UpdatedAt = dalGet.UpdatedAt.Round(time.Second).UTC()
+			assert.True(now.Equal(*dalGet.PackageVersionRequestedAt))
+			dalGet.PackageVersionRequestedAt = &now
+
+			assert.Equal(dal, dalGet)
+		})
+	})
+}
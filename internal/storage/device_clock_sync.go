@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// DeviceClockSync defines the application-layer clock synchronization state
+// of a device.
+type DeviceClockSync struct {
+	DevEUI    lorawan.EUI64 `db:"dev_eui"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+
+	// ClockDrift contains the last observed difference in seconds between
+	// the network time and the device time (network - device).
+	ClockDrift   int        `db:"clock_drift"`
+	ClockDriftAt *time.Time `db:"clock_drift_at"`
+
+	// Periodicity contains the last requested periodicity. The device
+	// sends an AppTimeReq every 128 * 2^Periodicity seconds.
+	Periodicity             *int       `db:"periodicity"`
+	PeriodicityRequestedAt  *time.Time `db:"periodicity_requested_at"`
+	PeriodicityAnsweredAt   *time.Time `db:"periodicity_answered_at"`
+	PeriodicityNotSupported bool       `db:"periodicity_not_supported"`
+
+	ResyncNbTransmissions int        `db:"resync_nb_transmissions"`
+	ResyncRequestedAt     *time.Time `db:"resync_requested_at"`
+}
+
+// CreateDeviceClockSync creates the given device clock synchronization
+// state.
+func CreateDeviceClockSync(ctx context.Context, db sqlx.Execer, cs *DeviceClockSync) error {
+	now := time.Now()
+	cs.CreatedAt = now
+	cs.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into device_clock_sync (
+			dev_eui,
+			created_at,
+			updated_at,
+			clock_drift,
+			clock_drift_at,
+			periodicity,
+			periodicity_requested_at,
+			periodicity_answered_at,
+			periodicity_not_supported,
+			resync_nb_transmissions,
+			resync_requested_at
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		cs.DevEUI[:],
+		cs.CreatedAt,
+		cs.UpdatedAt,
+		cs.ClockDrift,
+		cs.ClockDriftAt,
+		cs.Periodicity,
+		cs.PeriodicityRequestedAt,
+		cs.PeriodicityAnsweredAt,
+		cs.PeriodicityNotSupported,
+		cs.ResyncNbTransmissions,
+		cs.ResyncRequestedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": cs.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device clock-sync created")
+
+	return nil
+}
+
+// GetDeviceClockSync returns the clock synchronization state of the given
+// device.
+func GetDeviceClockSync(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64, forUpdate bool) (DeviceClockSync, error) {
+	var fu string
+	if forUpdate {
+		fu = " for update"
+	}
+
+	var cs DeviceClockSync
+	err := sqlx.Get(db, &cs, `
+		select
+			*
+		from
+			device_clock_sync
+		where
+			dev_eui = $1`+fu,
+		devEUI[:],
+	)
+	if err != nil {
+		return cs, handlePSQLError(Select, err, "select error")
+	}
+
+	return cs, nil
+}
+
+// UpdateDeviceClockSync updates the given device clock synchronization
+// state.
+func UpdateDeviceClockSync(ctx context.Context, db sqlx.Execer, cs *DeviceClockSync) error {
+	cs.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update device_clock_sync
+		set
+			updated_at = $2,
+			clock_drift = $3,
+			clock_drift_at = $4,
+			periodicity = $5,
+			periodicity_requested_at = $6,
+			periodicity_answered_at = $7,
+			periodicity_not_supported = $8,
+			resync_nb_transmissions = $9,
+			resync_requested_at = $10
+		where
+			dev_eui = $1`,
+		cs.DevEUI[:],
+		cs.UpdatedAt,
+		cs.ClockDrift,
+		cs.ClockDriftAt,
+		cs.Periodicity,
+		cs.PeriodicityRequestedAt,
+		cs.PeriodicityAnsweredAt,
+		cs.PeriodicityNotSupported,
+		cs.ResyncNbTransmissions,
+		cs.ResyncRequestedAt,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"dev_eui": cs.DevEUI,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("device clock-sync updated")
+
+	return nil
+}
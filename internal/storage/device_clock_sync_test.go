@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestDeviceClockSync() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	var spID uuid.UUID
+	copy(spID[:], sp.ServiceProfile.Id)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	dp := DeviceProfile{
+		Name:            "test-dp",
+		OrganizationID:  org.ID,
+		NetworkServerID: n.ID,
+	}
+	assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+	var dpID uuid.UUID
+	copy(dpID[:], dp.DeviceProfile.Id)
+
+	d := Device{
+		DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+		ApplicationID:   app.ID,
+		DeviceProfileID: dpID,
+		Name:            "test-device",
+		Description:     "test device",
+	}
+	assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+	ts.T().Run("Get does not exist", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, err := GetDeviceClockSync(context.Background(), ts.tx, d.DevEUI, false)
+		assert.Equal(ErrDoesNotExist, err)
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		cs := DeviceClockSync{
+			DevEUI: d.DevEUI,
+		}
+		assert.NoError(CreateDeviceClockSync(context.Background(), ts.tx, &cs))
+		cs.CreatedAt = cs.CreatedAt.Round(time.Second).UTC()
+		cs.UpdatedAt = cs.UpdatedAt.Round(time.Second).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			csGet, err := GetDeviceClockSync(context.Background(), ts.tx, d.DevEUI, false)
+			assert.NoError(err)
+			csGet.CreatedAt = csGet.CreatedAt.Round(time.Second).UTC()
+			csGet.UpdatedAt = csGet.UpdatedAt.Round(time.Second).UTC()
+
+			assert.Equal(cs, csGet)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			now := time.Now().Round(time.Second).UTC()
+			periodicity := 3
+
+			cs.ClockDrift = -12
+			cs.ClockDriftAt = &now
+			cs.Periodicity = &periodicity
+			cs.PeriodicityRequestedAt = &now
+			cs.PeriodicityAnsweredAt = &now
+			cs.PeriodicityNotSupported = true
+			cs.ResyncNbTransmissions = 2
+			cs.ResyncRequestedAt = &now
+			assert.NoError(UpdateDeviceClockSync(context.Background(), ts.tx, &cs))
+			cs.UpdatedAt = cs.UpdatedAt.Round(time.Second).UTC()
+
+			csGet, err := GetDeviceClockSync(context.Background(), ts.tx, d.DevEUI, true)
+			assert.NoError(err)
+			csGet.CreatedAt = csGet.CreatedAt.Round(time.Second).UTC()
+			csGet.UpdatedAt = csGet.UpdatedAt.Round(time.Second).UTC()
+			assert.True(now.Equal(*csGet.ClockDriftAt))
+			assert.True(now.Equal(*csGet.PeriodicityRequestedAt))
+			assert.True(now.Equal(*csGet.PeriodicityAnsweredAt))
+			assert.True(now.Equal(*csGet.ResyncRequestedAt))
+			csGet.ClockDriftAt = &now
+			csGet.PeriodicityRequestedAt = &now
+			csGet.PeriodicityAnsweredAt = &now
+			csGet.ResyncRequestedAt = &now
+
+			assert.Equal(cs, csGet)
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/lorawan"
+)
+
+const (
+	deviceCodecStateKeyTempl = "lora:as:device:%s:codec:state"
+)
+
+// GetDeviceCodecState returns the (JSON encoded) codec state of the device.
+// It returns nil when the device does not have a codec state.
+func GetDeviceCodecState(ctx context.Context, devEUI lorawan.EUI64) ([]byte, error) {
+	key := fmt.Sprintf(deviceCodecStateKeyTempl, devEUI)
+
+	b, err := RedisClient().Get(key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "get device codec state error")
+	}
+
+	return b, nil
+}
+
+// SaveDeviceCodecState saves the (JSON encoded) codec state of the device
+// with the given TTL. When the given state is nil, the state is deleted.
+func SaveDeviceCodecState(ctx context.Context, devEUI lorawan.EUI64, state []byte, ttl time.Duration) error {
+	key := fmt.Sprintf(deviceCodecStateKeyTempl, devEUI)
+
+	if state == nil {
+		if err := RedisClient().Del(key).Err(); err != nil {
+			return errors.Wrap(err, "delete device codec state error")
+		}
+		return nil
+	}
+
+	if err := RedisClient().Set(key, state, ttl).Err(); err != nil {
+		return errors.Wrap(err, "set device codec state error")
+	}
+
+	return nil
+}
@@ -13,6 +13,10 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 
+	"github.com/brocaar/lorawan"
+	"github.com/brocaar/lorawan/applayer/clocksync"
+	"github.com/brocaar/lorawan/applayer/fragmentation"
+	"github.com/brocaar/lorawan/applayer/multicastsetup"
 	"github.com/gyh1621/chirpstack-api/go/v3/ns"
 	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
 	"github.com/gyh1621/chirpstack-application-server/internal/codec"
@@ -21,16 +25,86 @@ import (
 
 // DeviceProfile defines the device-profile.
 type DeviceProfile struct {
-	NetworkServerID      int64            `db:"network_server_id"`
-	OrganizationID       int64            `db:"organization_id"`
-	CreatedAt            time.Time        `db:"created_at"`
-	UpdatedAt            time.Time        `db:"updated_at"`
-	Name                 string           `db:"name"`
-	PayloadCodec         codec.Type       `db:"payload_codec"`
-	PayloadEncoderScript string           `db:"payload_encoder_script"`
-	PayloadDecoderScript string           `db:"payload_decoder_script"`
-	Tags                 hstore.Hstore    `db:"tags"`
-	DeviceProfile        ns.DeviceProfile `db:"-"`
+	NetworkServerID              int64            `db:"network_server_id"`
+	OrganizationID               int64            `db:"organization_id"`
+	CreatedAt                    time.Time        `db:"created_at"`
+	UpdatedAt                    time.Time        `db:"updated_at"`
+	Name                         string           `db:"name"`
+	PayloadCodec                 codec.Type       `db:"payload_codec"`
+	PayloadEncoderScript         string           `db:"payload_encoder_script"`
+	PayloadDecoderScript         string           `db:"payload_decoder_script"`
+	PayloadProtobufDescriptorSet []byte           `db:"payload_protobuf_descriptor_set"`
+	PayloadProtobufMessages      hstore.Hstore    `db:"payload_protobuf_messages"`
+	PayloadCodecRevision         int              `db:"payload_codec_revision"`
+	Tags                         hstore.Hstore    `db:"tags"`
+	DeviceProfile                ns.DeviceProfile `db:"-"`
+
+	// ApplicationLayerParams contains the LoRaWAN application-layer package
+	// settings. When not set, the defaults are used.
+	ApplicationLayerParams ApplicationLayerParams `db:"-"`
+}
+
+// ApplicationLayerParams defines the LoRaWAN application-layer packages
+// (remote multicast setup, fragmented data block transport and clock
+// synchronization) of a device-profile and the fPorts they use.
+type ApplicationLayerParams struct {
+	MulticastSetupEnabled bool  `db:"app_layer_multicast_setup_enabled"`
+	MulticastSetupFPort   uint8 `db:"app_layer_multicast_setup_f_port"`
+	FragmentationEnabled  bool  `db:"app_layer_fragmentation_enabled"`
+	FragmentationFPort    uint8 `db:"app_layer_fragmentation_f_port"`
+	ClockSyncEnabled      bool  `db:"app_layer_clock_sync_enabled"`
+	ClockSyncFPort        uint8 `db:"app_layer_clock_sync_f_port"`
+}
+
+// DefaultApplicationLayerParams returns the application-layer settings
+// using the default fPorts of the application-layer packages.
+func DefaultApplicationLayerParams() ApplicationLayerParams {
+	return ApplicationLayerParams{
+		MulticastSetupEnabled: true,
+		MulticastSetupFPort:   multicastsetup.DefaultFPort,
+		FragmentationEnabled:  true,
+		FragmentationFPort:    fragmentation.DefaultFPort,
+		ClockSyncEnabled:      true,
+		ClockSyncFPort:        clocksync.DefaultFPort,
+	}
+}
+
+// Validate validates the application-layer settings. The fPorts must be
+// valid application fPorts and enabled packages can not share a fPort.
+func (p ApplicationLayerParams) Validate() error {
+	seen := make(map[uint8]struct{})
+
+	for _, pkg := range []struct {
+		enabled bool
+		fPort   uint8
+	}{
+		{p.MulticastSetupEnabled, p.MulticastSetupFPort},
+		{p.FragmentationEnabled, p.FragmentationFPort},
+		{p.ClockSyncEnabled, p.ClockSyncFPort},
+	} {
+		if pkg.fPort == 0 || pkg.fPort > 223 {
+			return ErrDeviceProfileInvalidAppLayerFPort
+		}
+
+		if !pkg.enabled {
+			continue
+		}
+
+		if _, ok := seen[pkg.fPort]; ok {
+			return ErrDeviceProfileAppLayerFPortConflict
+		}
+		seen[pkg.fPort] = struct{}{}
+	}
+
+	return nil
+}
+
+// IsApplicationLayerFPort returns true when the given fPort is used by one
+// of the enabled application-layer packages.
+func (p ApplicationLayerParams) IsApplicationLayerFPort(fPort uint8) bool {
+	return (p.MulticastSetupEnabled && fPort == p.MulticastSetupFPort) ||
+		(p.FragmentationEnabled && fPort == p.FragmentationFPort) ||
+		(p.ClockSyncEnabled && fPort == p.ClockSyncFPort)
 }
 
 // DeviceProfileMeta defines the device-profile meta record.
@@ -49,13 +123,40 @@ func (dp DeviceProfile) Validate() error {
 	if strings.TrimSpace(dp.Name) == "" || len(dp.Name) > 100 {
 		return ErrDeviceProfileInvalidName
 	}
+
+	if dp.PayloadCodec == codec.ProtobufType {
+		if err := dp.PayloadProtobufSchema().Validate(); err != nil {
+			return errors.Wrap(ErrDeviceProfileInvalidProtobufSchema, err.Error())
+		}
+	}
+
+	// unset application-layer settings are replaced by the defaults on
+	// create and update
+	if dp.ApplicationLayerParams != (ApplicationLayerParams{}) {
+		if err := dp.ApplicationLayerParams.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// PayloadProtobufSchema returns the schema used by the Protobuf codec.
+func (dp DeviceProfile) PayloadProtobufSchema() codec.ProtobufSchema {
+	return codec.ProtobufSchema{
+		DescriptorSet: dp.PayloadProtobufDescriptorSet,
+		Messages:      dp.PayloadProtobufMessages,
+	}
+}
+
 // CreateDeviceProfile creates the given device-profile.
 // This will create the device-profile at the network-server side and will
 // create a local reference record.
 func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) error {
+	if dp.ApplicationLayerParams == (ApplicationLayerParams{}) {
+		dp.ApplicationLayerParams = DefaultApplicationLayerParams()
+	}
+
 	if err := dp.Validate(); err != nil {
 		return errors.Wrap(err, "validate error")
 	}
@@ -81,8 +182,16 @@ func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 			payload_codec,
 			payload_encoder_script,
 			payload_decoder_script,
-			tags
-		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			payload_protobuf_descriptor_set,
+			payload_protobuf_messages,
+			tags,
+			app_layer_multicast_setup_enabled,
+			app_layer_multicast_setup_f_port,
+			app_layer_fragmentation_enabled,
+			app_layer_fragmentation_f_port,
+			app_layer_clock_sync_enabled,
+			app_layer_clock_sync_f_port
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)`,
 		dpID,
 		dp.NetworkServerID,
 		dp.OrganizationID,
@@ -92,7 +201,15 @@ func CreateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 		dp.PayloadCodec,
 		dp.PayloadEncoderScript,
 		dp.PayloadDecoderScript,
+		dp.PayloadProtobufDescriptorSet,
+		dp.PayloadProtobufMessages,
 		dp.Tags,
+		dp.ApplicationLayerParams.MulticastSetupEnabled,
+		dp.ApplicationLayerParams.MulticastSetupFPort,
+		dp.ApplicationLayerParams.FragmentationEnabled,
+		dp.ApplicationLayerParams.FragmentationFPort,
+		dp.ApplicationLayerParams.ClockSyncEnabled,
+		dp.ApplicationLayerParams.ClockSyncFPort,
 	)
 	if err != nil {
 		return handlePSQLError(Insert, err, "insert error")
@@ -145,7 +262,16 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 			payload_codec,
 			payload_encoder_script,
 			payload_decoder_script,
-			tags
+			payload_protobuf_descriptor_set,
+			payload_protobuf_messages,
+			payload_codec_revision,
+			tags,
+			app_layer_multicast_setup_enabled,
+			app_layer_multicast_setup_f_port,
+			app_layer_fragmentation_enabled,
+			app_layer_fragmentation_f_port,
+			app_layer_clock_sync_enabled,
+			app_layer_clock_sync_f_port
 		from device_profile
 		where
 			device_profile_id = $1`+fu,
@@ -164,7 +290,16 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 		&dp.PayloadCodec,
 		&dp.PayloadEncoderScript,
 		&dp.PayloadDecoderScript,
+		&dp.PayloadProtobufDescriptorSet,
+		&dp.PayloadProtobufMessages,
+		&dp.PayloadCodecRevision,
 		&dp.Tags,
+		&dp.ApplicationLayerParams.MulticastSetupEnabled,
+		&dp.ApplicationLayerParams.MulticastSetupFPort,
+		&dp.ApplicationLayerParams.FragmentationEnabled,
+		&dp.ApplicationLayerParams.FragmentationFPort,
+		&dp.ApplicationLayerParams.ClockSyncEnabled,
+		&dp.ApplicationLayerParams.ClockSyncFPort,
 	)
 	if err != nil {
 		return dp, handlePSQLError(Scan, err, "scan error")
@@ -201,6 +336,10 @@ func GetDeviceProfile(ctx context.Context, db sqlx.Queryer, id uuid.UUID, forUpd
 
 // UpdateDeviceProfile updates the given device-profile.
 func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) error {
+	if dp.ApplicationLayerParams == (ApplicationLayerParams{}) {
+		dp.ApplicationLayerParams = DefaultApplicationLayerParams()
+	}
+
 	if err := dp.Validate(); err != nil {
 		return errors.Wrap(err, "validate error")
 	}
@@ -237,7 +376,15 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 			payload_codec = $4,
 			payload_encoder_script = $5,
 			payload_decoder_script = $6,
-			tags = $7
+			payload_protobuf_descriptor_set = $7,
+			payload_protobuf_messages = $8,
+			tags = $9,
+			app_layer_multicast_setup_enabled = $10,
+			app_layer_multicast_setup_f_port = $11,
+			app_layer_fragmentation_enabled = $12,
+			app_layer_fragmentation_f_port = $13,
+			app_layer_clock_sync_enabled = $14,
+			app_layer_clock_sync_f_port = $15
 		where device_profile_id = $1`,
 		dpID,
 		dp.UpdatedAt,
@@ -245,7 +392,15 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 		dp.PayloadCodec,
 		dp.PayloadEncoderScript,
 		dp.PayloadDecoderScript,
+		dp.PayloadProtobufDescriptorSet,
+		dp.PayloadProtobufMessages,
 		dp.Tags,
+		dp.ApplicationLayerParams.MulticastSetupEnabled,
+		dp.ApplicationLayerParams.MulticastSetupFPort,
+		dp.ApplicationLayerParams.FragmentationEnabled,
+		dp.ApplicationLayerParams.FragmentationFPort,
+		dp.ApplicationLayerParams.ClockSyncEnabled,
+		dp.ApplicationLayerParams.ClockSyncFPort,
 	)
 	if err != nil {
 		return handlePSQLError(Update, err, "update error")
@@ -266,6 +421,81 @@ func UpdateDeviceProfile(ctx context.Context, db sqlx.Ext, dp *DeviceProfile) er
 	return nil
 }
 
+// UpdateDeviceProfileApplicationLayerParams updates the application-layer
+// settings of the given device-profile. As these settings are only used by
+// the application-server, no call to the network-server is made.
+func UpdateDeviceProfileApplicationLayerParams(ctx context.Context, db sqlx.Execer, id uuid.UUID, params ApplicationLayerParams) error {
+	if err := params.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	res, err := db.Exec(`
+		update device_profile
+		set
+			updated_at = $2,
+			app_layer_multicast_setup_enabled = $3,
+			app_layer_multicast_setup_f_port = $4,
+			app_layer_fragmentation_enabled = $5,
+			app_layer_fragmentation_f_port = $6,
+			app_layer_clock_sync_enabled = $7,
+			app_layer_clock_sync_f_port = $8
+		where device_profile_id = $1`,
+		id,
+		time.Now(),
+		params.MulticastSetupEnabled,
+		params.MulticastSetupFPort,
+		params.FragmentationEnabled,
+		params.FragmentationFPort,
+		params.ClockSyncEnabled,
+		params.ClockSyncFPort,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("device-profile application-layer settings updated")
+
+	return nil
+}
+
+// GetApplicationLayerParamsForDevice returns the application-layer settings
+// of the device-profile of the given device.
+func GetApplicationLayerParamsForDevice(ctx context.Context, db sqlx.Queryer, devEUI lorawan.EUI64) (ApplicationLayerParams, error) {
+	var params ApplicationLayerParams
+
+	err := sqlx.Get(db, &params, `
+		select
+			dp.app_layer_multicast_setup_enabled,
+			dp.app_layer_multicast_setup_f_port,
+			dp.app_layer_fragmentation_enabled,
+			dp.app_layer_fragmentation_f_port,
+			dp.app_layer_clock_sync_enabled,
+			dp.app_layer_clock_sync_f_port
+		from
+			device d
+		inner join device_profile dp
+			on dp.device_profile_id = d.device_profile_id
+		where
+			d.dev_eui = $1`,
+		devEUI[:],
+	)
+	if err != nil {
+		return params, handlePSQLError(Select, err, "select error")
+	}
+
+	return params, nil
+}
+
 // DeleteDeviceProfile deletes the device-profile matching the given id.
 func DeleteDeviceProfile(ctx context.Context, db sqlx.Ext, id uuid.UUID) error {
 	n, err := GetNetworkServerForDeviceProfileID(ctx, db, id)
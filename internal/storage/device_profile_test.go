@@ -53,6 +53,80 @@ func TestDeviceProfileValidate(t *testing.T) {
 	}
 }
 
+func TestApplicationLayerParamsValidate(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Params func(p *ApplicationLayerParams)
+		Error  error
+	}{
+		{
+			Name:   "defaults",
+			Params: func(p *ApplicationLayerParams) {},
+		},
+		{
+			Name: "custom fPort",
+			Params: func(p *ApplicationLayerParams) {
+				p.ClockSyncFPort = 100
+			},
+		},
+		{
+			Name: "fPort 0",
+			Params: func(p *ApplicationLayerParams) {
+				p.ClockSyncFPort = 0
+			},
+			Error: ErrDeviceProfileInvalidAppLayerFPort,
+		},
+		{
+			Name: "reserved fPort",
+			Params: func(p *ApplicationLayerParams) {
+				p.FragmentationFPort = 224
+			},
+			Error: ErrDeviceProfileInvalidAppLayerFPort,
+		},
+		{
+			Name: "fPort conflict",
+			Params: func(p *ApplicationLayerParams) {
+				p.ClockSyncFPort = p.FragmentationFPort
+			},
+			Error: ErrDeviceProfileAppLayerFPortConflict,
+		},
+		{
+			Name: "fPort conflict with disabled package",
+			Params: func(p *ApplicationLayerParams) {
+				p.ClockSyncEnabled = false
+				p.ClockSyncFPort = p.FragmentationFPort
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			p := DefaultApplicationLayerParams()
+			tst.Params(&p)
+			assert.Equal(tst.Error, p.Validate())
+		})
+	}
+}
+
+func TestApplicationLayerParamsIsApplicationLayerFPort(t *testing.T) {
+	assert := require.New(t)
+
+	p := DefaultApplicationLayerParams()
+	assert.True(p.IsApplicationLayerFPort(200))
+	assert.True(p.IsApplicationLayerFPort(201))
+	assert.True(p.IsApplicationLayerFPort(202))
+	assert.False(p.IsApplicationLayerFPort(10))
+
+	p.ClockSyncEnabled = false
+	assert.False(p.IsApplicationLayerFPort(202))
+
+	p.FragmentationFPort = 10
+	assert.False(p.IsApplicationLayerFPort(201))
+	assert.True(p.IsApplicationLayerFPort(10))
+}
+
 func (ts *StorageTestSuite) TestDeviceProfile() {
 	assert := require.New(ts.T())
 
@@ -21,25 +21,49 @@ const (
 
 // errors
 var (
-	ErrAlreadyExists                   = errors.New("object already exists")
-	ErrDoesNotExist                    = errors.New("object does not exist")
-	ErrUsedByOtherObjects              = errors.New("this object is used by other objects, remove them first")
-	ErrApplicationInvalidName          = errors.New("invalid application name")
-	ErrNodeInvalidName                 = errors.New("invalid node name")
-	ErrNodeMaxRXDelay                  = errors.New("max value of RXDelay is 15")
-	ErrCFListTooManyChannels           = errors.New("too many channels in channel-list")
-	ErrUserInvalidUsername             = errors.New("username name may only be composed of upper and lower case characters and digits")
-	ErrUserPasswordLength              = errors.New("passwords must be at least 6 characters long")
-	ErrInvalidUsernameOrPassword       = errors.New("invalid username or password")
-	ErrOrganizationInvalidName         = errors.New("invalid organization name")
-	ErrGatewayInvalidName              = errors.New("invalid gateway name")
-	ErrInvalidEmail                    = errors.New("invalid e-mail")
-	ErrInvalidGatewayDiscoveryInterval = errors.New("invalid gateway-discovery interval, it must be greater than 0")
-	ErrDeviceProfileInvalidName        = errors.New("invalid device-profile name")
-	ErrServiceProfileInvalidName       = errors.New("invalid service-profile name")
-	ErrMulticastGroupInvalidName       = errors.New("invalid multicast-group name")
-	ErrOrganizationMaxDeviceCount      = errors.New("organization reached max. device count")
-	ErrOrganizationMaxGatewayCount     = errors.New("organization reached max. gateway count")
+	ErrAlreadyExists                      = errors.New("object already exists")
+	ErrDoesNotExist                       = errors.New("object does not exist")
+	ErrUsedByOtherObjects                 = errors.New("this object is used by other objects, remove them first")
+	ErrApplicationInvalidName             = errors.New("invalid application name")
+	ErrNodeInvalidName                    = errors.New("invalid node name")
+	ErrNodeMaxRXDelay                     = errors.New("max value of RXDelay is 15")
+	ErrCFListTooManyChannels              = errors.New("too many channels in channel-list")
+	ErrUserInvalidUsername                = errors.New("username name may only be composed of upper and lower case characters and digits")
+	ErrUserPasswordLength                 = errors.New("password does not meet the minimum length of the password policy")
+	ErrUserPasswordCharacterClasses       = errors.New("password does not contain the character classes required by the password policy")
+	ErrUserPasswordReused                 = errors.New("password must not match one of the previous passwords")
+	ErrUserPasswordExpired                = errors.New("password has expired and must be changed")
+	ErrInvalidUsernameOrPassword          = errors.New("invalid username or password")
+	ErrOrganizationInvalidName            = errors.New("invalid organization name")
+	ErrGatewayInvalidName                 = errors.New("invalid gateway name")
+	ErrInvalidEmail                       = errors.New("invalid e-mail")
+	ErrInvalidGatewayDiscoveryInterval    = errors.New("invalid gateway-discovery interval, it must be greater than 0")
+	ErrDeviceProfileInvalidName           = errors.New("invalid device-profile name")
+	ErrDeviceProfileInvalidProtobufSchema = errors.New("invalid device-profile protobuf schema")
+	ErrDeviceProfileInvalidAppLayerFPort  = errors.New("application-layer fPort must be between 1 and 223")
+	ErrDeviceProfileAppLayerFPortConflict = errors.New("enabled application-layer packages must use different fPorts")
+	ErrServiceProfileInvalidName          = errors.New("invalid service-profile name")
+	ErrMulticastGroupInvalidName          = errors.New("invalid multicast-group name")
+	ErrOrganizationMaxDeviceCount         = errors.New("organization reached max. device count")
+	ErrOrganizationMaxGatewayCount        = errors.New("organization reached max. gateway count")
+	ErrFirmwareImageInvalidName           = errors.New("invalid firmware image name")
+	ErrFirmwareImageInvalidVersion        = errors.New("invalid firmware image version")
+	ErrFirmwareImageInvalidPayload        = errors.New("invalid firmware image payload")
+	ErrFirmwareImageInvalidDescriptor     = errors.New("firmware image descriptor must be exactly 4 bytes")
+	ErrFirmwareImageInvalidDeviceProfile  = errors.New("device-profile does not exist within the organization of the firmware image")
+	ErrFirmwareImageIncompatible          = errors.New("firmware image is not compatible with the device-profile")
+	ErrFUOTADeploymentInvalidWaves        = errors.New("fuota deployment waves must be increasing percentages, ending at 100")
+	ErrFUOTADeploymentInvalidThreshold    = errors.New("fuota deployment success-rate threshold must be between 0 and 100")
+	ErrFUOTADeploymentInvalidStartWindow  = errors.New("fuota deployment start-time window must end after it starts")
+	ErrTOTPCodeRequired                   = errors.New("two-factor authentication code required")
+	ErrInvalidTOTPCode                    = errors.New("invalid two-factor authentication code")
+	ErrTOTPNotEnabled                     = errors.New("two-factor authentication is not enabled")
+	ErrTOTPRequired                       = errors.New("two-factor authentication is required for admin users")
+	ErrInvalidUserToken                   = errors.New("invalid or expired token")
+	ErrRoleInvalidName                    = errors.New("invalid role name")
+	ErrRoleInvalidOrganization            = errors.New("role does not exist within the organization")
+	ErrRolePredefined                     = errors.New("predefined roles can not be modified")
+	ErrApplicationUserNotOrganizationUser = errors.New("user must be a member of the organization of the application")
 )
 
 func handlePSQLError(action Action, err error, description string) error {
@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// FirmwareImage defines a firmware image which can be deployed using FUOTA.
+type FirmwareImage struct {
+	ID                uuid.UUID `db:"id"`
+	CreatedAt         time.Time `db:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"`
+	OrganizationID    int64     `db:"organization_id"`
+	Name              string    `db:"name"`
+	Version           string    `db:"version"`
+	Description       string    `db:"description"`
+	Payload           []byte    `db:"payload"`
+	SHA256            []byte    `db:"sha256"`
+	Descriptor        []byte    `db:"descriptor"`
+	Signature         []byte    `db:"signature"`
+	SignatureVerified bool      `db:"signature_verified"`
+
+	// DeviceProfileIDs contains the device-profiles the image is compatible
+	// with. When empty, the image is compatible with all device-profiles
+	// of the organization.
+	DeviceProfileIDs []uuid.UUID `db:"-"`
+}
+
+// FirmwareImageListItem defines the firmware image for listing.
+type FirmwareImageListItem struct {
+	ID                uuid.UUID `db:"id"`
+	CreatedAt         time.Time `db:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"`
+	OrganizationID    int64     `db:"organization_id"`
+	Name              string    `db:"name"`
+	Version           string    `db:"version"`
+	Description       string    `db:"description"`
+	Size              int       `db:"size"`
+	SHA256            []byte    `db:"sha256"`
+	SignatureVerified bool      `db:"signature_verified"`
+}
+
+// FirmwareImageFilters provides filters for filtering firmware images.
+type FirmwareImageFilters struct {
+	OrganizationID  int64      `db:"organization_id"`
+	DeviceProfileID *uuid.UUID `db:"device_profile_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filter.
+func (f FirmwareImageFilters) SQL() string {
+	var filters []string
+
+	if f.OrganizationID != 0 {
+		filters = append(filters, "fi.organization_id = :organization_id")
+	}
+
+	// an image without device-profiles is compatible with all
+	// device-profiles
+	if f.DeviceProfileID != nil {
+		filters = append(filters, `(
+			not exists (select 1 from firmware_image_device_profile fidp where fidp.firmware_image_id = fi.id)
+			or exists (select 1 from firmware_image_device_profile fidp where fidp.firmware_image_id = fi.id and fidp.device_profile_id = :device_profile_id)
+		)`)
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// Validate validates the firmware image data.
+func (fi FirmwareImage) Validate() error {
+	if strings.TrimSpace(fi.Name) == "" || len(fi.Name) > 100 {
+		return ErrFirmwareImageInvalidName
+	}
+	if strings.TrimSpace(fi.Version) == "" || len(fi.Version) > 50 {
+		return ErrFirmwareImageInvalidVersion
+	}
+	if len(fi.Payload) == 0 {
+		return ErrFirmwareImageInvalidPayload
+	}
+	if len(fi.Descriptor) != 4 {
+		return ErrFirmwareImageInvalidDescriptor
+	}
+	return nil
+}
+
+// CompatibleWith returns true when the firmware image is compatible with
+// the given device-profile.
+func (fi FirmwareImage) CompatibleWith(deviceProfileID uuid.UUID) bool {
+	if len(fi.DeviceProfileIDs) == 0 {
+		return true
+	}
+
+	for _, id := range fi.DeviceProfileIDs {
+		if id == deviceProfileID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FUOTADescriptor returns the descriptor of the firmware image as used by
+// the fragmentation session setup.
+func (fi FirmwareImage) FUOTADescriptor() [4]byte {
+	var out [4]byte
+	copy(out[:], fi.Descriptor)
+	return out
+}
+
+// CreateFirmwareImage creates the given firmware image. The SHA-256 of the
+// payload is calculated. When no descriptor is set, the first four bytes of
+// the SHA-256 are used as descriptor.
+func CreateFirmwareImage(ctx context.Context, db sqlx.Ext, fi *FirmwareImage) error {
+	sum := sha256.Sum256(fi.Payload)
+	fi.SHA256 = sum[:]
+	if len(fi.Descriptor) == 0 {
+		fi.Descriptor = fi.SHA256[:4]
+	}
+
+	if err := fi.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	var err error
+	fi.ID, err = uuid.NewV4()
+	if err != nil {
+		return errors.Wrap(err, "new uuid v4 error")
+	}
+
+	now := time.Now()
+	fi.CreatedAt = now
+	fi.UpdatedAt = now
+
+	_, err = db.Exec(`
+		insert into firmware_image (
+			id,
+			created_at,
+			updated_at,
+			organization_id,
+			name,
+			version,
+			description,
+			payload,
+			sha256,
+			descriptor,
+			signature,
+			signature_verified
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		fi.ID,
+		fi.CreatedAt,
+		fi.UpdatedAt,
+		fi.OrganizationID,
+		fi.Name,
+		fi.Version,
+		fi.Description,
+		fi.Payload,
+		fi.SHA256,
+		fi.Descriptor,
+		fi.Signature,
+		fi.SignatureVerified,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	if err := setFirmwareImageDeviceProfiles(db, *fi); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"id":              fi.ID,
+		"organization_id": fi.OrganizationID,
+		"version":         fi.Version,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("firmware image created")
+
+	return nil
+}
+
+// GetFirmwareImage returns the firmware image for the given ID.
+func GetFirmwareImage(ctx context.Context, db sqlx.Queryer, id uuid.UUID) (FirmwareImage, error) {
+	var fi FirmwareImage
+
+	err := sqlx.Get(db, &fi, `
+		select
+			*
+		from
+			firmware_image
+		where
+			id = $1`,
+		id,
+	)
+	if err != nil {
+		return fi, handlePSQLError(Select, err, "select error")
+	}
+
+	err = sqlx.Select(db, &fi.DeviceProfileIDs, `
+		select
+			device_profile_id
+		from
+			firmware_image_device_profile
+		where
+			firmware_image_id = $1
+		order by
+			device_profile_id`,
+		id,
+	)
+	if err != nil {
+		return fi, handlePSQLError(Select, err, "select error")
+	}
+
+	return fi, nil
+}
+
+// GetFirmwareImageCount returns the number of firmware images.
+func GetFirmwareImageCount(ctx context.Context, db sqlx.Queryer, filters FirmwareImageFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			firmware_image fi
+	`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetFirmwareImages returns the firmware images, ordered by name and latest
+// created first.
+func GetFirmwareImages(ctx context.Context, db sqlx.Queryer, filters FirmwareImageFilters) ([]FirmwareImageListItem, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			fi.id,
+			fi.created_at,
+			fi.updated_at,
+			fi.organization_id,
+			fi.name,
+			fi.version,
+			fi.description,
+			length(fi.payload) as size,
+			fi.sha256,
+			fi.signature_verified
+		from
+			firmware_image fi
+	`+filters.SQL()+`
+		order by
+			fi.name,
+			fi.created_at desc
+		limit :limit
+		offset :offset
+	`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var items []FirmwareImageListItem
+	if err := sqlx.Select(db, &items, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return items, nil
+}
+
+// UpdateFirmwareImage updates the given firmware image. Note that the
+// payload, version and signature of an image can not be updated.
+func UpdateFirmwareImage(ctx context.Context, db sqlx.Ext, fi *FirmwareImage) error {
+	if err := fi.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	fi.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update firmware_image
+		set
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			descriptor = $5
+		where
+			id = $1`,
+		fi.ID,
+		fi.UpdatedAt,
+		fi.Name,
+		fi.Description,
+		fi.Descriptor,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	if err := setFirmwareImageDeviceProfiles(db, *fi); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"id":     fi.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("firmware image updated")
+
+	return nil
+}
+
+// DeleteFirmwareImage deletes the firmware image for the given ID. An image
+// referenced by a FUOTA deployment can not be deleted.
+func DeleteFirmwareImage(ctx context.Context, db sqlx.Ext, id uuid.UUID) error {
+	res, err := db.Exec(`
+		delete from firmware_image
+		where
+			id = $1`,
+		id,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("firmware image deleted")
+
+	return nil
+}
+
+// setFirmwareImageDeviceProfiles replaces the device-profiles of the given
+// firmware image. The device-profiles must belong to the organization of
+// the image.
+func setFirmwareImageDeviceProfiles(db sqlx.Ext, fi FirmwareImage) error {
+	_, err := db.Exec(`
+		delete from firmware_image_device_profile
+		where
+			firmware_image_id = $1`,
+		fi.ID,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
+	seen := make(map[uuid.UUID]struct{})
+	for _, dpID := range fi.DeviceProfileIDs {
+		if _, ok := seen[dpID]; ok {
+			continue
+		}
+		seen[dpID] = struct{}{}
+
+		res, err := db.Exec(`
+			insert into firmware_image_device_profile (
+				firmware_image_id,
+				device_profile_id
+			)
+			select
+				$1,
+				device_profile_id
+			from
+				device_profile
+			where
+				device_profile_id = $2
+				and organization_id = $3`,
+			fi.ID,
+			dpID,
+			fi.OrganizationID,
+		)
+		if err != nil {
+			return handlePSQLError(Insert, err, "insert error")
+		}
+		ra, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "get rows affected error")
+		}
+		if ra == 0 {
+			return ErrFirmwareImageInvalidDeviceProfile
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/brocaar/lorawan"
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+)
+
+func TestFirmwareImageCompatibleWith(t *testing.T) {
+	assert := require.New(t)
+
+	dpID := uuid.Must(uuid.NewV4())
+	fi := FirmwareImage{}
+	assert.True(fi.CompatibleWith(dpID))
+
+	fi.DeviceProfileIDs = []uuid.UUID{uuid.Must(uuid.NewV4())}
+	assert.False(fi.CompatibleWith(dpID))
+
+	fi.DeviceProfileIDs = append(fi.DeviceProfileIDs, dpID)
+	assert.True(fi.CompatibleWith(dpID))
+}
+
+func (ts *StorageTestSuite) TestFirmwareImage() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	org2 := Organization{
+		Name: "test-org-2",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org2))
+
+	var dpIDs []uuid.UUID
+	for _, o := range []Organization{org, org2} {
+		dp := DeviceProfile{
+			Name:            "test-dp",
+			OrganizationID:  o.ID,
+			NetworkServerID: n.ID,
+		}
+		assert.NoError(CreateDeviceProfile(context.Background(), ts.tx, &dp))
+		var dpID uuid.UUID
+		copy(dpID[:], dp.DeviceProfile.Id)
+		dpIDs = append(dpIDs, dpID)
+	}
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		payload := []byte{1, 2, 3, 4, 5}
+		sum := sha256.Sum256(payload)
+
+		fi := FirmwareImage{
+			OrganizationID:   org.ID,
+			Name:             "test-image",
+			Version:          "1.0.0",
+			Description:      "first release",
+			Payload:          payload,
+			DeviceProfileIDs: []uuid.UUID{dpIDs[0]},
+		}
+		assert.NoError(CreateFirmwareImage(context.Background(), ts.tx, &fi))
+		fi.CreatedAt = fi.CreatedAt.UTC().Round(time.Millisecond)
+		fi.UpdatedAt = fi.UpdatedAt.UTC().Round(time.Millisecond)
+
+		assert.Equal(sum[:], fi.SHA256)
+		assert.Equal(sum[:4], fi.Descriptor)
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			fiGet, err := GetFirmwareImage(context.Background(), ts.tx, fi.ID)
+			assert.NoError(err)
+			fiGet.CreatedAt = fiGet.CreatedAt.UTC().Round(time.Millisecond)
+			fiGet.UpdatedAt = fiGet.UpdatedAt.UTC().Round(time.Millisecond)
+			assert.Equal(fi, fiGet)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			filters := FirmwareImageFilters{
+				OrganizationID:  org.ID,
+				DeviceProfileID: &dpIDs[0],
+				Limit:           10,
+			}
+
+			count, err := GetFirmwareImageCount(context.Background(), ts.tx, filters)
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			items, err := GetFirmwareImages(context.Background(), ts.tx, filters)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(fi.ID, items[0].ID)
+			assert.Equal(len(payload), items[0].Size)
+
+			filters.OrganizationID = org2.ID
+			count, err = GetFirmwareImageCount(context.Background(), ts.tx, filters)
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			invalid := fi
+			invalid.DeviceProfileIDs = []uuid.UUID{dpIDs[1]}
+			err := UpdateFirmwareImage(context.Background(), ts.tx, &invalid)
+			assert.Equal(ErrFirmwareImageInvalidDeviceProfile, errors.Cause(err))
+
+			fi.Name = "updated-image"
+			fi.Descriptor = []byte{1, 2, 3, 4}
+			fi.DeviceProfileIDs = nil
+			assert.NoError(UpdateFirmwareImage(context.Background(), ts.tx, &fi))
+			fi.UpdatedAt = fi.UpdatedAt.UTC().Round(time.Millisecond)
+
+			fiGet, err := GetFirmwareImage(context.Background(), ts.tx, fi.ID)
+			assert.NoError(err)
+			assert.Equal("updated-image", fiGet.Name)
+			assert.Equal([]byte{1, 2, 3, 4}, fiGet.Descriptor)
+			assert.Len(fiGet.DeviceProfileIDs, 0)
+		})
+
+		t.Run("FUOTA deployment referencing the image", func(t *testing.T) {
+			assert := require.New(t)
+
+			sp := ServiceProfile{
+				Name:            "test-sp",
+				OrganizationID:  org.ID,
+				NetworkServerID: n.ID,
+			}
+			assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+			var spID uuid.UUID
+			copy(spID[:], sp.ServiceProfile.Id)
+
+			app := Application{
+				Name:             "test-app",
+				OrganizationID:   org.ID,
+				ServiceProfileID: spID,
+			}
+			assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+			d := Device{
+				DevEUI:          lorawan.EUI64{1, 2, 3, 4, 5, 6, 7, 8},
+				ApplicationID:   app.ID,
+				DeviceProfileID: dpIDs[0],
+				Name:            "test-device",
+			}
+			assert.NoError(CreateDevice(context.Background(), ts.tx, &d))
+
+			fd := FUOTADeployment{
+				Name:            "test deployment",
+				Descriptor:      fi.FUOTADescriptor(),
+				Payload:         payload,
+				UnicastTimeout:  time.Minute,
+				GroupType:       FUOTADeploymentGroupTypeC,
+				FirmwareImageID: &fi.ID,
+			}
+			assert.NoError(CreateFUOTADeploymentForDevice(context.Background(), ts.tx, &fd, d.DevEUI))
+
+			// the payload is read from the image
+			var stored []byte
+			assert.NoError(ts.tx.Get(&stored, "select payload from fuota_deployment where id = $1", fd.ID))
+			assert.Len(stored, 0)
+
+			fdGet, err := GetFUOTADeployment(context.Background(), ts.tx, fd.ID, false)
+			assert.NoError(err)
+			assert.Equal(payload, fdGet.Payload)
+			assert.Equal(&fi.ID, fdGet.FirmwareImageID)
+			assert.Equal([4]byte{1, 2, 3, 4}, fdGet.Descriptor)
+
+			t.Run("Delete image in use", func(t *testing.T) {
+				assert := require.New(t)
+				assert.Equal(ErrUsedByOtherObjects, DeleteFirmwareImage(context.Background(), ts.tx, fi.ID))
+			})
+		})
+	})
+}
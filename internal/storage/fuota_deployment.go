@@ -3,11 +3,14 @@ package storage
 import (
 	"context"
 	"fmt"
+	"math"
+	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
@@ -34,10 +37,17 @@ const (
 	FUOTADeploymentFragmentationSessSetup FUOTADeploymentState = "FRAG_SESS_SETUP"
 	FUOTADeploymentMulticastSessCSetup    FUOTADeploymentState = "MC_SESS_C_SETUP"
 	FUOTADeploymentEnqueue                FUOTADeploymentState = "ENQUEUE"
+	FUOTADeploymentUnicastDelivery        FUOTADeploymentState = "UNICAST_DELIVERY"
 	FUOTADeploymentStatusRequest          FUOTADeploymentState = "STATUS_REQUEST"
 	FUOTADeploymentSetDeviceStatus        FUOTADeploymentState = "SET_DEVICE_STATUS"
 	FUOTADeploymentCleanup                FUOTADeploymentState = "CLEANUP"
+	FUOTADeploymentNextWave               FUOTADeploymentState = "NEXT_WAVE"
+	FUOTADeploymentHalted                 FUOTADeploymentState = "HALTED"
 	FUOTADeploymentDone                   FUOTADeploymentState = "DONE"
+	FUOTADeploymentPaused                 FUOTADeploymentState = "PAUSED"
+	FUOTADeploymentCancel                 FUOTADeploymentState = "CANCEL"
+	FUOTADeploymentCancelCleanup          FUOTADeploymentState = "CANCEL_CLEANUP"
+	FUOTADeploymentCancelled              FUOTADeploymentState = "CANCELLED"
 )
 
 // FUOTADeploymentDeviceState defines the fuota deployment device state.
@@ -48,13 +58,16 @@ const (
 	FUOTADeploymentDevicePending FUOTADeploymentDeviceState = "PENDING"
 	FUOTADeploymentDeviceSuccess FUOTADeploymentDeviceState = "SUCCESS"
 	FUOTADeploymentDeviceError   FUOTADeploymentDeviceState = "ERROR"
+	FUOTADeploymentDeviceWaiting FUOTADeploymentDeviceState = "WAITING"
 )
 
 // FUOTADeploymentGroupType defines the group-type.
 type FUOTADeploymentGroupType string
 
-// FUOTA deployment group types.
+// FUOTA deployment group types. Deployments of group-type A do not use
+// multicast, the fragments are sent to each device through the device-queue.
 const (
+	FUOTADeploymentGroupTypeA FUOTADeploymentGroupType = "A"
 	FUOTADeploymentGroupTypeB FUOTADeploymentGroupType = "B"
 	FUOTADeploymentGroupTypeC FUOTADeploymentGroupType = "C"
 )
@@ -81,6 +94,37 @@ type FUOTADeployment struct {
 	State               FUOTADeploymentState     `db:"state"`
 	UnicastTimeout      time.Duration            `db:"unicast_timeout"`
 	NextStepAfter       time.Time                `db:"next_step_after"`
+
+	// PreviousState holds the state before the deployment was paused or
+	// cancelled. A paused deployment resumes from this state.
+	PreviousState FUOTADeploymentState `db:"previous_state"`
+
+	// FragmentsSent holds the total number of enqueued fragments, including
+	// the repair fragments. For group-type A, it holds the number of
+	// fragments to send to each device.
+	FragmentsSent int `db:"fragments_sent"`
+
+	// RepairCount holds the number of repair sessions and RepairFragments the
+	// number of extra coded fragments to send in the current repair session.
+	RepairCount     int `db:"repair_count"`
+	RepairFragments int `db:"repair_fragments"`
+
+	// FirmwareImageID references the firmware image of the deployment. When
+	// set, the Payload is read from the firmware image.
+	FirmwareImageID *uuid.UUID `db:"firmware_image_id"`
+
+	// StartAfter and StartBefore define the start-time window. Waves are not
+	// started before StartAfter and the deployment is halted when a wave
+	// could not be started before StartBefore.
+	StartAfter  *time.Time `db:"start_after"`
+	StartBefore *time.Time `db:"start_before"`
+
+	// Waves holds the cumulative percentages of the devices per wave (e.g.
+	// 1, 10, 100). A wave is only started when the success-rate (0 - 100)
+	// of the previous wave is at least WaveSuccessThreshold.
+	Waves                []int   `db:"waves"`
+	CurrentWave          int     `db:"current_wave"`
+	WaveSuccessThreshold float64 `db:"wave_success_threshold"`
 }
 
 // FUOTADeploymentListItem defines a FUOTA deployment item for listing.
@@ -102,6 +146,34 @@ type FUOTADeploymentDevice struct {
 	UpdatedAt         time.Time                  `db:"updated_at"`
 	State             FUOTADeploymentDeviceState `db:"state"`
 	ErrorMessage      string                     `db:"error_message"`
+	NbFragReceived    int                        `db:"nb_frag_received"`
+	MissingFrag       int                        `db:"missing_frag"`
+
+	// FragmentsSent holds the number of fragments enqueued for the device
+	// (group-type A only).
+	FragmentsSent int `db:"fragments_sent"`
+
+	// Wave holds the wave of a staged deployment the device belongs to.
+	Wave int `db:"wave"`
+}
+
+// FUOTADeploymentWaveStats contains the device states of a deployment wave.
+type FUOTADeploymentWaveStats struct {
+	Wave    int `db:"wave"`
+	Total   int `db:"total"`
+	Waiting int `db:"waiting"`
+	Pending int `db:"pending"`
+	Success int `db:"success"`
+	Error   int `db:"error"`
+}
+
+// SuccessRate returns the percentage (0 - 100) of successful devices. A
+// wave without devices is considered successful.
+func (s FUOTADeploymentWaveStats) SuccessRate() float64 {
+	if s.Total == 0 {
+		return 100
+	}
+	return float64(s.Success) * 100 / float64(s.Total)
 }
 
 // FUOTADeploymentDeviceListItem defines the Device as FUOTA deployment list item.
@@ -162,7 +234,13 @@ func CreateFUOTADeploymentForDevice(ctx context.Context, db sqlx.Ext, fd *FUOTAD
 	fd.UpdatedAt = now
 	fd.NextStepAfter = now
 	if fd.State == "" {
-		fd.State = FUOTADeploymentMulticastCreate
+		// there is no multicast-group to create when the fragments are
+		// sent through the device-queue
+		if fd.GroupType == FUOTADeploymentGroupTypeA {
+			fd.State = FUOTADeploymentFragmentationSessSetup
+		} else {
+			fd.State = FUOTADeploymentMulticastCreate
+		}
 	}
 
 	_, err = db.Exec(`
@@ -187,8 +265,18 @@ func CreateFUOTADeploymentForDevice(ctx context.Context, db sqlx.Ext, fd *FUOTAD
 			group_type,
 			dr,
 			frequency,
-			ping_slot_period
-		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`,
+			ping_slot_period,
+			previous_state,
+			fragments_sent,
+			repair_count,
+			repair_fragments,
+			firmware_image_id,
+			start_after,
+			start_before,
+			waves,
+			current_wave,
+			wave_success_threshold
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30)`,
 		fd.ID,
 		fd.Type,
 		fd.CreatedAt,
@@ -197,7 +285,7 @@ func CreateFUOTADeploymentForDevice(ctx context.Context, db sqlx.Ext, fd *FUOTAD
 		fd.MulticastGroupID,
 		[]byte{fd.FragmentationMatrix},
 		fd.Descriptor[:],
-		fd.Payload,
+		fd.storedPayload(),
 		fd.State,
 		fd.NextStepAfter,
 		fd.UnicastTimeout,
@@ -209,6 +297,16 @@ func CreateFUOTADeploymentForDevice(ctx context.Context, db sqlx.Ext, fd *FUOTAD
 		fd.DR,
 		fd.Frequency,
 		fd.PingSlotPeriod,
+		fd.PreviousState,
+		fd.FragmentsSent,
+		fd.RepairCount,
+		fd.RepairFragments,
+		fd.FirmwareImageID,
+		fd.StartAfter,
+		fd.StartBefore,
+		pq.Array(fd.Waves),
+		fd.CurrentWave,
+		fd.WaveSuccessThreshold,
 	)
 	if err != nil {
 		return handlePSQLError(Insert, err, "insert error")
@@ -253,12 +351,25 @@ func CreateFUOTADeploymentForGroup(ctx context.Context, db sqlx.Ext, fd *FUOTADe
 		return errors.Wrap(err, "new uuid error")
 	}
 
+	if err := fd.validateRollout(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
 	fd.Type = FUOTADeploymentForGroup
 	fd.CreatedAt = now
 	fd.UpdatedAt = now
 	fd.NextStepAfter = now
 	if fd.State == "" {
-		fd.State = FUOTADeploymentFragmentationSessSetup
+		// staged deployments start the first wave within the start-time
+		// window
+		if fd.Staged() {
+			fd.State = FUOTADeploymentNextWave
+		} else {
+			fd.State = FUOTADeploymentFragmentationSessSetup
+		}
+	}
+	if fd.StartAfter != nil && fd.StartAfter.After(now) {
+		fd.NextStepAfter = *fd.StartAfter
 	}
 
 	_, err = db.Exec(`
@@ -283,8 +394,18 @@ func CreateFUOTADeploymentForGroup(ctx context.Context, db sqlx.Ext, fd *FUOTADe
 			group_type,
 			dr,
 			frequency,
-			ping_slot_period
-		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`,
+			ping_slot_period,
+			previous_state,
+			fragments_sent,
+			repair_count,
+			repair_fragments,
+			firmware_image_id,
+			start_after,
+			start_before,
+			waves,
+			current_wave,
+			wave_success_threshold
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30)`,
 		fd.ID,
 		fd.Type,
 		fd.CreatedAt,
@@ -293,7 +414,7 @@ func CreateFUOTADeploymentForGroup(ctx context.Context, db sqlx.Ext, fd *FUOTADe
 		fd.MulticastGroupID,
 		[]byte{fd.FragmentationMatrix},
 		fd.Descriptor[:],
-		fd.Payload,
+		fd.storedPayload(),
 		fd.State,
 		fd.NextStepAfter,
 		fd.UnicastTimeout,
@@ -305,6 +426,16 @@ func CreateFUOTADeploymentForGroup(ctx context.Context, db sqlx.Ext, fd *FUOTADe
 		fd.DR,
 		fd.Frequency,
 		fd.PingSlotPeriod,
+		fd.PreviousState,
+		fd.FragmentsSent,
+		fd.RepairCount,
+		fd.RepairFragments,
+		fd.FirmwareImageID,
+		fd.StartAfter,
+		fd.StartBefore,
+		pq.Array(fd.Waves),
+		fd.CurrentWave,
+		fd.WaveSuccessThreshold,
 	)
 	if err != nil {
 		return handlePSQLError(Insert, err, "insert error")
@@ -319,7 +450,26 @@ func CreateFUOTADeploymentForGroup(ctx context.Context, db sqlx.Ext, fd *FUOTADe
 		return errors.Wrap(err, "get devices for multicast group error")
 	}
 
-	for _, device := range deviceList {
+	// the devices of a staged deployment are randomly assigned to the
+	// waves, they are waiting until their wave is started
+	state := FUOTADeploymentDevicePending
+	waves := make([]int, len(deviceList))
+	if fd.Staged() {
+		state = FUOTADeploymentDeviceWaiting
+
+		var i int
+		for wave, size := range FUOTADeploymentWaveSizes(len(deviceList), fd.Waves) {
+			for j := 0; j < size; j++ {
+				waves[i] = wave
+				i++
+			}
+		}
+		rand.Shuffle(len(waves), func(i, j int) {
+			waves[i], waves[j] = waves[j], waves[i]
+		})
+	}
+
+	for i, device := range deviceList {
 		_, err = db.Exec(`
 		insert into fuota_deployment_device (
 			fuota_deployment_id,
@@ -327,14 +477,16 @@ func CreateFUOTADeploymentForGroup(ctx context.Context, db sqlx.Ext, fd *FUOTADe
 			created_at,
 			updated_at,
 			state,
-			error_message
-		) values ($1, $2, $3, $4, $5, $6)`,
+			error_message,
+			wave
+		) values ($1, $2, $3, $4, $5, $6, $7)`,
 			fd.ID,
 			device.Device.DevEUI,
 			now,
 			now,
-			FUOTADeploymentDevicePending,
+			state,
 			"",
+			waves[i],
 		)
 		if err != nil {
 			return handlePSQLError(Insert, err, "insert error")
@@ -367,7 +519,7 @@ func GetFUOTADeployment(ctx context.Context, db sqlx.Ext, id uuid.UUID, forUpdat
 			multicast_group_id,
 			fragmentation_matrix,
 			descriptor,
-			payload,
+			coalesce((select fi.payload from firmware_image fi where fi.id = firmware_image_id), payload),
 			state,
 			next_step_after,
 			unicast_timeout,
@@ -378,7 +530,17 @@ func GetFUOTADeployment(ctx context.Context, db sqlx.Ext, id uuid.UUID, forUpdat
 			group_type,
 			dr,
 			frequency,
-			ping_slot_period
+			ping_slot_period,
+			previous_state,
+			fragments_sent,
+			repair_count,
+			repair_fragments,
+			firmware_image_id,
+			start_after,
+			start_before,
+			waves,
+			current_wave,
+			wave_success_threshold
 		from
 			fuota_deployment
 		where
@@ -403,7 +565,7 @@ func GetPendingFUOTADeployments(ctx context.Context, db sqlx.Ext, batchSize int)
 			multicast_group_id,
 			fragmentation_matrix,
 			descriptor,
-			payload,
+			coalesce((select fi.payload from firmware_image fi where fi.id = firmware_image_id), payload),
 			state,
 			next_step_after,
 			unicast_timeout,
@@ -414,18 +576,31 @@ func GetPendingFUOTADeployments(ctx context.Context, db sqlx.Ext, batchSize int)
 			group_type,
 			dr,
 			frequency,
-			ping_slot_period
+			ping_slot_period,
+			previous_state,
+			fragments_sent,
+			repair_count,
+			repair_fragments,
+			firmware_image_id,
+			start_after,
+			start_before,
+			waves,
+			current_wave,
+			wave_success_threshold
 		from
 			fuota_deployment
 		where
-			state != $1
-			and next_step_after <= $2
-		limit $3
+			state not in ($1, $2, $3, $6)
+			and next_step_after <= $4
+		limit $5
 		for update
 		skip locked`,
 		FUOTADeploymentDone,
+		FUOTADeploymentPaused,
+		FUOTADeploymentCancelled,
 		time.Now(),
 		batchSize,
+		FUOTADeploymentHalted,
 	)
 	if err != nil {
 		return nil, handlePSQLError(Select, err, "select error")
@@ -466,7 +641,17 @@ func UpdateFUOTADeployment(ctx context.Context, db sqlx.Ext, fd *FUOTADeployment
 			group_type = $15,
 			dr = $16,
 			frequency = $17,
-			ping_slot_period = $18
+			ping_slot_period = $18,
+			previous_state = $19,
+			fragments_sent = $20,
+			repair_count = $21,
+			repair_fragments = $22,
+			firmware_image_id = $23,
+			start_after = $24,
+			start_before = $25,
+			waves = $26,
+			current_wave = $27,
+			wave_success_threshold = $28
 		where
 			id = $1`,
 		fd.ID,
@@ -475,7 +660,7 @@ func UpdateFUOTADeployment(ctx context.Context, db sqlx.Ext, fd *FUOTADeployment
 		fd.MulticastGroupID,
 		[]byte{fd.FragmentationMatrix},
 		fd.Descriptor[:],
-		fd.Payload,
+		fd.storedPayload(),
 		fd.State,
 		fd.NextStepAfter,
 		fd.UnicastTimeout,
@@ -487,6 +672,16 @@ func UpdateFUOTADeployment(ctx context.Context, db sqlx.Ext, fd *FUOTADeployment
 		fd.DR,
 		fd.Frequency,
 		fd.PingSlotPeriod,
+		fd.PreviousState,
+		fd.FragmentsSent,
+		fd.RepairCount,
+		fd.RepairFragments,
+		fd.FirmwareImageID,
+		fd.StartAfter,
+		fd.StartBefore,
+		pq.Array(fd.Waves),
+		fd.CurrentWave,
+		fd.WaveSuccessThreshold,
 	)
 	if err != nil {
 		return handlePSQLError(Update, err, "update error")
@@ -577,8 +772,13 @@ func GetFUOTADeployments(ctx context.Context, db sqlx.Queryer, filters FUOTADepl
 }
 
 // GetFUOTADeploymentDevice returns the FUOTA deployment record for the given
-// device.
-func GetFUOTADeploymentDevice(ctx context.Context, db sqlx.Queryer, fuotaDeploymentID uuid.UUID, devEUI lorawan.EUI64) (FUOTADeploymentDevice, error) {
+// device. When forUpdate is set to true, then db must be a db transaction.
+func GetFUOTADeploymentDevice(ctx context.Context, db sqlx.Queryer, fuotaDeploymentID uuid.UUID, devEUI lorawan.EUI64, forUpdate bool) (FUOTADeploymentDevice, error) {
+	var fu string
+	if forUpdate {
+		fu = " for update"
+	}
+
 	var out FUOTADeploymentDevice
 	err := sqlx.Get(db, &out, `
 		select
@@ -587,7 +787,7 @@ func GetFUOTADeploymentDevice(ctx context.Context, db sqlx.Queryer, fuotaDeploym
 			fuota_deployment_device
 		where
 			fuota_deployment_id = $1
-			and dev_eui = $2`,
+			and dev_eui = $2`+fu,
 		fuotaDeploymentID,
 		devEUI,
 	)
@@ -630,7 +830,10 @@ func UpdateFUOTADeploymentDevice(ctx context.Context, db sqlx.Ext, fdd *FUOTADep
 		set
 			updated_at = $3,
 			state = $4,
-			error_message = $5
+			error_message = $5,
+			nb_frag_received = $6,
+			missing_frag = $7,
+			fragments_sent = $8
 		where
 			dev_eui = $1
 			and fuota_deployment_id = $2`,
@@ -639,6 +842,9 @@ func UpdateFUOTADeploymentDevice(ctx context.Context, db sqlx.Ext, fdd *FUOTADep
 		fdd.UpdatedAt,
 		fdd.State,
 		fdd.ErrorMessage,
+		fdd.NbFragReceived,
+		fdd.MissingFrag,
+		fdd.FragmentsSent,
 	)
 	if err != nil {
 		return handlePSQLError(Update, err, "update error")
@@ -746,11 +952,152 @@ func GetServiceProfileIDForFUOTADeployment(ctx context.Context, db sqlx.Ext, fuo
 	return out, nil
 }
 
+// StartFUOTADeploymentWave sets the waiting devices of the given wave to
+// pending. It returns the devices of the wave.
+func StartFUOTADeploymentWave(ctx context.Context, db sqlx.Queryer, fuotaDeploymentID uuid.UUID, wave int) ([]lorawan.EUI64, error) {
+	var devEUIs []lorawan.EUI64
+	err := sqlx.Select(db, &devEUIs, `
+		update
+			fuota_deployment_device
+		set
+			updated_at = $5,
+			state = $4
+		where
+			fuota_deployment_id = $1
+			and wave = $2
+			and state = $3
+		returning
+			dev_eui`,
+		fuotaDeploymentID,
+		wave,
+		FUOTADeploymentDeviceWaiting,
+		FUOTADeploymentDevicePending,
+		time.Now(),
+	)
+	if err != nil {
+		return nil, handlePSQLError(Update, err, "update error")
+	}
+
+	log.WithFields(log.Fields{
+		"id":      fuotaDeploymentID,
+		"wave":    wave,
+		"devices": len(devEUIs),
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("fuota deployment wave started")
+
+	return devEUIs, nil
+}
+
+// GetFUOTADeploymentWaveStats returns the device states per wave of the
+// given FUOTA deployment.
+func GetFUOTADeploymentWaveStats(ctx context.Context, db sqlx.Queryer, fuotaDeploymentID uuid.UUID) ([]FUOTADeploymentWaveStats, error) {
+	var out []FUOTADeploymentWaveStats
+
+	err := sqlx.Select(db, &out, `
+		select
+			wave,
+			count(*) as total,
+			sum(case when state = $2 then 1 else 0 end) as waiting,
+			sum(case when state = $3 then 1 else 0 end) as pending,
+			sum(case when state = $4 then 1 else 0 end) as success,
+			sum(case when state = $5 then 1 else 0 end) as error
+		from
+			fuota_deployment_device
+		where
+			fuota_deployment_id = $1
+		group by
+			wave
+		order by
+			wave`,
+		fuotaDeploymentID,
+		FUOTADeploymentDeviceWaiting,
+		FUOTADeploymentDevicePending,
+		FUOTADeploymentDeviceSuccess,
+		FUOTADeploymentDeviceError,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return out, nil
+}
+
+// FUOTADeploymentWaveSizes returns the number of devices per wave, given
+// the total number of devices and the cumulative percentages of the waves.
+// Each wave contains at least one device, as long as there are devices
+// left.
+func FUOTADeploymentWaveSizes(devices int, waves []int) []int {
+	if len(waves) == 0 {
+		return []int{devices}
+	}
+
+	out := make([]int, len(waves))
+	var assigned int
+	for i, pct := range waves {
+		n := int(math.Ceil(float64(devices*pct) / 100))
+		if i == len(waves)-1 || n > devices {
+			n = devices
+		}
+		if n <= assigned && assigned < devices {
+			n = assigned + 1
+		}
+		out[i] = n - assigned
+		assigned = n
+	}
+
+	return out
+}
+
+// Staged returns true when the deployment is started in waves or within a
+// start-time window.
+func (fd FUOTADeployment) Staged() bool {
+	return len(fd.Waves) != 0 || fd.StartAfter != nil || fd.StartBefore != nil
+}
+
+// WaveCount returns the number of waves of the deployment.
+func (fd FUOTADeployment) WaveCount() int {
+	if len(fd.Waves) == 0 {
+		return 1
+	}
+	return len(fd.Waves)
+}
+
+func (fd FUOTADeployment) validateRollout() error {
+	for i, pct := range fd.Waves {
+		if pct <= 0 || pct > 100 || (i > 0 && pct <= fd.Waves[i-1]) {
+			return ErrFUOTADeploymentInvalidWaves
+		}
+	}
+	if len(fd.Waves) != 0 && fd.Waves[len(fd.Waves)-1] != 100 {
+		return ErrFUOTADeploymentInvalidWaves
+	}
+
+	if fd.WaveSuccessThreshold < 0 || fd.WaveSuccessThreshold > 100 {
+		return ErrFUOTADeploymentInvalidThreshold
+	}
+
+	if fd.StartAfter != nil && fd.StartBefore != nil && !fd.StartBefore.After(*fd.StartAfter) {
+		return ErrFUOTADeploymentInvalidStartWindow
+	}
+
+	return nil
+}
+
+// storedPayload returns the payload to store in the fuota_deployment table.
+// The payload of a deployment referencing a firmware image is not duplicated.
+func (fd FUOTADeployment) storedPayload() []byte {
+	if fd.FirmwareImageID != nil {
+		return []byte{}
+	}
+	return fd.Payload
+}
+
 func scanFUOTADeployment(row sqlx.ColScanner) (FUOTADeployment, error) {
 	var fd FUOTADeployment
 
 	var fragmentationMatrix []byte
 	var descriptor []byte
+	var waves []int64
 
 	err := row.Scan(
 		&fd.ID,
@@ -773,6 +1120,16 @@ func scanFUOTADeployment(row sqlx.ColScanner) (FUOTADeployment, error) {
 		&fd.DR,
 		&fd.Frequency,
 		&fd.PingSlotPeriod,
+		&fd.PreviousState,
+		&fd.FragmentsSent,
+		&fd.RepairCount,
+		&fd.RepairFragments,
+		&fd.FirmwareImageID,
+		&fd.StartAfter,
+		&fd.StartBefore,
+		pq.Array(&waves),
+		&fd.CurrentWave,
+		&fd.WaveSuccessThreshold,
 	)
 	if err != nil {
 		return fd, handlePSQLError(Select, err, "select error")
@@ -788,5 +1145,9 @@ func scanFUOTADeployment(row sqlx.ColScanner) (FUOTADeployment, error) {
 	}
 	copy(fd.Descriptor[:], descriptor)
 
+	for _, w := range waves {
+		fd.Waves = append(fd.Waves, int(w))
+	}
+
 	return fd, nil
 }
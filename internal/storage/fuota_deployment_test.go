@@ -13,6 +13,103 @@ import (
 	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
 )
 
+func TestFUOTADeploymentWaveSizes(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Devices  int
+		Waves    []int
+		Expected []int
+	}{
+		{
+			Name:     "no waves",
+			Devices:  10,
+			Expected: []int{10},
+		},
+		{
+			Name:     "canary",
+			Devices:  10000,
+			Waves:    []int{1, 10, 100},
+			Expected: []int{100, 900, 9000},
+		},
+		{
+			Name:     "at least one device per wave",
+			Devices:  10,
+			Waves:    []int{1, 10, 100},
+			Expected: []int{1, 1, 8},
+		},
+		{
+			Name:     "less devices than waves",
+			Devices:  2,
+			Waves:    []int{1, 10, 100},
+			Expected: []int{1, 1, 0},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.Expected, FUOTADeploymentWaveSizes(tst.Devices, tst.Waves))
+		})
+	}
+}
+
+func TestFUOTADeploymentValidateRollout(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		Name          string
+		Deployment    FUOTADeployment
+		ExpectedError error
+	}{
+		{
+			Name: "valid",
+			Deployment: FUOTADeployment{
+				Waves:                []int{1, 10, 100},
+				WaveSuccessThreshold: 95,
+				StartAfter:           &now,
+				StartBefore:          &later,
+			},
+		},
+		{
+			Name: "waves not increasing",
+			Deployment: FUOTADeployment{
+				Waves: []int{10, 10, 100},
+			},
+			ExpectedError: ErrFUOTADeploymentInvalidWaves,
+		},
+		{
+			Name: "last wave is not 100",
+			Deployment: FUOTADeployment{
+				Waves: []int{1, 10},
+			},
+			ExpectedError: ErrFUOTADeploymentInvalidWaves,
+		},
+		{
+			Name: "invalid threshold",
+			Deployment: FUOTADeployment{
+				WaveSuccessThreshold: 101,
+			},
+			ExpectedError: ErrFUOTADeploymentInvalidThreshold,
+		},
+		{
+			Name: "invalid start-time window",
+			Deployment: FUOTADeployment{
+				StartAfter:  &later,
+				StartBefore: &now,
+			},
+			ExpectedError: ErrFUOTADeploymentInvalidStartWindow,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.Name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.ExpectedError, tst.Deployment.validateRollout())
+		})
+	}
+}
+
 func (ts *StorageTestSuite) TestFUOTADeployment() {
 	assert := require.New(ts.T())
 
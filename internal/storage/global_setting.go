@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// Global settings.
+const (
+	// GlobalSettingRequireAdminTOTP defines if two-factor authentication is
+	// required for global and organization admin users.
+	GlobalSettingRequireAdminTOTP = "require_admin_totp"
+)
+
+// GetGlobalSetting decodes the value of the given global setting into v.
+// It returns ErrDoesNotExist when the setting has not been set.
+func GetGlobalSetting(ctx context.Context, db sqlx.Queryer, key string, v interface{}) error {
+	var b []byte
+	err := sqlx.Get(db, &b, `
+		select
+			value
+		from
+			global_setting
+		where
+			key = $1`,
+		key,
+	)
+	if err != nil {
+		return handlePSQLError(Select, err, "select error")
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrap(err, "unmarshal json error")
+	}
+
+	return nil
+}
+
+// SetGlobalSetting sets the given global setting to v.
+func SetGlobalSetting(ctx context.Context, db sqlx.Execer, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal json error")
+	}
+
+	_, err = db.Exec(`
+		insert into global_setting (
+			key,
+			updated_at,
+			value
+		) values ($1, $2, $3)
+		on conflict (key) do update
+		set
+			updated_at = excluded.updated_at,
+			value = excluded.value`,
+		key,
+		time.Now(),
+		b,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "upsert error")
+	}
+
+	log.WithFields(log.Fields{
+		"key":    key,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("storage: global setting updated")
+
+	return nil
+}
@@ -44,6 +44,10 @@ type OrganizationUser struct {
 	IsGatewayAdmin bool      `db:"is_gateway_admin"`
 	CreatedAt      time.Time `db:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at"`
+
+	// RoleID contains the role assigned to the user. When set, the role
+	// replaces the admin flags.
+	RoleID *int64 `db:"role_id"`
 }
 
 // CreateOrganization creates the given Organization.
@@ -346,8 +350,24 @@ func UpdateOrganizationUser(ctx context.Context, db sqlx.Execer, organizationID,
 	return nil
 }
 
-// DeleteOrganizationUser deletes the given organization user.
+// DeleteOrganizationUser deletes the given organization user, including
+// the application users of the user within the organization.
 func DeleteOrganizationUser(ctx context.Context, db sqlx.Execer, organizationID, userID int64) error {
+	// application users must be a member of the organization
+	_, err := db.Exec(`
+		delete from application_user au
+		using application a
+		where
+			a.id = au.application_id
+			and a.organization_id = $1
+			and au.user_id = $2`,
+		organizationID,
+		userID,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
 	res, err := db.Exec(`delete from organization_user where organization_id = $1 and user_id = $2`, organizationID, userID)
 	if err != nil {
 		return handlePSQLError(Delete, err, "delete error")
@@ -379,7 +399,8 @@ func GetOrganizationUser(ctx context.Context, db sqlx.Queryer, organizationID, u
 			ou.updated_at as updated_at,
 			ou.is_admin as is_admin,
 			ou.is_device_admin as is_device_admin,
-			ou.is_gateway_admin as is_gateway_admin
+			ou.is_gateway_admin as is_gateway_admin,
+			ou.role_id as role_id
 		from organization_user ou
 		inner join "user" u
 			on u.id = ou.user_id
@@ -422,7 +443,8 @@ func GetOrganizationUsers(ctx context.Context, db sqlx.Queryer, organizationID i
 			ou.updated_at as updated_at,
 			ou.is_admin as is_admin,
 			ou.is_device_admin as is_device_admin,
-			ou.is_gateway_admin as is_gateway_admin
+			ou.is_gateway_admin as is_gateway_admin,
+			ou.role_id as role_id
 		from organization_user ou
 		inner join "user" u
 			on u.id = ou.user_id
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// PasswordPolicy defines the policy which applies when setting the password
+// of a user.
+type PasswordPolicy struct {
+	MinLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSpecial   bool
+
+	// HistorySize defines the number of previous passwords which can not be
+	// reused.
+	HistorySize int
+
+	// MaxAge defines the duration after which a password expires.
+	MaxAge time.Duration
+}
+
+// passwordPolicy holds the configured password policy.
+var passwordPolicy = PasswordPolicy{
+	MinLength: 6,
+}
+
+// SetPasswordPolicy sets the password policy.
+func SetPasswordPolicy(p PasswordPolicy) {
+	passwordPolicy = p
+}
+
+// Validate validates the given password against the policy.
+func (p PasswordPolicy) Validate(pw string) error {
+	if utf8.RuneCountInString(pw) < p.MinLength {
+		return ErrUserPasswordLength
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			special = true
+		}
+	}
+
+	if (p.RequireUppercase && !upper) || (p.RequireLowercase && !lower) || (p.RequireDigit && !digit) || (p.RequireSpecial && !special) {
+		return ErrUserPasswordCharacterClasses
+	}
+
+	return nil
+}
+
+// UpdateUserPassword validates the given password against the password
+// policy (including the password history), sets it and updates the user.
+func UpdateUserPassword(ctx context.Context, db sqlx.Ext, u *User, pw string) error {
+	if passwordPolicy.HistorySize > 0 {
+		// the current password might have been set before the history
+		// was recorded
+		if u.PasswordHash != "" && hashCompare(pw, u.PasswordHash) {
+			return ErrUserPasswordReused
+		}
+
+		var hashes []string
+		err := sqlx.Select(db, &hashes, `
+			select
+				password_hash
+			from
+				user_password_history
+			where
+				user_id = $1
+			order by
+				created_at desc,
+				id desc
+			limit $2`,
+			u.ID,
+			passwordPolicy.HistorySize,
+		)
+		if err != nil {
+			return handlePSQLError(Select, err, "select error")
+		}
+
+		for _, h := range hashes {
+			if hashCompare(pw, h) {
+				return ErrUserPasswordReused
+			}
+		}
+	}
+
+	if err := u.SetPasswordHash(pw); err != nil {
+		return err
+	}
+
+	if err := UpdateUser(ctx, db, u); err != nil {
+		return err
+	}
+
+	if passwordPolicy.HistorySize > 0 {
+		if err := createUserPasswordHistory(db, u.ID, u.PasswordHash); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"id":     u.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user password updated")
+
+	return nil
+}
+
+// createUserPasswordHistory records the given password hash into the
+// password history of the user and removes the entries which are no longer
+// needed.
+func createUserPasswordHistory(db sqlx.Execer, userID int64, passwordHash string) error {
+	_, err := db.Exec(`
+		insert into user_password_history (
+			user_id,
+			created_at,
+			password_hash
+		) values ($1, $2, $3)`,
+		userID,
+		time.Now(),
+		passwordHash,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	// remove the entries which are no longer needed
+	_, err = db.Exec(`
+		delete from
+			user_password_history
+		where
+			user_id = $1
+			and id not in (
+				select
+					id
+				from
+					user_password_history
+				where
+					user_id = $1
+				order by
+					created_at desc,
+					id desc
+				limit $2
+			)`,
+		userID,
+		passwordPolicy.HistorySize,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+
+	return nil
+}
+
+// PasswordExpired returns true when the password of the user has expired
+// according to the password policy.
+func (u User) PasswordExpired() bool {
+	if passwordPolicy.MaxAge <= 0 || u.PasswordHash == "" {
+		return false
+	}
+
+	changedAt := u.CreatedAt
+	if u.PasswordChangedAt != nil {
+		changedAt = *u.PasswordChangedAt
+	}
+
+	return time.Since(changedAt) > passwordPolicy.MaxAge
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		err      error
+	}{
+		{
+			name:     "valid password",
+			policy:   PasswordPolicy{MinLength: 6},
+			password: "secret",
+		},
+		{
+			name:     "too short",
+			policy:   PasswordPolicy{MinLength: 6},
+			password: "short",
+			err:      ErrUserPasswordLength,
+		},
+		{
+			name:     "length is counted in characters",
+			policy:   PasswordPolicy{MinLength: 6},
+			password: "pässwö",
+		},
+		{
+			name: "all character classes",
+			policy: PasswordPolicy{
+				MinLength:        8,
+				RequireUppercase: true,
+				RequireLowercase: true,
+				RequireDigit:     true,
+				RequireSpecial:   true,
+			},
+			password: "Secret1!",
+		},
+		{
+			name:     "missing uppercase",
+			policy:   PasswordPolicy{RequireUppercase: true},
+			password: "secret",
+			err:      ErrUserPasswordCharacterClasses,
+		},
+		{
+			name:     "missing lowercase",
+			policy:   PasswordPolicy{RequireLowercase: true},
+			password: "SECRET",
+			err:      ErrUserPasswordCharacterClasses,
+		},
+		{
+			name:     "missing digit",
+			policy:   PasswordPolicy{RequireDigit: true},
+			password: "secret",
+			err:      ErrUserPasswordCharacterClasses,
+		},
+		{
+			name:     "missing special character",
+			policy:   PasswordPolicy{RequireSpecial: true},
+			password: "Secret1",
+			err:      ErrUserPasswordCharacterClasses,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tst.err, tst.policy.Validate(tst.password))
+		})
+	}
+}
+
+func TestUserPasswordExpired(t *testing.T) {
+	defer SetPasswordPolicy(passwordPolicy)
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		user     User
+		expected bool
+	}{
+		{
+			name:   "no max age",
+			maxAge: 0,
+			user: User{
+				PasswordHash:      "hash",
+				PasswordChangedAt: &old,
+			},
+		},
+		{
+			name:   "changed recently",
+			maxAge: 24 * time.Hour,
+			user: User{
+				PasswordHash:      "hash",
+				PasswordChangedAt: &now,
+			},
+		},
+		{
+			name:   "expired",
+			maxAge: 24 * time.Hour,
+			user: User{
+				PasswordHash:      "hash",
+				PasswordChangedAt: &old,
+			},
+			expected: true,
+		},
+		{
+			name:   "expired, fallback to created at",
+			maxAge: 24 * time.Hour,
+			user: User{
+				CreatedAt:    old,
+				PasswordHash: "hash",
+			},
+			expected: true,
+		},
+		{
+			name:   "no password (external user)",
+			maxAge: 24 * time.Hour,
+			user: User{
+				CreatedAt: old,
+			},
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			SetPasswordPolicy(PasswordPolicy{MaxAge: tst.maxAge})
+			assert.Equal(tst.expected, tst.user.PasswordExpired())
+		})
+	}
+}
+
+func (ts *StorageTestSuite) TestUpdateUserPassword() {
+	assert := require.New(ts.T())
+
+	defer SetPasswordPolicy(passwordPolicy)
+	SetPasswordPolicy(PasswordPolicy{
+		MinLength:   6,
+		HistorySize: 2,
+	})
+
+	user := User{
+		Email:    "password@example.com",
+		IsActive: true,
+	}
+	assert.NoError(user.SetPasswordHash("password1"))
+	assert.NoError(CreateUser(context.Background(), ts.tx, &user))
+
+	ts.T().Run("Current password", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(ErrUserPasswordReused, UpdateUserPassword(context.Background(), ts.tx, &user, "password1"))
+	})
+
+	ts.T().Run("Too short", func(t *testing.T) {
+		assert := require.New(t)
+		assert.Equal(ErrUserPasswordLength, UpdateUserPassword(context.Background(), ts.tx, &user, "pw"))
+	})
+
+	ts.T().Run("Update", func(t *testing.T) {
+		assert := require.New(t)
+
+		assert.NoError(UpdateUserPassword(context.Background(), ts.tx, &user, "password2"))
+		assert.NoError(UpdateUserPassword(context.Background(), ts.tx, &user, "password3"))
+
+		_, err := GetUserByEmailAndPassword(context.Background(), ts.tx, user.Email, "password3")
+		assert.NoError(err)
+
+		t.Run("Reuse password in history", func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(ErrUserPasswordReused, UpdateUserPassword(context.Background(), ts.tx, &user, "password2"))
+		})
+
+		t.Run("Reuse password outside history", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateUserPassword(context.Background(), ts.tx, &user, "password4"))
+			assert.NoError(UpdateUserPassword(context.Background(), ts.tx, &user, "password2"))
+		})
+	})
+}
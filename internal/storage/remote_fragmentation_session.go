@@ -32,6 +32,14 @@ type RemoteFragmentationSession struct {
 	RetryAfter          time.Time                 `db:"retry_after"`
 	RetryCount          int                       `db:"retry_count"`
 	RetryInterval       time.Duration             `db:"retry_interval"`
+
+	// Fragmentation package v2 fields. The MIC is computed over the data
+	// block and DataBlockAuthOK contains the result of the data block
+	// authentication by the device (nil when not yet reported).
+	PackageVersion  int     `db:"package_version"`
+	SessionCnt      int     `db:"session_cnt"`
+	MIC             [4]byte `db:"mic"`
+	DataBlockAuthOK *bool   `db:"data_block_auth_ok"`
 }
 
 // CreateRemoteFragmentationSession creates the given fragmentation session.
@@ -57,8 +65,12 @@ func CreateRemoteFragmentationSession(ctx context.Context, db sqlx.Ext, sess *Re
 			state_provisioned,
 			retry_after,
 			retry_count,
-			retry_interval
-		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+			retry_interval,
+			package_version,
+			session_cnt,
+			mic,
+			data_block_auth_ok
+		) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`,
 		sess.DevEUI,
 		sess.FragIndex,
 		sess.CreatedAt,
@@ -75,6 +87,10 @@ func CreateRemoteFragmentationSession(ctx context.Context, db sqlx.Ext, sess *Re
 		sess.RetryAfter,
 		sess.RetryCount,
 		sess.RetryInterval,
+		sess.PackageVersion,
+		sess.SessionCnt,
+		sess.MIC[:],
+		sess.DataBlockAuthOK,
 	)
 	if err != nil {
 		return handlePSQLError(Insert, err, "insert error")
@@ -113,7 +129,11 @@ func GetRemoteFragmentationSession(ctx context.Context, db sqlx.Queryer, devEUI
 			state_provisioned,
 			retry_after,
 			retry_count,
-			retry_interval
+			retry_interval,
+			package_version,
+			session_cnt,
+			mic,
+			data_block_auth_ok
 		from
 			remote_fragmentation_session
 		where
@@ -148,7 +168,11 @@ func GetPendingRemoteFragmentationSessions(ctx context.Context, db sqlx.Queryer,
 			fs.state_provisioned,
 			fs.retry_after,
 			fs.retry_count,
-			fs.retry_interval
+			fs.retry_interval,
+			fs.package_version,
+			fs.session_cnt,
+			fs.mic,
+			fs.data_block_auth_ok
 		from
 			remote_fragmentation_session fs
 		where
@@ -214,7 +238,11 @@ func UpdateRemoteFragmentationSession(ctx context.Context, db sqlx.Ext, sess *Re
 			state_provisioned = $12,
 			retry_after = $13,
 			retry_count = $14,
-			retry_interval = $15
+			retry_interval = $15,
+			package_version = $16,
+			session_cnt = $17,
+			mic = $18,
+			data_block_auth_ok = $19
 		where
 			dev_eui = $1
 			and frag_index = $2`,
@@ -233,6 +261,10 @@ func UpdateRemoteFragmentationSession(ctx context.Context, db sqlx.Ext, sess *Re
 		sess.RetryAfter,
 		sess.RetryCount,
 		sess.RetryInterval,
+		sess.PackageVersion,
+		sess.SessionCnt,
+		sess.MIC[:],
+		sess.DataBlockAuthOK,
 	)
 	if err != nil {
 		return handlePSQLError(Update, err, "update error")
@@ -289,6 +321,7 @@ func scanRemoteFragmentationSession(row sqlx.ColScanner) (RemoteFragmentationSes
 	var mcGroupIDs []int64
 	var fragmentationMatrix []byte
 	var descriptor []byte
+	var mic []byte
 
 	err := row.Scan(
 		&sess.DevEUI,
@@ -307,6 +340,10 @@ func scanRemoteFragmentationSession(row sqlx.ColScanner) (RemoteFragmentationSes
 		&sess.RetryAfter,
 		&sess.RetryCount,
 		&sess.RetryInterval,
+		&sess.PackageVersion,
+		&sess.SessionCnt,
+		&mic,
+		&sess.DataBlockAuthOK,
 	)
 	if err != nil {
 		return sess, handlePSQLError(Select, err, "select error")
@@ -326,5 +363,10 @@ func scanRemoteFragmentationSession(row sqlx.ColScanner) (RemoteFragmentationSes
 	}
 	copy(sess.Descriptor[:], descriptor)
 
+	if len(mic) != len(sess.MIC) {
+		return sess, fmt.Errorf("MIC must have length %d, got %d", len(sess.MIC), len(mic))
+	}
+	copy(sess.MIC[:], mic)
+
 	return sess, nil
 }
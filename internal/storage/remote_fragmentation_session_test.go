@@ -101,6 +101,9 @@ func (ts *StorageTestSuite) TestRemoteFragmentationSession() {
 			RetryAfter:          now,
 			RetryCount:          1,
 			RetryInterval:       time.Minute,
+			PackageVersion:      2,
+			SessionCnt:          3,
+			MIC:                 [4]byte{5, 6, 7, 8},
 		}
 		assert.NoError(CreateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
 		rfs.CreatedAt = rfs.CreatedAt.UTC().Round(time.Millisecond)
@@ -176,6 +179,8 @@ func (ts *StorageTestSuite) TestRemoteFragmentationSession() {
 			rfs.RetryAfter = now
 			rfs.RetryCount = 2
 			rfs.RetryInterval = time.Minute * 2
+			authOK := true
+			rfs.DataBlockAuthOK = &authOK
 
 			assert.NoError(UpdateRemoteFragmentationSession(context.Background(), ts.tx, &rfs))
 			rfs.UpdatedAt = rfs.UpdatedAt.UTC().Round(time.Millisecond)
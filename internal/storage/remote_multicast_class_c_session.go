@@ -167,6 +167,28 @@ func GetPendingRemoteMulticastClassCSessions(ctx context.Context, db sqlx.Querye
 	return items, nil
 }
 
+// GetRemoteMulticastClassCSessionsForMulticastGroup returns the multicast
+// Class-C session records of all devices of the given multicast-group.
+func GetRemoteMulticastClassCSessionsForMulticastGroup(ctx context.Context, db sqlx.Queryer, multicastGroupID uuid.UUID) ([]RemoteMulticastClassCSession, error) {
+	var items []RemoteMulticastClassCSession
+
+	if err := sqlx.Select(db, &items, `
+		select
+			*
+		from
+			remote_multicast_class_c_session
+		where
+			multicast_group_id = $1
+		order by
+			dev_eui`,
+		multicastGroupID,
+	); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return items, nil
+}
+
 // UpdateRemoteMulticastClassCSession updates the given remote multicast
 // Class-C session.
 func UpdateRemoteMulticastClassCSession(ctx context.Context, db sqlx.Ext, sess *RemoteMulticastClassCSession) error {
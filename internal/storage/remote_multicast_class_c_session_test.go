@@ -118,6 +118,15 @@ func (ts *StorageTestSuite) TestRemoteMulticastClassCSession() {
 			assert.Equal(sess, sessGet)
 		})
 
+		t.Run("GetForMulticastGroup", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetRemoteMulticastClassCSessionsForMulticastGroup(context.Background(), ts.tx, mgID)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(d.DevEUI, items[0].DevEUI)
+		})
+
 		t.Run("GetPending no setup", func(t *testing.T) {
 			assert := require.New(t)
 
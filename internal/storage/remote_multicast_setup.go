@@ -186,6 +186,28 @@ func GetRemoteMulticastSetupItemsByDevice(ctx context.Context, db sqlx.Queryer,
 	return items, nil
 }
 
+// GetRemoteMulticastSetupItemsForMulticastGroup returns the multicast-setup
+// records of all devices of the given multicast-group.
+func GetRemoteMulticastSetupItemsForMulticastGroup(ctx context.Context, db sqlx.Queryer, multicastGroupID uuid.UUID) ([]RemoteMulticastSetup, error) {
+	var items []RemoteMulticastSetup
+
+	if err := sqlx.Select(db, &items, `
+		select
+			*
+		from
+			remote_multicast_setup
+		where
+			multicast_group_id = $1
+		order by
+			dev_eui`,
+		multicastGroupID,
+	); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return items, nil
+}
+
 // UpdateRemoteMulticastSetup updates the given update multicast-group setup.
 func UpdateRemoteMulticastSetup(ctx context.Context, db sqlx.Ext, dmg *RemoteMulticastSetup) error {
 	dmg.UpdatedAt = time.Now()
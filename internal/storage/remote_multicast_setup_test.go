@@ -107,6 +107,15 @@ func (ts *StorageTestSuite) TestRemoteMulticastSetup() {
 			assert.Equal(dmg, dmgGet)
 		})
 
+		t.Run("GetForMulticastGroup", func(t *testing.T) {
+			assert := require.New(t)
+
+			items, err := GetRemoteMulticastSetupItemsForMulticastGroup(context.Background(), ts.tx, mgID)
+			assert.NoError(err)
+			assert.Len(items, 1)
+			assert.Equal(d.DevEUI, items[0].DevEUI)
+		})
+
 		t.Run("GetPending", func(t *testing.T) {
 			assert := require.New(t)
 
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+// Predefined roles, which are available to all organizations.
+const (
+	// RoleViewer can see the application and its devices.
+	RoleViewer = "viewer"
+
+	// RoleOperator can manage the devices and the device-queue of the
+	// application.
+	RoleOperator = "operator"
+
+	// RoleAdmin can update the application and manage its devices and the
+	// device-keys.
+	RoleAdmin = "admin"
+)
+
+// Role defines a set of permissions which can be assigned to the users of
+// an organization, or to the users of a single application. The
+// predefined roles do not have an organization and can not be updated or
+// deleted.
+type Role struct {
+	ID             int64     `db:"id"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+	OrganizationID *int64    `db:"organization_id"`
+	Name           string    `db:"name"`
+	Description    string    `db:"description"`
+
+	// Permissions contains the granted permissions, in the format
+	// <resource>:<action> (e.g. gateway:read).
+	Permissions pq.StringArray `db:"permissions"`
+}
+
+// RoleFilters provides filters for filtering roles. The predefined roles
+// are always included.
+type RoleFilters struct {
+	OrganizationID int64 `db:"organization_id"`
+
+	// Limit and Offset are added for convenience so that this struct can
+	// be given as the arguments.
+	Limit  int `db:"limit"`
+	Offset int `db:"offset"`
+}
+
+// SQL returns the SQL filter.
+func (f RoleFilters) SQL() string {
+	var filters []string
+
+	if f.OrganizationID != 0 {
+		filters = append(filters, "(r.organization_id = :organization_id or r.organization_id is null)")
+	}
+
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return "where " + strings.Join(filters, " and ")
+}
+
+// Validate validates the role data.
+func (r Role) Validate() error {
+	if r.OrganizationID == nil {
+		return ErrRolePredefined
+	}
+	if strings.TrimSpace(r.Name) == "" || len(r.Name) > 100 {
+		return ErrRoleInvalidName
+	}
+	return nil
+}
+
+// CreateRole creates the given role.
+func CreateRole(ctx context.Context, db sqlx.Queryer, r *Role) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	if r.Permissions == nil {
+		r.Permissions = pq.StringArray{}
+	}
+
+	now := time.Now()
+
+	err := sqlx.Get(db, &r.ID, `
+		insert into role (
+			created_at,
+			updated_at,
+			organization_id,
+			name,
+			description,
+			permissions
+		) values ($1, $2, $3, $4, $5, $6) returning id`,
+		now,
+		now,
+		r.OrganizationID,
+		r.Name,
+		r.Description,
+		r.Permissions,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+	r.CreatedAt = now
+	r.UpdatedAt = now
+
+	log.WithFields(log.Fields{
+		"id":              r.ID,
+		"organization_id": r.OrganizationID,
+		"name":            r.Name,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("role created")
+
+	return nil
+}
+
+// GetRole returns the role for the given ID.
+func GetRole(ctx context.Context, db sqlx.Queryer, id int64) (Role, error) {
+	var r Role
+
+	err := sqlx.Get(db, &r, `
+		select
+			*
+		from
+			role
+		where
+			id = $1`,
+		id,
+	)
+	if err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// GetPredefinedRole returns the predefined role for the given name.
+func GetPredefinedRole(ctx context.Context, db sqlx.Queryer, name string) (Role, error) {
+	var r Role
+
+	err := sqlx.Get(db, &r, `
+		select
+			*
+		from
+			role
+		where
+			organization_id is null
+			and name = $1`,
+		name,
+	)
+	if err != nil {
+		return r, handlePSQLError(Select, err, "select error")
+	}
+
+	return r, nil
+}
+
+// GetRoleCount returns the number of roles.
+func GetRoleCount(ctx context.Context, db sqlx.Queryer, filters RoleFilters) (int, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			count(*)
+		from
+			role r
+	`+filters.SQL(), filters)
+	if err != nil {
+		return 0, errors.Wrap(err, "named query error")
+	}
+
+	var count int
+	if err := sqlx.Get(db, &count, query, args...); err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// GetRoles returns the roles, the predefined roles first and then ordered
+// by name.
+func GetRoles(ctx context.Context, db sqlx.Queryer, filters RoleFilters) ([]Role, error) {
+	query, args, err := sqlx.BindNamed(sqlx.DOLLAR, `
+		select
+			r.*
+		from
+			role r
+	`+filters.SQL()+`
+		order by
+			r.organization_id nulls first,
+			r.name
+		limit :limit
+		offset :offset
+	`, filters)
+	if err != nil {
+		return nil, errors.Wrap(err, "named query error")
+	}
+
+	var roles []Role
+	if err := sqlx.Select(db, &roles, query, args...); err != nil {
+		return nil, handlePSQLError(Select, err, "select error")
+	}
+
+	return roles, nil
+}
+
+// UpdateRole updates the given role. The organization of a role can not be
+// updated. Predefined roles can not be updated.
+func UpdateRole(ctx context.Context, db sqlx.Execer, r *Role) error {
+	if err := r.Validate(); err != nil {
+		return errors.Wrap(err, "validate error")
+	}
+
+	if r.Permissions == nil {
+		r.Permissions = pq.StringArray{}
+	}
+
+	r.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update role
+		set
+			updated_at = $2,
+			name = $3,
+			description = $4,
+			permissions = $5
+		where
+			id = $1
+			and organization_id is not null`,
+		r.ID,
+		r.UpdatedAt,
+		r.Name,
+		r.Description,
+		r.Permissions,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     r.ID,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("role updated")
+
+	return nil
+}
+
+// DeleteRole deletes the role for the given ID. A role which is assigned to
+// organization or application users can not be deleted, neither can the
+// predefined roles.
+func DeleteRole(ctx context.Context, db sqlx.Execer, id int64) error {
+	res, err := db.Exec(`
+		delete from role
+		where
+			id = $1
+			and organization_id is not null`,
+		id,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"id":     id,
+		"ctx_id": ctx.Value(logging.ContextIDKey),
+	}).Info("role deleted")
+
+	return nil
+}
+
+// UpdateOrganizationUserRole assigns the given role to the organization
+// user. When a role is assigned, it replaces the admin flags of the
+// organization user. Set roleID to nil to remove the role.
+func UpdateOrganizationUserRole(ctx context.Context, db sqlx.Ext, organizationID, userID int64, roleID *int64) error {
+	if roleID != nil {
+		r, err := GetRole(ctx, db, *roleID)
+		if err != nil {
+			if errors.Cause(err) == ErrDoesNotExist {
+				return ErrRoleInvalidOrganization
+			}
+			return errors.Wrap(err, "get role error")
+		}
+		if r.OrganizationID != nil && *r.OrganizationID != organizationID {
+			return ErrRoleInvalidOrganization
+		}
+	}
+
+	res, err := db.Exec(`
+		update organization_user
+		set
+			role_id = $3,
+			updated_at = now()
+		where
+			organization_id = $1
+			and user_id = $2`,
+		organizationID,
+		userID,
+		roleID,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"user_id":         userID,
+		"organization_id": organizationID,
+		"role_id":         roleID,
+		"ctx_id":          ctx.Value(logging.ContextIDKey),
+	}).Info("organization user role updated")
+
+	return nil
+}
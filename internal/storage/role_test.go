@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver"
+	nsmock "github.com/gyh1621/chirpstack-application-server/internal/backend/networkserver/mock"
+)
+
+func (ts *StorageTestSuite) TestRole() {
+	assert := require.New(ts.T())
+
+	nsClient := nsmock.NewClient()
+	networkserver.SetPool(nsmock.NewPool(nsClient))
+
+	n := NetworkServer{
+		Name:   "test",
+		Server: "test:1234",
+	}
+	assert.NoError(CreateNetworkServer(context.Background(), ts.tx, &n))
+
+	org := Organization{
+		Name: "test-org",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+
+	org2 := Organization{
+		Name: "test-org-2",
+	}
+	assert.NoError(CreateOrganization(context.Background(), ts.tx, &org2))
+
+	sp := ServiceProfile{
+		Name:            "test-sp",
+		NetworkServerID: n.ID,
+		OrganizationID:  org.ID,
+	}
+	assert.NoError(CreateServiceProfile(context.Background(), ts.tx, &sp))
+	spID, err := uuid.FromBytes(sp.ServiceProfile.Id)
+	assert.NoError(err)
+
+	app := Application{
+		Name:             "test-app",
+		OrganizationID:   org.ID,
+		ServiceProfileID: spID,
+	}
+	assert.NoError(CreateApplication(context.Background(), ts.tx, &app))
+
+	user := User{
+		IsActive: true,
+		Email:    "technician@example.com",
+	}
+	assert.NoError(CreateUser(context.Background(), ts.tx, &user))
+	assert.NoError(CreateOrganizationUser(context.Background(), ts.tx, org.ID, user.ID, false, false, false))
+
+	user2 := User{
+		IsActive: true,
+		Email:    "other@example.com",
+	}
+	assert.NoError(CreateUser(context.Background(), ts.tx, &user2))
+
+	ts.T().Run("Create with invalid name", func(t *testing.T) {
+		assert := require.New(t)
+
+		r := Role{
+			OrganizationID: &org.ID,
+		}
+		assert.Equal(ErrRoleInvalidName, errors.Cause(CreateRole(context.Background(), ts.tx, &r)))
+	})
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		r := Role{
+			OrganizationID: &org.ID,
+			Name:           "field technician",
+			Description:    "Field technician",
+			Permissions:    pq.StringArray{"gateway:read", "gateway:list", "device-queue:create"},
+		}
+		assert.NoError(CreateRole(context.Background(), ts.tx, &r))
+		r.CreatedAt = r.CreatedAt.Round(0).UTC()
+		r.UpdatedAt = r.UpdatedAt.Round(0).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			rGet, err := GetRole(context.Background(), ts.tx, r.ID)
+			assert.NoError(err)
+			rGet.CreatedAt = rGet.CreatedAt.Round(0).UTC()
+			rGet.UpdatedAt = rGet.UpdatedAt.Round(0).UTC()
+			assert.Equal(r, rGet)
+		})
+
+		t.Run("List", func(t *testing.T) {
+			assert := require.New(t)
+
+			// including the predefined roles
+			count, err := GetRoleCount(context.Background(), ts.tx, RoleFilters{OrganizationID: org.ID})
+			assert.NoError(err)
+			assert.Equal(4, count)
+
+			count, err = GetRoleCount(context.Background(), ts.tx, RoleFilters{OrganizationID: org2.ID})
+			assert.NoError(err)
+			assert.Equal(3, count)
+
+			roles, err := GetRoles(context.Background(), ts.tx, RoleFilters{OrganizationID: org.ID, Limit: 10})
+			assert.NoError(err)
+			assert.Len(roles, 4)
+			assert.Equal(RoleAdmin, roles[0].Name)
+			assert.Nil(roles[0].OrganizationID)
+			assert.Equal(r.ID, roles[3].ID)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			r.Name = "technician"
+			r.Permissions = pq.StringArray{"gateway:read"}
+			assert.NoError(UpdateRole(context.Background(), ts.tx, &r))
+
+			rGet, err := GetRole(context.Background(), ts.tx, r.ID)
+			assert.NoError(err)
+			assert.Equal("technician", rGet.Name)
+			assert.Equal(pq.StringArray{"gateway:read"}, rGet.Permissions)
+		})
+
+		t.Run("Organization user role", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(UpdateOrganizationUserRole(context.Background(), ts.tx, org.ID, user.ID, &r.ID))
+			ou, err := GetOrganizationUser(context.Background(), ts.tx, org.ID, user.ID)
+			assert.NoError(err)
+			assert.Equal(&r.ID, ou.RoleID)
+
+			t.Run("Role of other organization", func(t *testing.T) {
+				assert := require.New(t)
+
+				r2 := Role{
+					OrganizationID: &org2.ID,
+					Name:           "other",
+				}
+				assert.NoError(CreateRole(context.Background(), ts.tx, &r2))
+				assert.Equal(ErrRoleInvalidOrganization, UpdateOrganizationUserRole(context.Background(), ts.tx, org.ID, user.ID, &r2.ID))
+				assert.NoError(DeleteRole(context.Background(), ts.tx, r2.ID))
+			})
+
+			t.Run("Remove", func(t *testing.T) {
+				assert := require.New(t)
+
+				assert.NoError(UpdateOrganizationUserRole(context.Background(), ts.tx, org.ID, user.ID, nil))
+				ou, err := GetOrganizationUser(context.Background(), ts.tx, org.ID, user.ID)
+				assert.NoError(err)
+				assert.Nil(ou.RoleID)
+			})
+		})
+
+		t.Run("Application user", func(t *testing.T) {
+			assert := require.New(t)
+
+			t.Run("Not an organization user", func(t *testing.T) {
+				assert := require.New(t)
+
+				au := ApplicationUser{
+					ApplicationID: app.ID,
+					UserID:        user2.ID,
+					RoleID:        r.ID,
+				}
+				assert.Equal(ErrApplicationUserNotOrganizationUser, CreateApplicationUser(context.Background(), ts.tx, &au))
+			})
+
+			au := ApplicationUser{
+				ApplicationID: app.ID,
+				UserID:        user.ID,
+				RoleID:        r.ID,
+			}
+			assert.NoError(CreateApplicationUser(context.Background(), ts.tx, &au))
+
+			auGet, err := GetApplicationUser(context.Background(), ts.tx, app.ID, user.ID)
+			assert.NoError(err)
+			assert.Equal(user.Email, auGet.Email)
+			assert.Equal(r.ID, auGet.RoleID)
+
+			count, err := GetApplicationUserCount(context.Background(), ts.tx, app.ID)
+			assert.NoError(err)
+			assert.Equal(1, count)
+
+			users, err := GetApplicationUsers(context.Background(), ts.tx, app.ID, 10, 0)
+			assert.NoError(err)
+			assert.Len(users, 1)
+			assert.Equal(user.ID, users[0].UserID)
+
+			t.Run("Update", func(t *testing.T) {
+				assert := require.New(t)
+
+				r2 := Role{
+					OrganizationID: &org.ID,
+					Name:           "viewer",
+				}
+				assert.NoError(CreateRole(context.Background(), ts.tx, &r2))
+
+				au.RoleID = r2.ID
+				assert.NoError(UpdateApplicationUser(context.Background(), ts.tx, &au))
+
+				auGet, err := GetApplicationUser(context.Background(), ts.tx, app.ID, user.ID)
+				assert.NoError(err)
+				assert.Equal(r2.ID, auGet.RoleID)
+			})
+
+			t.Run("Delete organization user", func(t *testing.T) {
+				assert := require.New(t)
+
+				assert.NoError(DeleteOrganizationUser(context.Background(), ts.tx, org.ID, user.ID))
+				_, err := GetApplicationUser(context.Background(), ts.tx, app.ID, user.ID)
+				assert.Equal(ErrDoesNotExist, errors.Cause(err))
+			})
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteRole(context.Background(), ts.tx, r.ID))
+			_, err := GetRole(context.Background(), ts.tx, r.ID)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+		})
+
+		t.Run("Delete assigned role", func(t *testing.T) {
+			assert := require.New(t)
+
+			r2 := Role{
+				OrganizationID: &org.ID,
+				Name:           "assigned",
+			}
+			assert.NoError(CreateRole(context.Background(), ts.tx, &r2))
+			assert.NoError(CreateOrganizationUser(context.Background(), ts.tx, org.ID, user2.ID, false, false, false))
+			assert.NoError(UpdateOrganizationUserRole(context.Background(), ts.tx, org.ID, user2.ID, &r2.ID))
+
+			assert.Equal(ErrUsedByOtherObjects, DeleteRole(context.Background(), ts.tx, r2.ID))
+		})
+	})
+
+	ts.T().Run("Predefined roles", func(t *testing.T) {
+		assert := require.New(t)
+
+		r, err := GetPredefinedRole(context.Background(), ts.tx, RoleOperator)
+		assert.NoError(err)
+		assert.Nil(r.OrganizationID)
+		assert.Contains(r.Permissions, "device:update")
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			r.Permissions = pq.StringArray{"gateway:delete"}
+			assert.Equal(ErrRolePredefined, errors.Cause(UpdateRole(context.Background(), ts.tx, &r)))
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.Equal(ErrDoesNotExist, DeleteRole(context.Background(), ts.tx, r.ID))
+		})
+
+		t.Run("Organization user role", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(CreateOrganizationUser(context.Background(), ts.tx, org2.ID, user2.ID, false, false, false))
+			assert.NoError(UpdateOrganizationUserRole(context.Background(), ts.tx, org2.ID, user2.ID, &r.ID))
+		})
+
+		t.Run("Application user", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(CreateOrganizationUser(context.Background(), ts.tx, org.ID, user.ID, false, false, false))
+			assert.NoError(CreateApplicationUser(context.Background(), ts.tx, &ApplicationUser{
+				ApplicationID: app.ID,
+				UserID:        user.ID,
+				RoleID:        r.ID,
+			}))
+		})
+	})
+}
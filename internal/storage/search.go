@@ -27,7 +27,8 @@ type SearchResult struct {
 }
 
 // GlobalSearch performs a search on organizations, applications, gateways
-// and devices.
+// and devices. Applications and devices are filtered on the applications
+// to which the user has access (see ApplicationUser).
 func GlobalSearch(ctx context.Context, db sqlx.Queryer, userID int64, globalAdmin bool, search string, limit, offset int) ([]SearchResult, error) {
 	var result []SearchResult
 
@@ -63,7 +64,7 @@ func GlobalSearch(ctx context.Context, db sqlx.Queryer, userID int64, globalAdmi
 		left join "user" u
 			on u.id = ou.user_id
 		where
-			($3 = true or u.id = $4)
+			($3 = true or (u.id = $4 and `+applicationScopeSQL+`))
 			and (d.name ilike $2 or encode(d.dev_eui, 'hex') ilike $2 or ($7 != hstore('') and d.tags @> $7))
 		union
 		select
@@ -130,7 +131,7 @@ func GlobalSearch(ctx context.Context, db sqlx.Queryer, userID int64, globalAdmi
 		left join "user" u
 			on u.id = ou.user_id
 		where
-			($3 = true or u.id = $4)
+			($3 = true or (u.id = $4 and `+applicationScopeSQL+`))
 			and a.name ilike $2
 		order by
 			score desc
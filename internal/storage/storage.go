@@ -4,6 +4,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/go-redis/redis/v7"
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
@@ -12,6 +13,7 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gyh1621/chirpstack-application-server/internal/config"
+	"github.com/gyh1621/chirpstack-application-server/internal/jwtkeys"
 	"github.com/gyh1621/chirpstack-application-server/internal/migrations"
 )
 
@@ -22,6 +24,16 @@ var (
 	applicationServerID uuid.UUID
 )
 
+// signToken signs the given claims using the configured asymmetric
+// signing key or else (HS256) using the JWT secret.
+func signToken(claims jwt.Claims) (string, error) {
+	if jwtkeys.Enabled() {
+		return jwtkeys.Sign(claims)
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtsecret)
+}
+
 // Setup configures the storage package.
 func Setup(c config.Config) error {
 	log.Info("storage: setting up storage package")
@@ -29,6 +41,17 @@ func Setup(c config.Config) error {
 	jwtsecret = []byte(c.ApplicationServer.ExternalAPI.JWTSecret)
 	HashIterations = c.General.PasswordHashIterations
 
+	pp := c.ApplicationServer.UserAuthentication.PasswordPolicy
+	SetPasswordPolicy(PasswordPolicy{
+		MinLength:        pp.MinLength,
+		RequireUppercase: pp.RequireUppercase,
+		RequireLowercase: pp.RequireLowercase,
+		RequireDigit:     pp.RequireDigit,
+		RequireSpecial:   pp.RequireSpecial,
+		HistorySize:      pp.HistorySize,
+		MaxAge:           pp.MaxAge,
+	})
+
 	if err := applicationServerID.UnmarshalText([]byte(c.ApplicationServer.ID)); err != nil {
 		return errors.Wrap(err, "decode application_server.id error")
 	}
@@ -27,8 +27,9 @@ const saltSize = 16
 // defaultSessionTTL defines the default session TTL
 const defaultSessionTTL = time.Hour * 24
 
-// Any printable characters, at least 6 characters.
-var passwordValidator = regexp.MustCompile(`^.{6,}$`)
+// totpEnrollmentTokenTTL defines the TTL of the two-factor authentication
+// enrollment token
+const totpEnrollmentTokenTTL = time.Minute * 15
 
 // Must contain @ (this is far from perfect)
 var emailValidator = regexp.MustCompile(`.+@.+`)
@@ -47,6 +48,8 @@ type User struct {
 	EmailOld      string    `db:"email_old"`
 	Note          string    `db:"note"`
 	ExternalID    *string   `db:"external_id"` // must be pointer for unique index
+
+	PasswordChangedAt *time.Time `db:"password_changed_at"`
 }
 
 // Validate validates the user data.
@@ -58,10 +61,11 @@ func (u User) Validate() error {
 	return nil
 }
 
-// SetPasswordHash hashes the given password and sets it.
+// SetPasswordHash validates the given password against the password policy,
+// hashes it and sets it.
 func (u *User) SetPasswordHash(pw string) error {
-	if !passwordValidator.MatchString(pw) {
-		return ErrUserPasswordLength
+	if err := passwordPolicy.Validate(pw); err != nil {
+		return err
 	}
 
 	pwHash, err := hash(pw, saltSize, HashIterations)
@@ -69,7 +73,9 @@ func (u *User) SetPasswordHash(pw string) error {
 		return err
 	}
 
+	now := time.Now()
 	u.PasswordHash = pwHash
+	u.PasswordChangedAt = &now
 
 	return nil
 }
@@ -123,10 +129,11 @@ func CreateUser(ctx context.Context, db sqlx.Queryer, user *User) error {
 			email,
 			email_verified,
 			note,
-			external_id
+			external_id,
+			password_changed_at
 		)
 		values (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		returning
 			id`,
 		user.IsAdmin,
@@ -139,6 +146,7 @@ func CreateUser(ctx context.Context, db sqlx.Queryer, user *User) error {
 		user.EmailVerified,
 		user.Note,
 		user.ExternalID,
+		user.PasswordChangedAt,
 	)
 	if err != nil {
 		return handlePSQLError(Insert, err, "insert error")
@@ -278,7 +286,8 @@ func UpdateUser(ctx context.Context, db sqlx.Execer, u *User) error {
 			email_verified = $7,
 			note = $8,
 			external_id = $9,
-			password_hash = $10
+			password_hash = $10,
+			password_changed_at = $11
 		where
 			id = $1`,
 		u.ID,
@@ -291,6 +300,7 @@ func UpdateUser(ctx context.Context, db sqlx.Execer, u *User) error {
 		u.Note,
 		u.ExternalID,
 		u.PasswordHash,
+		u.PasswordChangedAt,
 	)
 	if err != nil {
 		return handlePSQLError(Update, err, "update error")
@@ -346,6 +356,17 @@ func DeleteUser(ctx context.Context, db sqlx.Execer, id int64) error {
 // LoginUserByPassword returns a JWT token for the user matching the given email
 // and password combination.
 func LoginUserByPassword(ctx context.Context, db sqlx.Queryer, email string, password string) (string, error) {
+	user, err := GetUserByEmailAndPassword(ctx, db, email, password)
+	if err != nil {
+		return "", err
+	}
+
+	return GetUserToken(user)
+}
+
+// GetUserByEmailAndPassword returns the user matching the given email and
+// password combination.
+func GetUserByEmailAndPassword(ctx context.Context, db sqlx.Queryer, email string, password string) (User, error) {
 	// get the user by email
 	var user User
 	err := sqlx.Get(db, &user, `
@@ -358,17 +379,17 @@ func LoginUserByPassword(ctx context.Context, db sqlx.Queryer, email string, pas
 	`, email)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return "", ErrInvalidUsernameOrPassword
+			return User{}, ErrInvalidUsernameOrPassword
 		}
-		return "", errors.Wrap(err, "select error")
+		return User{}, errors.Wrap(err, "select error")
 	}
 
 	// Compare the passed in password with the hash in the database.
 	if !hashCompare(password, user.PasswordHash) {
-		return "", ErrInvalidUsernameOrPassword
+		return User{}, ErrInvalidUsernameOrPassword
 	}
 
-	return GetUserToken(user)
+	return user, nil
 }
 
 // GetProfile returns the user profile (user, applications and organizations
@@ -478,7 +499,7 @@ func GetUserToken(u User) (string, error) {
 	} else {
 		expSecondsSinceEpoch = nowSecondsSinceEpoch + int64(defaultSessionTTL/time.Second)
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	jwt, err := signToken(jwt.MapClaims{
 		"iss":      "chirpstack-application-server",
 		"aud":      "chirpstack-application-server",
 		"nbf":      nowSecondsSinceEpoch,
@@ -487,8 +508,27 @@ func GetUserToken(u User) (string, error) {
 		"id":       u.ID,
 		"username": u.Email, // backwards compatibility
 	})
+	if err != nil {
+		return jwt, errors.Wrap(err, "get jwt signed string error")
+	}
+	return jwt, err
+}
 
-	jwt, err := token.SignedString(jwtsecret)
+// GetUserTOTPEnrollmentToken returns a short-lived JWT token for the given
+// user, which only grants access to the two-factor authentication
+// enrollment. This token is returned on login when two-factor
+// authentication is required but has not yet been enabled by the user.
+func GetUserTOTPEnrollmentToken(u User) (string, error) {
+	now := time.Now()
+	jwt, err := signToken(jwt.MapClaims{
+		"iss":      "chirpstack-application-server",
+		"aud":      "chirpstack-application-server",
+		"nbf":      now.Unix(),
+		"exp":      now.Add(totpEnrollmentTokenTTL).Unix(),
+		"sub":      "totp_enrollment",
+		"id":       u.ID,
+		"username": u.Email,
+	})
 	if err != nil {
 		return jwt, errors.Wrap(err, "get jwt signed string error")
 	}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+)
+
+const (
+	userTokenKeyTempl = "lora:as:user:token:%s:%x"
+)
+
+// User token purposes.
+const (
+	UserTokenPasswordReset     = "password_reset"
+	UserTokenEmailVerification = "email_verification"
+)
+
+// UserToken defines a single-use token which is sent to the user by e-mail,
+// e.g. for resetting the password.
+type UserToken struct {
+	UserID int64 `json:"userID"`
+
+	// Email contains the e-mail address to which the token was sent. The
+	// token must be rejected when the e-mail address of the user has been
+	// changed since.
+	Email string `json:"email"`
+
+	// PasswordChangedAt contains the time the password of the user was
+	// last changed when the token was created. Password reset tokens must
+	// be rejected when the password has been changed since.
+	PasswordChangedAt *time.Time `json:"passwordChangedAt,omitempty"`
+}
+
+// CreateUserToken creates a new token for the given purpose and returns the
+// (plaintext) token. Only the hash of the token is stored.
+func CreateUserToken(ctx context.Context, purpose string, t UserToken, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random bytes error")
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	val, err := json.Marshal(t)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal json error")
+	}
+
+	if err := RedisClient().Set(userTokenKey(purpose, token), val, ttl).Err(); err != nil {
+		return "", errors.Wrap(err, "set user token error")
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": t.UserID,
+		"purpose": purpose,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user token created")
+
+	return token, nil
+}
+
+// UseUserToken returns and deletes the given token. It returns
+// ErrInvalidUserToken when the token does not exist or has expired.
+func UseUserToken(ctx context.Context, purpose, token string) (UserToken, error) {
+	var t UserToken
+	key := userTokenKey(purpose, token)
+
+	// get and delete the token in a single transaction, so that it can only
+	// be used once
+	var get *redis.StringCmd
+	_, err := RedisClient().TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key)
+		pipe.Del(key)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return t, errors.Wrap(err, "get user token error")
+	}
+
+	b, err := get.Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return t, ErrInvalidUserToken
+		}
+		return t, errors.Wrap(err, "get user token error")
+	}
+
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, errors.Wrap(err, "unmarshal json error")
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": t.UserID,
+		"purpose": purpose,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user token used")
+
+	return t, nil
+}
+
+func userTokenKey(purpose, token string) string {
+	return fmt.Sprintf(userTokenKeyTempl, purpose, sha256.Sum256([]byte(token)))
+}
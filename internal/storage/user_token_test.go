@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func (ts *StorageTestSuite) TestUserToken() {
+	assert := require.New(ts.T())
+
+	ut := UserToken{
+		UserID: 1,
+		Email:  "user@example.com",
+	}
+
+	token, err := CreateUserToken(context.Background(), UserTokenPasswordReset, ut, time.Minute)
+	assert.NoError(err)
+	assert.NotEqual("", token)
+
+	// the token is bound to its purpose
+	_, err = UseUserToken(context.Background(), UserTokenEmailVerification, token)
+	assert.Equal(ErrInvalidUserToken, err)
+
+	utGet, err := UseUserToken(context.Background(), UserTokenPasswordReset, token)
+	assert.NoError(err)
+	assert.Equal(ut, utGet)
+
+	// the token can only be used once
+	_, err = UseUserToken(context.Background(), UserTokenPasswordReset, token)
+	assert.Equal(ErrInvalidUserToken, err)
+
+	_, err = UseUserToken(context.Background(), UserTokenPasswordReset, "invalid")
+	assert.Equal(ErrInvalidUserToken, err)
+}
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/logging"
+	"github.com/gyh1621/chirpstack-application-server/internal/totp"
+)
+
+// totpRecoveryCodeCount defines the number of recovery codes which are
+// generated on activation of two-factor authentication.
+const totpRecoveryCodeCount = 10
+
+// UserTOTP defines the two-factor authentication (TOTP) state of a user.
+// Until the enrollment has been confirmed with a valid code, Enabled is
+// false.
+type UserTOTP struct {
+	UserID    int64     `db:"user_id"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Secret    string    `db:"secret"`
+	Enabled   bool      `db:"enabled"`
+
+	// LastCounter contains the time-step counter of the last accepted code,
+	// to prevent the replay of codes.
+	LastCounter int64 `db:"last_counter"`
+}
+
+// CreateUserTOTP creates the given user TOTP state.
+func CreateUserTOTP(ctx context.Context, db sqlx.Execer, t *UserTOTP) error {
+	now := time.Now()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+
+	_, err := db.Exec(`
+		insert into user_totp (
+			user_id,
+			created_at,
+			updated_at,
+			secret,
+			enabled,
+			last_counter
+		) values ($1, $2, $3, $4, $5, $6)`,
+		t.UserID,
+		t.CreatedAt,
+		t.UpdatedAt,
+		t.Secret,
+		t.Enabled,
+		t.LastCounter,
+	)
+	if err != nil {
+		return handlePSQLError(Insert, err, "insert error")
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": t.UserID,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user totp created")
+
+	return nil
+}
+
+// GetUserTOTP returns the TOTP state of the given user.
+func GetUserTOTP(ctx context.Context, db sqlx.Queryer, userID int64, forUpdate bool) (UserTOTP, error) {
+	var fu string
+	if forUpdate {
+		fu = " for update"
+	}
+
+	var t UserTOTP
+	err := sqlx.Get(db, &t, `
+		select
+			*
+		from
+			user_totp
+		where
+			user_id = $1`+fu,
+		userID,
+	)
+	if err != nil {
+		return t, handlePSQLError(Select, err, "select error")
+	}
+
+	return t, nil
+}
+
+// UpdateUserTOTP updates the given user TOTP state.
+func UpdateUserTOTP(ctx context.Context, db sqlx.Execer, t *UserTOTP) error {
+	t.UpdatedAt = time.Now()
+
+	res, err := db.Exec(`
+		update user_totp
+		set
+			updated_at = $2,
+			secret = $3,
+			enabled = $4,
+			last_counter = $5
+		where
+			user_id = $1`,
+		t.UserID,
+		t.UpdatedAt,
+		t.Secret,
+		t.Enabled,
+		t.LastCounter,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": t.UserID,
+		"enabled": t.Enabled,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user totp updated")
+
+	return nil
+}
+
+// DeleteUserTOTP deletes the TOTP state and the recovery codes of the given
+// user.
+func DeleteUserTOTP(ctx context.Context, db sqlx.Execer, userID int64) error {
+	_, err := db.Exec(`
+		delete from
+			user_totp_recovery_code
+		where
+			user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete recovery codes error")
+	}
+
+	res, err := db.Exec(`
+		delete from
+			user_totp
+		where
+			user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrDoesNotExist
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user totp deleted")
+
+	return nil
+}
+
+// ValidateUserTOTPCode validates the given code against the TOTP secret of
+// the given user. A code can only be used once.
+func ValidateUserTOTPCode(ctx context.Context, db sqlx.Ext, userID int64, code string) error {
+	t, err := GetUserTOTP(ctx, db, userID, false)
+	if err != nil {
+		return errors.Wrap(err, "get user totp error")
+	}
+
+	counter, ok, err := totp.Validate(t.Secret, code, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "validate totp code error")
+	}
+	if !ok {
+		return ErrInvalidTOTPCode
+	}
+
+	// the counter must be greater than the counter of the last accepted
+	// code, else the code has already been used
+	res, err := db.Exec(`
+		update user_totp
+		set
+			last_counter = $2
+		where
+			user_id = $1
+			and last_counter < $2`,
+		userID,
+		counter,
+	)
+	if err != nil {
+		return handlePSQLError(Update, err, "update error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrInvalidTOTPCode
+	}
+
+	return nil
+}
+
+// CreateUserTOTPRecoveryCodes generates new recovery codes for the given
+// user, replacing the existing codes. Only the hashes are stored, the
+// plaintext codes are returned once.
+func CreateUserTOTPRecoveryCodes(ctx context.Context, db sqlx.Execer, userID int64) ([]string, error) {
+	_, err := db.Exec(`
+		delete from
+			user_totp_recovery_code
+		where
+			user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, handlePSQLError(Delete, err, "delete error")
+	}
+
+	var codes []string
+	for i := 0; i < totpRecoveryCodeCount; i++ {
+		code, err := newTOTPRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = db.Exec(`
+			insert into user_totp_recovery_code (
+				user_id,
+				code_hash
+			) values ($1, $2)`,
+			userID,
+			totpRecoveryCodeHash(code),
+		)
+		if err != nil {
+			return nil, handlePSQLError(Insert, err, "insert error")
+		}
+
+		codes = append(codes, code)
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user totp recovery codes created")
+
+	return codes, nil
+}
+
+// GetUserTOTPRecoveryCodeCount returns the number of unused recovery codes
+// of the given user.
+func GetUserTOTPRecoveryCodeCount(ctx context.Context, db sqlx.Queryer, userID int64) (int, error) {
+	var count int
+	err := sqlx.Get(db, &count, `
+		select
+			count(*)
+		from
+			user_totp_recovery_code
+		where
+			user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return 0, handlePSQLError(Select, err, "select error")
+	}
+
+	return count, nil
+}
+
+// UseUserTOTPRecoveryCode validates and consumes the given recovery code.
+// It returns ErrInvalidTOTPCode when the code does not exist.
+func UseUserTOTPRecoveryCode(ctx context.Context, db sqlx.Execer, userID int64, code string) error {
+	res, err := db.Exec(`
+		delete from
+			user_totp_recovery_code
+		where
+			user_id = $1
+			and code_hash = $2`,
+		userID,
+		totpRecoveryCodeHash(code),
+	)
+	if err != nil {
+		return handlePSQLError(Delete, err, "delete error")
+	}
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "get rows affected error")
+	}
+	if ra == 0 {
+		return ErrInvalidTOTPCode
+	}
+
+	log.WithFields(log.Fields{
+		"user_id": userID,
+		"ctx_id":  ctx.Value(logging.ContextIDKey),
+	}).Info("storage: user totp recovery code used")
+
+	return nil
+}
+
+// IsUserTOTPRequired returns true when two-factor authentication is
+// required for the given user. This is the case when it is required for
+// admin users and the user is a global or organization admin.
+func IsUserTOTPRequired(ctx context.Context, db sqlx.Queryer, u User) (bool, error) {
+	var required bool
+	if err := GetGlobalSetting(ctx, db, GlobalSettingRequireAdminTOTP, &required); err != nil {
+		if errors.Cause(err) == ErrDoesNotExist {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "get global setting error")
+	}
+
+	if !required {
+		return false, nil
+	}
+
+	if u.IsAdmin {
+		return true, nil
+	}
+
+	var isOrgAdmin bool
+	err := sqlx.Get(db, &isOrgAdmin, `
+		select
+			exists (
+				select
+					1
+				from
+					organization_user
+				where
+					user_id = $1
+					and is_admin = true
+			)`,
+		u.ID,
+	)
+	if err != nil {
+		return false, handlePSQLError(Select, err, "select error")
+	}
+
+	return isOrgAdmin, nil
+}
+
+// newTOTPRecoveryCode returns a new random recovery code, formatted as
+// xxxx-xxxx-xxxx-xxxx.
+func newTOTPRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random bytes error")
+	}
+
+	s := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// totpRecoveryCodeHash returns the hash of the given recovery code. As the
+// codes are random (80 bits), a plain SHA-256 hash is sufficient.
+func totpRecoveryCodeHash(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+
+	h := sha256.Sum256([]byte(code))
+	return h[:]
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gyh1621/chirpstack-application-server/internal/totp"
+)
+
+func (ts *StorageTestSuite) TestUserTOTP() {
+	assert := require.New(ts.T())
+
+	user := User{
+		Email:    "totp@example.com",
+		IsActive: true,
+	}
+	assert.NoError(CreateUser(context.Background(), ts.tx, &user))
+
+	secret, err := totp.GenerateSecret()
+	assert.NoError(err)
+
+	ts.T().Run("Create", func(t *testing.T) {
+		assert := require.New(t)
+
+		ut := UserTOTP{
+			UserID: user.ID,
+			Secret: secret,
+		}
+		assert.NoError(CreateUserTOTP(context.Background(), ts.tx, &ut))
+		ut.CreatedAt = ut.CreatedAt.Round(time.Second).UTC()
+		ut.UpdatedAt = ut.UpdatedAt.Round(time.Second).UTC()
+
+		t.Run("Get", func(t *testing.T) {
+			assert := require.New(t)
+
+			utGet, err := GetUserTOTP(context.Background(), ts.tx, user.ID, false)
+			assert.NoError(err)
+			utGet.CreatedAt = utGet.CreatedAt.Round(time.Second).UTC()
+			utGet.UpdatedAt = utGet.UpdatedAt.Round(time.Second).UTC()
+			assert.Equal(ut, utGet)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			assert := require.New(t)
+
+			ut.Enabled = true
+			assert.NoError(UpdateUserTOTP(context.Background(), ts.tx, &ut))
+
+			utGet, err := GetUserTOTP(context.Background(), ts.tx, user.ID, false)
+			assert.NoError(err)
+			assert.True(utGet.Enabled)
+		})
+
+		t.Run("Validate code", func(t *testing.T) {
+			assert := require.New(t)
+
+			code, err := totp.Code(secret, totp.Counter(time.Now()))
+			assert.NoError(err)
+
+			assert.NoError(ValidateUserTOTPCode(context.Background(), ts.tx, user.ID, code))
+
+			// replay
+			assert.Equal(ErrInvalidTOTPCode, errors.Cause(ValidateUserTOTPCode(context.Background(), ts.tx, user.ID, code)))
+
+			// invalid code
+			assert.Equal(ErrInvalidTOTPCode, errors.Cause(ValidateUserTOTPCode(context.Background(), ts.tx, user.ID, "abcdef")))
+		})
+
+		t.Run("Recovery codes", func(t *testing.T) {
+			assert := require.New(t)
+
+			codes, err := CreateUserTOTPRecoveryCodes(context.Background(), ts.tx, user.ID)
+			assert.NoError(err)
+			assert.Len(codes, totpRecoveryCodeCount)
+
+			count, err := GetUserTOTPRecoveryCodeCount(context.Background(), ts.tx, user.ID)
+			assert.NoError(err)
+			assert.Equal(totpRecoveryCodeCount, count)
+
+			// codes are case-insensitive and the separators are optional
+			assert.NoError(UseUserTOTPRecoveryCode(context.Background(), ts.tx, user.ID, "  "+codes[0]+" "))
+			assert.Equal(ErrInvalidTOTPCode, errors.Cause(UseUserTOTPRecoveryCode(context.Background(), ts.tx, user.ID, codes[0])))
+
+			count, err = GetUserTOTPRecoveryCodeCount(context.Background(), ts.tx, user.ID)
+			assert.NoError(err)
+			assert.Equal(totpRecoveryCodeCount-1, count)
+		})
+
+		t.Run("Required", func(t *testing.T) {
+			assert := require.New(t)
+
+			required, err := IsUserTOTPRequired(context.Background(), ts.tx, user)
+			assert.NoError(err)
+			assert.False(required)
+
+			assert.NoError(SetGlobalSetting(context.Background(), ts.tx, GlobalSettingRequireAdminTOTP, true))
+
+			required, err = IsUserTOTPRequired(context.Background(), ts.tx, user)
+			assert.NoError(err)
+			assert.False(required)
+
+			org := Organization{Name: "test-org"}
+			assert.NoError(CreateOrganization(context.Background(), ts.tx, &org))
+			assert.NoError(CreateOrganizationUser(context.Background(), ts.tx, org.ID, user.ID, true, false, false))
+
+			required, err = IsUserTOTPRequired(context.Background(), ts.tx, user)
+			assert.NoError(err)
+			assert.True(required)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			assert := require.New(t)
+
+			assert.NoError(DeleteUserTOTP(context.Background(), ts.tx, user.ID))
+			assert.Equal(ErrDoesNotExist, errors.Cause(DeleteUserTOTP(context.Background(), ts.tx, user.ID)))
+
+			_, err := GetUserTOTP(context.Background(), ts.tx, user.ID, false)
+			assert.Equal(ErrDoesNotExist, errors.Cause(err))
+
+			count, err := GetUserTOTPRecoveryCodeCount(context.Background(), ts.tx, user.ID)
+			assert.NoError(err)
+			assert.Equal(0, count)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+// Package totp implements the time-based one-time password algorithm
+// (RFC 6238) as used by authenticator apps.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Digits defines the number of digits of a code.
+	Digits = 6
+
+	// Period defines the time-step (seconds) of a code.
+	Period = 30
+
+	// Skew defines the number of time-steps before and after the current
+	// time-step which are accepted, to compensate for clock drift.
+	Skew = 1
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random (base32 encoded) secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random bytes error")
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth URI for the given issuer, account name and secret.
+// This URI is usually presented as QR code to the user.
+func URI(issuer, accountName, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", Digits))
+	v.Set("period", fmt.Sprintf("%d", Period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + accountName,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// Counter returns the time-step counter for the given time.
+func Counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for the given secret and counter.
+func Code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", errors.Wrap(err, "decode secret error")
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+
+	// dynamic truncation (RFC 4226, section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Validate validates the given code against the given secret and time.
+// On success, it returns the counter of the matching time-step. To prevent
+// replay, the caller must reject codes of which the counter is not greater
+// than the counter of the last accepted code.
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	counter := Counter(t)
+	for i := -Skew; i <= Skew; i++ {
+		c, err := Code(secret, counter+int64(i))
+		if err != nil {
+			return 0, false, err
+		}
+
+		if hmac.Equal([]byte(c), []byte(code)) {
+			return counter + int64(i), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
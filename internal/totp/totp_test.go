@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	// test vectors from RFC 6238 (SHA1), truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.code, func(t *testing.T) {
+			assert := require.New(t)
+
+			code, err := Code(secret, Counter(time.Unix(tst.time, 0)))
+			assert.NoError(err)
+			assert.Equal(tst.code, code)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert := require.New(t)
+
+	secret, err := GenerateSecret()
+	assert.NoError(err)
+
+	now := time.Now()
+	code, err := Code(secret, Counter(now))
+	assert.NoError(err)
+
+	t.Run("Current time-step", func(t *testing.T) {
+		assert := require.New(t)
+
+		counter, ok, err := Validate(secret, code, now)
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal(Counter(now), counter)
+	})
+
+	t.Run("Within skew", func(t *testing.T) {
+		assert := require.New(t)
+
+		counter, ok, err := Validate(secret, code, now.Add(Period*time.Second))
+		assert.NoError(err)
+		assert.True(ok)
+		assert.Equal(Counter(now), counter)
+	})
+
+	t.Run("Outside skew", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, ok, err := Validate(secret, code, now.Add(3*Period*time.Second))
+		assert.NoError(err)
+		assert.False(ok)
+	})
+
+	t.Run("Invalid length", func(t *testing.T) {
+		assert := require.New(t)
+
+		_, ok, err := Validate(secret, code[1:], now)
+		assert.NoError(err)
+		assert.False(ok)
+	})
+}
+
+func TestURI(t *testing.T) {
+	assert := require.New(t)
+
+	u, err := url.Parse(URI("ChirpStack", "admin@example.com", "JBSWY3DPEHPK3PXP"))
+	assert.NoError(err)
+	assert.Equal("otpauth", u.Scheme)
+	assert.Equal("totp", u.Host)
+	assert.Equal("/ChirpStack:admin@example.com", u.Path)
+	assert.Equal("JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal("ChirpStack", u.Query().Get("issuer"))
+	assert.Equal("6", u.Query().Get("digits"))
+}
@@ -0,0 +1,9 @@
+-- +migrate Up
+alter table device_profile
+    add column payload_protobuf_descriptor_set bytea,
+    add column payload_protobuf_messages hstore;
+
+-- +migrate Down
+alter table device_profile
+    drop column payload_protobuf_descriptor_set,
+    drop column payload_protobuf_messages;
@@ -0,0 +1,86 @@
+-- +migrate Up
+create table codec_revision (
+    id bigserial primary key,
+    created_at timestamp with time zone not null,
+    device_profile_id uuid references device_profile on delete cascade,
+    application_id bigint references application on delete cascade,
+    revision integer not null,
+    user_id bigint references "user" on delete set null,
+    api_key_id uuid,
+    payload_codec text not null,
+    payload_encoder_script text not null,
+    payload_decoder_script text not null,
+    payload_protobuf_descriptor_set bytea,
+    payload_protobuf_messages hstore,
+
+    check ((device_profile_id is null) != (application_id is null))
+);
+
+create unique index idx_codec_revision_device_profile_id_revision on codec_revision(device_profile_id, revision);
+create unique index idx_codec_revision_application_id_revision on codec_revision(application_id, revision);
+
+alter table device_profile
+    add column payload_codec_revision integer not null default 0;
+
+alter table application
+    add column payload_codec_revision integer not null default 0;
+
+-- store the current codec settings as initial revision
+insert into codec_revision (
+    created_at,
+    device_profile_id,
+    revision,
+    payload_codec,
+    payload_encoder_script,
+    payload_decoder_script,
+    payload_protobuf_descriptor_set,
+    payload_protobuf_messages
+)
+select
+    updated_at,
+    device_profile_id,
+    1,
+    payload_codec,
+    payload_encoder_script,
+    payload_decoder_script,
+    payload_protobuf_descriptor_set,
+    payload_protobuf_messages
+from
+    device_profile
+where
+    payload_codec != '';
+
+update device_profile set payload_codec_revision = 1 where payload_codec != '';
+
+insert into codec_revision (
+    created_at,
+    application_id,
+    revision,
+    payload_codec,
+    payload_encoder_script,
+    payload_decoder_script
+)
+select
+    now(),
+    id,
+    1,
+    payload_codec,
+    payload_encoder_script,
+    payload_decoder_script
+from
+    application
+where
+    payload_codec != '';
+
+update application set payload_codec_revision = 1 where payload_codec != '';
+
+-- +migrate Down
+alter table application
+    drop column payload_codec_revision;
+
+alter table device_profile
+    drop column payload_codec_revision;
+
+drop index idx_codec_revision_application_id_revision;
+drop index idx_codec_revision_device_profile_id_revision;
+drop table codec_revision;
@@ -0,0 +1,7 @@
+-- +migrate Up
+alter table fuota_deployment
+    add column previous_state varchar(20) not null default '';
+
+-- +migrate Down
+alter table fuota_deployment
+    drop column previous_state;
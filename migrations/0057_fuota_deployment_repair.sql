@@ -0,0 +1,19 @@
+-- +migrate Up
+alter table fuota_deployment
+    add column fragments_sent integer not null default 0,
+    add column repair_count smallint not null default 0,
+    add column repair_fragments integer not null default 0;
+
+alter table fuota_deployment_device
+    add column nb_frag_received integer not null default 0,
+    add column missing_frag integer not null default 0;
+
+-- +migrate Down
+alter table fuota_deployment_device
+    drop column missing_frag,
+    drop column nb_frag_received;
+
+alter table fuota_deployment
+    drop column repair_fragments,
+    drop column repair_count,
+    drop column fragments_sent;
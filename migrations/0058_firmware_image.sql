@@ -0,0 +1,49 @@
+-- +migrate Up
+create table firmware_image (
+    id uuid primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    organization_id bigint not null references organization on delete cascade,
+    name varchar(100) not null,
+    version varchar(50) not null,
+    description text not null,
+    payload bytea not null,
+    sha256 bytea not null,
+    descriptor bytea not null,
+    signature bytea,
+    signature_verified boolean not null default false,
+
+    unique (organization_id, name, version)
+);
+
+create index idx_firmware_image_organization_id on firmware_image(organization_id);
+create index idx_firmware_image_created_at on firmware_image(created_at);
+create index idx_firmware_image_updated_at on firmware_image(updated_at);
+
+create table firmware_image_device_profile (
+    firmware_image_id uuid not null references firmware_image on delete cascade,
+    device_profile_id uuid not null references device_profile on delete cascade,
+
+    primary key (firmware_image_id, device_profile_id)
+);
+
+create index idx_firmware_image_device_profile_device_profile_id on firmware_image_device_profile(device_profile_id);
+
+alter table fuota_deployment
+    add column firmware_image_id uuid references firmware_image on delete restrict;
+
+create index idx_fuota_deployment_firmware_image_id on fuota_deployment(firmware_image_id);
+
+-- +migrate Down
+drop index idx_fuota_deployment_firmware_image_id;
+
+alter table fuota_deployment
+    drop column firmware_image_id;
+
+drop index idx_firmware_image_device_profile_device_profile_id;
+drop table firmware_image_device_profile;
+
+drop index idx_firmware_image_updated_at;
+drop index idx_firmware_image_created_at;
+drop index idx_firmware_image_organization_id;
+drop table firmware_image;
@@ -0,0 +1,7 @@
+-- +migrate Up
+alter table fuota_deployment_device
+    add column fragments_sent integer not null default 0;
+
+-- +migrate Down
+alter table fuota_deployment_device
+    drop column fragments_sent;
@@ -0,0 +1,25 @@
+-- +migrate Up
+alter table fuota_deployment
+    add column start_after timestamp with time zone,
+    add column start_before timestamp with time zone,
+    add column waves integer[] not null default '{}',
+    add column current_wave integer not null default 0,
+    add column wave_success_threshold double precision not null default 0;
+
+alter table fuota_deployment_device
+    add column wave integer not null default 0;
+
+create index idx_fuota_deployment_device_fuota_deployment_id_wave on fuota_deployment_device(fuota_deployment_id, wave);
+
+-- +migrate Down
+drop index idx_fuota_deployment_device_fuota_deployment_id_wave;
+
+alter table fuota_deployment_device
+    drop column wave;
+
+alter table fuota_deployment
+    drop column wave_success_threshold,
+    drop column current_wave,
+    drop column waves,
+    drop column start_before,
+    drop column start_after;
@@ -0,0 +1,17 @@
+-- +migrate Up
+alter table device_profile
+    add column app_layer_multicast_setup_enabled boolean not null default true,
+    add column app_layer_multicast_setup_f_port smallint not null default 200,
+    add column app_layer_fragmentation_enabled boolean not null default true,
+    add column app_layer_fragmentation_f_port smallint not null default 201,
+    add column app_layer_clock_sync_enabled boolean not null default true,
+    add column app_layer_clock_sync_f_port smallint not null default 202;
+
+-- +migrate Down
+alter table device_profile
+    drop column app_layer_clock_sync_f_port,
+    drop column app_layer_clock_sync_enabled,
+    drop column app_layer_fragmentation_f_port,
+    drop column app_layer_fragmentation_enabled,
+    drop column app_layer_multicast_setup_f_port,
+    drop column app_layer_multicast_setup_enabled;
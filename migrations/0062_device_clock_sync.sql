@@ -0,0 +1,17 @@
+-- +migrate Up
+create table device_clock_sync (
+    dev_eui bytea primary key references device on delete cascade,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    clock_drift integer not null default 0,
+    clock_drift_at timestamp with time zone,
+    periodicity smallint,
+    periodicity_requested_at timestamp with time zone,
+    periodicity_answered_at timestamp with time zone,
+    periodicity_not_supported boolean not null default false,
+    resync_nb_transmissions smallint not null default 0,
+    resync_requested_at timestamp with time zone
+);
+
+-- +migrate Down
+drop table device_clock_sync;
@@ -0,0 +1,26 @@
+-- +migrate Up
+create table device_application_layer (
+    dev_eui bytea primary key references device on delete cascade,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    clock_sync_version smallint,
+    multicast_setup_version smallint,
+    fragmentation_version smallint,
+    fragmentation_session_cnt integer not null default 0,
+    package_version_requested_at timestamp with time zone
+);
+
+alter table remote_fragmentation_session
+    add column package_version smallint not null default 1,
+    add column session_cnt integer not null default 0,
+    add column mic bytea not null default '\x00000000',
+    add column data_block_auth_ok boolean;
+
+-- +migrate Down
+alter table remote_fragmentation_session
+    drop column data_block_auth_ok,
+    drop column mic,
+    drop column session_cnt,
+    drop column package_version;
+
+drop table device_application_layer;
@@ -0,0 +1,27 @@
+-- +migrate Up
+create table audit_log (
+    id bigserial primary key,
+    created_at timestamp with time zone not null,
+    request_id uuid,
+    organization_id bigint,
+    subject varchar(20) not null,
+    user_id bigint,
+    username varchar(100) not null default '',
+    api_key_id uuid,
+    service varchar(100) not null,
+    method varchar(100) not null,
+    action varchar(10) not null,
+    resource_type varchar(100) not null,
+    resource_id varchar(100) not null default '',
+    changes jsonb not null default '{}'
+);
+
+create index idx_audit_log_created_at on audit_log(created_at);
+create index idx_audit_log_organization_id_created_at on audit_log(organization_id, created_at);
+create index idx_audit_log_resource_type_resource_id on audit_log(resource_type, resource_id);
+
+-- +migrate Down
+drop index idx_audit_log_resource_type_resource_id;
+drop index idx_audit_log_organization_id_created_at;
+drop index idx_audit_log_created_at;
+drop table audit_log;
@@ -0,0 +1,17 @@
+-- +migrate Up
+alter table api_key
+    add column expires_at timestamp with time zone,
+    add column scopes varchar(50)[] not null default '{}',
+    add column last_used_at timestamp with time zone,
+    add column token_id uuid not null default '00000000-0000-0000-0000-000000000000',
+    add column previous_token_id uuid,
+    add column previous_token_expires_at timestamp with time zone;
+
+-- +migrate Down
+alter table api_key
+    drop column previous_token_expires_at,
+    drop column previous_token_id,
+    drop column token_id,
+    drop column last_used_at,
+    drop column scopes,
+    drop column expires_at;
@@ -0,0 +1,26 @@
+-- +migrate Up
+create table user_totp (
+    user_id bigint primary key references "user" on delete cascade,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    secret varchar(64) not null,
+    enabled boolean not null default false,
+    last_counter bigint not null default 0
+);
+
+create table user_totp_recovery_code (
+    user_id bigint not null references "user" on delete cascade,
+    code_hash bytea not null,
+    primary key (user_id, code_hash)
+);
+
+create table global_setting (
+    key varchar(100) primary key,
+    updated_at timestamp with time zone not null,
+    value jsonb not null
+);
+
+-- +migrate Down
+drop table global_setting;
+drop table user_totp_recovery_code;
+drop table user_totp;
@@ -0,0 +1,25 @@
+-- +migrate Up
+alter table "user"
+    add column password_changed_at timestamp with time zone;
+
+create table user_password_history (
+    id bigserial primary key,
+    user_id bigint not null references "user" on delete cascade,
+    created_at timestamp with time zone not null,
+    password_hash varchar(200) not null
+);
+
+create index idx_user_password_history_user_id on user_password_history(user_id);
+
+alter table audit_log
+    alter column action type varchar(20);
+
+-- +migrate Down
+alter table audit_log
+    alter column action type varchar(10);
+
+drop index idx_user_password_history_user_id;
+drop table user_password_history;
+
+alter table "user"
+    drop column password_changed_at;
@@ -0,0 +1,44 @@
+-- +migrate Up
+create table role (
+    id bigserial primary key,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+    organization_id bigint not null references organization on delete cascade,
+    name varchar(100) not null,
+    description text not null,
+    permissions text[] not null
+);
+
+create index idx_role_organization_id on role(organization_id);
+create unique index idx_role_organization_id_name on role(organization_id, name);
+
+alter table organization_user
+    add column role_id bigint references role;
+
+create index idx_organization_user_role_id on organization_user(role_id);
+
+create table application_user (
+    application_id bigint not null references application on delete cascade,
+    user_id bigint not null references "user" on delete cascade,
+    role_id bigint not null references role,
+    created_at timestamp with time zone not null,
+    updated_at timestamp with time zone not null,
+
+    primary key (application_id, user_id)
+);
+
+create index idx_application_user_user_id on application_user(user_id);
+create index idx_application_user_role_id on application_user(role_id);
+
+-- +migrate Down
+drop index idx_application_user_role_id;
+drop index idx_application_user_user_id;
+drop table application_user;
+
+drop index idx_organization_user_role_id;
+alter table organization_user
+    drop column role_id;
+
+drop index idx_role_organization_id_name;
+drop index idx_role_organization_id;
+drop table role;
@@ -0,0 +1,60 @@
+-- +migrate Up
+alter table role
+    alter column organization_id drop not null;
+
+create unique index idx_role_name_predefined on role(name) where organization_id is null;
+
+insert into role (
+    created_at,
+    updated_at,
+    organization_id,
+    name,
+    description,
+    permissions
+) values
+(
+    now(),
+    now(),
+    null,
+    'viewer',
+    'See the application and its devices.',
+    array['application:read', 'device:read', 'device:list', 'device-queue:list']
+),
+(
+    now(),
+    now(),
+    null,
+    'operator',
+    'Manage the devices and the device-queue of the application and see the device-keys.',
+    array['application:read', 'device:create', 'device:read', 'device:update', 'device:delete', 'device:list', 'device-queue:create', 'device-queue:list', 'device-queue:delete', 'device-keys:read']
+),
+(
+    now(),
+    now(),
+    null,
+    'admin',
+    'Update the application, manage its devices, the device-queue and the device-keys.',
+    array['application:read', 'application:update', 'device:create', 'device:read', 'device:update', 'device:delete', 'device:list', 'device-queue:create', 'device-queue:list', 'device-queue:delete', 'device-keys:create', 'device-keys:read', 'device-keys:update', 'device-keys:delete']
+);
+
+-- +migrate Down
+delete from application_user
+using role r
+where
+    r.id = application_user.role_id
+    and r.organization_id is null;
+
+update organization_user
+set
+    role_id = null
+from role r
+where
+    r.id = organization_user.role_id
+    and r.organization_id is null;
+
+delete from role where organization_id is null;
+
+drop index idx_role_name_predefined;
+
+alter table role
+    alter column organization_id set not null;